@@ -0,0 +1,80 @@
+package mcpserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig obtains and renews certificates automatically from Let's
+// Encrypt, or another ACME CA given by DirectoryURL. Certificates and the
+// account key are kept in CacheDir. The tls-alpn-01 challenge is answered
+// on the server's own port, which must then be 443; http-01 needs an
+// extra listener on HTTPAddr (default ":80"), which also redirects plain
+// HTTP to HTTPS.
+type ACMEConfig struct {
+	Domains      []string `json:"domains" schema:"required"`
+	Email        string   `json:"email,omitempty"`
+	CacheDir     string   `json:"cacheDir,omitempty"` // default "acme-cache"
+	Challenge    string   `json:"challenge,omitempty" schema:"enum=tls-alpn-01|http-01"`
+	HTTPAddr     string   `json:"httpAddr,omitempty"`
+	DirectoryURL string   `json:"directoryURL,omitempty"`
+}
+
+func (c TLSConfig) check() error {
+	if c.ACME == nil {
+		return nil
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		return errors.New("certFile/keyFile and acme are mutually exclusive")
+	}
+	if len(c.ACME.Domains) == 0 {
+		return errors.New("acme requires at least one domain")
+	}
+	switch c.ACME.Challenge {
+	case "", "tls-alpn-01", "http-01":
+	default:
+		return fmt.Errorf("unknown acme challenge %q", c.ACME.Challenge)
+	}
+	return nil
+}
+
+func (c *ACMEConfig) manager() *autocert.Manager {
+	dir := c.CacheDir
+	if dir == "" {
+		dir = "acme-cache"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Cache:      autocert.DirCache(dir),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+	return m
+}
+
+// configure makes srv obtain its certificates through ACME, when enabled.
+// Otherwise srv serves CertFile and KeyFile.
+func (c TLSConfig) configure(srv *http.Server) {
+	if c.ACME == nil {
+		return
+	}
+	m := c.ACME.manager()
+	if c.ACME.Challenge == "http-01" {
+		addr := c.ACME.HTTPAddr
+		if addr == "" {
+			addr = ":80"
+		}
+		go func() {
+			log.Printf("ACME http-01 listener stopped: %v", http.ListenAndServe(addr, m.HTTPHandler(nil)))
+		}()
+	}
+	srv.TLSConfig = m.TLSConfig()
+}
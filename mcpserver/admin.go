@@ -0,0 +1,110 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminConfig protects the /admin API. The token may also be supplied via
+// MCP_ADMIN_TOKEN. Login adds browser sign-in for the API and the
+// dashboard; without a token or login the admin API is disabled.
+type AdminConfig struct {
+	Token string            `json:"token,omitempty"`
+	Login *AdminLoginConfig `json:"login,omitempty"`
+}
+
+// adminHandler serves one admin resource. rest is the path after the
+// resource name, without a leading slash.
+type adminHandler func(w http.ResponseWriter, r *http.Request, rest string)
+
+func (s *MCPServer) setupAdmin() {
+	s.adminRoutes = map[string]adminHandler{
+		"flags":      s.handleAdminFlags,
+		"usage":      s.handleAdminUsage,
+		"accounting": s.handleAdminAccounting,
+		"jobs":       s.handleAdminJobs,
+		"runtime":    s.handleAdminRuntime,
+		"config":     s.handleAdminConfig,
+		"bundle":     s.handleAdminBundle,
+		"canaries":   s.handleAdminCanaries,
+		"approvals":  s.handleAdminApprovals,
+		"tools":      s.handleAdminTools,
+		"sessions":   s.handleAdminSessions,
+		"purge":      s.handleAdminPurge,
+		"telemetry":  s.handleAdminTelemetry,
+		"keys":       s.handleAdminKeys,
+		"clients":    s.handleAdminClients,
+	}
+}
+
+func (s *MCPServer) adminToken() string {
+	if t := os.Getenv("MCP_ADMIN_TOKEN"); t != "" {
+		return t
+	}
+	return s.cfg.Admin.Token
+}
+
+func (s *MCPServer) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	login := s.cfg.Admin.Login
+	if s.adminToken() == "" && login == nil {
+		http.Error(w, "Admin API disabled", http.StatusNotFound)
+		return
+	}
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/"), "/")
+	if login != nil {
+		switch resource {
+		case "login", "logout":
+			s.handleAdminLogin(w, r, resource)
+			return
+		case "oidc":
+			s.handleAdminOIDC(w, r, rest)
+			return
+		}
+	}
+	// Wrong admin tokens count against the same per-address limit as
+	// wrong passwords.
+	addr := clientIP(r)
+	bearer := strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if bearer && s.logins.blocked(addr) {
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+	if err := s.adminAuthorized(r); err != nil {
+		if bearer {
+			s.logins.fail(addr)
+		}
+		s.publish(EventAuthFailed, map[string]interface{}{"realm": "admin", "remoteAddr": addr, "path": r.URL.Path, "reason": err.Error()})
+		// A signed-in browser failing the CSRF check is forbidden, not
+		// unauthenticated.
+		if _, ok := s.adminSession(r); ok {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	handler, ok := s.adminRoutes[resource]
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	handler(w, r, rest)
+}
+
+// writeJSON writes v as the JSON response body. A value that cannot be
+// encoded yields a 500 instead of a truncated body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("encoding response: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,638 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// AdminLoginConfig enables browser sign-in to the dashboard and the admin
+// API, separate from MCP API keys. Signed-in browsers hold a session
+// cookie; mutating admin requests made with it must carry the session's
+// CSRF token. The admin token keeps working for scripts.
+type AdminLoginConfig struct {
+	// Users sign in with a password, stored as a bcrypt hash (see
+	// "mcp-server config hash-password").
+	Users []AdminUser `json:"users,omitempty"`
+	OIDC  *OIDCConfig `json:"oidc,omitempty"`
+	// SessionTTL is how long a sign-in lasts (default 8h).
+	SessionTTL Duration `json:"sessionTTL,omitempty" schema:"format=duration"`
+}
+
+// AdminUser is a static dashboard user.
+type AdminUser struct {
+	Name         string `json:"name" schema:"required"`
+	PasswordHash string `json:"passwordHash" schema:"required"`
+}
+
+// OIDCConfig signs users in with an OpenID Connect provider through the
+// authorization code flow with PKCE. RedirectURL is the address of
+// /admin/oidc/callback as the browser sees it. Only users whose email
+// (when verified) or subject matches an Allow pattern, such as
+// "*@example.com", get in.
+type OIDCConfig struct {
+	Issuer       string   `json:"issuer" schema:"required"`
+	ClientID     string   `json:"clientId" schema:"required"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	RedirectURL  string   `json:"redirectURL" schema:"required"`
+	Scopes       []string `json:"scopes,omitempty"`
+	Allow        []string `json:"allow" schema:"required"`
+}
+
+const (
+	adminCookie          = "mcp_admin"
+	oidcStateCookie      = "mcp_oidc_state"
+	loginCSRFCookie      = "mcp_login_csrf"
+	defaultAdminLoginTTL = 8 * time.Hour
+	oidcFlowTimeout      = 10 * time.Minute
+	// After maxAdminFailures wrong passwords or admin tokens from an
+	// address within adminFailureWindow, it is refused until the window
+	// ends.
+	maxAdminFailures   = 5
+	adminFailureWindow = 15 * time.Minute
+)
+
+func (c *AdminLoginConfig) check() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Users) == 0 && c.OIDC == nil {
+		return errors.New("login needs users or oidc")
+	}
+	for _, u := range c.Users {
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return fmt.Errorf("user %q: passwordHash is not a bcrypt hash", u.Name)
+		}
+	}
+	if o := c.OIDC; o != nil {
+		if o.Issuer == "" || o.ClientID == "" || o.RedirectURL == "" {
+			return errors.New("oidc needs issuer, clientId and redirectURL")
+		}
+		if len(o.Allow) == 0 {
+			return errors.New("oidc needs allow patterns; any account of the provider could sign in otherwise")
+		}
+		if u, err := url.Parse(o.RedirectURL); err != nil || !strings.HasSuffix(u.Path, "/admin/oidc/callback") {
+			return errors.New("oidc redirectURL must point at /admin/oidc/callback")
+		}
+	}
+	return nil
+}
+
+func (c *AdminLoginConfig) ttl() time.Duration {
+	if c.SessionTTL > 0 {
+		return time.Duration(c.SessionTTL)
+	}
+	return defaultAdminLoginTTL
+}
+
+// adminLogin is a signed-in browser.
+type adminLogin struct {
+	user    string
+	csrf    string
+	expires time.Time
+}
+
+// oidcFlow is a sign-in waiting for the provider to redirect back.
+type oidcFlow struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// oidcProvider holds the endpoints of a provider's discovery document.
+type oidcProvider struct {
+	Issuer        string `json:"issuer"`
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+}
+
+// adminFailures counts the failed attempts of an address since first.
+type adminFailures struct {
+	count int
+	first time.Time
+}
+
+// adminLogins keeps sign-ins in memory, so they survive reloads but not
+// restarts.
+type adminLogins struct {
+	mu        sync.Mutex
+	sessions  map[string]*adminLogin // by cookie value
+	flows     map[string]*oidcFlow   // by state
+	providers map[string]*oidcProvider
+	failures  map[string]*adminFailures // by client address
+}
+
+func newAdminLogins() *adminLogins {
+	return &adminLogins{
+		sessions:  map[string]*adminLogin{},
+		flows:     map[string]*oidcFlow{},
+		providers: map[string]*oidcProvider{},
+		failures:  map[string]*adminFailures{},
+	}
+}
+
+// blocked reports whether addr used up its failed attempts.
+func (a *adminLogins) blocked(addr string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failures[addr]
+	if ok && time.Since(f.first) >= adminFailureWindow {
+		delete(a.failures, addr)
+		return false
+	}
+	return ok && f.count >= maxAdminFailures
+}
+
+// fail counts a wrong password or admin token from addr.
+func (a *adminLogins) fail(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for k, f := range a.failures {
+		if now.Sub(f.first) >= adminFailureWindow {
+			delete(a.failures, k)
+		}
+	}
+	f, ok := a.failures[addr]
+	if !ok {
+		f = &adminFailures{first: now}
+		a.failures[addr] = f
+	}
+	f.count++
+}
+
+// succeed forgets the failed attempts of addr.
+func (a *adminLogins) succeed(addr string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failures, addr)
+}
+
+func (a *adminLogins) create(user string, ttl time.Duration) (string, *adminLogin) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for id, l := range a.sessions {
+		if now.After(l.expires) {
+			delete(a.sessions, id)
+		}
+	}
+	id := randomID() + randomID()
+	l := &adminLogin{user: user, csrf: randomID(), expires: now.Add(ttl)}
+	a.sessions[id] = l
+	return id, l
+}
+
+func (a *adminLogins) get(id string) (*adminLogin, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.sessions[id]
+	if !ok || time.Now().After(l.expires) {
+		delete(a.sessions, id)
+		return nil, false
+	}
+	return l, true
+}
+
+func (a *adminLogins) end(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, id)
+}
+
+func (a *adminLogins) startFlow(next string) (string, *oidcFlow) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for state, f := range a.flows {
+		if now.After(f.expires) {
+			delete(a.flows, state)
+		}
+	}
+	state := randomID()
+	f := &oidcFlow{nonce: randomID(), verifier: randomID() + randomID(), next: next, expires: now.Add(oidcFlowTimeout)}
+	a.flows[state] = f
+	return state, f
+}
+
+// finishFlow removes and returns the flow of state; each is used once.
+func (a *adminLogins) finishFlow(state string) (*oidcFlow, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.flows[state]
+	delete(a.flows, state)
+	if !ok || time.Now().After(f.expires) {
+		return nil, false
+	}
+	return f, true
+}
+
+// adminSession returns the sign-in of r's cookie, if any.
+func (s *MCPServer) adminSession(r *http.Request) (*adminLogin, bool) {
+	if s.cfg.Admin.Login == nil {
+		return nil, false
+	}
+	c, err := r.Cookie(adminCookie)
+	if err != nil {
+		return nil, false
+	}
+	return s.logins.get(c.Value)
+}
+
+// adminAuthorized checks the admin token or, for browsers, the session
+// cookie and the CSRF token of mutating requests.
+func (s *MCPServer) adminAuthorized(r *http.Request) error {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token := s.adminToken()
+		if token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(h, "Bearer ")), []byte(token)) == 1 {
+			return nil
+		}
+		return errors.New("invalid admin token")
+	}
+	login, ok := s.adminSession(r)
+	if !ok {
+		return errors.New("invalid admin token")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	given := r.Header.Get("X-CSRF-Token")
+	if given == "" {
+		given = r.PostFormValue("csrf")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(login.csrf)) != 1 {
+		return errors.New("missing or invalid CSRF token")
+	}
+	return nil
+}
+
+var loginTemplate = template.Must(template.ParseFS(assets, "assets/login.html"))
+
+// handleAdminLogin serves the sign-in page (GET) and password sign-in
+// (POST) at /admin/login, and POST /admin/logout.
+func (s *MCPServer) handleAdminLogin(w http.ResponseWriter, r *http.Request, resource string) {
+	cfg := s.cfg.Admin.Login
+	next := safeNext(r.FormValue("next"))
+	if resource == "logout" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.adminAuthorized(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if c, err := r.Cookie(adminCookie); err == nil {
+			s.logins.end(c.Value)
+		}
+		s.setAdminCookie(w, r, adminCookie, "", -1)
+		http.Redirect(w, r, s.cfg.Proxy.basePath()+"/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	var failure string
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// The form carries the token of its cookie, so other sites cannot
+		// sign a browser in to an account of theirs.
+		c, err := r.Cookie(loginCSRFCookie)
+		addr := clientIP(r)
+		switch {
+		case err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue("csrf"))) != 1:
+			failure, status = "The sign-in form expired; try again.", http.StatusForbidden
+		case s.logins.blocked(addr):
+			failure, status = "Too many failed sign-ins; try again later.", http.StatusTooManyRequests
+		default:
+			name, password := r.PostFormValue("user"), r.PostFormValue("password")
+			if s.checkAdminPassword(name, password) {
+				s.logins.succeed(addr)
+				s.setAdminCookie(w, r, loginCSRFCookie, "", -1)
+				s.signIn(w, r, name, next)
+				return
+			}
+			s.logins.fail(addr)
+			s.publish(EventAuthFailed, map[string]interface{}{"realm": "dashboard", "remoteAddr": addr, "path": r.URL.Path, "reason": "invalid user or password"})
+			failure, status = "Invalid user or password.", http.StatusUnauthorized
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	csrf := randomID()
+	s.setAdminCookie(w, r, loginCSRFCookie, csrf, int(oidcFlowTimeout.Seconds()))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, map[string]interface{}{
+		"Base":      s.cfg.Proxy.basePath(),
+		"Next":      next,
+		"CSRF":      csrf,
+		"Passwords": len(cfg.Users) > 0,
+		"OIDC":      cfg.OIDC != nil,
+		"Error":     failure,
+	})
+}
+
+// checkAdminPassword compares against every user's hash, so the time
+// taken does not tell whether the user exists.
+func (s *MCPServer) checkAdminPassword(name, password string) bool {
+	ok := false
+	for _, u := range s.cfg.Admin.Login.Users {
+		match := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+		if match && subtle.ConstantTimeCompare([]byte(u.Name), []byte(name)) == 1 {
+			ok = true
+		}
+	}
+	return ok && name != ""
+}
+
+func (s *MCPServer) signIn(w http.ResponseWriter, r *http.Request, user, next string) {
+	ttl := s.cfg.Admin.Login.ttl()
+	id, _ := s.logins.create(user, ttl)
+	s.setAdminCookie(w, r, adminCookie, id, int(ttl.Seconds()))
+	http.Redirect(w, r, s.cfg.Proxy.basePath()+next, http.StatusSeeOther)
+}
+
+// setAdminCookie sets an HttpOnly cookie for the server's paths, Secure
+// when the request came over HTTPS. maxAge -1 deletes it.
+func (s *MCPServer) setAdminCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	path := s.cfg.Proxy.basePath()
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeNext keeps redirects after sign-in on this server.
+func safeNext(next string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\r\n") {
+		return "/dashboard"
+	}
+	return next
+}
+
+// handleAdminOIDC starts a sign-in at /admin/oidc/login and completes it
+// at /admin/oidc/callback.
+func (s *MCPServer) handleAdminOIDC(w http.ResponseWriter, r *http.Request, rest string) {
+	cfg := s.cfg.Admin.Login.OIDC
+	if cfg == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	provider, err := s.oidcProvider(ctx, cfg.Issuer)
+	if err != nil {
+		http.Error(w, "Identity provider unavailable: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	switch rest {
+	case "login":
+		state, flow := s.logins.startFlow(safeNext(r.FormValue("next")))
+		challenge := sha256.Sum256([]byte(flow.verifier))
+		scopes := cfg.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {cfg.ClientID},
+			"redirect_uri":          {cfg.RedirectURL},
+			"scope":                 {strings.Join(scopes, " ")},
+			"state":                 {state},
+			"nonce":                 {flow.nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		// The state cookie ties the callback to the browser that started
+		// the sign-in.
+		s.setAdminCookie(w, r, oidcStateCookie, state, int(oidcFlowTimeout.Seconds()))
+		target := provider.Authorization
+		if strings.Contains(target, "?") {
+			target += "&" + q.Encode()
+		} else {
+			target += "?" + q.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+
+	case "callback":
+		state := r.FormValue("state")
+		c, err := r.Cookie(oidcStateCookie)
+		s.setAdminCookie(w, r, oidcStateCookie, "", -1)
+		if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+			http.Error(w, "Sign-in expired or started in another browser", http.StatusBadRequest)
+			return
+		}
+		flow, ok := s.logins.finishFlow(state)
+		if !ok {
+			http.Error(w, "Sign-in expired or started in another browser", http.StatusBadRequest)
+			return
+		}
+		if e := r.FormValue("error"); e != "" {
+			http.Error(w, "Sign-in refused by the identity provider: "+e, http.StatusForbidden)
+			return
+		}
+		user, err := s.oidcUser(ctx, cfg, provider, r.FormValue("code"), flow)
+		if err != nil {
+			s.publish(EventAuthFailed, map[string]interface{}{"realm": "dashboard", "remoteAddr": clientIP(r), "path": r.URL.Path, "reason": err.Error()})
+			http.Error(w, "Sign-in failed: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		s.signIn(w, r, user, flow.next)
+
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// oidcProvider fetches and caches the issuer's discovery document.
+func (s *MCPServer) oidcProvider(ctx context.Context, issuer string) (*oidcProvider, error) {
+	s.logins.mu.Lock()
+	p := s.logins.providers[issuer]
+	s.logins.mu.Unlock()
+	if p != nil {
+		return p, nil
+	}
+	p = &oidcProvider{}
+	if err := s.oidcGet(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", p); err != nil {
+		return nil, err
+	}
+	if p.Issuer != issuer || p.Authorization == "" || p.Token == "" {
+		return nil, errors.New("discovery document does not match the issuer")
+	}
+	s.logins.mu.Lock()
+	s.logins.providers[issuer] = p
+	s.logins.mu.Unlock()
+	return p, nil
+}
+
+func (s *MCPServer) oidcGet(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: HTTP %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// oidcUser redeems code and returns the user the ID token names. The
+// token comes straight from the provider's token endpoint over TLS, which
+// OpenID Connect Core (3.1.3.7) accepts in place of checking its
+// signature; its issuer, audience, expiry and nonce are checked here.
+func (s *MCPServer) oidcUser(ctx context.Context, cfg *OIDCConfig, provider *oidcProvider, code string, flow *oidcFlow) (string, error) {
+	if code == "" {
+		return "", errors.New("no authorization code")
+	}
+	secret, err := expandSecrets(cfg.ClientSecret, s.secrets)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"client_id":     {cfg.ClientID},
+		"code_verifier": {flow.verifier},
+	}
+	if secret != "" {
+		form.Set("client_secret", secret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.Token, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("token request failed: HTTP %d %s", resp.StatusCode, tokens.Error)
+	}
+
+	parts := strings.Split(tokens.IDToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed ID token")
+	}
+	var claims struct {
+		Issuer        string          `json:"iss"`
+		Subject       string          `json:"sub"`
+		Audience      json.RawMessage `json:"aud"`
+		Expires       int64           `json:"exp"`
+		Nonce         string          `json:"nonce"`
+		Email         string          `json:"email"`
+		EmailVerified *bool           `json:"email_verified"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.New("malformed ID token")
+	}
+	var audience []string
+	if json.Unmarshal(claims.Audience, &audience) != nil {
+		var one string
+		json.Unmarshal(claims.Audience, &one)
+		audience = []string{one}
+	}
+	switch {
+	case claims.Issuer != provider.Issuer:
+		return "", errors.New("ID token from another issuer")
+	case !contains(audience, cfg.ClientID):
+		return "", errors.New("ID token for another client")
+	case time.Now().Unix() >= claims.Expires:
+		return "", errors.New("ID token expired")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(flow.nonce)) != 1:
+		return "", errors.New("ID token nonce mismatch")
+	}
+
+	// Providers that leave out email_verified may let users set any
+	// address, so only a verified one stands in for the subject.
+	user := claims.Subject
+	if claims.Email != "" && claims.EmailVerified != nil && *claims.EmailVerified {
+		user = claims.Email
+	}
+	if !matchAny(cfg.Allow, user) && !matchAny(cfg.Allow, claims.Subject) {
+		return "", fmt.Errorf("%s is not allowed", user)
+	}
+	return user, nil
+}
+
+// hashPassword reads a password from the terminal, or a line of stdin,
+// and prints its bcrypt hash for admin.login.users.
+func hashPassword() int {
+	var password []byte
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		p, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		password = p
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "config hash-password: no password on stdin")
+			return 1
+		}
+		password = []byte(strings.TrimRight(line, "\r\n"))
+	}
+	if len(password) == 0 {
+		fmt.Fprintln(os.Stderr, "config hash-password: empty password")
+		return 1
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(hash))
+	return 0
+}
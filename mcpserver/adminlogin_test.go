@@ -0,0 +1,122 @@
+package mcpserver
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdminLogin(t *testing.T) {
+	t.Setenv("MCP_ADMIN_TOKEN", "")
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, h := testHandler(t, &Config{Admin: AdminConfig{Login: &AdminLoginConfig{
+		Users: []AdminUser{{Name: "ops", PasswordHash: string(hash)}},
+	}}})
+	cookie := func(w *http.Response, name string) *http.Cookie {
+		for _, c := range w.Cookies() {
+			if c.Name == name && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+	// The form's token comes with the page and must match its cookie.
+	page := serve(h, testRequest("GET", "/admin/login", "127.0.0.1:5000", "")).Result()
+	formToken := cookie(page, loginCSRFCookie)
+	if page.StatusCode != http.StatusOK || formToken == nil {
+		t.Fatalf("login page: %d, cookies %v", page.StatusCode, page.Cookies())
+	}
+	login := func(peer, user, password, csrf string, c *http.Cookie) *http.Response {
+		form := url.Values{"user": {user}, "password": {password}, "csrf": {csrf}}.Encode()
+		r := testRequest("POST", "/admin/login", peer, form)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if c != nil {
+			r.AddCookie(c)
+		}
+		return serve(h, r).Result()
+	}
+
+	for _, tc := range []struct {
+		name, user, password, csrf string
+		cookie                     *http.Cookie
+		want                       int
+	}{
+		{"no form cookie", "ops", "correct horse", formToken.Value, nil, http.StatusForbidden},
+		{"forged form token", "ops", "correct horse", "forged", formToken, http.StatusForbidden},
+		{"wrong password", "ops", "wrong", formToken.Value, formToken, http.StatusUnauthorized},
+		{"unknown user", "root", "correct horse", formToken.Value, formToken, http.StatusUnauthorized},
+	} {
+		if resp := login("127.0.0.1:5000", tc.user, tc.password, tc.csrf, tc.cookie); resp.StatusCode != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+	resp := login("127.0.0.1:5000", "ops", "correct horse", formToken.Value, formToken)
+	session := cookie(resp, adminCookie)
+	if resp.StatusCode != http.StatusSeeOther || session == nil || !session.HttpOnly {
+		t.Fatalf("sign-in: %d, cookies %v", resp.StatusCode, resp.Cookies())
+	}
+	signedIn, ok := s.logins.get(session.Value)
+	if !ok {
+		t.Fatal("no session")
+	}
+
+	admin := func(method, path, csrf string, c *http.Cookie) int {
+		r := testRequest(method, path, "127.0.0.1:5000", "")
+		if c != nil {
+			r.AddCookie(c)
+		}
+		if csrf != "" {
+			r.Header.Set("X-CSRF-Token", csrf)
+		}
+		return serve(h, r).Code
+	}
+	for _, tc := range []struct {
+		name, method, path, csrf string
+		cookie                   *http.Cookie
+		want                     int
+	}{
+		{"read without a session", "GET", "/admin/usage", "", nil, http.StatusUnauthorized},
+		{"read with the session", "GET", "/admin/usage", "", session, http.StatusOK},
+		{"forged session", "GET", "/admin/usage", "", &http.Cookie{Name: adminCookie, Value: "forged"}, http.StatusUnauthorized},
+		{"write without CSRF token", "POST", "/admin/approvals/nosuch/deny", "", session, http.StatusForbidden},
+		{"write with a wrong CSRF token", "POST", "/admin/approvals/nosuch/deny", "wrong", session, http.StatusForbidden},
+		{"write with the CSRF token", "POST", "/admin/approvals/nosuch/deny", signedIn.csrf, session, http.StatusNotFound},
+		{"logout without CSRF token", "POST", "/admin/logout", "", session, http.StatusForbidden},
+		{"logout", "POST", "/admin/logout", signedIn.csrf, session, http.StatusSeeOther},
+		{"after logout", "GET", "/admin/usage", "", session, http.StatusUnauthorized},
+	} {
+		if got := admin(tc.method, tc.path, tc.csrf, tc.cookie); got != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	// Repeated wrong passwords lock the address out, but not others.
+	for i := 0; i < maxAdminFailures; i++ {
+		login("198.51.100.7:5000", "ops", "wrong", formToken.Value, formToken)
+	}
+	if resp := login("198.51.100.7:5000", "ops", "correct horse", formToken.Value, formToken); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("locked out address: %d", resp.StatusCode)
+	}
+	if resp := login("127.0.0.1:5000", "ops", "correct horse", formToken.Value, formToken); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("other address: %d", resp.StatusCode)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"/admin/usage":             "/admin/usage",
+		"":                         "/dashboard",
+		"https://attacker.example": "/dashboard",
+		"//attacker.example":       "/dashboard",
+		"/\\attacker.example":      "/dashboard",
+	} {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApprovalConfig holds calls to destructive tools until a human approves
+// them. Tools annotated destructiveHint need approval, plus any matching
+// Tools patterns. With Elicit, the calling user is asked first when the
+// client supports elicitation; otherwise the call waits for an operator
+// to decide through the admin API. Undecided calls are denied after
+// Timeout. Webhook, when set, receives a POST for every new request and
+// decision.
+type ApprovalConfig struct {
+	Enabled bool     `json:"enabled,omitempty"`
+	Tools   []string `json:"tools,omitempty"`
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"` // default 5m
+	Elicit  bool     `json:"elicit,omitempty"`
+	Webhook string   `json:"webhook,omitempty"`
+}
+
+const (
+	defaultApprovalTimeout = 5 * time.Minute
+	approvalsMetric        = "mcp_approvals_total"
+
+	// maxDecidedApprovals bounds how many decided requests are kept for
+	// the admin API.
+	maxDecidedApprovals = 200
+)
+
+// Approval is a call awaiting or past a decision. Status is "pending",
+// "approved", "denied" or "expired".
+type Approval struct {
+	ID        string          `json:"id"`
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	KeyName   string          `json:"keyName,omitempty"`
+	Client    string          `json:"client,omitempty"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason,omitempty"`
+	DecidedBy string          `json:"decidedBy,omitempty"`
+	Created   time.Time       `json:"created"`
+	Expires   time.Time       `json:"expires"`
+	Decided   *time.Time      `json:"decided,omitempty"`
+
+	done chan struct{}
+}
+
+var errApprovalNotFound = errors.New("approval not found")
+
+// approvalQueue holds approval requests; it is shared by all generations.
+type approvalQueue struct {
+	mu       sync.Mutex
+	requests map[string]*Approval
+	decided  []string // IDs in decision order, oldest first
+}
+
+func newApprovalQueue() *approvalQueue {
+	return &approvalQueue{requests: map[string]*Approval{}}
+}
+
+func (q *approvalQueue) add(a *Approval) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.requests[a.ID] = a
+}
+
+// decide settles a pending request and returns a copy of it.
+func (q *approvalQueue) decide(id, status, by, reason string) (Approval, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a, ok := q.requests[id]
+	if !ok {
+		return Approval{}, errApprovalNotFound
+	}
+	if a.Status != "pending" {
+		return *a, fmt.Errorf("approval %s is already %s", id, a.Status)
+	}
+	now := time.Now().UTC()
+	a.Status, a.DecidedBy, a.Reason, a.Decided = status, by, reason, &now
+	close(a.done)
+	q.decided = append(q.decided, id)
+	if len(q.decided) > maxDecidedApprovals {
+		delete(q.requests, q.decided[0])
+		q.decided = q.decided[1:]
+	}
+	return *a, nil
+}
+
+func (q *approvalQueue) get(id string) (Approval, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a, ok := q.requests[id]
+	if !ok {
+		return Approval{}, false
+	}
+	return *a, true
+}
+
+// list returns requests with the given status, or all, oldest first.
+func (q *approvalQueue) list(status string) []Approval {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := []Approval{}
+	for _, a := range q.requests {
+		if status == "" || a.Status == status {
+			out = append(out, *a)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.Before(out[j].Created) })
+	return out
+}
+
+// needsApproval reports whether calls to tool must be approved.
+func (s *MCPServer) needsApproval(tool Tool) bool {
+	cfg := s.cfg.Approval
+	if !cfg.Enabled {
+		return false
+	}
+	a := tool.Annotations
+	return a != nil && a.DestructiveHint != nil && *a.DestructiveHint || matchAny(cfg.Tools, tool.Name)
+}
+
+// gateApprovals wraps the handlers of tools that need approval.
+func (s *MCPServer) gateApprovals() {
+	for name, tool := range s.tools {
+		if s.needsApproval(tool) {
+			s.handlers[name] = s.approvalGate(name, s.handlers[name])
+		}
+	}
+}
+
+func (s *MCPServer) approvalGate(tool string, next ToolHandler) ToolHandler {
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		if ToolContextFrom(ctx).DryRun {
+			return next(ctx, args)
+		}
+		if err := s.awaitApproval(ctx, tool, args); err != nil {
+			return nil, err
+		}
+		return next(ctx, args)
+	}
+}
+
+// awaitApproval blocks until the call is approved and returns an error if
+// it is denied, expires or ctx ends first.
+func (s *MCPServer) awaitApproval(ctx context.Context, tool string, args json.RawMessage) error {
+	caller := callerFrom(ctx)
+	sess := sessionFrom(ctx)
+	if s.cfg.Approval.Elicit && sess != nil {
+		res, err := s.elicit(ctx, sess, Elicitation{
+			Message: fmt.Sprintf("Allow %s to run with arguments %s?", tool, compactJSON(args)),
+			Schema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"approve": map[string]interface{}{"type": "boolean", "description": "Run the tool"},
+				},
+				"required": []string{"approve"},
+			},
+		})
+		switch {
+		case err == nil && res.Accepted() && res.Content["approve"] == true:
+			s.metrics.inc(approvalsMetric, "tool", tool, "outcome", "approved")
+			return nil
+		case err == nil:
+			s.metrics.inc(approvalsMetric, "tool", tool, "outcome", "denied")
+			return fmt.Errorf("call to %s was declined by the user", tool)
+		case !errors.Is(err, ErrElicitationUnavailable):
+			return err
+		}
+		// The user cannot be asked; fall back to an operator.
+	}
+
+	timeout := time.Duration(s.cfg.Approval.Timeout)
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	now := time.Now().UTC()
+	a := &Approval{
+		ID:        randomID(),
+		Tool:      tool,
+		Arguments: args,
+		KeyName:   caller.KeyName(),
+		Status:    "pending",
+		Created:   now,
+		Expires:   now.Add(timeout),
+		done:      make(chan struct{}),
+	}
+	if sess != nil {
+		a.Client = sess.ClientName
+	}
+	// Copied before it is shared; an operator may decide it right away.
+	pending := *a
+	s.approvals.add(a)
+	log.Printf("approval %s: %s awaits approval", a.ID, tool)
+	s.notifyApproval("approval.pending", pending)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-a.done:
+	case <-timer.C:
+		s.settleApproval(a.ID, "expired", "", "")
+	case <-ctx.Done():
+		s.settleApproval(a.ID, "denied", "", "call cancelled")
+		return ctx.Err()
+	}
+	decided, _ := s.approvals.get(a.ID)
+	if decided.Status == "approved" {
+		return nil
+	}
+	msg := fmt.Sprintf("call to %s was %s", tool, decided.Status)
+	if decided.Status == "expired" {
+		msg = fmt.Sprintf("call to %s was not approved within %v", tool, timeout)
+	}
+	if decided.Reason != "" {
+		msg += ": " + decided.Reason
+	}
+	return errors.New(msg)
+}
+
+// settleApproval decides a request and reports the decision.
+func (s *MCPServer) settleApproval(id, status, by, reason string) (Approval, error) {
+	a, err := s.approvals.decide(id, status, by, reason)
+	if err != nil {
+		return a, err
+	}
+	s.metrics.inc(approvalsMetric, "tool", a.Tool, "outcome", status)
+	log.Printf("approval %s: %s %s", id, a.Tool, status)
+	s.notifyApproval("approval."+status, a)
+	return a, nil
+}
+
+// notifyApproval posts event to the configured webhook in the background.
+func (s *MCPServer) notifyApproval(event string, a Approval) {
+	url := s.cfg.Approval.Webhook
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{"event": event, "approval": a})
+	if err != nil {
+		return
+	}
+	client := s.httpClient()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("approval webhook: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("approval webhook: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("approval webhook: %s", resp.Status)
+		}
+	}()
+}
+
+// compactJSON renders raw on one line for prompts.
+func compactJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil || buf.Len() == 0 {
+		return "{}"
+	}
+	return buf.String()
+}
+
+// handleAdminApprovals serves GET /admin/approvals[?status=], GET
+// /admin/approvals/{id} and POST /admin/approvals/{id}/approve or /deny,
+// which take an optional {"reason": "..."} body.
+func (s *MCPServer) handleAdminApprovals(w http.ResponseWriter, r *http.Request, rest string) {
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"approvals": s.approvals.list(r.URL.Query().Get("status"))})
+		return
+	}
+	id, action, _ := strings.Cut(rest, "/")
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a, ok := s.approvals.get(id)
+		if !ok {
+			http.Error(w, "Approval not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := map[string]string{"approve": "approved", "deny": "denied"}[action]
+	if status == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	a, err := s.settleApproval(id, status, "admin", body.Reason)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, a)
+	case errors.Is(err, errApprovalNotFound):
+		http.Error(w, "Approval not found", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
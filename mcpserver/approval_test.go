@@ -0,0 +1,81 @@
+package mcpserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovalGate(t *testing.T) {
+	t.Setenv("MCP_ADMIN_TOKEN", "")
+	destructive := true
+	s, h := testHandler(t, &Config{
+		Admin:    AdminConfig{Token: "admin-token"},
+		Approval: ApprovalConfig{Enabled: true, Tools: []string{"deploy_*"}, Timeout: Duration(time.Second)},
+		Tools: []ToolConfig{
+			{Name: "drop_table", Annotations: &ToolAnnotations{DestructiveHint: &destructive}, Backend: BackendConfig{Type: "static", Text: "dropped"}},
+			{Name: "deploy_web", Backend: BackendConfig{Type: "static", Text: "deployed"}},
+			{Name: "status", Backend: BackendConfig{Type: "static", Text: "fine"}},
+		},
+	})
+	decide := func(id, action, body string) int {
+		r := testRequest("POST", "/admin/approvals/"+id+"/"+action, "127.0.0.1:5000", body)
+		r.Header.Set("Authorization", "Bearer admin-token")
+		return serve(h, r).Code
+	}
+	// run calls tool and has decision made on its pending request.
+	run := func(tool string, decision func(id string)) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.handlers[tool](s.toolContext(context.Background(), tool), []byte(`{"table": "users"}`))
+			done <- err
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for decision != nil {
+			if pending := s.approvals.list("pending"); len(pending) > 0 {
+				if pending[0].Tool != tool {
+					t.Fatalf("pending call to %s", pending[0].Tool)
+				}
+				decision(pending[0].ID)
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: no approval request", tool)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return <-done
+	}
+
+	if err := run("status", nil); err != nil {
+		t.Errorf("ungated tool: %v", err)
+	}
+	if err := run("drop_table", func(id string) {
+		if code := decide(id, "approve", ""); code != http.StatusOK {
+			t.Errorf("approve: %d", code)
+		}
+		if code := decide(id, "deny", ""); code == http.StatusOK {
+			t.Errorf("decided twice: %d", code)
+		}
+	}); err != nil {
+		t.Errorf("approved call: %v", err)
+	}
+	if err := run("deploy_web", func(id string) {
+		decide(id, "deny", `{"reason": "change freeze"}`)
+	}); err == nil || !strings.Contains(err.Error(), "denied: change freeze") {
+		t.Errorf("denied call: %v", err)
+	}
+	if err := run("drop_table", func(string) {}); err == nil || !strings.Contains(err.Error(), "not approved within") {
+		t.Errorf("undecided call: %v", err)
+	}
+	if code := decide("nosuch", "approve", ""); code != http.StatusNotFound {
+		t.Errorf("unknown approval: %d", code)
+	}
+	for _, a := range s.approvals.list("") {
+		if a.Status == "pending" {
+			t.Errorf("left pending: %+v", a)
+		}
+	}
+}
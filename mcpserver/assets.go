@@ -0,0 +1,111 @@
+package mcpserver
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"text/template"
+)
+
+// assets are compiled into the binary, so a deployment needs no files
+// besides it: the dashboard page and the starter workspace "init" writes.
+//
+//go:embed assets
+var assets embed.FS
+
+// initDir is the starter workspace within assets. Its files are templates
+// with [[ ]] delimiters, leaving {{ }} to the tool templates they contain.
+const initDir = "assets/init"
+
+// runInitCommand implements the "init" subcommand.
+func runInitCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server init [-force] [dir]")
+		return 2
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	written, err := writeWorkspace(dir, *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init: %v\n", err)
+		return 1
+	}
+	for _, name := range written {
+		fmt.Println("wrote", name)
+	}
+	fmt.Printf("start the server with: cd %s && MCP_CONFIG=mcp.json mcp-server\n", dir)
+	return 0
+}
+
+// writeWorkspace renders the starter workspace into dir with a fresh API
+// key and admin token. Without force, it writes nothing if any of its
+// files exist.
+func writeWorkspace(dir string, force bool) ([]string, error) {
+	values := map[string]string{"APIKey": "mcp_" + randomID(), "AdminToken": randomID()}
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	err := fs.WalkDir(assets, initDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		text, err := assets.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(path.Base(name)).Delims("[[", "]]").Parse(string(text))
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := t.Execute(&out, values); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(initDir, name)
+		files = append(files, file{filepath.Join(dir, rel), out.Bytes()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !force {
+		for _, f := range files {
+			if _, err := os.Stat(f.name); err == nil {
+				return nil, fmt.Errorf("%s exists; use -force to overwrite", f.name)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0o700); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, f := range files {
+		// mcp.json holds the generated secrets.
+		perm := os.FileMode(0o644)
+		if filepath.Base(f.name) == "mcp.json" {
+			perm = 0o600
+		}
+		if err := os.WriteFile(f.name, f.data, perm); err != nil {
+			return written, err
+		}
+		written = append(written, f.name)
+	}
+	return written, nil
+}
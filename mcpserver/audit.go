@@ -0,0 +1,337 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// AuditConfig exports server events, tool calls included, to Kafka or
+// NATS for a central data platform. Events are batched and a batch is
+// retried until the broker acknowledges it, so delivery is at least once;
+// consumers deduplicate on the event ID. With Spool, batches are kept in
+// the state store until acknowledged and resent after a restart.
+// Events that do not fit in Buffer while the broker is unreachable are
+// dropped and counted.
+type AuditConfig struct {
+	Events           []string     `json:"events,omitempty"` // patterns, default all
+	Kafka            *KafkaConfig `json:"kafka,omitempty"`
+	NATS             *NATSConfig  `json:"nats,omitempty"`
+	BatchSize        int          `json:"batchSize,omitempty"`                              // default 100
+	FlushInterval    Duration     `json:"flushInterval,omitempty" schema:"format=duration"` // default 1s
+	Buffer           int          `json:"buffer,omitempty"`                                 // default 10000
+	Spool            bool         `json:"spool,omitempty"`
+	IncludeArguments bool         `json:"includeArguments,omitempty"`
+}
+
+const (
+	auditEventsMetric       = "mcp_audit_events_total"
+	auditSendFailuresMetric = "mcp_audit_send_failures_total"
+
+	defaultAuditBatchSize     = 100
+	defaultAuditFlushInterval = time.Second
+	defaultAuditBuffer        = 10000
+	maxAuditBackoff           = 30 * time.Second
+)
+
+// auditRecord is one exported event.
+type auditRecord struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// auditSink publishes a batch and returns once the broker has
+// acknowledged all of it.
+type auditSink interface {
+	name() string
+	send(ctx context.Context, batch []auditRecord) error
+	close()
+}
+
+// auditExporter queues events from the bus and ships them in batches.
+type auditExporter struct {
+	cfg     AuditConfig
+	sink    auditSink
+	metrics *metricsRegistry
+	db      store.Store // spool, if enabled
+	host    string
+
+	mu     sync.Mutex
+	queue  []auditRecord
+	wake   chan struct{}
+	done   chan struct{}
+	nextID int64 // spool file sequence
+}
+
+// newAuditExporter returns nil when no sink is configured.
+func newAuditExporter(cfg AuditConfig, metrics *metricsRegistry, db store.Store) (*auditExporter, error) {
+	var sink auditSink
+	var err error
+	switch {
+	case cfg.Kafka != nil && cfg.NATS != nil:
+		return nil, fmt.Errorf("configure either kafka or nats, not both")
+	case cfg.Kafka != nil:
+		sink, err = newKafkaSink(*cfg.Kafka)
+	case cfg.NATS != nil:
+		sink, err = newNATSSink(*cfg.NATS)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Spool && db == nil {
+		return nil, fmt.Errorf("spool needs a state store: set dataDir or store")
+	}
+	if !cfg.Spool {
+		db = nil
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultAuditBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = Duration(defaultAuditFlushInterval)
+	}
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = defaultAuditBuffer
+	}
+	host, _ := os.Hostname()
+	return &auditExporter{cfg: cfg, sink: sink, metrics: metrics, db: db, host: host, wake: make(chan struct{}, 1), done: make(chan struct{})}, nil
+}
+
+// record queues ev; it is the exporter's bus subscriber.
+func (a *auditExporter) record(ev Event) {
+	if len(a.cfg.Events) > 0 && !matchAny(a.cfg.Events, ev.Type) {
+		return
+	}
+	doc := map[string]interface{}{"id": ev.ID, "type": ev.Type, "time": ev.Time, "host": a.host, "data": ev.Data}
+	if a.cfg.IncludeArguments && len(ev.Arguments) > 0 {
+		doc["arguments"] = ev.Arguments
+	}
+	value, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	if len(a.queue) >= a.cfg.Buffer {
+		a.mu.Unlock()
+		a.metrics.inc(auditEventsMetric, "sink", a.sink.name(), "outcome", "dropped")
+		return
+	}
+	a.queue = append(a.queue, auditRecord{Key: ev.ID, Type: ev.Type, Value: value})
+	full := len(a.queue) >= a.cfg.BatchSize
+	a.mu.Unlock()
+	if full {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run ships batches until ctx is cancelled, then makes a last attempt and
+// spools what is left.
+func (a *auditExporter) run(ctx context.Context) {
+	defer close(a.done)
+	defer a.sink.close()
+	if err := a.resendSpool(ctx); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	ticker := time.NewTicker(time.Duration(a.cfg.FlushInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.shutdown()
+			return
+		case <-ticker.C:
+		case <-a.wake:
+		}
+		for {
+			batch := a.take()
+			if len(batch) == 0 {
+				break
+			}
+			if !a.ship(ctx, batch) {
+				break
+			}
+		}
+	}
+}
+
+// wait blocks until run has finished after ctx was cancelled.
+func (a *auditExporter) wait(ctx context.Context) {
+	if ctx.Err() != nil {
+		<-a.done
+	}
+}
+
+// take removes up to one batch from the queue.
+func (a *auditExporter) take() []auditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := len(a.queue)
+	if n > a.cfg.BatchSize {
+		n = a.cfg.BatchSize
+	}
+	batch := append([]auditRecord(nil), a.queue[:n]...)
+	a.queue = a.queue[n:]
+	return batch
+}
+
+// ship spools batch and sends it, retrying with backoff until it is
+// acknowledged or ctx ends. It reports whether the batch went out.
+func (a *auditExporter) ship(ctx context.Context, batch []auditRecord) bool {
+	spooled := a.spool(batch)
+	backoff := time.Second
+	for {
+		err := a.sink.send(ctx, batch)
+		if err == nil {
+			a.metrics.add(auditEventsMetric, float64(len(batch)), "sink", a.sink.name(), "outcome", "exported")
+			if spooled != "" {
+				deleteRecord(a.db, nsAudit, spooled)
+			}
+			return true
+		}
+		a.metrics.inc(auditSendFailuresMetric, "sink", a.sink.name())
+		log.Printf("audit: sending %d events to %s failed, retrying in %v: %v", len(batch), a.sink.name(), backoff, err)
+		select {
+		case <-ctx.Done():
+			if spooled == "" {
+				// Put the batch back for the final attempt.
+				a.mu.Lock()
+				a.queue = append(batch, a.queue...)
+				a.mu.Unlock()
+			}
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxAuditBackoff {
+			backoff = maxAuditBackoff
+		}
+	}
+}
+
+// shutdown gives the broker a few seconds to take the rest of the queue
+// and spools what it does not.
+func (a *auditExporter) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		batch := a.take()
+		if len(batch) == 0 {
+			return
+		}
+		if err := a.sink.send(ctx, batch); err == nil {
+			a.metrics.add(auditEventsMetric, float64(len(batch)), "sink", a.sink.name(), "outcome", "exported")
+			continue
+		}
+		if a.spool(batch) == "" {
+			a.metrics.add(auditEventsMetric, float64(len(batch)), "sink", a.sink.name(), "outcome", "dropped")
+		}
+	}
+}
+
+// spool stores batch and returns its key, or "" without a spool.
+func (a *auditExporter) spool(batch []auditRecord) string {
+	if a.db == nil {
+		return ""
+	}
+	a.mu.Lock()
+	a.nextID++
+	// Keys sort by creation time, which is the order batches are resent in.
+	key := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), a.nextID)
+	a.mu.Unlock()
+	if err := putJSON(a.db, nsAudit, key, "", batch, time.Time{}); err != nil {
+		log.Printf("audit: spooling batch: %v", err)
+		return ""
+	}
+	return key
+}
+
+// purgeKey drops queued and spooled events of API key name and returns
+// how many. Events already delivered are out of its reach.
+func (a *auditExporter) purgeKey(ctx context.Context, name string) (int, error) {
+	match := func(r auditRecord) bool {
+		var doc struct {
+			Data struct {
+				Key string `json:"key"`
+			} `json:"data"`
+		}
+		return json.Unmarshal(r.Value, &doc) == nil && doc.Data.Key == name
+	}
+	filter := func(batch []auditRecord) []auditRecord {
+		kept := batch[:0]
+		for _, r := range batch {
+			if !match(r) {
+				kept = append(kept, r)
+			}
+		}
+		return kept
+	}
+	a.mu.Lock()
+	n := len(a.queue)
+	a.queue = filter(a.queue)
+	n -= len(a.queue)
+	a.mu.Unlock()
+	if a.db == nil {
+		return n, nil
+	}
+	recs, err := a.db.List(ctx, nsAudit, "")
+	if err != nil {
+		return n, err
+	}
+	for _, rec := range recs {
+		var batch []auditRecord
+		if json.Unmarshal(rec.Value, &batch) != nil {
+			continue
+		}
+		kept := filter(batch)
+		switch dropped := len(batch) - len(kept); {
+		case dropped == 0:
+			continue
+		case len(kept) == 0:
+			err = a.db.Delete(ctx, nsAudit, rec.Key)
+		default:
+			err = putJSON(a.db, nsAudit, rec.Key, "", kept, time.Time{})
+		}
+		if err != nil {
+			return n, err
+		}
+		n += len(batch) - len(kept)
+	}
+	return n, nil
+}
+
+// resendSpool sends the batches a previous run left behind, oldest first.
+func (a *auditExporter) resendSpool(ctx context.Context) error {
+	if a.db == nil {
+		return nil
+	}
+	recs, err := a.db.List(ctx, nsAudit, "")
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		var batch []auditRecord
+		if err := json.Unmarshal(rec.Value, &batch); err != nil {
+			log.Printf("audit: dropping unreadable spooled batch %s: %v", rec.Key, err)
+			deleteRecord(a.db, nsAudit, rec.Key)
+			continue
+		}
+		// ship spools the batch afresh, so the old record can go either way.
+		ok := a.ship(ctx, batch)
+		deleteRecord(a.db, nsAudit, rec.Key)
+		if !ok {
+			return nil
+		}
+	}
+	return nil
+}
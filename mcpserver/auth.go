@@ -0,0 +1,106 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig lists the API keys accepted on /mcp. When no keys are
+// configured the endpoint is open.
+type AuthConfig struct {
+	APIKeys []APIKeyConfig `json:"apiKeys,omitempty"`
+	// SignatureWindow is how far the timestamp of a signed request may be
+	// from the server's clock (default 5m).
+	SignatureWindow Duration `json:"signatureWindow,omitempty" schema:"format=duration"`
+	// MintKeys lets /admin/keys create, list and revoke keys kept in the
+	// state store. It turns authentication on even without APIKeys.
+	MintKeys bool `json:"mintKeys,omitempty"`
+}
+
+// enabled reports whether /mcp requires a key.
+func (c AuthConfig) enabled() bool {
+	return len(c.APIKeys) > 0 || c.MintKeys
+}
+
+// APIKeyConfig is a static API key. Role is free-form and used by tool
+// exposure rules. Key is sent as a bearer token; Secret, which may refer
+// to ${secret:name}, instead signs requests with HMAC under the key's
+// name. A key has either or both. Tools, when set, are the name patterns
+// of the only tools the key may list and call. Names are unique: sessions,
+// jobs and quotas belong to a key by name.
+type APIKeyConfig struct {
+	Name   string      `json:"name" schema:"required"`
+	Key    string      `json:"key,omitempty"`
+	Secret string      `json:"secret,omitempty"`
+	Role   string      `json:"role,omitempty"`
+	Tools  []string    `json:"tools,omitempty"`
+	Quota  QuotaConfig `json:"quota,omitempty"`
+}
+
+var errUnauthorized = errors.New("invalid or missing API key")
+
+// Caller identifies who is making an MCP request.
+type Caller struct {
+	Key     *APIKeyConfig
+	Session *Session
+}
+
+// Role returns the caller's API key role, or "" when unauthenticated.
+func (c *Caller) Role() string {
+	if c == nil || c.Key == nil {
+		return ""
+	}
+	return c.Key.Role
+}
+
+// KeyName returns the caller's API key name, or "" when unauthenticated.
+func (c *Caller) KeyName() string {
+	if c == nil || c.Key == nil {
+		return ""
+	}
+	return c.Key.Name
+}
+
+type callerKey struct{}
+
+func withCaller(ctx context.Context, c *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// callerFrom returns the caller stored in ctx, or nil.
+func callerFrom(ctx context.Context) *Caller {
+	c, _ := ctx.Value(callerKey{}).(*Caller)
+	return c
+}
+
+// authenticate matches the request's bearer token or X-API-Key header
+// against the configured keys, or checks its signature. It returns nil
+// without error when authentication is disabled.
+func (s *MCPServer) authenticate(r *http.Request) (*APIKeyConfig, error) {
+	if !s.cfg.Auth.enabled() {
+		return nil, nil
+	}
+	if r.Header.Get(signatureHeader) != "" {
+		return s.verifySignature(r)
+	}
+	token := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token == "" {
+		return nil, errUnauthorized
+	}
+	if s.cfg.Auth.MintKeys && strings.HasPrefix(token, mintedKeyPrefix) {
+		return s.authenticateMinted(r.Context(), token)
+	}
+	for i := range s.cfg.Auth.APIKeys {
+		key := &s.cfg.Auth.APIKeys[i]
+		if key.Key != "" && subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			return key, nil
+		}
+	}
+	return nil, errUnauthorized
+}
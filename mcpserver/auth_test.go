@@ -0,0 +1,27 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyNames(t *testing.T) {
+	for _, tc := range []struct {
+		keys []APIKeyConfig
+		err  string
+	}{
+		{[]APIKeyConfig{{Name: "ops", Key: "k1"}, {Name: "ci", Key: "k2"}}, ""},
+		{[]APIKeyConfig{{Key: "k1"}}, "apiKeys[0]: name is required"},
+		{[]APIKeyConfig{{Name: "ops", Key: "k1"}, {Name: "ops", Key: "k2"}}, `apiKeys[1]: name "ops" is used by another key`},
+		{[]APIKeyConfig{{Name: "ops", Key: "k1"}, {Name: "ops", Secret: "0123456789abcdef"}}, `apiKeys[1]: name "ops" is used by another key`},
+		{[]APIKeyConfig{{Name: "anonymous", Key: "k1"}}, "reserved for unauthenticated callers"},
+		{[]APIKeyConfig{{Name: "ops"}}, "apiKeys[0]: set key or secret"},
+	} {
+		_, err := newConfiguredServer(&Config{Auth: AuthConfig{APIKeys: tc.keys}})
+		if tc.err == "" && err != nil {
+			t.Errorf("%+v: %v", tc.keys, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%+v: %v, want %s", tc.keys, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package mcpserver
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mcp-server/store"
+)
+
+// A backup is a gzipped tar archive of everything a deployment needs to
+// come back after losing its host:
+//
+//	config.json     the config file, byte for byte
+//	store.jsonl     every live state store record, one per line
+//	files/<kind>    state kept outside the store (see backupPaths)
+//	manifest.json   where it all came from, written last
+//
+// Records are dumped logically rather than as database files, so a backup
+// taken from SQLite restores into Postgres and across schema versions.
+// With a passphrase the archive is encrypted; see sealWriter.
+const backupVersion = 1
+
+type backupManifest struct {
+	Version int               `json:"version"`
+	Created time.Time         `json:"created"`
+	Host    string            `json:"host,omitempty"`
+	Config  string            `json:"config,omitempty"`
+	Profile string            `json:"profile,omitempty"`
+	Records int               `json:"records"`
+	Files   map[string]string `json:"files,omitempty"`
+}
+
+// backupPaths returns the files and directories outside the state store
+// that hold state worth keeping, by their name in the archive.
+func backupPaths(cfg *Config) map[string]string {
+	paths := map[string]string{}
+	if cfg.Usage.Path != "" {
+		paths["usage"] = cfg.Usage.Path
+	}
+	if cfg.VCR.Mode != "" {
+		paths["vcr"] = cfg.VCR.Dir
+		if paths["vcr"] == "" {
+			paths["vcr"] = defaultCassetteDir
+		}
+	}
+	if acme := cfg.TLS.ACME; acme != nil {
+		paths["acme"] = acme.CacheDir
+		if paths["acme"] == "" {
+			paths["acme"] = "acme-cache"
+		}
+	}
+	return paths
+}
+
+// backupPassphrase reads the passphrase from file, or else
+// $MCP_BACKUP_PASSPHRASE; empty means no encryption.
+func backupPassphrase(file string) (string, error) {
+	if file == "" {
+		return os.Getenv("MCP_BACKUP_PASSPHRASE"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// runBackupCommand implements the "backup" subcommand.
+func runBackupCommand(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", `archive to write, "-" for stdout (default mcp-backup-<time>.tar.gz)`)
+	passFile := fs.String("passphrase-file", "", "encrypt with the passphrase in this file (default $MCP_BACKUP_PASSPHRASE)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	pass, err := backupPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	if *out == "" {
+		*out = "mcp-backup-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+		if pass != "" {
+			*out += ".enc"
+		}
+	}
+	m, err := writeBackupFile(*out, pass)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "backed up %s\n", describeBackup(m))
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "wrote %s\n", *out)
+	}
+	return 0
+}
+
+// writeBackupFile writes the backup to name, replacing it only once the
+// archive is complete.
+func writeBackupFile(name, pass string) (*backupManifest, error) {
+	cfgPath, env := os.Getenv("MCP_CONFIG"), os.Getenv("MCP_ENV")
+	if name == "-" {
+		return writeBackup(os.Stdout, cfgPath, env, pass)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	m, err := writeBackup(f, cfgPath, env, pass)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, os.Rename(f.Name(), name)
+}
+
+func writeBackup(w io.Writer, cfgPath, env, pass string) (*backupManifest, error) {
+	cfg, err := loadConfig(cfgPath, env)
+	if err != nil {
+		return nil, err
+	}
+	m := &backupManifest{Version: backupVersion, Created: time.Now().UTC(), Profile: env, Files: backupPaths(cfg)}
+	m.Host, _ = os.Hostname()
+	if cfgPath != "" {
+		m.Config, _ = filepath.Abs(cfgPath)
+	}
+
+	if pass != "" {
+		sw, err := newSealWriter(w, pass)
+		if err != nil {
+			return nil, err
+		}
+		defer sw.Close()
+		w = sw
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if cfgPath != "" {
+		data, err := os.ReadFile(cfgPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := tarBytes(tw, "config.json", data, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	if m.Records, err = tarRecords(tw, cfg); err != nil {
+		return nil, fmt.Errorf("state store: %w", err)
+	}
+	for kind, p := range m.Files {
+		found, err := tarPath(tw, "files/"+kind, p)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			delete(m.Files, kind)
+		}
+	}
+	data, _ := json.MarshalIndent(m, "", "  ")
+	if err := tarBytes(tw, "manifest.json", data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if sw, ok := w.(*sealWriter); ok {
+		return m, sw.Close()
+	}
+	return m, nil
+}
+
+func tarBytes(tw *tar.Writer, name string, data []byte, mode int64) error {
+	hdr := &tar.Header{Name: name, Mode: mode, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// tarRecords adds store.jsonl when a state store is configured. The
+// records are spooled to a temporary file first since tar needs the size
+// up front; they are read in one query, so the dump is consistent even
+// with the server running. Encrypted values stay encrypted.
+func tarRecords(tw *tar.Writer, cfg *Config) (int, error) {
+	db, err := openDatabase(cfg)
+	if err != nil || db == nil {
+		return 0, err
+	}
+	defer db.Close()
+	tmp, err := os.CreateTemp("", "mcp-records-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	bw := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(bw)
+	n := 0
+	err = db.Each(context.Background(), func(rec *store.Record) error {
+		n++
+		return enc.Encode(rec)
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return 0, err
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	hdr := &tar.Header{Name: "store.jsonl", Mode: 0o600, Size: fi.Size(), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	_, err = io.Copy(tw, tmp)
+	return n, err
+}
+
+// tarPath adds the file or directory tree at p under name and reports
+// whether p exists; a feature may not have written anything yet.
+func tarPath(tw *tar.Writer, name, p string) (bool, error) {
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return true, filepath.WalkDir(p, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(p, file)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+func describeBackup(m *backupManifest) string {
+	parts := []string{}
+	if m.Config != "" {
+		parts = append(parts, "config")
+	}
+	parts = append(parts, fmt.Sprintf("%d records", m.Records))
+	kinds := make([]string, 0, len(m.Files))
+	for kind := range m.Files {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return strings.Join(append(parts, kinds...), ", ")
+}
+
+// runRestoreCommand implements the "restore" subcommand. The archive is
+// unpacked and checked in a staging directory before anything is written,
+// and nothing existing is overwritten without -force.
+func runRestoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	cfgPath := fs.String("config", os.Getenv("MCP_CONFIG"), "where to restore the config file (default $MCP_CONFIG, else its original path)")
+	passFile := fs.String("passphrase-file", "", "decrypt with the passphrase in this file (default $MCP_BACKUP_PASSPHRASE)")
+	force := fs.Bool("force", false, "replace existing config, records and files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server restore [flags] <archive>")
+		return 2
+	}
+	pass, err := backupPassphrase(*passFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	m, err := restoreBackup(fs.Arg(0), *cfgPath, pass, *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "restored %s from the backup of %s taken %s\n", describeBackup(m), m.Host, m.Created.Format(time.RFC3339))
+	return 0
+}
+
+func restoreBackup(name, cfgPath, pass string, force bool) (*backupManifest, error) {
+	stage, err := os.MkdirTemp("", "mcp-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+	if err := unpackBackup(name, pass, stage); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(stage, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("not a complete backup: %w", err)
+	}
+	m := &backupManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if m.Version > backupVersion {
+		return nil, fmt.Errorf("backup format %d is newer than this server's %d", m.Version, backupVersion)
+	}
+
+	// The restored config decides where everything else goes.
+	cfgData, err := os.ReadFile(filepath.Join(stage, "config.json"))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		cfgData = nil
+	case err != nil:
+		return nil, err
+	case cfgPath == "":
+		if cfgPath = m.Config; cfgPath == "" {
+			return nil, errors.New("set -config or MCP_CONFIG to say where the config goes")
+		}
+	}
+	env := os.Getenv("MCP_ENV")
+	if env == "" {
+		env = m.Profile
+	}
+	var cfg *Config
+	if cfgData != nil {
+		cfg, err = parseConfig(cfgData, cfgPath, env)
+	} else {
+		cfg, err = loadConfig(cfgPath, env)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recs, err := readBackupRecords(filepath.Join(stage, "store.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	paths := backupPaths(cfg)
+
+	var conflicts []string
+	if cfgData != nil {
+		if old, err := os.ReadFile(cfgPath); err == nil && string(old) != string(cfgData) {
+			conflicts = append(conflicts, cfgPath)
+		}
+	}
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("state store: %w", err)
+	}
+	if db == nil && len(recs) > 0 {
+		return nil, fmt.Errorf("the backup has %d records but the config has no state store", len(recs))
+	}
+	if db != nil {
+		defer db.Close()
+		errHas := errors.New("has records")
+		err := db.Each(context.Background(), func(*store.Record) error { return errHas })
+		if err == errHas {
+			conflicts = append(conflicts, "the state store")
+		} else if err != nil {
+			return nil, fmt.Errorf("state store: %w", err)
+		}
+	}
+	for kind, p := range paths {
+		if _, err := os.Lstat(filepath.Join(stage, "files", kind)); err != nil {
+			continue
+		}
+		if entries, err := os.ReadDir(p); err == nil && len(entries) > 0 {
+			conflicts = append(conflicts, p)
+		} else if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			conflicts = append(conflicts, p)
+		}
+	}
+	if len(conflicts) > 0 && !force {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("would overwrite %s; use -force to replace them", strings.Join(conflicts, ", "))
+	}
+
+	if cfgData != nil {
+		if err := os.MkdirAll(filepath.Dir(cfgPath), 0o755); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(cfgPath, cfgData); err != nil {
+			return nil, err
+		}
+	}
+	if db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := db.Replace(ctx, recs); err != nil {
+			return nil, fmt.Errorf("state store: %w", err)
+		}
+	}
+	m.Records = len(recs)
+	for kind := range m.Files {
+		if dst, ok := paths[kind]; !ok {
+			delete(m.Files, kind)
+		} else if err := copyTree(filepath.Join(stage, "files", kind), dst); err != nil {
+			return nil, err
+		}
+	}
+	if cfgData == nil {
+		m.Config = ""
+	}
+	return m, nil
+}
+
+// unpackBackup extracts archive name, "-" for stdin, into dir.
+func unpackBackup(name, pass, dir string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(sealMagic)); string(magic) == sealMagic {
+		if pass == "" {
+			return errors.New("the backup is encrypted: give -passphrase-file or set MCP_BACKUP_PASSPHRASE")
+		}
+		sr, err := newSealReader(br, pass)
+		if err != nil {
+			return err
+		}
+		r = sr
+	} else {
+		r = br
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("bad archive entry %q", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readBackupRecords(file string) ([]*store.Record, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []*store.Record
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		rec := &store.Record{}
+		if err := dec.Decode(rec); err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("store.jsonl: %w", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// copyTree copies the staged file or directory src to dst, keeping file
+// modes.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && file == src {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, fi.Mode().Perm())
+	})
+}
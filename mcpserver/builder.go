@@ -0,0 +1,422 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Builder assembles a server for embedding in other programs:
+//
+//	err := mcpserver.New().
+//		WithConfigFile(os.Getenv("MCP_CONFIG"), os.Getenv("MCP_ENV")).
+//		WithTool(tool, handler).
+//		WithTransport(mcpserver.TCP(":8080")).
+//		Start(ctx)
+//
+// Programs that enable exec hardening must call RunCommand first thing in
+// main, since hardened commands re-execute the program.
+type Builder struct {
+	path, env   string
+	doc         []byte // config given in code, as JSON
+	opts        options
+	tools       []customTool
+	subscribers []eventSubscriber
+	services    Services
+	transport   *Transport
+	err         error
+}
+
+// customTool is a tool registered in code rather than in the config.
+type customTool struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+// Transport is where Start listens.
+type Transport struct {
+	addr, socket string
+}
+
+// TCP listens on a TCP address such as ":8080".
+func TCP(addr string) Transport {
+	return Transport{addr: addr}
+}
+
+// UnixSocket listens on a Unix domain socket, with the mode and group of
+// the config's listen section.
+func UnixSocket(path string) Transport {
+	return Transport{socket: path}
+}
+
+// options are settings that override the config file, on start-up and on
+// every reload.
+type options struct {
+	readOnly bool
+	acme     []string
+	socket   string
+}
+
+func (o options) apply(cfg *Config) {
+	if o.readOnly {
+		cfg.Policy.ReadOnly = true
+	}
+	if len(o.acme) > 0 {
+		if cfg.TLS.ACME == nil {
+			cfg.TLS.ACME = &ACMEConfig{}
+		}
+		cfg.TLS.ACME.Domains = o.acme
+	}
+	if o.socket != "" {
+		cfg.Listen.Socket = o.socket
+	}
+}
+
+// New returns a builder for a server with the built-in tools and the
+// default config.
+func New() *Builder {
+	return &Builder{}
+}
+
+// WithConfigFile reads the config from path with profile env applied.
+// The file is read again on every reload.
+func (b *Builder) WithConfigFile(path, env string) *Builder {
+	b.path, b.env, b.doc = path, env, nil
+	return b
+}
+
+// WithConfig uses cfg instead of a config file. It is validated like a
+// file and reloads rebuild the server from it.
+func (b *Builder) WithConfig(cfg *Config) *Builder {
+	doc, err := json.Marshal(cfg)
+	if err != nil {
+		b.err = fmt.Errorf("invalid config: %w", err)
+	}
+	b.path, b.env, b.doc = "", cfg.Profile, doc
+	return b
+}
+
+// WithTool registers a tool implemented in Go. A tool defined in the
+// config with the same name replaces it.
+func (b *Builder) WithTool(tool Tool, handler ToolHandler) *Builder {
+	b.tools = append(b.tools, customTool{tool, handler})
+	return b
+}
+
+// OnEvent calls fn for every server event whose type matches one of
+// patterns, such as "tool.*", or for every event without patterns. fn runs
+// on the publishing goroutine and must return quickly.
+func (b *Builder) OnEvent(fn func(Event), patterns ...string) *Builder {
+	b.subscribers = append(b.subscribers, eventSubscriber{fn, patterns})
+	return b
+}
+
+// WithServices sets the services tool handlers find in their
+// ToolContext.
+func (b *Builder) WithServices(sv Services) *Builder {
+	b.services = sv
+	return b
+}
+
+// WithTransport sets where Start listens. The default is the config's
+// listen socket if set, otherwise TCP port $PORT (8080 if unset).
+func (b *Builder) WithTransport(t Transport) *Builder {
+	b.transport = &t
+	b.opts.socket = t.socket
+	return b
+}
+
+// WithReadOnly exposes only tools annotated readOnlyHint.
+func (b *Builder) WithReadOnly() *Builder {
+	b.opts.readOnly = true
+	return b
+}
+
+// WithACME serves HTTPS with ACME certificates for domains.
+func (b *Builder) WithACME(domains ...string) *Builder {
+	b.opts.acme = domains
+	return b
+}
+
+func (b *Builder) loadConfig() (*Config, error) {
+	if b.doc != nil {
+		return parseConfig(b.doc, "config", b.env)
+	}
+	return loadConfig(b.path, b.env)
+}
+
+// Start configures the server and serves until ctx is cancelled, then
+// shuts down gracefully. It returns nil after a shutdown.
+func (b *Builder) Start(ctx context.Context) error {
+	if b.err != nil {
+		return b.err
+	}
+	cfg, err := b.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config:\n%w", err)
+	}
+	b.opts.apply(cfg)
+	if err := applyRuntimeTuning(cfg.Memory); err != nil {
+		return fmt.Errorf("invalid memory config: %w", err)
+	}
+
+	server := NewMCPServer(cfg)
+	if server.store, err = openStore(cfg); err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	if server.store != nil {
+		defer server.store.Close()
+		if err := server.sessions.attach(server.store); err != nil {
+			return fmt.Errorf("failed to load sessions: %w", err)
+		}
+		if err := server.accounting.attach(server.store); err != nil {
+			return fmt.Errorf("failed to load quota counts: %w", err)
+		}
+		// Registered after Close, so the last counts are stored before it.
+		defer server.accounting.flush()
+		go server.accounting.flushLoop(ctx)
+	}
+	server.telemetry = newTelemetry(cfg.Telemetry, server.store)
+	if server.audit, err = newAuditExporter(cfg.Audit, server.metrics, server.store); err != nil {
+		return fmt.Errorf("invalid audit config: %w", err)
+	}
+	server.custom = b.tools
+	server.subscribers = b.subscribers
+	server.services = b.services.withDefaults()
+	if err := server.configure(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.MQTT.enabled() {
+		if server.mqtt, err = server.newMQTTBridge(); err != nil {
+			return fmt.Errorf("invalid mqtt config: %w", err)
+		}
+		go server.mqtt.run(ctx)
+	}
+	live := newLiveServer(server, b)
+	go live.reloadOnSignal(ctx)
+
+	if cfg.Usage.Path != "" {
+		if err := server.usage.load(cfg.Usage.Path); err != nil {
+			log.Printf("usage stats: load failed: %v", err)
+		}
+		go server.usage.persistLoop(ctx, cfg.Usage)
+	}
+
+	if diag := cfg.Diagnostics; diag.Addr != "" {
+		if err := checkLoopback(diag.Addr); err != nil {
+			return fmt.Errorf("invalid diagnostics config: %w", err)
+		}
+		go func() {
+			log.Printf("diagnostics listener stopped: %v", serveDiagnostics(ctx, diag))
+		}()
+	}
+
+	go server.memory.monitor(ctx)
+	go server.shedder.monitor(ctx)
+
+	server.sessions.removeStale()
+	go server.sessions.expireLoop(ctx, func(sess *Session) {
+		server.current().publish(EventSessionExpired, sessionEvent(sess))
+	})
+
+	if err := server.jobs.start(ctx, server.store); err != nil {
+		return fmt.Errorf("failed to start job queue: %w", err)
+	}
+	if server.audit != nil {
+		go server.audit.run(ctx)
+		defer server.audit.wait(ctx)
+	}
+	go server.retentionLoop(ctx)
+	if server.telemetry != nil {
+		go server.telemetry.loop(ctx, server.current)
+	}
+
+	if remote := cfg.Features.Remote; remote != nil {
+		go server.flags.pollRemote(ctx, remote)
+	}
+
+	return b.serve(ctx, cfg, live, cfg.listeners(b.addr(cfg)))
+}
+
+// addr is the socket path or TCP address to listen on.
+func (b *Builder) addr(cfg *Config) string {
+	if cfg.Listen.Socket != "" {
+		return cfg.Listen.Socket
+	}
+	if b.transport != nil && b.transport.addr != "" {
+		return b.transport.addr
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return ":" + port
+}
+
+// RunCommand runs the subcommand named by args, the program's arguments
+// without its name, and reports whether there was one.
+func RunCommand(args []string) (code int, ok bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case hardenArg:
+		return runHardened(args[1:]), true
+	case "init":
+		return runInitCommand(args[1:]), true
+	case "config":
+		return runConfigCommand(args[1:]), true
+	case "generate":
+		return runGenerateCommand(args[1:]), true
+	case "loadtest":
+		return runLoadTestCommand(args[1:]), true
+	case "doctor":
+		return runDoctorCommand(args[1:]), true
+	case "repl":
+		return runREPLCommand(args[1:]), true
+	case "top":
+		return runTopCommand(args[1:]), true
+	case "migrate":
+		return runMigrateCommand(args[1:]), true
+	case "rekey":
+		return runRekeyCommand(args[1:]), true
+	case "version":
+		return runVersionCommand(args[1:]), true
+	case "update":
+		return runUpdateCommand(args[1:]), true
+	case "backup":
+		return runBackupCommand(args[1:]), true
+	case "restore":
+		return runRestoreCommand(args[1:]), true
+	}
+	return 0, false
+}
+
+// handler builds the public HTTP handler of listener lc.
+func (l *liveServer) handler(cfg *Config, lc ListenerConfig) (http.Handler, error) {
+	server := l.server()
+
+	// Public routes get their own mux so nothing registered on
+	// http.DefaultServeMux (such as net/http/pprof) is exposed.
+	mux := http.NewServeMux()
+
+	// Root handler
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message": "Go MCP Server Running!",
+			"version": serverVersion,
+			"endpoints": map[string]string{
+				"health": cfg.Proxy.basePath() + "/health",
+				"mcp":    cfg.Proxy.basePath() + "/mcp",
+			},
+			"timestamp": time.Now().UTC(),
+			"uptime":    int64(uptime().Seconds()),
+			"startedAt": processStart.UTC(),
+		})
+	})
+
+	// Health endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":    "healthy",
+			"server":    "Go MCP Server",
+			"version":   serverVersion,
+			"uptime":    int64(uptime().Seconds()),
+			"startedAt": processStart.UTC(),
+		})
+	})
+
+	// MCP endpoint
+	mux.HandleFunc("/mcp", l.handle((*MCPServer).handleMCP))
+
+	// Admin API
+	mux.HandleFunc("/admin/", l.handle((*MCPServer).handleAdmin))
+
+	// Prometheus metrics
+	mux.Handle("/metrics", server.metrics)
+
+	if cfg.Dashboard.Enabled {
+		mux.HandleFunc("/dashboard", l.handle((*MCPServer).handleDashboard))
+	}
+
+	var handler http.Handler = mux
+	if lc.requiresTLS(cfg.Policy) {
+		handler = requireTLS(mux)
+	}
+	handler = lc.restrictListener(handler)
+	handler = l.secureHTTP(handler)
+	handler = l.restrictInbound(handler)
+	handler = recoverPanics(server.metrics, handler)
+	handler, err := cfg.Proxy.wrap(handler)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy config: %w", err)
+	}
+	return handler, nil
+}
+
+// printBanner prints the server's endpoints, as reached through the
+// first listener, and the other listeners.
+func printBanner(cfg *Config, server *MCPServer, listeners []ListenerConfig) {
+	first := listeners[0]
+	_, port, _ := net.SplitHostPort(first.Addr)
+	host := net.JoinHostPort("localhost", port)
+	base := cfg.Proxy.basePath()
+	if first.Socket != "" {
+		host = "localhost"
+	} else if acme := cfg.TLS.ACME; acme != nil && first.TLS {
+		host = acme.Domains[0]
+		if port != "443" {
+			host += ":" + port
+		}
+	}
+
+	scheme := "http"
+	if first.TLS {
+		scheme = "https"
+	}
+	if first.Socket != "" {
+		fmt.Printf("🚀 Go MCP Server starting on socket %s\n", first.Socket)
+	} else {
+		fmt.Printf("🚀 Go MCP Server starting on port %s\n", port)
+	}
+	for _, lc := range listeners[1:] {
+		kind := "http"
+		switch {
+		case lc.Socket != "":
+			kind = "socket"
+		case lc.TLS:
+			kind = "https"
+		}
+		fmt.Printf("🔌 Also listening on %s (%s)\n", lc.name(), kind)
+	}
+	if cfg.Profile != "" {
+		fmt.Printf("🧭 Profile: %s\n", cfg.Profile)
+	}
+	fmt.Printf("📡 MCP endpoint: %s://%s%s/mcp\n", scheme, host, base)
+	fmt.Printf("💓 Health check: %s://%s%s/health\n", scheme, host, base)
+	fmt.Printf("🏠 Root endpoint: %s://%s%s/\n", scheme, host, base)
+	if cfg.Policy.ReadOnly {
+		fmt.Printf("🔒 Read-only: %d tools hidden\n", len(server.writableTools()))
+	}
+	if cfg.Chaos.Enabled {
+		fmt.Println("🌪️  Chaos mode: injecting faults into tool calls and responses")
+	}
+	if cfg.Dashboard.Enabled {
+		fmt.Printf("📊 Dashboard: %s://%s%s/dashboard\n", scheme, host, base)
+	}
+
+	if addr := cfg.Diagnostics.Addr; addr != "" {
+		fmt.Printf("🩺 Diagnostics: http://%s/debug/pprof/\n", addr)
+	}
+}
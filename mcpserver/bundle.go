@@ -0,0 +1,307 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const bundleVersion = 1
+
+// maxBundleBytes bounds an imported bundle.
+const maxBundleBytes = 8 << 20
+
+// Bundle is a portable snapshot of a deployment's setup: its declarative
+// tools, deprecations and aliases, exposure rules and feature flags,
+// including flags overridden through the admin API. Auth, TLS and other
+// environment-specific settings are not part of it.
+type Bundle struct {
+	Version       int                          `json:"version"`
+	ExportedAt    time.Time                    `json:"exportedAt"`
+	Profile       string                       `json:"profile,omitempty"`
+	Tools         []ToolConfig                 `json:"tools"`
+	Deprecations  map[string]DeprecationConfig `json:"deprecations,omitempty"`
+	Aliases       map[string]string            `json:"aliases,omitempty"`
+	Exposure      []ExposureRule               `json:"exposure,omitempty"`
+	Flags         map[string]FlagConfig        `json:"flags,omitempty"`
+	FlagOverrides map[string]FlagConfig        `json:"flagOverrides,omitempty"`
+}
+
+// BundleImport reports what an import changed, or would change.
+type BundleImport struct {
+	DryRun        bool          `json:"dryRun"`
+	Added         []string      `json:"added,omitempty"`
+	Replaced      []string      `json:"replaced,omitempty"`
+	Removed       []string      `json:"removed,omitempty"`
+	FlagOverrides int           `json:"flagOverrides"`
+	Status        *ReloadStatus `json:"status,omitempty"`
+}
+
+func (s *MCPServer) exportBundle() Bundle {
+	b := Bundle{
+		Version:       bundleVersion,
+		ExportedAt:    time.Now().UTC(),
+		Profile:       s.cfg.Profile,
+		Tools:         s.cfg.Tools,
+		Deprecations:  s.cfg.Deprecations,
+		Aliases:       s.cfg.Aliases,
+		Exposure:      s.cfg.Exposure,
+		Flags:         s.cfg.Features.Flags,
+		FlagOverrides: s.flags.overridden(),
+	}
+	if b.Tools == nil {
+		b.Tools = []ToolConfig{}
+	}
+	return b
+}
+
+// importBundle writes b into the config file and reloads it. In merge
+// mode tools replace those of the same name and map entries are merged;
+// in replace mode the bundle's sections replace the file's. Exposure
+// rules always replace the file's when the bundle has any. Nothing is
+// written unless the resulting config builds.
+func (l *liveServer) importBundle(b Bundle, replace, dryRun bool) (*BundleImport, error) {
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if l.path == "" {
+		return nil, fmt.Errorf("import needs a config file (MCP_CONFIG)")
+	}
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	original, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(original))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", l.path, err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	report := &BundleImport{DryRun: dryRun, FlagOverrides: len(b.FlagOverrides)}
+	doc["tools"] = mergeTools(doc["tools"], b.Tools, replace, report)
+	mergeSection(doc, "deprecations", b.Deprecations, replace)
+	mergeSection(doc, "aliases", b.Aliases, replace)
+	if len(b.Exposure) > 0 {
+		doc["exposure"] = toDoc(b.Exposure)
+	}
+	features, _ := doc["features"].(map[string]interface{})
+	if features == nil {
+		features = map[string]interface{}{}
+	}
+	mergeSection(features, "flags", b.Flags, replace)
+	if len(features) > 0 {
+		doc["features"] = features
+	}
+
+	// Templates often contain <, > and &; keep them readable.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	cfg, err := parseConfig(data, l.path, l.env)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := l.buildFrom(l.server(), cfg); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	if err := writeFileAtomic(l.path, data); err != nil {
+		return nil, err
+	}
+	if err := l.reloadLocked(); err != nil {
+		return nil, err
+	}
+	flags := l.server().flags
+	for name, fc := range b.FlagOverrides {
+		flags.setOverride(name, fc)
+	}
+	l.mu.RLock()
+	status := l.status
+	l.mu.RUnlock()
+	report.Status = &status
+	return report, nil
+}
+
+// mergeTools merges the bundle's tools into the document's tools array.
+func mergeTools(existing interface{}, tools []ToolConfig, replace bool, report *BundleImport) []interface{} {
+	current, _ := existing.([]interface{})
+	index := map[string]int{}
+	for i, t := range current {
+		if m, ok := t.(map[string]interface{}); ok {
+			if name, ok := m["name"].(string); ok {
+				index[name] = i
+			}
+		}
+	}
+	incoming := map[string]bool{}
+	var out []interface{}
+	if !replace {
+		out = append(out, current...)
+	}
+	for _, t := range tools {
+		incoming[t.Name] = true
+		i, exists := index[t.Name]
+		switch {
+		case exists:
+			report.Replaced = append(report.Replaced, t.Name)
+		default:
+			report.Added = append(report.Added, t.Name)
+		}
+		if exists && !replace {
+			out[i] = toDoc(t)
+		} else {
+			out = append(out, toDoc(t))
+		}
+	}
+	if replace {
+		for name := range index {
+			if !incoming[name] {
+				report.Removed = append(report.Removed, name)
+			}
+		}
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Replaced)
+	sort.Strings(report.Removed)
+	if out == nil {
+		out = []interface{}{}
+	}
+	return out
+}
+
+// mergeSection merges the map v into doc[key], or replaces it.
+func mergeSection(doc map[string]interface{}, key string, v interface{}, replace bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Len() == 0 && !replace {
+		return
+	}
+	incoming, _ := toDoc(v).(map[string]interface{})
+	if replace {
+		if rv.Len() == 0 {
+			delete(doc, key)
+		} else {
+			doc[key] = incoming
+		}
+		return
+	}
+	merged, _ := doc[key].(map[string]interface{})
+	if merged == nil {
+		merged = map[string]interface{}{}
+	}
+	for k, val := range incoming {
+		merged[k] = val
+	}
+	doc[key] = merged
+}
+
+// toDoc converts v to its generic JSON form.
+func toDoc(v interface{}) interface{} {
+	data, _ := json.Marshal(v)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	dec.Decode(&out)
+	return out
+}
+
+// writeFileAtomic replaces path with data, keeping its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileVia(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileVia writes a file through a temporary file in its directory,
+// renamed into place once fn succeeds, so a failure leaves no partial
+// file behind. A replaced file keeps its permissions; a new one gets 0644.
+func writeFileVia(name string, fn func(io.Writer) error) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if err := fn(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// handleAdminBundle serves GET /admin/bundle (export) and POST
+// /admin/bundle (import; ?mode=replace, ?dryRun=true).
+func (s *MCPServer) handleAdminBundle(w http.ResponseWriter, r *http.Request, rest string) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Disposition", `attachment; filename="mcp-bundle.json"`)
+		writeJSON(w, http.StatusOK, s.exportBundle())
+	case http.MethodPost:
+		if s.live == nil {
+			http.Error(w, "Import not available", http.StatusNotFound)
+			return
+		}
+		var b Bundle
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleBytes)).Decode(&b); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		q := r.URL.Query()
+		mode := q.Get("mode")
+		if mode != "" && mode != "merge" && mode != "replace" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "mode must be merge or replace"})
+			return
+		}
+		report, err := s.live.importBundle(b, mode == "replace", q.Get("dryRun") == "true")
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{
+				"error": fmt.Sprintf("import failed, configuration unchanged: %v", err),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,69 @@
+package mcpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileVia(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "sub", "out.txt")
+	if err := writeFileAtomic(name, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Fatalf("new file: %v, %v", fi, err)
+	}
+	if err := os.Chmod(name, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A failed write leaves the old file and no temporary one.
+	err := writeFileVia(name, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("boom")
+	})
+	if err == nil {
+		t.Fatal("failed write succeeded")
+	}
+	if data, _ := os.ReadFile(name); string(data) != "one" {
+		t.Errorf("after failed write: %q", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(name)); len(entries) != 1 {
+		t.Errorf("left behind %v", entries)
+	}
+	if err := writeFileAtomic(name, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(name); fi.Mode().Perm() != 0o600 {
+		t.Errorf("replaced file mode %v", fi.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(name); string(data) != "two" {
+		t.Errorf("after replace: %q", data)
+	}
+}
+
+func TestBundleImportSize(t *testing.T) {
+	s, err := newConfiguredServer(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLiveServer(s, &Builder{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { s.handleAdminBundle(w, r, "") })
+
+	big := `{"version":1,"tools":[` + strings.Repeat(" ", maxBundleBytes) + `]}`
+	w := serve(h, testRequest(http.MethodPost, "/admin/bundle", "127.0.0.1:1234", big))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "too large") {
+		t.Errorf("oversized bundle: %d %s", w.Code, w.Body)
+	}
+
+	// A small one gets past decoding; this server has no file to import into.
+	w = serve(h, testRequest(http.MethodPost, "/admin/bundle?dryRun=true", "127.0.0.1:1234", `{"version":1}`))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "config file") {
+		t.Errorf("small bundle: %d %s", w.Code, w.Body)
+	}
+}
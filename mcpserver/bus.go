@@ -0,0 +1,173 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event types published on the bus.
+const (
+	EventServerStarted      = "server.started"
+	EventToolCompleted      = "tool.completed"
+	EventToolFailed         = "tool.failed"
+	EventAuthFailed         = "auth.failed"
+	EventConfigReloaded     = "config.reloaded"
+	EventConfigReloadFailed = "config.reload_failed"
+	EventSessionCreated     = "session.created"
+	EventSessionClosed      = "session.closed"
+	EventSessionExpired     = "session.expired"
+	EventFlagsChanged       = "flags.changed"
+	EventToolsChanged       = "tools.changed"
+	EventDataPurged         = "data.purged"
+)
+
+// Event is something that happened in the server. Data holds only
+// JSON-friendly values, since webhooks send events as they are. Arguments
+// of tool events are left out of the JSON form and only exported by the
+// audit exporter when asked to.
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Time      time.Time              `json:"time"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Arguments json.RawMessage        `json:"-"`
+}
+
+// eventBus is the server's internal publish/subscribe channel. The
+// dispatch code publishes what happened and usage, metrics, notifications,
+// webhooks, the audit exporter and embedders' handlers subscribe, so none
+// of them is called from the request path directly. Delivery is synchronous, in subscription
+// order: subscribers must return quickly and move slow work to a
+// goroutine. A panicking subscriber is recovered without affecting the
+// others. Each server generation has its own bus, so a reload replaces
+// the subscriptions along with the config.
+type eventBus struct {
+	metrics *metricsRegistry
+	mu      sync.RWMutex
+	subs    []*subscription
+}
+
+// subscription receives events whose type matches one of patterns, or
+// every event without patterns.
+type subscription struct {
+	name     string
+	patterns []string
+	fn       func(Event)
+}
+
+func newEventBus(metrics *metricsRegistry) *eventBus {
+	return &eventBus{metrics: metrics}
+}
+
+// subscribe adds fn for events matching patterns and returns a function
+// that removes it.
+func (b *eventBus) subscribe(name string, fn func(Event), patterns ...string) func() {
+	sub := &subscription{name: name, patterns: patterns, fn: fn}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s == sub {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (b *eventBus) publish(ev Event) {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		if len(sub.patterns) == 0 || matchAny(sub.patterns, ev.Type) {
+			b.deliver(sub, ev)
+		}
+	}
+}
+
+func (b *eventBus) deliver(sub *subscription, ev Event) {
+	defer func() {
+		if p := recover(); p != nil {
+			recovered(b.metrics, "event subscriber "+sub.name, p)
+		}
+	}()
+	sub.fn(ev)
+}
+
+// publish announces an event on the server's bus.
+func (s *MCPServer) publish(eventType string, data map[string]interface{}) {
+	s.publishEvent(Event{Type: eventType, Data: data})
+}
+
+// publishEvent fills in the ID and time of ev and publishes it.
+func (s *MCPServer) publishEvent(ev Event) {
+	if s.bus == nil {
+		return
+	}
+	ev.ID, ev.Time = randomID(), time.Now().UTC()
+	s.bus.publish(ev)
+}
+
+// eventSubscriber is a handler registered with Builder.OnEvent.
+type eventSubscriber struct {
+	fn       func(Event)
+	patterns []string
+}
+
+// setupEvents creates the bus and subscribes the server's subsystems.
+func (s *MCPServer) setupEvents() {
+	s.bus = newEventBus(s.metrics)
+	s.bus.subscribe("usage", s.recordUsage, EventToolCompleted, EventToolFailed)
+	s.bus.subscribe("metrics", s.recordToolMetrics, EventToolCompleted, EventToolFailed)
+	s.bus.subscribe("clients", s.recordClient, EventToolCompleted, EventToolFailed)
+	s.bus.subscribe("notifications", func(ev Event) {
+		// Flags matter to tool lists only through exposure rules.
+		if flag, _ := ev.Data["flag"].(string); ev.Type == EventFlagsChanged && !s.exposureFlag(flag) {
+			return
+		}
+		s.notifier.broadcast("notifications/tools/list_changed", nil)
+	}, EventToolsChanged, EventFlagsChanged)
+	if len(s.webhooks) > 0 {
+		s.bus.subscribe("webhooks", s.deliverWebhooks)
+	}
+	if s.audit != nil {
+		s.bus.subscribe("audit", s.audit.record)
+	}
+	for _, sub := range s.subscribers {
+		s.bus.subscribe("embedder", sub.fn, sub.patterns...)
+	}
+}
+
+// toolEventDuration is the duration carried by a tool event.
+func toolEventDuration(ev Event) time.Duration {
+	ms, _ := ev.Data["durationMs"].(float64)
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (s *MCPServer) recordUsage(ev Event) {
+	tool, _ := ev.Data["tool"].(string)
+	failed := ev.Type == EventToolFailed
+	s.usage.record(tool, toolEventDuration(ev), failed)
+	if failed {
+		e := ToolError{Time: ev.Time, Tool: tool}
+		e.Client, _ = ev.Data["client"].(string)
+		e.Key, _ = ev.Data["key"].(string)
+		e.Message, _ = ev.Data["error"].(string)
+		s.usage.recordError(e)
+	}
+}
+
+func (s *MCPServer) recordToolMetrics(ev Event) {
+	tool, _ := ev.Data["tool"].(string)
+	s.metrics.observe(toolDurationMetric, toolEventDuration(ev).Seconds(), "tool", tool)
+	outcome := "ok"
+	if ev.Type == EventToolFailed {
+		outcome = "error"
+	}
+	s.metrics.inc(toolCallsMetric, "tool", tool, "outcome", outcome)
+}
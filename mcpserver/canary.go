@@ -0,0 +1,255 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CanaryConfig runs a new version of a declarative tool's backend next to
+// the current one. Percent of calls go to the canary; once MinCalls canary
+// calls have been made, the canary is rolled back automatically whenever
+// more than MaxErrorRate of its last MinCalls calls failed. Promote a
+// canary by making its backend the tool's backend.
+type CanaryConfig struct {
+	Version      string        `json:"version,omitempty"`
+	Backend      BackendConfig `json:"backend" schema:"required"`
+	Percent      float64       `json:"percent" schema:"minimum=0"`
+	MaxErrorRate float64       `json:"maxErrorRate,omitempty" schema:"minimum=0"` // default 0.1
+	MinCalls     int           `json:"minCalls,omitempty" schema:"minimum=1"`     // default 20
+}
+
+const (
+	defaultCanaryMaxErrorRate = 0.1
+	defaultCanaryMinCalls     = 20
+
+	canaryCallsMetric     = "mcp_canary_calls_total"
+	canaryRollbacksMetric = "mcp_canary_rollbacks_total"
+)
+
+// canaryStore keeps canary state across reloads, so a rolled-back canary
+// stays rolled back until its definition changes or it is reset.
+type canaryStore struct {
+	mu     sync.Mutex
+	states map[string]*canaryState // by tool name
+}
+
+// canaryState tracks one tool's canary.
+type canaryState struct {
+	mu          sync.Mutex
+	tool        string
+	fingerprint string
+	cfg         CanaryConfig
+	window      []bool // last MinCalls canary outcomes, true = failed
+	next        int
+	filled      int
+
+	stableCalls, stableErrors int64
+	canaryCalls, canaryErrors int64
+	rolledBack                bool
+	rolledBackAt              time.Time
+	rollbackReason            string
+}
+
+func newCanaryStore() *canaryStore {
+	return &canaryStore{states: map[string]*canaryState{}}
+}
+
+// state returns the state of tool's canary, starting afresh when the
+// canary definition changed.
+func (cs *canaryStore) state(tool string, cfg CanaryConfig) *canaryState {
+	if cfg.MaxErrorRate == 0 {
+		cfg.MaxErrorRate = defaultCanaryMaxErrorRate
+	}
+	if cfg.MinCalls == 0 {
+		cfg.MinCalls = defaultCanaryMinCalls
+	}
+	raw, _ := json.Marshal(cfg)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if st, ok := cs.states[tool]; ok && st.fingerprint == string(raw) {
+		return st
+	}
+	st := &canaryState{tool: tool, fingerprint: string(raw), cfg: cfg, window: make([]bool, cfg.MinCalls)}
+	cs.states[tool] = st
+	return st
+}
+
+func (cs *canaryStore) get(tool string) (*canaryState, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	st, ok := cs.states[tool]
+	return st, ok
+}
+
+// pick reports whether the next call goes to the canary.
+func (st *canaryState) pick() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return !st.rolledBack && rand.Float64()*100 < st.cfg.Percent
+}
+
+// record counts a call and reports whether it triggered a rollback.
+func (st *canaryState) record(canary, failed bool) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !canary {
+		st.stableCalls++
+		if failed {
+			st.stableErrors++
+		}
+		return false
+	}
+	st.canaryCalls++
+	if failed {
+		st.canaryErrors++
+	}
+	st.window[st.next] = failed
+	st.next = (st.next + 1) % len(st.window)
+	if st.filled < len(st.window) {
+		st.filled++
+	}
+	if st.rolledBack || st.filled < len(st.window) {
+		return false
+	}
+	failures := 0
+	for _, f := range st.window {
+		if f {
+			failures++
+		}
+	}
+	rate := float64(failures) / float64(len(st.window))
+	if rate <= st.cfg.MaxErrorRate {
+		return false
+	}
+	st.rollback(fmt.Sprintf("error rate %.0f%% over the last %d calls exceeds %.0f%%",
+		rate*100, len(st.window), st.cfg.MaxErrorRate*100))
+	return true
+}
+
+func (st *canaryState) rollback(reason string) {
+	st.rolledBack, st.rolledBackAt, st.rollbackReason = true, time.Now().UTC(), reason
+}
+
+// reset resumes a rolled-back canary with a clean window.
+func (st *canaryState) reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rolledBack, st.rolledBackAt, st.rollbackReason = false, time.Time{}, ""
+	st.window = make([]bool, len(st.window))
+	st.next, st.filled = 0, 0
+}
+
+func (st *canaryState) snapshot() map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := map[string]interface{}{
+		"tool":         st.tool,
+		"version":      st.cfg.Version,
+		"percent":      st.cfg.Percent,
+		"maxErrorRate": st.cfg.MaxErrorRate,
+		"minCalls":     st.cfg.MinCalls,
+		"stableCalls":  st.stableCalls,
+		"stableErrors": st.stableErrors,
+		"canaryCalls":  st.canaryCalls,
+		"canaryErrors": st.canaryErrors,
+		"rolledBack":   st.rolledBack,
+	}
+	if st.rolledBack {
+		out["rolledBackAt"] = st.rolledBackAt
+		out["rollbackReason"] = st.rollbackReason
+	}
+	return out
+}
+
+// canaryHandler splits calls between stable and canary.
+func (s *MCPServer) canaryHandler(tool string, cfg CanaryConfig, stable, canary ToolHandler) ToolHandler {
+	st := s.canaries.state(tool, cfg)
+	version := cfg.Version
+	if version == "" {
+		version = "canary"
+	}
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		if ToolContextFrom(ctx).DryRun {
+			return stable(ctx, args)
+		}
+		useCanary := st.pick()
+		handler, label := stable, "stable"
+		if useCanary {
+			handler, label = canary, version
+		}
+		result, err := handler(ctx, args)
+		failed := err != nil || isErrorResult(result)
+		outcome := "ok"
+		if failed {
+			outcome = "error"
+		}
+		s.metrics.inc(canaryCallsMetric, "tool", tool, "version", label, "outcome", outcome)
+		if st.record(useCanary, failed) {
+			s.metrics.inc(canaryRollbacksMetric, "tool", tool)
+			log.Printf("canary %s of tool %q rolled back: %s", version, tool, st.snapshot()["rollbackReason"])
+		}
+		return result, err
+	}
+}
+
+// isErrorResult reports whether a tool result is flagged isError.
+func isErrorResult(result interface{}) bool {
+	m, ok := result.(map[string]interface{})
+	return ok && m["isError"] == true
+}
+
+// handleAdminCanaries serves GET /admin/canaries and POST
+// /admin/canaries/{tool}/reset or /rollback.
+func (s *MCPServer) handleAdminCanaries(w http.ResponseWriter, r *http.Request, rest string) {
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var names []string
+		for _, tc := range s.cfg.Tools {
+			if tc.Canary != nil {
+				names = append(names, tc.Name)
+			}
+		}
+		sort.Strings(names)
+		out := []map[string]interface{}{}
+		for _, name := range names {
+			if st, ok := s.canaries.get(name); ok {
+				out = append(out, st.snapshot())
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"canaries": out})
+		return
+	}
+	tool, action, _ := strings.Cut(rest, "/")
+	st, ok := s.canaries.get(tool)
+	if !ok {
+		http.Error(w, "No canary for tool", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch action {
+	case "reset":
+		st.reset()
+	case "rollback":
+		st.mu.Lock()
+		st.rollback("rolled back through the admin API")
+		st.mu.Unlock()
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, st.snapshot())
+}
@@ -0,0 +1,87 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+)
+
+// publicCapabilities is the part of the capability report safe to show
+// before authentication.
+func (s *MCPServer) publicCapabilities() map[string]interface{} {
+	auth := "none"
+	if s.cfg.Auth.enabled() {
+		auth = "apiKey"
+	}
+	locales := make([]string, 0, len(s.i18n.tags))
+	for _, tag := range s.i18n.tags {
+		locales = append(locales, tag.String())
+	}
+	transports := []string{"http", "sse"}
+	if s.cfg.TLS.enabled() {
+		transports = []string{"https", "sse"}
+	}
+	return map[string]interface{}{
+		"profile":    s.cfg.Profile,
+		"readOnly":   s.cfg.Policy.ReadOnly,
+		"transports": transports,
+		"auth":       auth,
+		"locales":    locales,
+	}
+}
+
+// capabilities reports what this deployment supports as seen by caller:
+// only tools it may call, flags evaluated for its tenant and its own
+// quota.
+func (s *MCPServer) capabilities(caller *Caller) map[string]interface{} {
+	out := s.publicCapabilities()
+
+	backends := map[string]string{}
+	for _, tc := range s.cfg.Tools {
+		backends[tc.Name] = tc.Backend.Type
+	}
+	packages := map[string][]string{}
+	for _, name := range s.toolNames() {
+		if !s.toolVisible(name, caller) {
+			continue
+		}
+		pkg := "builtin"
+		if typ, ok := backends[name]; ok {
+			pkg = "declarative:" + typ
+		}
+		packages[pkg] = append(packages[pkg], name)
+	}
+	for _, names := range packages {
+		sort.Strings(names)
+	}
+	out["toolPackages"] = packages
+
+	limits := map[string]interface{}{
+		"asyncWorkers":   s.jobs.cfg.Workers,
+		"asyncQueueSize": s.jobs.cfg.QueueSize,
+	}
+	if s.cfg.Memory.MaxInFlightBytes > 0 {
+		limits["maxInFlightBytes"] = s.cfg.Memory.MaxInFlightBytes
+	}
+	if caller != nil && caller.Key != nil {
+		limits["quota"] = caller.Key.Quota
+	}
+	out["limits"] = limits
+
+	flags := map[string]bool{}
+	for _, f := range s.flags.snapshot() {
+		flags[f.Name] = s.flags.enabled(f.Name, caller.KeyName())
+	}
+	out["featureFlags"] = flags
+	return out
+}
+
+// serverCapabilitiesTool reports the calling client's view of the
+// deployment.
+func (s *MCPServer) serverCapabilitiesTool(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	out, err := json.MarshalIndent(s.capabilities(callerFrom(ctx)), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return textResult(string(out)), nil
+}
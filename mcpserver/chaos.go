@@ -0,0 +1,141 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// ChaosConfig injects faults so client authors can check their retry and
+// error handling against the server; never enable it in production. Tool
+// calls matching Tools (all when empty) are delayed by Latency plus up to
+// Jitter, fail with ErrorRate and have their text cut short with
+// TruncateRate. POST /mcp requests are refused with 503 at HTTPErrorRate,
+// and DropRate of responses break off halfway with the connection closed.
+// Rates are probabilities between 0 and 1.
+type ChaosConfig struct {
+	Enabled       bool     `json:"enabled,omitempty"`
+	Tools         []string `json:"tools,omitempty"`
+	Latency       Duration `json:"latency,omitempty" schema:"format=duration"`
+	Jitter        Duration `json:"jitter,omitempty" schema:"format=duration"`
+	ErrorRate     float64  `json:"errorRate,omitempty" schema:"minimum=0,maximum=1"`
+	TruncateRate  float64  `json:"truncateRate,omitempty" schema:"minimum=0,maximum=1"`
+	HTTPErrorRate float64  `json:"httpErrorRate,omitempty" schema:"minimum=0,maximum=1"`
+	DropRate      float64  `json:"dropRate,omitempty" schema:"minimum=0,maximum=1"`
+}
+
+const chaosFaultsMetric = "mcp_chaos_faults_total"
+
+var errChaos = errors.New("chaos: injected failure")
+
+func (c ChaosConfig) check() error {
+	for _, rate := range []float64{c.ErrorRate, c.TruncateRate, c.HTTPErrorRate, c.DropRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("rates must be between 0 and 1")
+		}
+	}
+	if c.Latency < 0 || c.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	return nil
+}
+
+// injectChaos wraps the handlers of the tools chaos applies to.
+func (s *MCPServer) injectChaos() {
+	cfg := s.cfg.Chaos
+	if !cfg.Enabled {
+		return
+	}
+	for name := range s.handlers {
+		if len(cfg.Tools) == 0 || matchAny(cfg.Tools, name) {
+			s.handlers[name] = s.chaosHandler(name, s.handlers[name])
+		}
+	}
+}
+
+func (s *MCPServer) chaosHandler(tool string, next ToolHandler) ToolHandler {
+	cfg := s.cfg.Chaos
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		delay := time.Duration(cfg.Latency)
+		if cfg.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(cfg.Jitter)))
+		}
+		if delay > 0 {
+			s.metrics.inc(chaosFaultsMetric, "kind", "latency")
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		if chance(cfg.ErrorRate) {
+			s.metrics.inc(chaosFaultsMetric, "kind", "error")
+			return nil, errChaos
+		}
+		result, err := next(ctx, args)
+		if err == nil && chance(cfg.TruncateRate) {
+			s.metrics.inc(chaosFaultsMetric, "kind", "truncate")
+			result = truncateResult(result)
+		}
+		return result, err
+	}
+}
+
+// truncateResult cuts every text item of result to half its length.
+func truncateResult(result interface{}) interface{} {
+	decoded := decodeResult(result)
+	content, _ := decoded["content"].([]interface{})
+	for _, item := range content {
+		if m, ok := item.(map[string]interface{}); ok {
+			if text, ok := m["text"].(string); ok {
+				runes := []rune(text)
+				m["text"] = string(runes[:len(runes)/2])
+			}
+		}
+	}
+	return decoded
+}
+
+// chaosTransport applies the transport faults to a POST /mcp request. It
+// returns the writer to respond with, or false when the request was
+// already answered.
+func (s *MCPServer) chaosTransport(w http.ResponseWriter) (http.ResponseWriter, bool) {
+	cfg := s.cfg.Chaos
+	if !cfg.Enabled {
+		return w, true
+	}
+	if chance(cfg.HTTPErrorRate) {
+		s.metrics.inc(chaosFaultsMetric, "kind", "http_error")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, errChaos.Error(), http.StatusServiceUnavailable)
+		return w, false
+	}
+	if chance(cfg.DropRate) {
+		s.metrics.inc(chaosFaultsMetric, "kind", "drop")
+		return &droppingWriter{ResponseWriter: w}, true
+	}
+	return w, true
+}
+
+// droppingWriter sends the first half of the response body and then aborts
+// the connection.
+type droppingWriter struct {
+	http.ResponseWriter
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	w.ResponseWriter.Write(p[:len(p)/2])
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func chance(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,284 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksumBufferSize is the read size of checksum_file: large enough to
+// keep the disk streaming, small enough to notice a cancellation quickly.
+const checksumBufferSize = 1 << 20
+
+// checksumAlgorithms are the hashes checksum_file and verify_checksum
+// compute, by name.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+	"blake2b-256": func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+}
+
+type checksumFileArgs struct {
+	Root       string   `json:"root,omitempty" jsonschema:"description=Workspace root of path; may be left out when there is only one"`
+	Path       string   `json:"path" jsonschema:"required,description=Root-relative file to hash"`
+	Algorithms []string `json:"algorithms,omitempty" jsonschema:"description=Hashes to compute in one pass: md5\\, sha1\\, sha256\\, sha384\\, sha512 or blake2b-256 (default sha256)"`
+}
+
+type verifyChecksumArgs struct {
+	Root         string `json:"root,omitempty" jsonschema:"description=Workspace root of path and checksumFile; may be left out when there is only one"`
+	Path         string `json:"path" jsonschema:"required,description=Root-relative file to verify"`
+	Expected     string `json:"expected,omitempty" jsonschema:"description=Expected hex digest\\, optionally prefixed like sha256:"`
+	Algorithm    string `json:"algorithm,omitempty" jsonschema:"enum=md5|sha1|sha256|sha384|sha512|blake2b-256,description=Hash of expected; guessed from its prefix or length when left out"`
+	ChecksumFile string `json:"checksumFile,omitempty" jsonschema:"description=Root-relative checksum list in sha256sum format (such as SHA256SUMS) to look path up in\\, instead of expected"`
+}
+
+func (s *MCPServer) setupChecksumTools() {
+	sum, sumHandler, _ := typedTool("checksum_file", "Compute the checksums of a workspace file of any size, streaming it once for all requested hashes with progress updates", s.checksumFileTool)
+	sum.Annotations = readOnlyAnnotations()
+	s.addTool(sum, sumHandler)
+
+	verify, verifyHandler, _ := typedTool("verify_checksum", "Check a workspace file against an expected checksum or a SHA256SUMS-style list, streaming it with progress updates", s.verifyChecksumTool)
+	verify.Annotations = readOnlyAnnotations()
+	s.addTool(verify, verifyHandler)
+}
+
+func (s *MCPServer) checksumFileTool(ctx context.Context, args checksumFileArgs) (interface{}, error) {
+	algorithms := args.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha256"}
+	}
+	for _, name := range algorithms {
+		if checksumAlgorithms[name] == nil {
+			return nil, fmt.Errorf("unknown algorithm %q; use one of %s", name, strings.Join(checksumAlgorithmNames(), ", "))
+		}
+	}
+	full, err := s.workspaceFile(args.Root, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	sums, size, elapsed, err := hashFile(ctx, full, algorithms)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"path":       args.Path,
+		"size":       size,
+		"checksums":  sums,
+		"durationMs": elapsed.Milliseconds(),
+	}, nil
+}
+
+func (s *MCPServer) verifyChecksumTool(ctx context.Context, args verifyChecksumArgs) (interface{}, error) {
+	expected := args.Expected
+	if (expected == "") == (args.ChecksumFile == "") {
+		return nil, errors.New("give exactly one of expected and checksumFile")
+	}
+	if args.ChecksumFile != "" {
+		list, err := s.workspaceFile(args.Root, args.ChecksumFile)
+		if err != nil {
+			return nil, err
+		}
+		if expected, err = lookupChecksum(list, args.Path); err != nil {
+			return nil, err
+		}
+	}
+	algorithm, digest, err := parseChecksum(expected, args.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	full, err := s.workspaceFile(args.Root, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	sums, size, elapsed, err := hashFile(ctx, full, []string{algorithm})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"path":       args.Path,
+		"size":       size,
+		"algorithm":  algorithm,
+		"expected":   digest,
+		"actual":     sums[algorithm],
+		"match":      sums[algorithm] == digest,
+		"durationMs": elapsed.Milliseconds(),
+	}, nil
+}
+
+// workspaceFile resolves a regular file in a workspace root.
+func (s *MCPServer) workspaceFile(root, rel string) (string, error) {
+	r, err := s.cfg.Workspace.root(root)
+	if err != nil {
+		return "", err
+	}
+	full, err := r.resolve(rel)
+	if err != nil {
+		return "", err
+	}
+	st, err := os.Stat(full)
+	if err != nil {
+		return "", err
+	}
+	if !st.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", rel)
+	}
+	return full, nil
+}
+
+// hashFile reads the file once, feeding every algorithm, and reports
+// progress in bytes to the caller at most every progressInterval.
+func hashFile(ctx context.Context, full string, algorithms []string) (map[string]string, int64, time.Duration, error) {
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	total := st.Size()
+
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, name := range algorithms {
+		hashes[i] = checksumAlgorithms[name]()
+		writers[i] = hashes[i]
+	}
+	w := io.MultiWriter(writers...)
+	tc := ToolContextFrom(ctx)
+	buf := make([]byte, checksumBufferSize)
+	start := time.Now()
+	lastProgress := start
+	var done int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+		n, err := f.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			done += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if time.Since(lastProgress) >= progressInterval {
+			lastProgress = time.Now()
+			tc.Progress(float64(done), float64(total), fmt.Sprintf("%.1f of %.1f MiB hashed", float64(done)/(1<<20), float64(total)/(1<<20)))
+		}
+	}
+	sums := make(map[string]string, len(algorithms))
+	for i, name := range algorithms {
+		sums[name] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, done, time.Since(start), nil
+}
+
+// parseChecksum splits an expected checksum like "sha256:ab12..." into
+// its algorithm and lowercase digest. Without a prefix or algorithm the
+// algorithm is guessed from the digest's length.
+func parseChecksum(expected, algorithm string) (string, string, error) {
+	digest := strings.ToLower(strings.TrimSpace(expected))
+	if name, rest, ok := strings.Cut(digest, ":"); ok {
+		if algorithm != "" && algorithm != name {
+			return "", "", fmt.Errorf("expected is a %s checksum, not %s", name, algorithm)
+		}
+		algorithm, digest = name, rest
+	}
+	if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+		return "", "", fmt.Errorf("expected checksum %q is not hexadecimal", expected)
+	}
+	if algorithm == "" {
+		switch len(digest) {
+		case 32:
+			algorithm = "md5"
+		case 40:
+			algorithm = "sha1"
+		case 64:
+			algorithm = "sha256"
+		case 96:
+			algorithm = "sha384"
+		case 128:
+			algorithm = "sha512"
+		default:
+			return "", "", fmt.Errorf("cannot tell the algorithm of a %d-digit checksum; set algorithm", len(digest))
+		}
+	}
+	newHash := checksumAlgorithms[algorithm]
+	if newHash == nil {
+		return "", "", fmt.Errorf("unknown algorithm %q; use one of %s", algorithm, strings.Join(checksumAlgorithmNames(), ", "))
+	}
+	if want := newHash().Size() * 2; len(digest) != want {
+		return "", "", fmt.Errorf("a %s checksum has %d hex digits, not %d", algorithm, want, len(digest))
+	}
+	return algorithm, digest, nil
+}
+
+// lookupChecksum finds the digest of rel in a checksum list of
+// "<digest>  <name>" lines, as written by sha256sum and friends. Names
+// match in full or by base name.
+func lookupChecksum(list, rel string) (string, error) {
+	f, err := os.Open(list)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	var byBase string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		digest, name, ok := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		if !ok || strings.HasPrefix(digest, "#") {
+			continue
+		}
+		// A leading '*' marks binary mode.
+		name = path.Clean(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(name), "*"), "./"))
+		switch {
+		case name == rel:
+			return digest, nil
+		case path.Base(name) == path.Base(rel) && byBase == "":
+			byBase = digest
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	if byBase == "" {
+		return "", fmt.Errorf("%s is not listed in the checksum file", rel)
+	}
+	return byBase, nil
+}
+
+func checksumAlgorithmNames() []string {
+	names := make([]string, 0, len(checksumAlgorithms))
+	for name := range checksumAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
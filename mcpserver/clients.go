@@ -0,0 +1,153 @@
+package mcpserver
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const clientCallsMetric = "mcp_client_tool_calls_total"
+
+// maxClientLabels bounds the client names tracked one by one. Names come
+// from clients' initialize requests, so past the bound further names are
+// counted as "other" rather than growing the metrics without limit.
+const maxClientLabels = 100
+
+// ClientUsageStats summarizes the tool calls of one client application,
+// as named in its initialize request.
+type ClientUsageStats struct {
+	Client    string           `json:"client"`
+	Calls     int64            `json:"calls"`
+	Errors    int64            `json:"errors"`
+	ErrorRate float64          `json:"errorRate"`
+	Versions  map[string]int64 `json:"versions"` // calls by client version
+	Sessions  int              `json:"sessions"` // open now
+	LastSeen  *time.Time       `json:"lastSeen,omitempty"`
+}
+
+// clientTracker counts tool calls by client. It lives in memory and is
+// shared across reloads.
+type clientTracker struct {
+	mu      sync.Mutex
+	clients map[string]*ClientUsageStats
+}
+
+func newClientTracker() *clientTracker {
+	return &clientTracker{clients: map[string]*ClientUsageStats{}}
+}
+
+// clientLabel cleans a client name for use as a label value.
+func clientLabel(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
+
+// record counts a call and returns the label it was counted under.
+func (t *clientTracker) record(name, version string, failed bool) string {
+	label := clientLabel(name)
+	if len(version) > 32 {
+		version = version[:32]
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.clients[label]
+	if c == nil {
+		if len(t.clients) >= maxClientLabels {
+			label = "other"
+			c = t.clients[label]
+		}
+		if c == nil {
+			c = &ClientUsageStats{Client: label, Versions: map[string]int64{}}
+			t.clients[label] = c
+		}
+	}
+	c.Calls++
+	if failed {
+		c.Errors++
+	}
+	c.Versions[version]++
+	now := time.Now().UTC()
+	c.LastSeen = &now
+	return label
+}
+
+// stats returns every client's usage, most calls first, with its open
+// sessions counted from sessions.
+func (t *clientTracker) stats(sessions []Session) []ClientUsageStats {
+	open := map[string]int{}
+	for _, sess := range sessions {
+		open[clientLabel(sess.ClientName)]++
+	}
+	t.mu.Lock()
+	out := make([]ClientUsageStats, 0, len(t.clients))
+	for _, c := range t.clients {
+		st := *c
+		st.Versions = make(map[string]int64, len(c.Versions))
+		for v, n := range c.Versions {
+			st.Versions[v] = n
+		}
+		if st.Calls > 0 {
+			st.ErrorRate = float64(st.Errors) / float64(st.Calls)
+		}
+		st.Sessions = open[st.Client]
+		delete(open, st.Client)
+		out = append(out, st)
+	}
+	t.mu.Unlock()
+	// Clients with sessions but no calls yet.
+	for label, n := range open {
+		out = append(out, ClientUsageStats{Client: label, Versions: map[string]int64{}, Sessions: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Calls != out[j].Calls {
+			return out[i].Calls > out[j].Calls
+		}
+		return out[i].Client < out[j].Client
+	})
+	return out
+}
+
+func (t *clientTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clients = map[string]*ClientUsageStats{}
+}
+
+// recordClient attributes a tool event to the calling client.
+func (s *MCPServer) recordClient(ev Event) {
+	name, _ := ev.Data["client"].(string)
+	version, _ := ev.Data["clientVersion"].(string)
+	failed := ev.Type == EventToolFailed
+	label := s.clients.record(name, version, failed)
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	s.metrics.inc(clientCallsMetric, "client", label, "outcome", outcome)
+}
+
+// handleAdminClients serves per-client usage at /admin/clients.
+func (s *MCPServer) handleAdminClients(w http.ResponseWriter, r *http.Request, rest string) {
+	if rest != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"clients": s.clients.stats(s.sessions.list())})
+}
@@ -0,0 +1,504 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CloudConfig lists the cloud accounts the cloud_ inventory tools read.
+type CloudConfig struct {
+	Accounts []CloudAccount `json:"accounts,omitempty"`
+}
+
+// CloudAccount is an AWS account, GCP project or Azure subscription. No
+// keys are configured here: the server uses the credentials it finds in
+// its environment the way the provider's CLI does, from environment
+// variables, credential files, workload identity or the instance
+// metadata service, so grant that identity read-only access.
+type CloudAccount struct {
+	Name         string   `json:"name" schema:"required"`
+	Provider     string   `json:"provider" schema:"required,enum=aws|gcp|azure"`
+	Regions      []string `json:"regions,omitempty"`                          // aws; default $AWS_REGION
+	Profile      string   `json:"profile,omitempty"`                          // aws; a profile of the shared credentials file
+	Project      string   `json:"project,omitempty"`                          // gcp; default from the credentials
+	Subscription string   `json:"subscription,omitempty"`                     // azure; default $AZURE_SUBSCRIPTION_ID
+	Timeout      Duration `json:"timeout,omitempty" schema:"format=duration"` // default 30s
+}
+
+const (
+	defaultCloudTimeout = 30 * time.Second
+	defaultCloudItems   = 50
+	maxCloudItems       = 500
+	// maxCloudTags bounds the tags or labels listed per resource.
+	maxCloudTags     = 10
+	maxCloudResponse = 16 << 20
+	// cloudTokenSlack renews credentials this long before they expire.
+	cloudTokenSlack = 5 * time.Minute
+)
+
+var (
+	awsRegionPattern    = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	gcpProjectPattern   = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$|^[a-z0-9.-]+:[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	azureSubscriptionID = regexp.MustCompile(`^[0-9a-fA-F]{8}-([0-9a-fA-F]{4}-){3}[0-9a-fA-F]{12}$`)
+)
+
+// metadataClient reaches instance metadata services on link-local
+// addresses, which must never go through an egress proxy.
+var metadataClient = &http.Client{Transport: &http.Transport{Proxy: nil}, Timeout: 5 * time.Second}
+
+// cloudProvider lists one account's resources. Listings return a page of
+// summarized items from the provider's page token, asking for about size
+// items, and the token of the next page, if any.
+type cloudProvider interface {
+	instances(ctx context.Context, region, token string, size int) (cloudPage, error)
+	buckets(ctx context.Context, token string, size int) (cloudPage, error)
+	dnsZones(ctx context.Context, token string, size int) (cloudPage, error)
+	dnsRecords(ctx context.Context, zone, token string, size int) (cloudPage, error)
+}
+
+type cloudPage struct {
+	items []map[string]interface{}
+	next  string
+}
+
+func (c CloudConfig) enabled() bool {
+	return len(c.Accounts) > 0
+}
+
+func (c CloudConfig) check() error {
+	seen := map[string]bool{}
+	for _, a := range c.Accounts {
+		if a.Name == "" || seen[a.Name] {
+			return fmt.Errorf("account %q: names must be unique and non-empty", a.Name)
+		}
+		seen[a.Name] = true
+		if err := a.check(); err != nil {
+			return fmt.Errorf("account %q: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+func (a CloudAccount) check() error {
+	if a.Provider != "aws" && (len(a.Regions) > 0 || a.Profile != "") {
+		return errors.New("regions and profile are for aws accounts")
+	}
+	if a.Provider != "gcp" && a.Project != "" {
+		return errors.New("project is for gcp accounts")
+	}
+	if a.Provider != "azure" && a.Subscription != "" {
+		return errors.New("subscription is for azure accounts")
+	}
+	switch a.Provider {
+	case "aws":
+		regions := a.awsRegions()
+		if len(regions) == 0 {
+			return errors.New("regions is required when AWS_REGION is not set")
+		}
+		for _, r := range regions {
+			if !awsRegionPattern.MatchString(r) {
+				return fmt.Errorf("invalid region %q", r)
+			}
+		}
+	case "gcp":
+		if a.Project != "" && !gcpProjectPattern.MatchString(a.Project) {
+			return fmt.Errorf("invalid project ID %q", a.Project)
+		}
+	case "azure":
+		if !azureSubscriptionID.MatchString(a.azureSubscription()) {
+			return errors.New("subscription must be a subscription ID, or AZURE_SUBSCRIPTION_ID set to one")
+		}
+	default:
+		return fmt.Errorf("provider %q must be aws, gcp or azure", a.Provider)
+	}
+	return nil
+}
+
+func (s *MCPServer) setupCloudTools() {
+	providers := map[string]cloudProvider{}
+	for _, a := range s.cfg.Cloud.Accounts {
+		switch a.Provider {
+		case "aws":
+			providers[a.Name] = &awsAccount{account: a}
+		case "gcp":
+			providers[a.Name] = &gcpAccount{account: a}
+		case "azure":
+			providers[a.Name] = &azureAccount{account: a}
+		}
+	}
+	inv := &cloudInventory{cfg: s.cfg.Cloud, providers: providers}
+	openWorld := true
+	instances, instancesHandler, _ := typedTool("cloud_list_instances", "List the compute instances (EC2, Compute Engine or virtual machines) of a cloud account with their state, type, zone and addresses", inv.listInstances)
+	instances.Annotations = readOnlyAnnotations()
+	instances.Annotations.OpenWorldHint = &openWorld
+	s.addTool(instances, instancesHandler)
+
+	buckets, bucketsHandler, _ := typedTool("cloud_list_buckets", "List the storage buckets (S3 or Cloud Storage buckets, or Azure storage accounts) of a cloud account", inv.listBuckets)
+	buckets.Annotations = readOnlyAnnotations()
+	buckets.Annotations.OpenWorldHint = &openWorld
+	s.addTool(buckets, bucketsHandler)
+
+	dns, dnsHandler, _ := typedTool("cloud_list_dns_records", "List the DNS zones of a cloud account, or the records of one zone", inv.listDNSRecords)
+	dns.Annotations = readOnlyAnnotations()
+	dns.Annotations.OpenWorldHint = &openWorld
+	s.addTool(dns, dnsHandler)
+}
+
+// cloudInventory serves the cloud_ tools from the configured accounts,
+// whose providers keep their credentials between calls.
+type cloudInventory struct {
+	cfg       CloudConfig
+	providers map[string]cloudProvider
+}
+
+// account returns the named account, or the only one when name is empty.
+func (inv *cloudInventory) account(name string) (CloudAccount, cloudProvider, error) {
+	if name == "" && len(inv.cfg.Accounts) == 1 {
+		a := inv.cfg.Accounts[0]
+		return a, inv.providers[a.Name], nil
+	}
+	names := make([]string, len(inv.cfg.Accounts))
+	for i, a := range inv.cfg.Accounts {
+		if a.Name == name {
+			return a, inv.providers[a.Name], nil
+		}
+		names[i] = a.Name
+	}
+	return CloudAccount{}, nil, fmt.Errorf("unknown cloud account %q; configured: %s", name, strings.Join(names, ", "))
+}
+
+type cloudListArgs struct {
+	Account   string `json:"account,omitempty" jsonschema:"description=Configured cloud account; may be left out when there is only one"`
+	PageToken string `json:"page_token,omitempty" jsonschema:"description=next_page_token of the previous call\\, to continue the listing"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=500,description=Most buckets to return (default 50)"`
+}
+
+type cloudInstancesArgs struct {
+	Account   string `json:"account,omitempty" jsonschema:"description=Configured cloud account; may be left out when there is only one"`
+	Region    string `json:"region,omitempty" jsonschema:"description=AWS region to list (default the account's first region); GCP and Azure list every region"`
+	PageToken string `json:"page_token,omitempty" jsonschema:"description=next_page_token of the previous call\\, to continue the listing"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=500,description=Most instances to return (default 50)"`
+}
+
+type cloudDNSArgs struct {
+	Account   string `json:"account,omitempty" jsonschema:"description=Configured cloud account; may be left out when there is only one"`
+	Zone      string `json:"zone,omitempty" jsonschema:"description=Zone whose records to list\\, as its id in the zone listing; leave out to list the zones"`
+	PageToken string `json:"page_token,omitempty" jsonschema:"description=next_page_token of the previous call\\, to continue the listing"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=500,description=Most zones or records to return (default 50)"`
+}
+
+// list runs one listing with the account's timeout and pages it in steps
+// of the requested limit.
+func (inv *cloudInventory) list(ctx context.Context, account, pageToken string, limit int, fetch func(context.Context, cloudProvider, string, int) (cloudPage, error)) (CloudAccount, []map[string]interface{}, string, error) {
+	a, p, err := inv.account(account)
+	if err != nil {
+		return a, nil, "", err
+	}
+	if limit <= 0 {
+		limit = defaultCloudItems
+	}
+	limit = min(limit, maxCloudItems)
+	ctx, cancel := context.WithTimeout(ctx, orDefault(a.Timeout, defaultCloudTimeout))
+	defer cancel()
+	items, next, err := cloudPaged(pageToken, limit, func(token string, size int) (cloudPage, error) {
+		return fetch(ctx, p, token, size)
+	})
+	if err != nil {
+		return a, nil, "", fmt.Errorf("%s: %w", a.Name, err)
+	}
+	return a, items, next, nil
+}
+
+func (inv *cloudInventory) listInstances(ctx context.Context, args cloudInstancesArgs) (interface{}, error) {
+	a, _, err := inv.account(args.Account)
+	if err != nil {
+		return nil, err
+	}
+	region := args.Region
+	switch {
+	case a.Provider != "aws":
+		if region != "" {
+			return nil, errors.New("region is for aws accounts")
+		}
+	case region == "":
+		region = a.awsRegions()[0]
+	case !awsRegionPattern.MatchString(region):
+		return nil, fmt.Errorf("invalid region %q", region)
+	}
+	_, items, next, err := inv.list(ctx, args.Account, args.PageToken, args.Limit, func(ctx context.Context, p cloudProvider, token string, size int) (cloudPage, error) {
+		return p.instances(ctx, region, token, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := cloudResult(a, "instances", items, next, "state", "type")
+	if region != "" {
+		out["region"] = region
+	}
+	return out, nil
+}
+
+func (inv *cloudInventory) listBuckets(ctx context.Context, args cloudListArgs) (interface{}, error) {
+	a, items, next, err := inv.list(ctx, args.Account, args.PageToken, args.Limit, func(ctx context.Context, p cloudProvider, token string, size int) (cloudPage, error) {
+		return p.buckets(ctx, token, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloudResult(a, "buckets", items, next, "location"), nil
+}
+
+func (inv *cloudInventory) listDNSRecords(ctx context.Context, args cloudDNSArgs) (interface{}, error) {
+	if args.Zone == "" {
+		a, items, next, err := inv.list(ctx, args.Account, args.PageToken, args.Limit, func(ctx context.Context, p cloudProvider, token string, size int) (cloudPage, error) {
+			return p.dnsZones(ctx, token, size)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return cloudResult(a, "zones", items, next), nil
+	}
+	a, items, next, err := inv.list(ctx, args.Account, args.PageToken, args.Limit, func(ctx context.Context, p cloudProvider, token string, size int) (cloudPage, error) {
+		return p.dnsRecords(ctx, args.Zone, token, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := cloudResult(a, "records", items, next, "type")
+	out["zone"] = args.Zone
+	return out, nil
+}
+
+// cloudResult shapes a listing for the model: the items, their count and
+// how many of them have each value of the tally keys.
+func cloudResult(a CloudAccount, kind string, items []map[string]interface{}, next string, tally ...string) map[string]interface{} {
+	out := map[string]interface{}{
+		"account":  a.Name,
+		"provider": a.Provider,
+		kind:       items,
+		"count":    len(items),
+	}
+	for _, key := range tally {
+		counts := map[string]int{}
+		for _, item := range items {
+			if v, ok := item[key].(string); ok && v != "" {
+				counts[v]++
+			}
+		}
+		if len(counts) > 0 {
+			out["by_"+key] = counts
+		}
+	}
+	if next != "" {
+		out["next_page_token"] = next
+	}
+	return out
+}
+
+// cloudCursor is a page token: the provider's token of a page and how
+// many of its items were returned already. It lets limit differ from the
+// page sizes providers allow or, for Azure, pick themselves.
+type cloudCursor struct {
+	Token string `json:"t,omitempty"`
+	Skip  int    `json:"s,omitempty"`
+}
+
+func cloudPaged(pageToken string, limit int, fetch func(token string, size int) (cloudPage, error)) ([]map[string]interface{}, string, error) {
+	var cur cloudCursor
+	if pageToken != "" {
+		data, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil || json.Unmarshal(data, &cur) != nil || cur.Skip < 0 {
+			return nil, "", errors.New("invalid page_token")
+		}
+	}
+	page, err := fetch(cur.Token, cur.Skip+limit)
+	if err != nil {
+		return nil, "", err
+	}
+	items := page.items[min(cur.Skip, len(page.items)):]
+	next := cloudCursor{Token: page.next}
+	if len(items) > limit {
+		items, next = items[:limit], cloudCursor{Token: cur.Token, Skip: cur.Skip + limit}
+	} else if page.next == "" {
+		return items, "", nil
+	}
+	data, _ := json.Marshal(next)
+	return items, base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// cloudTags returns up to maxCloudTags tags or labels, sorted by key,
+// with the number left out.
+func cloudTags(tags map[string]string) (map[string]string, int) {
+	if len(tags) <= maxCloudTags {
+		return tags, 0
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := map[string]string{}
+	for _, k := range keys[:maxCloudTags] {
+		out[k] = tags[k]
+	}
+	return out, len(tags) - maxCloudTags
+}
+
+// cloudItem returns a summary without its empty fields.
+func cloudItem(item map[string]interface{}) map[string]interface{} {
+	for k, v := range item {
+		switch v := v.(type) {
+		case nil:
+			delete(item, k)
+		case string:
+			if v == "" {
+				delete(item, k)
+			}
+		case int:
+			if v == 0 {
+				delete(item, k)
+			}
+		}
+	}
+	return item
+}
+
+// withValues adds up to maxCloudTags values, like the data of a DNS
+// record, to a summary, with the number left out.
+func withValues(item map[string]interface{}, values []string) map[string]interface{} {
+	if len(values) == 0 {
+		return item
+	}
+	item["values"] = values[:min(len(values), maxCloudTags)]
+	if len(values) > maxCloudTags {
+		item["more_values"] = len(values) - maxCloudTags
+	}
+	return item
+}
+
+// withTags adds the tags of a resource to its summary.
+func withTags(item map[string]interface{}, tags map[string]string) map[string]interface{} {
+	if len(tags) == 0 {
+		return item
+	}
+	kept, more := cloudTags(tags)
+	item["tags"] = kept
+	if more > 0 {
+		item["more_tags"] = more
+	}
+	return item
+}
+
+// cloudDo sends req and returns the body of a successful reply. Error
+// replies are reported with the provider's message when one is found.
+func cloudDo(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCloudResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCloudResponse {
+		return nil, fmt.Errorf("%s: response is over %d MiB", req.URL.Host, maxCloudResponse>>20)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s: HTTP %d: %s", req.URL.Host, resp.StatusCode, cloudErrorMessage(data))
+	}
+	return data, nil
+}
+
+var xmlErrorMessage = regexp.MustCompile(`<Message>([^<]*)</Message>`)
+
+// cloudErrorMessage picks the message out of an error reply: JSON from
+// Google and Azure APIs and OAuth servers, XML from AWS.
+func cloudErrorMessage(data []byte) string {
+	var reply struct {
+		Error interface{} `json:"error"`
+		Desc  string      `json:"error_description"`
+	}
+	if json.Unmarshal(data, &reply) == nil {
+		switch e := reply.Error.(type) {
+		case map[string]interface{}:
+			if msg, ok := e["message"].(string); ok {
+				return msg
+			}
+		case string:
+			if reply.Desc != "" {
+				return e + ": " + reply.Desc
+			}
+			return e
+		}
+	}
+	if m := xmlErrorMessage.FindSubmatch(data); m != nil {
+		return string(m[1])
+	}
+	return string(bytes.TrimSpace(data[:min(len(data), 512)]))
+}
+
+// getJSON fetches a provider URL with a bearer token, unless token is
+// empty, and decodes the reply into out.
+func getJSON(ctx context.Context, rawURL, token string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	data, err := cloudDo(ToolContextFrom(ctx).HTTPClient, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// oauthToken is an OAuth 2.0 token reply. Azure's managed identity
+// endpoints write expires_in as a string.
+type oauthToken struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+}
+
+// expiry returns when the token expires, assuming an hour if unsaid.
+func (t oauthToken) expiry(now time.Time) time.Time {
+	raw := strings.Trim(string(t.ExpiresIn), `"`)
+	var secs int64
+	if _, err := fmt.Sscan(raw, &secs); err != nil || secs <= 0 {
+		secs = 3600
+	}
+	return now.Add(time.Duration(secs) * time.Second)
+}
+
+// fetchToken sends an OAuth token request and decodes the reply.
+func fetchToken(client *http.Client, req *http.Request) (oauthToken, error) {
+	var tok oauthToken
+	data, err := cloudDo(client, req)
+	if err != nil {
+		return tok, err
+	}
+	if err := json.Unmarshal(data, &tok); err != nil || tok.AccessToken == "" {
+		return tok, fmt.Errorf("%s: no access token in the reply", req.URL.Host)
+	}
+	return tok, nil
+}
+
+// homeDir returns the user's home directory, or "" when unknown.
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
@@ -0,0 +1,357 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAWSSign checks the signer against the GET and POST cases of AWS's
+// Signature Version 4 test suite, which all sign for the same key and
+// time.
+func TestAWSSign(t *testing.T) {
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name, method, path string
+		query              url.Values
+		token              string
+		signed, signature  string
+	}{
+		{"get-vanilla", "GET", "/", nil, "", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "/", url.Values{"Param2": {"value2"}, "Param1": {"value1"}}, "", "host;x-amz-date",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-query-unreserved", "GET", "/", url.Values{"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz": {"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"}}, "", "host;x-amz-date",
+			"9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197"},
+		{"get-utf8", "GET", "/ሴ", nil, "", "host;x-amz-date",
+			"8318018e0b0f223aa2bbf98705b62bb787dc9c0e678f255a891fd03141be5d85"},
+		{"get-space", "GET", "/example space/", nil, "", "host;x-amz-date",
+			"652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741"},
+		{"post-vanilla", "POST", "/", nil, "", "host;x-amz-date",
+			"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-sts-header-before", "POST", "/", nil, "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA==", "host;x-amz-date;x-amz-security-token",
+			"85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, "https://example.amazonaws.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// As awsAccount.get builds its requests.
+			req.URL.Path, req.URL.RawPath = tc.path, awsEscapePath(tc.path)
+			req.URL.RawQuery = strings.ReplaceAll(tc.query.Encode(), "+", "%20")
+			c := creds
+			c.SessionToken = tc.token
+			awsSign(req, c, "service", "us-east-1", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=" + tc.signed + ", Signature=" + tc.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization\n got %s\nwant %s", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+func TestAWSEscapePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"/":                           "/",
+		"/env/prod/terraform.tfstate": "/env/prod/terraform.tfstate",
+		"/a b+c":                      "/a%20b%2Bc",
+		"/ä~x":                        "/%C3%A4~x",
+	} {
+		if got := awsEscapePath(in); got != want {
+			t.Errorf("awsEscapePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestCloudPaged pages through a listing whose provider pages hold 3
+// items while the caller asks for 2 at a time.
+func TestCloudPaged(t *testing.T) {
+	const total, pageSize = 8, 3
+	fetch := func(token string, size int) (cloudPage, error) {
+		start := 0
+		if token != "" {
+			start, _ = strconv.Atoi(token)
+		}
+		var page cloudPage
+		for i := start; i < min(start+pageSize, total); i++ {
+			page.items = append(page.items, map[string]interface{}{"id": i})
+		}
+		if start+pageSize < total {
+			page.next = strconv.Itoa(start + pageSize)
+		}
+		return page, nil
+	}
+	var got []int
+	token := ""
+	for calls := 0; ; calls++ {
+		if calls > total {
+			t.Fatal("listing does not end")
+		}
+		items, next, err := cloudPaged(token, 2, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) > 2 {
+			t.Fatalf("got %d items, limit 2", len(items))
+		}
+		for _, item := range items {
+			got = append(got, item["id"].(int))
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4 5 6 7]" {
+		t.Errorf("items %v", got)
+	}
+	if _, _, err := cloudPaged("not a token", 2, fetch); err == nil {
+		t.Error("invalid page token accepted")
+	}
+}
+
+func TestCloudErrorMessage(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"error":{"code":403,"message":"Permission denied"}}`:                                                                         "Permission denied",
+		`{"error":"invalid_grant","error_description":"Token expired"}`:                                                                "invalid_grant: Token expired",
+		`<Response><Errors><Error><Code>AuthFailure</Code><Message>AWS was not able to validate</Message></Error></Errors></Response>`: "AWS was not able to validate",
+		"  plain text  ": "plain text",
+	} {
+		if got := cloudErrorMessage([]byte(body)); got != want {
+			t.Errorf("cloudErrorMessage(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+// cloudTestTransport sends every request to a test server, with the host
+// it was meant for in X-Original-Host.
+type cloudTestTransport struct {
+	target *url.URL
+}
+
+func (tr cloudTestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Original-Host", req.URL.Host)
+	req.URL.Scheme, req.URL.Host = tr.target.Scheme, tr.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// cloudTestContext returns a context whose HTTP client reaches handler.
+func cloudTestContext(t *testing.T, handler http.HandlerFunc) context.Context {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: cloudTestTransport{target}}
+	return WithToolContext(context.Background(), &ToolContext{Services: Services{HTTPClient: client}.withDefaults()})
+}
+
+// collectCloud calls a listing tool with limit 2 until it has no next
+// page, and returns the value of field of every item.
+func collectCloud(t *testing.T, kind, field string, call func(pageToken string) (interface{}, error)) []string {
+	t.Helper()
+	var got []string
+	token := ""
+	for calls := 0; calls < 50; calls++ {
+		res, err := call(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := res.(map[string]interface{})
+		items := out[kind].([]map[string]interface{})
+		if len(items) > 2 {
+			t.Fatalf("got %d %s, limit 2", len(items), kind)
+		}
+		for _, item := range items {
+			got = append(got, fmt.Sprint(item[field]))
+		}
+		next, _ := out["next_page_token"].(string)
+		if next == "" {
+			return got
+		}
+		token = next
+	}
+	t.Fatal("listing does not end")
+	return nil
+}
+
+// pageRange returns the items a fake API serves for a page token, which
+// is the index of the first item, and a page size.
+func pageRange(token, size string, total int) (start, end int) {
+	start, _ = strconv.Atoi(token)
+	n, err := strconv.Atoi(size)
+	if err != nil || n <= 0 {
+		n = 3
+	}
+	return start, min(start+n, total)
+}
+
+func TestAWSPaging(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	ctx := cloudTestContext(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			http.Error(w, "<Error><Message>unsigned</Message></Error>", http.StatusForbidden)
+			return
+		}
+		q := r.URL.Query()
+		host := r.Header.Get("X-Original-Host")
+		switch {
+		case host == "ec2.eu-west-1.amazonaws.com" && q.Get("Action") == "DescribeInstances":
+			start, end := pageRange(q.Get("NextToken"), q.Get("MaxResults"), 7)
+			fmt.Fprint(w, `<DescribeInstancesResponse><reservationSet><item><instancesSet>`)
+			for i := start; i < end; i++ {
+				fmt.Fprintf(w, `<item><instanceId>i-%d</instanceId><instanceType>t3.micro</instanceType><instanceState><name>running</name></instanceState><tagSet><item><key>Name</key><value>web%d</value></item></tagSet></item>`, i, i)
+			}
+			fmt.Fprint(w, `</instancesSet></item></reservationSet>`)
+			if end < 7 {
+				fmt.Fprintf(w, `<nextToken>%d</nextToken>`, end)
+			}
+			fmt.Fprint(w, `</DescribeInstancesResponse>`)
+		case host == "route53.amazonaws.com" && r.URL.Path == "/2013-04-01/hostedzone/Z123/rrset":
+			// Records continue from the name of the next one, r<n>.
+			start := 0
+			if name := q.Get("name"); name != "" {
+				start, _ = strconv.Atoi(strings.TrimPrefix(name, "r"))
+			}
+			_, end := pageRange("", q.Get("maxitems"), 5)
+			end = min(start+end, 5)
+			fmt.Fprint(w, `<ListResourceRecordSetsResponse><ResourceRecordSets>`)
+			for i := start; i < end; i++ {
+				fmt.Fprintf(w, `<ResourceRecordSet><Name>r%d</Name><Type>A</Type><TTL>60</TTL><ResourceRecords><ResourceRecord><Value>10.0.0.%d</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>`, i, i)
+			}
+			fmt.Fprint(w, `</ResourceRecordSets>`)
+			if end < 5 {
+				fmt.Fprintf(w, `<IsTruncated>true</IsTruncated><NextRecordName>r%d</NextRecordName><NextRecordType>A</NextRecordType>`, end)
+			}
+			fmt.Fprint(w, `</ListResourceRecordSetsResponse>`)
+		default:
+			http.Error(w, "<Error><Message>unexpected "+host+r.URL.String()+"</Message></Error>", http.StatusNotFound)
+		}
+	})
+	account := CloudAccount{Name: "prod", Provider: "aws", Regions: []string{"eu-west-1"}}
+	inv := &cloudInventory{cfg: CloudConfig{Accounts: []CloudAccount{account}}, providers: map[string]cloudProvider{"prod": &awsAccount{account: account}}}
+
+	ids := collectCloud(t, "instances", "id", func(token string) (interface{}, error) {
+		return inv.listInstances(ctx, cloudInstancesArgs{PageToken: token, Limit: 2})
+	})
+	if fmt.Sprint(ids) != "[i-0 i-1 i-2 i-3 i-4 i-5 i-6]" {
+		t.Errorf("instances %v", ids)
+	}
+	names := collectCloud(t, "records", "name", func(token string) (interface{}, error) {
+		return inv.listDNSRecords(ctx, cloudDNSArgs{Zone: "Z123", PageToken: token, Limit: 2})
+	})
+	if fmt.Sprint(names) != "[r0 r1 r2 r3 r4]" {
+		t.Errorf("records %v", names)
+	}
+	if _, err := inv.listDNSRecords(ctx, cloudDNSArgs{Zone: "../etc"}); err == nil {
+		t.Error("invalid zone accepted")
+	}
+}
+
+func TestGCPPaging(t *testing.T) {
+	ctx := cloudTestContext(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gcp-token" {
+			http.Error(w, `{"error":{"message":"unauthenticated"}}`, http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		if r.Header.Get("X-Original-Host") != "compute.googleapis.com" || r.URL.Path != "/compute/v1/projects/my-project/aggregated/instances" {
+			http.Error(w, `{"error":{"message":"unexpected"}}`, http.StatusNotFound)
+			return
+		}
+		start, end := pageRange(q.Get("pageToken"), q.Get("maxResults"), 5)
+		// Two zones per page, which the listing puts in order.
+		items := map[string]interface{}{}
+		for i := start; i < end; i++ {
+			zone := fmt.Sprintf("zones/z-%d", 1-i%2)
+			list, _ := items[zone].(map[string][]map[string]interface{})
+			if list == nil {
+				list = map[string][]map[string]interface{}{}
+			}
+			list["instances"] = append(list["instances"], map[string]interface{}{
+				"id": strconv.Itoa(i), "name": fmt.Sprintf("vm%d", i), "status": "RUNNING",
+				"machineType": "zones/" + zone + "/machineTypes/e2-small", "zone": zone,
+			})
+			items[zone] = list
+		}
+		reply := map[string]interface{}{"items": items}
+		if end < 5 {
+			reply["nextPageToken"] = strconv.Itoa(end)
+		}
+		json.NewEncoder(w).Encode(reply)
+	})
+	account := CloudAccount{Name: "data", Provider: "gcp", Project: "my-project"}
+	g := &gcpAccount{account: account, token: "gcp-token", expires: time.Now().Add(time.Hour), project: "my-project"}
+	inv := &cloudInventory{cfg: CloudConfig{Accounts: []CloudAccount{account}}, providers: map[string]cloudProvider{"data": g}}
+	got := collectCloud(t, "instances", "name", func(token string) (interface{}, error) {
+		return inv.listInstances(ctx, cloudInstancesArgs{PageToken: token, Limit: 2})
+	})
+	// Each provider page of 2 lists zone z-0 before z-1.
+	if fmt.Sprint(got) != "[vm1 vm0 vm3 vm2 vm4]" {
+		t.Errorf("instances %v", got)
+	}
+}
+
+func TestAzurePaging(t *testing.T) {
+	const sub = "3b8f2c41-6d0e-4f7a-9c15-2e7d84a0b6f9"
+	ctx := cloudTestContext(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer azure-token" || r.Header.Get("X-Original-Host") != "management.azure.com" {
+			http.Error(w, `{"error":{"message":"unauthenticated"}}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/subscriptions/"+sub+"/providers/Microsoft.Storage/storageAccounts" {
+			http.Error(w, `{"error":{"message":"unexpected"}}`, http.StatusNotFound)
+			return
+		}
+		// Resource Manager picks its own page size, here 3.
+		start, end := pageRange(r.URL.Query().Get("$skiptoken"), "", 7)
+		var value []map[string]interface{}
+		for i := start; i < end; i++ {
+			value = append(value, map[string]interface{}{
+				"id":       fmt.Sprintf("/subscriptions/%s/resourceGroups/rg%d/providers/Microsoft.Storage/storageAccounts/sa%d", sub, i, i),
+				"name":     fmt.Sprintf("sa%d", i),
+				"location": "westeurope",
+				"sku":      map[string]string{"name": "Standard_LRS"},
+			})
+		}
+		reply := map[string]interface{}{"value": value}
+		if end < 7 {
+			reply["nextLink"] = fmt.Sprintf("https://management.azure.com/subscriptions/%s/providers/Microsoft.Storage/storageAccounts?api-version=2023-05-01&$skiptoken=%d", sub, end)
+		}
+		json.NewEncoder(w).Encode(reply)
+	})
+	account := CloudAccount{Name: "corp", Provider: "azure", Subscription: sub}
+	az := &azureAccount{account: account, token: "azure-token", expires: time.Now().Add(time.Hour)}
+	inv := &cloudInventory{cfg: CloudConfig{Accounts: []CloudAccount{account}}, providers: map[string]cloudProvider{"corp": az}}
+	got := collectCloud(t, "buckets", "name", func(token string) (interface{}, error) {
+		return inv.listBuckets(ctx, cloudListArgs{PageToken: token, Limit: 2})
+	})
+	if fmt.Sprint(got) != "[sa0 sa1 sa2 sa3 sa4 sa5 sa6]" {
+		t.Errorf("buckets %v", got)
+	}
+
+	// A page token pointing anywhere but Resource Manager is refused
+	// before the token is sent.
+	data, _ := json.Marshal(cloudCursor{Token: "https://attacker.example/steal"})
+	if _, err := az.buckets(ctx, "https://attacker.example/steal", 2); err == nil {
+		t.Error("foreign nextLink accepted")
+	}
+	if _, err := inv.listBuckets(ctx, cloudListArgs{PageToken: base64.RawURLEncoding.EncodeToString(data)}); err == nil {
+		t.Error("foreign page token accepted")
+	}
+}
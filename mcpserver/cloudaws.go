@@ -0,0 +1,515 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsAccount lists EC2 instances, S3 buckets and Route 53 zones with
+// requests signed by Signature Version 4.
+type awsAccount struct {
+	account CloudAccount
+	mu      sync.Mutex
+	creds   awsCredentials
+}
+
+// awsCredentials are access keys. The container and instance metadata
+// endpoints write the session token as Token, STS as SessionToken.
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId" xml:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey" xml:"SecretAccessKey"`
+	SessionToken    string    `json:"Token" xml:"SessionToken"`
+	Expiration      time.Time `json:"Expiration" xml:"Expiration"`
+}
+
+var route53ZoneID = regexp.MustCompile(`^[A-Z0-9]+$`)
+
+func (a CloudAccount) awsRegions() []string {
+	if len(a.Regions) > 0 {
+		return a.Regions
+	}
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return []string{r}
+		}
+	}
+	return nil
+}
+
+func (aws *awsAccount) credentials(ctx context.Context) (awsCredentials, error) {
+	aws.mu.Lock()
+	defer aws.mu.Unlock()
+	if c := aws.creds; c.AccessKeyID != "" && (c.Expiration.IsZero() || time.Until(c.Expiration) > cloudTokenSlack) {
+		return c, nil
+	}
+	creds, err := aws.findCredentials(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("aws credentials: %w", err)
+	}
+	aws.creds = creds
+	return creds, nil
+}
+
+// findCredentials looks where the AWS CLI does: the environment, the
+// shared credentials file, a web identity token, then the container and
+// instance metadata endpoints. A configured profile is only looked up in
+// the credentials file.
+func (aws *awsAccount) findCredentials(ctx context.Context) (awsCredentials, error) {
+	profile := aws.account.Profile
+	if profile == "" {
+		id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if id != "" && secret != "" {
+			return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+		}
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	creds, found, err := awsSharedCredentials(profile)
+	switch {
+	case err != nil || found:
+		return creds, err
+	case aws.account.Profile != "":
+		return creds, fmt.Errorf("profile %q has no access keys in the shared credentials file", profile)
+	}
+	if file, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); file != "" && role != "" {
+		return aws.assumeRoleWithWebIdentity(ctx, file, role)
+	}
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return awsContainerCredentials(ctx)
+	}
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return creds, errors.New("none found in the environment or the shared credentials file")
+	}
+	creds, err = awsInstanceCredentials(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("none found in the environment or the shared credentials file, nor from instance metadata: %w", err)
+	}
+	return creds, nil
+}
+
+// awsSharedCredentials reads the access keys of profile, by default
+// "default", from ~/.aws/credentials or $AWS_SHARED_CREDENTIALS_FILE.
+func awsSharedCredentials(profile string) (awsCredentials, bool, error) {
+	var creds awsCredentials
+	if profile == "" {
+		profile = "default"
+	}
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home := homeDir()
+		if home == "" {
+			return creds, false, nil
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return creds, false, nil
+	} else if err != nil {
+		return creds, false, err
+	}
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != "", nil
+}
+
+// assumeRoleWithWebIdentity trades a web identity token, like that of an
+// EKS service account, for the role's credentials.
+func (aws *awsAccount) assumeRoleWithWebIdentity(ctx context.Context, file, role string) (awsCredentials, error) {
+	var reply struct {
+		Credentials awsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	token, err := os.ReadFile(file)
+	if err != nil {
+		return reply.Credentials, err
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = "mcp-server"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	endpoint := "https://sts." + aws.account.awsRegions()[0] + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return reply.Credentials, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data, err := cloudDo(ToolContextFrom(ctx).HTTPClient, req)
+	if err != nil {
+		return reply.Credentials, err
+	}
+	if err := xml.Unmarshal(data, &reply); err != nil || reply.Credentials.AccessKeyID == "" {
+		return reply.Credentials, errors.New("sts: no credentials in the reply")
+	}
+	return reply.Credentials, nil
+}
+
+// awsContainerCredentials fetches the task role credentials of ECS, or
+// of EKS Pod Identity when a full URI is given.
+func awsContainerCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		endpoint = "http://169.254.170.2" + rel
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return awsMetadataCredentials(req)
+}
+
+// awsInstanceCredentials fetches the EC2 instance role's credentials
+// from IMDSv2.
+func awsInstanceCredentials(ctx context.Context) (awsCredentials, error) {
+	base := "http://169.254.169.254"
+	if e := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"); e != "" {
+		base = strings.TrimSuffix(e, "/")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, base+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := cloudDo(metadataClient, req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	get := func(role string) *http.Request {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base+"/latest/meta-data/iam/security-credentials/"+role, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return req
+	}
+	roles, err := cloudDo(metadataClient, get(""))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return awsCredentials{}, errors.New("the instance has no IAM role")
+	}
+	return awsMetadataCredentials(get(url.PathEscape(role)))
+}
+
+func awsMetadataCredentials(req *http.Request) (awsCredentials, error) {
+	var creds awsCredentials
+	data, err := cloudDo(metadataClient, req)
+	if err != nil {
+		return creds, err
+	}
+	if err := json.Unmarshal(data, &creds); err != nil || creds.AccessKeyID == "" {
+		return creds, fmt.Errorf("%s: no credentials in the reply", req.URL.Host)
+	}
+	return creds, nil
+}
+
+// call sends a signed GET to an AWS API and decodes its XML reply.
+func (aws *awsAccount) call(ctx context.Context, service, region, host, apiPath string, query url.Values, out interface{}) error {
+	data, err := aws.get(ctx, service, region, host, apiPath, query)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// get sends a signed GET to an AWS API and returns the reply body.
+func (aws *awsAccount) get(ctx context.Context, service, region, host, apiPath string, query url.Values) ([]byte, error) {
+	creds, err := aws.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Signature Version 4 wants everything but unreserved characters
+	// percent-encoded, and spaces as %20, not +.
+	req.URL.Path, req.URL.RawPath = apiPath, awsEscapePath(apiPath)
+	req.URL.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	awsSign(req, creds, service, region, time.Now())
+	return cloudDo(ToolContextFrom(ctx).HTTPClient, req)
+}
+
+// awsEscapePath percent-encodes a path, like an S3 object key, for
+// Signature Version 4.
+func awsEscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsSign signs a request without a body with Signature Version 4.
+func awsSign(req *http.Request, creds awsCredentials, service, region string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	scope := stamp[:8] + "/" + region + "/" + service + "/aws4_request"
+	emptyHash := sha256.Sum256(nil)
+	payloadHash := hex.EncodeToString(emptyHash[:])
+	names := []string{"host"}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		names = append(names, "x-amz-content-sha256")
+	}
+	req.Header.Set("X-Amz-Date", stamp)
+	names = append(names, "x-amz-date")
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		names = append(names, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = req.Header.Get(name)
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, payloadHash}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	// The key is derived from the secret and the scope, and its last HMAC
+	// is the signature.
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{stamp[:8], region, service, "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, hex.EncodeToString(key)))
+}
+
+type awsTag struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+func (aws *awsAccount) instances(ctx context.Context, region, token string, size int) (cloudPage, error) {
+	// DescribeInstances takes 5 to 1000 results per page.
+	query := url.Values{
+		"Action":     {"DescribeInstances"},
+		"Version":    {"2016-11-15"},
+		"MaxResults": {strconv.Itoa(min(max(size, 5), 1000))},
+	}
+	if token != "" {
+		query.Set("NextToken", token)
+	}
+	var reply struct {
+		Reservations []struct {
+			Instances []struct {
+				ID        string   `xml:"instanceId"`
+				Type      string   `xml:"instanceType"`
+				State     string   `xml:"instanceState>name"`
+				Zone      string   `xml:"placement>availabilityZone"`
+				PrivateIP string   `xml:"privateIpAddress"`
+				PublicIP  string   `xml:"ipAddress"`
+				Launched  string   `xml:"launchTime"`
+				Tags      []awsTag `xml:"tagSet>item"`
+			} `xml:"instancesSet>item"`
+		} `xml:"reservationSet>item"`
+		NextToken string `xml:"nextToken"`
+	}
+	if err := aws.call(ctx, "ec2", region, "ec2."+region+".amazonaws.com", "/", query, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextToken}
+	for _, r := range reply.Reservations {
+		for _, in := range r.Instances {
+			tags := map[string]string{}
+			for _, t := range in.Tags {
+				tags[t.Key] = t.Value
+			}
+			name := tags["Name"]
+			delete(tags, "Name")
+			page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+				"id":         in.ID,
+				"name":       name,
+				"state":      in.State,
+				"type":       in.Type,
+				"zone":       in.Zone,
+				"private_ip": in.PrivateIP,
+				"public_ip":  in.PublicIP,
+				"launched":   in.Launched,
+			}), tags))
+		}
+	}
+	return page, nil
+}
+
+func (aws *awsAccount) buckets(ctx context.Context, token string, size int) (cloudPage, error) {
+	query := url.Values{"max-buckets": {strconv.Itoa(min(size, 10000))}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	var reply struct {
+		Buckets []struct {
+			Name    string `xml:"Name"`
+			Created string `xml:"CreationDate"`
+			Region  string `xml:"BucketRegion"`
+		} `xml:"Buckets>Bucket"`
+		ContinuationToken string `xml:"ContinuationToken"`
+	}
+	if err := aws.call(ctx, "s3", "us-east-1", "s3.amazonaws.com", "/", query, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.ContinuationToken}
+	for _, b := range reply.Buckets {
+		page.items = append(page.items, cloudItem(map[string]interface{}{
+			"name":     b.Name,
+			"location": b.Region,
+			"created":  b.Created,
+		}))
+	}
+	return page, nil
+}
+
+func (aws *awsAccount) dnsZones(ctx context.Context, token string, size int) (cloudPage, error) {
+	query := url.Values{"maxitems": {strconv.Itoa(min(size, 100))}}
+	if token != "" {
+		query.Set("marker", token)
+	}
+	var reply struct {
+		Zones []struct {
+			ID      string `xml:"Id"`
+			Name    string `xml:"Name"`
+			Private bool   `xml:"Config>PrivateZone"`
+			Comment string `xml:"Config>Comment"`
+			Records int    `xml:"ResourceRecordSetCount"`
+		} `xml:"HostedZones>HostedZone"`
+		Truncated  bool   `xml:"IsTruncated"`
+		NextMarker string `xml:"NextMarker"`
+	}
+	if err := aws.call(ctx, "route53", "us-east-1", "route53.amazonaws.com", "/2013-04-01/hostedzone", query, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	var page cloudPage
+	if reply.Truncated {
+		page.next = reply.NextMarker
+	}
+	for _, z := range reply.Zones {
+		page.items = append(page.items, cloudItem(map[string]interface{}{
+			"id":      strings.TrimPrefix(z.ID, "/hostedzone/"),
+			"name":    z.Name,
+			"private": z.Private,
+			"records": z.Records,
+			"comment": z.Comment,
+		}))
+	}
+	return page, nil
+}
+
+func (aws *awsAccount) dnsRecords(ctx context.Context, zone, token string, size int) (cloudPage, error) {
+	id := strings.TrimPrefix(zone, "/hostedzone/")
+	if !route53ZoneID.MatchString(id) {
+		return cloudPage{}, fmt.Errorf("invalid zone %q; use the id of a hosted zone, like Z0123456789ABC", zone)
+	}
+	query := url.Values{"maxitems": {strconv.Itoa(min(size, 300))}}
+	// Record listings continue from the name, type and set identifier
+	// of the next record, which the page token holds.
+	if token != "" {
+		from, err := url.ParseQuery(token)
+		if err != nil {
+			return cloudPage{}, errors.New("invalid page_token")
+		}
+		for _, k := range []string{"name", "type", "identifier"} {
+			if v := from.Get(k); v != "" {
+				query.Set(k, v)
+			}
+		}
+	}
+	var reply struct {
+		Records []struct {
+			Name   string   `xml:"Name"`
+			Type   string   `xml:"Type"`
+			TTL    int      `xml:"TTL"`
+			SetID  string   `xml:"SetIdentifier"`
+			Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+			Alias  string   `xml:"AliasTarget>DNSName"`
+		} `xml:"ResourceRecordSets>ResourceRecordSet"`
+		Truncated bool   `xml:"IsTruncated"`
+		NextName  string `xml:"NextRecordName"`
+		NextType  string `xml:"NextRecordType"`
+		NextSetID string `xml:"NextRecordIdentifier"`
+	}
+	if err := aws.call(ctx, "route53", "us-east-1", "route53.amazonaws.com", "/2013-04-01/hostedzone/"+id+"/rrset", query, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	var page cloudPage
+	if reply.Truncated {
+		next := url.Values{"name": {reply.NextName}, "type": {reply.NextType}}
+		if reply.NextSetID != "" {
+			next.Set("identifier", reply.NextSetID)
+		}
+		page.next = next.Encode()
+	}
+	for _, r := range reply.Records {
+		page.items = append(page.items, withValues(cloudItem(map[string]interface{}{
+			"name":   r.Name,
+			"type":   r.Type,
+			"ttl":    r.TTL,
+			"alias":  r.Alias,
+			"set_id": r.SetID,
+		}), r.Values))
+	}
+	return page, nil
+}
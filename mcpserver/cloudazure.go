@@ -0,0 +1,374 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureAccount lists the virtual machines, storage accounts and DNS zones
+// of a subscription through Azure Resource Manager.
+type azureAccount struct {
+	account CloudAccount
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+const azureManagement = "https://management.azure.com"
+
+var (
+	azureResourceGroup = regexp.MustCompile(`^[-\w.()]{1,90}$`)
+	azureZoneName      = regexp.MustCompile(`^[a-zA-Z0-9.-]{1,253}$`)
+)
+
+func (a CloudAccount) azureSubscription() string {
+	if a.Subscription != "" {
+		return a.Subscription
+	}
+	return os.Getenv("AZURE_SUBSCRIPTION_ID")
+}
+
+func (az *azureAccount) accessToken(ctx context.Context) (string, error) {
+	az.mu.Lock()
+	defer az.mu.Unlock()
+	if az.token != "" && time.Until(az.expires) > cloudTokenSlack {
+		return az.token, nil
+	}
+	tok, err := azureLogin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("azure credentials: %w", err)
+	}
+	az.token, az.expires = tok.AccessToken, tok.expiry(time.Now())
+	return az.token, nil
+}
+
+// azureLogin signs in as the service principal of AZURE_TENANT_ID and
+// AZURE_CLIENT_ID, with AZURE_CLIENT_SECRET or the federated token of
+// AKS workload identity, or else as the managed identity.
+func azureLogin(ctx context.Context) (oauthToken, error) {
+	tenant, client := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {client}, "scope": {azureManagement + "/.default"}}
+	switch {
+	case tenant == "" || client == "":
+		return azureManagedIdentityToken(ctx, client)
+	case os.Getenv("AZURE_CLIENT_SECRET") != "":
+		form.Set("client_secret", os.Getenv("AZURE_CLIENT_SECRET"))
+	case os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "":
+		assertion, err := os.ReadFile(os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+		if err != nil {
+			return oauthToken{}, err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	default:
+		return azureManagedIdentityToken(ctx, client)
+	}
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+	endpoint := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(ToolContextFrom(ctx).HTTPClient, req)
+}
+
+// azureManagedIdentityToken gets a token for the managed identity of an
+// App Service or Functions app, or else of the virtual machine, picking
+// the user-assigned identity clientID if given.
+func azureManagedIdentityToken(ctx context.Context, clientID string) (oauthToken, error) {
+	query := url.Values{"resource": {azureManagement + "/"}}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	endpoint, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER")
+	header, value := "X-IDENTITY-HEADER", secret
+	if endpoint != "" && secret != "" {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		endpoint, header, value = "http://169.254.169.254/metadata/identity/oauth2/token", "Metadata", "true"
+		query.Set("api-version", "2018-02-01")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set(header, value)
+	tok, err := fetchToken(metadataClient, req)
+	if err != nil {
+		return tok, fmt.Errorf("no service principal in the environment, and no managed identity: %w", err)
+	}
+	return tok, nil
+}
+
+// armList fetches a page of a Resource Manager listing: the first from
+// first, a path with its query, and later ones from the nextLink the
+// previous page gave.
+func (az *azureAccount) armList(ctx context.Context, first, token string, out interface{}) error {
+	link := azureManagement + "/subscriptions/" + url.PathEscape(az.account.azureSubscription()) + first
+	if token != "" {
+		if !strings.HasPrefix(token, azureManagement+"/") {
+			return errors.New("invalid page_token")
+		}
+		link = token
+	}
+	access, err := az.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	return getJSON(ctx, link, access, out)
+}
+
+// armResource is the part of a Resource Manager resource all listings
+// summarize.
+type armResource struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Location string            `json:"location"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// resourceGroup returns the resource group in the resource's ID.
+func (r armResource) resourceGroup() string {
+	parts := strings.Split(r.ID, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func (az *azureAccount) instances(ctx context.Context, region, token string, size int) (cloudPage, error) {
+	var reply struct {
+		Value []struct {
+			armResource
+			Zones      []string `json:"zones"`
+			Properties struct {
+				VMID     string `json:"vmId"`
+				Hardware struct {
+					Size string `json:"vmSize"`
+				} `json:"hardwareProfile"`
+				InstanceView struct {
+					Statuses []struct {
+						Code string `json:"code"`
+					} `json:"statuses"`
+				} `json:"instanceView"`
+				TimeCreated string `json:"timeCreated"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+	// Listing with statusOnly includes each machine's power state.
+	if err := az.armList(ctx, "/providers/Microsoft.Compute/virtualMachines?api-version=2024-07-01&statusOnly=true", token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextLink}
+	for _, vm := range reply.Value {
+		state := ""
+		for _, st := range vm.Properties.InstanceView.Statuses {
+			if power, ok := strings.CutPrefix(st.Code, "PowerState/"); ok {
+				state = power
+			}
+		}
+		zone := vm.Location
+		if len(vm.Zones) > 0 {
+			zone += "-" + vm.Zones[0]
+		}
+		page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+			"id":             vm.Properties.VMID,
+			"name":           vm.Name,
+			"resource_group": vm.resourceGroup(),
+			"state":          state,
+			"type":           vm.Properties.Hardware.Size,
+			"zone":           zone,
+			"launched":       vm.Properties.TimeCreated,
+		}), vm.Tags))
+	}
+	return page, nil
+}
+
+func (az *azureAccount) buckets(ctx context.Context, token string, size int) (cloudPage, error) {
+	var reply struct {
+		Value []struct {
+			armResource
+			Kind string `json:"kind"`
+			SKU  struct {
+				Name string `json:"name"`
+			} `json:"sku"`
+			Properties struct {
+				CreationTime string `json:"creationTime"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+	if err := az.armList(ctx, "/providers/Microsoft.Storage/storageAccounts?api-version=2023-05-01", token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextLink}
+	for _, sa := range reply.Value {
+		page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+			"name":           sa.Name,
+			"resource_group": sa.resourceGroup(),
+			"location":       sa.Location,
+			"kind":           sa.Kind,
+			"class":          sa.SKU.Name,
+			"created":        sa.Properties.CreationTime,
+		}), sa.Tags))
+	}
+	return page, nil
+}
+
+func (az *azureAccount) dnsZones(ctx context.Context, token string, size int) (cloudPage, error) {
+	var reply struct {
+		Value []struct {
+			armResource
+			Properties struct {
+				Records int `json:"numberOfRecordSets"`
+			} `json:"properties"`
+		} `json:"value"`
+		NextLink string `json:"nextLink"`
+	}
+	first := "/providers/Microsoft.Network/dnszones?api-version=2018-05-01&$top=" + strconv.Itoa(min(size, 100))
+	if err := az.armList(ctx, first, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextLink}
+	for _, z := range reply.Value {
+		page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+			"id":      z.resourceGroup() + "/" + z.Name,
+			"name":    z.Name,
+			"records": z.Properties.Records,
+		}), z.Tags))
+	}
+	return page, nil
+}
+
+// azureRecordSet is a DNS record set with the data of every record type.
+type azureRecordSet struct {
+	armResource
+	Properties struct {
+		FQDN string `json:"fqdn"`
+		TTL  int    `json:"TTL"`
+		A    []struct {
+			IP string `json:"ipv4Address"`
+		} `json:"ARecords"`
+		AAAA []struct {
+			IP string `json:"ipv6Address"`
+		} `json:"AAAARecords"`
+		CNAME *struct {
+			CNAME string `json:"cname"`
+		} `json:"CNAMERecord"`
+		MX []struct {
+			Preference int    `json:"preference"`
+			Exchange   string `json:"exchange"`
+		} `json:"MXRecords"`
+		NS []struct {
+			Host string `json:"nsdname"`
+		} `json:"NSRecords"`
+		PTR []struct {
+			Host string `json:"ptrdname"`
+		} `json:"PTRRecords"`
+		TXT []struct {
+			Value []string `json:"value"`
+		} `json:"TXTRecords"`
+		SRV []struct {
+			Priority int    `json:"priority"`
+			Weight   int    `json:"weight"`
+			Port     int    `json:"port"`
+			Target   string `json:"target"`
+		} `json:"SRVRecords"`
+		CAA []struct {
+			Flags int    `json:"flags"`
+			Tag   string `json:"tag"`
+			Value string `json:"value"`
+		} `json:"caaRecords"`
+		SOA *struct {
+			Host  string `json:"host"`
+			Email string `json:"email"`
+		} `json:"SOARecord"`
+		Target *struct {
+			ID string `json:"id"`
+		} `json:"targetResource"`
+	} `json:"properties"`
+}
+
+// values returns the record data written as in a zone file.
+func (r azureRecordSet) values() []string {
+	p := r.Properties
+	var out []string
+	for _, a := range p.A {
+		out = append(out, a.IP)
+	}
+	for _, a := range p.AAAA {
+		out = append(out, a.IP)
+	}
+	if p.CNAME != nil {
+		out = append(out, p.CNAME.CNAME)
+	}
+	for _, mx := range p.MX {
+		out = append(out, fmt.Sprintf("%d %s", mx.Preference, mx.Exchange))
+	}
+	for _, ns := range p.NS {
+		out = append(out, ns.Host)
+	}
+	for _, ptr := range p.PTR {
+		out = append(out, ptr.Host)
+	}
+	for _, txt := range p.TXT {
+		out = append(out, strconv.Quote(strings.Join(txt.Value, "")))
+	}
+	for _, srv := range p.SRV {
+		out = append(out, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target))
+	}
+	for _, caa := range p.CAA {
+		out = append(out, fmt.Sprintf("%d %s %q", caa.Flags, caa.Tag, caa.Value))
+	}
+	if p.SOA != nil {
+		out = append(out, p.SOA.Host+" "+p.SOA.Email)
+	}
+	return out
+}
+
+func (az *azureAccount) dnsRecords(ctx context.Context, zone, token string, size int) (cloudPage, error) {
+	group, name, ok := strings.Cut(zone, "/")
+	if !ok || !azureResourceGroup.MatchString(group) || !azureZoneName.MatchString(name) {
+		return cloudPage{}, fmt.Errorf("invalid zone %q; use resource-group/zone-name, as the zone listing gives", zone)
+	}
+	var reply struct {
+		Value    []azureRecordSet `json:"value"`
+		NextLink string           `json:"nextLink"`
+	}
+	first := "/resourceGroups/" + url.PathEscape(group) + "/providers/Microsoft.Network/dnsZones/" + name +
+		"/all?api-version=2018-05-01&$top=" + strconv.Itoa(min(size, 100))
+	if err := az.armList(ctx, first, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextLink}
+	for _, r := range reply.Value {
+		alias := ""
+		if r.Properties.Target != nil {
+			alias = r.Properties.Target.ID
+		}
+		page.items = append(page.items, withValues(cloudItem(map[string]interface{}{
+			"name":  r.Properties.FQDN,
+			"type":  r.Type[strings.LastIndex(r.Type, "/")+1:],
+			"ttl":   r.Properties.TTL,
+			"alias": alias,
+		}), r.values()))
+	}
+	return page, nil
+}
@@ -0,0 +1,390 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcpAccount lists Compute Engine instances, Cloud Storage buckets and
+// Cloud DNS zones of a project.
+type gcpAccount struct {
+	account CloudAccount
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	project string
+}
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform.read-only"
+
+var gcpZoneName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+
+// gcpCredentialsFile is an application default credentials file: a
+// service account key, or what gcloud auth application-default login
+// writes.
+type gcpCredentialsFile struct {
+	Type           string `json:"type"`
+	ProjectID      string `json:"project_id"`
+	QuotaProjectID string `json:"quota_project_id"`
+	ClientEmail    string `json:"client_email"`
+	PrivateKey     string `json:"private_key"`
+	TokenURI       string `json:"token_uri"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	RefreshToken   string `json:"refresh_token"`
+}
+
+func gcpCredentialsPath() string {
+	if p := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); p != "" {
+		return p
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	switch {
+	case dir != "":
+	case runtime.GOOS == "windows":
+		dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	case homeDir() != "":
+		dir = filepath.Join(homeDir(), ".config", "gcloud")
+	default:
+		return ""
+	}
+	return filepath.Join(dir, "application_default_credentials.json")
+}
+
+// session returns an access token and the project to list, if known,
+// logging in again when the token is about to expire.
+func (g *gcpAccount) session(ctx context.Context) (string, string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Until(g.expires) > cloudTokenSlack {
+		return g.token, g.project, nil
+	}
+	tok, project, err := g.login(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("gcp credentials: %w", err)
+	}
+	if g.account.Project != "" {
+		project = g.account.Project
+	} else if p := os.Getenv("GOOGLE_CLOUD_PROJECT"); p != "" {
+		project = p
+	}
+	g.token, g.expires, g.project = tok.AccessToken, tok.expiry(time.Now()), project
+	return g.token, g.project, nil
+}
+
+// login uses the application default credentials file if there is one,
+// and the metadata server otherwise.
+func (g *gcpAccount) login(ctx context.Context) (oauthToken, string, error) {
+	file := gcpCredentialsPath()
+	data, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		var f gcpCredentialsFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return oauthToken{}, "", fmt.Errorf("%s: %w", file, err)
+		}
+		tok, err := f.token(ctx)
+		project := f.ProjectID
+		if project == "" {
+			project = f.QuotaProjectID
+		}
+		return tok, project, err
+	case os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" || !errors.Is(err, fs.ErrNotExist):
+		return oauthToken{}, "", err
+	}
+	return gcpMetadataToken(ctx)
+}
+
+func (f gcpCredentialsFile) token(ctx context.Context) (oauthToken, error) {
+	tokenURI := f.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+	var form url.Values
+	switch f.Type {
+	case "service_account":
+		assertion, err := f.assertion(tokenURI, time.Now())
+		if err != nil {
+			return oauthToken{}, err
+		}
+		form = url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	case "authorized_user":
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {f.ClientID},
+			"client_secret": {f.ClientSecret},
+			"refresh_token": {f.RefreshToken},
+		}
+	default:
+		return oauthToken{}, fmt.Errorf("credentials of type %q are not supported; use a service account key, gcloud auth application-default login or the metadata server", f.Type)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(ToolContextFrom(ctx).HTTPClient, req)
+}
+
+// assertion returns the JWT a service account trades for a token.
+func (f gcpCredentialsFile) assertion(audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return "", errors.New("the service account private_key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("service account private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the service account private_key is not an RSA key")
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   f.ClientEmail,
+		"scope": gcpScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// gcpMetadataToken gets the token of the default service account of the
+// instance, Cloud Run service or GKE workload, and its project.
+func gcpMetadataToken(ctx context.Context) (oauthToken, string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	get := func(p string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/"+p, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return cloudDo(metadataClient, req)
+	}
+	var tok oauthToken
+	data, err := get("instance/service-accounts/default/token")
+	if err != nil {
+		return tok, "", fmt.Errorf("no credentials file, and no metadata server: %w", err)
+	}
+	if err := json.Unmarshal(data, &tok); err != nil || tok.AccessToken == "" {
+		return tok, "", errors.New("metadata server: no access token in the reply")
+	}
+	project, _ := get("project/project-id")
+	return tok, strings.TrimSpace(string(project)), nil
+}
+
+// gcpList fetches a page of a Google API listing.
+func (g *gcpAccount) gcpList(ctx context.Context, endpoint string, query url.Values, token string, out interface{}) error {
+	access, _, err := g.session(ctx)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		query.Set("pageToken", token)
+	}
+	return getJSON(ctx, endpoint+"?"+query.Encode(), access, out)
+}
+
+func (g *gcpAccount) projectID(ctx context.Context) (string, error) {
+	_, project, err := g.session(ctx)
+	if err == nil && project == "" {
+		err = errors.New("the credentials name no project; set project")
+	}
+	return project, err
+}
+
+func (g *gcpAccount) projectPath(ctx context.Context) (string, error) {
+	project, err := g.projectID(ctx)
+	return url.PathEscape(project), err
+}
+
+type gcpInstance struct {
+	ID                string            `json:"id"`
+	Name              string            `json:"name"`
+	Status            string            `json:"status"`
+	MachineType       string            `json:"machineType"`
+	Zone              string            `json:"zone"`
+	CreationTimestamp string            `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+func (g *gcpAccount) instances(ctx context.Context, region, token string, size int) (cloudPage, error) {
+	project, err := g.projectPath(ctx)
+	if err != nil {
+		return cloudPage{}, err
+	}
+	var reply struct {
+		Items map[string]struct {
+			Instances []gcpInstance `json:"instances"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	query := url.Values{"maxResults": {strconv.Itoa(min(size, 500))}, "returnPartialSuccess": {"true"}}
+	if err := g.gcpList(ctx, "https://compute.googleapis.com/compute/v1/projects/"+project+"/aggregated/instances", query, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	scopes := make([]string, 0, len(reply.Items))
+	for scope := range reply.Items {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	page := cloudPage{next: reply.NextPageToken}
+	for _, scope := range scopes {
+		for _, in := range reply.Items[scope].Instances {
+			var privateIP, publicIP string
+			if len(in.NetworkInterfaces) > 0 {
+				nic := in.NetworkInterfaces[0]
+				privateIP = nic.NetworkIP
+				if len(nic.AccessConfigs) > 0 {
+					publicIP = nic.AccessConfigs[0].NatIP
+				}
+			}
+			page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+				"id":         in.ID,
+				"name":       in.Name,
+				"state":      strings.ToLower(in.Status),
+				"type":       path.Base(in.MachineType),
+				"zone":       path.Base(in.Zone),
+				"private_ip": privateIP,
+				"public_ip":  publicIP,
+				"launched":   in.CreationTimestamp,
+			}), in.Labels))
+		}
+	}
+	return page, nil
+}
+
+func (g *gcpAccount) buckets(ctx context.Context, token string, size int) (cloudPage, error) {
+	project, err := g.projectID(ctx)
+	if err != nil {
+		return cloudPage{}, err
+	}
+	var reply struct {
+		Items []struct {
+			Name         string            `json:"name"`
+			Location     string            `json:"location"`
+			StorageClass string            `json:"storageClass"`
+			TimeCreated  string            `json:"timeCreated"`
+			Labels       map[string]string `json:"labels"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	query := url.Values{"project": {project}, "maxResults": {strconv.Itoa(min(size, 1000))}, "projection": {"noAcl"}}
+	if err := g.gcpList(ctx, "https://storage.googleapis.com/storage/v1/b", query, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextPageToken}
+	for _, b := range reply.Items {
+		page.items = append(page.items, withTags(cloudItem(map[string]interface{}{
+			"name":     b.Name,
+			"location": strings.ToLower(b.Location),
+			"class":    b.StorageClass,
+			"created":  b.TimeCreated,
+		}), b.Labels))
+	}
+	return page, nil
+}
+
+func (g *gcpAccount) dnsZones(ctx context.Context, token string, size int) (cloudPage, error) {
+	project, err := g.projectPath(ctx)
+	if err != nil {
+		return cloudPage{}, err
+	}
+	var reply struct {
+		ManagedZones []struct {
+			Name        string `json:"name"`
+			DNSName     string `json:"dnsName"`
+			Visibility  string `json:"visibility"`
+			Description string `json:"description"`
+		} `json:"managedZones"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	query := url.Values{"maxResults": {strconv.Itoa(min(size, 1000))}}
+	if err := g.gcpList(ctx, "https://dns.googleapis.com/dns/v1/projects/"+project+"/managedZones", query, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextPageToken}
+	for _, z := range reply.ManagedZones {
+		page.items = append(page.items, cloudItem(map[string]interface{}{
+			"id":      z.Name,
+			"name":    z.DNSName,
+			"private": z.Visibility == "private",
+			"comment": z.Description,
+		}))
+	}
+	return page, nil
+}
+
+func (g *gcpAccount) dnsRecords(ctx context.Context, zone, token string, size int) (cloudPage, error) {
+	if !gcpZoneName.MatchString(zone) {
+		return cloudPage{}, fmt.Errorf("invalid zone %q; use the name of a managed zone", zone)
+	}
+	project, err := g.projectPath(ctx)
+	if err != nil {
+		return cloudPage{}, err
+	}
+	var reply struct {
+		Rrsets []struct {
+			Name    string   `json:"name"`
+			Type    string   `json:"type"`
+			TTL     int      `json:"ttl"`
+			Rrdatas []string `json:"rrdatas"`
+		} `json:"rrsets"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	query := url.Values{"maxResults": {strconv.Itoa(min(size, 1000))}}
+	if err := g.gcpList(ctx, "https://dns.googleapis.com/dns/v1/projects/"+project+"/managedZones/"+zone+"/rrsets", query, token, &reply); err != nil {
+		return cloudPage{}, err
+	}
+	page := cloudPage{next: reply.NextPageToken}
+	for _, r := range reply.Rrsets {
+		page.items = append(page.items, withValues(cloudItem(map[string]interface{}{
+			"name": r.Name,
+			"type": r.Type,
+			"ttl":  r.TTL,
+		}), r.Rrdatas))
+	}
+	return page, nil
+}
@@ -0,0 +1,700 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LanguageToolConfig is a formatter or linter of format_code and
+// lint_code. A preset name needs nothing else; other tools give the
+// command and, for linters, how to read its output.
+type LanguageToolConfig struct {
+	Name      string   `json:"name" schema:"required"`
+	Languages []string `json:"languages,omitempty"`
+	// Command reads the source on stdin and runs in the workspace root.
+	// "{path}" in an argument becomes the root-relative file path, which
+	// for inline content is made up from the language.
+	Command []string `json:"command,omitempty"`
+	// Output is how a linter reports: "lines" of file:line[:col]: message
+	// on stdout or stderr, or ruff's JSON.
+	Output  string   `json:"output,omitempty" schema:"enum=lines|ruff-json"`
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"` // default 30s
+}
+
+// The gofmt presets are built in, using go/format and go/parser; the
+// others run the named program.
+var (
+	formatterPresets = map[string]LanguageToolConfig{
+		"gofmt":     {Languages: []string{"go"}},
+		"goimports": {Languages: []string{"go"}, Command: []string{"goimports"}},
+		"prettier": {
+			Languages: []string{"javascript", "typescript", "css", "scss", "json", "markdown", "yaml", "html"},
+			Command:   []string{"prettier", "--stdin-filepath", "{path}"},
+		},
+		"ruff": {Languages: []string{"python"}, Command: []string{"ruff", "format", "--stdin-filename", "{path}", "-"}},
+	}
+	linterPresets = map[string]LanguageToolConfig{
+		"gofmt": {Languages: []string{"go"}},
+		"ruff": {
+			Languages: []string{"python"},
+			Command:   []string{"ruff", "check", "--output-format=json", "--no-fix", "--stdin-filename", "{path}", "-"},
+			Output:    "ruff-json",
+		},
+	}
+	defaultFormatters = []LanguageToolConfig{{Name: "gofmt"}}
+	defaultLinters    = []LanguageToolConfig{{Name: "gofmt"}}
+)
+
+// formatExts adds the languages only formatters know to languageByExt.
+var formatExts = map[string]string{
+	".css": "css", ".scss": "scss", ".json": "json", ".md": "markdown",
+	".yaml": "yaml", ".yml": "yaml", ".html": "html", ".htm": "html",
+}
+
+const (
+	defaultLanguageToolTimeout = 30 * time.Second
+	maxLintFiles               = 200
+	maxLintDiagnostics         = 500
+	// maxDiffCells bounds the line comparison of diffs; larger changes are
+	// shown as one replacement.
+	maxDiffCells = 4 << 20
+)
+
+func fileLanguage(rel string) string {
+	ext := strings.ToLower(path.Ext(rel))
+	if lang := languageByExt[ext]; lang != "" {
+		return lang
+	}
+	return formatExts[ext]
+}
+
+// inlineName makes up a file name for inline content in lang, for tools
+// that pick their rules by extension.
+func inlineName(lang string) string {
+	var exts []string
+	for _, m := range []map[string]string{languageByExt, formatExts} {
+		for ext, l := range m {
+			if l == lang {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	if len(exts) == 0 {
+		return "stdin"
+	}
+	// The shortest extension is the usual one: .js rather than .mjs.
+	sort.Slice(exts, func(i, j int) bool {
+		return len(exts[i]) < len(exts[j]) || len(exts[i]) == len(exts[j]) && exts[i] < exts[j]
+	})
+	return "stdin" + exts[0]
+}
+
+// withPreset fills in t from presets.
+func (t LanguageToolConfig) withPreset(presets map[string]LanguageToolConfig) LanguageToolConfig {
+	preset := presets[t.Name]
+	if t.Languages == nil {
+		t.Languages = preset.Languages
+	}
+	if t.Command == nil {
+		t.Command = preset.Command
+	}
+	if t.Output == "" {
+		t.Output = preset.Output
+	}
+	if t.Output == "" {
+		t.Output = "lines"
+	}
+	return t
+}
+
+// builtin reports whether t is done in process.
+func (t LanguageToolConfig) builtin() bool {
+	return t.Name == "gofmt" && len(t.Command) == 0
+}
+
+func checkLanguageTools(kind string, tools []LanguageToolConfig, presets map[string]LanguageToolConfig) error {
+	for _, t := range tools {
+		if t.Name == "" {
+			return fmt.Errorf("%s need a name", kind)
+		}
+		if _, ok := presets[t.Name]; !ok && len(t.Command) == 0 {
+			return fmt.Errorf("%s %q: no such preset; give a command", kind, t.Name)
+		}
+		t = t.withPreset(presets)
+		if len(t.Languages) == 0 {
+			return fmt.Errorf("%s %q: no languages", kind, t.Name)
+		}
+		if t.Output != "lines" && t.Output != "ruff-json" {
+			return fmt.Errorf("%s %q: unknown output %q", kind, t.Name, t.Output)
+		}
+	}
+	return nil
+}
+
+// formatter returns the first formatter for lang.
+func (c WorkspaceConfig) formatter(lang string) (LanguageToolConfig, bool) {
+	tools := c.Formatters
+	if tools == nil {
+		tools = defaultFormatters
+	}
+	for _, t := range tools {
+		t = t.withPreset(formatterPresets)
+		for _, l := range t.Languages {
+			if l == lang {
+				return t, true
+			}
+		}
+	}
+	return LanguageToolConfig{}, false
+}
+
+// linters returns every linter for lang.
+func (c WorkspaceConfig) linters(lang string) []LanguageToolConfig {
+	tools := c.Linters
+	if tools == nil {
+		tools = defaultLinters
+	}
+	var out []LanguageToolConfig
+	for _, t := range tools {
+		t = t.withPreset(linterPresets)
+		for _, l := range t.Languages {
+			if l == lang {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
+
+type formatCodeArgs struct {
+	Root     string `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path     string `json:"path,omitempty" jsonschema:"description=Root-relative file to format"`
+	Content  string `json:"content,omitempty" jsonschema:"description=Source to format instead of a file"`
+	Language string `json:"language,omitempty" jsonschema:"description=Language of content; defaults to the one of path's extension"`
+	Write    bool   `json:"write,omitempty" jsonschema:"description=Save the formatted file; only in writable roots"`
+}
+
+type lintCodeArgs struct {
+	Root     string `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path     string `json:"path,omitempty" jsonschema:"description=Root-relative file or directory to lint"`
+	Content  string `json:"content,omitempty" jsonschema:"description=Source to lint instead of files"`
+	Language string `json:"language,omitempty" jsonschema:"description=Language of content; defaults to the one of path's extension"`
+}
+
+// lintDiagnostic is a problem a linter or formatter found.
+type lintDiagnostic struct {
+	Path      string `json:"path,omitempty"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Severity  string `json:"severity"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	Source    string `json:"source"`
+}
+
+// codeSource is the input of format_code and lint_code: a workspace file
+// or inline content.
+type codeSource struct {
+	root WorkspaceRoot
+	dir  string // where tools run; empty for inline content without a root
+	rel  string
+	full string
+	lang string
+	src  []byte
+}
+
+func (s *MCPServer) codeSource(rootName, rel, content, lang string) (*codeSource, error) {
+	if (rel == "") == (content == "") {
+		return nil, errors.New("give either path or content")
+	}
+	cs := &codeSource{lang: lang}
+	r, err := s.cfg.Workspace.root(rootName)
+	if content != "" {
+		if lang == "" {
+			return nil, errors.New("language is required with content")
+		}
+		if err == nil {
+			cs.root, cs.dir = r, r.Path
+		}
+		cs.rel, cs.src = inlineName(lang), []byte(content)
+		return cs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cs.root, cs.dir = r, r.Path
+	cs.rel = strings.Trim(path.Clean("/"+filepath.ToSlash(rel)), "/")
+	if cs.full, err = r.resolve(cs.rel); err != nil {
+		return nil, err
+	}
+	if cs.lang == "" {
+		cs.lang = fileLanguage(cs.rel)
+	}
+	return cs, nil
+}
+
+func (cs *codeSource) read() error {
+	if cs.full == "" {
+		return nil
+	}
+	src, err := os.ReadFile(cs.full)
+	if err != nil {
+		return err
+	}
+	if bytes.IndexByte(src, 0) >= 0 {
+		return fmt.Errorf("%s: binary file", cs.rel)
+	}
+	cs.src = src
+	return nil
+}
+
+func (s *MCPServer) formatCodeTool(ctx context.Context, args formatCodeArgs) (map[string]interface{}, error) {
+	cs, err := s.codeSource(args.Root, args.Path, args.Content, args.Language)
+	if err != nil {
+		return nil, err
+	}
+	if args.Write {
+		if cs.full == "" {
+			return nil, errors.New("write needs a path")
+		}
+		if !cs.root.Writable {
+			return nil, fmt.Errorf("workspace root %q is not writable", cs.root.Name)
+		}
+		for p := cs.rel; p != "."; p = path.Dir(p) {
+			if s.cfg.Workspace.excluded(p) {
+				return nil, fmt.Errorf("%s is excluded from the workspace", cs.rel)
+			}
+		}
+		// Hold off apply_patch between reading and writing the file.
+		patchMu.Lock()
+		defer patchMu.Unlock()
+	}
+	if err := cs.read(); err != nil {
+		return nil, err
+	}
+	f, ok := s.cfg.Workspace.formatter(cs.lang)
+	if !ok {
+		return nil, fmt.Errorf("no formatter for %s", languageName(cs.lang))
+	}
+	result := map[string]interface{}{"language": cs.lang, "formatter": f.Name}
+	if cs.full != "" {
+		result["path"] = cs.rel
+	}
+	formatted, diags, err := formatSource(ctx, s.commands, f, cs)
+	if err != nil {
+		result["changed"] = false
+		result["error"] = err.Error()
+		result["diagnostics"] = diags
+		return result, nil
+	}
+	// Formatters write \n; keep a file's \r\n.
+	if bytes.Contains(cs.src, []byte("\r\n")) && !bytes.Contains(formatted, []byte("\r\n")) {
+		formatted = bytes.ReplaceAll(formatted, []byte("\n"), []byte("\r\n"))
+	}
+	changed := !bytes.Equal(cs.src, formatted)
+	result["changed"] = changed
+	result["diagnostics"] = []lintDiagnostic{}
+	result["diff"] = ""
+	if changed {
+		result["diff"] = unifiedDiff(cs.rel, string(cs.src), string(formatted))
+	}
+	if cs.full == "" {
+		result["content"] = string(formatted)
+	}
+	if args.Write {
+		result["written"] = false
+		if changed {
+			if err := writeFileAtomic(cs.full, formatted); err != nil {
+				return nil, err
+			}
+			s.code.invalidate(cs.root.Name)
+			result["written"] = true
+		}
+	}
+	return result, nil
+}
+
+func languageName(lang string) string {
+	if lang == "" {
+		return "files of this type"
+	}
+	return lang
+}
+
+// formatSource runs formatter f on cs. When it fails, the diagnostics are
+// the problems it reported.
+func formatSource(ctx context.Context, cmds *workspaceCommands, f LanguageToolConfig, cs *codeSource) ([]byte, []lintDiagnostic, error) {
+	if f.builtin() {
+		out, err := format.Source(cs.src)
+		if err != nil {
+			return nil, goSyntaxDiagnostics(err, cs, f.Name), fmt.Errorf("%s: %v", f.Name, firstLine(err.Error()))
+		}
+		return out, nil, nil
+	}
+	stdout, stderr, exitCode, err := runLanguageTool(ctx, cmds, f, cs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if exitCode != 0 {
+		lines := strings.Split(string(stderr)+"\n"+string(stdout), "\n")
+		msg := strings.TrimSpace(string(stderr))
+		if msg == "" {
+			msg = fmt.Sprintf("exit status %d", exitCode)
+		}
+		return nil, lineDiagnostics(lines, cs, f.Name), fmt.Errorf("%s: %s", f.Name, firstLine(msg))
+	}
+	return stdout, nil, nil
+}
+
+func (s *MCPServer) lintCodeTool(ctx context.Context, args lintCodeArgs) (map[string]interface{}, error) {
+	cs, err := s.codeSource(args.Root, args.Path, args.Content, args.Language)
+	if err != nil {
+		return nil, err
+	}
+	sources := []*codeSource{cs}
+	truncated := false
+	if st, err := os.Stat(cs.full); cs.full != "" && err == nil && st.IsDir() {
+		sources = nil
+		err := s.cfg.Workspace.walk(cs.root, func(rel, full string) error {
+			if cs.rel != "" && !strings.HasPrefix(rel, cs.rel+"/") {
+				return nil
+			}
+			lang := fileLanguage(rel)
+			if len(s.cfg.Workspace.linters(lang)) == 0 {
+				return nil
+			}
+			if len(sources) == maxLintFiles {
+				truncated = true
+				return filepath.SkipAll
+			}
+			sources = append(sources, &codeSource{root: cs.root, dir: cs.dir, rel: rel, full: full, lang: lang})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if len(s.cfg.Workspace.linters(cs.lang)) == 0 {
+		return nil, fmt.Errorf("no linter for %s", languageName(cs.lang))
+	}
+
+	diags := []lintDiagnostic{}
+	used := map[string]bool{}
+	for _, src := range sources {
+		if err := src.read(); err != nil {
+			return nil, err
+		}
+		for _, l := range s.cfg.Workspace.linters(src.lang) {
+			found, err := lintSource(ctx, s.commands, l, src)
+			if err != nil {
+				return nil, err
+			}
+			used[l.Name] = true
+			diags = append(diags, found...)
+		}
+		if len(diags) >= maxLintDiagnostics {
+			diags, truncated = diags[:maxLintDiagnostics], true
+			break
+		}
+	}
+	linters := make([]string, 0, len(used))
+	for name := range used {
+		linters = append(linters, name)
+	}
+	sort.Strings(linters)
+	return map[string]interface{}{
+		"diagnostics": diags,
+		"files":       len(sources),
+		"linters":     linters,
+		"truncated":   truncated,
+	}, nil
+}
+
+// lintSource runs linter l on cs.
+func lintSource(ctx context.Context, cmds *workspaceCommands, l LanguageToolConfig, cs *codeSource) ([]lintDiagnostic, error) {
+	if l.builtin() {
+		_, err := parser.ParseFile(token.NewFileSet(), cs.rel, cs.src, parser.AllErrors)
+		return goSyntaxDiagnostics(err, cs, l.Name), nil
+	}
+	stdout, stderr, exitCode, err := runLanguageTool(ctx, cmds, l, cs)
+	if err != nil {
+		return nil, err
+	}
+	if l.Output == "ruff-json" {
+		var found []struct {
+			Code     *string
+			Message  string
+			Location struct {
+				Row    int `json:"row"`
+				Column int `json:"column"`
+			} `json:"location"`
+			EndLocation struct {
+				Row    int `json:"row"`
+				Column int `json:"column"`
+			} `json:"end_location"`
+		}
+		if err := json.Unmarshal(stdout, &found); err != nil {
+			return nil, fmt.Errorf("%s: exit status %d: %s", l.Name, exitCode, firstLine(strings.TrimSpace(string(stderr))))
+		}
+		diags := make([]lintDiagnostic, 0, len(found))
+		for _, f := range found {
+			d := lintDiagnostic{
+				Path: cs.path(), Line: f.Location.Row, Column: f.Location.Column,
+				EndLine: f.EndLocation.Row, EndColumn: f.EndLocation.Column,
+				Severity: "warning", Message: f.Message, Source: l.Name,
+			}
+			if f.Code != nil {
+				d.Code = *f.Code
+			} else {
+				d.Severity = "error" // syntax errors have no rule
+			}
+			diags = append(diags, d)
+		}
+		return diags, nil
+	}
+	lines := strings.Split(string(stdout)+"\n"+string(stderr), "\n")
+	return lineDiagnostics(lines, cs, l.Name), nil
+}
+
+// path is how diagnostics name cs: the file, or nothing for content.
+func (cs *codeSource) path() string {
+	if cs.full == "" {
+		return ""
+	}
+	return cs.rel
+}
+
+// runLanguageTool runs the command of t with cs on stdin.
+func runLanguageTool(ctx context.Context, cmds *workspaceCommands, t LanguageToolConfig, cs *codeSource) (stdout, stderr []byte, exitCode int, err error) {
+	timeout := defaultLanguageToolTimeout
+	if t.Timeout > 0 {
+		timeout = time.Duration(t.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	argv := make([]string, len(t.Command))
+	for i, a := range t.Command {
+		argv[i] = strings.ReplaceAll(a, "{path}", filepath.FromSlash(cs.rel))
+	}
+	cmd, err := cmds.command(ctx, cs.root.Path, false, cs.dir, argv[0], argv[1:])
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	cmd.Stdin = bytes.NewReader(cs.src)
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &limitedWriter{w: &out, n: maxBackendOutput}
+	cmd.Stderr = &limitedWriter{w: &errOut, n: maxBackendOutput}
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, 0, fmt.Errorf("%s did not finish within %v", t.Name, timeout)
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return out.Bytes(), errOut.Bytes(), exit.ExitCode(), nil
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%s: %w", t.Name, err)
+	}
+	return out.Bytes(), errOut.Bytes(), 0, nil
+}
+
+func goSyntaxDiagnostics(err error, cs *codeSource, source string) []lintDiagnostic {
+	diags := []lintDiagnostic{}
+	var list scanner.ErrorList
+	if !errors.As(err, &list) {
+		return diags
+	}
+	for _, e := range list {
+		diags = append(diags, lintDiagnostic{
+			Path: cs.path(), Line: e.Pos.Line, Column: e.Pos.Column,
+			Severity: "error", Code: "syntax", Message: e.Msg, Source: source,
+		})
+	}
+	return diags
+}
+
+// lineDiagnostics reads file:line[:col]: message lines, the form most
+// tools print, as problems in cs.
+func lineDiagnostics(lines []string, cs *codeSource, source string) []lintDiagnostic {
+	diags := []lintDiagnostic{}
+	for _, d := range parseDiagnostics(lines) {
+		severity, msg := "warning", d.Message
+		for _, level := range []string{"error", "warning", "note", "info"} {
+			if rest, ok := strings.CutPrefix(msg, level+":"); ok {
+				severity, msg = level, strings.TrimSpace(rest)
+				break
+			}
+		}
+		if severity == "note" {
+			severity = "info"
+		}
+		diags = append(diags, lintDiagnostic{
+			Path: cs.path(), Line: d.Line, Column: d.Column,
+			Severity: severity, Message: msg, Source: source,
+		})
+	}
+	return diags
+}
+
+func firstLine(s string) string {
+	first, _, _ := strings.Cut(s, "\n")
+	return first
+}
+
+// unifiedDiff returns the changes from a to b as a unified diff of name
+// with three lines of context, as apply_patch reads it. Line endings are
+// compared as \n.
+func unifiedDiff(name, a, b string) string {
+	al, bl := diffSplit(a), diffSplit(b)
+	ops := diffLines(al, bl)
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+	// aAt and bAt count the lines of a and b before each op.
+	aAt, bAt := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aAt[i+1], bAt[i+1] = aAt[i], bAt[i]
+		if op.kind != '+' {
+			aAt[i+1]++
+		}
+		if op.kind != '-' {
+			bAt[i+1]++
+		}
+	}
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk over changes less than two contexts apart.
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+		start, stop := max(i-context, 0), min(end+context, len(ops))
+		aStart, aCount := aAt[start]+1, aAt[stop]-aAt[start]
+		bStart, bCount := bAt[start]+1, bAt[stop]-bAt[start]
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:stop] {
+			text, noNewline := strings.CutSuffix(op.text, "\x00")
+			out.WriteByte(op.kind)
+			out.WriteString(text)
+			out.WriteByte('\n')
+			if noNewline {
+				out.WriteString("\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// diffSplit splits s into lines. A last line without a newline is marked
+// with a trailing NUL, so it differs from the same line with one.
+func diffSplit(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	noNewline := !strings.HasSuffix(s, "\n")
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if noNewline {
+		lines[len(lines)-1] += "\x00"
+	}
+	return lines
+}
+
+// diffLines finds a shortest edit script from a to b by longest common
+// subsequence, after setting aside the common prefix and suffix.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:pre] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(am)+1)*(len(bm)+1) > maxDiffCells {
+		for _, line := range am {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range bm {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the common length of am[i:] and bm[j:].
+		w := len(bm) + 1
+		lcs := make([]int32, (len(am)+1)*w)
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else {
+					lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(am) || j < len(bm) {
+			switch {
+			case i < len(am) && j < len(bm) && am[i] == bm[j]:
+				ops = append(ops, diffOp{' ', am[i]})
+				i, j = i+1, j+1
+			case j == len(bm) || i < len(am) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+				ops = append(ops, diffOp{'-', am[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', bm[j]})
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
@@ -0,0 +1,673 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// codeSymbol is one definition found in a workspace file.
+type codeSymbol struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Container string `json:"container,omitempty"`
+	Language  string `json:"language"`
+	Root      string `json:"root"`
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// rootIndex is the symbol index of one workspace root. files maps the
+// source files found to their language.
+type rootIndex struct {
+	root    WorkspaceRoot
+	symbols []codeSymbol
+	files   map[string]string
+	built   time.Time
+	took    time.Duration
+	failed  int
+}
+
+// codeIndex holds an index per workspace root, built on first use and
+// rebuilt by index_workspace.
+type codeIndex struct {
+	cfg   WorkspaceConfig
+	cmds  *workspaceCommands
+	mu    sync.Mutex
+	roots map[string]*rootIndex
+}
+
+func newCodeIndex(cfg WorkspaceConfig, cmds *workspaceCommands) *codeIndex {
+	return &codeIndex{cfg: cfg, cmds: cmds, roots: map[string]*rootIndex{}}
+}
+
+const maxSignatureLen = 200
+
+var languageByExt = map[string]string{
+	".go": "go", ".py": "python", ".pyi": "python",
+	".js": "javascript", ".jsx": "javascript", ".mjs": "javascript", ".cjs": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".mts": "typescript", ".cts": "typescript",
+	".rs": "rust", ".java": "java", ".kt": "kotlin", ".kts": "kotlin", ".cs": "csharp",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".cxx": "cpp", ".hpp": "cpp", ".hh": "cpp",
+	".rb": "ruby", ".php": "php", ".sh": "shell", ".bash": "shell",
+}
+
+// symbolPattern finds definitions on a single line. The "name" group is
+// the symbol; a "kind" group, when present, overrides kind, and a
+// "container" group names the enclosing type.
+type symbolPattern struct {
+	kind string
+	re   *regexp.Regexp
+}
+
+func compilePatterns(defs ...string) []symbolPattern {
+	out := make([]symbolPattern, 0, len(defs)/2)
+	for i := 0; i < len(defs); i += 2 {
+		out = append(out, symbolPattern{defs[i], regexp.MustCompile(defs[i+1])})
+	}
+	return out
+}
+
+var (
+	jsPatterns = compilePatterns(
+		"function", `^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(?P<name>[\w$]+)`,
+		"class", `^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+(?P<name>[\w$]+)`,
+		"function", `^\s*(?:export\s+)?(?:const|let|var)\s+(?P<name>[\w$]+)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|[\w$]+\s*=>)`,
+		"interface", `^\s*(?:export\s+)?interface\s+(?P<name>[\w$]+)`,
+		"type", `^\s*(?:export\s+)?type\s+(?P<name>[\w$]+)\s*(?:<[^>]*>)?\s*=`,
+		"enum", `^\s*(?:export\s+)?(?:const\s+)?enum\s+(?P<name>[\w$]+)`,
+		"method", `^\s+(?:(?:public|private|protected|static|async|readonly|override|get|set)\s+)*(?P<name>[\w$]+)\s*\([^)]*\)\s*(?::\s*[^{]+)?\{\s*$`,
+	)
+	symbolPatterns = map[string][]symbolPattern{
+		"python": compilePatterns(
+			"class", `^\s*class\s+(?P<name>\w+)`,
+			"function", `^\s*(?:async\s+)?def\s+(?P<name>\w+)`,
+		),
+		"javascript": jsPatterns,
+		"typescript": jsPatterns,
+		"rust": compilePatterns(
+			"function", `^\s*(?:pub(?:\([^)]*\))?\s+)?(?:const\s+)?(?:async\s+)?(?:unsafe\s+)?(?:extern\s+"[^"]*"\s+)?fn\s+(?P<name>\w+)`,
+			"type", `^\s*(?:pub(?:\([^)]*\))?\s+)?(?P<kind>struct|enum|trait|type|mod|union)\s+(?P<name>\w+)`,
+			"const", `^\s*(?:pub(?:\([^)]*\))?\s+)?(?P<kind>const|static)\s+(?:mut\s+)?(?P<name>\w+)\s*:`,
+			"macro", `^\s*macro_rules!\s*(?P<name>\w+)`,
+		),
+		"java": compilePatterns(
+			"class", `^\s*(?:(?:public|private|protected|static|final|abstract|sealed|non-sealed)\s+)*(?P<kind>class|interface|enum|record)\s+(?P<name>\w+)`,
+			"method", `^\s*(?:(?:public|private|protected|static|final|abstract|synchronized|native|default)\s+)*(?:<[^>]+>\s+)?[\w<>\[\],.?]+\s+(?P<name>\w+)\s*\([^;]*$`,
+		),
+		"csharp": compilePatterns(
+			"class", `^\s*(?:(?:public|private|protected|internal|static|sealed|abstract|partial|readonly|ref)\s+)*(?P<kind>class|interface|enum|record|struct)\s+(?P<name>\w+)`,
+			"method", `^\s*(?:(?:public|private|protected|internal|static|virtual|override|abstract|async|extern|unsafe|new|sealed)\s+)*(?:<[^>]+>\s+)?[\w<>\[\],.?]+\s+(?P<name>\w+)\s*(?:<[^>]+>)?\s*\([^;]*$`,
+		),
+		"kotlin": compilePatterns(
+			"class", `^\s*(?:(?:public|private|protected|internal|data|sealed|abstract|open|inner|enum|annotation|value)\s+)*(?P<kind>class|interface|object)\s+(?P<name>\w+)`,
+			"function", `^\s*(?:(?:public|private|protected|internal|override|open|suspend|inline|operator|infix|tailrec|abstract|final)\s+)*fun\s+(?:<[^>]+>\s*)?(?:[\w.]+\.)?(?P<name>\w+)`,
+		),
+		"c": compilePatterns(
+			"function", `^(?:[\w*&<>,]+\s+)*?[*&]*(?P<name>[A-Za-z_]\w*)\s*\([^;]*$`,
+			"struct", `^\s*(?:typedef\s+)?(?P<kind>struct|enum|union)\s+(?P<name>\w+)\s*(?:\{|$)`,
+			"macro", `^\s*#\s*define\s+(?P<name>\w+)`,
+		),
+		"cpp": compilePatterns(
+			"function", `^(?:[\w*&<>,:]+\s+)*?[*&]*(?:(?P<container>\w+)::)?(?P<name>~?[A-Za-z_]\w*)\s*\([^;]*$`,
+			"class", `^\s*(?:typedef\s+)?(?:template\s*<[^>]*>\s*)?(?P<kind>class|struct|enum|union)\s+(?:class\s+)?(?P<name>\w+)\s*(?:[:{]|final|$)`,
+			"namespace", `^\s*namespace\s+(?P<name>\w+)`,
+			"macro", `^\s*#\s*define\s+(?P<name>\w+)`,
+		),
+		"ruby": compilePatterns(
+			"method", `^\s*def\s+(?:self\.)?(?P<name>\w+[?!=]?)`,
+			"class", `^\s*(?P<kind>class|module)\s+(?:[\w:]+::)?(?P<name>\w+)`,
+		),
+		"php": compilePatterns(
+			"function", `^\s*(?:(?:public|private|protected|static|abstract|final)\s+)*function\s+&?(?P<name>\w+)`,
+			"class", `^\s*(?:(?:abstract|final|readonly)\s+)*(?P<kind>class|interface|trait|enum)\s+(?P<name>\w+)`,
+		),
+		"shell": compilePatterns(
+			"function", `^\s*function\s+(?P<name>[\w-]+)`,
+			"function", `^\s*(?P<name>[\w-]+)\s*\(\)\s*\{?\s*$`,
+		),
+	}
+	// notSymbols are words the looser patterns would take for method or
+	// function names.
+	notSymbols = map[string]bool{
+		"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true,
+		"function": true, "with": true, "else": true, "new": true, "throw": true, "sizeof": true,
+		"do": true, "try": true, "foreach": true, "using": true, "lock": true, "synchronized": true,
+	}
+)
+
+// trimSignature is line trimmed to a readable length.
+func trimSignature(line string) string {
+	line = strings.TrimSpace(line)
+	if len(line) > maxSignatureLen {
+		line = line[:maxSignatureLen] + "…"
+	}
+	return line
+}
+
+// goSymbols parses a Go file. Files with syntax errors yield what parsed.
+func goSymbols(rel string, src []byte) ([]codeSymbol, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, rel, src, parser.SkipObjectResolution)
+	if f == nil {
+		return nil, err
+	}
+	lines := bytes.Split(src, []byte("\n"))
+	var out []codeSymbol
+	add := func(name *ast.Ident, kind, container string) {
+		if name == nil || name.Name == "_" {
+			return
+		}
+		line := fset.Position(name.Pos()).Line
+		out = append(out, codeSymbol{Name: name.Name, Kind: kind, Container: container, Language: "go", Path: rel, Line: line, Signature: trimSignature(string(lines[line-1]))})
+	}
+	for _, decl := range f.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List) == 0 {
+				add(d.Name, "function", "")
+				continue
+			}
+			add(d.Name, "method", receiverName(d.Recv.List[0].Type))
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					kind := "type"
+					switch t := s.Type.(type) {
+					case *ast.StructType:
+						kind = "struct"
+						for _, field := range t.Fields.List {
+							for _, name := range field.Names {
+								add(name, "field", s.Name.Name)
+							}
+						}
+					case *ast.InterfaceType:
+						kind = "interface"
+						for _, m := range t.Methods.List {
+							for _, name := range m.Names {
+								add(name, "method", s.Name.Name)
+							}
+						}
+					}
+					add(s.Name, kind, "")
+				case *ast.ValueSpec:
+					kind := "var"
+					if d.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, name := range s.Names {
+						add(name, kind, "")
+					}
+				}
+			}
+		}
+	}
+	return out, err
+}
+
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.IndexListExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// patternSymbols finds definitions in a file of another language with
+// symbolPatterns. Python methods get their class as container, from
+// indentation.
+func patternSymbols(lang, rel string, src []byte) []codeSymbol {
+	pats := symbolPatterns[lang]
+	var out []codeSymbol
+	type class struct {
+		indent int
+		name   string
+	}
+	var classes []class
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	sc.Buffer(make([]byte, 64<<10), defaultWorkspaceMaxFileBytes)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if lang == "python" && strings.TrimSpace(line) != "" {
+			for len(classes) > 0 && classes[len(classes)-1].indent >= indent {
+				classes = classes[:len(classes)-1]
+			}
+		}
+		for _, p := range pats {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			sym := codeSymbol{Kind: p.kind, Language: lang, Path: rel, Line: n, Signature: trimSignature(line)}
+			for i, group := range p.re.SubexpNames() {
+				switch group {
+				case "name":
+					sym.Name = m[i]
+				case "kind":
+					sym.Kind = m[i]
+				case "container":
+					sym.Container = m[i]
+				}
+			}
+			if notSymbols[sym.Name] {
+				continue
+			}
+			if lang == "python" {
+				if len(classes) > 0 && sym.Kind == "function" {
+					sym.Kind, sym.Container = "method", classes[len(classes)-1].name
+				}
+				if sym.Kind == "class" {
+					classes = append(classes, class{indent, sym.Name})
+				}
+			}
+			out = append(out, sym)
+			break
+		}
+	}
+	return out
+}
+
+// ctagsSymbols runs universal-ctags over files, relative to dir.
+func ctagsSymbols(ctx context.Context, cmds *workspaceCommands, ctags, dir string, files map[string]string) ([]codeSymbol, error) {
+	var list bytes.Buffer
+	for rel, lang := range files {
+		if lang != "go" {
+			list.WriteString(rel + "\n")
+		}
+	}
+	if list.Len() == 0 {
+		return nil, nil
+	}
+	cmd, err := cmds.command(ctx, dir, false, dir, ctags, []string{"--output-format=json", "--fields=+nKS", "--sort=no", "-f", "-", "-L", "-"})
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = &list
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ctags: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var syms []codeSymbol
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		var tag struct {
+			Type      string `json:"_type"`
+			Name      string `json:"name"`
+			Path      string `json:"path"`
+			Line      int    `json:"line"`
+			Kind      string `json:"kind"`
+			Scope     string `json:"scope"`
+			Pattern   string `json:"pattern"`
+			Signature string `json:"signature"`
+		}
+		if json.Unmarshal(line, &tag) != nil || tag.Type != "tag" || files[tag.Path] == "" {
+			continue
+		}
+		sig := strings.TrimSuffix(strings.TrimPrefix(tag.Pattern, "/^"), "$/")
+		if sig == "" {
+			sig = tag.Name + tag.Signature
+		}
+		syms = append(syms, codeSymbol{Name: tag.Name, Kind: tag.Kind, Container: tag.Scope, Language: files[tag.Path], Path: tag.Path, Line: tag.Line, Signature: trimSignature(sig)})
+	}
+	return syms, nil
+}
+
+// build indexes root r.
+func (x *codeIndex) build(ctx context.Context, r WorkspaceRoot) (*rootIndex, error) {
+	start := time.Now()
+	idx := &rootIndex{root: r, files: map[string]string{}}
+	err := x.cfg.walk(r, func(rel, full string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lang := languageByExt[strings.ToLower(path.Ext(rel))]
+		if lang == "" {
+			return nil
+		}
+		idx.files[rel] = lang
+		if lang != "go" && x.cfg.Ctags != "" {
+			return nil
+		}
+		src, err := os.ReadFile(full)
+		if err != nil {
+			idx.failed++
+			return nil
+		}
+		var syms []codeSymbol
+		if lang == "go" {
+			if syms, err = goSymbols(rel, src); err != nil {
+				idx.failed++
+			}
+		} else {
+			syms = patternSymbols(lang, rel, src)
+		}
+		idx.symbols = append(idx.symbols, syms...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if x.cfg.Ctags != "" {
+		syms, err := ctagsSymbols(ctx, x.cmds, x.cfg.Ctags, r.Path, idx.files)
+		if err != nil {
+			return nil, err
+		}
+		idx.symbols = append(idx.symbols, syms...)
+	}
+	for i := range idx.symbols {
+		idx.symbols[i].Root = r.Name
+	}
+	idx.built, idx.took = time.Now().UTC(), time.Since(start)
+	x.mu.Lock()
+	x.roots[r.Name] = idx
+	x.mu.Unlock()
+	return idx, nil
+}
+
+// invalidate drops the index of a root whose files changed; it is
+// rebuilt on next use.
+func (x *codeIndex) invalidate(root string) {
+	x.mu.Lock()
+	delete(x.roots, root)
+	x.mu.Unlock()
+}
+
+// get returns the indexes of the named roots, building missing ones.
+func (x *codeIndex) get(ctx context.Context, name string) ([]*rootIndex, error) {
+	roots, err := x.cfg.selectRoots(name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*rootIndex, 0, len(roots))
+	for _, r := range roots {
+		x.mu.Lock()
+		idx := x.roots[r.Name]
+		x.mu.Unlock()
+		if idx == nil {
+			if idx, err = x.build(ctx, r); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, idx)
+	}
+	return out, nil
+}
+
+type indexWorkspaceArgs struct {
+	Root string `json:"root,omitempty" jsonschema:"description=Workspace root to index (default: all)"`
+}
+
+type findSymbolArgs struct {
+	Name  string `json:"name" jsonschema:"required,minLength=1,description=Symbol name"`
+	Match string `json:"match,omitempty" jsonschema:"enum=exact|prefix|contains,description=How to match the name (default exact); prefix and contains ignore case"`
+	Kind  string `json:"kind,omitempty" jsonschema:"description=Only symbols of this kind\\, like function\\, method\\, class or struct"`
+	Root  string `json:"root,omitempty" jsonschema:"description=Only this workspace root"`
+	Limit int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=1000,description=Maximum results (default 50)"`
+}
+
+type findReferencesArgs struct {
+	Name  string `json:"name" jsonschema:"required,pattern=^[A-Za-z_$][A-Za-z0-9_$]*$,description=Identifier to find"`
+	Root  string `json:"root,omitempty" jsonschema:"description=Only this workspace root"`
+	Path  string `json:"path,omitempty" jsonschema:"description=Only files under this root-relative directory or file"`
+	Limit int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=1000,description=Maximum results (default 100)"`
+}
+
+// setupCodeTools registers the code navigation tools over the configured
+// workspace roots.
+func (s *MCPServer) setupCodeTools() {
+	s.code = newCodeIndex(s.cfg.Workspace, s.commands)
+	index, indexHandler, _ := typedTool("index_workspace", "Build or rebuild the symbol index of the workspace roots and report what it holds", s.indexWorkspaceTool)
+	index.Annotations = readOnlyAnnotations()
+	s.addTool(index, indexHandler)
+
+	find, findHandler, _ := typedTool("find_symbol", "Find where functions, types, classes and other symbols are defined in the workspace", s.findSymbolTool)
+	find.Annotations = readOnlyAnnotations()
+	s.addTool(find, findHandler)
+
+	refs, refsHandler, _ := typedTool("find_references", "Find the uses of an identifier across the workspace's source files", s.findReferencesTool)
+	refs.Annotations = readOnlyAnnotations()
+	s.addTool(refs, refsHandler)
+
+	search, searchHandler, _ := typedTool("code_search", "Search the workspace's files for a regular expression or literal text, with optional context lines", s.codeSearchTool)
+	search.Annotations = readOnlyAnnotations()
+	s.addTool(search, searchHandler)
+
+	format, formatHandler, _ := typedTool("format_code", "Format a workspace file or inline source with the language's formatter and return the diff; can save the result", s.formatCodeTool)
+	if !s.cfg.Workspace.writable() {
+		format.Annotations = readOnlyAnnotations()
+	}
+	s.addTool(format, formatHandler)
+
+	lint, lintHandler, _ := typedTool("lint_code", "Check workspace files, a directory or inline source with the language's linters and return their diagnostics", s.lintCodeTool)
+	lint.Annotations = readOnlyAnnotations()
+	s.addTool(lint, lintHandler)
+
+	if s.cfg.Workspace.writable() {
+		destructive := true
+		patch, patchHandler, _ := typedTool("apply_patch", "Change files in a writable workspace root with a unified diff or line range edits; all changes apply or none do", s.applyPatchTool)
+		patch.Annotations = &ToolAnnotations{DestructiveHint: &destructive}
+		s.addTool(patch, patchHandler)
+	}
+
+	if s.cfg.Workspace.Govulncheck != "" || s.cfg.Workspace.OSVScanner != "" {
+		scan, scanHandler, _ := typedTool("scan_dependencies", "Check a module or lockfile's dependencies for known vulnerabilities and report each with its severity and fixed versions", s.scanDependenciesTool)
+		scan.Annotations = readOnlyAnnotations()
+		s.addTool(scan, scanHandler)
+	}
+
+	if len(s.cfg.Workspace.Projects) > 0 {
+		build, buildHandler, _ := typedTool("run_build", "Run a configured project's build command and report its exit status, output and compiler diagnostics", s.runBuildTool)
+		s.addTool(build, buildHandler)
+
+		tests, testsHandler, _ := typedTool("run_tests", "Run a configured project's tests and report how many passed, failed and were skipped, with the output of each failure", s.runTestsTool)
+		s.addTool(tests, testsHandler)
+	}
+}
+
+func (s *MCPServer) indexWorkspaceTool(ctx context.Context, args indexWorkspaceArgs) (map[string]interface{}, error) {
+	roots, err := s.cfg.Workspace.selectRoots(args.Root)
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]interface{}
+	for _, r := range roots {
+		idx, err := s.code.build(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		languages := map[string]int{}
+		for _, lang := range idx.files {
+			languages[lang]++
+		}
+		kinds := map[string]int{}
+		for _, sym := range idx.symbols {
+			kinds[sym.Kind]++
+		}
+		out = append(out, map[string]interface{}{
+			"root": r.Name, "files": len(idx.files), "symbols": len(idx.symbols), "languages": languages,
+			"kinds": kinds, "unparsable": idx.failed, "tookMs": idx.took.Milliseconds(), "indexedAt": idx.built,
+		})
+	}
+	return map[string]interface{}{"roots": out}, nil
+}
+
+func (s *MCPServer) findSymbolTool(ctx context.Context, args findSymbolArgs) (map[string]interface{}, error) {
+	idxs, err := s.code.get(ctx, args.Root)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	lower := strings.ToLower(args.Name)
+	matches := func(name string) bool {
+		switch args.Match {
+		case "prefix":
+			return strings.HasPrefix(strings.ToLower(name), lower)
+		case "contains":
+			return strings.Contains(strings.ToLower(name), lower)
+		}
+		return name == args.Name
+	}
+	var found []codeSymbol
+	for _, idx := range idxs {
+		for _, sym := range idx.symbols {
+			if matches(sym.Name) && (args.Kind == "" || sym.Kind == args.Kind) {
+				found = append(found, sym)
+			}
+		}
+	}
+	// Exact names first, then by location.
+	sort.SliceStable(found, func(i, j int) bool {
+		ei, ej := found[i].Name == args.Name, found[j].Name == args.Name
+		if ei != ej {
+			return ei
+		}
+		if found[i].Root != found[j].Root {
+			return found[i].Root < found[j].Root
+		}
+		if found[i].Path != found[j].Path {
+			return found[i].Path < found[j].Path
+		}
+		return found[i].Line < found[j].Line
+	})
+	total := len(found)
+	if total > limit {
+		found = found[:limit]
+	}
+	if found == nil {
+		found = []codeSymbol{}
+	}
+	return map[string]interface{}{"symbols": found, "total": total, "truncated": total > limit}, nil
+}
+
+// codeReference is one use of an identifier.
+type codeReference struct {
+	Root       string `json:"root"`
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Text       string `json:"text"`
+	Definition bool   `json:"definition,omitempty"`
+}
+
+// findReferencesTool matches whole identifiers. In Go files only
+// identifier tokens count; elsewhere comments and strings match too.
+func (s *MCPServer) findReferencesTool(ctx context.Context, args findReferencesArgs) (map[string]interface{}, error) {
+	idxs, err := s.code.get(ctx, args.Root)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	under := strings.Trim(path.Clean("/"+args.Path), "/")
+	refs := []codeReference{}
+	total := 0
+	for _, idx := range idxs {
+		defs := map[string]bool{}
+		for _, sym := range idx.symbols {
+			if sym.Name == args.Name {
+				defs[fmt.Sprintf("%s:%d", sym.Path, sym.Line)] = true
+			}
+		}
+		r := idx.root
+		files := make([]string, 0, len(idx.files))
+		for rel := range idx.files {
+			if under == "" || rel == under || strings.HasPrefix(rel, under+"/") {
+				files = append(files, rel)
+			}
+		}
+		sort.Strings(files)
+		for _, rel := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			full, err := r.resolve(rel)
+			if err != nil {
+				continue
+			}
+			src, err := os.ReadFile(full)
+			if err != nil || !bytes.Contains(src, []byte(args.Name)) {
+				continue
+			}
+			for _, pos := range identifierPositions(idx.files[rel], src, args.Name) {
+				total++
+				if len(refs) < limit {
+					refs = append(refs, codeReference{Root: r.Name, Path: rel, Line: pos.line, Column: pos.col, Text: pos.text, Definition: defs[fmt.Sprintf("%s:%d", rel, pos.line)]})
+				}
+			}
+		}
+	}
+	return map[string]interface{}{"references": refs, "total": total, "truncated": total > limit}, nil
+}
+
+type identifierPos struct {
+	line, col int
+	text      string
+}
+
+// identifierPositions finds name as a whole identifier in src.
+func identifierPositions(lang string, src []byte, name string) []identifierPos {
+	var out []identifierPos
+	if lang == "go" {
+		fset := token.NewFileSet()
+		file := fset.AddFile("", fset.Base(), len(src))
+		var sc scanner.Scanner
+		sc.Init(file, src, nil, 0)
+		lines := bytes.Split(src, []byte("\n"))
+		for {
+			pos, tok, lit := sc.Scan()
+			if tok == token.EOF {
+				return out
+			}
+			if tok == token.IDENT && lit == name {
+				p := file.Position(pos)
+				out = append(out, identifierPos{p.Line, p.Column, trimSignature(string(lines[p.Line-1]))})
+			}
+		}
+	}
+	for n, line := range bytes.Split(src, []byte("\n")) {
+		for off := 0; ; {
+			i := bytes.Index(line[off:], []byte(name))
+			if i < 0 {
+				break
+			}
+			start, end := off+i, off+i+len(name)
+			if (start == 0 || !isIdentByte(line[start-1])) && (end == len(line) || !isIdentByte(line[end])) {
+				out = append(out, identifierPos{n + 1, start + 1, trimSignature(string(line))})
+			}
+			off = end
+		}
+	}
+	return out
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
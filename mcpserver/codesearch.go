@@ -0,0 +1,330 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type codeSearchArgs struct {
+	Query      string   `json:"query" jsonschema:"required,minLength=1,description=Text or regular expression to search for"`
+	Mode       string   `json:"mode,omitempty" jsonschema:"enum=regex|literal,description=How to read the query (default regex)"`
+	IgnoreCase bool     `json:"ignoreCase,omitempty" jsonschema:"description=Match regardless of case"`
+	WholeWord  bool     `json:"wholeWord,omitempty" jsonschema:"description=Only match whole words"`
+	Root       string   `json:"root,omitempty" jsonschema:"description=Only this workspace root"`
+	Path       string   `json:"path,omitempty" jsonschema:"description=Only under this root-relative directory or file"`
+	Globs      []string `json:"globs,omitempty" jsonschema:"description=File name globs like *.go; a leading ! excludes"`
+	Context    int      `json:"context,omitempty" jsonschema:"minimum=0,maximum=10,description=Lines of context before and after each match"`
+	Limit      int      `json:"limit,omitempty" jsonschema:"minimum=1,maximum=1000,description=Maximum matches (default 100)"`
+}
+
+// searchHit is a matching line.
+type searchHit struct {
+	Root   string   `json:"root"`
+	Path   string   `json:"path"`
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLineLen   = 500
+)
+
+// codeSearchTool searches the workspace with ripgrep when configured and
+// with a Go implementation otherwise. Both skip the workspace excludes,
+// large files and binary files, and neither reads .gitignore files, so
+// they find the same matches.
+func (s *MCPServer) codeSearchTool(ctx context.Context, args codeSearchArgs) (map[string]interface{}, error) {
+	pattern := args.Query
+	if args.Mode == "literal" {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if args.WholeWord {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+	if args.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	for _, g := range args.Globs {
+		if _, err := path.Match(strings.TrimPrefix(g, "!"), ""); err != nil {
+			return nil, fmt.Errorf("glob %q: %w", g, err)
+		}
+	}
+	roots, err := s.cfg.Workspace.selectRoots(args.Root)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	engine := "go"
+	if s.cfg.Workspace.Ripgrep != "" {
+		engine = "ripgrep"
+	}
+	hits := []searchHit{}
+	truncated, searched := false, 0
+	for _, r := range roots {
+		under := strings.Trim(path.Clean("/"+filepath.ToSlash(args.Path)), "/")
+		full, err := r.resolve(under)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(full); err != nil {
+			continue
+		}
+		searched++
+		var found []searchHit
+		if engine == "ripgrep" {
+			found, err = s.ripgrep(ctx, r, args, under, limit+1-len(hits))
+		} else {
+			found, err = s.cfg.Workspace.grep(ctx, r, re, args.Globs, under, limit+1-len(hits))
+		}
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, found...)
+		if len(hits) > limit {
+			hits, truncated = hits[:limit], true
+			break
+		}
+	}
+	if searched == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory", args.Path)
+	}
+	if args.Context > 0 {
+		addSearchContext(hits, roots, args.Context)
+	}
+	return map[string]interface{}{"matches": hits, "truncated": truncated, "engine": engine}, nil
+}
+
+// globsAllow applies code_search globs to the root-relative path rel:
+// excluding globs match the file or any directory above it; including
+// ones, when given, must match the file.
+func globsAllow(globs []string, rel string) bool {
+	included, anyInclude := false, false
+	for _, g := range globs {
+		if exclude := strings.HasPrefix(g, "!"); exclude {
+			for p := rel; p != "."; p = path.Dir(p) {
+				if globMatch(g[1:], p) {
+					return false
+				}
+			}
+			continue
+		}
+		anyInclude = true
+		included = included || globMatch(g, rel)
+	}
+	return included || !anyInclude
+}
+
+func globMatch(pattern, rel string) bool {
+	if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, rel)
+	return ok
+}
+
+// grep is the Go search engine.
+func (c WorkspaceConfig) grep(ctx context.Context, r WorkspaceRoot, re *regexp.Regexp, globs []string, under string, limit int) ([]searchHit, error) {
+	var hits []searchHit
+	errLimit := errors.New("limit reached")
+	err := c.walk(r, func(rel, full string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if under != "" && rel != under && !strings.HasPrefix(rel, under+"/") || !globsAllow(globs, rel) {
+			return nil
+		}
+		src, err := os.ReadFile(full)
+		if err != nil || bytes.IndexByte(src[:min(len(src), 8<<10)], 0) >= 0 {
+			return nil // unreadable or binary
+		}
+		for n, line := range bytes.Split(bytes.TrimSuffix(src, []byte("\n")), []byte("\n")) {
+			loc := re.FindIndex(line)
+			if loc == nil {
+				continue
+			}
+			hits = append(hits, searchHit{Root: r.Name, Path: rel, Line: n + 1, Column: loc[0] + 1, Text: searchLine(line)})
+			if len(hits) >= limit {
+				return errLimit
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errLimit) {
+		err = nil
+	}
+	return hits, err
+}
+
+// ripgrep runs the configured rg binary in root r and reads its JSON
+// output until limit matches. Sorting by path makes it single-threaded,
+// but keeps results in the Go engine's order.
+func (s *MCPServer) ripgrep(ctx context.Context, r WorkspaceRoot, args codeSearchArgs, under string, limit int) ([]searchHit, error) {
+	cfg := s.cfg.Workspace
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd, err := s.commands.command(ctx, r.Path, false, r.Path, cfg.Ripgrep, ripgrepArgs(cfg, args, under))
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ripgrep: %w", err)
+	}
+	var hits []searchHit
+	rd := bufio.NewReader(stdout)
+	for len(hits) < limit {
+		line, err := rd.ReadBytes('\n')
+		if len(line) > 0 {
+			if hit, ok := parseRipgrepMatch(line); ok {
+				hit.Root = r.Name
+				hits = append(hits, hit)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(hits) >= limit {
+		cancel()
+		cmd.Wait()
+		return hits, nil
+	}
+	// rg exits 1 when nothing matched.
+	if err := cmd.Wait(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+			return nil, fmt.Errorf("ripgrep: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	return hits, nil
+}
+
+// ripgrepArgs builds the rg command line for a code_search in the
+// root-relative directory under.
+func ripgrepArgs(cfg WorkspaceConfig, args codeSearchArgs, under string) []string {
+	maxBytes := cfg.MaxFileBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultWorkspaceMaxFileBytes
+	}
+	cmdArgs := []string{"--json", "--no-config", "--no-ignore", "--hidden", "--sort", "path", "--max-filesize", strconv.FormatInt(maxBytes, 10)}
+	if args.Mode == "literal" {
+		cmdArgs = append(cmdArgs, "--fixed-strings")
+	}
+	if args.IgnoreCase {
+		cmdArgs = append(cmdArgs, "--ignore-case")
+	}
+	if args.WholeWord {
+		cmdArgs = append(cmdArgs, "--word-regexp")
+	}
+	// The last matching glob wins in rg, so the workspace excludes go
+	// after the caller's: a glob like .git/** cannot re-include them.
+	for _, g := range args.Globs {
+		cmdArgs = append(cmdArgs, "--glob", g)
+	}
+	excludes := cfg.Exclude
+	if excludes == nil {
+		excludes = defaultWorkspaceExcludes
+	}
+	for _, g := range excludes {
+		cmdArgs = append(cmdArgs, "--glob", "!"+g)
+	}
+	target := "."
+	if under != "" {
+		target = filepath.FromSlash(under)
+	}
+	return append(cmdArgs, "--regexp", args.Query, "--", target)
+}
+
+// parseRipgrepMatch decodes one "match" message of rg --json.
+func parseRipgrepMatch(line []byte) (searchHit, bool) {
+	var msg struct {
+		Type string `json:"type"`
+		Data struct {
+			Path       struct{ Text string }
+			Lines      struct{ Text string }
+			LineNumber int `json:"line_number"`
+			Submatches []struct {
+				Start int `json:"start"`
+			} `json:"submatches"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(line, &msg) != nil || msg.Type != "match" {
+		return searchHit{}, false
+	}
+	hit := searchHit{
+		Path: strings.TrimPrefix(filepath.ToSlash(msg.Data.Path.Text), "./"),
+		Line: msg.Data.LineNumber,
+		Text: searchLine([]byte(strings.TrimRight(msg.Data.Lines.Text, "\r\n"))),
+	}
+	if len(msg.Data.Submatches) > 0 {
+		hit.Column = msg.Data.Submatches[0].Start + 1
+	}
+	return hit, true
+}
+
+// searchLine trims a matching line for output.
+func searchLine(line []byte) string {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) > maxSearchLineLen {
+		return string(line[:maxSearchLineLen]) + "…"
+	}
+	return string(line)
+}
+
+// addSearchContext fills in the lines around each hit.
+func addSearchContext(hits []searchHit, roots []WorkspaceRoot, n int) {
+	byName := map[string]WorkspaceRoot{}
+	for _, r := range roots {
+		byName[r.Name] = r
+	}
+	var lines [][]byte
+	var current string
+	for i := range hits {
+		h := &hits[i]
+		if key := h.Root + "/" + h.Path; key != current {
+			current, lines = key, nil
+			if full, err := byName[h.Root].resolve(h.Path); err == nil {
+				if src, err := os.ReadFile(full); err == nil {
+					lines = bytes.Split(src, []byte("\n"))
+				}
+			}
+		}
+		for j := max(h.Line-1-n, 0); j < h.Line-1 && j < len(lines); j++ {
+			h.Before = append(h.Before, searchLine(lines[j]))
+		}
+		for j := h.Line; j < h.Line+n && j < len(lines); j++ {
+			h.After = append(h.After, searchLine(lines[j]))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package mcpserver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRipgrepGlobOrder(t *testing.T) {
+	args := codeSearchArgs{Query: "x", Globs: []string{".git/**", "*.go", "!vendor/**"}}
+	cmd := ripgrepArgs(WorkspaceConfig{}, args, "")
+	var globs []string
+	for i, a := range cmd {
+		if a == "--glob" {
+			globs = append(globs, cmd[i+1])
+		}
+	}
+	want := append([]string{}, args.Globs...)
+	for _, g := range defaultWorkspaceExcludes {
+		want = append(want, "!"+g)
+	}
+	if strings.Join(globs, " ") != strings.Join(want, " ") {
+		t.Errorf("globs %q, want %q", globs, want)
+	}
+
+	rg, err := exec.LookPath("rg")
+	if err != nil {
+		t.Skip("rg not installed")
+	}
+	dir := t.TempDir()
+	for name, body := range map[string]string{".git/config": "secret", "main.go": "secret"} {
+		full := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(full), 0o755)
+		if err := os.WriteFile(full, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := newConfiguredServer(&Config{Workspace: WorkspaceConfig{Ripgrep: rg}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, globs := range [][]string{nil, {".git/**"}, {"*"}, {"config"}} {
+		hits, err := s.ripgrep(context.Background(), WorkspaceRoot{Name: "ws", Path: dir}, codeSearchArgs{Query: "secret", Globs: globs}, "", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range hits {
+			if strings.HasPrefix(filepath.ToSlash(h.Path), ".git/") {
+				t.Errorf("globs %q reached %s", globs, h.Path)
+			}
+		}
+	}
+}
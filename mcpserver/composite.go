@@ -0,0 +1,198 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxPipelineDepth bounds nesting of pipelines that call other pipelines.
+const maxPipelineDepth = 8
+
+type pipelineDepthKey struct{}
+
+// newPipelineBackend builds a composite tool that runs other tools in
+// sequence, mapping each step's output into the next step's arguments.
+// The result of the last step is the result of the pipeline.
+func (s *MCPServer) newPipelineBackend(b BackendConfig) (ToolHandler, error) {
+	if len(b.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline backend requires steps")
+	}
+	for i, step := range b.Steps {
+		if step.Tool == "" {
+			return nil, fmt.Errorf("pipeline step %d: tool is required", i+1)
+		}
+	}
+	steps := b.Steps
+
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		depth, _ := ctx.Value(pipelineDepthKey{}).(int)
+		if depth >= maxPipelineDepth {
+			return nil, fmt.Errorf("pipelines nested deeper than %d", maxPipelineDepth)
+		}
+		ctx = context.WithValue(ctx, pipelineDepthKey{}, depth+1)
+
+		input, err := argsMap(args)
+		if err != nil {
+			return nil, err
+		}
+		if ToolContextFrom(ctx).DryRun {
+			return dryRunPipeline(steps, input)
+		}
+		var prev interface{} = input
+		var result interface{}
+		caller := callerFrom(ctx)
+		for i, step := range steps {
+			// A step runs only tools its caller could call directly.
+			handler, ok := s.handlers[step.Tool]
+			if !ok || !s.toolVisible(step.Tool, caller) {
+				return nil, fmt.Errorf("step %d: unknown tool %q", i+1, step.Tool)
+			}
+			stepArgs, err := mapStepArguments(step.Arguments, prev, input)
+			if err != nil {
+				return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Tool, err)
+			}
+			raw, err := json.Marshal(stepArgs)
+			if err != nil {
+				return nil, err
+			}
+			result, err = handler(s.toolContext(ctx, step.Tool), raw)
+			if err != nil {
+				return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Tool, err)
+			}
+			decoded := decodeResult(result)
+			if isErr, _ := decoded["isError"].(bool); isErr {
+				return result, nil
+			}
+			prev = resultValue(decoded)
+		}
+		return result, nil
+	}, nil
+}
+
+// mapStepArguments builds a step's arguments. Without a mapping, an object
+// output of the previous step is passed through unchanged.
+func mapStepArguments(mapping map[string]interface{}, prev interface{}, input map[string]interface{}) (interface{}, error) {
+	if len(mapping) == 0 {
+		if obj, ok := prev.(map[string]interface{}); ok {
+			return obj, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+	out := make(map[string]interface{}, len(mapping))
+	for name, v := range mapping {
+		expr, ok := v.(string)
+		if !ok || !strings.HasPrefix(expr, "$") {
+			out[name] = v
+			continue
+		}
+		var doc interface{} = prev
+		if strings.HasPrefix(expr, "$args") {
+			doc, expr = input, "$"+strings.TrimPrefix(expr, "$args")
+		}
+		val, err := evalJSONPath(expr, doc)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", name, err)
+		}
+		out[name] = val
+	}
+	return out, nil
+}
+
+// dryRunPipeline previews a pipeline without running any step. Only the
+// first step's arguments are known before a step has run.
+func dryRunPipeline(steps []PipelineStep, input map[string]interface{}) (interface{}, error) {
+	first, err := mapStepArguments(steps[0].Arguments, input, input)
+	if err != nil {
+		return nil, fmt.Errorf("step 1 (%s): %w", steps[0].Tool, err)
+	}
+	args, err := json.Marshal(first)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(steps)-1)
+	for i, step := range steps[1:] {
+		names[i] = step.Tool
+	}
+	if len(names) == 0 {
+		return dryRunResult("call %s with %s", steps[0].Tool, args)
+	}
+	return dryRunResult("call %s with %s, then %s", steps[0].Tool, args, strings.Join(names, ", "))
+}
+
+// decodeResult converts a handler result into generic JSON form.
+func decodeResult(result interface{}) map[string]interface{} {
+	decoded := map[string]interface{}{}
+	if b, err := json.Marshal(result); err == nil {
+		json.Unmarshal(b, &decoded)
+	}
+	return decoded
+}
+
+// resultValue extracts the value a following step can address: structured
+// content when present, otherwise a lone text item (parsed as JSON when it
+// is valid JSON), otherwise the raw content list.
+func resultValue(result map[string]interface{}) interface{} {
+	if sc, ok := result["structuredContent"]; ok {
+		return sc
+	}
+	content, _ := result["content"].([]interface{})
+	if len(content) == 1 {
+		if item, ok := content[0].(map[string]interface{}); ok && item["type"] == "text" {
+			text, _ := item["text"].(string)
+			var v interface{}
+			if err := json.Unmarshal([]byte(text), &v); err == nil {
+				return v
+			}
+			return text
+		}
+	}
+	return content
+}
+
+// checkPipelines verifies that pipeline steps refer to registered tools and
+// that pipelines do not call each other in a cycle.
+func (s *MCPServer) checkPipelines(tools []ToolConfig) error {
+	graph := map[string][]string{}
+	for _, tc := range tools {
+		if tc.Backend.Type != "pipeline" {
+			continue
+		}
+		for i, step := range tc.Backend.Steps {
+			if _, ok := s.handlers[step.Tool]; !ok {
+				return fmt.Errorf("tool %q: step %d: unknown tool %q", tc.Name, i+1, step.Tool)
+			}
+			graph[tc.Name] = append(graph[tc.Name], step.Tool)
+		}
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("tool %q: pipeline cycle", name)
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, next := range graph[name] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for name := range graph {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
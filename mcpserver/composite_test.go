@@ -0,0 +1,43 @@
+package mcpserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPipelineStepsNeedVisibleTools(t *testing.T) {
+	static := func(name, text string) ToolConfig {
+		return ToolConfig{Name: name, Backend: BackendConfig{Type: "static", Text: text}}
+	}
+	pipeline := func(name, step string) ToolConfig {
+		return ToolConfig{Name: name, Backend: BackendConfig{Type: "pipeline", Steps: []PipelineStep{{Tool: step}}}}
+	}
+	key := APIKeyConfig{Name: "team-a", Key: "k", Tools: []string{"chain", "leak", "public"}}
+	s, err := newConfiguredServer(&Config{
+		Auth:  AuthConfig{APIKeys: []APIKeyConfig{key}},
+		Tools: []ToolConfig{static("public", "hello"), static("secret", "s3cret"), pipeline("chain", "public"), pipeline("leak", "secret")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withCaller(context.Background(), &Caller{Key: &key})
+	for _, tc := range []struct {
+		tool, err string
+	}{
+		{"chain", ""},
+		{"leak", `step 1: unknown tool "secret"`},
+	} {
+		_, err := s.handlers[tc.tool](s.toolContext(ctx, tc.tool), []byte(`{}`))
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %v", tc.tool, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: err = %v, want %s", tc.tool, err, tc.err)
+		}
+	}
+	// Without a key restriction, as on stdio, every step runs.
+	if _, err := s.handlers["leak"](context.Background(), []byte(`{}`)); err != nil {
+		t.Errorf("leak without caller: %v", err)
+	}
+}
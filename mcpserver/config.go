@@ -0,0 +1,229 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"mcp-server/store"
+)
+
+// Config is the server configuration, read from the JSON file named by
+// MCP_CONFIG. Every section is optional.
+type Config struct {
+	// Profile is the active profile, selected with MCP_ENV. Profiles are
+	// partial configs layered over the rest of the file; see profiles.go.
+	Profile  string                     `json:"-"`
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
+
+	Tools    []ToolConfig   `json:"tools"`
+	Auth     AuthConfig     `json:"auth"`
+	Exposure []ExposureRule `json:"exposure,omitempty"`
+	Features FeaturesConfig `json:"features"`
+	Admin    AdminConfig    `json:"admin"`
+	Usage    UsageConfig    `json:"usage"`
+	Jobs     JobsConfig     `json:"jobs"`
+
+	Diagnostics DiagnosticsConfig `json:"diagnostics"`
+	Memory      MemoryConfig      `json:"memory"`
+	Shedding    SheddingConfig    `json:"shedding"`
+	Text        TextConfig        `json:"text"`
+	I18n        I18nConfig        `json:"i18n"`
+	Sessions    SessionsConfig    `json:"sessions"`
+	Hardening   HardeningConfig   `json:"hardening"`
+	RunAs       RunAsConfig       `json:"runAs"`
+	Listen      ListenConfig      `json:"listen"`
+	Proxy       ProxyConfig       `json:"proxy"`
+	Server      ServerConfig      `json:"server"`
+	Logging     LoggingConfig     `json:"logging"`
+	Dashboard   DashboardConfig   `json:"dashboard"`
+	Policy      PolicyConfig      `json:"policy"`
+	TLS         TLSConfig         `json:"tls"`
+	Elicitation ElicitationConfig `json:"elicitation"`
+	Approval    ApprovalConfig    `json:"approval"`
+	VCR         VCRConfig         `json:"vcr"`
+	Chaos       ChaosConfig       `json:"chaos"`
+	Audit       AuditConfig       `json:"audit"`
+	Retention   RetentionConfig   `json:"retention"`
+	Telemetry   TelemetryConfig   `json:"telemetry"`
+	Update      UpdateConfig      `json:"update"`
+	Workspace   WorkspaceConfig   `json:"workspace"`
+	Templates   TemplatesConfig   `json:"templates"`
+	Query       QueryConfig       `json:"query"`
+	ScratchDB   ScratchDBConfig   `json:"scratchDB"`
+	Transfer    TransferConfig    `json:"transfer"`
+	SNMP        SNMPConfig        `json:"snmp"`
+	MQTT        MQTTConfig        `json:"mqtt"`
+	Egress      EgressConfig      `json:"egress"`
+	Inbound     InboundConfig     `json:"inbound"`
+	Security    SecurityConfig    `json:"security"`
+
+	HomeAssistant HomeAssistantConfig `json:"homeAssistant"`
+	Prometheus    PrometheusConfig    `json:"prometheus"`
+	Logs          LogsConfig          `json:"logs"`
+	Cloud         CloudConfig         `json:"cloud"`
+	Terraform     TerraformConfig     `json:"terraform"`
+
+	// DataDir holds persistent state, the SQLite state store by default.
+	DataDir string       `json:"dataDir,omitempty"`
+	Store   store.Config `json:"store"`
+
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	// Listeners, when set, replace the default address and listen.socket
+	// with several listeners served at once.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+
+	// Secrets are named values the env of config tools can refer to as
+	// ${secret:name}.
+	Secrets map[string]SecretConfig `json:"secrets,omitempty"`
+
+	// StrictDecoding enables strict JSON decoding (unknown fields and
+	// duplicate keys rejected) per path prefix, e.g. {"/mcp": true}.
+	StrictDecoding map[string]bool `json:"strictDecoding,omitempty"`
+
+	// Deprecations is keyed by tool name. Aliases maps old tool names to
+	// the tools that replaced them; aliases are callable but not listed.
+	Deprecations map[string]DeprecationConfig `json:"deprecations,omitempty"`
+	Aliases      map[string]string            `json:"aliases,omitempty"`
+}
+
+// ToolConfig declares a tool whose handler is built from a backend
+// definition instead of Go code.
+type ToolConfig struct {
+	Name        string           `json:"name" schema:"required"`
+	Description string           `json:"description"`
+	InputSchema json.RawMessage  `json:"inputSchema,omitempty"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+	Backend     BackendConfig    `json:"backend" schema:"required"`
+	Canary      *CanaryConfig    `json:"canary,omitempty"`
+}
+
+// BackendConfig describes how a declarative tool is executed. Which fields
+// apply depends on Type: "http", "graphql", "exec", "template", "static" or
+// "pipeline". String
+// fields marked as templates are rendered with the tool arguments.
+type BackendConfig struct {
+	Type string `json:"type" schema:"required,enum=http|graphql|exec|template|static|pipeline"`
+
+	// http: URL, Headers and Body are templates.
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+
+	// graphql: posts Query (a template) to URL with Headers. Variables maps
+	// GraphQL variable names to argument names; when empty, all arguments
+	// are sent as variables.
+	Query         string            `json:"query,omitempty"`
+	OperationName string            `json:"operationName,omitempty"`
+	Variables     map[string]string `json:"variables,omitempty"`
+
+	// exec: each of Args is a template; Command is not. With Sandbox set,
+	// the command runs in a throwaway container.
+	//
+	// Env is set for this tool only: as variables of an exec command, and
+	// for http and graphql through {{env "NAME"}} in Headers. Values may
+	// refer to ${secret:name} and the server's $VARIABLES.
+	Env map[string]string `json:"env,omitempty"`
+
+	Command string         `json:"command,omitempty"`
+	Args    []string       `json:"args,omitempty"`
+	Dir     string         `json:"dir,omitempty"`
+	Sandbox *SandboxConfig `json:"sandbox,omitempty"`
+
+	// template
+	Template string `json:"template,omitempty"`
+
+	// static
+	Text string `json:"text,omitempty"`
+
+	// pipeline
+	Steps []PipelineStep `json:"steps,omitempty"`
+
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// PipelineStep calls another tool as part of a pipeline. String argument
+// values starting with "$" are JSONPath expressions evaluated against the
+// previous step's output ("$args" refers to the pipeline's own arguments);
+// any other value is passed through literally.
+type PipelineStep struct {
+	Tool      string                 `json:"tool" schema:"required"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+// LoggingConfig controls request logging. Verbose logs every JSON-RPC
+// call with its caller and duration.
+type LoggingConfig struct {
+	Verbose bool `json:"verbose,omitempty"`
+	// SlowRequest logs and counts JSON-RPC requests that take at least
+	// this long; zero turns it off.
+	SlowRequest Duration `json:"slowRequest,omitempty"`
+}
+
+// Duration is a time.Duration that reads from JSON strings like "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfig reads the config file at path and applies profile env when
+// set. An empty path yields the default (empty) configuration.
+func loadConfig(path, env string) (*Config, error) {
+	data := []byte("{}")
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return parseConfig(data, path, env)
+}
+
+// parseConfig validates and decodes the config document data, read from
+// path, with profile env applied.
+func parseConfig(data []byte, path, env string) (*Config, error) {
+	cfg := &Config{}
+	issues := validateConfig(data)
+	var problems []string
+	for _, issue := range issues {
+		if issue.Warning {
+			log.Printf("%s:%s", path, issue)
+		} else {
+			problems = append(problems, fmt.Sprintf("%s:%s", path, issue))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	if env != "" {
+		var err error
+		if data, err = applyProfile(data, env); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.Profile = env
+	return cfg, nil
+}
@@ -0,0 +1,487 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ConfigIssue is a problem found while validating a config file. Line and
+// Column are 1-based; both are zero for issues without a position.
+type ConfigIssue struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+	Warning bool
+}
+
+func (i ConfigIssue) String() string {
+	var b strings.Builder
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d: ", i.Line, i.Column)
+	}
+	if i.Warning {
+		b.WriteString("warning: ")
+	}
+	if i.Path != "" {
+		b.WriteString(i.Path + ": ")
+	}
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// deprecatedConfigKeys maps config paths, with array indexes written as
+// "[]", to what replaces them.
+var deprecatedConfigKeys = map[string]string{
+	"tools[].annotations.deprecated": `use the top-level "deprecations" map`,
+	"tools[].annotations.replacedBy": `use "replacement" in the top-level "deprecations" map`,
+	"jobs.dir":                       `use the top-level "dataDir"`,
+	"jobs.retention":                 `use "retention.jobs"`,
+}
+
+// configSchema returns the JSON Schema of the config file.
+func configSchema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(Config{}))
+
+	// A profile is a partial config that may extend another profile.
+	profile := schemaFor(reflect.TypeOf(Config{}))
+	profileProps := profile["properties"].(map[string]interface{})
+	delete(profileProps, "profiles")
+	profileProps["extends"] = map[string]interface{}{"type": "string"}
+	schema["properties"].(map[string]interface{})["profiles"] = map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": profile,
+	}
+
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "MCP server configuration"
+	return schema
+}
+
+// validateConfig checks a config document against configSchema. Unknown
+// keys, wrong types and bad enum, duration or size values are errors;
+// deprecated keys and keys that only match case-insensitively are
+// warnings.
+func validateConfig(data []byte) []ConfigIssue {
+	return validateJSON(data, configSchema())
+}
+
+// validateJSON checks the JSON document data against schema.
+func validateJSON(data []byte, schema map[string]interface{}) []ConfigIssue {
+	v := &configValidator{data: data}
+	root, err := parseJSONNodes(data)
+	if err != nil {
+		var syntax *json.SyntaxError
+		offset := len(data)
+		if errors.As(err, &syntax) {
+			offset = int(syntax.Offset)
+		}
+		v.add(offset, "", false, "%v", err)
+		return v.issues
+	}
+	v.check(root, schema, "", "")
+	return v.issues
+}
+
+// hasErrors reports whether any issue is not a warning.
+func hasErrors(issues []ConfigIssue) bool {
+	for _, i := range issues {
+		if !i.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonNode is a parsed JSON value that remembers where it starts in the
+// source. kind uses the classes of jsonKind.
+type jsonNode struct {
+	offset int
+	kind   byte
+	value  interface{} // string, json.Number or bool for scalars
+
+	keys       []string // objects, in source order
+	keyOffsets []int
+	fields     []*jsonNode
+	items      []*jsonNode // arrays
+}
+
+type nodeParser struct {
+	data []byte
+	dec  *json.Decoder
+}
+
+func parseJSONNodes(data []byte) (*jsonNode, error) {
+	p := &nodeParser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.dec.Token(); err != io.EOF {
+		// Let encoding/json describe the trailing data.
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("unexpected data after the top-level value")
+	}
+	return root, nil
+}
+
+// start returns the offset of the next token.
+func (p *nodeParser) start() int {
+	off := int(p.dec.InputOffset())
+	for off < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+func (p *nodeParser) parse() (*jsonNode, error) {
+	n := &jsonNode{offset: p.start()}
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		n.kind = byte(t)
+		for p.dec.More() {
+			if t == '{' {
+				keyOffset := p.start()
+				key, err := p.dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+				n.keyOffsets = append(n.keyOffsets, keyOffset)
+			}
+			child, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			if t == '{' {
+				n.fields = append(n.fields, child)
+			} else {
+				n.items = append(n.items, child)
+			}
+		}
+		if _, err := p.dec.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.kind, n.value = '"', t
+	case json.Number:
+		n.kind, n.value = '0', t
+	case bool:
+		n.kind, n.value = 't', t
+	case nil:
+		n.kind = 'n'
+	}
+	return n, nil
+}
+
+type configValidator struct {
+	data   []byte
+	issues []ConfigIssue
+}
+
+func (v *configValidator) add(offset int, path string, warning bool, format string, args ...interface{}) {
+	line, col := 1, 1
+	for _, c := range v.data[:offset] {
+		if c == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	v.issues = append(v.issues, ConfigIssue{
+		Line:    line,
+		Column:  col,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+// check validates n against schema. pattern is path with array indexes
+// collapsed, for deprecatedConfigKeys.
+func (v *configValidator) check(n *jsonNode, schema map[string]interface{}, path, pattern string) {
+	typ, _ := schema["type"].(string)
+	if typ == "" || n.kind == 'n' {
+		return // any value; null leaves the field at its default
+	}
+	kinds := map[string]byte{
+		"object": '{', "array": '[', "string": '"',
+		"integer": '0', "number": '0', "boolean": 't',
+	}
+	if kinds[typ] != n.kind {
+		v.add(n.offset, path, false, "must be %s %s", article(typ), typ)
+		return
+	}
+
+	switch typ {
+	case "object":
+		v.checkObject(n, schema, path, pattern)
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range n.items {
+			v.check(item, items, fmt.Sprintf("%s[%d]", path, i), pattern+"[]")
+		}
+		v.checkLength(n, schema, path, len(n.items), "minItems", "maxItems", "items")
+	case "integer", "number":
+		num := n.value.(json.Number)
+		f, err := num.Float64()
+		if err != nil || typ == "integer" && strings.ContainsAny(num.String(), ".eE") {
+			v.add(n.offset, path, false, "must be %s %s", article(typ), typ)
+			return
+		}
+		if min, ok := schema["minimum"].(float64); ok && f < min {
+			v.add(n.offset, path, false, "must be at least %v", min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && f > max {
+			v.add(n.offset, path, false, "must be at most %v", max)
+		}
+	case "string":
+		s := n.value.(string)
+		if enum, ok := schema["enum"].([]string); ok && !contains(enum, s) {
+			v.add(n.offset, path, false, "must be one of %s", strings.Join(enum, ", "))
+		}
+		v.checkLength(n, schema, path, utf8.RuneCountInString(s), "minLength", "maxLength", "characters")
+		if expr, ok := schema["pattern"].(string); ok {
+			if re, err := compilePattern(expr); err != nil {
+				v.add(n.offset, path, false, "invalid pattern in schema: %v", err)
+			} else if !re.MatchString(s) {
+				v.add(n.offset, path, false, "must match %s", expr)
+			}
+		}
+		switch schema["format"] {
+		case "duration":
+			if _, err := time.ParseDuration(s); err != nil {
+				v.add(n.offset, path, false, `must be a duration like "30s"`)
+			}
+		case "byteSize":
+			if _, err := parseByteSize(s); err != nil {
+				v.add(n.offset, path, false, `must be a size like "512MiB"`)
+			}
+		}
+	}
+}
+
+// checkLength checks length n against the schema's lower and upper bounds.
+func (v *configValidator) checkLength(node *jsonNode, schema map[string]interface{}, path string, n int, lower, upper, unit string) {
+	if min, ok := schema[lower].(float64); ok && float64(n) < min {
+		v.add(node.offset, path, false, "must have at least %v %s", min, unit)
+	}
+	if max, ok := schema[upper].(float64); ok && float64(n) > max {
+		v.add(node.offset, path, false, "must have at most %v %s", max, unit)
+	}
+}
+
+// patterns caches compiled schema patterns.
+var patterns sync.Map
+
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(expr, re)
+	return re, nil
+}
+
+func (v *configValidator) checkObject(n *jsonNode, schema map[string]interface{}, path, pattern string) {
+	props, _ := schema["properties"].(map[string]interface{})
+	extra, _ := schema["additionalProperties"].(map[string]interface{})
+	seen := map[string]bool{}
+	for i, key := range n.keys {
+		keyPath, keyPattern := joinPath(path, key), joinPath(pattern, key)
+		if seen[key] {
+			v.add(n.keyOffsets[i], keyPath, false, "duplicate key")
+			continue
+		}
+		seen[key] = true
+
+		var child map[string]interface{}
+		switch {
+		case props[key] != nil:
+			child, _ = props[key].(map[string]interface{})
+		case extra != nil:
+			child = extra
+		default:
+			// encoding/json matches field names case-insensitively.
+			if name := matchFold(props, key); name != "" {
+				v.add(n.keyOffsets[i], keyPath, true, "should be spelled %q", name)
+				seen[name] = true
+				child, _ = props[name].(map[string]interface{})
+				keyPath, keyPattern = joinPath(path, name), joinPath(pattern, name)
+				break
+			}
+			msg := "unknown key"
+			if name := closestKey(props, key); name != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", name)
+			}
+			// additionalProperties: true allows the key but it is still
+			// worth a warning.
+			v.add(n.keyOffsets[i], keyPath, schema["additionalProperties"] == true, "%s", msg)
+			continue
+		}
+		if hint, ok := deprecatedConfigKeys[keyPattern]; ok {
+			v.add(n.keyOffsets[i], keyPath, true, "deprecated: %s", hint)
+		}
+		v.check(n.fields[i], child, keyPath, keyPattern)
+	}
+	if required, ok := schema["required"].([]string); ok {
+		for _, name := range required {
+			if !seen[name] {
+				v.add(n.offset, path, false, "missing required key %q", name)
+			}
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func article(typ string) string {
+	if typ == "object" || typ == "array" || typ == "integer" {
+		return "an"
+	}
+	return "a"
+}
+
+func matchFold(props map[string]interface{}, key string) string {
+	for name := range props {
+		if strings.EqualFold(name, key) {
+			return name
+		}
+	}
+	return ""
+}
+
+// closestKey suggests the property within edit distance 2 of key.
+func closestKey(props map[string]interface{}, key string) string {
+	best, bestDist := "", 3
+	for _, name := range sortedKeys(props) {
+		if d := editDistance(name, key); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
+
+// runConfigCommand implements the "config" subcommand:
+//
+//	mcp-server config validate [file]   check a config file (default $MCP_CONFIG)
+//	mcp-server config schema            print the config JSON Schema
+func runConfigCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server config validate [file] | config schema | config hash-password")
+		return 2
+	}
+	switch args[0] {
+	case "schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(configSchema())
+		return 0
+	case "validate":
+		path := os.Getenv("MCP_CONFIG")
+		if len(args) > 1 {
+			path = args[1]
+		}
+		if path == "" {
+			fmt.Fprintln(os.Stderr, "config validate: no file given and MCP_CONFIG is not set")
+			return 2
+		}
+		return validateConfigFile(path)
+	case "hash-password":
+		return hashPassword()
+	default:
+		fmt.Fprintf(os.Stderr, "config: unknown command %q\n", args[0])
+		return 2
+	}
+}
+
+func validateConfigFile(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	issues := validateConfig(data)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	for _, issue := range issues {
+		fmt.Printf("%s:%s\n", path, issue)
+	}
+	if hasErrors(issues) {
+		return 1
+	}
+	// The schema is satisfied; build the server with the MCP_ENV profile
+	// applied to catch what only the loaders check, such as templates and
+	// pipeline references.
+	cfg, err := loadConfig(path, os.Getenv(envVar))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if _, err := newConfiguredServer(cfg); err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("%s: OK (%d warnings)\n", path, len(issues))
+	return 0
+}
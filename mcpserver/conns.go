@@ -0,0 +1,88 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	connectionsMetric        = "mcp_http_connections"
+	connectionsTotalMetric   = "mcp_http_connections_total"
+	connectionDurationMetric = "mcp_http_connection_duration_seconds"
+	streamsMetric            = "mcp_sse_streams"
+	streamDurationMetric     = "mcp_sse_stream_duration_seconds"
+	slowRequestsMetric       = "mcp_slow_requests_total"
+)
+
+// connectionBuckets suit long-lived connections and event streams, from
+// one-off requests to streams held open for hours.
+var connectionBuckets = []float64{1, 5, 15, 60, 300, 900, 3600, 14400}
+
+// connTracker counts a listener's open connections and how long they
+// last, through http.Server.ConnState.
+type connTracker struct {
+	metrics  *metricsRegistry
+	listener string
+	mu       sync.Mutex
+	opened   map[net.Conn]time.Time
+}
+
+func newConnTracker(m *metricsRegistry, listener string) *connTracker {
+	return &connTracker{metrics: m, listener: listener, opened: map[net.Conn]time.Time{}}
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		t.mu.Lock()
+		t.opened[c] = time.Now()
+		t.mu.Unlock()
+		t.metrics.inc(connectionsTotalMetric, "listener", t.listener)
+		t.metrics.add(connectionsMetric, 1, "listener", t.listener)
+	case http.StateHijacked, http.StateClosed:
+		t.mu.Lock()
+		start, ok := t.opened[c]
+		delete(t.opened, c)
+		t.mu.Unlock()
+		if ok {
+			t.metrics.add(connectionsMetric, -1, "listener", t.listener)
+			t.metrics.observe(connectionDurationMetric, time.Since(start).Seconds(), "listener", t.listener)
+		}
+	}
+}
+
+// trackStream counts an open event stream; the returned func ends it.
+func (s *MCPServer) trackStream() func() {
+	start := time.Now()
+	s.metrics.add(streamsMetric, 1)
+	return func() {
+		s.metrics.add(streamsMetric, -1)
+		s.metrics.observe(streamDurationMetric, time.Since(start).Seconds())
+	}
+}
+
+// reportSlow logs and counts a JSON-RPC request that took longer than
+// logging.slowRequest, naming the tool of tools/call.
+func (s *MCPServer) reportSlow(req *JSONRPCRequest, caller *Caller, r *http.Request, elapsed time.Duration) {
+	threshold := time.Duration(s.cfg.Logging.SlowRequest)
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	if req.Method == "tools/call" {
+		json.Unmarshal(req.Params, &params)
+	}
+	client := ""
+	if caller.Session != nil {
+		client = caller.Session.ClientName + "/" + caller.Session.ClientVersion
+	}
+	s.metrics.inc(slowRequestsMetric, "method", req.Method, "tool", params.Name)
+	log.Printf("slow rpc %s tool=%q id=%s key=%s client=%q ip=%s took %v (threshold %v)",
+		req.Method, params.Name, req.ID, caller.KeyName(), client, clientIP(r), elapsed.Round(time.Microsecond), threshold)
+}
@@ -0,0 +1,1346 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Data conversion reads every format into one tree: nil, bool,
+// json.Number, string, []interface{} and *dataObject, which keeps keys in
+// their input order. Numbers keep their text, so no precision is lost;
+// floats keep a decimal point so they stay floats. Dates become strings,
+// and infinities and NaN, which JSON lacks, become "inf", "-inf" and "nan".
+
+// dataObject is an object that keeps its key order.
+type dataObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newDataObject() *dataObject {
+	return &dataObject{values: map[string]interface{}{}}
+}
+
+// set adds or replaces key; a replaced key keeps its place.
+func (o *dataObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *dataObject) sorted() []string {
+	keys := append([]string(nil), o.keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+type convertArgs struct {
+	Content    string         `json:"content,omitempty" jsonschema:"description=Data to convert"`
+	Root       string         `json:"root,omitempty" jsonschema:"description=Workspace root of path and outputPath"`
+	Path       string         `json:"path,omitempty" jsonschema:"description=Root-relative file to convert instead of content"`
+	From       string         `json:"from,omitempty" jsonschema:"enum=json|jsonl|yaml|toml|csv|xml,description=Input format; defaults to the one of path's extension"`
+	To         string         `json:"to" jsonschema:"required,enum=json|jsonl|yaml|toml|csv|xml,description=Output format"`
+	OutputPath string         `json:"outputPath,omitempty" jsonschema:"description=Write the result to this root-relative file in a writable root instead of returning it"`
+	Overwrite  bool           `json:"overwrite,omitempty" jsonschema:"description=Replace outputPath if it exists"`
+	Options    convertOptions `json:"options,omitempty"`
+}
+
+type convertOptions struct {
+	Indent      *int   `json:"indent,omitempty" jsonschema:"minimum=0,maximum=8,description=Indentation of JSON\\, YAML and XML output (default 2; 0 writes compact JSON)"`
+	SortKeys    bool   `json:"sortKeys,omitempty" jsonschema:"description=Sort object keys instead of keeping their input order"`
+	InferTypes  bool   `json:"inferTypes,omitempty" jsonschema:"description=Read CSV cells and XML text as numbers\\, booleans\\, null and JSON where they look like them\\, instead of as strings"`
+	Delimiter   string `json:"delimiter,omitempty" jsonschema:"maxLength=1,description=CSV field delimiter (default comma)"`
+	FlatKeys    bool   `json:"flatKeys,omitempty" jsonschema:"description=Keep dotted CSV headers as keys instead of nesting objects by them"`
+	RootElement string `json:"rootElement,omitempty" jsonschema:"description=XML root element for data without a single top-level key (default root)"`
+}
+
+func (o convertOptions) indent() int {
+	if o.Indent == nil {
+		return 2
+	}
+	return *o.Indent
+}
+
+func (o convertOptions) comma() rune {
+	if o.Delimiter == "" {
+		return ','
+	}
+	return []rune(o.Delimiter)[0]
+}
+
+const (
+	// maxConvertInput bounds the documents read whole; record streams
+	// between files are not limited.
+	maxConvertInput  = 64 << 20
+	maxConvertOutput = 8 << 20
+	maxYAMLNodes     = 1 << 20
+)
+
+var convertFormatByExt = map[string]string{
+	".json": "json", ".jsonl": "jsonl", ".ndjson": "jsonl", ".yaml": "yaml", ".yml": "yaml",
+	".toml": "toml", ".csv": "csv", ".xml": "xml",
+}
+
+// streamable formats are sequences of records that can be converted one
+// record at a time.
+func streamable(format string) bool {
+	return format == "json" || format == "jsonl" || format == "csv"
+}
+
+func (s *MCPServer) setupDataTools() {
+	convert, convertHandler, _ := typedTool("convert_data", "Convert data between JSON, JSON Lines, YAML, TOML, CSV and XML, inline or between workspace files", s.convertDataTool)
+	if !s.cfg.Workspace.writable() {
+		convert.Annotations = readOnlyAnnotations()
+	}
+	s.addTool(convert, convertHandler)
+
+	query, queryHandler, _ := typedTool("query_json", "Run a jq expression over JSON, YAML, TOML, CSV or XML given inline, read from the workspace or fetched from an allowed URL, returning only what it selects", s.queryJSONTool)
+	query.Annotations = readOnlyAnnotations()
+	s.addTool(query, queryHandler)
+}
+
+func (s *MCPServer) convertDataTool(ctx context.Context, args convertArgs) (interface{}, error) {
+	if (args.Content == "") == (args.Path == "") {
+		return nil, errors.New("give either content or path")
+	}
+	from := args.From
+	if from == "" && args.Path != "" {
+		from = convertFormatByExt[strings.ToLower(path.Ext(args.Path))]
+	}
+	if from == "" {
+		return nil, errors.New("from is required when it cannot be told from the path")
+	}
+	opts := args.Options
+
+	var in string
+	if args.Path != "" {
+		r, err := s.cfg.Workspace.root(args.Root)
+		if err != nil {
+			return nil, err
+		}
+		if in, err = r.resolve(args.Path); err != nil {
+			return nil, err
+		}
+	}
+	if args.OutputPath == "" {
+		var src io.Reader = strings.NewReader(args.Content)
+		if in != "" {
+			f, err := os.Open(in)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			src = f
+		}
+		v, err := readData(src, from, opts)
+		if err != nil {
+			return nil, err
+		}
+		out := &limitedBuffer{max: maxConvertOutput}
+		if err := writeData(out, v, args.To, opts); err != nil {
+			return nil, err
+		}
+		return out.String(), nil
+	}
+
+	r, rel, outPath, err := s.outputFile(args.Root, args.OutputPath, args.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	if in != "" && outPath == in {
+		return nil, errors.New("outputPath is the input file")
+	}
+	result := map[string]interface{}{"from": from, "to": args.To, "outputPath": rel}
+	streamed := in != "" && streamable(from) && streamable(args.To)
+	err = writeFileVia(outPath, func(w io.Writer) error {
+		if streamed {
+			n, err := streamRecords(ctx, in, from, w, args.To, opts)
+			result["records"] = n
+			return err
+		}
+		var src io.Reader = strings.NewReader(args.Content)
+		if in != "" {
+			f, err := os.Open(in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			src = f
+		}
+		v, err := readData(src, from, opts)
+		if err != nil {
+			return err
+		}
+		return writeData(w, v, args.To, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if st, err := os.Stat(outPath); err == nil {
+		result["bytes"] = st.Size()
+	}
+	result["streamed"] = streamed
+	s.code.invalidate(r.Name)
+	return result, nil
+}
+
+// outputFile checks that a tool may create rel in a writable root, or
+// replace it when overwrite is set, and returns the root, the cleaned
+// path and its file system path.
+func (s *MCPServer) outputFile(root, rel string, overwrite bool) (WorkspaceRoot, string, string, error) {
+	r, err := s.cfg.Workspace.root(root)
+	if err != nil {
+		return r, "", "", err
+	}
+	if !r.Writable {
+		return r, "", "", fmt.Errorf("workspace root %q is not writable", r.Name)
+	}
+	rel = strings.Trim(path.Clean("/"+filepath.ToSlash(rel)), "/")
+	for p := rel; p != "."; p = path.Dir(p) {
+		if s.cfg.Workspace.excluded(p) {
+			return r, "", "", fmt.Errorf("%s is excluded from the workspace", rel)
+		}
+	}
+	full, err := r.resolve(rel)
+	if err != nil {
+		return r, "", "", err
+	}
+	if _, err := os.Stat(full); err == nil && !overwrite {
+		return r, "", "", fmt.Errorf("%s exists; set overwrite to replace it", rel)
+	}
+	return r, rel, full, nil
+}
+
+// limitedBuffer fails writes past max bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, fmt.Errorf("output exceeds %d bytes; write it to a file with outputPath", b.max)
+	}
+	return b.Buffer.Write(p)
+}
+
+// readData reads a whole document.
+func readData(r io.Reader, format string, opts convertOptions) (interface{}, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConvertInput+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConvertInput {
+		return nil, fmt.Errorf("input exceeds %d bytes", maxConvertInput)
+	}
+	var v interface{}
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if v, err = readJSONValue(dec, nil); err == nil {
+			if _, extra := dec.Token(); extra != io.EOF {
+				err = errors.New("unexpected data after the JSON value")
+			}
+		}
+	case "jsonl", "csv":
+		var recs recordReader
+		if recs, err = newRecordReader(bytes.NewReader(data), format, opts); err != nil {
+			break
+		}
+		list := []interface{}{}
+		for {
+			rec, err2 := recs.next()
+			if err2 == io.EOF {
+				break
+			}
+			if err2 != nil {
+				return nil, err2
+			}
+			list = append(list, rec)
+		}
+		v = list
+	case "yaml":
+		v, err = readYAML(data)
+	case "toml":
+		v, err = readTOML(data)
+	case "xml":
+		v, err = readXML(data, opts)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", format, err)
+	}
+	return v, nil
+}
+
+// writeData writes a whole document.
+func writeData(w io.Writer, v interface{}, format string, opts convertOptions) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case "json":
+		err = writeJSONValue(bw, v, opts, 0)
+		if err == nil {
+			err = bw.WriteByte('\n')
+		}
+	case "jsonl":
+		list, ok := v.([]interface{})
+		if !ok {
+			list = []interface{}{v}
+		}
+		compact := opts
+		compact.Indent = new(int)
+		for _, item := range list {
+			if err = writeJSONValue(bw, item, compact, 0); err != nil {
+				break
+			}
+			bw.WriteByte('\n')
+		}
+	case "yaml":
+		err = writeYAML(bw, v, opts)
+	case "toml":
+		err = writeTOML(bw, v, opts)
+	case "csv":
+		rows, ok := v.([]interface{})
+		if !ok {
+			rows = []interface{}{v}
+		}
+		err = writeCSV(bw, func(fn func(interface{}) error) error {
+			for _, row := range rows {
+				if err := fn(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, opts)
+	case "xml":
+		err = writeXML(bw, v, opts)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", format, err)
+	}
+	return bw.Flush()
+}
+
+// recordReader yields the records of a stream until io.EOF.
+type recordReader interface {
+	next() (interface{}, error)
+}
+
+type recordFunc func() (interface{}, error)
+
+func (f recordFunc) next() (interface{}, error) { return f() }
+
+// newRecordReader reads JSON Lines, CSV rows, or the elements of a JSON
+// array, which is a single record if the document is not an array.
+func newRecordReader(r io.Reader, format string, opts convertOptions) (recordReader, error) {
+	switch format {
+	case "jsonl":
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64<<10), maxConvertInput)
+		line := 0
+		return recordFunc(func() (interface{}, error) {
+			for sc.Scan() {
+				line++
+				text := bytes.TrimSpace(sc.Bytes())
+				if len(text) == 0 {
+					continue
+				}
+				dec := json.NewDecoder(bytes.NewReader(text))
+				dec.UseNumber()
+				v, err := readJSONValue(dec, nil)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				return v, nil
+			}
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}), nil
+	case "json":
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if tok != json.Delim('[') {
+			done := false
+			return recordFunc(func() (interface{}, error) {
+				if done {
+					return nil, io.EOF
+				}
+				done = true
+				return readJSONValue(dec, tok)
+			}), nil
+		}
+		return recordFunc(func() (interface{}, error) {
+			if !dec.More() {
+				return nil, io.EOF
+			}
+			return readJSONValue(dec, nil)
+		}), nil
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.Comma = opts.comma()
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err == io.EOF {
+			return recordFunc(func() (interface{}, error) { return nil, io.EOF }), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return recordFunc(func() (interface{}, error) {
+			row, err := cr.Read()
+			if err != nil {
+				return nil, err
+			}
+			obj := newDataObject()
+			for i, name := range header {
+				cell := ""
+				if i < len(row) {
+					cell = row[i]
+				}
+				var v interface{} = cell
+				if opts.InferTypes {
+					v = inferScalar(cell)
+				}
+				if opts.FlatKeys || !strings.Contains(name, ".") {
+					obj.set(name, v)
+				} else if err := setDotted(obj, strings.Split(name, "."), v); err != nil {
+					return nil, err
+				}
+			}
+			return obj, nil
+		}), nil
+	}
+	return nil, fmt.Errorf("%s is not a record format", format)
+}
+
+// setDotted sets the value at a path of keys, creating objects on the way.
+func setDotted(obj *dataObject, keys []string, v interface{}) error {
+	for _, k := range keys[:len(keys)-1] {
+		child, ok := obj.values[k].(*dataObject)
+		if !ok {
+			if _, taken := obj.values[k]; taken {
+				return fmt.Errorf("column %q is both a value and an object", strings.Join(keys, "."))
+			}
+			child = newDataObject()
+			obj.set(k, child)
+		}
+		obj = child
+	}
+	obj.set(keys[len(keys)-1], v)
+	return nil
+}
+
+var numberText = regexp.MustCompile(`^-?(?:0|[1-9]\d*)(?:\.\d+)?(?:[eE][+-]?\d+)?$`)
+
+// inferScalar reads text as the JSON value it looks like.
+func inferScalar(s string) interface{} {
+	switch t := strings.TrimSpace(s); {
+	case t == "":
+		return nil
+	case t == "true" || t == "false":
+		return t == "true"
+	case t == "null":
+		return nil
+	case numberText.MatchString(t):
+		return json.Number(t)
+	case t[0] == '[' || t[0] == '{':
+		dec := json.NewDecoder(strings.NewReader(t))
+		dec.UseNumber()
+		if v, err := readJSONValue(dec, nil); err == nil && !dec.More() {
+			return v
+		}
+	}
+	return s
+}
+
+// streamRecords converts the records of in to out one at a time. CSV
+// output needs every column before the first row, so its input is read
+// twice.
+func streamRecords(ctx context.Context, in, from string, w io.Writer, to string, opts convertOptions) (int, error) {
+	each := func(fn func(interface{}) error) error {
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		recs, err := newRecordReader(bufio.NewReader(f), from, opts)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", from, err)
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rec, err := recs.next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", from, err)
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	n := 0
+	counted := func(fn func(interface{}) error) func(interface{}) error {
+		return func(rec interface{}) error {
+			n++
+			return fn(rec)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	var err error
+	switch to {
+	case "csv":
+		err = writeCSV(bw, func(fn func(interface{}) error) error {
+			n = 0
+			return each(counted(fn))
+		}, opts)
+	case "jsonl":
+		compact := opts
+		compact.Indent = new(int)
+		err = each(counted(func(rec interface{}) error {
+			if err := writeJSONValue(bw, rec, compact, 0); err != nil {
+				return err
+			}
+			return bw.WriteByte('\n')
+		}))
+	case "json":
+		bw.WriteByte('[')
+		err = each(counted(func(rec interface{}) error {
+			if n > 1 {
+				bw.WriteByte(',')
+			}
+			if opts.indent() > 0 {
+				bw.WriteString("\n" + strings.Repeat(" ", opts.indent()))
+			}
+			return writeJSONValue(bw, rec, opts, 1)
+		}))
+		if n > 0 && opts.indent() > 0 {
+			bw.WriteByte('\n')
+		}
+		bw.WriteString("]\n")
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// JSON.
+
+// readJSONValue reads the next value from dec, which must use numbers;
+// first is its first token when already read.
+func readJSONValue(dec *json.Decoder, first json.Token) (interface{}, error) {
+	tok := first
+	if tok == nil {
+		var err error
+		if tok, err = dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := newDataObject()
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := readJSONValue(dec, nil)
+				if err != nil {
+					return nil, err
+				}
+				obj.set(k.(string), v)
+			}
+			_, err := dec.Token()
+			return obj, err
+		case '[':
+			list := []interface{}{}
+			for dec.More() {
+				v, err := readJSONValue(dec, nil)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	}
+	return tok, nil
+}
+
+func writeJSONValue(w *bufio.Writer, v interface{}, opts convertOptions, depth int) error {
+	indent := opts.indent()
+	newline := func(d int) {
+		if indent > 0 {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(" ", indent*d))
+		}
+	}
+	switch v := v.(type) {
+	case *dataObject:
+		if len(v.keys) == 0 {
+			_, err := w.WriteString("{}")
+			return err
+		}
+		keys := v.keys
+		if opts.SortKeys {
+			keys = v.sorted()
+		}
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			newline(depth + 1)
+			w.WriteString(jsonString(k))
+			w.WriteByte(':')
+			if indent > 0 {
+				w.WriteByte(' ')
+			}
+			if err := writeJSONValue(w, v.values[k], opts, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		return w.WriteByte('}')
+	case []interface{}:
+		if len(v) == 0 {
+			_, err := w.WriteString("[]")
+			return err
+		}
+		w.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			newline(depth + 1)
+			if err := writeJSONValue(w, item, opts, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(depth)
+		return w.WriteByte(']')
+	case nil:
+		_, err := w.WriteString("null")
+		return err
+	case bool:
+		_, err := w.WriteString(strconv.FormatBool(v))
+		return err
+	case json.Number:
+		_, err := w.WriteString(v.String())
+		return err
+	case string:
+		_, err := w.WriteString(jsonString(v))
+		return err
+	}
+	return fmt.Errorf("cannot write %T", v)
+}
+
+// jsonString quotes s as JSON without escaping HTML characters.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// floatNumber formats f so that it reads back as a float.
+func floatNumber(f float64) interface{} {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return json.Number(s)
+}
+
+// YAML.
+
+// readYAML reads a document, or a list of them for a multi-document
+// stream. Aliases are expanded, up to maxYAMLNodes nodes in all.
+func readYAML(data []byte) (interface{}, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []interface{}
+	budget := maxYAMLNodes
+	for {
+		var n yaml.Node
+		if err := dec.Decode(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		v, err := fromYAMLNode(&n, &budget)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, v)
+	}
+	switch len(docs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return docs[0], nil
+	}
+	return docs, nil
+}
+
+func fromYAMLNode(n *yaml.Node, budget *int) (interface{}, error) {
+	if *budget--; *budget < 0 {
+		return nil, errors.New("document expands to too many nodes")
+	}
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return fromYAMLNode(n.Content[0], budget)
+	case yaml.AliasNode:
+		return fromYAMLNode(n.Alias, budget)
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := fromYAMLNode(c, budget)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		obj := newDataObject()
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, vn := n.Content[i], n.Content[i+1]
+			v, err := fromYAMLNode(vn, budget)
+			if err != nil {
+				return nil, err
+			}
+			// Merge keys add the entries not set explicitly.
+			if k.ShortTag() == "!!merge" {
+				merged := []interface{}{v}
+				if list, ok := v.([]interface{}); ok {
+					merged = list
+				}
+				for _, m := range merged {
+					if mo, ok := m.(*dataObject); ok {
+						for _, mk := range mo.keys {
+							if _, set := obj.values[mk]; !set {
+								obj.set(mk, mo.values[mk])
+							}
+						}
+					}
+				}
+				continue
+			}
+			obj.set(k.Value, v)
+		}
+		return obj, nil
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := n.Decode(&b)
+		return b, err
+	case "!!int":
+		var i int64
+		if err := n.Decode(&i); err != nil {
+			return n.Value, nil // too big for int64: keep the text
+		}
+		return json.Number(strconv.FormatInt(i, 10)), nil
+	case "!!float":
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, err
+		}
+		return floatNumber(f), nil
+	}
+	return n.Value, nil
+}
+
+func writeYAML(w io.Writer, v interface{}, opts convertOptions) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(max(opts.indent(), 2))
+	if err := enc.Encode(toYAMLNode(v, opts)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func toYAMLNode(v interface{}, opts convertOptions) *yaml.Node {
+	switch v := v.(type) {
+	case *dataObject:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		keys := v.keys
+		if opts.SortKeys {
+			keys = v.sorted()
+		}
+		for _, k := range keys {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, toYAMLNode(v.values[k], opts))
+		}
+		return n
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, toYAMLNode(item, opts))
+		}
+		return n
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(v.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}
+	}
+	s := fmt.Sprint(v)
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	if strings.Contains(strings.TrimSuffix(s, "\n"), "\n") {
+		n.Style = yaml.LiteralStyle
+	}
+	return n
+}
+
+// TOML.
+
+// readTOML reads a document in its key order, which the decoder's
+// metadata records.
+func readTOML(data []byte) (interface{}, error) {
+	var m map[string]interface{}
+	md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	order := map[string]int{}
+	for i, k := range md.Keys() {
+		key := strings.Join(k, "\x00")
+		if _, ok := order[key]; !ok {
+			order[key] = i
+		}
+	}
+	return fromTOML(m, "", order), nil
+}
+
+func fromTOML(v interface{}, at string, order map[string]int) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		prefix := at
+		if prefix != "" {
+			prefix += "\x00"
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			oi, iok := order[prefix+keys[i]]
+			oj, jok := order[prefix+keys[j]]
+			if iok != jok {
+				return iok
+			}
+			if oi != oj {
+				return oi < oj
+			}
+			return keys[i] < keys[j]
+		})
+		obj := newDataObject()
+		for _, k := range keys {
+			obj.set(k, fromTOML(v[k], prefix+k, order))
+		}
+		return obj
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = fromTOML(item, at, order)
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = fromTOML(item, at, order)
+		}
+		return list
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		return floatNumber(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case bool, string:
+		return v
+	}
+	return fmt.Sprint(v) // local dates and times
+}
+
+var bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(k string) string {
+	if bareTOMLKey.MatchString(k) {
+		return k
+	}
+	return tomlString(k)
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeTOML writes an object: plain keys first, then tables, then arrays
+// of tables, each in input order. TOML has no null.
+func writeTOML(w *bufio.Writer, v interface{}, opts convertOptions) error {
+	obj, ok := v.(*dataObject)
+	if !ok {
+		return errors.New("TOML documents must be objects")
+	}
+	return writeTOMLTable(w, obj, nil, opts)
+}
+
+func isTableArray(v interface{}) bool {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(*dataObject); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func writeTOMLTable(w *bufio.Writer, obj *dataObject, at []string, opts convertOptions) error {
+	keys := obj.keys
+	if opts.SortKeys {
+		keys = obj.sorted()
+	}
+	for _, k := range keys {
+		v := obj.values[k]
+		if _, table := v.(*dataObject); table || isTableArray(v) {
+			continue
+		}
+		text, err := tomlInline(v, append(at, k))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s = %s\n", tomlKey(k), text)
+	}
+	header := func(path []string) string {
+		parts := make([]string, len(path))
+		for i, p := range path {
+			parts[i] = tomlKey(p)
+		}
+		return strings.Join(parts, ".")
+	}
+	for _, k := range keys {
+		if child, ok := obj.values[k].(*dataObject); ok {
+			path := append(append([]string(nil), at...), k)
+			fmt.Fprintf(w, "\n[%s]\n", header(path))
+			if err := writeTOMLTable(w, child, path, opts); err != nil {
+				return err
+			}
+		}
+	}
+	for _, k := range keys {
+		if v := obj.values[k]; isTableArray(v) {
+			path := append(append([]string(nil), at...), k)
+			for _, item := range v.([]interface{}) {
+				fmt.Fprintf(w, "\n[[%s]]\n", header(path))
+				if err := writeTOMLTable(w, item.(*dataObject), path, opts); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func tomlInline(v interface{}, at []string) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML cannot hold null, at %s", strings.Join(at, "."))
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case string:
+		return tomlString(v), nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			text, err := tomlInline(item, append(at, strconv.Itoa(i)))
+			if err != nil {
+				return "", err
+			}
+			parts[i] = text
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case *dataObject:
+		parts := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			text, err := tomlInline(v.values[k], append(at, k))
+			if err != nil {
+				return "", err
+			}
+			parts[i] = tomlKey(k) + " = " + text
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	}
+	return "", fmt.Errorf("cannot write %T", v)
+}
+
+// CSV.
+
+// writeCSV writes rows, objects flattened to dotted columns, as CSV. each
+// is called twice: to collect the columns, then to write the rows.
+func writeCSV(w *bufio.Writer, each func(func(interface{}) error) error, opts convertOptions) error {
+	var columns []string
+	seen := map[string]bool{}
+	err := each(func(row interface{}) error {
+		flat := newDataObject()
+		flattenRow(flat, "", row)
+		for _, k := range flat.keys {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if opts.SortKeys {
+		sort.Strings(columns)
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.comma()
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	err = each(func(row interface{}) error {
+		flat := newDataObject()
+		flattenRow(flat, "", row)
+		cells := make([]string, len(columns))
+		for i, c := range columns {
+			cells[i] = csvCell(flat.values[c])
+		}
+		return cw.Write(cells)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// flattenRow sets the scalar leaves of v in out under dotted keys; a row
+// that is not an object is a single "value" column.
+func flattenRow(out *dataObject, prefix string, v interface{}) {
+	obj, ok := v.(*dataObject)
+	if !ok {
+		if prefix == "" {
+			prefix = "value"
+		}
+		out.set(prefix, v)
+		return
+	}
+	for _, k := range obj.keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if child, ok := obj.values[k].(*dataObject); ok && len(child.keys) > 0 {
+			flattenRow(out, key, child)
+		} else {
+			out.set(key, obj.values[k])
+		}
+	}
+}
+
+// csvCell writes lists and objects as JSON, which inferTypes reads back.
+func csvCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	}
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	writeJSONValue(bw, v, convertOptions{Indent: new(int)}, 0)
+	bw.Flush()
+	return buf.String()
+}
+
+// XML.
+//
+// Elements map to objects as most converters do: attributes become "@"
+// keys, text beside attributes or children becomes "#text", repeated
+// elements become lists, and an element with only text becomes a string;
+// an empty one becomes null.
+
+func readXML(data []byte, opts convertOptions) (interface{}, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil, errors.New("no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := readXMLElement(dec, start, opts)
+			if err != nil {
+				return nil, err
+			}
+			root := newDataObject()
+			root.set(start.Name.Local, v)
+			return root, nil
+		}
+	}
+}
+
+func readXMLElement(dec *xml.Decoder, start xml.StartElement, opts convertOptions) (interface{}, error) {
+	scalar := func(s string) interface{} {
+		if opts.InferTypes {
+			return inferScalar(s)
+		}
+		return s
+	}
+	obj := newDataObject()
+	for _, a := range start.Attr {
+		if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" {
+			continue
+		}
+		obj.set("@"+a.Name.Local, scalar(a.Value))
+	}
+	repeated := map[string]bool{}
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := readXMLElement(dec, t, opts)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			prev, exists := obj.values[name]
+			switch {
+			case !exists:
+				obj.set(name, child)
+			case repeated[name]:
+				obj.values[name] = append(prev.([]interface{}), child)
+			default:
+				repeated[name] = true
+				obj.values[name] = []interface{}{prev, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			body := strings.TrimSpace(text.String())
+			if len(obj.keys) == 0 {
+				if body == "" {
+					return nil, nil
+				}
+				return scalar(body), nil
+			}
+			if body != "" {
+				obj.set("#text", scalar(body))
+			}
+			return obj, nil
+		}
+	}
+}
+
+func writeXML(w *bufio.Writer, v interface{}, opts convertOptions) error {
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	name := opts.RootElement
+	if obj, ok := v.(*dataObject); ok && name == "" && len(obj.keys) == 1 && !strings.HasPrefix(obj.keys[0], "@") {
+		if _, list := obj.values[obj.keys[0]].([]interface{}); !list {
+			return writeXMLElement(w, obj.keys[0], obj.values[obj.keys[0]], opts, 0)
+		}
+	}
+	if name == "" {
+		name = "root"
+	}
+	if list, ok := v.([]interface{}); ok {
+		wrapped := newDataObject()
+		wrapped.set("item", list)
+		v = wrapped
+	}
+	return writeXMLElement(w, name, v, opts, 0)
+}
+
+// xmlName makes a key a valid element or attribute name.
+func xmlName(k string) string {
+	var b strings.Builder
+	for i, r := range k {
+		switch {
+		case unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.')):
+			b.WriteRune(r)
+		case i == 0 && unicode.IsDigit(r):
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func xmlText(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func writeXMLElement(w *bufio.Writer, name string, v interface{}, opts convertOptions, depth int) error {
+	pad := strings.Repeat(" ", opts.indent()*depth)
+	newline := "\n"
+	if opts.indent() == 0 {
+		pad, newline = "", ""
+	}
+	name = xmlName(name)
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				wrapped := newDataObject()
+				wrapped.set("item", item)
+				item = wrapped
+			}
+			if err := writeXMLElement(w, name, item, opts, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		fmt.Fprintf(w, "%s<%s/>%s", pad, name, newline)
+		return nil
+	case *dataObject:
+		keys := v.keys
+		if opts.SortKeys {
+			keys = v.sorted()
+		}
+		w.WriteString(pad + "<" + name)
+		var children []string
+		text, hasText := "", false
+		for _, k := range keys {
+			switch {
+			case strings.HasPrefix(k, "@"):
+				fmt.Fprintf(w, ` %s="%s"`, xmlName(k[1:]), xmlText(csvCell(v.values[k])))
+			case k == "#text":
+				text, hasText = csvCell(v.values[k]), true
+			default:
+				children = append(children, k)
+			}
+		}
+		if len(children) == 0 {
+			if !hasText {
+				w.WriteString("/>" + newline)
+				return nil
+			}
+			fmt.Fprintf(w, ">%s</%s>%s", xmlText(text), name, newline)
+			return nil
+		}
+		w.WriteString(">" + newline)
+		if hasText {
+			w.WriteString(pad + strings.Repeat(" ", opts.indent()) + xmlText(text) + newline)
+		}
+		for _, k := range children {
+			if err := writeXMLElement(w, k, v.values[k], opts, depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "%s</%s>%s", pad, name, newline)
+		return nil
+	}
+	fmt.Fprintf(w, "%s<%s>%s</%s>%s", pad, name, xmlText(csvCell(v)), name, newline)
+	return nil
+}
@@ -0,0 +1,67 @@
+package mcpserver
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+)
+
+// DashboardConfig enables a read-only HTML status page at /dashboard.
+// When an admin token is configured, the page asks for it as the HTTP
+// basic auth password; with admin.login, browsers are sent to the sign-in
+// page instead.
+type DashboardConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+var dashboardTemplate = template.Must(template.ParseFS(assets, "assets/dashboard.html"))
+
+// handleDashboard serves the status page.
+func (s *MCPServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	login, signedIn := s.adminSession(r)
+	if token := s.adminToken(); !signedIn && (token != "" || s.cfg.Admin.Login != nil) {
+		_, given, _ := r.BasicAuth()
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			if s.cfg.Admin.Login != nil {
+				http.Redirect(w, r, s.cfg.Proxy.basePath()+"/admin/login?next=/dashboard", http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="mcp-server"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	names := s.toolNames()
+	descriptions := make(map[string]string, len(names))
+	for _, name := range names {
+		descriptions[name] = s.tools[name].Description
+	}
+	jobs := map[string]int{}
+	for _, job := range s.jobs.list("", true, "") {
+		jobs[job.Status]++
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardTemplate.Execute(w, map[string]interface{}{
+		"Profile":      s.cfg.Profile,
+		"Runtime":      readRuntimeStats(),
+		"Tools":        s.usage.stats(names),
+		"Clients":      s.clients.stats(s.sessions.list()),
+		"Descriptions": descriptions,
+		"Flags":        s.flags.snapshot(),
+		"Jobs":         jobs,
+		"Approvals":    s.approvals.list("pending"),
+		"Base":         s.cfg.Proxy.basePath(),
+		"Login":        dashboardLogin(login),
+	})
+}
+
+// dashboardLogin is what the page shows of a sign-in: the user and the
+// CSRF token its sign-out form posts.
+func dashboardLogin(l *adminLogin) map[string]string {
+	if l == nil {
+		return nil
+	}
+	return map[string]string{"User": l.user, "CSRF": l.csrf}
+}
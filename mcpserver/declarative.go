@@ -0,0 +1,369 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultBackendTimeout = 30 * time.Second
+	// maxBackendOutput caps how much of a backend's response is returned.
+	maxBackendOutput = 1 << 20
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"env": func(name string) (string, error) {
+		return "", fmt.Errorf("env %q: only headers can read the tool's env", name)
+	},
+}
+
+// loadDeclarativeTools materializes handlers for tools defined in config.
+// A config tool replaces a Go tool of the same name added with WithTool,
+// but not a built-in one.
+func (s *MCPServer) loadDeclarativeTools(tools []ToolConfig) error {
+	custom := map[string]bool{}
+	for _, t := range s.custom {
+		custom[t.tool.Name] = true
+	}
+	for _, tc := range tools {
+		if tc.Name == "" {
+			return fmt.Errorf("tool without a name")
+		}
+		if _, exists := s.tools[tc.Name]; exists && !custom[tc.Name] {
+			return fmt.Errorf("tool %q: already registered", tc.Name)
+		}
+		delete(custom, tc.Name)
+		handler, err := s.newBackendHandler(tc.Backend)
+		if err != nil {
+			return fmt.Errorf("tool %q: %w", tc.Name, err)
+		}
+		if c := tc.Canary; c != nil {
+			if c.Percent < 0 || c.Percent > 100 {
+				return fmt.Errorf("tool %q: canary percent must be between 0 and 100", tc.Name)
+			}
+			canary, err := s.newBackendHandler(c.Backend)
+			if err != nil {
+				return fmt.Errorf("tool %q: canary: %w", tc.Name, err)
+			}
+			handler = s.canaryHandler(tc.Name, *c, handler, canary)
+		}
+		var schema interface{} = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		}
+		if len(tc.InputSchema) > 0 {
+			schema = tc.InputSchema
+		}
+		s.addTool(Tool{
+			Name:        tc.Name,
+			Description: tc.Description,
+			InputSchema: schema,
+			Annotations: tc.Annotations,
+		}, handler)
+	}
+	return s.checkPipelines(tools)
+}
+
+func (s *MCPServer) newBackendHandler(b BackendConfig) (ToolHandler, error) {
+	env, err := toolEnv(b.Env, s.secrets)
+	if err != nil {
+		return nil, err
+	}
+	if len(env) > 0 && b.Type != "http" && b.Type != "graphql" && b.Type != "exec" {
+		return nil, fmt.Errorf("env applies to http, graphql and exec backends only")
+	}
+	switch b.Type {
+	case "http":
+		return newHTTPBackend(b, env)
+	case "exec":
+		runAs, err := s.cfg.RunAs.resolve()
+		if err != nil {
+			return nil, err
+		}
+		return newExecBackend(b, env, s.cfg.Hardening, runAs)
+	case "graphql":
+		return newGraphQLBackend(b, env)
+	case "pipeline":
+		return s.newPipelineBackend(b)
+	case "template":
+		return newTemplateBackend(b)
+	case "static":
+		text := b.Text
+		return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			return textResult(text), nil
+		}, nil
+	case "":
+		return nil, fmt.Errorf("backend type is required")
+	default:
+		return nil, fmt.Errorf("unknown backend type %q", b.Type)
+	}
+}
+
+func newHTTPBackend(b BackendConfig, env map[string]string) (ToolHandler, error) {
+	if b.URL == "" {
+		return nil, fmt.Errorf("http backend requires url")
+	}
+	url, err := compileTemplate("url", b.URL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := compileTemplate("body", b.Body)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := compileHeaders(b.Headers, env)
+	if err != nil {
+		return nil, err
+	}
+	method := strings.ToUpper(b.Method)
+	if method == "" {
+		method = http.MethodGet
+		if b.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	timeout := backendTimeout(b)
+
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		data, err := argsMap(args)
+		if err != nil {
+			return nil, err
+		}
+		target, err := renderTemplate(url, data)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := renderTemplate(body, data)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if payload != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if err := applyHeaders(req, headers, data); err != nil {
+			return nil, err
+		}
+		if ToolContextFrom(ctx).DryRun {
+			return dryRunResult("send %s %s with headers %s and body %q", method, target, headerNames(req.Header), payload)
+		}
+
+		resp, err := ToolContextFrom(ctx).HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(io.LimitReader(resp.Body, maxBackendOutput))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, out)
+		}
+		return textResult(string(out)), nil
+	}, nil
+}
+
+// compileHeaders compiles header templates, which can read env with
+// {{env "NAME"}}.
+func compileHeaders(h map[string]string, env map[string]string) (map[string]*template.Template, error) {
+	headers := make(map[string]*template.Template, len(h))
+	for k, v := range h {
+		t, err := compileTemplate("header "+k, v)
+		if err != nil {
+			return nil, err
+		}
+		headers[k] = t.Funcs(envFuncs(env))
+	}
+	return headers, nil
+}
+
+func applyHeaders(req *http.Request, headers map[string]*template.Template, data interface{}) error {
+	for k, t := range headers {
+		v, err := renderTemplate(t, data)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(k, v)
+	}
+	return nil
+}
+
+func newExecBackend(b BackendConfig, env map[string]string, hardening HardeningConfig, runAs *runAsUser) (ToolHandler, error) {
+	if b.Command == "" {
+		return nil, fmt.Errorf("exec backend requires command")
+	}
+	if b.Sandbox != nil && b.Sandbox.Image == "" {
+		return nil, fmt.Errorf("exec sandbox requires image")
+	}
+	args := make([]*template.Template, len(b.Args))
+	for i, a := range b.Args {
+		var err error
+		if args[i], err = compileTemplate(fmt.Sprintf("arg %d", i), a); err != nil {
+			return nil, err
+		}
+	}
+	timeout := backendTimeout(b)
+	toolVars := envList(env)
+
+	return func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		data, err := argsMap(raw)
+		if err != nil {
+			return nil, err
+		}
+		argv := make([]string, len(args))
+		for i, t := range args {
+			if argv[i], err = renderTemplate(t, data); err != nil {
+				return nil, err
+			}
+		}
+
+		if ToolContextFrom(ctx).DryRun {
+			cmdline := append([]string{b.Command}, argv...)
+			if b.Sandbox != nil {
+				return dryRunResult("run %q in sandbox image %s", cmdline, b.Sandbox.Image)
+			}
+			return dryRunResult("run %q", cmdline)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var sessionDir string
+		env := traceEnv(ctx)
+		if sess := sessionFrom(ctx); sess != nil {
+			if sess.Dir != "" {
+				if sessionDir, err = sess.workdir(); err != nil {
+					return nil, err
+				}
+			}
+			env = append(env, sess.Env...)
+		}
+
+		var cmd *exec.Cmd
+		if b.Sandbox != nil {
+			cmd = b.Sandbox.command(ctx, b, argv, sessionDir, env, toolVars)
+		} else {
+			if hardening.Enabled {
+				if cmd, err = hardening.command(ctx, nil, []string{sessionDir}, b.Command, argv); err != nil {
+					return nil, err
+				}
+			} else {
+				cmd = exec.CommandContext(ctx, b.Command, argv...)
+			}
+			cmd.Dir = b.Dir
+			if sessionDir != "" && !filepath.IsAbs(b.Dir) {
+				cmd.Dir = filepath.Join(sessionDir, b.Dir)
+			}
+			if env != nil || runAs != nil || len(toolVars) > 0 {
+				// Session variables win over the identity, and the tool's
+				// own env over both.
+				cmd.Env = append(append(append(os.Environ(), runAs.env()...), env...), toolVars...)
+			}
+			if err := runAs.apply(cmd, sessionDir); err != nil {
+				return nil, err
+			}
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &limitedWriter{w: &stdout, n: maxBackendOutput}
+		cmd.Stderr = &limitedWriter{w: &stderr, n: maxBackendOutput}
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return textResult(stdout.String()), nil
+	}, nil
+}
+
+func newTemplateBackend(b BackendConfig) (ToolHandler, error) {
+	t, err := compileTemplate("template", b.Template)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		data, err := argsMap(args)
+		if err != nil {
+			return nil, err
+		}
+		text, err := renderTemplate(t, data)
+		if err != nil {
+			return nil, err
+		}
+		return textResult(text), nil
+	}, nil
+}
+
+func backendTimeout(b BackendConfig) time.Duration {
+	if b.Timeout > 0 {
+		return time.Duration(b.Timeout)
+	}
+	return defaultBackendTimeout
+}
+
+func compileTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func renderTemplate(t *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// argsMap decodes tool arguments for use as template data.
+func argsMap(args json.RawMessage) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	if len(args) == 0 || string(args) == "null" {
+		return data, nil
+	}
+	if err := json.Unmarshal(args, &data); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	return data, nil
+}
+
+// limitedWriter silently discards anything written past n bytes.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+	chunk := p
+	if len(chunk) > l.n {
+		chunk = chunk[:l.n]
+	}
+	n, err := l.w.Write(chunk)
+	l.n -= n
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
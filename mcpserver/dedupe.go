@@ -0,0 +1,104 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const dedupedCallsMetric = "mcp_deduplicated_calls_total"
+
+// callGroup collapses identical concurrent calls of idempotent tools into
+// one execution, so a client retrying a slow call does not start it again.
+// It is shared by all generations.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*sharedCall
+}
+
+// sharedCall is one execution and the calls waiting for it.
+type sharedCall struct {
+	done    chan struct{}
+	result  interface{}     // for the call that started it
+	raw     json.RawMessage // for the calls that joined it
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newCallGroup() *callGroup {
+	return &callGroup{calls: map[string]*sharedCall{}}
+}
+
+// idempotentTool reports whether the tool is annotated idempotentHint.
+func (s *MCPServer) idempotentTool(name string) bool {
+	tool, ok := s.tools[name]
+	return ok && tool.Annotations != nil && tool.Annotations.IdempotentHint != nil && *tool.Annotations.IdempotentHint
+}
+
+// executeShared runs a tool call, joining an identical one already in
+// flight when the tool is idempotent: the same API key calling the same
+// tool with equal arguments. The execution runs until it finishes or every
+// call waiting for it has gone.
+func (s *MCPServer) executeShared(ctx context.Context, name string, args json.RawMessage) interface{} {
+	if !s.idempotentTool(name) {
+		return s.executeTool(ctx, name, args)
+	}
+	id := callerFrom(ctx).KeyName() + "\x00" + name + "\x00" + argumentsHash(args)
+	g := s.calls
+	g.mu.Lock()
+	c, joined := g.calls[id]
+	if !joined {
+		// The first caller going away must not cancel the others' result.
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &sharedCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[id] = c
+		go func() {
+			defer cancel()
+			result := s.executeDetached(runCtx, name, args)
+			raw, err := json.Marshal(result)
+			if err != nil {
+				raw, _ = json.Marshal(errorResult(err))
+			}
+			g.mu.Lock()
+			delete(g.calls, id)
+			c.result, c.raw = result, raw
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+	if joined {
+		s.metrics.inc(dedupedCallsMetric, "tool", name)
+	}
+
+	select {
+	case <-c.done:
+		if joined {
+			// Joined calls get their own copy of the result.
+			return c.raw
+		}
+		return c.result
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+		}
+		g.mu.Unlock()
+		return errorResult(ctx.Err())
+	}
+}
+
+// executeDetached runs a tool outside any request, turning a panic into
+// an error result rather than a crash.
+func (s *MCPServer) executeDetached(ctx context.Context, name string, args json.RawMessage) (result interface{}) {
+	defer func() {
+		if p := recover(); p != nil {
+			id := recovered(s.metrics, "shared:"+name, p)
+			result = errorResult(fmt.Errorf("internal error (correlation ID %s)", id))
+		}
+	}()
+	return s.executeTool(ctx, name, args)
+}
@@ -0,0 +1,67 @@
+package mcpserver
+
+import "fmt"
+
+// DeprecationConfig marks a tool as deprecated. Replacement names the tool
+// clients should move to; Message is optional extra guidance.
+type DeprecationConfig struct {
+	Replacement string `json:"replacement,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+const deprecatedCallsMetric = "mcp_deprecated_tool_calls_total"
+
+// applyDeprecations annotates deprecated tools and registers aliases. It
+// runs after all tools are registered so any tool may be referenced.
+func (s *MCPServer) applyDeprecations() error {
+	for name, dep := range s.cfg.Deprecations {
+		tool, ok := s.tools[name]
+		if !ok {
+			return fmt.Errorf("deprecation for unknown tool %q", name)
+		}
+		if dep.Replacement != "" {
+			if _, ok := s.tools[dep.Replacement]; !ok {
+				return fmt.Errorf("tool %q: unknown replacement %q", name, dep.Replacement)
+			}
+		}
+		note := "Deprecated."
+		if dep.Replacement != "" {
+			note = fmt.Sprintf("Deprecated: use %s instead.", dep.Replacement)
+		}
+		if dep.Message != "" {
+			note += " " + dep.Message
+		}
+		tool.Description = note + " " + tool.Description
+		ann := ToolAnnotations{}
+		if tool.Annotations != nil {
+			ann = *tool.Annotations
+		}
+		ann.Deprecated = true
+		ann.ReplacedBy = dep.Replacement
+		tool.Annotations = &ann
+		s.tools[name] = tool
+	}
+
+	for alias, target := range s.cfg.Aliases {
+		if _, exists := s.tools[alias]; exists {
+			return fmt.Errorf("alias %q collides with a registered tool", alias)
+		}
+		if _, ok := s.tools[target]; !ok {
+			return fmt.Errorf("alias %q: unknown tool %q", alias, target)
+		}
+	}
+	return nil
+}
+
+// resolveTool maps an alias to its target tool and records use of aliases
+// and deprecated tools.
+func (s *MCPServer) resolveTool(name string) string {
+	if target, ok := s.cfg.Aliases[name]; ok {
+		s.metrics.inc(deprecatedCallsMetric, "tool", name, "replacement", target)
+		return target
+	}
+	if dep, ok := s.cfg.Deprecations[name]; ok {
+		s.metrics.inc(deprecatedCallsMetric, "tool", name, "replacement", dep.Replacement)
+	}
+	return name
+}
@@ -0,0 +1,452 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type scanDependenciesArgs struct {
+	Root    string `json:"root,omitempty" jsonschema:"description=Workspace root to scan"`
+	Path    string `json:"path,omitempty" jsonschema:"description=Root-relative module directory or lockfile (default the root)"`
+	Scanner string `json:"scanner,omitempty" jsonschema:"enum=auto|govulncheck|osv-scanner,description=Scanner to use; auto picks govulncheck for Go modules"`
+}
+
+// vulnFinding is one known vulnerability affecting a dependency.
+type vulnFinding struct {
+	ID            string   `json:"id"`
+	Aliases       []string `json:"aliases,omitempty"`
+	Summary       string   `json:"summary,omitempty"`
+	Package       string   `json:"package"`
+	Ecosystem     string   `json:"ecosystem,omitempty"`
+	Version       string   `json:"version,omitempty"`
+	FixedVersions []string `json:"fixedVersions"`
+	Severity      string   `json:"severity"`
+	Score         float64  `json:"score,omitempty"`
+	// Reachability is how far govulncheck traced the vulnerable code:
+	// "called", "imported" or only "required" by the module.
+	Reachability string   `json:"reachability,omitempty"`
+	CallSites    []string `json:"callSites,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	URL          string   `json:"url"`
+}
+
+const (
+	scanTimeout    = 5 * time.Minute
+	maxScanOutput  = 32 << 20
+	maxCallSites   = 5
+	osvEntryPrefix = "https://osv.dev/vulnerability/"
+)
+
+var severityRank = map[string]int{"critical": 0, "high": 1, "medium": 2, "low": 3, "unknown": 4}
+
+func (s *MCPServer) scanDependenciesTool(ctx context.Context, args scanDependenciesArgs) (map[string]interface{}, error) {
+	r, err := s.cfg.Workspace.root(args.Root)
+	if err != nil {
+		return nil, err
+	}
+	rel := strings.Trim(path.Clean("/"+filepath.ToSlash(args.Path)), "/")
+	full, err := r.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(full)
+	if err != nil {
+		return nil, err
+	}
+	scanner := args.Scanner
+	if scanner == "" || scanner == "auto" {
+		scanner = s.pickScanner(full, st.IsDir())
+	}
+	var findings []vulnFinding
+	switch scanner {
+	case "govulncheck":
+		if s.cfg.Workspace.Govulncheck == "" {
+			return nil, errors.New("govulncheck is not configured")
+		}
+		dir := full
+		if !st.IsDir() {
+			if filepath.Base(full) != "go.mod" {
+				return nil, fmt.Errorf("govulncheck scans Go modules; %s is not a go.mod", rel)
+			}
+			dir = filepath.Dir(full)
+		}
+		findings, err = runGovulncheck(ctx, s.commands, s.cfg.Workspace.Govulncheck, dir, r.Path)
+	case "osv-scanner":
+		if s.cfg.Workspace.OSVScanner == "" {
+			return nil, errors.New("osv-scanner is not configured")
+		}
+		findings, err = runOSVScanner(ctx, s.commands, s.cfg.Workspace.OSVScanner, full, st.IsDir(), r.Path)
+	default:
+		return nil, errors.New("no dependency scanner is configured for this path")
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.ID < b.ID
+	})
+	summary := map[string]int{"total": len(findings)}
+	for _, f := range findings {
+		summary[f.Severity]++
+		if f.Reachability == "called" {
+			summary["called"]++
+		}
+	}
+	if findings == nil {
+		findings = []vulnFinding{}
+	}
+	return map[string]interface{}{
+		"scanner":  scanner,
+		"root":     r.Name,
+		"path":     rel,
+		"findings": findings,
+		"summary":  summary,
+	}, nil
+}
+
+// pickScanner chooses govulncheck for Go modules, which it can check for
+// reachable code, and osv-scanner for everything else.
+func (s *MCPServer) pickScanner(full string, isDir bool) string {
+	goModule := filepath.Base(full) == "go.mod"
+	if isDir {
+		_, err := os.Stat(filepath.Join(full, "go.mod"))
+		goModule = err == nil
+	}
+	switch {
+	case goModule && s.cfg.Workspace.Govulncheck != "":
+		return "govulncheck"
+	case s.cfg.Workspace.OSVScanner != "":
+		return "osv-scanner"
+	}
+	return ""
+}
+
+// runScanner runs a scanner in dir, below the workspace root, and returns
+// its standard output. okExit lists the exit codes besides 0 that mean the
+// scan itself worked. Without cmds the scanner runs with the server's own
+// environment, which terraform needs for provider credentials.
+func runScanner(ctx context.Context, cmds *workspaceCommands, root, dir, name string, args []string, okExit ...int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if cmds != nil {
+		var err error
+		if cmd, err = cmds.command(ctx, root, false, dir, name, args); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), traceEnv(ctx)...)
+	}
+	var stdout, stderr bytes.Buffer
+	lw := &limitedWriter{w: &stdout, n: maxScanOutput}
+	cmd.Stdout = lw
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxBackendOutput}
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s did not finish within %v", filepath.Base(name), scanTimeout)
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		for _, code := range okExit {
+			if exit.ExitCode() == code {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", filepath.Base(name), err, firstLine(strings.TrimSpace(stderr.String())))
+	}
+	if lw.n <= 0 {
+		return nil, fmt.Errorf("%s: output exceeds %d bytes", filepath.Base(name), maxScanOutput)
+	}
+	return stdout.Bytes(), nil
+}
+
+// osvEntry is the part of an OSV vulnerability record scan_dependencies
+// reads.
+type osvEntry struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Details  string   `json:"details"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Ranges []struct {
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+func (e osvEntry) summary() string {
+	if e.Summary != "" {
+		return e.Summary
+	}
+	return firstLine(e.Details)
+}
+
+// fixedVersions lists the versions of pkg that fix e.
+func (e osvEntry) fixedVersions(pkg string) []string {
+	versions := []string{}
+	seen := map[string]bool{}
+	for _, a := range e.Affected {
+		if a.Package.Name != pkg {
+			continue
+		}
+		for _, r := range a.Ranges {
+			for _, ev := range r.Events {
+				if v := ev["fixed"]; v != "" && !seen[v] {
+					seen[v] = true
+					versions = append(versions, v)
+				}
+			}
+		}
+	}
+	return versions
+}
+
+// severity rates e from its database's rating or a numeric score.
+// Vector-only CVSS ratings are not scored, so they come out "unknown".
+func (e osvEntry) severity() (string, float64) {
+	label := e.DatabaseSpecific.Severity
+	for _, a := range e.Affected {
+		if label == "" {
+			label = a.DatabaseSpecific.Severity
+		}
+	}
+	for _, sv := range e.Severity {
+		if score, err := strconv.ParseFloat(sv.Score, 64); err == nil {
+			return scoreSeverity(score), score
+		}
+	}
+	switch l := strings.ToLower(label); l {
+	case "critical", "high", "medium", "low":
+		return l, 0
+	case "moderate":
+		return "medium", 0
+	}
+	return "unknown", 0
+}
+
+// scoreSeverity maps a CVSS score to its qualitative rating.
+func scoreSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "medium"
+	case score > 0:
+		return "low"
+	}
+	return "unknown"
+}
+
+// runGovulncheck scans the Go module in dir. Its JSON output is a stream
+// of messages: OSV entries, then one finding per vulnerable trace, which
+// are merged per vulnerability, keeping the deepest reachability. Call
+// sites are given relative to rootPath.
+func runGovulncheck(ctx context.Context, cmds *workspaceCommands, bin, dir, rootPath string) ([]vulnFinding, error) {
+	out, err := runScanner(ctx, cmds, rootPath, dir, bin, []string{"-format", "json", "./..."})
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]osvEntry{}
+	byID := map[string]*vulnFinding{}
+	var order []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var msg struct {
+			OSV     *osvEntry `json:"osv"`
+			Finding *struct {
+				OSV          string `json:"osv"`
+				FixedVersion string `json:"fixed_version"`
+				Trace        []struct {
+					Module   string `json:"module"`
+					Version  string `json:"version"`
+					Package  string `json:"package"`
+					Function string `json:"function"`
+					Receiver string `json:"receiver"`
+					Position *struct {
+						Filename string `json:"filename"`
+						Line     int    `json:"line"`
+					} `json:"position"`
+				} `json:"trace"`
+			} `json:"finding"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("govulncheck: %w", err)
+		}
+		if msg.OSV != nil {
+			entries[msg.OSV.ID] = *msg.OSV
+		}
+		f := msg.Finding
+		if f == nil || len(f.Trace) == 0 {
+			continue
+		}
+		vuln := f.Trace[0]
+		reach := "required"
+		if vuln.Function != "" {
+			reach = "called"
+		} else if vuln.Package != "" {
+			reach = "imported"
+		}
+		v := byID[f.OSV]
+		if v == nil {
+			v = &vulnFinding{ID: f.OSV, Package: vuln.Module, Ecosystem: "Go", Version: vuln.Version, FixedVersions: []string{}, URL: osvEntryPrefix + f.OSV}
+			if f.FixedVersion != "" {
+				v.FixedVersions = []string{f.FixedVersion}
+			}
+			byID[f.OSV] = v
+			order = append(order, f.OSV)
+		}
+		if reachRank(reach) < reachRank(v.Reachability) {
+			v.Reachability = reach
+		}
+		// The last frame of a trace is the caller in the scanned module.
+		if reach == "called" && len(v.CallSites) < maxCallSites {
+			if caller := f.Trace[len(f.Trace)-1]; caller.Position != nil {
+				rel, err := filepath.Rel(rootPath, caller.Position.Filename)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					rel = caller.Position.Filename
+				}
+				site := fmt.Sprintf("%s:%d", filepath.ToSlash(rel), caller.Position.Line)
+				if fn := vuln.Function; fn != "" {
+					if vuln.Receiver != "" {
+						fn = strings.TrimPrefix(vuln.Receiver, "*") + "." + fn
+					}
+					site += " calls " + vuln.Package + "." + fn
+				}
+				v.CallSites = append(v.CallSites, site)
+			}
+		}
+	}
+	findings := make([]vulnFinding, 0, len(order))
+	for _, id := range order {
+		v := byID[id]
+		if e, ok := entries[id]; ok {
+			v.Aliases, v.Summary = e.Aliases, e.summary()
+			v.Severity, v.Score = e.severity()
+		} else {
+			v.Severity = "unknown"
+		}
+		findings = append(findings, *v)
+	}
+	return findings, nil
+}
+
+func reachRank(reach string) int {
+	switch reach {
+	case "called":
+		return 0
+	case "imported":
+		return 1
+	case "required":
+		return 2
+	}
+	return 3
+}
+
+// runOSVScanner scans a lockfile, or a directory recursively, with
+// osv-scanner, which exits 1 when it finds vulnerabilities.
+func runOSVScanner(ctx context.Context, cmds *workspaceCommands, bin, full string, isDir bool, rootPath string) ([]vulnFinding, error) {
+	args := []string{"--format", "json"}
+	if isDir {
+		args = append(args, "--recursive", full)
+	} else {
+		args = append(args, "--lockfile", full)
+	}
+	out, err := runScanner(ctx, cmds, rootPath, rootPath, bin, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Results []struct {
+			Source struct {
+				Path string `json:"path"`
+			} `json:"source"`
+			Packages []struct {
+				Package struct {
+					Name      string `json:"name"`
+					Version   string `json:"version"`
+					Ecosystem string `json:"ecosystem"`
+				} `json:"package"`
+				Vulnerabilities []osvEntry `json:"vulnerabilities"`
+				Groups          []struct {
+					IDs         []string `json:"ids"`
+					MaxSeverity string   `json:"max_severity"`
+				} `json:"groups"`
+			} `json:"packages"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("osv-scanner: %w", err)
+	}
+	var findings []vulnFinding
+	for _, res := range report.Results {
+		source := res.Source.Path
+		if rel, err := filepath.Rel(rootPath, source); err == nil && !strings.HasPrefix(rel, "..") {
+			source = filepath.ToSlash(rel)
+		}
+		for _, p := range res.Packages {
+			entries := map[string]osvEntry{}
+			for _, e := range p.Vulnerabilities {
+				entries[e.ID] = e
+			}
+			// A group is one vulnerability known under several IDs.
+			for _, g := range p.Groups {
+				if len(g.IDs) == 0 {
+					continue
+				}
+				e := entries[g.IDs[0]]
+				f := vulnFinding{
+					ID: g.IDs[0], Aliases: g.IDs[1:], Summary: e.summary(),
+					Package: p.Package.Name, Ecosystem: p.Package.Ecosystem, Version: p.Package.Version,
+					FixedVersions: e.fixedVersions(p.Package.Name), Source: source, URL: osvEntryPrefix + g.IDs[0],
+				}
+				f.Severity, f.Score = e.severity()
+				if score, err := strconv.ParseFloat(g.MaxSeverity, 64); err == nil {
+					f.Severity, f.Score = scoreSeverity(score), score
+				}
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,125 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DiagnosticsConfig enables net/http/pprof and runtime statistics on a
+// separate listener. Addr must be a loopback address; Token, when set, is
+// required as a bearer token.
+type DiagnosticsConfig struct {
+	Addr  string `json:"addr,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// processStart is when the process started. It carries a monotonic clock
+// reading, so uptime is immune to wall-clock adjustments.
+var processStart = time.Now()
+
+// uptime returns how long the process has been running.
+func uptime() time.Duration {
+	return time.Since(processStart)
+}
+
+// RuntimeStats is a snapshot of Go runtime health.
+type RuntimeStats struct {
+	StartedAt     time.Time `json:"startedAt"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+
+	Goroutines   int       `json:"goroutines"`
+	HeapAlloc    uint64    `json:"heapAllocBytes"`
+	HeapInuse    uint64    `json:"heapInuseBytes"`
+	HeapObjects  uint64    `json:"heapObjects"`
+	Sys          uint64    `json:"sysBytes"`
+	NumGC        uint32    `json:"numGC"`
+	LastGC       time.Time `json:"lastGC"`
+	PauseTotalNs uint64    `json:"gcPauseTotalNs"`
+	LastPauseNs  uint64    `json:"gcLastPauseNs"`
+	GOMAXPROCS   int       `json:"gomaxprocs"`
+}
+
+func readRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return RuntimeStats{
+		StartedAt:     processStart.UTC(),
+		UptimeSeconds: int64(uptime().Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapObjects:   m.HeapObjects,
+		Sys:           m.Sys,
+		NumGC:         m.NumGC,
+		LastGC:        time.Unix(0, int64(m.LastGC)).UTC(),
+		PauseTotalNs:  m.PauseTotalNs,
+		LastPauseNs:   m.PauseNs[(m.NumGC+255)%256],
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+	}
+}
+
+// handleAdminRuntime serves GET /admin/runtime.
+func (s *MCPServer) handleAdminRuntime(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, readRuntimeStats())
+}
+
+// diagnosticsHandler serves pprof under /debug/pprof/ and runtime stats at
+// /debug/runtime.
+func diagnosticsHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, readRuntimeStats())
+	})
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkLoopback rejects diagnostics addresses reachable from other hosts.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("diagnostics address %q is not a loopback address", addr)
+}
+
+// serveDiagnostics runs the diagnostics listener until ctx is cancelled.
+func serveDiagnostics(ctx context.Context, cfg DiagnosticsConfig) error {
+	srv := &http.Server{Addr: cfg.Addr, Handler: diagnosticsHandler(cfg.Token)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,381 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// doctorProtocolVersion is the protocol version the doctor asks for.
+const doctorProtocolVersion = "2025-03-26"
+
+// doctorCheck is one item of the compliance report. Status is "pass",
+// "warn", "fail" or "skip".
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// doctor runs the compliance checklist against one server.
+type doctor struct {
+	*mcpClient
+	ctx     context.Context
+	session string
+	checks  []doctorCheck
+}
+
+// runDoctorCommand implements the "doctor" subcommand.
+func runDoctorCommand(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	target := fs.String("target", defaultTarget(), "MCP endpoint URL")
+	apiKey := fs.String("api-key", os.Getenv("MCP_API_KEY"), "API key sent as a bearer token (default $MCP_API_KEY)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	d := &doctor{
+		mcpClient: &mcpClient{target: *target, apiKey: *apiKey, http: &http.Client{Timeout: *timeout}},
+		ctx:       context.Background(),
+	}
+	d.run()
+
+	counts := map[string]int{}
+	for _, c := range d.checks {
+		counts[c.Status]++
+	}
+	failed := counts["fail"]
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"target": *target, "checks": d.checks, "passed": failed == 0})
+	} else {
+		fmt.Printf("MCP compliance check of %s\n\n", *target)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, c := range d.checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+		}
+		tw.Flush()
+		fmt.Printf("\n%d passed, %d warnings, %d failed, %d skipped\n", counts["pass"], counts["warn"], counts["fail"], counts["skip"])
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (d *doctor) report(name, status, format string, args ...interface{}) {
+	d.checks = append(d.checks, doctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// run performs the checks in order; without a successful initialize the
+// rest are skipped.
+func (d *doctor) run() {
+	if !d.checkInitialize() {
+		d.report("remaining checks", "skip", "initialize failed")
+		return
+	}
+	d.checkVersionNegotiation()
+	d.checkInitialized()
+	d.checkPing()
+	d.checkRequestID()
+	d.checkErrorCode("parse error", []byte(`{"jsonrpc": "2.0", "id": 1, "method": `), codeParseError)
+	d.checkErrorCode("invalid request", []byte(`{"id": 1, "method": "ping"}`), codeInvalidRequest)
+	d.checkErrorCode("unknown method", []byte(`{"jsonrpc": "2.0", "id": 1, "method": "doctor/no-such-method"}`), codeMethodNotFound)
+	d.checkErrorCode("invalid params", []byte(`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": 42}}`), codeInvalidParams)
+	d.checkToolsList()
+	d.checkInvalidCursor()
+	d.checkUnknownTool()
+	d.checkStream()
+	d.checkTermination()
+}
+
+func (d *doctor) initialize(version string) (*rpcReply, error) {
+	return d.call(d.ctx, "", "initialize", map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "mcp-server-doctor", "version": "1.0.0"},
+	})
+}
+
+// failure describes a reply that is not a JSON-RPC result, or returns "".
+func failure(reply *rpcReply, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case reply.Status != http.StatusOK:
+		return fmt.Sprintf("HTTP %d: %s", reply.Status, strings.TrimSpace(string(reply.Body)))
+	case reply.Error != nil:
+		return fmt.Sprintf("JSON-RPC error %d: %s", reply.Error.Code, reply.Error.Message)
+	case reply.Result == nil:
+		return "response is not a JSON-RPC result: " + strings.TrimSpace(string(reply.Body))
+	}
+	return ""
+}
+
+func (d *doctor) checkInitialize() bool {
+	reply, err := d.initialize(doctorProtocolVersion)
+	if msg := failure(reply, err); msg != "" {
+		d.report("initialize", "fail", "%s", msg)
+		return false
+	}
+	var result struct {
+		ProtocolVersion string          `json:"protocolVersion"`
+		Capabilities    json.RawMessage `json:"capabilities"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	json.Unmarshal(reply.Result, &result)
+	var missing []string
+	if result.ProtocolVersion == "" {
+		missing = append(missing, "protocolVersion")
+	}
+	if jsonKind(result.Capabilities) != '{' {
+		missing = append(missing, "capabilities")
+	}
+	if result.ServerInfo.Name == "" {
+		missing = append(missing, "serverInfo.name")
+	}
+	if len(missing) > 0 {
+		d.report("initialize", "fail", "result lacks %s", strings.Join(missing, ", "))
+		return false
+	}
+	d.session = reply.Session
+	d.report("initialize", "pass", "%s %s, protocol %s", result.ServerInfo.Name, result.ServerInfo.Version, result.ProtocolVersion)
+	if d.session == "" {
+		d.report("session", "warn", "no %s header; the server keeps no sessions", sessionHeader)
+	} else {
+		d.report("session", "pass", "session ID assigned")
+	}
+	return true
+}
+
+// checkVersionNegotiation asks for a version no server supports, which
+// must be answered with one the server does support.
+func (d *doctor) checkVersionNegotiation() {
+	const bogus = "1999-01-01"
+	reply, err := d.initialize(bogus)
+	if msg := failure(reply, err); msg != "" {
+		d.report("version negotiation", "fail", "unsupported version refused instead of countered: %s", msg)
+		return
+	}
+	d.endSession(reply.Session)
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(reply.Result, &result)
+	switch result.ProtocolVersion {
+	case "":
+		d.report("version negotiation", "fail", "no protocolVersion in the result")
+	case bogus:
+		d.report("version negotiation", "fail", "server accepted unknown version %s", bogus)
+	default:
+		d.report("version negotiation", "pass", "%s countered with %s", bogus, result.ProtocolVersion)
+	}
+}
+
+func (d *doctor) checkInitialized() {
+	reply, err := d.notify(d.ctx, d.session, "notifications/initialized")
+	switch {
+	case err != nil:
+		d.report("initialized notification", "fail", "%v", err)
+	case reply.Status == http.StatusAccepted:
+		d.report("initialized notification", "pass", "202 Accepted")
+	case reply.Status < 300 && len(bytes.TrimSpace(reply.Body)) == 0:
+		d.report("initialized notification", "warn", "HTTP %d instead of 202 Accepted", reply.Status)
+	default:
+		d.report("initialized notification", "fail", "HTTP %d: %s", reply.Status, strings.TrimSpace(string(reply.Body)))
+	}
+}
+
+func (d *doctor) checkPing() {
+	reply, err := d.call(d.ctx, d.session, "ping", nil)
+	if msg := failure(reply, err); msg != "" {
+		d.report("ping", "fail", "%s", msg)
+		return
+	}
+	d.report("ping", "pass", "")
+}
+
+// checkRequestID checks that a string ID comes back unchanged.
+func (d *doctor) checkRequestID() {
+	id := `"doctor-ß-1"`
+	reply, err := d.post(d.ctx, d.session, []byte(`{"jsonrpc": "2.0", "id": `+id+`, "method": "ping"}`))
+	if err != nil {
+		d.report("request id", "fail", "%v", err)
+		return
+	}
+	if string(reply.ID) != id {
+		d.report("request id", "fail", "sent id %s, got %s", id, reply.ID)
+		return
+	}
+	d.report("request id", "pass", "string ID echoed")
+}
+
+// checkErrorCode sends body and expects a JSON-RPC error with code.
+func (d *doctor) checkErrorCode(name string, body []byte, code int) {
+	reply, err := d.post(d.ctx, d.session, body)
+	switch {
+	case err != nil:
+		d.report(name, "fail", "%v", err)
+	case reply.Error == nil:
+		d.report(name, "fail", "expected error %d, got HTTP %d: %s", code, reply.Status, strings.TrimSpace(string(reply.Body)))
+	case reply.Error.Code != code:
+		d.report(name, "fail", "expected error %d, got %d (%s)", code, reply.Error.Code, reply.Error.Message)
+	default:
+		d.report(name, "pass", "%d %s", code, reply.Error.Message)
+	}
+}
+
+// checkToolsList checks the tool definitions and follows nextCursor
+// through every page.
+func (d *doctor) checkToolsList() {
+	seen := map[string]bool{}
+	var params interface{}
+	pages := 0
+	for {
+		reply, err := d.call(d.ctx, d.session, "tools/list", params)
+		if msg := failure(reply, err); msg != "" {
+			d.report("tools/list", "fail", "page %d: %s", pages+1, msg)
+			return
+		}
+		pages++
+		var result struct {
+			Tools []struct {
+				Name        string `json:"name"`
+				InputSchema struct {
+					Type string `json:"type"`
+				} `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(reply.Result, &result); err != nil || result.Tools == nil {
+			d.report("tools/list", "fail", "result has no tools array")
+			return
+		}
+		for _, t := range result.Tools {
+			switch {
+			case t.Name == "":
+				d.report("tools/list", "fail", "tool without a name")
+				return
+			case t.InputSchema.Type != "object":
+				d.report("tools/list", "fail", "tool %q: inputSchema type must be \"object\"", t.Name)
+				return
+			case seen[t.Name]:
+				d.report("tools/list", "fail", "tool %q listed twice", t.Name)
+				return
+			}
+			seen[t.Name] = true
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		if pages == 100 {
+			d.report("tools/list", "fail", "still paginating after %d pages", pages)
+			return
+		}
+		params = map[string]string{"cursor": result.NextCursor}
+	}
+	d.report("tools/list", "pass", "%d tools in %d page(s)", len(seen), pages)
+}
+
+func (d *doctor) checkInvalidCursor() {
+	reply, err := d.call(d.ctx, d.session, "tools/list", map[string]string{"cursor": "doctor-invalid-cursor"})
+	switch {
+	case err != nil:
+		d.report("invalid cursor", "fail", "%v", err)
+	case reply.Error != nil && reply.Error.Code == codeInvalidParams:
+		d.report("invalid cursor", "pass", "%d %s", reply.Error.Code, reply.Error.Message)
+	case reply.Error != nil:
+		d.report("invalid cursor", "warn", "error %d instead of %d", reply.Error.Code, codeInvalidParams)
+	default:
+		d.report("invalid cursor", "warn", "invalid cursor ignored; expected error %d", codeInvalidParams)
+	}
+}
+
+func (d *doctor) checkUnknownTool() {
+	reply, err := d.call(d.ctx, d.session, "tools/call", map[string]interface{}{"name": "doctor_no_such_tool"})
+	if err != nil {
+		d.report("unknown tool", "fail", "%v", err)
+		return
+	}
+	var result struct {
+		IsError bool `json:"isError"`
+	}
+	json.Unmarshal(reply.Result, &result)
+	switch {
+	case reply.Error != nil:
+		d.report("unknown tool", "pass", "error %d %s", reply.Error.Code, reply.Error.Message)
+	case result.IsError:
+		d.report("unknown tool", "pass", "result flagged isError")
+	default:
+		d.report("unknown tool", "warn", "answered with neither a JSON-RPC error nor isError: %s", compactJSON(reply.Result))
+	}
+}
+
+// checkStream opens the GET notification stream, which a server either
+// offers as an event stream or refuses with 405.
+func (d *doctor) checkStream() {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+	req, err := d.request(ctx, http.MethodGet, d.session, nil)
+	if err != nil {
+		d.report("notification stream", "fail", "%v", err)
+		return
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := d.http.Do(req)
+	if err != nil {
+		d.report("notification stream", "fail", "%v", err)
+		return
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream"):
+		d.report("notification stream", "pass", "event stream opened")
+	case resp.StatusCode == http.StatusMethodNotAllowed:
+		d.report("notification stream", "pass", "not offered (405)")
+	default:
+		d.report("notification stream", "fail", "HTTP %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+// checkTermination deletes the session, after which it must be unknown.
+func (d *doctor) checkTermination() {
+	if d.session == "" {
+		d.report("session termination", "skip", "no session")
+		return
+	}
+	status, err := d.endSession(d.session)
+	switch {
+	case err != nil:
+		d.report("session termination", "fail", "%v", err)
+		return
+	case status == http.StatusMethodNotAllowed:
+		d.report("session termination", "pass", "clients may not end sessions (405)")
+		return
+	case status >= 300:
+		d.report("session termination", "fail", "DELETE answered HTTP %d", status)
+		return
+	}
+	reply, err := d.call(d.ctx, d.session, "ping", nil)
+	switch {
+	case err != nil:
+		d.report("session termination", "fail", "%v", err)
+	case reply.Status == http.StatusNotFound:
+		d.report("session termination", "pass", "ended session answers 404")
+	default:
+		d.report("session termination", "fail", "ended session answered HTTP %d instead of 404", reply.Status)
+	}
+}
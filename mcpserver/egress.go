@@ -0,0 +1,309 @@
+package mcpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EgressConfig routes the outbound HTTP requests of tools, webhooks and
+// approval notifications through proxies and sets whom they trust. Proxy
+// URLs are http, https, socks5 or socks5h URLs with any credentials in
+// the user info, and may refer to ${secret:name}; "direct" means no proxy.
+type EgressConfig struct {
+	// Proxy is used for requests no rule matches. When empty, HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY of the server's environment apply.
+	Proxy string `json:"proxy,omitempty"`
+	// NoProxy lists hosts reached without the default proxy: names, which
+	// match their subdomains too, IPs, CIDRs, or "*".
+	NoProxy []string `json:"noProxy,omitempty"`
+	// Rules pick a proxy by tool and host; the first match wins.
+	Rules []EgressRule `json:"rules,omitempty"`
+	TLS   EgressTLS    `json:"tls"`
+}
+
+// EgressTLS adds trusted CAs for internal PKIs and pins the keys hosts
+// may present.
+type EgressTLS struct {
+	// CAFiles are PEM files of CAs trusted besides the system's.
+	CAFiles []string `json:"caFiles,omitempty"`
+	// SystemCAs false trusts only CAFiles.
+	SystemCAs *bool `json:"systemCAs,omitempty"`
+	// Pins restrict the certificate chains hosts are accepted with.
+	Pins []EgressPin `json:"pins,omitempty"`
+}
+
+// EgressPin accepts a connection to one of Hosts (written like NoProxy)
+// only if a certificate of its verified chain has one of the public keys
+// in SHA256: base64 SHA-256 hashes of the DER SubjectPublicKeyInfo, as
+// curl's --pinnedpubkey takes, with or without a "sha256/" prefix.
+type EgressPin struct {
+	Hosts  []string `json:"hosts" schema:"required"`
+	SHA256 []string `json:"sha256" schema:"required"`
+}
+
+func (t EgressTLS) enabled() bool {
+	return len(t.CAFiles) > 0 || t.SystemCAs != nil || len(t.Pins) > 0
+}
+
+// EgressRule sends the requests of matching tools to matching hosts
+// through Proxy. Tools are name patterns and Hosts are written like
+// NoProxy; an empty list matches everything.
+type EgressRule struct {
+	Tools []string `json:"tools,omitempty"`
+	Hosts []string `json:"hosts,omitempty"`
+	Proxy string   `json:"proxy" schema:"required"`
+}
+
+func (c EgressConfig) enabled() bool {
+	return c.Proxy != "" || len(c.NoProxy) > 0 || len(c.Rules) > 0 || c.TLS.enabled()
+}
+
+// egressRoute is an EgressRule with its proxy parsed; a nil proxy is a
+// direct connection.
+type egressRoute struct {
+	tools []string
+	hosts []string
+	proxy *url.URL
+}
+
+type egressRouter struct {
+	routes  []egressRoute
+	noProxy []string
+	proxy   *url.URL
+	fromEnv bool
+}
+
+// client returns base with its transport proxied and its TLS set up per
+// c, or nil when c configures nothing.
+func (c EgressConfig) client(base *http.Client, secrets map[string]string) (*http.Client, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	if err := checkHosts(c.NoProxy); err != nil {
+		return nil, fmt.Errorf("noProxy: %w", err)
+	}
+	router := &egressRouter{noProxy: c.NoProxy, fromEnv: c.Proxy == ""}
+	if c.Proxy != "" {
+		u, err := parseEgressProxy(c.Proxy, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		router.proxy = u
+	}
+	for i, rule := range c.Rules {
+		if err := checkHosts(rule.Hosts); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		u, err := parseEgressProxy(rule.Proxy, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		router.routes = append(router.routes, egressRoute{tools: rule.Tools, hosts: rule.Hosts, proxy: u})
+	}
+
+	// The proxy goes on the underlying transport, below trace propagation.
+	rt, tracing := base.Transport, false
+	if t, ok := rt.(*tracingTransport); ok {
+		rt, tracing = t.base, true
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	tr, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, errors.New("the HTTP client's transport does not support proxies")
+	}
+	tr = tr.Clone()
+	tr.Proxy = router.proxyFor
+	if c.TLS.enabled() {
+		cfg, err := c.TLS.clientConfig(tr.TLSClientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		tr.TLSClientConfig = cfg
+	}
+	client := *base
+	client.Transport = tr
+	if tracing {
+		client.Transport = &tracingTransport{base: tr}
+	}
+	return &client, nil
+}
+
+func checkHosts(hosts []string) error {
+	for _, h := range hosts {
+		if strings.Contains(h, "/") {
+			if _, _, err := net.ParseCIDR(h); err != nil {
+				return fmt.Errorf("host %q: %w", h, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clientConfig returns a copy of base (nil for the defaults) trusting
+// the configured CAs and checking the pins.
+func (t EgressTLS) clientConfig(base *tls.Config) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if len(t.CAFiles) > 0 || t.SystemCAs != nil {
+		pool := x509.NewCertPool()
+		if t.SystemCAs == nil || *t.SystemCAs {
+			system, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("system CAs: %w", err)
+			}
+			pool = system
+		}
+		for _, f := range t.CAFiles {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("%s: no PEM certificates", f)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+	if len(t.Pins) == 0 {
+		return cfg, nil
+	}
+
+	type pin struct {
+		hosts  []string
+		hashes [][]byte
+	}
+	pins := make([]pin, len(t.Pins))
+	for i, p := range t.Pins {
+		if len(p.Hosts) == 0 || len(p.SHA256) == 0 {
+			return nil, fmt.Errorf("pin %d: hosts and sha256 are required", i+1)
+		}
+		if err := checkHosts(p.Hosts); err != nil {
+			return nil, fmt.Errorf("pin %d: %w", i+1, err)
+		}
+		pins[i].hosts = p.Hosts
+		for _, h := range p.SHA256 {
+			sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimPrefix(h, "sha256/"), "/"))
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("pin %d: %q is not a base64 SHA-256 hash", i+1, h)
+			}
+			pins[i].hashes = append(pins[i].hashes, sum)
+		}
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, p := range pins {
+			if !matchHosts(p.hosts, cs.ServerName) {
+				continue
+			}
+			// Chains are verified before VerifyConnection runs; only
+			// their keys are compared here.
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					for _, want := range p.hashes {
+						if bytes.Equal(sum[:], want) {
+							return nil
+						}
+					}
+				}
+			}
+			return fmt.Errorf("certificate of %s matches no pinned key", cs.ServerName)
+		}
+		return nil
+	}
+	return cfg, nil
+}
+
+// parseEgressProxy expands and checks a proxy URL; "direct" is nil.
+func parseEgressProxy(raw string, secrets map[string]string) (*url.URL, error) {
+	if raw == "direct" {
+		return nil, nil
+	}
+	expanded, err := expandSecrets(raw, secrets)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(expanded)
+	if err != nil {
+		// The error would quote the URL, credentials included.
+		return nil, errors.New("invalid proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %s: scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %s: no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// proxyFor is the http.Transport Proxy function. The tool is read from
+// the request's ToolContext; requests made outside a tool call match
+// rules without tools.
+func (r *egressRouter) proxyFor(req *http.Request) (*url.URL, error) {
+	host := req.URL.Hostname()
+	tool := ""
+	if tc, ok := req.Context().Value(toolContextKey{}).(*ToolContext); ok {
+		tool = tc.Tool
+	}
+	for _, route := range r.routes {
+		if len(route.tools) > 0 && !matchAny(route.tools, tool) {
+			continue
+		}
+		if len(route.hosts) > 0 && !matchHosts(route.hosts, host) {
+			continue
+		}
+		return route.proxy, nil
+	}
+	if matchHosts(r.noProxy, host) {
+		return nil, nil
+	}
+	if r.fromEnv {
+		return http.ProxyFromEnvironment(req)
+	}
+	return r.proxy, nil
+}
+
+// matchHosts reports whether host is one of the patterns: "*", a name
+// that also covers its subdomains (a leading dot is allowed), an IP or a
+// CIDR.
+func matchHosts(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSuffix(p, "."))
+		switch {
+		case p == "*":
+			return true
+		case strings.Contains(p, "/"):
+			if _, n, err := net.ParseCIDR(p); err == nil && ip != nil && n.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if pip := net.ParseIP(p); pip != nil && pip.Equal(ip) {
+				return true
+			}
+		default:
+			p = strings.TrimPrefix(p, ".")
+			if host == p || strings.HasSuffix(host, "."+p) {
+				return true
+			}
+		}
+	}
+	return false
+}
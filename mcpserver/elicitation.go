@@ -0,0 +1,198 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ElicitationConfig sets how long a tool waits for the user to answer an
+// elicitation request when the tool does not say.
+type ElicitationConfig struct {
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"` // default 2m
+}
+
+const (
+	defaultElicitationTimeout = 2 * time.Minute
+	elicitationsMetric        = "mcp_elicitations_total"
+)
+
+// ErrElicitationUnavailable is returned by Elicit when the user cannot be
+// asked, because the client did not announce the elicitation capability or
+// has no notification stream open, and the request has no default.
+var ErrElicitationUnavailable = errors.New("elicitation unavailable: client cannot be asked for input")
+
+// ErrElicitationTimeout is returned by Elicit when the user did not answer
+// in time and the request has no default.
+var ErrElicitationTimeout = errors.New("elicitation timed out")
+
+// Elicitation asks the user for structured input through the client.
+// Schema is a flat object schema of primitive properties, as MCP requires.
+// Default, when set, is the answer used if the user cannot be asked or does
+// not answer in time; Timeout overrides elicitation.timeout.
+type Elicitation struct {
+	Message string
+	Schema  map[string]interface{}
+	Default map[string]interface{}
+	Timeout time.Duration
+}
+
+// ElicitResult is the user's answer. Action is "accept", "decline" or
+// "cancel"; Content holds the accepted input. Defaulted reports that the
+// Default was used because the user was not asked or did not answer.
+type ElicitResult struct {
+	Action    string                 `json:"action"`
+	Content   map[string]interface{} `json:"content,omitempty"`
+	Defaulted bool                   `json:"-"`
+}
+
+// Accepted reports whether the user accepted and supplied input.
+func (r ElicitResult) Accepted() bool {
+	return r.Action == "accept"
+}
+
+// clientResponse is a JSON-RPC response a client sent to a request of the
+// server.
+type clientResponse struct {
+	Result json.RawMessage
+	Error  *JSONRPCError
+}
+
+// elicitor tracks elicitation requests awaiting the client's response.
+type elicitor struct {
+	mu      sync.Mutex
+	pending map[string]chan clientResponse // by session ID and request ID
+}
+
+func newElicitor() *elicitor {
+	return &elicitor{pending: map[string]chan clientResponse{}}
+}
+
+func pendingKey(sessionID, requestID string) string {
+	return sessionID + "/" + requestID
+}
+
+// resolve hands a client's response to the request waiting for it and
+// reports whether one was.
+func (e *elicitor) resolve(sessionID, requestID string, resp clientResponse) bool {
+	e.mu.Lock()
+	ch, ok := e.pending[pendingKey(sessionID, requestID)]
+	delete(e.pending, pendingKey(sessionID, requestID))
+	e.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+	return ok
+}
+
+// Elicit asks the user of the calling session for input and waits for the
+// answer.
+func (tc *ToolContext) Elicit(ctx context.Context, req Elicitation) (ElicitResult, error) {
+	if tc.server == nil {
+		return elicitDefault(req, ErrElicitationUnavailable)
+	}
+	return tc.server.elicit(ctx, tc.Session, req)
+}
+
+func (s *MCPServer) elicit(ctx context.Context, sess *Session, req Elicitation) (ElicitResult, error) {
+	if sess == nil || !clientSupports(sess.Capabilities, "elicitation") {
+		s.metrics.inc(elicitationsMetric, "outcome", "unavailable")
+		return elicitDefault(req, ErrElicitationUnavailable)
+	}
+	schema := req.Schema
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(s.cfg.Elicitation.Timeout)
+	}
+	if timeout <= 0 {
+		timeout = defaultElicitationTimeout
+	}
+
+	id := "elicit-" + randomID()
+	ch := make(chan clientResponse, 1)
+	key := pendingKey(sess.ID, id)
+	s.elicitor.mu.Lock()
+	s.elicitor.pending[key] = ch
+	s.elicitor.mu.Unlock()
+	defer func() {
+		s.elicitor.mu.Lock()
+		delete(s.elicitor.pending, key)
+		s.elicitor.mu.Unlock()
+	}()
+
+	delivered := s.notifier.request(sess.ID, id, "elicitation/create", map[string]interface{}{
+		"message":         req.Message,
+		"requestedSchema": schema,
+	})
+	if !delivered {
+		s.metrics.inc(elicitationsMetric, "outcome", "unavailable")
+		return elicitDefault(req, ErrElicitationUnavailable)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		result, err := parseElicitResponse(resp, schema)
+		if err != nil {
+			s.metrics.inc(elicitationsMetric, "outcome", "invalid")
+			return ElicitResult{}, err
+		}
+		s.metrics.inc(elicitationsMetric, "outcome", result.Action)
+		return result, nil
+	case <-timer.C:
+		s.metrics.inc(elicitationsMetric, "outcome", "timeout")
+		return elicitDefault(req, ErrElicitationTimeout)
+	case <-ctx.Done():
+		return ElicitResult{}, ctx.Err()
+	}
+}
+
+// elicitDefault answers with req.Default, or fails with err without one.
+func elicitDefault(req Elicitation, err error) (ElicitResult, error) {
+	if req.Default == nil {
+		return ElicitResult{}, err
+	}
+	return ElicitResult{Action: "accept", Content: req.Default, Defaulted: true}, nil
+}
+
+// parseElicitResponse checks the client's answer; accepted content must
+// satisfy schema.
+func parseElicitResponse(resp clientResponse, schema map[string]interface{}) (ElicitResult, error) {
+	if resp.Error != nil {
+		return ElicitResult{}, fmt.Errorf("elicitation failed: %s", resp.Error.Message)
+	}
+	var result ElicitResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return ElicitResult{}, fmt.Errorf("invalid elicitation response: %w", err)
+	}
+	switch result.Action {
+	case "accept":
+		content, _ := json.Marshal(result.Content)
+		if issues := validateJSON(content, schema); hasErrors(issues) {
+			return ElicitResult{}, fmt.Errorf("invalid elicitation response: %s", issueSummary(issues))
+		}
+	case "decline", "cancel":
+		result.Content = nil
+	default:
+		return ElicitResult{}, fmt.Errorf("invalid elicitation response: unknown action %q", result.Action)
+	}
+	return result, nil
+}
+
+// clientSupports reports whether capabilities, as sent in initialize,
+// include name.
+func clientSupports(capabilities json.RawMessage, name string) bool {
+	var caps map[string]json.RawMessage
+	if json.Unmarshal(capabilities, &caps) != nil {
+		return false
+	}
+	_, ok := caps[name]
+	return ok
+}
@@ -0,0 +1,116 @@
+package mcpserver
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ExposureRule restricts which callers can see and call the tools it
+// matches. Tools and Clients accept path.Match patterns such as "admin_*".
+// Flag, when set, names a feature flag that must be on for the caller's
+// tenant. A tool matched by one or more rules is available only to callers
+// that satisfy at least one of them; unmatched tools are available to
+// everyone.
+type ExposureRule struct {
+	Tools            []string `json:"tools" schema:"required"`
+	Roles            []string `json:"roles,omitempty"`
+	Clients          []string `json:"clients,omitempty"`
+	MinClientVersion string   `json:"minClientVersion,omitempty"`
+	Flag             string   `json:"flag,omitempty"`
+}
+
+func (rule *ExposureRule) matchesTool(name string) bool {
+	return matchAny(rule.Tools, name)
+}
+
+func (rule *ExposureRule) allows(c *Caller, flags *flagStore) bool {
+	if rule.Flag != "" && !flags.enabled(rule.Flag, c.KeyName()) {
+		return false
+	}
+	if len(rule.Roles) > 0 && !contains(rule.Roles, c.Role()) {
+		return false
+	}
+	var clientName, clientVersion string
+	if c != nil && c.Session != nil {
+		clientName, clientVersion = c.Session.ClientName, c.Session.ClientVersion
+	}
+	if len(rule.Clients) > 0 && !matchAny(rule.Clients, clientName) {
+		return false
+	}
+	if rule.MinClientVersion != "" && compareVersions(clientVersion, rule.MinClientVersion) < 0 {
+		return false
+	}
+	return true
+}
+
+// toolVisible reports whether the caller may list and call the tool.
+func (s *MCPServer) toolVisible(name string, c *Caller) bool {
+	if s.cfg.Policy.ReadOnly && !readOnlyTool(s.tools[name]) {
+		return false
+	}
+	if c != nil && c.Key != nil && len(c.Key.Tools) > 0 && !matchAny(c.Key.Tools, name) {
+		return false
+	}
+	matched := false
+	for i := range s.cfg.Exposure {
+		rule := &s.cfg.Exposure[i]
+		if !rule.matchesTool(name) {
+			continue
+		}
+		if rule.allows(c, s.flags) {
+			return true
+		}
+		matched = true
+	}
+	return !matched
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares dotted numeric versions ("1.10.2" > "1.9").
+// Non-numeric suffixes are ignored; an empty version sorts lowest.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = leadingInt(as[i])
+		}
+		if i < len(bs) {
+			y = leadingInt(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
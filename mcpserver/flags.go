@@ -0,0 +1,253 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FeaturesConfig configures feature flags. Remote, when set, is polled for
+// flag definitions that take precedence over Flags.
+type FeaturesConfig struct {
+	Flags  map[string]FlagConfig `json:"flags,omitempty"`
+	Remote *RemoteFlagsConfig    `json:"remote,omitempty"`
+}
+
+// FlagConfig is a flag's default state plus per-tenant overrides, where a
+// tenant is an API key name.
+type FlagConfig struct {
+	Enabled bool            `json:"enabled"`
+	Tenants map[string]bool `json:"tenants,omitempty"`
+}
+
+// RemoteFlagsConfig points at an HTTP endpoint returning a JSON object of
+// flag name to FlagConfig.
+type RemoteFlagsConfig struct {
+	URL      string            `json:"url" schema:"required"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Interval Duration          `json:"interval,omitempty"`
+}
+
+const defaultFlagsInterval = time.Minute
+
+// flagStore resolves flags from three layers, highest first: overrides set
+// through the admin API, the remote provider, and the config file.
+type flagStore struct {
+	mu        sync.RWMutex
+	config    map[string]FlagConfig
+	remote    map[string]FlagConfig
+	overrides map[string]FlagConfig
+	remoteErr error
+	version   uint64 // bumped on every change, for caches of flag-dependent results
+}
+
+func newFlagStore(cfg FeaturesConfig) *flagStore {
+	return &flagStore{
+		config:    cfg.Flags,
+		remote:    map[string]FlagConfig{},
+		overrides: map[string]FlagConfig{},
+	}
+}
+
+func (f *flagStore) lookup(name string) (FlagConfig, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if fc, ok := f.overrides[name]; ok {
+		return fc, true
+	}
+	if fc, ok := f.remote[name]; ok {
+		return fc, true
+	}
+	fc, ok := f.config[name]
+	return fc, ok
+}
+
+// enabled reports whether the flag is on for tenant. Unknown flags are off.
+func (f *flagStore) enabled(name, tenant string) bool {
+	fc, ok := f.lookup(name)
+	if !ok {
+		return false
+	}
+	if on, ok := fc.Tenants[tenant]; ok && tenant != "" {
+		return on
+	}
+	return fc.Enabled
+}
+
+// setConfig replaces the config-file layer after a reload.
+func (f *flagStore) setConfig(flags map[string]FlagConfig) {
+	f.mu.Lock()
+	f.config = flags
+	f.version++
+	f.mu.Unlock()
+}
+
+func (f *flagStore) setOverride(name string, fc FlagConfig) {
+	f.mu.Lock()
+	f.overrides[name] = fc
+	f.version++
+	f.mu.Unlock()
+}
+
+func (f *flagStore) clearOverride(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.overrides[name]
+	delete(f.overrides, name)
+	f.version++
+	return ok
+}
+
+// currentVersion changes whenever any flag may have changed.
+func (f *flagStore) currentVersion() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.version
+}
+
+// overridden returns a copy of the admin API overrides.
+func (f *flagStore) overridden() map[string]FlagConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make(map[string]FlagConfig, len(f.overrides))
+	for name, fc := range f.overrides {
+		out[name] = fc
+	}
+	return out
+}
+
+type flagState struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	FlagConfig
+}
+
+// snapshot lists the effective state of every known flag.
+func (f *flagStore) snapshot() []flagState {
+	f.mu.RLock()
+	names := map[string]string{}
+	for n := range f.config {
+		names[n] = "config"
+	}
+	for n := range f.remote {
+		names[n] = "remote"
+	}
+	for n := range f.overrides {
+		names[n] = "admin"
+	}
+	f.mu.RUnlock()
+
+	out := make([]flagState, 0, len(names))
+	for n, src := range names {
+		fc, _ := f.lookup(n)
+		out = append(out, flagState{Name: n, Source: src, FlagConfig: fc})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// pollRemote refreshes remote flags until ctx is cancelled. A failed fetch
+// keeps the last good set.
+func (f *flagStore) pollRemote(ctx context.Context, rc *RemoteFlagsConfig) {
+	interval := time.Duration(rc.Interval)
+	if interval <= 0 {
+		interval = defaultFlagsInterval
+	}
+	for {
+		flags, err := fetchRemoteFlags(ctx, rc)
+		f.mu.Lock()
+		f.remoteErr = err
+		if err == nil && !reflect.DeepEqual(f.remote, flags) {
+			f.remote = flags
+			f.version++
+		}
+		f.mu.Unlock()
+		if err != nil {
+			log.Printf("feature flags: remote fetch failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func fetchRemoteFlags(ctx context.Context, rc *RemoteFlagsConfig) (map[string]FlagConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultBackendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rc.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range rc.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	flags := map[string]FlagConfig{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBackendOutput)).Decode(&flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// handleAdminFlags serves /admin/flags and /admin/flags/{name}.
+func (s *MCPServer) handleAdminFlags(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := map[string]interface{}{"flags": s.flags.snapshot()}
+		s.flags.mu.RLock()
+		if s.flags.remoteErr != nil {
+			resp["remoteError"] = s.flags.remoteErr.Error()
+		}
+		s.flags.mu.RUnlock()
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fc, ok := s.flags.lookup(name)
+		if !ok {
+			http.Error(w, "Flag not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, flagState{Name: name, FlagConfig: fc})
+	case http.MethodPut:
+		var fc FlagConfig
+		if err := s.decodeBody(r, &fc); err != nil {
+			http.Error(w, "Invalid flag: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.flags.setOverride(name, fc)
+		s.publish(EventFlagsChanged, map[string]interface{}{"flag": name, "enabled": fc.Enabled})
+		writeJSON(w, http.StatusOK, flagState{Name: name, Source: "admin", FlagConfig: fc})
+	case http.MethodDelete:
+		if !s.flags.clearOverride(name) {
+			http.Error(w, "No override for flag", http.StatusNotFound)
+			return
+		}
+		s.publish(EventFlagsChanged, map[string]interface{}{"flag": name, "cleared": true})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
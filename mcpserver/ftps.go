@@ -0,0 +1,355 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ftpsClient is an FTP control connection protected by TLS, explicitly
+// (AUTH TLS on port 21) or implicitly (port 990). Data connections are
+// passive and encrypted too, resuming the control connection's TLS
+// session as servers like vsftpd require. It is used by one goroutine,
+// but may be closed from another.
+type ftpsClient struct {
+	conn net.Conn
+	text *textproto.Conn
+	host string
+	tls  *tls.Config
+
+	mu       sync.Mutex
+	dataConn net.Conn // of the transfer in progress
+}
+
+// dialFTPS connects and logs in to e. tlsConfig carries the trusted CAs
+// and pins of egress.tls.
+func dialFTPS(ctx context.Context, e TransferEndpoint, secrets map[string]string, tlsConfig *tls.Config) (*ftpsClient, error) {
+	password, err := expandSecrets(e.Password, secrets)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(e.address())
+	if err != nil {
+		return nil, err
+	}
+	cfg := tlsConfig.Clone()
+	cfg.ServerName = host
+	cfg.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	c := &ftpsClient{host: host, tls: cfg}
+
+	d := net.Dialer{Timeout: e.timeout()}
+	conn, err := d.DialContext(ctx, "tcp", e.address())
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(e.timeout()))
+	if e.ImplicitTLS {
+		conn = tls.Client(conn, cfg)
+	}
+	c.conn, c.text = conn, textproto.NewConn(conn)
+	if err := c.login(e, password); err != nil {
+		c.close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *ftpsClient) login(e TransferEndpoint, password string) error {
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return err
+	}
+	if !e.ImplicitTLS {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return fmt.Errorf("server refused TLS: %w", err)
+		}
+		tc := tls.Client(c.conn, c.tls)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		c.conn, c.text = tc, textproto.NewConn(tc)
+	}
+	code, err := c.cmd(0, "USER %s", e.Username)
+	switch {
+	case err != nil:
+		return err
+	case code == 331:
+		if _, err := c.cmd(230, "PASS %s", password); err != nil {
+			return err
+		}
+	case code != 230:
+		return fmt.Errorf("ftps: unexpected reply %d to USER", code)
+	}
+	for _, cmd := range []string{"PBSZ 0", "PROT P", "TYPE I"} {
+		if _, err := c.cmd(200, "%s", cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmd sends a command and reads its reply, whose code must match expect
+// as textproto.Reader.ReadResponse checks it, or be below 400 for 0.
+func (c *ftpsClient) cmd(expect int, format string, args ...interface{}) (int, error) {
+	line := fmt.Sprintf(format, args...)
+	if strings.ContainsAny(line, "\r\n") {
+		return 0, errors.New("ftps: line breaks in a command")
+	}
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		return 0, err
+	}
+	if expect == 0 {
+		code, msg, err := c.text.ReadResponse(0)
+		if err == nil && code >= 400 {
+			err = &textproto.Error{Code: code, Msg: msg}
+		}
+		return code, err
+	}
+	code, _, err := c.text.ReadResponse(expect)
+	return code, err
+}
+
+func (c *ftpsClient) close() error {
+	c.mu.Lock()
+	if c.dataConn != nil {
+		c.dataConn.Close()
+	}
+	c.mu.Unlock()
+	c.text.PrintfLine("QUIT")
+	return c.conn.Close()
+}
+
+// data opens a passive data connection and sends command over the
+// control connection, returning once the server has accepted it.
+func (c *ftpsClient) data(command string) (*tls.Conn, error) {
+	addr, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, defaultTransferTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.dataConn = conn
+	c.mu.Unlock()
+	if _, err := c.cmd(1, "%s", command); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc := tls.Client(conn, c.tls)
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("data connection: %w", err)
+	}
+	return tc, nil
+}
+
+// passive asks for a data port with EPSV, falling back to PASV. The data
+// connection always goes to the control connection's host: the address in
+// a PASV reply is often a private one behind NAT.
+func (c *ftpsClient) passive() (string, error) {
+	if err := c.text.PrintfLine("EPSV"); err != nil {
+		return "", err
+	}
+	code, msg, err := c.text.ReadResponse(229)
+	if err == nil {
+		// Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return "", fmt.Errorf("ftps: malformed EPSV reply %q", msg)
+		}
+		return net.JoinHostPort(c.host, msg[start+4:end]), nil
+	}
+	if code < 500 {
+		return "", err
+	}
+	if err := c.text.PrintfLine("PASV"); err != nil {
+		return "", err
+	}
+	if _, msg, err = c.text.ReadResponse(227); err != nil {
+		return "", err
+	}
+	// Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("ftps: malformed PASV reply %q", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("ftps: malformed PASV reply %q", msg)
+	}
+	hi, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	lo, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("ftps: malformed PASV reply %q", msg)
+	}
+	return net.JoinHostPort(c.host, strconv.Itoa(hi<<8|lo)), nil
+}
+
+func (c *ftpsClient) stat(p string) (remoteEntry, error) {
+	e := remoteEntry{Name: p[strings.LastIndex(p, "/")+1:]}
+	if err := c.text.PrintfLine("SIZE %s", p); err != nil {
+		return e, err
+	}
+	_, msg, err := c.text.ReadResponse(213)
+	if err != nil {
+		var te *textproto.Error
+		if errors.As(err, &te) && te.Code == 550 {
+			return e, fmt.Errorf("ftps: %w", os.ErrNotExist)
+		}
+		return e, err
+	}
+	if e.Size, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err != nil {
+		return e, fmt.Errorf("ftps: malformed SIZE reply %q", msg)
+	}
+	return e, nil
+}
+
+// list reads a directory with MLSD, or LIST on servers without it.
+func (c *ftpsClient) list(dir string) ([]remoteEntry, error) {
+	entries, err := c.listLines("MLSD "+dir, parseMLSD)
+	var te *textproto.Error
+	if errors.As(err, &te) && (te.Code == 500 || te.Code == 502) {
+		return c.listLines("LIST "+dir, parseLIST)
+	}
+	return entries, err
+}
+
+func (c *ftpsClient) listLines(command string, parse func(string) (remoteEntry, bool)) ([]remoteEntry, error) {
+	conn, err := c.data(command)
+	if err != nil {
+		return nil, err
+	}
+	var entries []remoteEntry
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() && len(entries) < maxTransferEntries {
+		if e, ok := parse(strings.TrimRight(sc.Text(), "\r")); ok && e.Name != "." && e.Name != ".." {
+			entries = append(entries, e)
+		}
+	}
+	scanErr := sc.Err()
+	conn.Close()
+	// A listing cut short may be reported as aborted.
+	if _, _, err := c.text.ReadResponse(226); err != nil && len(entries) < maxTransferEntries {
+		return nil, err
+	}
+	return entries, scanErr
+}
+
+// parseMLSD reads a line like "type=file;size=42;modify=20240102150405; name".
+func parseMLSD(line string) (remoteEntry, bool) {
+	facts, name, ok := strings.Cut(line, " ")
+	if !ok {
+		return remoteEntry{}, false
+	}
+	e := remoteEntry{Name: name}
+	for _, fact := range strings.Split(facts, ";") {
+		k, v, _ := strings.Cut(fact, "=")
+		switch strings.ToLower(k) {
+		case "type":
+			switch strings.ToLower(v) {
+			case "cdir", "pdir":
+				return remoteEntry{}, false
+			case "dir":
+				e.Dir = true
+			}
+		case "size":
+			e.Size, _ = strconv.ParseInt(v, 10, 64)
+		case "modify":
+			if t, err := time.Parse("20060102150405", v[:min(len(v), 14)]); err == nil {
+				e.ModTime = t
+			}
+		case "unix.mode":
+			e.Mode = v
+		}
+	}
+	return e, true
+}
+
+// parseLIST reads a Unix "ls -l" line, the format of nearly every server
+// without MLSD. Its times are too vague to report.
+func parseLIST(line string) (remoteEntry, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || len(fields[0]) < 10 {
+		return remoteEntry{}, false
+	}
+	e := remoteEntry{Dir: fields[0][0] == 'd'}
+	e.Size, _ = strconv.ParseInt(fields[4], 10, 64)
+	// The name is the rest of the line after the eighth field.
+	rest := line
+	for i := 0; i < 8; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		rest = rest[strings.IndexByte(rest, ' ')+1:]
+	}
+	e.Name = strings.TrimLeft(rest, " ")
+	if fields[0][0] == 'l' {
+		e.Name, _, _ = strings.Cut(e.Name, " -> ")
+	}
+	return e, e.Name != ""
+}
+
+// open retrieves p.
+func (c *ftpsClient) open(p string) (io.ReadCloser, error) {
+	conn, err := c.data("RETR " + p)
+	if err != nil {
+		return nil, err
+	}
+	return &ftpsTransfer{c: c, conn: conn}, nil
+}
+
+// create stores p. FTP cannot create a file exclusively, so without
+// overwrite an existing file is looked for first.
+func (c *ftpsClient) create(p string, overwrite bool) (io.WriteCloser, error) {
+	if !overwrite {
+		if _, err := c.stat(p); err == nil {
+			return nil, fmt.Errorf("ftps: %w", os.ErrExist)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	conn, err := c.data("STOR " + p)
+	if err != nil {
+		return nil, err
+	}
+	return &ftpsTransfer{c: c, conn: conn, store: true}, nil
+}
+
+// ftpsTransfer is the data connection of a RETR or STOR. Closing it
+// waits for the server to confirm the transfer.
+type ftpsTransfer struct {
+	c     *ftpsClient
+	conn  *tls.Conn
+	store bool
+}
+
+func (t *ftpsTransfer) Read(p []byte) (int, error)  { return t.conn.Read(p) }
+func (t *ftpsTransfer) Write(p []byte) (int, error) { return t.conn.Write(p) }
+
+func (t *ftpsTransfer) Close() error {
+	if t.store {
+		// Closing a socket with unread data, such as TLS session tickets,
+		// resets it, and the server may lose the file's tail: end the
+		// stream and let the server close first.
+		t.conn.CloseWrite()
+		t.conn.SetReadDeadline(time.Now().Add(defaultTransferTimeout))
+		io.Copy(io.Discard, t.conn)
+	}
+	if err := t.conn.Close(); err != nil {
+		return err
+	}
+	_, _, err := t.c.text.ReadResponse(226)
+	return err
+}
@@ -0,0 +1,132 @@
+package mcpserver
+
+// Native fuzz tests. go test runs the seed inputs; go test -fuzz explores
+// from them, for example
+//
+//	go test ./mcpserver -run '^$' -fuzz FuzzRequest -fuzztime 1m
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var (
+	fuzzValidRequest = []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	fuzzBatch        = []byte(`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":"b","method":"tools/call","params":{"name":"nope","arguments":{}}}]`)
+)
+
+// FuzzRequest checks that parseRequest classifies any body without
+// panicking, in both modes, and that the reply to it is valid JSON.
+func FuzzRequest(f *testing.F) {
+	for _, seed := range []string{
+		string(fuzzValidRequest),
+		`{"jsonrpc":"2.0","id":"x","method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`,
+		`{"jsonrpc":"2.0","id":7,"result":{}}`,
+		`{"jsonrpc":"1.0","id":null}`,
+		`{"id":1,"method":"ping","extra":true}`,
+		`[]`,
+		`{`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, strict := range []bool{false, true} {
+			req, rpcErr := parseRequest(bytes.NewReader(data), strict)
+			switch {
+			case rpcErr != nil:
+				if rpcErr.Code != codeParseError && rpcErr.Code != codeInvalidRequest {
+					t.Fatalf("strict=%v: unexpected error code %d", strict, rpcErr.Code)
+				}
+			case req == nil:
+				t.Fatalf("strict=%v: no request and no error", strict)
+			case req.Method == "" && req.response == nil:
+				t.Fatalf("strict=%v: request without method accepted", strict)
+			}
+			var id interface{}
+			if req != nil {
+				id = req.ID
+			}
+			var buf bytes.Buffer
+			if err := writeResponse(&buf, id, nil, rpcErr); err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(buf.Bytes()) {
+				t.Fatalf("invalid response: %s", buf.String())
+			}
+		}
+	})
+}
+
+// FuzzBatch posts any body to /mcp, including batches and other arrays,
+// and checks that the server answers with valid JSON and no server error.
+func FuzzBatch(f *testing.F) {
+	for _, seed := range [][]byte{
+		fuzzValidRequest,
+		fuzzBatch,
+		[]byte(`[1,"two",null]`),
+		[]byte(`[[{"jsonrpc":"2.0","id":1,"method":"ping"}]]`),
+		[]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"fuzz","version":"0"}}}`),
+	} {
+		f.Add(seed)
+	}
+	server, err := newConfiguredServer(&Config{})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := httptest.NewRequest(http.MethodPost, "/mcp", bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept", "application/json, text/event-stream")
+		w := httptest.NewRecorder()
+		server.handleMCP(w, r)
+		if w.Code >= 500 {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		if w.Code == http.StatusOK && !json.Valid(w.Body.Bytes()) {
+			t.Fatalf("invalid response: %s", w.Body)
+		}
+	})
+}
+
+// FuzzSchema validates documents against the config schema and against a
+// schema taken from the input itself, as tools/validate does with tool
+// schemas: everything up to the first newline is the schema, the rest the
+// document.
+func FuzzSchema(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"object","properties":{"name":{"type":"string","minLength":1},"tags":{"type":"array","items":{"type":"string"}},"limits":{"type":"object","properties":{"max":{"type":"integer","minimum":0,"maximum":10}},"required":["max"]}},"required":["name"]}` + "\n" +
+			`{"name":"x","tags":["a",1],"limits":{"max":11}}`,
+		`{"type":"object","properties":{"mode":{"enum":["a","b"]},"items":{"type":"array","items":{"type":"object","properties":{"n":{"type":"number"}}}}}}` + "\n" +
+			`{"mode":"c","items":[{"n":"1"},{"n":2}]}`,
+		`{"server":{"maxBodyBytes":"1MiB"},"tools":[{"name":"t"}]}`,
+		`true` + "\n" + `{}`,
+	} {
+		f.Add([]byte(seed))
+	}
+	config := configSchema()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		validateJSON(data, config)
+		schemaPart, doc, found := bytes.Cut(data, []byte("\n"))
+		if !found {
+			return
+		}
+		var schema interface{}
+		if json.Unmarshal(schemaPart, &schema) != nil {
+			return
+		}
+		m := schemaMap(schema)
+		if m == nil {
+			return
+		}
+		for _, issue := range validateJSON(doc, m) {
+			if issue.Message == "" {
+				t.Fatalf("issue without message: %+v", issue)
+			}
+		}
+	})
+}
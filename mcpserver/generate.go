@@ -0,0 +1,235 @@
+package mcpserver
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var toolNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// toolScaffold is the data of the generate templates.
+type toolScaffold struct {
+	Package     string
+	Name        string // tool name, snake_case
+	Type        string // Go name, CamelCase
+	Description string
+}
+
+var registryTemplate = template.Must(template.New("registry").Parse(`// Package {{.Package}} holds the Go tools compiled into the server. Scaffold a
+// new one with
+//
+//	mcp-server generate tool <name>
+package {{.Package}}
+
+import "mcp-server/mcpserver"
+
+var registrations []func(*mcpserver.Builder)
+
+// register queues a tool for Register; generated tools call it from init.
+func register(fn func(*mcpserver.Builder)) {
+	registrations = append(registrations, fn)
+}
+
+// Register adds the package's tools to b.
+func Register(b *mcpserver.Builder) *mcpserver.Builder {
+	for _, fn := range registrations {
+		fn(b)
+	}
+	return b
+}
+`))
+
+var toolTemplate = template.Must(template.New("tool").Parse(`package {{.Package}}
+
+import (
+	"context"
+
+	"mcp-server/mcpserver"
+)
+
+// {{.Type}}Args are the arguments of the {{.Name}} tool. Their json,
+// jsonschema and validate tags define its input schema.
+type {{.Type}}Args struct {
+	Input string ` + "`" + `json:"input" jsonschema:"required,description=TODO: describe the input"` + "`" + `
+}
+
+// {{.Type}} implements the {{.Name}} tool.
+func {{.Type}}(ctx context.Context, args {{.Type}}Args) (string, error) {
+	// TODO: implement {{.Name}}. mcpserver.ToolContextFrom(ctx) has the
+	// logger, HTTP client and caller.
+	return args.Input, nil
+}
+
+func init() {
+	register(func(b *mcpserver.Builder) {
+		mcpserver.RegisterTypedTool(b, {{printf "%q" .Name}}, {{printf "%q" .Description}}, {{.Type}})
+	})
+}
+`))
+
+var toolTestTemplate = template.Must(template.New("test").Parse(`package {{.Package}}
+
+import (
+	"context"
+	"testing"
+)
+
+func Test{{.Type}}(t *testing.T) {
+	got, err := {{.Type}}(context.Background(), {{.Type}}Args{Input: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hello" {
+		t.Errorf("{{.Type}} = %q, want %q", got, "hello")
+	}
+}
+`))
+
+// runGenerateCommand implements the "generate" subcommand:
+//
+//	mcp-server generate tool <name> [-dir tools] [-description text]
+//	mcp-server generate grafana|alerts [-o file] [-selector matchers]
+func runGenerateCommand(args []string) int {
+	if len(args) > 0 && (args[0] == "grafana" || args[0] == "alerts") {
+		return runGenerateMonitoring(args[0], args[1:])
+	}
+	if len(args) == 0 || args[0] != "tool" {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server generate tool <name> [-dir tools] [-description text]")
+		fmt.Fprintln(os.Stderr, "       mcp-server generate grafana|alerts [-o file] [-selector matchers] [-error-rate 0.05] [-p99 5s]")
+		return 2
+	}
+	fs := flag.NewFlagSet("generate tool", flag.ContinueOnError)
+	dir := fs.String("dir", "tools", "package directory to write the tool to")
+	desc := fs.String("description", "", "tool description (default: a TODO)")
+	// Flags may come before or after the name.
+	var names []string
+	for rest := args[1:]; ; rest = fs.Args()[1:] {
+		if err := fs.Parse(rest); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		names = append(names, fs.Arg(0))
+	}
+	if len(names) != 1 {
+		fmt.Fprintln(os.Stderr, "generate tool: exactly one tool name required")
+		return 2
+	}
+	files, err := generateTool(*dir, names[0], *desc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate tool: %v\n", err)
+		return 1
+	}
+	for _, f := range files {
+		fmt.Println("created", f)
+	}
+	return 0
+}
+
+// generateTool writes the scaffold of tool name into dir, adding the
+// registry when dir has none yet, and returns the files it created.
+func generateTool(dir, name, desc string) ([]string, error) {
+	if !toolNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("tool name %q must be snake_case, like lookup_user", name)
+	}
+	pkg, hasRegistry, err := scaffoldPackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	data := toolScaffold{Package: pkg, Name: name, Type: camelCase(name), Description: desc}
+	if data.Description == "" {
+		data.Description = "TODO: describe " + name
+	}
+
+	outputs := []struct {
+		path string
+		tmpl *template.Template
+	}{
+		{filepath.Join(dir, name+".go"), toolTemplate},
+		{filepath.Join(dir, name+"_test.go"), toolTestTemplate},
+	}
+	if !hasRegistry {
+		outputs = append(outputs, struct {
+			path string
+			tmpl *template.Template
+		}{filepath.Join(dir, "tools.go"), registryTemplate})
+	}
+	for _, out := range outputs {
+		if _, err := os.Stat(out.path); err == nil {
+			return nil, fmt.Errorf("%s already exists", out.path)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	var created []string
+	for _, out := range outputs {
+		var buf bytes.Buffer
+		if err := out.tmpl.Execute(&buf, data); err != nil {
+			return created, err
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return created, fmt.Errorf("%s: %w", out.path, err)
+		}
+		if err := os.WriteFile(out.path, src, 0o644); err != nil {
+			return created, err
+		}
+		created = append(created, out.path)
+	}
+	return created, nil
+}
+
+// scaffoldPackage returns the package name of the Go files in dir, or one
+// derived from the directory name, and whether dir already has the
+// register function generated tools call.
+func scaffoldPackage(dir string) (pkg string, hasRegistry bool, err error) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(token.NewFileSet(), f, nil, 0)
+		if err != nil {
+			return "", false, err
+		}
+		pkg = parsed.Name.Name
+		for _, decl := range parsed.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "register" {
+				hasRegistry = true
+			}
+		}
+	}
+	if pkg == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return "", false, err
+		}
+		pkg = strings.ToLower(regexp.MustCompile(`[^A-Za-z0-9]`).ReplaceAllString(filepath.Base(abs), ""))
+		if pkg == "" || pkg[0] >= '0' && pkg[0] <= '9' {
+			return "", false, errors.New("cannot derive a package name from the directory; use -dir")
+		}
+	}
+	return pkg, hasRegistry, nil
+}
+
+// camelCase turns a snake_case tool name into an exported Go name.
+func camelCase(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// newGraphQLBackend wraps a GraphQL query or mutation as a tool. The query
+// is sent with variables taken from the tool arguments and the response
+// data is returned as JSON text.
+func newGraphQLBackend(b BackendConfig, env map[string]string) (ToolHandler, error) {
+	if b.URL == "" {
+		return nil, fmt.Errorf("graphql backend requires url")
+	}
+	if b.Query == "" {
+		return nil, fmt.Errorf("graphql backend requires query")
+	}
+	query, err := compileTemplate("query", b.Query)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := compileHeaders(b.Headers, env)
+	if err != nil {
+		return nil, err
+	}
+	timeout := backendTimeout(b)
+
+	return func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+		data, err := argsMap(args)
+		if err != nil {
+			return nil, err
+		}
+		q, err := renderTemplate(query, data)
+		if err != nil {
+			return nil, err
+		}
+		gqlReq := graphQLRequest{
+			Query:         q,
+			OperationName: b.OperationName,
+			Variables:     data,
+		}
+		if len(b.Variables) > 0 {
+			gqlReq.Variables = make(map[string]interface{}, len(b.Variables))
+			for name, arg := range b.Variables {
+				if v, ok := data[arg]; ok {
+					gqlReq.Variables[name] = v
+				}
+			}
+		}
+		payload, err := json.Marshal(gqlReq)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.URL, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		if err := applyHeaders(req, headers, data); err != nil {
+			return nil, err
+		}
+		if ToolContextFrom(ctx).DryRun {
+			return dryRunResult("send POST %s with headers %s and body %s", b.URL, headerNames(req.Header), payload)
+		}
+
+		resp, err := ToolContextFrom(ctx).HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		out, err := io.ReadAll(io.LimitReader(resp.Body, maxBackendOutput))
+		if err != nil {
+			return nil, err
+		}
+
+		var gqlResp graphQLResponse
+		if err := json.Unmarshal(out, &gqlResp); err != nil {
+			if resp.StatusCode >= 400 {
+				return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, out)
+			}
+			return nil, fmt.Errorf("invalid GraphQL response: %w", err)
+		}
+		if len(gqlResp.Errors) > 0 {
+			msgs := make([]string, len(gqlResp.Errors))
+			for i, e := range gqlResp.Errors {
+				msgs[i] = e.Message
+			}
+			return nil, fmt.Errorf("GraphQL error: %s", strings.Join(msgs, "; "))
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, out)
+		}
+		return textResult(string(gqlResp.Data)), nil
+	}, nil
+}
@@ -0,0 +1,84 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+)
+
+// HardeningConfig confines exec backends on Linux. Every subprocess is
+// started through a helper that restricts itself before executing the
+// tool: landlock allows reading and executing only below ReadPaths and
+// writing only below WritePaths and the session's scratch directory, and
+// a seccomp filter refuses syscalls tools have no business making
+// (ptrace, mount, module loading, bpf, namespace changes, ...). Exec
+// backends with a container sandbox are not affected.
+type HardeningConfig struct {
+	Enabled    bool     `json:"enabled,omitempty"`
+	ReadPaths  []string `json:"readPaths,omitempty"`
+	WritePaths []string `json:"writePaths,omitempty"`
+	Seccomp    *bool    `json:"seccomp,omitempty"` // default true
+}
+
+var (
+	defaultReadPaths  = []string{"/usr", "/lib", "/lib64", "/bin", "/sbin", "/etc"}
+	defaultWritePaths = []string{"/dev/null", "/tmp"}
+)
+
+// hardenArg is the hidden subcommand of the hardening helper:
+//
+//	mcp-server __harden <policy JSON> <command> [args...]
+const hardenArg = "__harden"
+
+// hardenPolicy is what the helper applies.
+type hardenPolicy struct {
+	Read    []string `json:"read"`
+	Write   []string `json:"write"`
+	Seccomp bool     `json:"seccomp"`
+}
+
+// policy adds the directories in read and write, typically a session's
+// scratch directory or a workspace root, to the configured paths. Empty
+// entries are skipped.
+func (c HardeningConfig) policy(read, write []string) hardenPolicy {
+	p := hardenPolicy{
+		Read:    c.ReadPaths,
+		Write:   c.WritePaths,
+		Seccomp: c.Seccomp == nil || *c.Seccomp,
+	}
+	if len(p.Read) == 0 {
+		p.Read = defaultReadPaths
+	}
+	if len(p.Write) == 0 {
+		p.Write = defaultWritePaths
+	}
+	p.Read = appendPaths(p.Read, read)
+	p.Write = appendPaths(p.Write, write)
+	return p
+}
+
+func appendPaths(base, extra []string) []string {
+	out := append([]string(nil), base...)
+	for _, dir := range extra {
+		if dir != "" {
+			out = append(out, dir)
+		}
+	}
+	return out
+}
+
+// command returns name with argv, run through the hardening helper with
+// read and write added to the policy.
+func (c HardeningConfig) command(ctx context.Context, read, write []string, name string, argv []string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := json.Marshal(c.policy(read, write))
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{hardenArg, string(policy), name}, argv...)
+	return exec.CommandContext(ctx, self, args...), nil
+}
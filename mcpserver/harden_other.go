@@ -0,0 +1,18 @@
+//go:build !linux
+
+package mcpserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func hardeningSupported(cfg HardeningConfig) error {
+	return errors.New("exec hardening requires Linux")
+}
+
+func runHardened(args []string) int {
+	fmt.Fprintln(os.Stderr, "exec hardening requires Linux")
+	return 1
+}
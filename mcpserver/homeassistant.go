@@ -0,0 +1,281 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HomeAssistantConfig opts in to the ha_ tools, which use a Home
+// Assistant instance's REST API with a long-lived access token. Tools
+// only see and act on entities matching Entities, patterns like
+// "light.*" or "sensor.kitchen_*"; ha_call_service may call only the
+// services matching Services, like "light.turn_on" or "cover.*".
+type HomeAssistantConfig struct {
+	URL      string   `json:"url,omitempty"`   // http://homeassistant.local:8123
+	Token    string   `json:"token,omitempty"` // may refer to ${secret:name}
+	Entities []string `json:"entities,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty" schema:"format=duration"` // default 10s
+}
+
+const (
+	defaultHATimeout  = 10 * time.Second
+	defaultHAEntities = 200
+	maxHAEntities     = 2000
+	// maxHAResponse allows for /api/states of large installations.
+	maxHAResponse = 16 << 20
+)
+
+var (
+	haEntityID = regexp.MustCompile(`^[a-z0-9_]+\.[a-z0-9_]+$`)
+	haName     = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// haTargetKeys are service data fields that select entities other than by
+// entity_id, which would get around the allowlist.
+var haTargetKeys = []string{"entity_id", "device_id", "area_id", "floor_id", "label_id"}
+
+func (c HomeAssistantConfig) enabled() bool {
+	return c.URL != ""
+}
+
+func (c HomeAssistantConfig) check(secrets map[string]string) error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return errors.New("url must be an http or https URL without credentials")
+	}
+	if c.Token == "" {
+		return errors.New("token is required")
+	}
+	if _, err := expandSecrets(c.Token, secrets); err != nil {
+		return err
+	}
+	if len(c.Entities) == 0 {
+		return errors.New("entities is required; list the entity patterns tools may use")
+	}
+	for _, p := range append(append([]string{}, c.Entities...), c.Services...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *MCPServer) setupHomeAssistantTools() {
+	openWorld, destructive := true, false
+	list, listHandler, _ := typedTool("ha_list_entities", "List Home Assistant entities with their state, such as lights, sensors and switches", s.haListEntitiesTool)
+	list.Annotations = readOnlyAnnotations()
+	list.Annotations.OpenWorldHint = &openWorld
+	s.addTool(list, listHandler)
+
+	get, getHandler, _ := typedTool("ha_get_state", "Get the state and attributes of a Home Assistant entity", s.haGetStateTool)
+	get.Annotations = readOnlyAnnotations()
+	get.Annotations.OpenWorldHint = &openWorld
+	s.addTool(get, getHandler)
+
+	if len(s.cfg.HomeAssistant.Services) > 0 {
+		call, callHandler, _ := typedTool("ha_call_service", "Call a Home Assistant service on entities, such as light.turn_on or climate.set_temperature", s.haCallServiceTool)
+		call.Annotations = &ToolAnnotations{DestructiveHint: &destructive, OpenWorldHint: &openWorld}
+		s.addTool(call, callHandler)
+	}
+}
+
+// haState is an entity as /api/states returns it.
+type haState struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged string                 `json:"last_changed"`
+	LastUpdated string                 `json:"last_updated"`
+}
+
+func (st haState) summary() map[string]interface{} {
+	out := map[string]interface{}{
+		"entity_id":    st.EntityID,
+		"state":        st.State,
+		"last_changed": st.LastChanged,
+	}
+	if name, ok := st.Attributes["friendly_name"].(string); ok {
+		out["name"] = name
+	}
+	if unit, ok := st.Attributes["unit_of_measurement"].(string); ok {
+		out["unit"] = unit
+	}
+	return out
+}
+
+// haRequest sends a request to the REST API and decodes the JSON reply
+// into out.
+func (s *MCPServer) haRequest(ctx context.Context, method, apiPath string, body interface{}, out interface{}) error {
+	c := s.cfg.HomeAssistant
+	token, err := expandSecrets(c.Token, s.secrets)
+	if err != nil {
+		return err
+	}
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, orDefault(c.Timeout, defaultHATimeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+apiPath, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ToolContextFrom(ctx).HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHAResponse))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errors.New("home assistant refused the token")
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("home assistant: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(data[:min(len(data), 512)]))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("home assistant: invalid response: %w", err)
+	}
+	return nil
+}
+
+// haEntity checks that id is a well-formed entity ID on the allowlist.
+func (s *MCPServer) haEntity(id string) error {
+	if !haEntityID.MatchString(id) {
+		return fmt.Errorf("invalid entity_id %q", id)
+	}
+	if !matchAny(s.cfg.HomeAssistant.Entities, id) {
+		return fmt.Errorf("entity %s is not in homeAssistant.entities", id)
+	}
+	return nil
+}
+
+type haListArgs struct {
+	Domain string `json:"domain,omitempty" jsonschema:"description=Only entities of this domain\\, like light or sensor"`
+	Search string `json:"search,omitempty" jsonschema:"description=Only entities whose ID or name contains this text\\, ignoring case"`
+	Limit  int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=2000,description=Most entities to return (default 200)"`
+}
+
+func (s *MCPServer) haListEntitiesTool(ctx context.Context, args haListArgs) (interface{}, error) {
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultHAEntities
+	}
+	limit = min(limit, maxHAEntities)
+	var states []haState
+	if err := s.haRequest(ctx, http.MethodGet, "/api/states", nil, &states); err != nil {
+		return nil, err
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].EntityID < states[j].EntityID })
+	search := strings.ToLower(args.Search)
+	entities := []map[string]interface{}{}
+	truncated := false
+	for _, st := range states {
+		if !matchAny(s.cfg.HomeAssistant.Entities, st.EntityID) {
+			continue
+		}
+		if args.Domain != "" && !strings.HasPrefix(st.EntityID, args.Domain+".") {
+			continue
+		}
+		name, _ := st.Attributes["friendly_name"].(string)
+		if search != "" && !strings.Contains(strings.ToLower(st.EntityID), search) && !strings.Contains(strings.ToLower(name), search) {
+			continue
+		}
+		if len(entities) == limit {
+			truncated = true
+			break
+		}
+		entities = append(entities, st.summary())
+	}
+	return map[string]interface{}{"entities": entities, "truncated": truncated}, nil
+}
+
+type haGetArgs struct {
+	EntityID string `json:"entity_id" jsonschema:"required,description=Entity to read\\, like light.kitchen"`
+}
+
+func (s *MCPServer) haGetStateTool(ctx context.Context, args haGetArgs) (interface{}, error) {
+	if err := s.haEntity(args.EntityID); err != nil {
+		return nil, err
+	}
+	var st haState
+	if err := s.haRequest(ctx, http.MethodGet, "/api/states/"+args.EntityID, nil, &st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+type haCallArgs struct {
+	Domain    string                 `json:"domain" jsonschema:"required,description=Service domain\\, like light"`
+	Service   string                 `json:"service" jsonschema:"required,description=Service name\\, like turn_on"`
+	EntityIDs []string               `json:"entity_ids" jsonschema:"required,minItems=1,description=Entities to call the service on"`
+	Data      map[string]interface{} `json:"data,omitempty" jsonschema:"description=Service fields\\, like {\"brightness_pct\": 40}"`
+}
+
+func (s *MCPServer) haCallServiceTool(ctx context.Context, args haCallArgs) (interface{}, error) {
+	if !haName.MatchString(args.Domain) || !haName.MatchString(args.Service) {
+		return nil, errors.New("domain and service are lowercase letters, digits and underscores")
+	}
+	service := args.Domain + "." + args.Service
+	if !matchAny(s.cfg.HomeAssistant.Services, service) {
+		return nil, fmt.Errorf("service %s is not in homeAssistant.services", service)
+	}
+	if len(args.EntityIDs) == 0 {
+		return nil, errors.New("give the entity_ids to call the service on")
+	}
+	for _, id := range args.EntityIDs {
+		if err := s.haEntity(id); err != nil {
+			return nil, err
+		}
+	}
+	data := map[string]interface{}{}
+	for k, v := range args.Data {
+		for _, key := range haTargetKeys {
+			if k == key {
+				return nil, fmt.Errorf("data must not contain %s; name entities in entity_ids", k)
+			}
+		}
+		data[k] = v
+	}
+	data["entity_id"] = args.EntityIDs
+
+	// The reply lists the states that changed during the call.
+	var changed []haState
+	if err := s.haRequest(ctx, http.MethodPost, "/api/services/"+args.Domain+"/"+args.Service, data, &changed); err != nil {
+		return nil, err
+	}
+	states := []map[string]interface{}{}
+	for _, st := range changed {
+		if matchAny(s.cfg.HomeAssistant.Entities, st.EntityID) {
+			states = append(states, st.summary())
+		}
+	}
+	return map[string]interface{}{"service": service, "entity_ids": args.EntityIDs, "changed": states}, nil
+}
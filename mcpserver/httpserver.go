@@ -0,0 +1,77 @@
+package mcpserver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Defaults for ServerConfig. The write timeout stays off by default: tool
+// calls may legitimately run for minutes.
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultMaxBodyBytes      = 4 << 20
+)
+
+// ServerConfig tunes the HTTP server. Timeouts bound how long a client may
+// take to send headers (ReadHeaderTimeout) and the whole request
+// (ReadTimeout), how long writing the response may take (WriteTimeout,
+// zero for none) and how long an idle keep-alive connection is kept.
+// HTTP/2 is negotiated over TLS unless HTTP2 is false; event streams are
+// exempt from the read and write timeouts.
+type ServerConfig struct {
+	ReadHeaderTimeout    Duration `json:"readHeaderTimeout,omitempty"`
+	ReadTimeout          Duration `json:"readTimeout,omitempty"`
+	WriteTimeout         Duration `json:"writeTimeout,omitempty"`
+	IdleTimeout          Duration `json:"idleTimeout,omitempty"`
+	MaxHeaderBytes       int      `json:"maxHeaderBytes,omitempty" schema:"minimum=1024"`
+	MaxBodyBytes         string   `json:"maxBodyBytes,omitempty" schema:"format=byteSize"` // default "4MiB"
+	HTTP2                *bool    `json:"http2,omitempty"`                                 // default true
+	MaxConcurrentStreams uint32   `json:"maxConcurrentStreams,omitempty" schema:"minimum=1"`
+}
+
+func orDefault(d Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// bodyLimit returns the largest accepted JSON-RPC request body. The value
+// was validated by configure.
+func (c ServerConfig) bodyLimit() int64 {
+	if n, err := parseByteSize(c.MaxBodyBytes); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxBodyBytes
+}
+
+// httpServer returns a server for handler with the configured limits.
+func (c ServerConfig) httpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: orDefault(c.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       orDefault(c.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      time.Duration(c.WriteTimeout),
+		IdleTimeout:       orDefault(c.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// configureHTTP2 sets up or disables HTTP/2 on a TLS server. Call it after
+// srv.TLSConfig is final.
+func (c ServerConfig) configureHTTP2(srv *http.Server) error {
+	if c.HTTP2 != nil && !*c.HTTP2 {
+		// A non-nil, empty map turns off the built-in HTTP/2 support.
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	return http2.ConfigureServer(srv, &http2.Server{
+		MaxConcurrentStreams: c.MaxConcurrentStreams,
+		IdleTimeout:          srv.IdleTimeout,
+	})
+}
@@ -0,0 +1,217 @@
+package mcpserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// I18nConfig configures translation of user-facing messages. Messages
+// adds or overrides catalog entries: locale -> English message ->
+// translation. Keys are the English strings the server emits, so tool
+// descriptions from declarative tools can be translated the same way.
+type I18nConfig struct {
+	DefaultLocale string                       `json:"defaultLocale,omitempty"`
+	Messages      map[string]map[string]string `json:"messages,omitempty"`
+}
+
+// builtinMessages translates the server's own error strings and tool
+// descriptions.
+var builtinMessages = map[string]map[string]string{
+	"es": {
+		"Parse error":                      "Error de análisis",
+		"Invalid Request":                  "Solicitud no válida",
+		"Invalid params":                   "Parámetros no válidos",
+		"Method not found":                 "Método no encontrado",
+		"Unknown tool":                     "Herramienta desconocida",
+		"Quota exceeded":                   "Cuota superada",
+		"Server busy":                      "Servidor ocupado",
+		"Request body too large":           "Cuerpo de la solicitud demasiado grande",
+		"Session not found":                "Sesión no encontrada",
+		"invalid or missing API key":       "clave de API no válida o ausente",
+		"name is required":                 "el nombre es obligatorio",
+		"arguments must be an object":      "los argumentos deben ser un objeto",
+		"batch requests are not supported": "las solicitudes por lotes no son compatibles",
+		"job queue is full":                "la cola de trabajos está llena",
+		"unknown job":                      "trabajo desconocido",
+		"job already finished":             "el trabajo ya ha terminado",
+		"job not finished":                 "el trabajo no ha terminado",
+		"job cancelled":                    "trabajo cancelado",
+		"Get system information":           "Obtener información del sistema",
+		"Echo back a message":              "Devolver un mensaje",
+	},
+	"fr": {
+		"Parse error":                      "Erreur d'analyse",
+		"Invalid Request":                  "Requête invalide",
+		"Invalid params":                   "Paramètres invalides",
+		"Method not found":                 "Méthode introuvable",
+		"Unknown tool":                     "Outil inconnu",
+		"Quota exceeded":                   "Quota dépassé",
+		"Server busy":                      "Serveur occupé",
+		"Request body too large":           "Corps de la requête trop volumineux",
+		"Session not found":                "Session introuvable",
+		"invalid or missing API key":       "clé d'API invalide ou manquante",
+		"name is required":                 "le nom est obligatoire",
+		"arguments must be an object":      "les arguments doivent être un objet",
+		"batch requests are not supported": "les requêtes groupées ne sont pas prises en charge",
+		"job queue is full":                "la file des tâches est pleine",
+		"unknown job":                      "tâche inconnue",
+		"job already finished":             "tâche déjà terminée",
+		"job not finished":                 "tâche non terminée",
+		"job cancelled":                    "tâche annulée",
+		"Get system information":           "Obtenir des informations système",
+		"Echo back a message":              "Renvoyer un message",
+	},
+	"de": {
+		"Parse error":                      "Analysefehler",
+		"Invalid Request":                  "Ungültige Anfrage",
+		"Invalid params":                   "Ungültige Parameter",
+		"Method not found":                 "Methode nicht gefunden",
+		"Unknown tool":                     "Unbekanntes Werkzeug",
+		"Quota exceeded":                   "Kontingent überschritten",
+		"Server busy":                      "Server ausgelastet",
+		"Request body too large":           "Anfragekörper zu groß",
+		"Session not found":                "Sitzung nicht gefunden",
+		"invalid or missing API key":       "ungültiger oder fehlender API-Schlüssel",
+		"name is required":                 "Name ist erforderlich",
+		"arguments must be an object":      "Argumente müssen ein Objekt sein",
+		"batch requests are not supported": "Batch-Anfragen werden nicht unterstützt",
+		"job queue is full":                "Auftragswarteschlange ist voll",
+		"unknown job":                      "unbekannter Auftrag",
+		"job already finished":             "Auftrag bereits beendet",
+		"job not finished":                 "Auftrag noch nicht beendet",
+		"job cancelled":                    "Auftrag abgebrochen",
+		"Get system information":           "Systeminformationen abrufen",
+		"Echo back a message":              "Eine Nachricht zurückgeben",
+	},
+}
+
+// localizer negotiates a locale per request and looks up translations.
+type localizer struct {
+	tags     []language.Tag
+	messages map[language.Tag]map[string]string
+	matcher  language.Matcher
+}
+
+func newLocalizer(cfg I18nConfig) (*localizer, error) {
+	fallback := language.English
+	if cfg.DefaultLocale != "" {
+		tag, err := language.Parse(cfg.DefaultLocale)
+		if err != nil {
+			return nil, fmt.Errorf("i18n.defaultLocale: %w", err)
+		}
+		fallback = tag
+	}
+	l := &localizer{
+		tags:     []language.Tag{fallback},
+		messages: make(map[language.Tag]map[string]string),
+	}
+	add := func(locale string, msgs map[string]string) error {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return fmt.Errorf("i18n.messages: %w", err)
+		}
+		if l.messages[tag] == nil {
+			l.messages[tag] = make(map[string]string)
+			if tag != fallback {
+				l.tags = append(l.tags, tag)
+			}
+		}
+		for k, v := range msgs {
+			l.messages[tag][k] = v
+		}
+		return nil
+	}
+	for _, set := range []map[string]map[string]string{builtinMessages, cfg.Messages} {
+		locales := make([]string, 0, len(set))
+		for locale := range set {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+		for _, locale := range locales {
+			if err := add(locale, set[locale]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	l.matcher = language.NewMatcher(l.tags)
+	return l, nil
+}
+
+func (s *MCPServer) setupI18n() error {
+	l, err := newLocalizer(s.cfg.I18n)
+	if err != nil {
+		return err
+	}
+	s.i18n = l
+	return nil
+}
+
+// negotiate picks the best supported locale for the given preferences,
+// each an Accept-Language value or a single tag, in priority order.
+func (l *localizer) negotiate(prefs ...string) language.Tag {
+	for _, pref := range prefs {
+		if pref == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		if _, index, conf := l.matcher.Match(tags...); conf != language.No {
+			return l.tags[index]
+		}
+	}
+	return l.tags[0]
+}
+
+// translate returns msg in locale. Messages of the form "prefix: detail"
+// that have no entry of their own are translated part by part, which
+// covers wrapped Go errors.
+func (l *localizer) translate(locale language.Tag, msg string) string {
+	if t, ok := l.messages[locale][msg]; ok {
+		return t
+	}
+	if prefix, rest, ok := strings.Cut(msg, ": "); ok {
+		return l.translate(locale, prefix) + ": " + l.translate(locale, rest)
+	}
+	return msg
+}
+
+// error returns a translated copy of e.
+func (l *localizer) error(locale language.Tag, e *JSONRPCError) *JSONRPCError {
+	if e == nil {
+		return nil
+	}
+	out := *e
+	out.Message = l.translate(locale, e.Message)
+	if data, ok := e.Data.(string); ok {
+		out.Data = l.translate(locale, data)
+	}
+	return &out
+}
+
+// result translates the error text of a tool result built by unknownTool
+// or errorResult. Successful output is left alone.
+func (l *localizer) result(locale language.Tag, result interface{}) interface{} {
+	m, ok := result.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	if msg, ok := m["error"].(string); ok {
+		m["error"] = l.translate(locale, msg)
+	}
+	if m["isError"] != true {
+		return result
+	}
+	if items, ok := m["content"].([]map[string]interface{}); ok {
+		for _, item := range items {
+			if text, ok := item["text"].(string); ok {
+				item["text"] = l.translate(locale, text)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"mcp-server/store"
+)
+
+// idempotencyTTL is how long the result of a call is kept for its
+// idempotency key.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotentCall is the stored outcome of a call made with an
+// idempotency key.
+type idempotentCall struct {
+	Tool      string          `json:"tool"`
+	Arguments string          `json:"arguments"` // hash
+	Result    json.RawMessage `json:"result"`
+}
+
+// idempotencyKeyFrom reads the idempotencyKey of a request's _meta.
+func idempotencyKeyFrom(meta json.RawMessage) string {
+	var m struct {
+		Key string `json:"idempotencyKey"`
+	}
+	json.Unmarshal(meta, &m)
+	return m.Key
+}
+
+// callIdempotent makes a tools/call at most once per idempotency key, so
+// a client retrying after a lost response does not repeat side effects.
+// A repeat with the same tool and arguments gets the first result back;
+// one with different arguments is refused. Error results are not kept, so
+// retrying a failed call runs it again. Keys are scoped to the API key.
+func (s *MCPServer) callIdempotent(ctx context.Context, key, tool string, args json.RawMessage, call func() interface{}) interface{} {
+	id := callerFrom(ctx).KeyName() + "/" + key
+	hash := argumentsHash(args)
+	rec, err := s.store.Get(ctx, nsIdempotency, id)
+	switch {
+	case err == nil:
+		var prev idempotentCall
+		if err := json.Unmarshal(rec.Value, &prev); err == nil {
+			if prev.Tool != tool || prev.Arguments != hash {
+				return errorResult(fmt.Errorf("idempotency key %q was already used for a different call", key))
+			}
+			return prev.Result
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		// Better to risk a repeat than to fail the call.
+		log.Printf("idempotency: looking up %s: %v", key, err)
+	}
+
+	result := call()
+	if isErrorResult(result) {
+		return result
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return result
+	}
+	owner := callerFrom(ctx).KeyName()
+	if err := putJSON(s.store, nsIdempotency, id, owner, idempotentCall{Tool: tool, Arguments: hash, Result: raw}, time.Now().Add(idempotencyTTL)); err != nil {
+		log.Printf("idempotency: storing %s: %v", key, err)
+	}
+	return result
+}
+
+// argumentsHash identifies arguments regardless of key order and spacing.
+func argumentsHash(args json.RawMessage) string {
+	var v interface{}
+	if len(args) > 0 {
+		json.Unmarshal(args, &v)
+	}
+	canonical, _ := json.Marshal(v)
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
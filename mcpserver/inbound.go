@@ -0,0 +1,129 @@
+package mcpserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const inboundDeniedMetric = "mcp_inbound_denied_total"
+
+// InboundConfig restricts the client addresses the server answers. Allow
+// and Deny (IPs or CIDRs) apply to every path; MCP, Admin (including the
+// dashboard) and Metrics add rules for those surfaces. A request must
+// pass both. Client addresses are taken after trusted proxies, and
+// requests over the local socket are not checked.
+type InboundConfig struct {
+	Allow   []string    `json:"allow,omitempty"`
+	Deny    []string    `json:"deny,omitempty"`
+	MCP     InboundRule `json:"mcp"`
+	Admin   InboundRule `json:"admin"`
+	Metrics InboundRule `json:"metrics"`
+}
+
+// InboundRule admits addresses in Allow, or any address when Allow is
+// empty, except those in Deny.
+type InboundRule struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+type netRule struct {
+	allow, deny []*net.IPNet
+}
+
+func (r netRule) admits(ip net.IP) bool {
+	for _, n := range r.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, n := range r.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inboundACL is InboundConfig parsed; rules holds the surface rules by
+// surface name.
+type inboundACL struct {
+	all   netRule
+	rules map[string]netRule
+}
+
+func (r InboundRule) parse() (netRule, error) {
+	allow, err := parseNets(r.Allow)
+	if err != nil {
+		return netRule{}, fmt.Errorf("allow %w", err)
+	}
+	deny, err := parseNets(r.Deny)
+	if err != nil {
+		return netRule{}, fmt.Errorf("deny %w", err)
+	}
+	return netRule{allow: allow, deny: deny}, nil
+}
+
+func (c InboundConfig) acl() (*inboundACL, error) {
+	all, err := InboundRule{Allow: c.Allow, Deny: c.Deny}.parse()
+	if err != nil {
+		return nil, err
+	}
+	acl := &inboundACL{all: all, rules: map[string]netRule{}}
+	for surface, rule := range map[string]InboundRule{"mcp": c.MCP, "admin": c.Admin, "metrics": c.Metrics} {
+		if acl.rules[surface], err = rule.parse(); err != nil {
+			return nil, fmt.Errorf("%s: %w", surface, err)
+		}
+	}
+	return acl, nil
+}
+
+// inboundSurface names the rule set of a path.
+func inboundSurface(path string) string {
+	switch {
+	case path == "/mcp":
+		return "mcp"
+	case strings.HasPrefix(path, "/admin/") || path == "/dashboard":
+		return "admin"
+	case path == "/metrics":
+		return "metrics"
+	}
+	return ""
+}
+
+// allows reports whether a client at addr may reach path. Addresses that
+// are not IPs come from the local socket.
+func (a *inboundACL) allows(addr, path string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return true
+	}
+	if !a.all.admits(ip) {
+		return false
+	}
+	rule, ok := a.rules[inboundSurface(path)]
+	return !ok || rule.admits(ip)
+}
+
+// restrictInbound wraps next so requests from addresses the current
+// generation's inbound rules refuse get 403 before anything else runs.
+func (l *liveServer) restrictInbound(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := l.server()
+		if s.inbound != nil && !s.inbound.allows(clientIP(r), r.URL.Path) {
+			surface := inboundSurface(r.URL.Path)
+			if surface == "" {
+				surface = "other"
+			}
+			s.metrics.inc(inboundDeniedMetric, "surface", surface)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package mcpserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestInboundACL(t *testing.T) {
+	t.Setenv("MCP_ADMIN_TOKEN", "")
+	_, h := testHandler(t, &Config{
+		Admin: AdminConfig{Token: "admin-token"},
+		Inbound: InboundConfig{
+			Deny:  []string{"203.0.113.0/24"},
+			Admin: InboundRule{Allow: []string{"127.0.0.1", "10.0.0.0/8"}, Deny: []string{"10.9.0.0/16"}},
+		},
+	})
+	for _, tc := range []struct {
+		name, peer, path string
+		want             int
+	}{
+		{"denied everywhere", "203.0.113.5:4000", "/health", http.StatusForbidden},
+		{"denied on mcp", "203.0.113.5:4000", "/mcp", http.StatusForbidden},
+		{"other address", "198.51.100.1:4000", "/health", http.StatusOK},
+		{"admin outside its allow list", "198.51.100.1:4000", "/admin/usage", http.StatusForbidden},
+		{"dashboard outside the allow list", "198.51.100.1:4000", "/dashboard", http.StatusForbidden},
+		{"admin from an allowed network", "10.1.2.3:4000", "/admin/usage", http.StatusOK},
+		{"admin denied within the allow list", "10.9.1.1:4000", "/admin/usage", http.StatusForbidden},
+		{"admin from loopback", "127.0.0.1:4000", "/admin/usage", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRequest("GET", tc.path, tc.peer, "")
+			r.Header.Set("Authorization", "Bearer admin-token")
+			if w := serve(h, r); w.Code != tc.want {
+				t.Errorf("status %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+
+	if _, err := newConfiguredServer(&Config{Inbound: InboundConfig{MCP: InboundRule{Allow: []string{"10.0.0.300"}}}}); err == nil {
+		t.Error("bad address accepted")
+	}
+}
@@ -0,0 +1,1136 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the Jinja subset render_template accepts:
+//
+//	{{ expr }}  {% if %}/{% elif %}/{% else %}/{% endif %}
+//	{% for x in xs %} or {% for k, v in obj %} with loop.index and friends,
+//	and {% else %} for empty loops; {% set x = expr %}; {% raw %}; {# #}
+//
+// Expressions have literals, attribute and index access, arithmetic, ~,
+// comparisons, in, and/or/not, "is [not] defined|none", "a if c else b",
+// range() and filters from the function library. A "-" next to a
+// delimiter trims the whitespace on that side, as in Jinja.
+
+// safeString is output that is not escaped again, from the safe and
+// escape filters.
+type safeString string
+
+type jinjaUndefinedType struct{}
+
+// jinjaUndefined is the value of missing names and attributes. It prints
+// as nothing and is false.
+var jinjaUndefined = &jinjaUndefinedType{}
+
+const (
+	maxJinjaSteps = 1 << 20
+	maxJinjaRange = 10000
+)
+
+type jinjaNode interface{}
+
+type (
+	jinjaText   struct{ text string }
+	jinjaOutput struct {
+		expr jinjaExpr
+		line int
+	}
+	jinjaIf struct {
+		conds  []jinjaExpr
+		bodies [][]jinjaNode
+		els    []jinjaNode
+	}
+	jinjaFor struct {
+		key, value string
+		iter       jinjaExpr
+		body, els  []jinjaNode
+		line       int
+	}
+	jinjaSet struct {
+		name string
+		expr jinjaExpr
+	}
+)
+
+// jinjaToken is a piece of template source: text, an {{ expression }} or
+// a {% statement %}.
+type jinjaToken struct {
+	kind byte // 't', '{' or '%'
+	text string
+	line int
+}
+
+func lexJinja(src string) ([]jinjaToken, error) {
+	var toks []jinjaToken
+	line := 1
+	trimNext := false
+	for len(src) > 0 {
+		start := len(src)
+		for i := 0; i+1 < len(src); i++ {
+			if src[i] == '{' && strings.IndexByte("{%#", src[i+1]) >= 0 {
+				start = i
+				break
+			}
+		}
+		text := src[:start]
+		if trimNext {
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		}
+		trimNext = false
+		rest := src[start:]
+		if strings.HasPrefix(rest, "{{-") || strings.HasPrefix(rest, "{%-") || strings.HasPrefix(rest, "{#-") {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		if text != "" {
+			toks = append(toks, jinjaToken{'t', text, line})
+		}
+		line += strings.Count(src[:start], "\n")
+		if start == len(src) {
+			break
+		}
+		closer := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[rest[1]]
+		end := strings.Index(rest[2:], closer)
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unclosed %s", line, rest[:2])
+		}
+		inner := rest[2 : 2+end]
+		inner = strings.TrimPrefix(inner, "-")
+		if strings.HasSuffix(inner, "-") {
+			inner, trimNext = inner[:len(inner)-1], true
+		}
+		src = rest[2+end+2:]
+		switch rest[1] {
+		case '#':
+		case '%':
+			if strings.TrimSpace(inner) == "raw" {
+				stop := strings.Index(src, "endraw")
+				open := strings.LastIndex(src[:max(stop, 0)], "{%")
+				closeAt := strings.Index(src[max(stop, 0):], "%}")
+				if stop < 0 || open < 0 || closeAt < 0 {
+					return nil, fmt.Errorf("line %d: raw without endraw", line)
+				}
+				toks = append(toks, jinjaToken{'t', src[:open], line})
+				line += strings.Count(rest[:2+end+2], "\n") + strings.Count(src[:stop+closeAt+2], "\n")
+				src = src[stop+closeAt+2:]
+				continue
+			}
+			toks = append(toks, jinjaToken{'%', strings.TrimSpace(inner), line})
+		default:
+			toks = append(toks, jinjaToken{'{', strings.TrimSpace(inner), line})
+		}
+		line += strings.Count(rest[:2+end+2], "\n")
+	}
+	return toks, nil
+}
+
+// jinjaParser turns tokens into a tree of nodes.
+type jinjaParser struct {
+	toks []jinjaToken
+	pos  int
+}
+
+func parseJinja(src string) ([]jinjaNode, error) {
+	toks, err := lexJinja(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &jinjaParser{toks: toks}
+	nodes, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		return nil, fmt.Errorf("line %d: unexpected {%% %s %%}", end.line, end.text)
+	}
+	return nodes, nil
+}
+
+// parseBody reads nodes up to a statement it does not start: the end of
+// the enclosing block, which it returns, or nil at the end of input.
+func (p *jinjaParser) parseBody() ([]jinjaNode, *jinjaToken, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.toks) {
+		tok := p.toks[p.pos]
+		p.pos++
+		switch tok.kind {
+		case 't':
+			nodes = append(nodes, &jinjaText{tok.text})
+		case '{':
+			expr, err := parseJinjaExpr(tok.text)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", tok.line, err)
+			}
+			nodes = append(nodes, &jinjaOutput{expr, tok.line})
+		case '%':
+			word, rest, _ := strings.Cut(tok.text, " ")
+			rest = strings.TrimSpace(rest)
+			var node jinjaNode
+			var err error
+			switch word {
+			case "if":
+				node, err = p.parseIf(rest)
+			case "for":
+				node, err = p.parseFor(rest, tok.line)
+			case "set":
+				name, value, ok := strings.Cut(rest, "=")
+				name = strings.TrimSpace(name)
+				if !ok || !isJinjaName(name) {
+					err = errors.New("set needs a name = value")
+					break
+				}
+				var expr jinjaExpr
+				if expr, err = parseJinjaExpr(value); err == nil {
+					node = &jinjaSet{name, expr}
+				}
+			default:
+				return nodes, &tok, nil
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", tok.line, err)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil, nil
+}
+
+func (p *jinjaParser) parseIf(cond string) (jinjaNode, error) {
+	node := &jinjaIf{}
+	for {
+		expr, err := parseJinjaExpr(cond)
+		if err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		node.conds, node.bodies = append(node.conds, expr), append(node.bodies, body)
+		if end == nil {
+			return nil, errors.New("if without endif")
+		}
+		word, rest, _ := strings.Cut(end.text, " ")
+		switch word {
+		case "elif":
+			cond = rest
+			continue
+		case "else":
+			if node.els, end, err = p.parseBody(); err != nil {
+				return nil, err
+			}
+			if end == nil || end.text != "endif" {
+				return nil, errors.New("if without endif")
+			}
+			return node, nil
+		case "endif":
+			return node, nil
+		}
+		return nil, fmt.Errorf("unexpected {%% %s %%} in if", end.text)
+	}
+}
+
+func (p *jinjaParser) parseFor(spec string, line int) (jinjaNode, error) {
+	targets, iter, ok := strings.Cut(spec, " in ")
+	if !ok {
+		return nil, errors.New("for needs a name in a sequence")
+	}
+	node := &jinjaFor{line: line}
+	names := strings.Split(targets, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		if !isJinjaName(names[i]) {
+			return nil, fmt.Errorf("bad loop variable %q", names[i])
+		}
+	}
+	switch len(names) {
+	case 1:
+		node.value = names[0]
+	case 2:
+		node.key, node.value = names[0], names[1]
+	default:
+		return nil, errors.New("for takes one or two loop variables")
+	}
+	var err error
+	if node.iter, err = parseJinjaExpr(iter); err != nil {
+		return nil, err
+	}
+	body, end, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	node.body = body
+	if end != nil && end.text == "else" {
+		node.els, end, err = p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if end == nil || end.text != "endfor" {
+		return nil, errors.New("for without endfor")
+	}
+	return node, nil
+}
+
+func isJinjaName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Expressions.
+
+type jinjaExpr interface{}
+
+type (
+	jinjaLiteral struct{ v interface{} }
+	jinjaName    struct{ name string }
+	jinjaList    struct{ items []jinjaExpr }
+	jinjaAttr    struct {
+		x    jinjaExpr
+		name string
+	}
+	jinjaIndex struct{ x, index jinjaExpr }
+	jinjaCall  struct {
+		name string
+		args []jinjaExpr
+	}
+	jinjaFilter struct {
+		x    jinjaExpr
+		name string
+		args []jinjaExpr
+	}
+	jinjaUnary struct {
+		op string
+		x  jinjaExpr
+	}
+	jinjaBinary struct {
+		op   string
+		x, y jinjaExpr
+	}
+	jinjaTest struct {
+		x      jinjaExpr
+		test   string
+		negate bool
+	}
+	jinjaCond struct{ cond, then, els jinjaExpr }
+)
+
+type exprParser struct {
+	toks []string
+	pos  int
+}
+
+func parseJinjaExpr(src string) (jinjaExpr, error) {
+	toks, err := tokenizeJinjaExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{toks: toks}
+	expr, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return expr, nil
+}
+
+func tokenizeJinjaExpr(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' && j+1 < len(src) && src[j+1] >= '0' && src[j+1] <= '9') {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "//":
+					op = two
+				}
+			}
+			if !strings.Contains("==!=<=>=//+-*/%~|.,()[]<>", op) {
+				return nil, fmt.Errorf("unexpected %q", op)
+			}
+			toks = append(toks, op)
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("expected %q at the end", t)
+		}
+		return fmt.Errorf("expected %q, found %q", t, got)
+	}
+	return nil
+}
+
+func (p *exprParser) conditional() (jinjaExpr, error) {
+	x, err := p.or()
+	if err != nil || p.peek() != "if" {
+		return x, err
+	}
+	p.next()
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	var els jinjaExpr = jinjaLiteral{jinjaUndefined}
+	if p.peek() == "else" {
+		p.next()
+		if els, err = p.conditional(); err != nil {
+			return nil, err
+		}
+	}
+	return jinjaCond{cond, x, els}, nil
+}
+
+func (p *exprParser) or() (jinjaExpr, error) {
+	return p.binary(p.and, "or")
+}
+
+func (p *exprParser) and() (jinjaExpr, error) {
+	return p.binary(p.not, "and")
+}
+
+func (p *exprParser) not() (jinjaExpr, error) {
+	if p.peek() == "not" {
+		p.next()
+		x, err := p.not()
+		return jinjaUnary{"not", x}, err
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (jinjaExpr, error) {
+	x, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		switch op {
+		case "==", "!=", "<", ">", "<=", ">=", "in":
+			p.next()
+		case "not":
+			if p.pos+1 >= len(p.toks) || p.toks[p.pos+1] != "in" {
+				return x, nil
+			}
+			p.pos += 2
+			op = "not in"
+		case "is":
+			p.next()
+			negate := p.peek() == "not"
+			if negate {
+				p.next()
+			}
+			test := p.next()
+			if test != "defined" && test != "undefined" && test != "none" {
+				return nil, fmt.Errorf("unknown test %q", test)
+			}
+			x = jinjaTest{x, test, negate}
+			continue
+		default:
+			return x, nil
+		}
+		y, err := p.concat()
+		if err != nil {
+			return nil, err
+		}
+		x = jinjaBinary{op, x, y}
+	}
+}
+
+func (p *exprParser) concat() (jinjaExpr, error) {
+	return p.binary(p.additive, "~")
+}
+
+func (p *exprParser) additive() (jinjaExpr, error) {
+	return p.binary(p.multiplicative, "+", "-")
+}
+
+func (p *exprParser) multiplicative() (jinjaExpr, error) {
+	return p.binary(p.unary, "*", "/", "//", "%")
+}
+
+func (p *exprParser) binary(operand func() (jinjaExpr, error), ops ...string) (jinjaExpr, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		found := false
+		for _, o := range ops {
+			found = found || op == o
+		}
+		if !found {
+			return x, nil
+		}
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = jinjaBinary{op, x, y}
+	}
+}
+
+func (p *exprParser) unary() (jinjaExpr, error) {
+	if p.peek() == "-" {
+		p.next()
+		x, err := p.unary()
+		return jinjaUnary{"-", x}, err
+	}
+	return p.filtered()
+}
+
+func (p *exprParser) filtered() (jinjaExpr, error) {
+	x, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "|" {
+		p.next()
+		name := p.next()
+		if !isJinjaName(name) {
+			return nil, fmt.Errorf("bad filter %q", name)
+		}
+		var args []jinjaExpr
+		if p.peek() == "(" {
+			if args, err = p.args(); err != nil {
+				return nil, err
+			}
+		}
+		x = jinjaFilter{x, name, args}
+	}
+	return x, nil
+}
+
+func (p *exprParser) args() ([]jinjaExpr, error) {
+	p.next() // (
+	var args []jinjaExpr
+	for p.peek() != ")" {
+		arg, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(")")
+}
+
+func (p *exprParser) postfix() (jinjaExpr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.next()
+			name := p.next()
+			if !isJinjaName(name) && strings.Trim(name, "0123456789") != "" {
+				return nil, fmt.Errorf("bad attribute %q", name)
+			}
+			x = jinjaAttr{x, name}
+		case "[":
+			p.next()
+			index, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = jinjaIndex{x, index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (jinjaExpr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, errors.New("unexpected end of expression")
+	case t == "(":
+		x, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case t == "[":
+		var items []jinjaExpr
+		for p.peek() != "]" {
+			item, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		return jinjaList{items}, p.expect("]")
+	case t[0] == '"' || t[0] == '\'':
+		return jinjaLiteral{unquoteJinja(t)}, nil
+	case t[0] >= '0' && t[0] <= '9':
+		f, err := strconv.ParseFloat(t, 64)
+		return jinjaLiteral{f}, err
+	case t == "true" || t == "True":
+		return jinjaLiteral{true}, nil
+	case t == "false" || t == "False":
+		return jinjaLiteral{false}, nil
+	case t == "none" || t == "None" || t == "null":
+		return jinjaLiteral{nil}, nil
+	case isJinjaName(t):
+		if p.peek() == "(" {
+			args, err := p.args()
+			return jinjaCall{t, args}, err
+		}
+		return jinjaName{t}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+func unquoteJinja(t string) string {
+	body := t[1 : len(t)-1]
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' || i+1 == len(body) {
+			b.WriteByte(body[i])
+			continue
+		}
+		i++
+		switch body[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(body[i])
+		}
+	}
+	return b.String()
+}
+
+// Evaluation.
+
+type jinjaEnv struct {
+	ctx        context.Context
+	funcs      map[string]templateFunc
+	escapeHTML bool
+	out        *templateOutput
+	scopes     []map[string]interface{}
+	steps      int
+}
+
+func renderJinja(ctx context.Context, src string, data map[string]interface{}, funcs map[string]templateFunc, escapeHTML bool, out *templateOutput) error {
+	nodes, err := parseJinja(src)
+	if err != nil {
+		return err
+	}
+	env := &jinjaEnv{ctx: ctx, funcs: funcs, escapeHTML: escapeHTML, out: out, scopes: []map[string]interface{}{data}}
+	return env.exec(nodes)
+}
+
+// step counts work, so templates that loop without output still stop.
+func (e *jinjaEnv) step() error {
+	e.steps++
+	if e.steps > maxJinjaSteps {
+		return errors.New("template takes too many steps")
+	}
+	if e.steps%1024 == 0 {
+		return e.ctx.Err()
+	}
+	return nil
+}
+
+func (e *jinjaEnv) lookup(name string) interface{} {
+	for i := len(e.scopes) - 1; i >= 0; i-- {
+		if v, ok := e.scopes[i][name]; ok {
+			return v
+		}
+	}
+	return jinjaUndefined
+}
+
+func (e *jinjaEnv) exec(nodes []jinjaNode) error {
+	for _, n := range nodes {
+		if err := e.step(); err != nil {
+			return err
+		}
+		switch n := n.(type) {
+		case *jinjaText:
+			if _, err := e.out.WriteString(n.text); err != nil {
+				return err
+			}
+		case *jinjaOutput:
+			v, err := e.eval(n.expr)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n.line, err)
+			}
+			s := templateString(v)
+			if _, safe := v.(safeString); e.escapeHTML && !safe {
+				s = html.EscapeString(s)
+			}
+			if _, err := e.out.WriteString(s); err != nil {
+				return err
+			}
+		case *jinjaSet:
+			v, err := e.eval(n.expr)
+			if err != nil {
+				return err
+			}
+			e.scopes[len(e.scopes)-1][n.name] = v
+		case *jinjaIf:
+			body := n.els
+			for i, cond := range n.conds {
+				v, err := e.eval(cond)
+				if err != nil {
+					return err
+				}
+				if jinjaTruth(v) {
+					body = n.bodies[i]
+					break
+				}
+			}
+			if err := e.exec(body); err != nil {
+				return err
+			}
+		case *jinjaFor:
+			if err := e.execFor(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *jinjaEnv) execFor(n *jinjaFor) error {
+	seq, err := e.eval(n.iter)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", n.line, err)
+	}
+	var keys, values []interface{}
+	if m, ok := seq.(map[string]interface{}); ok {
+		keys = templateKeys(m)
+		for _, k := range keys {
+			values = append(values, m[k.(string)])
+		}
+		if n.key == "" {
+			values = keys
+		}
+	} else {
+		if values, err = templateList(seq); err != nil {
+			return fmt.Errorf("line %d: %w", n.line, err)
+		}
+		if n.key != "" {
+			return fmt.Errorf("line %d: two loop variables need an object", n.line)
+		}
+	}
+	if len(values) == 0 {
+		return e.exec(n.els)
+	}
+	scope := map[string]interface{}{}
+	e.scopes = append(e.scopes, scope)
+	defer func() { e.scopes = e.scopes[:len(e.scopes)-1] }()
+	for i, v := range values {
+		if err := e.step(); err != nil {
+			return err
+		}
+		scope[n.value] = v
+		if n.key != "" {
+			scope[n.key] = keys[i]
+		}
+		scope["loop"] = map[string]interface{}{
+			"index": float64(i + 1), "index0": float64(i), "revindex": float64(len(values) - i),
+			"first": i == 0, "last": i == len(values)-1, "length": float64(len(values)),
+		}
+		if err := e.exec(n.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *jinjaEnv) eval(x jinjaExpr) (interface{}, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case jinjaLiteral:
+		return x.v, nil
+	case jinjaName:
+		return e.lookup(x.name), nil
+	case jinjaList:
+		out := make([]interface{}, len(x.items))
+		for i, item := range x.items {
+			v, err := e.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case jinjaAttr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGet(v, x.name), nil
+	case jinjaIndex:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		index, err := e.eval(x.index)
+		if err != nil {
+			return nil, err
+		}
+		return jinjaGet(v, index), nil
+	case jinjaCall:
+		return e.call(x)
+	case jinjaFilter:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.name == "safe" {
+			return safeString(templateString(v)), nil
+		}
+		if _, safe := v.(safeString); safe && x.name == "escape" {
+			return v, nil
+		}
+		fn, ok := e.funcs[x.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q", x.name)
+		}
+		args, err := e.evalAll(x.args)
+		if err != nil {
+			return nil, err
+		}
+		out, err := fn(v, args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", x.name, err)
+		}
+		if x.name == "escape" {
+			// Already escaped, as Jinja's Markup.
+			return safeString(templateString(out)), nil
+		}
+		return out, nil
+	case jinjaUnary:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "not" {
+			return !jinjaTruth(v), nil
+		}
+		return -templateNumber(v), nil
+	case jinjaTest:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		var result bool
+		switch x.test {
+		case "defined":
+			result = v != jinjaUndefined
+		case "undefined":
+			result = v == jinjaUndefined
+		case "none":
+			result = v == nil
+		}
+		return result != x.negate, nil
+	case jinjaCond:
+		cond, err := e.eval(x.cond)
+		if err != nil {
+			return nil, err
+		}
+		if jinjaTruth(cond) {
+			return e.eval(x.then)
+		}
+		return e.eval(x.els)
+	case jinjaBinary:
+		return e.binary(x)
+	}
+	return nil, fmt.Errorf("cannot evaluate %T", x)
+}
+
+func (e *jinjaEnv) evalAll(xs []jinjaExpr) ([]interface{}, error) {
+	out := make([]interface{}, len(xs))
+	for i, x := range xs {
+		v, err := e.eval(x)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// call runs range() or a library function called directly, as in
+// upper(name).
+func (e *jinjaEnv) call(x jinjaCall) (interface{}, error) {
+	args, err := e.evalAll(x.args)
+	if err != nil {
+		return nil, err
+	}
+	if x.name == "range" {
+		if len(args) == 0 || len(args) > 3 {
+			return nil, errors.New("range takes one to three numbers")
+		}
+		start, stop, step := 0.0, templateNumber(args[0]), 1.0
+		if len(args) > 1 {
+			start, stop = stop, templateNumber(args[1])
+		}
+		if len(args) > 2 {
+			step = templateNumber(args[2])
+		}
+		if step == 0 {
+			return nil, errors.New("range step is 0")
+		}
+		if n := math.Ceil((stop - start) / step); n > maxJinjaRange {
+			return nil, fmt.Errorf("range is longer than %d", maxJinjaRange)
+		}
+		var out []interface{}
+		for v := start; step > 0 && v < stop || step < 0 && v > stop; v += step {
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	fn, ok := e.funcs[x.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", x.name)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s needs a value", x.name)
+	}
+	out, err := fn(args[0], args[1:]...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", x.name, err)
+	}
+	return out, nil
+}
+
+func (e *jinjaEnv) binary(x jinjaBinary) (interface{}, error) {
+	a, err := e.eval(x.x)
+	if err != nil {
+		return nil, err
+	}
+	// and/or return an operand and skip the second when they can.
+	switch x.op {
+	case "and":
+		if !jinjaTruth(a) {
+			return a, nil
+		}
+		return e.eval(x.y)
+	case "or":
+		if jinjaTruth(a) {
+			return a, nil
+		}
+		return e.eval(x.y)
+	}
+	b, err := e.eval(x.y)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "~":
+		return templateString(a) + templateString(b), nil
+	case "+":
+		if sa, ok := a.(string); ok {
+			return sa + templateString(b), nil
+		}
+		if la, ok := a.([]interface{}); ok {
+			lb, err := templateList(b)
+			return append(append([]interface{}(nil), la...), lb...), err
+		}
+		return templateNumber(a) + templateNumber(b), nil
+	case "-":
+		return templateNumber(a) - templateNumber(b), nil
+	case "*":
+		if sa, ok := a.(string); ok {
+			n := int(templateNumber(b))
+			if n < 0 || len(sa)*n > e.out.max {
+				return nil, fmt.Errorf("%w of %d bytes", errTemplateOutput, e.out.max)
+			}
+			return strings.Repeat(sa, n), nil
+		}
+		return templateNumber(a) * templateNumber(b), nil
+	case "/", "//", "%":
+		d := templateNumber(b)
+		if d == 0 {
+			return nil, errors.New("division by zero")
+		}
+		switch x.op {
+		case "/":
+			return templateNumber(a) / d, nil
+		case "//":
+			return math.Floor(templateNumber(a) / d), nil
+		}
+		return math.Mod(templateNumber(a), d), nil
+	case "==":
+		return jinjaEqual(a, b), nil
+	case "!=":
+		return !jinjaEqual(a, b), nil
+	case "<":
+		return templateCompare(a, b) < 0, nil
+	case ">":
+		return templateCompare(a, b) > 0, nil
+	case "<=":
+		return templateCompare(a, b) <= 0, nil
+	case ">=":
+		return templateCompare(a, b) >= 0, nil
+	case "in", "not in":
+		found, err := jinjaContains(b, a)
+		return found == (x.op == "in"), err
+	}
+	return nil, fmt.Errorf("unknown operator %q", x.op)
+}
+
+func jinjaGet(v, key interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if item, ok := v[templateString(key)]; ok {
+			return item
+		}
+	case []interface{}:
+		i := int(templateNumber(key))
+		if _, isNum := key.(float64); !isNum {
+			if n, err := strconv.Atoi(templateString(key)); err == nil {
+				i = n
+			} else {
+				return jinjaUndefined
+			}
+		}
+		if i < 0 {
+			i += len(v)
+		}
+		if i >= 0 && i < len(v) {
+			return v[i]
+		}
+	}
+	return jinjaUndefined
+}
+
+func jinjaContains(container, item interface{}) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		return strings.Contains(c, templateString(item)), nil
+	case map[string]interface{}:
+		_, ok := c[templateString(item)]
+		return ok, nil
+	}
+	list, err := templateList(container)
+	for _, v := range list {
+		if jinjaEqual(v, item) {
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+func jinjaEqual(a, b interface{}) bool {
+	if sa, ok := a.(safeString); ok {
+		a = string(sa)
+	}
+	if sb, ok := b.(safeString); ok {
+		b = string(sb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jinjaTruth(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case safeString:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return v != jinjaUndefined
+}
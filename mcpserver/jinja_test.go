@@ -0,0 +1,124 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRenderJinja(t *testing.T) {
+	const data = `{"name": "Ada", "user": {"name": "Ada", "age": 36}, "items": ["a", "b", "c"],
+		"obj": {"b": 2, "a": 1}, "n": 1, "flag": false, "nothing": null, "html": "<b>&</b>"}`
+	for _, tc := range []struct {
+		name, src string
+		escape    bool
+		want      string
+	}{
+		{"text", "plain text", false, "plain text"},
+		{"name", "Hello {{ name }}!", false, "Hello Ada!"},
+		{"attribute and index", "{{ user.name }} {{ user['age'] }} {{ items[1] }} {{ items[-1] }}", false, "Ada 36 b c"},
+		{"undefined prints nothing", "[{{ missing }}|{{ user.missing.deeper }}|{{ items[9] }}]", false, "[||]"},
+		{"arithmetic", "{{ 1 + 2 * 3 }} {{ (1 + 2) * 3 }} {{ 7 // 2 }} {{ 7 % 3 }} {{ 7 / 2 }} {{ -n }}", false, "7 9 3 1 3.5 -1"},
+		{"concat", "{{ 'n=' ~ n ~ '!' }}", false, "n=1!"},
+		{"conditional expression", "{{ 'x' if flag else 'y' }}{{ 'z' if n }}", false, "yz"},
+		{"if elif else", "{% if n > 1 and not flag %}a{% elif n == 1 %}b{% else %}c{% endif %}", false, "b"},
+		{"in", "{% if 'b' in items %}1{% endif %}{% if 'x' not in items %}2{% endif %}{% if 'd' in 'abcd' %}3{% endif %}{% if 'a' in obj %}4{% endif %}", false, "1234"},
+		{"tests", "{% if missing is defined %}d{% else %}u{% endif %}{% if nothing is none %}n{% endif %}{% if name is not none %}s{% endif %}", false, "uns"},
+		{"or returns an operand", "{{ missing or 'fallback' }} {{ name and 'yes' }}", false, "fallback yes"},
+		{"comparisons", "{% if n < 2 and n >= 1 and n != 2 and 'a' < 'b' %}ok{% endif %}", false, "ok"},
+
+		{"for", "{% for i in items %}{{ loop.index }}:{{ i }}{% if not loop.last %},{% endif %}{% endfor %}", false, "1:a,2:b,3:c"},
+		{"loop variables", "{% for i in items %}{{ loop.index0 }}{{ loop.revindex }}{{ loop.length }}{% if loop.first %}F{% endif %} {% endfor %}", false, "033F 123 213 "},
+		{"for over an object", "{% for k, v in obj %}{{ k }}={{ v }};{% endfor %}{% for k in obj %}{{ k }}{% endfor %}", false, "a=1;b=2;ab"},
+		{"for else", "{% for x in [] %}x{% else %}empty{% endfor %}", false, "empty"},
+		{"range", "{% for i in range(3) %}{{ i }}{% endfor %} {% for i in range(10, 0, -3) %}{{ i }},{% endfor %}", false, "012 10,7,4,1,"},
+		{"nested loops", "{% for a in [1, 2] %}{% for b in ['x', 'y'] %}{{ a }}{{ b }}{{ loop.index }} {% endfor %}{% endfor %}", false, "1x1 1y2 2x1 2y2 "},
+		{"loop scope", "{% for i in items %}{% set last = i %}{% endfor %}[{{ last }}][{{ i }}]", false, "[][]"},
+		{"set", "{% set greeting = 'hi ' ~ name %}{{ greeting }}", false, "hi Ada"},
+		{"list literal", "{{ [1, 'two', name] | join('/') }}", false, "1/two/Ada"},
+
+		{"whitespace control", "a  {%- if true -%}  b  {%- endif -%}  c\n{{- ' d' }}", false, "abc d"},
+		{"raw", "{% raw %}{{ not rendered }}{% if %}{% endraw %}!", false, "{{ not rendered }}{% if %}!"},
+		{"comment", "a{# {{ ignored }} #}b", false, "ab"},
+
+		{"upper", "{{ name | upper }} {{ upper(name) }}", false, "ADA ADA"},
+		{"chained filters", "{{ '  x ' | trim | length }} {{ items | reverse | first }}", false, "1 c"},
+		{"filter arguments", "{{ items | join(', ') }} {{ 'a-b' | replace('-', '+') }}", false, "a, b, c a+b"},
+		{"default", "{{ missing | default('n/a') }} {{ name | default('n/a') }}", false, "n/a Ada"},
+		{"tojson", "{{ obj | tojson }}", false, `{"a":1,"b":2}`},
+		{"title and round", "{{ 'hello world' | title }} {{ 3.14159 | round(2) }}", false, "Hello World 3.14"},
+		{"filter binds tighter than arithmetic", "{{ 1 + items | length }}", false, "4"},
+
+		{"no escaping by default", "{{ html }}", false, "<b>&</b>"},
+		{"html escaping", "<p>{{ html }}</p>", true, "<p>&lt;b&gt;&amp;&lt;/b&gt;</p>"},
+		{"safe", "{{ html | safe }}", true, "<b>&</b>"},
+		{"escape filter escapes once", "{{ html | escape }}", true, "&lt;b&gt;&amp;&lt;/b&gt;"},
+		{"escape of safe output", "{{ html | safe | escape }}", true, "<b>&</b>"},
+		{"template text is not escaped", "<i>{{ name }}</i>", true, "<i>Ada</i>"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderJinjaTest(tc.src, data, tc.escape)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("%s\n got %q\nwant %q", tc.src, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderJinjaErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"{% macro m() %}x{% endmacro %}":       "line 1: unexpected {% macro m() %}",
+		"{% include 'header.html' %}":          "unexpected {% include 'header.html' %}",
+		"{% extends 'base.html' %}":            "unexpected {% extends 'base.html' %}",
+		"{% block body %}x{% endblock %}":      "unexpected {% block body %}",
+		"{% with x = 1 %}{{ x }}{% endwith %}": "unexpected {% with x = 1 %}",
+		"{% if flag %}x":                       "if without endif",
+		"{% if flag %}x{% endfor %}":           "unexpected {% endfor %} in if",
+		"{% for x in items %}x":                "for without endfor",
+		"{% for x items %}{% endfor %}":        "for needs a name in a sequence",
+		"{% for a, b, c in obj %}{% endfor %}": "for takes one or two loop variables",
+		"{% for k, v in items %}{% endfor %}":  "two loop variables need an object",
+		"{% endfor %}":                         "unexpected {% endfor %}",
+		"{% set = 1 %}":                        "set needs a name = value",
+		"{{ name":                              "line 1: unclosed {{",
+		"{% raw %}never closed":                "raw without endraw",
+		"a\nb\n{{ name | nosuch }}":            "line 3: unknown filter \"nosuch\"",
+		"{{ nosuch(name) }}":                   "unknown function \"nosuch\"",
+		"{{ name.upper() }}":                   "",
+		"{{ 1 + }}":                            "",
+		"{{ range(100000) | length }}":         "range is longer than 10000",
+		"{{ range(1, 5, 0) }}":                 "range step is 0",
+		"{% for i in range(9999) %}{% for j in range(9999) %}{% endfor %}{% endfor %}": "too many steps",
+	} {
+		_, err := renderJinjaTest(src, `{"name": "Ada", "items": [1], "obj": {}, "flag": true}`, false)
+		if err == nil {
+			t.Errorf("%q rendered", src)
+		} else if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: %v, want %s", src, err, want)
+		}
+	}
+}
+
+func TestRenderJinjaOutputLimit(t *testing.T) {
+	out := &templateOutput{ctx: context.Background(), max: 100}
+	err := renderJinja(context.Background(), "{% for i in range(1000) %}{{ i }}{% endfor %}", map[string]interface{}{}, templateLibrary, false, out)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the size limit") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func renderJinjaTest(src, data string, escape bool) (string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		return "", err
+	}
+	out := &templateOutput{ctx: context.Background(), max: 1 << 20}
+	if err := renderJinja(context.Background(), src, values, templateLibrary, escape, out); err != nil {
+		return "", err
+	}
+	return out.buf.String(), nil
+}
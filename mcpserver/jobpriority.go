@@ -0,0 +1,147 @@
+package mcpserver
+
+import (
+	"context"
+	"time"
+)
+
+// JobPriorityConfig assigns queued jobs to a class. Jobs an agent submits
+// with an async tools/call are interactive and tasks from schedule_task are
+// batch, unless Batch or Interactive match the API key or tool; a job
+// matched by Batch is batch whatever else matches. Interactive jobs run
+// first, but a batch job that has waited StarvationAfter (default 1m) runs
+// ahead of them, so batch work is delayed rather than starved.
+type JobPriorityConfig struct {
+	Batch           JobClassMatch `json:"batch"`
+	Interactive     JobClassMatch `json:"interactive"`
+	StarvationAfter Duration      `json:"starvationAfter,omitempty" schema:"format=duration"`
+}
+
+// JobClassMatch selects jobs by API key name and tool name patterns.
+type JobClassMatch struct {
+	Keys  []string `json:"keys,omitempty"`
+	Tools []string `json:"tools,omitempty"`
+}
+
+func (m JobClassMatch) matches(keyName, tool string) bool {
+	return matchAny(m.Keys, keyName) || matchAny(m.Tools, tool)
+}
+
+const (
+	jobInteractive = "interactive"
+	jobBatch       = "batch"
+)
+
+// jobClasses lists the classes in the order workers prefer them.
+var jobClasses = []string{jobInteractive, jobBatch}
+
+const defaultJobStarvationAfter = time.Minute
+
+const (
+	jobQueueDepthMetric = "mcp_job_queue_depth"
+	jobWaitMetric       = "mcp_job_wait_seconds"
+	jobPromotedMetric   = "mcp_job_starvation_promotions_total"
+)
+
+// jobWaitBuckets suit time spent queued, from an idle pool to a backlog.
+var jobWaitBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 300, 900}
+
+// class returns the class of a job for keyName calling tool; def is the
+// class of its origin.
+func (c JobPriorityConfig) class(keyName, tool, def string) string {
+	switch {
+	case c.Batch.matches(keyName, tool):
+		return jobBatch
+	case c.Interactive.matches(keyName, tool):
+		return jobInteractive
+	}
+	return def
+}
+
+func (c JobPriorityConfig) starvationAfter() time.Duration {
+	return orDefault(c.StarvationAfter, defaultJobStarvationAfter)
+}
+
+// jobClass returns the class of a job, for jobs stored before classes
+// existed too.
+func jobClass(job *Job) string {
+	switch {
+	case job.Priority != "":
+		return job.Priority
+	case job.RunAt != nil:
+		return jobBatch
+	}
+	return jobInteractive
+}
+
+// queuedJob is a job waiting for a worker.
+type queuedJob struct {
+	id     string
+	queued time.Time
+}
+
+// depth returns how many jobs are waiting. Callers hold q.mu.
+func (q *jobQueue) depth() int {
+	n := 0
+	for _, jobs := range q.pending {
+		n += len(jobs)
+	}
+	return n
+}
+
+// enqueue adds job to the queue of its class and wakes a worker. It fails
+// when the queue is full, unless force is set. Callers hold q.mu.
+func (q *jobQueue) enqueue(job *Job, force bool) bool {
+	if !force && q.depth() >= q.cfg.QueueSize {
+		return false
+	}
+	class := jobClass(job)
+	q.pending[class] = append(q.pending[class], queuedJob{id: job.ID, queued: time.Now()})
+	q.s.metrics.set(jobQueueDepthMetric, float64(len(q.pending[class])), "class", class)
+	q.ready.Signal()
+	return true
+}
+
+// dequeue removes a cancelled job from its queue. Callers hold q.mu.
+func (q *jobQueue) dequeue(job *Job) {
+	class := jobClass(job)
+	jobs := q.pending[class]
+	for i, qj := range jobs {
+		if qj.id == job.ID {
+			q.pending[class] = append(jobs[:i:i], jobs[i+1:]...)
+			q.s.metrics.set(jobQueueDepthMetric, float64(len(q.pending[class])), "class", class)
+			return
+		}
+	}
+}
+
+// take waits for a job and returns the one to run next: the oldest
+// interactive job, unless the oldest batch job has waited too long. It
+// returns false once ctx is cancelled.
+func (q *jobQueue) take(ctx context.Context) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.depth() == 0 && ctx.Err() == nil {
+		q.ready.Wait()
+	}
+	if ctx.Err() != nil {
+		return "", false
+	}
+	class := jobInteractive
+	for _, c := range jobClasses {
+		if len(q.pending[c]) > 0 {
+			class = c
+			break
+		}
+	}
+	if batch := q.pending[jobBatch]; class != jobBatch && len(batch) > 0 &&
+		time.Since(batch[0].queued) >= q.cfg.Priority.starvationAfter() {
+		class = jobBatch
+		q.s.metrics.inc(jobPromotedMetric)
+	}
+	next := q.pending[class][0]
+	q.pending[class] = q.pending[class][1:]
+	q.s.metrics.set(jobQueueDepthMetric, float64(len(q.pending[class])), "class", class)
+	q.s.metrics.observe(jobWaitMetric, time.Since(next.queued).Seconds(), "class", class)
+	return next.id, true
+}
@@ -0,0 +1,655 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// JobsConfig configures asynchronous tool execution. With a state store,
+// jobs are persisted and unfinished jobs are resumed after a restart.
+// Finished jobs are deleted once older than Retention. Dir is the data
+// directory of older configs; job files found there are moved into the
+// store.
+type JobsConfig struct {
+	Workers   int      `json:"workers,omitempty"`
+	QueueSize int      `json:"queueSize,omitempty"`
+	Dir       string   `json:"dir,omitempty"`
+	Retention Duration `json:"retention,omitempty"`
+	// MaxScheduled bounds the tasks an API key may have waiting to run
+	// (default 100).
+	MaxScheduled int `json:"maxScheduled,omitempty" schema:"minimum=1"`
+	// MaxDelay is how far ahead schedule_task may schedule (default 30 days).
+	MaxDelay Duration `json:"maxDelay,omitempty" schema:"format=duration"`
+	// Priority decides which queued jobs workers take first.
+	Priority JobPriorityConfig `json:"priority"`
+}
+
+const (
+	defaultJobWorkers      = 4
+	defaultJobQueueSize    = 100
+	defaultJobRetention    = 24 * time.Hour
+	defaultJobMaxScheduled = 100
+	defaultJobMaxDelay     = 30 * 24 * time.Hour
+	jobSweepInterval       = time.Minute
+)
+
+const (
+	jobScheduled = "scheduled"
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+var (
+	errQueueFull   = errors.New("job queue is full")
+	errJobNotFound = errors.New("unknown job")
+	errJobFinished = errors.New("job already finished")
+	errTooManyJobs = errors.New("too many scheduled tasks")
+)
+
+// Job is an asynchronous tool call. A scheduled job waits until RunAt
+// before it is queued; Timezone is the zone it was scheduled in.
+type Job struct {
+	ID        string          `json:"id"`
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Status    string          `json:"status"`
+	Priority  string          `json:"priority,omitempty"` // interactive or batch
+	Result    json.RawMessage `json:"result,omitempty"`
+	KeyName   string          `json:"keyName,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Trace     string          `json:"traceparent,omitempty"`
+	RunAt     *time.Time      `json:"runAt,omitempty"`
+	Timezone  string          `json:"timezone,omitempty"`
+	Created   time.Time       `json:"createdAt"`
+	Started   *time.Time      `json:"startedAt,omitempty"`
+	Finished  *time.Time      `json:"finishedAt,omitempty"`
+}
+
+func (j *Job) done() bool {
+	return j.Status != jobScheduled && j.Status != jobQueued && j.Status != jobRunning
+}
+
+type jobQueue struct {
+	s       *MCPServer
+	cfg     JobsConfig
+	db      store.Store // nil keeps jobs in memory only
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	cancels map[string]context.CancelFunc
+	timers  map[string]*time.Timer
+	pending map[string][]queuedJob // queued jobs by class, oldest first
+	ready   *sync.Cond             // signalled when a job is queued
+}
+
+func newJobQueue(s *MCPServer, cfg JobsConfig) *jobQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultJobWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultJobQueueSize
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = Duration(defaultJobRetention)
+	}
+	if cfg.MaxScheduled <= 0 {
+		cfg.MaxScheduled = defaultJobMaxScheduled
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = Duration(defaultJobMaxDelay)
+	}
+	q := &jobQueue{
+		s:       s,
+		cfg:     cfg,
+		jobs:    map[string]*Job{},
+		cancels: map[string]context.CancelFunc{},
+		timers:  map[string]*time.Timer{},
+		pending: map[string][]queuedJob{},
+	}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// start restores the jobs kept in db, if any, and launches the workers.
+// Jobs that were queued when the server stopped are run; running ones are
+// run again only when their tool is read-only, since a tool with side
+// effects may have had them already, and fail otherwise. Scheduled jobs
+// wait for their time, or run at once if it has passed.
+func (q *jobQueue) start(ctx context.Context, db store.Store) error {
+	var resume []*Job
+	var scheduled []*Job
+	if db != nil {
+		q.db = db
+		if err := q.importFiles(); err != nil {
+			return err
+		}
+		recs, err := db.List(ctx, nsJobs, "")
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			job := &Job{}
+			if err := json.Unmarshal(rec.Value, job); err != nil {
+				log.Printf("jobs: skipping %s: %v", rec.Key, err)
+				continue
+			}
+			switch {
+			case job.Status == jobScheduled && job.RunAt != nil:
+				scheduled = append(scheduled, job)
+			case job.Status == jobRunning && !readOnlyTool(q.s.tools[job.Tool]):
+				finished := time.Now().UTC()
+				raw, _ := json.Marshal(errorResult(errors.New("interrupted by restart")))
+				job.Status, job.Result, job.Finished = jobFailed, raw, &finished
+				q.persist(job)
+			case !job.done():
+				job.Status, job.Started = jobQueued, nil
+				resume = append(resume, job)
+			}
+			q.jobs[job.ID] = job
+		}
+	}
+
+	for i := 0; i < q.cfg.Workers; i++ {
+		go q.worker(ctx)
+	}
+	go q.sweepLoop(ctx)
+	go func() {
+		// Wake the workers so they see ctx is done.
+		<-ctx.Done()
+		q.mu.Lock()
+		q.ready.Broadcast()
+		q.mu.Unlock()
+	}()
+	q.mu.Lock()
+	for _, job := range scheduled {
+		q.arm(job)
+	}
+	// Resumed jobs were accepted before, so they may exceed the queue size.
+	for _, job := range resume {
+		q.enqueue(job, true)
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// submit queues a tool call on behalf of caller.
+func (q *jobQueue) submit(ctx context.Context, caller *Caller, tool string, args json.RawMessage) (*Job, error) {
+	job := &Job{
+		ID:        randomID(),
+		Tool:      tool,
+		Arguments: args,
+		Status:    jobQueued,
+		Priority:  q.cfg.Priority.class(caller.KeyName(), tool, jobInteractive),
+		KeyName:   caller.KeyName(),
+		Created:   time.Now().UTC(),
+	}
+	if caller.Session != nil {
+		job.SessionID = caller.Session.ID
+	}
+	if tc, ok := traceFrom(ctx); ok {
+		job.Trace = tc.traceparent()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.enqueue(job, false) {
+		return nil, errQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.persist(job)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// schedule keeps a tool call on behalf of caller until runAt, when it is
+// queued like any other job. tz names the zone runAt was given in.
+func (q *jobQueue) schedule(ctx context.Context, caller *Caller, tool string, args json.RawMessage, runAt time.Time, tz string) (*Job, error) {
+	runAt = runAt.UTC()
+	job := &Job{
+		ID:        randomID(),
+		Tool:      tool,
+		Arguments: args,
+		Status:    jobScheduled,
+		Priority:  q.cfg.Priority.class(caller.KeyName(), tool, jobBatch),
+		KeyName:   caller.KeyName(),
+		RunAt:     &runAt,
+		Timezone:  tz,
+		Created:   time.Now().UTC(),
+	}
+	if caller.Session != nil {
+		job.SessionID = caller.Session.ID
+	}
+	if tc, ok := traceFrom(ctx); ok {
+		job.Trace = tc.traceparent()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := 0
+	for _, j := range q.jobs {
+		if j.KeyName == job.KeyName && j.Status == jobScheduled {
+			pending++
+		}
+	}
+	if pending >= q.cfg.MaxScheduled {
+		return nil, fmt.Errorf("%w: %d are waiting to run", errTooManyJobs, pending)
+	}
+	q.jobs[job.ID] = job
+	q.persist(job)
+	q.arm(job)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// arm starts the timer that queues a scheduled job. Callers hold q.mu.
+func (q *jobQueue) arm(job *Job) {
+	id := job.ID
+	q.timers[id] = time.AfterFunc(time.Until(*job.RunAt), func() { q.fire(id) })
+}
+
+// disarm stops the timer of a scheduled job, if any. Callers hold q.mu.
+func (q *jobQueue) disarm(id string) {
+	if t := q.timers[id]; t != nil {
+		t.Stop()
+		delete(q.timers, id)
+	}
+}
+
+// fire queues a scheduled job whose time has come. The job fails when
+// the queue is full rather than running later than asked.
+func (q *jobQueue) fire(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.timers, id)
+	job, ok := q.jobs[id]
+	if !ok || job.Status != jobScheduled {
+		return
+	}
+	if q.enqueue(job, false) {
+		job.Status = jobQueued
+	} else {
+		finished := time.Now().UTC()
+		job.Status, job.Finished = jobFailed, &finished
+		job.Result, _ = json.Marshal(errorResult(errQueueFull))
+	}
+	q.persist(job)
+}
+
+// get returns a copy of the job if it belongs to keyName; all matches any
+// owner (admin access).
+func (q *jobQueue) get(id, keyName string, all bool) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || (!all && job.KeyName != keyName) {
+		return nil, false
+	}
+	snapshot := *job
+	return &snapshot, true
+}
+
+// list returns copies of keyName's jobs (or everyone's when all is set),
+// newest first, optionally filtered by status.
+func (q *jobQueue) list(keyName string, all bool, status string) []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := []*Job{}
+	for _, job := range q.jobs {
+		if (!all && job.KeyName != keyName) || (status != "" && job.Status != status) {
+			continue
+		}
+		snapshot := *job
+		out = append(out, &snapshot)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Created.Equal(out[j].Created) {
+			return out[i].Created.After(out[j].Created)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// cancel stops a queued or running job. Running tools see their context
+// cancelled; the job is marked cancelled either way.
+func (q *jobQueue) cancel(id, keyName string, all bool) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || (!all && job.KeyName != keyName) {
+		return nil, errJobNotFound
+	}
+	if job.done() {
+		return nil, errJobFinished
+	}
+	if cancel := q.cancels[id]; cancel != nil {
+		cancel()
+	}
+	q.disarm(id)
+	q.dequeue(job)
+	finished := time.Now().UTC()
+	job.Status, job.Finished = jobCancelled, &finished
+	q.persist(job)
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// purge deletes the jobs match selects, cancelling unfinished ones, and
+// returns how many.
+func (q *jobQueue) purge(match func(*Job) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for id, job := range q.jobs {
+		if !match(job) {
+			continue
+		}
+		if cancel := q.cancels[id]; cancel != nil {
+			cancel()
+		}
+		q.disarm(id)
+		q.dequeue(job)
+		// A running job sees it was cancelled and is not stored again.
+		job.Status = jobCancelled
+		delete(q.jobs, id)
+		if q.db != nil {
+			deleteRecord(q.db, nsJobs, id)
+		}
+		n++
+	}
+	return n
+}
+
+// sweep deletes finished jobs older than the retention period.
+func (q *jobQueue) sweep() {
+	cutoff := time.Now().Add(-time.Duration(q.cfg.Retention))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.done() && job.Finished != nil && job.Finished.Before(cutoff) {
+			delete(q.jobs, id)
+			if q.db != nil {
+				deleteRecord(q.db, nsJobs, id)
+			}
+		}
+	}
+}
+
+func (q *jobQueue) sweepLoop(ctx context.Context) {
+	ticker := time.NewTicker(jobSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.sweep()
+		}
+	}
+}
+
+func (q *jobQueue) worker(ctx context.Context) {
+	for {
+		id, ok := q.take(ctx)
+		if !ok {
+			return
+		}
+		q.run(ctx, id)
+	}
+}
+
+func (q *jobQueue) run(ctx context.Context, id string) {
+	q.mu.Lock()
+	job, ok := q.jobs[id]
+	if !ok || job.Status != jobQueued {
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	job.Status, job.Started = jobRunning, &now
+	q.persist(job)
+	tool, args, keyName, sessionID, trace := job.Tool, job.Arguments, job.KeyName, job.SessionID, job.Trace
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q.cancels[id] = cancel
+	q.mu.Unlock()
+
+	s := q.s.current()
+	caller := &Caller{Key: s.lookupKey(keyName)}
+	if sess, ok := s.sessions.get(sessionID); ok {
+		caller.Session = sess
+	}
+	ctx = withCaller(ctx, caller)
+	if tc, ok := parseTraceparent(trace); ok {
+		ctx = withTrace(ctx, tc.child())
+	}
+	// Access is checked again: the key may have been removed, or the tool
+	// hidden from it, since the job was submitted.
+	var result interface{}
+	if s.cfg.Auth.enabled() && caller.Key == nil {
+		result = errorResult(fmt.Errorf("API key %q no longer exists", keyName))
+	} else if !s.toolVisible(tool, caller) {
+		result = errorResult(fmt.Errorf("%s may no longer be called with this API key", tool))
+	} else {
+		result = s.executeJob(ctx, tool, args)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		raw, _ = json.Marshal(errorResult(err))
+	}
+
+	q.mu.Lock()
+	delete(q.cancels, id)
+	if job.Status == jobCancelled {
+		q.mu.Unlock()
+		return
+	}
+	finished := time.Now().UTC()
+	job.Status, job.Result, job.Finished = jobCompleted, raw, &finished
+	if m, ok := result.(map[string]interface{}); ok && m["isError"] == true {
+		job.Status = jobFailed
+	}
+	q.persist(job)
+	status := job.Status
+	q.mu.Unlock()
+
+	if sessionID != "" {
+		q.s.notifier.send(sessionID, "notifications/jobs/completed", map[string]interface{}{
+			"jobId":  id,
+			"status": status,
+		})
+	}
+}
+
+// persist stores the job. A finished job's record expires with its
+// retention, should the sweep miss it. Callers hold q.mu.
+func (q *jobQueue) persist(job *Job) {
+	if q.db == nil {
+		return
+	}
+	if err := q.save(job); err != nil {
+		log.Printf("jobs: persisting %s: %v", job.ID, err)
+	}
+}
+
+func (q *jobQueue) save(job *Job) error {
+	var expires time.Time
+	if job.Finished != nil {
+		expires = job.Finished.Add(time.Duration(q.cfg.Retention))
+	}
+	return putJSON(q.db, nsJobs, job.ID, job.KeyName, job, expires)
+}
+
+// importFiles moves jobs persisted as files in jobs.dir by earlier
+// versions into the store.
+func (q *jobQueue) importFiles() error {
+	if q.cfg.Dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(q.cfg.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil || job.ID == "" {
+			log.Printf("jobs: not importing %s: %v", f, err)
+			continue
+		}
+		if err := q.save(job); err != nil {
+			return fmt.Errorf("importing %s: %w", f, err)
+		}
+		os.Remove(f)
+	}
+	if len(files) > 0 {
+		log.Printf("jobs: imported %d job files from %s into the state store", len(files), q.cfg.Dir)
+	}
+	return nil
+}
+
+// jobStatus is the jobs/status and jobs/list view of a job.
+func jobStatus(job *Job) map[string]interface{} {
+	out := map[string]interface{}{
+		"jobId":     job.ID,
+		"tool":      job.Tool,
+		"status":    job.Status,
+		"priority":  jobClass(job),
+		"createdAt": job.Created,
+	}
+	if job.RunAt != nil {
+		out["runAt"] = job.RunAt
+	}
+	if job.Started != nil {
+		out["startedAt"] = job.Started
+	}
+	if job.Finished != nil {
+		out["finishedAt"] = job.Finished
+	}
+	return out
+}
+
+// handleJobsMethod serves the jobs/* JSON-RPC methods for caller's jobs.
+func (s *MCPServer) handleJobsMethod(method string, raw json.RawMessage, caller *Caller, strict bool) (interface{}, *JSONRPCError) {
+	var params struct {
+		JobID  string          `json:"jobId"`
+		Status string          `json:"status"`
+		Meta   json.RawMessage `json:"_meta"`
+	}
+	if rpcErr := decodeParams(raw, &params, strict); rpcErr != nil {
+		return nil, rpcErr
+	}
+	owner := caller.KeyName()
+
+	if method == "jobs/list" {
+		jobs := s.jobs.list(owner, false, params.Status)
+		out := make([]map[string]interface{}, len(jobs))
+		for i, job := range jobs {
+			out[i] = jobStatus(job)
+		}
+		return map[string]interface{}{"jobs": out}, nil
+	}
+
+	if method == "jobs/cancel" {
+		job, err := s.jobs.cancel(params.JobID, owner, false)
+		if err != nil {
+			return nil, &JSONRPCError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return jobStatus(job), nil
+	}
+
+	job, ok := s.jobs.get(params.JobID, owner, false)
+	if !ok {
+		return nil, &JSONRPCError{Code: codeInvalidParams, Message: errJobNotFound.Error()}
+	}
+	switch method {
+	case "jobs/get":
+		out := jobStatus(job)
+		out["arguments"] = job.Arguments
+		if job.done() {
+			out["result"] = job.Result
+		}
+		return out, nil
+	case "jobs/result":
+		if !job.done() {
+			return nil, &JSONRPCError{Code: codeInvalidParams, Message: "job not finished", Data: jobStatus(job)}
+		}
+		if job.Status == jobCancelled {
+			return nil, &JSONRPCError{Code: codeInvalidParams, Message: "job cancelled"}
+		}
+		return job.Result, nil
+	default: // jobs/status
+		out := jobStatus(job)
+		if job.done() {
+			out["result"] = job.Result
+		}
+		return out, nil
+	}
+}
+
+// handleAdminJobs serves GET /admin/jobs[?status=] and GET or DELETE
+// (cancel) /admin/jobs/{id} across all API keys.
+func (s *MCPServer) handleAdminJobs(w http.ResponseWriter, r *http.Request, id string) {
+	if id == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": s.jobs.list("", true, r.URL.Query().Get("status"))})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := s.jobs.get(id, "", true)
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case http.MethodDelete:
+		job, err := s.jobs.cancel(id, "", true)
+		switch err {
+		case nil:
+			writeJSON(w, http.StatusOK, job)
+		case errJobNotFound:
+			http.Error(w, "Job not found", http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// asyncAccepted is the tools/call result for a queued job.
+func asyncAccepted(job *Job) map[string]interface{} {
+	result := textResult(fmt.Sprintf("Job %s queued; poll jobs/status for the result.", job.ID))
+	result["jobId"] = job.ID
+	result["status"] = job.Status
+	return result
+}
+
+func (s *MCPServer) lookupKey(name string) *APIKeyConfig {
+	for i := range s.cfg.Auth.APIKeys {
+		if s.cfg.Auth.APIKeys[i].Name == name {
+			return &s.cfg.Auth.APIKeys[i]
+		}
+	}
+	return s.activeMintedKey(name)
+}
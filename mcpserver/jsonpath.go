@@ -0,0 +1,124 @@
+package mcpserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// evalJSONPath evaluates a small JSONPath subset against a decoded JSON
+// value: "$", ".name", "['name']", "[index]" (negative counts from the end)
+// and the "[*]" / ".*" wildcards, which make the result a list.
+func evalJSONPath(path string, doc interface{}) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonpath %q: must start with $", path)
+	}
+	rest := path[1:]
+	nodes := []interface{}{doc}
+	wildcard := false
+
+	for rest != "" {
+		var key string
+		var index int
+		isIndex, all := false, false
+
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			all, rest = true, rest[2:]
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key, rest = rest[1:end+1], rest[end+1:]
+			if key == "" {
+				return nil, fmt.Errorf("jsonpath %q: empty key", path)
+			}
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q: unterminated [", path)
+			}
+			sel := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case sel == "*":
+				all = true
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				key = sel[1 : len(sel)-1]
+			default:
+				n, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("jsonpath %q: bad selector [%s]", path, sel)
+				}
+				index, isIndex = n, true
+			}
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", path, rest)
+		}
+
+		var next []interface{}
+		for _, node := range nodes {
+			switch {
+			case all:
+				switch v := node.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					for _, k := range sortedKeys(v) {
+						next = append(next, v[k])
+					}
+				}
+			case isIndex:
+				arr, ok := node.([]interface{})
+				if !ok {
+					if wildcard {
+						continue
+					}
+					return nil, fmt.Errorf("jsonpath %q: [%d] applied to non-array", path, index)
+				}
+				i := index
+				if i < 0 {
+					i += len(arr)
+				}
+				if i < 0 || i >= len(arr) {
+					if wildcard {
+						continue
+					}
+					return nil, fmt.Errorf("jsonpath %q: index %d out of range", path, index)
+				}
+				next = append(next, arr[i])
+			default:
+				obj, ok := node.(map[string]interface{})
+				v, found := obj[key]
+				if !ok || !found {
+					if wildcard {
+						continue
+					}
+					return nil, fmt.Errorf("jsonpath %q: no such key %q", path, key)
+				}
+				next = append(next, v)
+			}
+		}
+		nodes = next
+		wildcard = wildcard || all
+	}
+
+	if wildcard {
+		if nodes == nil {
+			nodes = []interface{}{}
+		}
+		return nodes, nil
+	}
+	return nodes[0], nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,466 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// KafkaConfig publishes audit events to a Kafka topic, keyed by event ID
+// with the event type in an "event-type" header. The producer speaks the
+// Kafka protocol itself (Produce v3, acks=all), so brokers from 0.11 on
+// work. Username and Password enable SASL/PLAIN, which belongs on TLS.
+type KafkaConfig struct {
+	Brokers  []string `json:"brokers" schema:"required"`
+	Topic    string   `json:"topic" schema:"required"`
+	TLS      bool     `json:"tls,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	ClientID string   `json:"clientId,omitempty"`                         // default "mcp-server"
+	Timeout  Duration `json:"timeout,omitempty" schema:"format=duration"` // default 10s
+}
+
+// Kafka API keys and the versions used.
+const (
+	kafkaProduce          = 0
+	kafkaMetadata         = 3
+	kafkaSaslHandshake    = 17
+	kafkaSaslAuthenticate = 36
+
+	defaultKafkaTimeout = 10 * time.Second
+)
+
+// kafkaErrors names the error codes a producer is likely to see.
+var kafkaErrors = map[int16]string{
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_FOR_PARTITION",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	31: "CLUSTER_AUTHORIZATION_FAILED",
+	33: "UNSUPPORTED_SASL_MECHANISM",
+	35: "UNSUPPORTED_VERSION",
+	58: "SASL_AUTHENTICATION_FAILED",
+	87: "INVALID_RECORD",
+}
+
+func kafkaError(code int16) error {
+	if name, ok := kafkaErrors[code]; ok {
+		return fmt.Errorf("kafka error %d (%s)", code, name)
+	}
+	return fmt.Errorf("kafka error %d", code)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaSink produces each batch to one partition of the topic, rotating
+// through the partitions. Any failure drops the connections and the
+// partition map, which are rebuilt on the next send. It is used by one
+// goroutine at a time.
+type kafkaSink struct {
+	cfg     KafkaConfig
+	timeout time.Duration
+
+	brokers    map[int32]string // node ID -> address
+	leaders    map[int32]int32  // partition -> node ID
+	partitions []int32
+	conns      map[int32]*kafkaConn
+	next       int
+}
+
+type kafkaConn struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	correlation int32
+}
+
+func newKafkaSink(cfg KafkaConfig) (*kafkaSink, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka: brokers and topic are required")
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "mcp-server"
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultKafkaTimeout
+	}
+	return &kafkaSink{cfg: cfg, timeout: timeout, conns: map[int32]*kafkaConn{}}, nil
+}
+
+func (k *kafkaSink) name() string { return "kafka" }
+
+func (k *kafkaSink) send(ctx context.Context, batch []auditRecord) error {
+	err := k.produce(ctx, batch)
+	if err != nil {
+		// Leadership may have moved or a broker gone away: start over.
+		k.close()
+	}
+	return err
+}
+
+func (k *kafkaSink) produce(ctx context.Context, batch []auditRecord) error {
+	if len(k.partitions) == 0 {
+		if err := k.refresh(ctx); err != nil {
+			return err
+		}
+	}
+	partition := k.partitions[k.next%len(k.partitions)]
+	k.next++
+	c, err := k.conn(ctx, k.leaders[partition])
+	if err != nil {
+		return err
+	}
+
+	records := kafkaRecordBatch(batch, time.Now())
+	var req kafkaWriter
+	req.int16(-1) // no transactional ID
+	req.int16(-1) // acks from all in-sync replicas
+	req.int32(int32(k.timeout / time.Millisecond))
+	req.int32(1)
+	req.string(k.cfg.Topic)
+	req.int32(1)
+	req.int32(partition)
+	req.bytes(records)
+	resp, err := c.request(ctx, k, kafkaProduce, 3, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r := kafkaReader{b: resp}
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		r.string()
+		for j, m := 0, r.int32(); j < int(m) && r.err == nil; j++ {
+			r.int32() // partition
+			code := r.int16()
+			r.int64() // base offset
+			r.int64() // log append time
+			if r.err == nil && code != 0 {
+				return fmt.Errorf("producing to %s/%d: %w", k.cfg.Topic, partition, kafkaError(code))
+			}
+		}
+	}
+	return r.err
+}
+
+// refresh reads the topic's partition leaders from the first bootstrap
+// broker that answers.
+func (k *kafkaSink) refresh(ctx context.Context) error {
+	var req kafkaWriter
+	req.int32(1)
+	req.string(k.cfg.Topic)
+	var lastErr error
+	for _, addr := range k.cfg.Brokers {
+		c, err := k.dial(ctx, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := c.request(ctx, k, kafkaMetadata, 1, req.Bytes())
+		c.conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return k.parseMetadata(resp)
+	}
+	return fmt.Errorf("kafka metadata: %w", lastErr)
+}
+
+func (k *kafkaSink) parseMetadata(resp []byte) error {
+	r := kafkaReader{b: resp}
+	k.brokers, k.leaders, k.partitions = map[int32]string{}, map[int32]int32{}, nil
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		node := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		k.brokers[node] = net.JoinHostPort(host, fmt.Sprint(port))
+	}
+	r.int32() // controller
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		code := r.int16()
+		topic := r.string()
+		r.int8() // internal
+		if r.err == nil && code != 0 {
+			return fmt.Errorf("kafka metadata for %s: %w", topic, kafkaError(code))
+		}
+		for j, m := 0, r.int32(); j < int(m) && r.err == nil; j++ {
+			code := r.int16()
+			partition := r.int32()
+			leader := r.int32()
+			r.int32s() // replicas
+			r.int32s() // in-sync replicas
+			if code == 0 && leader >= 0 {
+				k.leaders[partition] = leader
+				k.partitions = append(k.partitions, partition)
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("kafka metadata: %w", r.err)
+	}
+	if len(k.partitions) == 0 {
+		return fmt.Errorf("kafka metadata: no partition of %s has a leader", k.cfg.Topic)
+	}
+	return nil
+}
+
+// conn returns the connection to a broker, dialling it on first use.
+func (k *kafkaSink) conn(ctx context.Context, node int32) (*kafkaConn, error) {
+	if c, ok := k.conns[node]; ok {
+		return c, nil
+	}
+	addr, ok := k.brokers[node]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", node)
+	}
+	c, err := k.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	k.conns[node] = c
+	return c, nil
+}
+
+func (k *kafkaSink) dial(ctx context.Context, addr string) (*kafkaConn, error) {
+	d := net.Dialer{Timeout: k.timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if k.cfg.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tconn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		conn.SetDeadline(time.Now().Add(k.timeout))
+		if err := tconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("kafka %s: %w", addr, err)
+		}
+		conn = tconn
+	}
+	c := &kafkaConn{conn: conn, r: bufio.NewReader(conn)}
+	if k.cfg.Username != "" {
+		if err := c.authenticate(ctx, k); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("kafka %s: %w", addr, err)
+		}
+	}
+	return c, nil
+}
+
+// authenticate performs a SASL/PLAIN exchange.
+func (c *kafkaConn) authenticate(ctx context.Context, k *kafkaSink) error {
+	var req kafkaWriter
+	req.string("PLAIN")
+	resp, err := c.request(ctx, k, kafkaSaslHandshake, 1, req.Bytes())
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{b: resp}
+	if code := r.int16(); r.err == nil && code != 0 {
+		return fmt.Errorf("sasl handshake: %w", kafkaError(code))
+	}
+
+	req.Reset()
+	req.bytes([]byte("\x00" + k.cfg.Username + "\x00" + k.cfg.Password))
+	if resp, err = c.request(ctx, k, kafkaSaslAuthenticate, 0, req.Bytes()); err != nil {
+		return err
+	}
+	r = kafkaReader{b: resp}
+	code := r.int16()
+	msg := r.string()
+	if r.err == nil && code != 0 {
+		return fmt.Errorf("sasl authentication: %w: %s", kafkaError(code), msg)
+	}
+	return r.err
+}
+
+// request sends one request and returns the response body after the
+// correlation ID.
+func (c *kafkaConn) request(ctx context.Context, k *kafkaSink, apiKey, version int16, body []byte) ([]byte, error) {
+	deadline := time.Now().Add(k.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+	c.correlation++
+
+	var msg kafkaWriter
+	msg.int32(0) // size, filled in below
+	msg.int16(apiKey)
+	msg.int16(version)
+	msg.int32(c.correlation)
+	msg.string(k.cfg.ClientID)
+	msg.Write(body)
+	out := msg.Bytes()
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	if _, err := c.conn.Write(out); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("kafka: bad response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != c.correlation {
+		return nil, fmt.Errorf("kafka: response %d to request %d", id, c.correlation)
+	}
+	return resp[4:], nil
+}
+
+func (k *kafkaSink) close() {
+	for _, c := range k.conns {
+		c.conn.Close()
+	}
+	k.conns = map[int32]*kafkaConn{}
+	k.partitions = nil
+}
+
+// kafkaRecordBatch encodes batch as an uncompressed v2 record batch.
+func kafkaRecordBatch(batch []auditRecord, now time.Time) []byte {
+	ts := now.UnixMilli()
+	var records kafkaWriter
+	for i, rec := range batch {
+		var r kafkaWriter
+		r.int8(0)   // attributes
+		r.varint(0) // timestamp delta
+		r.varint(int64(i))
+		r.varbytes([]byte(rec.Key))
+		r.varbytes(rec.Value)
+		r.varint(1)
+		r.varbytes([]byte("event-type"))
+		r.varbytes([]byte(rec.Type))
+		records.varint(int64(r.Len()))
+		records.Write(r.Bytes())
+	}
+
+	// The CRC covers everything from the attributes on.
+	var body kafkaWriter
+	body.int16(0) // attributes: no compression, create time
+	body.int32(int32(len(batch) - 1))
+	body.int64(ts)
+	body.int64(ts)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(batch)))
+	body.Write(records.Bytes())
+
+	var out kafkaWriter
+	out.int64(0) // base offset, assigned by the broker
+	out.int32(int32(4 + 1 + 4 + body.Len()))
+	out.int32(-1) // partition leader epoch
+	out.int8(2)   // magic
+	out.int32(int32(crc32.Checksum(body.Bytes(), castagnoli)))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// kafkaWriter encodes Kafka protocol primitives.
+type kafkaWriter struct{ bytes.Buffer }
+
+func (w *kafkaWriter) int8(v int8)   { w.WriteByte(byte(v)) }
+func (w *kafkaWriter) int16(v int16) { w.Write(binary.BigEndian.AppendUint16(nil, uint16(v))) }
+func (w *kafkaWriter) int32(v int32) { w.Write(binary.BigEndian.AppendUint32(nil, uint32(v))) }
+func (w *kafkaWriter) int64(v int64) { w.Write(binary.BigEndian.AppendUint64(nil, uint64(v))) }
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.Write(b)
+}
+
+// varint writes a zigzag varint, as record fields use.
+func (w *kafkaWriter) varint(v int64) { w.Write(binary.AppendVarint(nil, v)) }
+
+func (w *kafkaWriter) varbytes(b []byte) {
+	w.varint(int64(len(b)))
+	w.Write(b)
+}
+
+// kafkaReader decodes Kafka protocol primitives. The first short read
+// sets err and later reads return zero values.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = errors.New("short kafka response")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string; null reads as "".
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+func (r *kafkaReader) int32s() {
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		r.int32()
+	}
+}
@@ -0,0 +1,260 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestKafkaRecordBatch checks the encoding of a one-record batch against
+// bytes laid out by hand from the Kafka record batch v2 format, with the
+// CRC-32C computed separately.
+func TestKafkaRecordBatch(t *testing.T) {
+	want := strings.Join([]string{
+		"0000000000000000",            // base offset
+		"00000057",                    // batch length
+		"ffffffff",                    // partition leader epoch
+		"02",                          // magic
+		"26cd20c9",                    // CRC-32C of the rest
+		"0000",                        // attributes
+		"00000000",                    // last offset delta
+		"0000018bcfe56800",            // first timestamp
+		"0000018bcfe56800",            // max timestamp
+		"ffffffffffffffff",            // producer ID
+		"ffff",                        // producer epoch
+		"ffffffff",                    // base sequence
+		"00000001",                    // record count
+		"4a",                          // record length 37
+		"00",                          // record attributes
+		"00",                          // timestamp delta
+		"00",                          // offset delta
+		"06" + "696431",               // key "id1"
+		"0e" + "7b2261223a317d",       // value {"a":1}
+		"02",                          // one header
+		"14" + "6576656e742d74797065", // "event-type"
+		"12" + "746f6f6c2e63616c6c",   // "tool.call"
+	}, "")
+	got := kafkaRecordBatch([]auditRecord{{Key: "id1", Type: "tool.call", Value: []byte(`{"a":1}`)}}, time.UnixMilli(1700000000000))
+	if hex.EncodeToString(got) != want {
+		t.Errorf("record batch\n got %x\nwant %s", got, want)
+	}
+	if crc := crc32.Checksum([]byte("123456789"), castagnoli); crc != 0xe3069283 {
+		t.Errorf("CRC-32C check value %#x", crc)
+	}
+}
+
+// kafkaMetadataFixture is a Metadata v1 response for topic "audit"
+// with the given topic error code.
+func kafkaMetadataFixture(topicErr string) []byte {
+	resp, _ := hex.DecodeString(strings.Join([]string{
+		"00000001",                                                    // one broker
+		"00000007", "0009" + "6b61666b612e6c616e", "00002384", "ffff", // 7, "kafka.lan", 9092, no rack
+		"00000007",                            // controller
+		"00000001",                            // one topic
+		topicErr, "0005" + "6175646974", "00", // "audit", not internal
+		"00000002", // two partitions
+		"0000", "00000000", "00000007", "00000001" + "00000007", "00000001" + "00000007",
+		"0005", "00000001", "ffffffff", "00000000", "00000000", // leader not available
+	}, ""))
+	return resp
+}
+
+func TestKafkaParseMetadata(t *testing.T) {
+	resp := kafkaMetadataFixture("0000")
+	k := &kafkaSink{cfg: KafkaConfig{Topic: "audit"}}
+	if err := k.parseMetadata(resp); err != nil {
+		t.Fatal(err)
+	}
+	if k.brokers[7] != "kafka.lan:9092" || len(k.partitions) != 1 || k.partitions[0] != 0 || k.leaders[0] != 7 {
+		t.Errorf("brokers %v, partitions %v, leaders %v", k.brokers, k.partitions, k.leaders)
+	}
+
+	if err := k.parseMetadata(resp[:len(resp)-3]); err == nil || !strings.Contains(err.Error(), "short") {
+		t.Errorf("truncated metadata: %v", err)
+	}
+	if err := k.parseMetadata(kafkaMetadataFixture("0003")); err == nil || !strings.Contains(err.Error(), "UNKNOWN_TOPIC_OR_PARTITION") {
+		t.Errorf("topic error: %v", err)
+	}
+}
+
+// fakeKafka is a single broker leading partition 0 of every topic. It
+// answers Metadata, Produce and the SASL/PLAIN exchange.
+type fakeKafka struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu         sync.Mutex
+	sasl       string
+	batches    [][]byte
+	produceErr int16
+}
+
+func newFakeKafka(t *testing.T) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeKafka{t: t, ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeKafka) serve(conn net.Conn) {
+	defer conn.Close()
+	versions := map[int16]int16{kafkaMetadata: 1, kafkaProduce: 3, kafkaSaslHandshake: 1, kafkaSaslAuthenticate: 0}
+	for {
+		var size int32
+		if binary.Read(conn, binary.BigEndian, &size) != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		r := kafkaReader{b: req}
+		key, version, correlation := r.int16(), r.int16(), r.int32()
+		if id := r.string(); id != "mcp-server" || versions[key] != version {
+			f.t.Errorf("request %d v%d from %q", key, version, id)
+			return
+		}
+
+		var resp kafkaWriter
+		resp.int32(0)
+		resp.int32(correlation)
+		switch key {
+		case kafkaMetadata:
+			host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+			p, _ := strconv.Atoi(port)
+			r.int32()
+			topic := r.string()
+			resp.int32(1) // one broker: node 1, no rack
+			resp.int32(1)
+			resp.string(host)
+			resp.int32(int32(p))
+			resp.int16(-1)
+			resp.int32(1) // controller
+			resp.int32(1) // one topic
+			resp.int16(0)
+			resp.string(topic)
+			resp.int8(0)
+			resp.int32(1) // partition 0, led by node 1, replicas [1], ISR [1]
+			resp.int16(0)
+			resp.int32(0)
+			resp.int32(1)
+			resp.int32(1)
+			resp.int32(1)
+			resp.int32(1)
+			resp.int32(1)
+		case kafkaProduce:
+			r.string() // transactional ID
+			if acks := r.int16(); acks != -1 {
+				f.t.Errorf("acks %d", acks)
+			}
+			r.int32()
+			r.int32()
+			topic := r.string()
+			r.int32()
+			partition := r.int32()
+			records := r.take(int(r.int32()))
+			f.mu.Lock()
+			f.batches = append(f.batches, records)
+			code := f.produceErr
+			f.mu.Unlock()
+			resp.int32(1)
+			resp.string(topic)
+			resp.int32(1)
+			resp.int32(partition)
+			resp.int16(code)
+			resp.int64(0)
+			resp.int64(-1)
+			resp.int32(0) // throttle time
+		case kafkaSaslHandshake:
+			if mech := r.string(); mech != "PLAIN" {
+				f.t.Errorf("mechanism %q", mech)
+			}
+			resp.int16(0)
+			resp.int32(1)
+			resp.string("PLAIN")
+		case kafkaSaslAuthenticate:
+			f.mu.Lock()
+			f.sasl = string(r.take(int(r.int32())))
+			f.mu.Unlock()
+			resp.int16(0)
+			resp.int16(-1)
+			resp.int32(0)
+		}
+		out := resp.Bytes()
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+func TestKafkaProduce(t *testing.T) {
+	f := newFakeKafka(t)
+	k, err := newKafkaSink(KafkaConfig{Brokers: []string{f.ln.Addr().String()}, Topic: "audit", Username: "svc", Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.close()
+	batch := []auditRecord{
+		{Key: "e1", Type: "tool.called", Value: []byte(`{"tool":"echo"}`)},
+		{Key: "e2", Type: "tool.completed", Value: []byte(`{"tool":"echo","ok":true}`)},
+	}
+	if err := k.send(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	sasl, batches := f.sasl, f.batches
+	f.produceErr = 6
+	f.mu.Unlock()
+	if sasl != "\x00svc\x00pw" {
+		t.Errorf("SASL/PLAIN message %q", sasl)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("broker got %d batches", len(batches))
+	}
+	got := batches[0]
+
+	// The broker can check the CRC and count the records.
+	if len(got) < 61 || got[16] != 2 {
+		t.Fatalf("not a v2 record batch: %x", got)
+	}
+	if crc := binary.BigEndian.Uint32(got[17:]); crc != crc32.Checksum(got[21:], castagnoli) {
+		t.Errorf("CRC %#x does not match", crc)
+	}
+	if n := binary.BigEndian.Uint32(got[57:]); n != 2 {
+		t.Errorf("record count %d", n)
+	}
+	if !bytes.Contains(got, []byte("tool.completed")) {
+		t.Error("record header missing")
+	}
+
+	err = k.send(context.Background(), batch)
+	if err == nil || !strings.Contains(err.Error(), "NOT_LEADER_FOR_PARTITION") {
+		t.Errorf("produce error: %v", err)
+	}
+	if len(k.partitions) != 0 || len(k.conns) != 0 {
+		t.Error("failed send kept its connections")
+	}
+}
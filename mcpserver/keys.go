@@ -0,0 +1,299 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// mintedKeyPrefix starts the tokens of minted keys, so they are told
+// apart from static keys without a store lookup.
+const mintedKeyPrefix = "mcpk_"
+
+// mintedKey is an API key created through /admin/keys. Only a hash of its
+// secret is stored; the token is shown once, when the key is minted.
+type mintedKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Role    string     `json:"role,omitempty"`
+	Tools   []string   `json:"tools,omitempty"`
+	Hash    string     `json:"hash"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+func (k *mintedKey) status(now time.Time) string {
+	switch {
+	case k.Revoked != nil:
+		return "revoked"
+	case k.Expires != nil && !now.Before(*k.Expires):
+		return "expired"
+	}
+	return "active"
+}
+
+func (k *mintedKey) apiKey() *APIKeyConfig {
+	return &APIKeyConfig{Name: k.Name, Role: k.Role, Tools: k.Tools}
+}
+
+func (k *mintedKey) view() map[string]interface{} {
+	out := map[string]interface{}{
+		"id":      k.ID,
+		"name":    k.Name,
+		"role":    k.Role,
+		"tools":   k.Tools,
+		"created": k.Created.UTC(),
+		"status":  k.status(time.Now()),
+	}
+	if k.Expires != nil {
+		out["expires"] = k.Expires.UTC()
+	}
+	if k.Revoked != nil {
+		out["revoked"] = k.Revoked.UTC()
+	}
+	return out
+}
+
+func hashKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *MCPServer) getMintedKey(ctx context.Context, id string) (*mintedKey, error) {
+	rec, err := s.store.Get(ctx, nsKeys, id)
+	if err != nil {
+		return nil, err
+	}
+	var k mintedKey
+	if err := json.Unmarshal(rec.Value, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (s *MCPServer) mintedKeys(ctx context.Context) ([]*mintedKey, error) {
+	recs, err := s.store.List(ctx, nsKeys, "")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*mintedKey, 0, len(recs))
+	for _, rec := range recs {
+		var k mintedKey
+		if json.Unmarshal(rec.Value, &k) == nil {
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.After(keys[j].Created) })
+	return keys, nil
+}
+
+// putMintedKey stores k. Records outlive the key's expiry, so its name
+// is never given to another key.
+func (s *MCPServer) putMintedKey(k *mintedKey) error {
+	return putJSON(s.store, nsKeys, k.ID, k.Name, k, time.Time{})
+}
+
+// keyNameUsed reports whether a static key or any minted key, revoked and
+// expired ones included, has the name.
+func (s *MCPServer) keyNameUsed(ctx context.Context, name string) (bool, error) {
+	for i := range s.cfg.Auth.APIKeys {
+		if s.cfg.Auth.APIKeys[i].Name == name {
+			return true, nil
+		}
+	}
+	keys, err := s.mintedKeys(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, k := range keys {
+		if k.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authenticateMinted resolves a minted key's token: mcpk_<id>.<secret>.
+// Revocation and expiry take effect on the next request, on every
+// replica sharing the store.
+func (s *MCPServer) authenticateMinted(ctx context.Context, token string) (*APIKeyConfig, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(token, mintedKeyPrefix), ".")
+	if !ok || id == "" || secret == "" {
+		return nil, errUnauthorized
+	}
+	k, err := s.getMintedKey(ctx, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("keys: looking up %s: %v", id, err)
+		}
+		return nil, errUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKeySecret(secret)), []byte(k.Hash)) != 1 || k.status(time.Now()) != "active" {
+		return nil, errUnauthorized
+	}
+	return k.apiKey(), nil
+}
+
+// activeMintedKey returns the active minted key named name, or nil.
+func (s *MCPServer) activeMintedKey(name string) *APIKeyConfig {
+	if !s.cfg.Auth.MintKeys || s.store == nil {
+		return nil
+	}
+	ctx, cancel := storeContext()
+	defer cancel()
+	keys, err := s.mintedKeys(ctx)
+	if err != nil {
+		log.Printf("keys: listing: %v", err)
+		return nil
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if k.Name == name && k.status(now) == "active" {
+			return k.apiKey()
+		}
+	}
+	return nil
+}
+
+// handleAdminKeys lists and mints keys at /admin/keys, and shows and
+// revokes one at /admin/keys/{id}.
+func (s *MCPServer) handleAdminKeys(w http.ResponseWriter, r *http.Request, rest string) {
+	if !s.cfg.Auth.MintKeys {
+		http.Error(w, "Key minting disabled; set auth.mintKeys", http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := s.mintedKeys(ctx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out := make([]map[string]interface{}, len(keys))
+			for i, k := range keys {
+				out[i] = k.view()
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"keys": out})
+		case http.MethodPost:
+			s.mintKey(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	k, err := s.getMintedKey(ctx, rest)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, k.view())
+	case http.MethodDelete:
+		if k.Revoked == nil {
+			now := time.Now().UTC()
+			k.Revoked = &now
+			if err := s.putMintedKey(k); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, k.view())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// mintMu serializes minting, so two requests cannot both find a name
+// free and then both take it. It outlives reloads, which replace the
+// server.
+var mintMu sync.Mutex
+
+func (s *MCPServer) mintKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name    string     `json:"name"`
+		Role    string     `json:"role"`
+		Tools   []string   `json:"tools"`
+		TTL     Duration   `json:"ttl"`
+		Expires *time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if body.Name == anonymousKeyName {
+		http.Error(w, fmt.Sprintf("name %q is reserved for unauthenticated callers", body.Name), http.StatusBadRequest)
+		return
+	}
+	for _, p := range body.Tools {
+		if _, err := path.Match(p, ""); err != nil {
+			http.Error(w, fmt.Sprintf("tools: bad pattern %q", p), http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	switch {
+	case body.TTL > 0 && body.Expires != nil:
+		http.Error(w, "give ttl or expires, not both", http.StatusBadRequest)
+		return
+	case body.TTL > 0:
+		t := now.Add(time.Duration(body.TTL))
+		body.Expires = &t
+	case body.Expires != nil && !body.Expires.After(now):
+		http.Error(w, "expires is in the past", http.StatusBadRequest)
+		return
+	}
+
+	// Quotas, jobs and audit records are kept by key name, so a name is
+	// never reused: a new key would inherit what the old one left.
+	mintMu.Lock()
+	defer mintMu.Unlock()
+	if used, err := s.keyNameUsed(r.Context(), body.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if used {
+		http.Error(w, fmt.Sprintf("key name %q is or was in use", body.Name), http.StatusConflict)
+		return
+	}
+	secret := randomID()
+	k := &mintedKey{
+		ID:      randomID()[:16],
+		Name:    body.Name,
+		Role:    body.Role,
+		Tools:   body.Tools,
+		Hash:    hashKeySecret(secret),
+		Created: now,
+		Expires: body.Expires,
+	}
+	if err := s.putMintedKey(k); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := k.view()
+	out["key"] = mintedKeyPrefix + k.ID + "." + secret
+	writeJSON(w, http.StatusCreated, out)
+}
@@ -0,0 +1,119 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"mcp-server/store"
+)
+
+func TestMintedKeys(t *testing.T) {
+	t.Setenv("MCP_ADMIN_TOKEN", "")
+	db, err := store.Open(store.Config{}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewMCPServer(&Config{
+		Admin: AdminConfig{Token: "admin-token"},
+		Auth:  AuthConfig{MintKeys: true, APIKeys: []APIKeyConfig{{Name: "static", Key: "static-key"}}},
+	})
+	s.store = db
+	if err := s.configure(); err != nil {
+		t.Fatal(err)
+	}
+	h := liveHandler(t, s)
+	admin := func(method, path, body string) (int, map[string]interface{}) {
+		r := testRequest(method, path, "127.0.0.1:5000", body)
+		r.Header.Set("Authorization", "Bearer admin-token")
+		w := serve(h, r)
+		var out map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &out)
+		return w.Code, out
+	}
+	call := func(token string) int {
+		r := testRequest("POST", "/mcp", "127.0.0.1:5000", `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return serve(h, r).Code
+	}
+
+	code, minted := admin("POST", "/admin/keys", `{"name": "ci", "ttl": "1h"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("mint: %d %v", code, minted)
+	}
+	token, _ := minted["key"].(string)
+	id, _ := minted["id"].(string)
+	if !strings.HasPrefix(token, mintedKeyPrefix+id+".") {
+		t.Fatalf("token %q for id %q", token, id)
+	}
+	if got := call(token); got != http.StatusOK {
+		t.Errorf("minted key: %d", got)
+	}
+	for _, bad := range []string{token + "x", mintedKeyPrefix + id + ".wrong", mintedKeyPrefix + "nosuchid.secret", mintedKeyPrefix + id} {
+		if got := call(bad); got != http.StatusUnauthorized {
+			t.Errorf("%q: %d", bad, got)
+		}
+	}
+	if _, shown := admin("GET", "/admin/keys/"+id, ""); shown["key"] != nil || shown["hash"] != nil || shown["status"] != "active" {
+		t.Errorf("shown %v", shown)
+	}
+
+	for body, want := range map[string]int{
+		`{"name": "ci"}`:                                   http.StatusConflict,
+		`{"name": "static"}`:                               http.StatusConflict,
+		`{"name": "anonymous"}`:                            http.StatusBadRequest,
+		`{"name": ""}`:                                     http.StatusBadRequest,
+		`{"name": "x", "tools": ["["]}`:                    http.StatusBadRequest,
+		`{"name": "x", "expires": "2000-01-01T00:00:00Z"}`: http.StatusBadRequest,
+	} {
+		if code, out := admin("POST", "/admin/keys", body); code != want {
+			t.Errorf("%s: %d %v, want %d", body, code, out, want)
+		}
+	}
+
+	// Revocation takes effect on the next request, and the name stays taken.
+	if code, out := admin("DELETE", "/admin/keys/"+id, ""); code != http.StatusOK || out["status"] != "revoked" {
+		t.Fatalf("revoke: %d %v", code, out)
+	}
+	if got := call(token); got != http.StatusUnauthorized {
+		t.Errorf("revoked key: %d", got)
+	}
+	if code, _ := admin("POST", "/admin/keys", `{"name": "ci"}`); code != http.StatusConflict {
+		t.Errorf("name of a revoked key: %d", code)
+	}
+	if got := call("static-key"); got != http.StatusOK {
+		t.Errorf("static key: %d", got)
+	}
+
+	// Of concurrent mints under one name, exactly one wins.
+	codes, start := make(chan int, 16), make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			code, _ := admin("POST", "/admin/keys", `{"name": "racer"}`)
+			codes <- code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("concurrent mint: %d", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d concurrent mints succeeded", created)
+	}
+}
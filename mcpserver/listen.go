@@ -0,0 +1,70 @@
+package mcpserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ListenConfig makes the server listen on a local socket instead of a TCP
+// port, for deployments that should not be reachable over the network.
+// Access is controlled by the socket file's permissions: Mode (octal,
+// default "0600") and, on Unix, Group. Windows 10 and later support the
+// same AF_UNIX sockets; access there follows the directory's ACL.
+type ListenConfig struct {
+	Socket string `json:"socket,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	Group  string `json:"group,omitempty"`
+}
+
+// listen creates the socket, replacing a stale one left by a previous run.
+func (c ListenConfig) listen() (net.Listener, error) {
+	mode := uint64(0o600)
+	if c.Mode != "" {
+		var err error
+		if mode, err = strconv.ParseUint(c.Mode, 8, 32); err != nil || mode > 0o777 {
+			return nil, fmt.Errorf("listen.mode %q: must be an octal permission like \"0660\"", c.Mode)
+		}
+	}
+	if fi, err := os.Lstat(c.Socket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", c.Socket)
+		}
+		if err := os.Remove(c.Socket); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", c.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.restrict(os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (c ListenConfig) restrict(mode os.FileMode) error {
+	if err := os.Chmod(c.Socket, mode); err != nil {
+		return err
+	}
+	if c.Group == "" {
+		return nil
+	}
+	g, err := user.LookupGroup(c.Group)
+	if _, unknown := err.(user.UnknownGroupError); unknown {
+		g, err = user.LookupGroupId(c.Group)
+	}
+	if err != nil {
+		return fmt.Errorf("listen.group: %w", err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return fmt.Errorf("listen.group: %w", err)
+	}
+	return os.Chown(c.Socket, -1, gid)
+}
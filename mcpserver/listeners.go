@@ -0,0 +1,234 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListenerConfig is one of several addresses served at once, such as
+// plaintext on an internal port and HTTPS on a public one. Each listener
+// is a TCP Addr or a Socket and wraps the shared routes in its own
+// checks.
+type ListenerConfig struct {
+	// Name labels the listener in logs; it defaults to the address.
+	Name string `json:"name,omitempty"`
+	Addr string `json:"addr,omitempty"`
+	// Network is "tcp" (default; "[::]" is dual-stack), "tcp4" or
+	// "tcp6" (IPv6 only).
+	Network string `json:"network,omitempty" schema:"enum=tcp|tcp4|tcp6"`
+	Socket  string `json:"socket,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Group   string `json:"group,omitempty"`
+	// TLS serves HTTPS with the certificate of the tls section.
+	TLS bool `json:"tls,omitempty"`
+	// Insecure exempts a plaintext TCP listener from policy.requireTLS,
+	// for internal networks.
+	Insecure bool `json:"insecure,omitempty"`
+	// Paths, when set, are the only path prefixes served, e.g. "/mcp" or
+	// "/admin/"; /health is always served.
+	Paths []string `json:"paths,omitempty"`
+	// Inbound restricts client addresses on this listener, on top of the
+	// inbound section.
+	Inbound InboundRule `json:"inbound"`
+}
+
+func (c ListenerConfig) name() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.Socket != "":
+		return c.Socket
+	}
+	return c.Addr
+}
+
+// requiresTLS reports whether policy.requireTLS refuses plain HTTP on c.
+// A local socket never crosses the network, so it needs no TLS.
+func (c ListenerConfig) requiresTLS(policy PolicyConfig) bool {
+	return policy.RequireTLS && !c.TLS && c.Socket == "" && !c.Insecure
+}
+
+func (c ListenerConfig) listen() (net.Listener, error) {
+	if c.Socket != "" {
+		return ListenConfig{Socket: c.Socket, Mode: c.Mode, Group: c.Group}.listen()
+	}
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	return net.Listen(network, c.Addr)
+}
+
+// listeners returns what to serve: the listeners section, or else one
+// listener on addr with the listen and tls sections applied.
+func (c *Config) listeners(addr string) []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	lc := ListenerConfig{Addr: addr, TLS: c.TLS.enabled()}
+	if c.Listen.Socket != "" {
+		lc = ListenerConfig{Socket: c.Listen.Socket, Mode: c.Listen.Mode, Group: c.Listen.Group}
+	}
+	return []ListenerConfig{lc}
+}
+
+func (c *Config) checkListeners() error {
+	names := map[string]bool{}
+	for i, lc := range c.Listeners {
+		if (lc.Addr == "") == (lc.Socket == "") {
+			return fmt.Errorf("listener %d: set exactly one of addr and socket", i+1)
+		}
+		if names[lc.name()] {
+			return fmt.Errorf("listener %d: %q is used twice", i+1, lc.name())
+		}
+		names[lc.name()] = true
+		switch lc.Network {
+		case "", "tcp", "tcp4", "tcp6":
+		default:
+			return fmt.Errorf("listener %s: network must be tcp, tcp4 or tcp6", lc.name())
+		}
+		if lc.TLS && !c.TLS.enabled() {
+			return fmt.Errorf("listener %s: tls needs tls.certFile and tls.keyFile or tls.acme", lc.name())
+		}
+		for _, p := range lc.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("listener %s: path %q must start with /", lc.name(), p)
+			}
+		}
+		if _, err := lc.Inbound.parse(); err != nil {
+			return fmt.Errorf("listener %s: inbound %w", lc.name(), err)
+		}
+	}
+	return nil
+}
+
+// restrictListener wraps next with the checks of one listener: its paths
+// and its inbound rule.
+func (c ListenerConfig) restrictListener(next http.Handler) http.Handler {
+	rule, _ := c.Inbound.parse() // checked by checkListeners
+	if len(c.Paths) == 0 && len(rule.allow) == 0 && len(rule.deny) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(clientIP(r)); ip != nil && !rule.admits(ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if len(c.Paths) > 0 && r.URL.Path != "/health" && !hasPathPrefix(c.Paths, r.URL.Path) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasPathPrefix(prefixes []string, path string) bool {
+	for _, p := range prefixes {
+		if path == strings.TrimSuffix(p, "/") || strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve runs an HTTP server on every listener until ctx is cancelled or
+// one of them fails, which stops the others.
+func (b *Builder) serve(ctx context.Context, cfg *Config, live *liveServer, listeners []ListenerConfig) error {
+	type bound struct {
+		lc  ListenerConfig
+		ln  net.Listener
+		srv *http.Server
+	}
+	var all []bound
+	closeAll := func() {
+		for _, bl := range all {
+			bl.ln.Close()
+		}
+	}
+	// TLS listeners share one configuration, so ACME runs once.
+	var tlsConfig *tls.Config
+	tlsConfigured := false
+	for _, lc := range listeners {
+		handler, err := live.handler(cfg, lc)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		ln, err := lc.listen()
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %w", lc.name(), err)
+		}
+		srv := cfg.Server.httpServer(handler)
+		srv.ConnState = newConnTracker(live.server().metrics, lc.name()).track
+		if lc.TLS {
+			if !tlsConfigured {
+				cfg.TLS.configure(srv)
+				tlsConfig, tlsConfigured = srv.TLSConfig, true
+			} else if tlsConfig != nil {
+				srv.TLSConfig = tlsConfig.Clone()
+			}
+			if err := cfg.Server.configureHTTP2(srv); err != nil {
+				ln.Close()
+				closeAll()
+				return fmt.Errorf("failed to configure HTTP/2: %w", err)
+			}
+		}
+		all = append(all, bound{lc, ln, srv})
+	}
+
+	printBanner(cfg, live.server(), listeners)
+	live.server().publish(EventServerStarted, map[string]interface{}{"addr": listeners[0].name(), "tools": len(live.server().tools), "profile": cfg.Profile})
+
+	shutdown := func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, bl := range all {
+			wg.Add(1)
+			go func(srv *http.Server) {
+				defer wg.Done()
+				srv.Shutdown(shutdownCtx)
+			}(bl.srv)
+		}
+		wg.Wait()
+	}
+	errc := make(chan error, len(all))
+	for _, bl := range all {
+		go func(bl bound) {
+			var err error
+			if bl.lc.TLS {
+				err = bl.srv.ServeTLS(bl.ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				err = bl.srv.Serve(bl.ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				err = fmt.Errorf("listener %s: %w", bl.lc.name(), err)
+			}
+			errc <- err
+		}(bl)
+	}
+
+	remaining := len(all)
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+		remaining--
+	}
+	shutdown()
+	for ; remaining > 0; remaining-- {
+		<-errc
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
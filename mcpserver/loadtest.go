@@ -0,0 +1,249 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadTest drives MCP traffic at a server. Every worker acts as a client
+// that initializes a session, lists the tools, makes callsPerSession tool
+// calls, ends the session and starts over.
+type loadTest struct {
+	*mcpClient
+	tool            string
+	arguments       json.RawMessage
+	callsPerSession int
+
+	mu  sync.Mutex
+	ops map[string]*loadOp
+}
+
+// loadOp collects the latencies and failures of one method.
+type loadOp struct {
+	latencies []float64 // milliseconds
+	errors    int
+	lastError string
+}
+
+// loadReport is the result of one method.
+type loadReport struct {
+	Method    string  `json:"method"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"errorRate"`
+	PerSecond float64 `json:"perSecond"`
+	P50Ms     float64 `json:"p50Ms"`
+	P90Ms     float64 `json:"p90Ms"`
+	P99Ms     float64 `json:"p99Ms"`
+	MaxMs     float64 `json:"maxMs"`
+	LastError string  `json:"lastError,omitempty"`
+}
+
+var loadMethods = []string{"initialize", "tools/list", "tools/call"}
+
+// runLoadTestCommand implements the "loadtest" subcommand.
+func runLoadTestCommand(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := fs.String("target", "", "MCP endpoint URL, e.g. http://localhost:8080/mcp")
+	concurrency := fs.Int("concurrency", 10, "number of concurrent clients")
+	duration := fs.Duration("duration", 60*time.Second, "how long to run")
+	apiKey := fs.String("api-key", os.Getenv("MCP_API_KEY"), "API key sent as a bearer token (default $MCP_API_KEY)")
+	tool := fs.String("tool", "system_info", "tool to call")
+	arguments := fs.String("arguments", "{}", "JSON arguments of the tool calls")
+	calls := fs.Int("calls", 10, "tool calls per session")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *target == "" || *concurrency < 1 || *duration <= 0 || *calls < 1 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server loadtest -target <url> [-concurrency N] [-duration 60s] [-tool name] [-arguments json] [-calls N] [-api-key key] [-json]")
+		return 2
+	}
+	if !json.Valid([]byte(*arguments)) {
+		fmt.Fprintln(os.Stderr, "loadtest: -arguments is not valid JSON")
+		return 2
+	}
+
+	lt := &loadTest{
+		mcpClient: &mcpClient{
+			target: *target,
+			apiKey: *apiKey,
+			http: &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+			},
+		},
+		tool:            *tool,
+		arguments:       json.RawMessage(*arguments),
+		callsPerSession: *calls,
+		ops:             map[string]*loadOp{},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "loadtest: %d clients calling %s on %s for %v\n", *concurrency, lt.tool, lt.target, *duration)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lt.runClient(ctx)
+		}()
+	}
+	wg.Wait()
+	reports := lt.report(time.Since(start))
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{
+			"target":      lt.target,
+			"concurrency": *concurrency,
+			"duration":    time.Since(start).Round(time.Millisecond).String(),
+			"methods":     reports,
+		})
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "method\trequests\terrors\terror rate\treq/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			r.Method, r.Requests, r.Errors, r.ErrorRate*100, r.PerSecond, r.P50Ms, r.P90Ms, r.P99Ms, r.MaxMs)
+	}
+	tw.Flush()
+	for _, r := range reports {
+		if r.LastError != "" {
+			fmt.Printf("last %s error: %s\n", r.Method, r.LastError)
+		}
+	}
+	return 0
+}
+
+// runClient runs sessions until ctx ends.
+func (lt *loadTest) runClient(ctx context.Context) {
+	for ctx.Err() == nil {
+		result, session, err := lt.call(ctx, "", "initialize", map[string]interface{}{
+			"protocolVersion": "2025-03-26",
+			"capabilities":    map[string]interface{}{},
+			"clientInfo":      map[string]string{"name": "mcp-server-loadtest", "version": "1.0.0"},
+		})
+		if err != nil || result == nil {
+			// Back off a little so an unreachable server is not spun on.
+			select {
+			case <-ctx.Done():
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+		lt.notify(ctx, session, "notifications/initialized")
+		lt.call(ctx, session, "tools/list", nil)
+		for i := 0; i < lt.callsPerSession && ctx.Err() == nil; i++ {
+			lt.call(ctx, session, "tools/call", map[string]interface{}{"name": lt.tool, "arguments": lt.arguments})
+		}
+		lt.endSession(session)
+	}
+}
+
+// call sends a JSON-RPC request and records its latency and outcome. It
+// returns the result and the session ID the server assigned, if any.
+// Requests cut short by the end of the run are not recorded.
+func (lt *loadTest) call(ctx context.Context, session, method string, params interface{}) (json.RawMessage, string, error) {
+	start := time.Now()
+	reply, err := lt.mcpClient.call(ctx, session, method, params)
+	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	if err == nil {
+		err = replyError(reply)
+	}
+	lt.record(method, elapsed, err)
+	if err != nil {
+		return nil, "", err
+	}
+	return reply.Result, reply.Session, nil
+}
+
+// replyError reports a failed request: an HTTP error, a JSON-RPC error or
+// a tool result flagged isError.
+func replyError(reply *rpcReply) error {
+	if reply.Status != http.StatusOK {
+		return fmt.Errorf("HTTP %d: %s", reply.Status, strings.TrimSpace(string(reply.Body)))
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("JSON-RPC error %d: %s", reply.Error.Code, reply.Error.Message)
+	}
+	if reply.Result == nil {
+		return fmt.Errorf("invalid response: %s", strings.TrimSpace(string(reply.Body)))
+	}
+	var result struct {
+		IsError bool   `json:"isError"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(reply.Result, &result) == nil {
+		switch {
+		case result.IsError:
+			return fmt.Errorf("tool error: %s", compactJSON(reply.Result))
+		case result.Error != "":
+			return errors.New(result.Error)
+		}
+	}
+	return nil
+}
+
+func (lt *loadTest) record(method string, elapsed time.Duration, err error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	op := lt.ops[method]
+	if op == nil {
+		op = &loadOp{}
+		lt.ops[method] = op
+	}
+	op.latencies = append(op.latencies, float64(elapsed.Microseconds())/1000)
+	if err != nil {
+		op.errors++
+		op.lastError = err.Error()
+	}
+}
+
+// report summarizes the run, which took elapsed.
+func (lt *loadTest) report(elapsed time.Duration) []loadReport {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	var out []loadReport
+	for _, method := range loadMethods {
+		op := lt.ops[method]
+		if op == nil {
+			continue
+		}
+		sort.Float64s(op.latencies)
+		n := len(op.latencies)
+		out = append(out, loadReport{
+			Method:    method,
+			Requests:  n,
+			Errors:    op.errors,
+			ErrorRate: float64(op.errors) / float64(n),
+			PerSecond: float64(n) / elapsed.Seconds(),
+			P50Ms:     percentile(op.latencies, 0.50),
+			P90Ms:     percentile(op.latencies, 0.90),
+			P99Ms:     percentile(op.latencies, 0.99),
+			MaxMs:     op.latencies[n-1],
+			LastError: op.lastError,
+		})
+	}
+	return out
+}
@@ -0,0 +1,419 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogsConfig names the log stores logs_query searches.
+type LogsConfig struct {
+	Sources []LogSource `json:"sources,omitempty"`
+}
+
+// LogSource is a Loki or Elasticsearch (or OpenSearch) endpoint. URL is
+// Loki's base, which may be a Grafana data source proxy, or the search
+// cluster's. Elasticsearch sources search Index, a pattern like
+// "logs-*", reading each hit's time and message from TimeField and
+// MessageField. Queries may span at most MaxRange.
+type LogSource struct {
+	Name         string            `json:"name" schema:"required"`
+	Type         string            `json:"type" schema:"required,enum=loki|elasticsearch"`
+	URL          string            `json:"url" schema:"required"`
+	BearerToken  string            `json:"bearerToken,omitempty"`
+	APIKey       string            `json:"apiKey,omitempty"` // Elasticsearch API key
+	Username     string            `json:"username,omitempty"`
+	Password     string            `json:"password,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Index        string            `json:"index,omitempty"`
+	TimeField    string            `json:"timeField,omitempty"`                         // default @timestamp
+	MessageField string            `json:"messageField,omitempty"`                      // default message
+	MaxRange     Duration          `json:"maxRange,omitempty" schema:"format=duration"` // default 24h
+	Timeout      Duration          `json:"timeout,omitempty" schema:"format=duration"`  // default 30s
+}
+
+const (
+	defaultLogRange   = time.Hour
+	defaultLogMaxSpan = 24 * time.Hour
+	defaultLogTimeout = 30 * time.Second
+	defaultLogLines   = 100
+	maxLogLines       = 1000
+	// maxLogLineBytes cuts single huge lines, like stack dumps.
+	maxLogLineBytes = 8 << 10
+	maxLogResponse  = 16 << 20
+)
+
+var esIndexPattern = regexp.MustCompile(`^[a-zA-Z0-9._*,:-]+$`)
+
+// logQuery is a logs_query call as the providers see it.
+type logQuery struct {
+	Query      string
+	Start, End time.Time
+	Limit      int
+	Forward    bool
+}
+
+// logLine is one entry of a result.
+type logLine struct {
+	Time      time.Time              `json:"time"`
+	Message   string                 `json:"message"`
+	Labels    map[string]string      `json:"labels,omitempty"` // Loki stream labels
+	Fields    map[string]interface{} `json:"fields,omitempty"` // other Elasticsearch fields
+	Truncated bool                   `json:"truncated,omitempty"`
+}
+
+// logProvider adapts a log store's query API.
+type logProvider interface {
+	search(ctx context.Context, q logQuery) ([]logLine, error)
+}
+
+func (c LogsConfig) enabled() bool {
+	return len(c.Sources) > 0
+}
+
+func (c LogsConfig) check(secrets map[string]string) error {
+	seen := map[string]bool{}
+	for _, src := range c.Sources {
+		if src.Name == "" || seen[src.Name] {
+			return fmt.Errorf("source %q: names must be unique and non-empty", src.Name)
+		}
+		seen[src.Name] = true
+		if err := src.check(secrets); err != nil {
+			return fmt.Errorf("source %q: %w", src.Name, err)
+		}
+	}
+	return nil
+}
+
+func (src LogSource) check(secrets map[string]string) error {
+	u, err := url.Parse(src.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return errors.New("url must be an http or https URL without credentials")
+	}
+	switch src.Type {
+	case "loki":
+		if src.APIKey != "" {
+			return errors.New("apiKey is for elasticsearch sources")
+		}
+	case "elasticsearch":
+		if !esIndexPattern.MatchString(src.Index) {
+			return errors.New("index is required: an index name or pattern like logs-*")
+		}
+	default:
+		return fmt.Errorf("type %q must be loki or elasticsearch", src.Type)
+	}
+	return src.credentials().check(secrets)
+}
+
+func (src LogSource) credentials() apiCredentials {
+	return apiCredentials{bearerToken: src.BearerToken, apiKey: src.APIKey, username: src.Username, password: src.Password, headers: src.Headers}
+}
+
+// source returns the named source, or the only one when name is empty.
+func (c LogsConfig) source(name string) (LogSource, error) {
+	if name == "" && len(c.Sources) == 1 {
+		return c.Sources[0], nil
+	}
+	names := make([]string, len(c.Sources))
+	for i, src := range c.Sources {
+		if src.Name == name {
+			return src, nil
+		}
+		names[i] = src.Name
+	}
+	return LogSource{}, fmt.Errorf("unknown log source %q; configured: %s", name, strings.Join(names, ", "))
+}
+
+type logsQueryArgs struct {
+	Source    string `json:"source,omitempty" jsonschema:"description=Configured log source; may be left out when there is only one"`
+	Query     string `json:"query" jsonschema:"description=LogQL for Loki\\, like {app=\"api\"} |= \"error\"; Lucene query string for Elasticsearch\\, like level:error AND service:api (empty for all)"`
+	Start     string `json:"start,omitempty" jsonschema:"description=Start of the range: RFC 3339\\, Unix seconds\\, now or now-15m (default now-1h)"`
+	End       string `json:"end,omitempty" jsonschema:"description=End of the range (default now)"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=1000,description=Most lines to return (default 100)"`
+	Direction string `json:"direction,omitempty" jsonschema:"enum=backward|forward,description=backward for the newest lines first (default) or forward for the oldest"`
+}
+
+func (s *MCPServer) setupLogsTools() {
+	openWorld := true
+	query, handler, _ := typedTool("logs_query", "Search a Loki or Elasticsearch log store over a bounded time range and return structured log lines", s.logsQueryTool)
+	query.Annotations = readOnlyAnnotations()
+	query.Annotations.OpenWorldHint = &openWorld
+	s.addTool(query, handler)
+}
+
+func (s *MCPServer) logsQueryTool(ctx context.Context, args logsQueryArgs) (interface{}, error) {
+	src, err := s.cfg.Logs.source(args.Source)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultLogLines
+	}
+	limit = min(limit, maxLogLines)
+	// One line over the limit tells whether there are more.
+	q := logQuery{Query: args.Query, Limit: limit + 1, Forward: args.Direction == "forward"}
+	if q.End, err = promTime(args.End, now); err != nil {
+		return nil, err
+	}
+	q.Start = q.End.Add(-defaultLogRange)
+	if args.Start != "" {
+		if q.Start, err = promTime(args.Start, now); err != nil {
+			return nil, err
+		}
+	}
+	if !q.End.After(q.Start) {
+		return nil, errors.New("end must be after start")
+	}
+	if span := orDefault(src.MaxRange, defaultLogMaxSpan); q.End.Sub(q.Start) > span {
+		return nil, fmt.Errorf("the range is over the %s source %s allows; narrow it", span, src.Name)
+	}
+
+	var provider logProvider
+	if src.Type == "loki" {
+		if strings.TrimSpace(q.Query) == "" {
+			return nil, errors.New(`loki needs a LogQL query with a stream selector, like {app="api"}`)
+		}
+		provider = lokiSource{s, src}
+	} else {
+		if strings.TrimSpace(q.Query) == "" {
+			q.Query = "*"
+		}
+		provider = esSource{s, src}
+	}
+	ctx, cancel := context.WithTimeout(ctx, orDefault(src.Timeout, defaultLogTimeout))
+	defer cancel()
+	lines, err := provider.search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		if q.Forward {
+			return lines[i].Time.Before(lines[j].Time)
+		}
+		return lines[i].Time.After(lines[j].Time)
+	})
+	truncated := len(lines) > limit
+	if truncated {
+		lines = lines[:limit]
+	}
+	for i := range lines {
+		if len(lines[i].Message) > maxLogLineBytes {
+			lines[i].Message, lines[i].Truncated = truncateUTF8(lines[i].Message, maxLogLineBytes), true
+		}
+	}
+	return map[string]interface{}{
+		"source":    src.Name,
+		"start":     q.Start.UTC().Format(time.RFC3339),
+		"end":       q.End.UTC().Format(time.RFC3339),
+		"lines":     lines,
+		"truncated": truncated,
+	}, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	for n > 0 && n < len(s) && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// logRequest sends a request to a log source and decodes its JSON reply
+// into out. Error replies are reported with the start of their body.
+func (s *MCPServer) logRequest(ctx context.Context, src LogSource, method, apiPath string, body []byte, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		payload = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(src.URL, "/")+apiPath, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := src.credentials().apply(req, s.secrets); err != nil {
+		return err
+	}
+	resp, err := ToolContextFrom(ctx).HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxLogResponse+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxLogResponse {
+		return fmt.Errorf("%s: response is over %d MiB; narrow the query", src.Name, maxLogResponse>>20)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s: HTTP %d: %s", src.Name, resp.StatusCode, bytes.TrimSpace(data[:min(len(data), 512)]))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", src.Name, err)
+	}
+	return nil
+}
+
+// lokiSource queries Loki's query_range API.
+type lokiSource struct {
+	s   *MCPServer
+	src LogSource
+}
+
+func (l lokiSource) search(ctx context.Context, q logQuery) ([]logLine, error) {
+	params := url.Values{
+		"query":     {q.Query},
+		"start":     {strconv.FormatInt(q.Start.UnixNano(), 10)},
+		"end":       {strconv.FormatInt(q.End.UnixNano(), 10)},
+		"limit":     {strconv.Itoa(q.Limit)},
+		"direction": {"backward"},
+	}
+	if q.Forward {
+		params.Set("direction", "forward")
+	}
+	var reply struct {
+		Data struct {
+			ResultType string `json:"resultType"`
+			Result     []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := l.s.logRequest(ctx, l.src, http.MethodGet, "/loki/api/v1/query_range?"+params.Encode(), nil, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("%s: the query returned %s, not log lines; metric queries belong in promql_query", l.src.Name, reply.Data.ResultType)
+	}
+	var lines []logLine
+	for _, stream := range reply.Data.Result {
+		for _, v := range stream.Values {
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid timestamp %q", l.src.Name, v[0])
+			}
+			lines = append(lines, logLine{Time: time.Unix(0, ns).UTC(), Message: v[1], Labels: stream.Stream})
+		}
+	}
+	return lines, nil
+}
+
+// esSource searches an Elasticsearch or OpenSearch index pattern with a
+// query string, filtered to the time range.
+type esSource struct {
+	s   *MCPServer
+	src LogSource
+}
+
+func (e esSource) fields() (timeField, messageField string) {
+	timeField, messageField = e.src.TimeField, e.src.MessageField
+	if timeField == "" {
+		timeField = "@timestamp"
+	}
+	if messageField == "" {
+		messageField = "message"
+	}
+	return timeField, messageField
+}
+
+func (e esSource) search(ctx context.Context, q logQuery) ([]logLine, error) {
+	timeField, messageField := e.fields()
+	order := "desc"
+	if q.Forward {
+		order = "asc"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"size":             q.Limit,
+		"track_total_hits": false,
+		"sort":             []interface{}{map[string]interface{}{timeField: map[string]string{"order": order}}},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"query_string": map[string]interface{}{"query": q.Query},
+				},
+				"filter": map[string]interface{}{
+					"range": map[string]interface{}{
+						timeField: map[string]string{
+							"gte":    q.Start.UTC().Format(time.RFC3339Nano),
+							"lte":    q.End.UTC().Format(time.RFC3339Nano),
+							"format": "strict_date_optional_time",
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Hits struct {
+			Hits []struct {
+				Index  string                 `json:"_index"`
+				Source map[string]interface{} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := e.s.logRequest(ctx, e.src, http.MethodPost, "/"+e.src.Index+"/_search", body, &reply); err != nil {
+		return nil, err
+	}
+	lines := make([]logLine, 0, len(reply.Hits.Hits))
+	for _, hit := range reply.Hits.Hits {
+		line := logLine{Fields: hit.Source}
+		if line.Fields == nil {
+			line.Fields = map[string]interface{}{}
+		}
+		if v, ok := takeField(line.Fields, timeField).(string); ok {
+			line.Time, _ = time.Parse(time.RFC3339Nano, v)
+		}
+		switch v := takeField(line.Fields, messageField).(type) {
+		case string:
+			line.Message = v
+		case nil:
+		default:
+			data, _ := json.Marshal(v)
+			line.Message = string(data)
+		}
+		line.Fields["_index"] = hit.Index
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// takeField removes and returns a field of an Elasticsearch document,
+// written either flat ("log.level") or nested ({"log": {"level": ..}}).
+func takeField(doc map[string]interface{}, name string) interface{} {
+	if v, ok := doc[name]; ok {
+		delete(doc, name)
+		return v
+	}
+	head, rest, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil
+	}
+	inner, _ := doc[head].(map[string]interface{})
+	if inner == nil {
+		return nil
+	}
+	v := takeField(inner, rest)
+	if len(inner) == 0 {
+		delete(doc, head)
+	}
+	return v
+}
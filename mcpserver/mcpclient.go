@@ -0,0 +1,135 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// mcpClient talks to an MCP server over streamable HTTP, for the loadtest,
+// doctor and repl commands.
+type mcpClient struct {
+	target string
+	apiKey string
+	http   *http.Client
+	nextID atomic.Int64
+}
+
+// defaultTarget is the MCP endpoint of a server on this machine.
+func defaultTarget() string {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port + "/mcp"
+}
+
+// rpcReply is the server's answer to a POST. Result and Error are set when
+// the body was a JSON-RPC response.
+type rpcReply struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	ID      json.RawMessage
+	Result  json.RawMessage
+	Error   *JSONRPCError
+	Session string
+}
+
+// call sends a request for method with a fresh ID.
+func (c *mcpClient) call(ctx context.Context, session, method string, params interface{}) (*rpcReply, error) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID.Add(1), "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	body, _ := json.Marshal(msg)
+	return c.post(ctx, session, body)
+}
+
+// notify sends a notification.
+func (c *mcpClient) notify(ctx context.Context, session, method string) (*rpcReply, error) {
+	body, _ := json.Marshal(map[string]string{"jsonrpc": "2.0", "method": method})
+	return c.post(ctx, session, body)
+}
+
+// post sends body as is and decodes a JSON or event-stream response.
+func (c *mcpClient) post(ctx context.Context, session string, body []byte) (*rpcReply, error) {
+	req, err := c.request(ctx, http.MethodPost, session, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	reply := &rpcReply{Status: resp.StatusCode, Header: resp.Header, Body: data, Session: resp.Header.Get(sessionHeader)}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		data = lastEventData(data)
+	}
+	var rpc struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *JSONRPCError   `json:"error"`
+	}
+	if json.Unmarshal(data, &rpc) == nil {
+		reply.ID, reply.Result, reply.Error = rpc.ID, rpc.Result, rpc.Error
+	}
+	return reply, nil
+}
+
+// endSession deletes the session, even after ctx of the run has ended.
+func (c *mcpClient) endSession(session string) (int, error) {
+	if session == "" {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := c.request(ctx, http.MethodDelete, session, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (c *mcpClient) request(ctx context.Context, method, session string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if session != "" {
+		req.Header.Set(sessionHeader, session)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// lastEventData returns the data of the last event in an event stream.
+func lastEventData(stream []byte) []byte {
+	var data []byte
+	for _, line := range strings.Split(string(stream), "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "data:"); ok {
+			data = []byte(strings.TrimSpace(rest))
+		}
+	}
+	return data
+}
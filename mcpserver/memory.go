@@ -0,0 +1,143 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryConfig tunes the Go runtime for small containers. Limit and GOGC
+// are ignored when the GOMEMLIMIT or GOGC environment variables are set.
+// While heap usage is above HighWaterPercent of the memory limit, or
+// MaxInFlightBytes of request bodies are being processed, new requests are
+// refused with 429.
+type MemoryConfig struct {
+	Limit            string `json:"limit,omitempty" schema:"format=byteSize"` // e.g. "512MiB"
+	GOGC             *int   `json:"gogc,omitempty"`
+	MaxInFlightBytes int64  `json:"maxInFlightBytes,omitempty"`
+	HighWaterPercent int    `json:"highWaterPercent,omitempty" schema:"minimum=1"`
+}
+
+const (
+	defaultHighWaterPercent = 90
+	memorySampleInterval    = time.Second
+)
+
+const backpressureMetric = "mcp_backpressure_rejections_total"
+
+// applyRuntimeTuning applies the memory limit and GC percentage.
+func applyRuntimeTuning(cfg MemoryConfig) error {
+	if cfg.Limit != "" && os.Getenv("GOMEMLIMIT") == "" {
+		n, err := parseByteSize(cfg.Limit)
+		if err != nil {
+			return fmt.Errorf("memory.limit: %w", err)
+		}
+		debug.SetMemoryLimit(n)
+	}
+	if cfg.GOGC != nil && os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(*cfg.GOGC)
+	}
+	return nil
+}
+
+// parseByteSize parses sizes such as "1048576", "64KiB", "512MiB", "2GB".
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// memoryGuard implements backpressure on request admission.
+type memoryGuard struct {
+	maxInFlight int64
+	highWater   int
+	pressure    atomic.Bool
+
+	mu       sync.Mutex
+	inFlight int64
+}
+
+func newMemoryGuard(cfg MemoryConfig) *memoryGuard {
+	g := &memoryGuard{maxInFlight: cfg.MaxInFlightBytes, highWater: cfg.HighWaterPercent}
+	if g.highWater <= 0 {
+		g.highWater = defaultHighWaterPercent
+	}
+	return g
+}
+
+// admit reserves n bytes of in-flight payload. It fails under memory
+// pressure or when the reservation would exceed the in-flight cap.
+func (g *memoryGuard) admit(n int64) (release func(), ok bool) {
+	if g.pressure.Load() {
+		return nil, false
+	}
+	if g.maxInFlight <= 0 {
+		return func() {}, true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.inFlight+n > g.maxInFlight && g.inFlight > 0 {
+		return nil, false
+	}
+	g.inFlight += n
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			g.inFlight -= n
+			g.mu.Unlock()
+		})
+	}, true
+}
+
+// monitor samples heap usage against the memory limit until ctx is
+// cancelled. Without a memory limit there is no pressure signal.
+func (g *memoryGuard) monitor(ctx context.Context) {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	ticker := time.NewTicker(memorySampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		limit := debug.SetMemoryLimit(-1)
+		if limit == math.MaxInt64 {
+			continue
+		}
+		metrics.Read(samples)
+		heap := samples[0].Value.Uint64()
+		high := uint64(limit) / 100 * uint64(g.highWater)
+		under := heap > high
+		if g.pressure.Swap(under) != under {
+			log.Printf("memory pressure %v (heap %d bytes, limit %d)", under, heap, limit)
+		}
+	}
+}
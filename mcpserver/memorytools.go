@@ -0,0 +1,123 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mcp-server/store"
+)
+
+// maxMemoryValueBytes bounds a single remembered value.
+const maxMemoryValueBytes = 64 << 10
+
+type memorySetArgs struct {
+	Key   string          `json:"key" jsonschema:"required,minLength=1,maxLength=256,description=Name to remember the value under"`
+	Value json.RawMessage `json:"value" jsonschema:"required,description=Any JSON value"`
+	TTL   Duration        `json:"ttl,omitempty" jsonschema:"description=Forget the value after this long\\, like 24h"`
+}
+
+type memoryKeyArgs struct {
+	Key string `json:"key" jsonschema:"required,minLength=1,maxLength=256,description=Name the value was remembered under"`
+}
+
+type memoryListArgs struct {
+	Prefix string `json:"prefix,omitempty" jsonschema:"description=Only list keys starting with this"`
+}
+
+// setupMemoryTools registers tools that let agents keep values across
+// sessions and restarts. Values are scoped to the caller's API key.
+func (s *MCPServer) setupMemoryTools() {
+	idempotent, destructive := true, true
+	set, setHandler, _ := typedTool("memory_set", "Remember a JSON value under a key, replacing any previous value", s.memorySetTool)
+	set.Annotations = &ToolAnnotations{IdempotentHint: &idempotent}
+	s.addTool(set, setHandler)
+
+	get, getHandler, _ := typedTool("memory_get", "Recall the value remembered under a key", s.memoryGetTool)
+	get.Annotations = readOnlyAnnotations()
+	s.addTool(get, getHandler)
+
+	list, listHandler, _ := typedTool("memory_list", "List remembered keys with their sizes and expiry", s.memoryListTool)
+	list.Annotations = readOnlyAnnotations()
+	s.addTool(list, listHandler)
+
+	del, delHandler, _ := typedTool("memory_delete", "Forget the value remembered under a key", s.memoryDeleteTool)
+	del.Annotations = &ToolAnnotations{DestructiveHint: &destructive, IdempotentHint: &idempotent}
+	s.addTool(del, delHandler)
+}
+
+// memoryKey is the record key of a caller's value: keys of different API
+// keys never collide.
+func memoryKey(ctx context.Context, key string) (owner, id string) {
+	owner = callerFrom(ctx).KeyName()
+	return owner, owner + "/" + key
+}
+
+func (s *MCPServer) memorySetTool(ctx context.Context, args memorySetArgs) (string, error) {
+	if len(args.Value) > maxMemoryValueBytes {
+		return "", fmt.Errorf("value is larger than %d KiB", maxMemoryValueBytes>>10)
+	}
+	var expires time.Time
+	if args.TTL > 0 {
+		expires = time.Now().Add(time.Duration(args.TTL))
+	}
+	if window := s.cfg.Retention.Memory; window > 0 {
+		if limit := time.Now().Add(time.Duration(window)); expires.IsZero() || expires.After(limit) {
+			expires = limit
+		}
+	}
+	owner, id := memoryKey(ctx, args.Key)
+	err := s.store.Put(ctx, &store.Record{Namespace: nsMemory, Key: id, Owner: owner, Value: args.Value, Expires: expires})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Remembered %q", args.Key), nil
+}
+
+func (s *MCPServer) memoryGetTool(ctx context.Context, args memoryKeyArgs) (map[string]interface{}, error) {
+	_, id := memoryKey(ctx, args.Key)
+	rec, err := s.store.Get(ctx, nsMemory, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("nothing is remembered under %q", args.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := memoryEntry(args.Key, rec)
+	out["value"] = json.RawMessage(rec.Value)
+	return out, nil
+}
+
+func (s *MCPServer) memoryListTool(ctx context.Context, args memoryListArgs) (map[string]interface{}, error) {
+	owner, prefix := memoryKey(ctx, args.Prefix)
+	recs, err := s.store.List(ctx, nsMemory, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := []map[string]interface{}{}
+	for _, rec := range recs {
+		entry := memoryEntry(strings.TrimPrefix(rec.Key, owner+"/"), rec)
+		entry["bytes"] = len(rec.Value)
+		keys = append(keys, entry)
+	}
+	return map[string]interface{}{"keys": keys}, nil
+}
+
+func (s *MCPServer) memoryDeleteTool(ctx context.Context, args memoryKeyArgs) (string, error) {
+	_, id := memoryKey(ctx, args.Key)
+	if err := s.store.Delete(ctx, nsMemory, id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Forgot %q", args.Key), nil
+}
+
+func memoryEntry(key string, rec *store.Record) map[string]interface{} {
+	out := map[string]interface{}{"key": key, "updated": rec.Updated.UTC()}
+	if !rec.Expires.IsZero() {
+		out["expires"] = rec.Expires.UTC()
+	}
+	return out
+}
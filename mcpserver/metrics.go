@@ -0,0 +1,240 @@
+package mcpserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry is a minimal Prometheus-compatible metrics registry.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	help       map[string]string
+	kinds      map[string]string
+	counters   map[string]map[string]float64 // name -> encoded labels -> value; gauges too
+	buckets    map[string][]float64
+	histograms map[string]map[string]*histogram
+}
+
+// histogram is one series of a histogram: counts per upper bound, plus
+// the running sum and count.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+const toolDurationMetric = "mcp_tool_duration_seconds"
+
+// latencyBuckets suit tool calls, from fast builtins to slow backends.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// setupMetrics declares the server's metrics so they carry help text.
+func (s *MCPServer) setupMetrics() {
+	s.metrics.counter(toolCallsMetric, "Tool calls by tool and outcome.")
+	s.metrics.counter(deprecatedCallsMetric, "Calls to deprecated tools and aliases.")
+	s.metrics.counter(dedupedCallsMetric, "Calls of idempotent tools that joined an identical call in flight, by tool.")
+	s.metrics.counter(keyBytesMetric, "Request and response bytes by API key.")
+	s.metrics.counter(keyCallsMetric, "Tool invocations by API key.")
+	s.metrics.counter(clientCallsMetric, "Tool calls by client application, as named in initialize, and outcome.")
+	s.metrics.counter(backpressureMetric, "Requests refused with 429 under memory pressure.")
+	s.metrics.counter(shedMetric, "Requests refused by load shedding, by priority class.")
+	s.metrics.gauge(inFlightMetric, "JSON-RPC requests being handled, when load shedding is on.")
+	s.metrics.counter(configReloadsMetric, "Config reloads by outcome.")
+	s.metrics.counter(panicsMetric, "Recovered panics by method, path or job tool.")
+	s.metrics.counter(approvalsMetric, "Approval decisions by tool and outcome.")
+	s.metrics.counter(chaosFaultsMetric, "Faults injected by chaos mode, by kind.")
+	s.metrics.counter(elicitationsMetric, "Elicitation requests by outcome.")
+	s.metrics.counter(canaryCallsMetric, "Calls to tools with a canary by version and outcome.")
+	s.metrics.counter(canaryRollbacksMetric, "Automatic canary rollbacks by tool.")
+	s.metrics.counter(webhookDeliveriesMetric, "Webhook deliveries by event and outcome.")
+	s.metrics.counter(auditEventsMetric, "Audit events by sink and outcome: exported or dropped.")
+	s.metrics.counter(auditSendFailuresMetric, "Failed attempts to send an audit batch by sink.")
+	s.metrics.counter(purgedMetric, "Records and entries deleted for outliving their retention, by category.")
+	s.metrics.counter(inboundDeniedMetric, "Requests refused by the inbound address rules, by surface.")
+	s.metrics.counter(transferBytesMetric, "Bytes moved by the transfer tools, by endpoint and direction.")
+	s.metrics.counter(mqttMessagesMetric, "MQTT messages received from and published to the broker, by direction.")
+	s.metrics.gauge(mqttConnectedMetric, "Whether the MQTT bridge is connected to its broker.")
+	s.metrics.counter(slowRequestsMetric, "JSON-RPC requests slower than logging.slowRequest, by method and tool.")
+	s.metrics.gauge(connectionsMetric, "Open HTTP connections by listener.")
+	s.metrics.counter(connectionsTotalMetric, "Accepted HTTP connections by listener.")
+	s.metrics.gauge(streamsMetric, "Open server-sent event streams.")
+	s.metrics.gauge(jobQueueDepthMetric, "Jobs waiting for a worker, by priority class.")
+	s.metrics.counter(jobPromotedMetric, "Batch jobs run ahead of interactive ones after waiting too long.")
+	s.metrics.histogram(jobWaitMetric, "Time jobs wait for a worker, by priority class.", jobWaitBuckets)
+	s.metrics.histogram(toolDurationMetric, "Tool call latency by tool, from the monotonic clock.", latencyBuckets)
+	s.metrics.histogram(connectionDurationMetric, "How long HTTP connections stay open, by listener.", connectionBuckets)
+	s.metrics.histogram(streamDurationMetric, "How long server-sent event streams stay open.", connectionBuckets)
+	s.metrics.gauge("process_start_time_seconds", "Start time of the process since the Unix epoch.")
+	s.metrics.set("process_start_time_seconds", float64(processStart.UnixNano())/1e9)
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		help:       map[string]string{},
+		kinds:      map[string]string{},
+		counters:   map[string]map[string]float64{},
+		buckets:    map[string][]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+// counter declares a counter so it is exported with help text.
+func (m *metricsRegistry) counter(name, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.help[name] = help
+	m.kinds[name] = "counter"
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+}
+
+// add increments a counter. labels are alternating name/value pairs.
+func (m *metricsRegistry) add(name string, v float64, labels ...string) {
+	key := encodeLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := m.counters[name]
+	if series == nil {
+		series = map[string]float64{}
+		m.counters[name] = series
+	}
+	series[key] += v
+}
+
+func (m *metricsRegistry) inc(name string, labels ...string) {
+	m.add(name, 1, labels...)
+}
+
+// gauge declares a gauge; its series are set rather than incremented.
+func (m *metricsRegistry) gauge(name, help string) {
+	m.counter(name, help)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[name] = "gauge"
+}
+
+func (m *metricsRegistry) set(name string, v float64, labels ...string) {
+	key := encodeLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+	m.counters[name][key] = v
+}
+
+// histogram declares a histogram with the given upper bounds, ascending.
+func (m *metricsRegistry) histogram(name, help string, buckets []float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.help[name] = help
+	m.kinds[name] = "histogram"
+	m.buckets[name] = buckets
+	if m.histograms[name] == nil {
+		m.histograms[name] = map[string]*histogram{}
+	}
+}
+
+// observe records v in a declared histogram.
+func (m *metricsRegistry) observe(name string, v float64, labels ...string) {
+	key := encodeLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series, ok := m.histograms[name]
+	if !ok {
+		return
+	}
+	h := series[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets[name]))}
+		series[key] = h
+	}
+	for i, bound := range m.buckets[name] {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func encodeLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		fmt.Fprintf(&b, "%s=\"%s\"", labels[i], v)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	names := make([]string, 0, len(m.counters)+len(m.histograms))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	for name := range m.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if help := m.help[name]; help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		}
+		if kind := m.kinds[name]; kind != "" {
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		}
+		if hs, ok := m.histograms[name]; ok {
+			m.writeHistogram(w, name, hs)
+			continue
+		}
+		series := m.counters[name]
+		keys := make([]string, 0, len(series))
+		for k := range series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, k, series[k])
+		}
+	}
+}
+
+func (m *metricsRegistry) writeHistogram(w io.Writer, name string, series map[string]*histogram) {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := series[k]
+		// Add le to the series' labels.
+		labels := strings.TrimSuffix(strings.TrimPrefix(k, "{"), "}")
+		if labels != "" {
+			labels += ","
+		}
+		for i, bound := range m.buckets[name] {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", name, k, h.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", name, k, h.count)
+	}
+}
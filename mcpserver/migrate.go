@@ -0,0 +1,173 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"mcp-server/store"
+)
+
+// Migrate applies pending state store migrations and returns without
+// serving, for running migrations as a deployment step of its own.
+func (b *Builder) Migrate(ctx context.Context) error {
+	if b.err != nil {
+		return b.err
+	}
+	cfg, err := b.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config:\n%w", err)
+	}
+	m, err := openMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := migrateUp(ctx, m); err != nil {
+		return err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("state store: schema at version %d", version)
+	return nil
+}
+
+// openMigrator connects to the configured state store without migrating.
+func openMigrator(cfg *Config) (*store.Migrator, error) {
+	dir := cfg.dataDir()
+	if dir == "" && !cfg.Store.Enabled() {
+		return nil, fmt.Errorf("no state store configured: set dataDir or store")
+	}
+	return store.NewMigrator(cfg.Store, dir)
+}
+
+// migrateUp applies pending migrations, logging each.
+func migrateUp(ctx context.Context, m *store.Migrator) error {
+	applied, err := m.Up(ctx)
+	for _, mig := range applied {
+		log.Printf("state store: applied migration %04d_%s", mig.Version, mig.Name)
+	}
+	return err
+}
+
+// runMigrateCommand implements the "migrate" subcommand.
+func runMigrateCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server migrate status | up | down [n] | to <version>")
+		return 2
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return usage()
+	}
+	cfg, err := loadConfig(os.Getenv("MCP_CONFIG"), os.Getenv("MCP_ENV"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	m, err := openMigrator(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	defer m.Close()
+	ctx := context.Background()
+	current, err := m.Version(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+
+	target := m.Latest()
+	switch args[0] {
+	case "status":
+		fmt.Printf("schema version %d, latest %d\n", current, m.Latest())
+		for _, mig := range m.Migrations() {
+			state := "pending"
+			if mig.Version <= current {
+				state = "applied"
+			}
+			fmt.Printf("  %04d_%s\t%s\n", mig.Version, mig.Name, state)
+		}
+		return 0
+	case "up":
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return usage()
+			}
+		}
+		if target = current - steps; target < 0 {
+			target = 0
+		}
+	case "to":
+		if len(args) != 2 {
+			return usage()
+		}
+		if target, err = strconv.Atoi(args[1]); err != nil {
+			return usage()
+		}
+	default:
+		return usage()
+	}
+
+	done, err := m.To(ctx, target)
+	verb := "applied"
+	if target < current {
+		verb = "reverted"
+	}
+	for _, mig := range done {
+		fmt.Printf("%s %04d_%s\n", verb, mig.Version, mig.Name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	if len(done) == 0 {
+		fmt.Printf("already at version %d\n", current)
+	}
+	return 0
+}
+
+// runRekeyCommand implements the "rekey" subcommand: it seals every record
+// with the first configured key, so the others can be retired.
+func runRekeyCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server rekey")
+		return 2
+	}
+	cfg, err := loadConfig(os.Getenv("MCP_CONFIG"), os.Getenv("MCP_ENV"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rekey: %v\n", err)
+		return 1
+	}
+	if !cfg.Store.Encryption.Enabled() {
+		fmt.Fprintln(os.Stderr, "rekey: no store.encryption keys configured")
+		return 1
+	}
+	ring, err := store.NewKeyring(cfg.Store.Encryption)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rekey: %v\n", err)
+		return 1
+	}
+	db, err := openDatabase(cfg)
+	if err == nil && db == nil {
+		err = fmt.Errorf("no state store configured: set dataDir or store")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rekey: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	n, err := store.Rekey(context.Background(), db, ring, hashedNamespaces...)
+	fmt.Printf("resealed %d records with key %q\n", n, cfg.Store.Encryption.Keys[0].ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rekey: %v\n", err)
+		return 1
+	}
+	return 0
+}
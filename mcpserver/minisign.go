@@ -0,0 +1,65 @@
+package mcpserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKey is a minisign public key: the "RW..." line of a .pub file.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// parseMinisignKey accepts the key line alone or a whole .pub file.
+func parseMinisignKey(text string) (*minisignKey, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, errors.New("not a minisign public key")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(raw[10:])}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+// verify checks a .minisig signature of data and returns its trusted
+// comment. Both legacy ("Ed") and prehashed ("ED") signatures are
+// accepted.
+func (k *minisignKey) verify(data []byte, sig []byte) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(string(sig)), "\r\n", "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("malformed minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 74 {
+		return "", errors.New("malformed minisign signature")
+	}
+	if !bytes.Equal(raw[2:10], k.id[:]) {
+		return "", fmt.Errorf("signed with key %X, not %X", raw[2:10], k.id)
+	}
+	msg := data
+	switch string(raw[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	default:
+		return "", fmt.Errorf("unknown signature algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(k.key, msg, raw[10:]) {
+		return "", errors.New("signature does not match")
+	}
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(k.key, append(append([]byte{}, raw[10:]...), comment...), global) {
+		return "", errors.New("trusted comment signature does not match")
+	}
+	return comment, nil
+}
@@ -0,0 +1,290 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// monitoringOptions shape the generated dashboard and alert rules.
+type monitoringOptions struct {
+	Selector  string        // label matchers of the server's series, e.g. job="mcp-server"
+	ErrorRate float64       // tool error ratio that alerts
+	P99       time.Duration // tool p99 latency that alerts
+}
+
+// runGenerateMonitoring implements "generate grafana" and "generate alerts".
+func runGenerateMonitoring(kind string, args []string) int {
+	fs := flag.NewFlagSet("generate "+kind, flag.ContinueOnError)
+	out := fs.String("o", "", "file to write (default stdout)")
+	opts := monitoringOptions{}
+	fs.StringVar(&opts.Selector, "selector", `job="mcp-server"`, "Prometheus label matchers selecting this server's series")
+	fs.Float64Var(&opts.ErrorRate, "error-rate", 0.05, "tool error ratio that alerts")
+	fs.DurationVar(&opts.P99, "p99", 5*time.Second, "tool p99 latency that alerts")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var data []byte
+	if kind == "grafana" {
+		var err error
+		if data, err = json.MarshalIndent(grafanaDashboard(opts), "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "generate %s: %v\n", kind, err)
+			return 1
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(alertRules(opts))
+	}
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := writeFileAtomic(*out, data); err != nil {
+		fmt.Fprintf(os.Stderr, "generate %s: %v\n", kind, err)
+		return 1
+	}
+	fmt.Println("created", *out)
+	return 0
+}
+
+// series returns the selector of metric with extra matchers appended.
+func (o monitoringOptions) series(metric string, matchers ...string) string {
+	all := matchers
+	if o.Selector != "" {
+		all = append([]string{o.Selector}, matchers...)
+	}
+	return metric + "{" + strings.Join(all, ",") + "}"
+}
+
+// grafanaDashboard builds a dashboard over the server's metrics. Its
+// datasource and tool variables are chosen on import and in the UI.
+func grafanaDashboard(o monitoringOptions) map[string]interface{} {
+	tool := `tool=~"$tool"`
+	calls := o.series(toolCallsMetric, tool)
+	errors := o.series(toolCallsMetric, tool, `outcome="error"`)
+	buckets := o.series(toolDurationMetric+"_bucket", tool)
+	quantile := func(q string) string {
+		return fmt.Sprintf("histogram_quantile(%s, sum by (tool, le) (rate(%s[$__rate_interval])))", q, buckets)
+	}
+
+	var panels []interface{}
+	y := 0
+	row := func(title string) {
+		panels = append(panels, map[string]interface{}{
+			"id": len(panels) + 1, "type": "row", "title": title, "collapsed": false,
+			"gridPos": map[string]int{"x": 0, "y": y, "w": 24, "h": 1},
+		})
+		y++
+	}
+	// add places panels left to right, w columns each, in rows of height h.
+	x := 0
+	add := func(w, h int, panel map[string]interface{}) {
+		if x+w > 24 {
+			x, y = 0, y+h
+		}
+		panel["id"] = len(panels) + 1
+		panel["datasource"] = grafanaDatasource
+		panel["gridPos"] = map[string]int{"x": x, "y": y, "w": w, "h": h}
+		panels = append(panels, panel)
+		x += w
+		if x >= 24 {
+			x, y = 0, y+h
+		}
+	}
+
+	row("Overview")
+	add(6, 4, statPanel("Calls/s", fmt.Sprintf("sum(rate(%s[$__rate_interval]))", calls), "reqps"))
+	add(6, 4, statPanel("Error ratio", fmt.Sprintf("sum(rate(%s[$__rate_interval])) / sum(rate(%s[$__rate_interval]))", errors, calls), "percentunit"))
+	add(6, 4, statPanel("p99 latency", fmt.Sprintf("histogram_quantile(0.99, sum by (le) (rate(%s[$__rate_interval])))", buckets), "s"))
+	add(6, 4, statPanel("Uptime", "time() - max("+o.series("process_start_time_seconds")+")", "s"))
+
+	row("Tools")
+	add(12, 8, timeseriesPanel("Calls by tool", "reqps", target(fmt.Sprintf("sum by (tool) (rate(%s[$__rate_interval]))", calls), "{{tool}}")))
+	add(12, 8, timeseriesPanel("Error ratio by tool", "percentunit", target(fmt.Sprintf("sum by (tool) (rate(%s[$__rate_interval])) / sum by (tool) (rate(%s[$__rate_interval]))", errors, calls), "{{tool}}")))
+	add(12, 8, timeseriesPanel("p50 latency by tool", "s", target(quantile("0.5"), "{{tool}}")))
+	add(12, 8, timeseriesPanel("p99 latency by tool", "s", target(quantile("0.99"), "{{tool}}")))
+
+	row("API keys")
+	add(12, 8, timeseriesPanel("Tool calls by key", "reqps", target(fmt.Sprintf("sum by (key) (rate(%s[$__rate_interval]))", o.series(keyCallsMetric)), "{{key}}")))
+	add(12, 8, timeseriesPanel("Bytes by key", "Bps", target(fmt.Sprintf("sum by (key, direction) (rate(%s[$__rate_interval]))", o.series(keyBytesMetric)), "{{key}} {{direction}}")))
+
+	row("Clients")
+	clientCalls := o.series(clientCallsMetric)
+	add(12, 8, timeseriesPanel("Tool calls by client", "reqps", target(fmt.Sprintf("sum by (client) (rate(%s[$__rate_interval]))", clientCalls), "{{client}}")))
+	add(12, 8, timeseriesPanel("Error ratio by client", "percentunit", target(fmt.Sprintf("sum by (client) (rate(%s[$__rate_interval])) / sum by (client) (rate(%s[$__rate_interval]))",
+		o.series(clientCallsMetric, `outcome="error"`), clientCalls), "{{client}}")))
+
+	row("Health")
+	add(8, 8, timeseriesPanel("Panics", "short", target(fmt.Sprintf("sum by (where) (increase(%s[$__rate_interval]))", o.series(panicsMetric)), "{{where}}")))
+	add(8, 8, timeseriesPanel("Backpressure rejections", "reqps", target(fmt.Sprintf("sum(rate(%s[$__rate_interval]))", o.series(backpressureMetric)), "rejected")))
+	add(8, 8, timeseriesPanel("Config reloads", "short", target(fmt.Sprintf("sum by (outcome) (increase(%s[$__rate_interval]))", o.series(configReloadsMetric)), "{{outcome}}")))
+
+	row("Rollouts and approvals")
+	add(8, 8, timeseriesPanel("Canary calls", "reqps", target(fmt.Sprintf("sum by (tool, version, outcome) (rate(%s[$__rate_interval]))", o.series(canaryCallsMetric)), "{{tool}} {{version}} {{outcome}}")))
+	add(8, 8, timeseriesPanel("Approvals", "short", target(fmt.Sprintf("sum by (outcome) (increase(%s[$__rate_interval]))", o.series(approvalsMetric)), "{{outcome}}")))
+	add(8, 8, timeseriesPanel("Deprecated tool calls", "reqps", target(fmt.Sprintf("sum by (tool, replacement) (rate(%s[$__rate_interval]))", o.series(deprecatedCallsMetric)), "{{tool}} → {{replacement}}")))
+	add(12, 8, timeseriesPanel("Elicitations", "short", target(fmt.Sprintf("sum by (outcome) (increase(%s[$__rate_interval]))", o.series(elicitationsMetric)), "{{outcome}}")))
+	add(12, 8, timeseriesPanel("Chaos faults", "short", target(fmt.Sprintf("sum by (kind) (increase(%s[$__rate_interval]))", o.series(chaosFaultsMetric)), "{{kind}}")))
+
+	return map[string]interface{}{
+		"__inputs": []map[string]interface{}{{
+			"name": "DS_PROMETHEUS", "label": "Prometheus", "type": "datasource",
+			"pluginId": "prometheus", "pluginName": "Prometheus",
+		}},
+		"title":         "MCP Server",
+		"uid":           "mcp-server",
+		"tags":          []string{"mcp"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]interface{}{"list": []interface{}{
+			map[string]interface{}{
+				"name": "datasource", "label": "Data source", "type": "datasource",
+				"query": "prometheus", "current": map[string]string{"text": "${DS_PROMETHEUS}", "value": "${DS_PROMETHEUS}"},
+			},
+			map[string]interface{}{
+				"name": "tool", "label": "Tool", "type": "query", "datasource": grafanaDatasource,
+				"query":   fmt.Sprintf("label_values(%s, tool)", o.series(toolCallsMetric)),
+				"refresh": 2, "multi": true, "includeAll": true, "allValue": ".*",
+				"current": map[string]interface{}{"text": "All", "value": "$__all"},
+				"sort":    1,
+			},
+		}},
+		"panels": panels,
+	}
+}
+
+var grafanaDatasource = map[string]string{"type": "prometheus", "uid": "${datasource}"}
+
+func target(expr, legend string) map[string]interface{} {
+	return map[string]interface{}{"refId": "A", "expr": expr, "legendFormat": legend, "datasource": grafanaDatasource}
+}
+
+func statPanel(title, expr, unit string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "stat",
+		"title":       title,
+		"targets":     []interface{}{target(expr, title)},
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}, "overrides": []interface{}{}},
+		"options":     map[string]interface{}{"reduceOptions": map[string]interface{}{"calcs": []string{"lastNotNull"}}},
+	}
+}
+
+func timeseriesPanel(title, unit string, targets ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "timeseries",
+		"title":       title,
+		"targets":     targets,
+		"fieldConfig": map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}, "overrides": []interface{}{}},
+		"options":     map[string]interface{}{"legend": map[string]interface{}{"displayMode": "table", "placement": "right", "showLegend": true}},
+	}
+}
+
+// alertRule is one Prometheus alerting rule.
+type alertRule struct {
+	Name        string
+	Expr        string
+	For         string
+	Severity    string
+	Summary     string
+	Description string
+}
+
+// alertRules renders a Prometheus rule file for the server.
+func alertRules(o monitoringOptions) string {
+	calls := o.series(toolCallsMetric)
+	rules := []alertRule{
+		{
+			Name:        "MCPServerDown",
+			Expr:        "up{" + o.Selector + "} == 0",
+			For:         "2m",
+			Severity:    "critical",
+			Summary:     "MCP server {{ $labels.instance }} is down",
+			Description: "Prometheus has not been able to scrape {{ $labels.instance }} for 2 minutes.",
+		},
+		{
+			Name: "MCPToolErrorRateHigh",
+			Expr: fmt.Sprintf("sum by (tool) (rate(%s[5m])) / sum by (tool) (rate(%s[5m])) > %g\nand sum by (tool) (rate(%s[5m])) > 0.1",
+				o.series(toolCallsMetric, `outcome="error"`), calls, o.ErrorRate, calls),
+			For:         "10m",
+			Severity:    "warning",
+			Summary:     "Tool {{ $labels.tool }} fails often",
+			Description: fmt.Sprintf("{{ $value | humanizePercentage }} of {{ $labels.tool }} calls failed over 5 minutes (threshold %g%%).", o.ErrorRate*100),
+		},
+		{
+			Name:        "MCPToolLatencyHigh",
+			Expr:        fmt.Sprintf("histogram_quantile(0.99, sum by (tool, le) (rate(%s[5m]))) > %g", o.series(toolDurationMetric+"_bucket"), o.P99.Seconds()),
+			For:         "10m",
+			Severity:    "warning",
+			Summary:     "Tool {{ $labels.tool }} is slow",
+			Description: fmt.Sprintf("p99 latency of {{ $labels.tool }} is {{ $value | humanizeDuration }} (threshold %v).", o.P99),
+		},
+		{
+			Name:        "MCPServerPanics",
+			Expr:        fmt.Sprintf("sum by (instance, where) (increase(%s[5m])) > 0", o.series(panicsMetric)),
+			Severity:    "warning",
+			Summary:     "MCP server {{ $labels.instance }} recovered from panics",
+			Description: "{{ $value }} panics in {{ $labels.where }} over 5 minutes.",
+		},
+		{
+			Name:        "MCPServerBackpressure",
+			Expr:        fmt.Sprintf("sum by (instance) (rate(%s[5m])) > 0", o.series(backpressureMetric)),
+			For:         "5m",
+			Severity:    "warning",
+			Summary:     "MCP server {{ $labels.instance }} is refusing requests under memory pressure",
+			Description: "{{ $value }} requests/s are refused with 429 because the memory limit is near.",
+		},
+		{
+			Name:        "MCPConfigReloadFailed",
+			Expr:        fmt.Sprintf("sum by (instance) (increase(%s[15m])) > 0", o.series(configReloadsMetric, `outcome="error"`)),
+			Severity:    "warning",
+			Summary:     "MCP server {{ $labels.instance }} failed to reload its config",
+			Description: "The server keeps running the previous config; see GET /admin/config for the error.",
+		},
+		{
+			Name:        "MCPCanaryRolledBack",
+			Expr:        fmt.Sprintf("sum by (instance, tool) (increase(%s[15m])) > 0", o.series(canaryRollbacksMetric)),
+			Severity:    "warning",
+			Summary:     "Canary of {{ $labels.tool }} was rolled back",
+			Description: "The canary version of {{ $labels.tool }} failed more than the stable version and was rolled back.",
+		},
+		{
+			Name:        "MCPServerRestarted",
+			Expr:        fmt.Sprintf("changes(%s[15m]) > 0", o.series("process_start_time_seconds")),
+			Severity:    "info",
+			Summary:     "MCP server {{ $labels.instance }} restarted",
+			Description: "The process start time changed in the last 15 minutes.",
+		},
+		{
+			Name:        "MCPChaosModeActive",
+			Expr:        fmt.Sprintf("sum by (instance) (increase(%s[15m])) > 0", o.series(chaosFaultsMetric)),
+			Severity:    "info",
+			Summary:     "Chaos mode is injecting faults on {{ $labels.instance }}",
+			Description: "Turn chaos.enabled off unless this is a test deployment.",
+		},
+	}
+
+	var b strings.Builder
+	b.WriteString("# Prometheus alerting rules for mcp-server, generated by\n")
+	b.WriteString("# \"mcp-server generate alerts\".\n")
+	b.WriteString("groups:\n  - name: mcp-server\n    rules:\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "      - alert: %s\n        expr: |\n", r.Name)
+		for _, line := range strings.Split(r.Expr, "\n") {
+			fmt.Fprintf(&b, "          %s\n", line)
+		}
+		if r.For != "" {
+			fmt.Fprintf(&b, "        for: %s\n", r.For)
+		}
+		fmt.Fprintf(&b, "        labels:\n          severity: %s\n", r.Severity)
+		// JSON strings are valid YAML double-quoted scalars.
+		summary, _ := json.Marshal(r.Summary)
+		description, _ := json.Marshal(r.Description)
+		fmt.Fprintf(&b, "        annotations:\n          summary: %s\n          description: %s\n", summary, description)
+	}
+	return b.String()
+}
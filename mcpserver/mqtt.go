@@ -0,0 +1,780 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MQTTConfig connects the server to an MQTT broker. Topics matching the
+// Subscribe filters become resources, mqtt://topics/{topic}, holding the
+// last message received on each, and sessions subscribed to one are sent
+// notifications/resources/updated on every message. mqtt_publish sends to
+// topics matching the Publish filters. An mqtts:// URL uses TLS, trusting
+// the CAs and pins of egress.tls. Password may refer to ${secret:name}.
+type MQTTConfig struct {
+	URL       string   `json:"url,omitempty"`      // mqtt://host:1883 or mqtts://host:8883
+	ClientID  string   `json:"clientId,omitempty"` // default mcp-server- and a random suffix
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	Subscribe []string `json:"subscribe,omitempty"`
+	Publish   []string `json:"publish,omitempty"`
+	KeepAlive Duration `json:"keepAlive,omitempty" schema:"format=duration"` // default 30s
+	Timeout   Duration `json:"timeout,omitempty" schema:"format=duration"`   // default 10s
+}
+
+const (
+	mqttURIPrefix        = "mqtt://topics/"
+	defaultMQTTKeepAlive = 30 * time.Second
+	defaultMQTTTimeout   = 10 * time.Second
+	maxMQTTRetryDelay    = time.Minute
+	// maxMQTTTopics bounds the topics kept as resources, and
+	// maxMQTTPayload the bytes kept of each one's last message.
+	maxMQTTTopics  = 1000
+	maxMQTTPayload = 64 << 10
+
+	mqttMessagesMetric  = "mcp_mqtt_messages_total"
+	mqttConnectedMetric = "mcp_mqtt_connected"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttRefusals explain the CONNACK return codes.
+var mqttRefusals = []string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad username or password",
+	5: "not authorized",
+}
+
+func (c MQTTConfig) enabled() bool {
+	return c.URL != ""
+}
+
+func (c MQTTConfig) check(secrets map[string]string) error {
+	if !c.enabled() {
+		if len(c.Subscribe) > 0 || len(c.Publish) > 0 {
+			return errors.New("url is required")
+		}
+		return nil
+	}
+	if _, _, err := c.broker(); err != nil {
+		return err
+	}
+	if len(c.Subscribe) == 0 && len(c.Publish) == 0 {
+		return errors.New("give subscribe or publish topic filters")
+	}
+	for _, f := range c.Subscribe {
+		if err := checkTopic(f, true); err != nil {
+			return fmt.Errorf("subscribe: %w", err)
+		}
+	}
+	for _, f := range c.Publish {
+		if err := checkTopic(f, true); err != nil {
+			return fmt.Errorf("publish: %w", err)
+		}
+	}
+	if c.Username == "" && c.Password != "" {
+		return errors.New("password needs a username")
+	}
+	_, err := expandSecrets(c.Password, secrets)
+	return err
+}
+
+// broker returns the broker's address and whether it is reached over TLS.
+func (c MQTTConfig) broker() (string, bool, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "mqtt" && u.Scheme != "mqtts") {
+		return "", false, errors.New("url must look like mqtt://host:1883 or mqtts://host:8883")
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"mqtt": "1883", "mqtts": "8883"}[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port), u.Scheme == "mqtts", nil
+}
+
+// checkTopic reports whether t is a valid topic name or, with wildcards,
+// topic filter: "+" must be a whole level, and "#" the whole last one.
+func checkTopic(t string, wildcards bool) error {
+	if t == "" || len(t) > 65535 || !utf8.ValidString(t) || strings.ContainsRune(t, 0) {
+		return fmt.Errorf("invalid topic %q", t)
+	}
+	levels := strings.Split(t, "/")
+	for i, level := range levels {
+		if !strings.ContainsAny(level, "+#") {
+			continue
+		}
+		if !wildcards {
+			return fmt.Errorf("topic %q has wildcards", t)
+		}
+		if level != "+" && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("invalid wildcard in topic filter %q", t)
+		}
+	}
+	return nil
+}
+
+// topicMatches reports whether topic matches filter. Wildcards at the
+// first level do not match topics starting with "$", like $SYS.
+func topicMatches(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func matchTopics(filters []string, topic string) bool {
+	for _, f := range filters {
+		if topicMatches(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// topicURI is the resource URI of a topic; each level is escaped.
+func topicURI(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		levels[i] = url.PathEscape(level)
+	}
+	return mqttURIPrefix + strings.Join(levels, "/")
+}
+
+// uriTopic is the topic of a resource URI made by topicURI.
+func uriTopic(uri string) (string, bool) {
+	rest, ok := strings.CutPrefix(uri, mqttURIPrefix)
+	if !ok {
+		return "", false
+	}
+	levels := strings.Split(rest, "/")
+	for i, level := range levels {
+		l, err := url.PathUnescape(level)
+		if err != nil {
+			return "", false
+		}
+		levels[i] = l
+	}
+	topic := strings.Join(levels, "/")
+	return topic, checkTopic(topic, false) == nil
+}
+
+// mqttMessage is the last message received on a topic.
+type mqttMessage struct {
+	payload   []byte
+	truncated bool
+	retained  bool
+	received  time.Time
+}
+
+// mqttBridge keeps one connection to the broker while the server runs,
+// redialling with backoff when it drops. It is shared by all generations,
+// so changes to the mqtt config take effect after a restart.
+type mqttBridge struct {
+	cfg       MQTTConfig
+	addr      string
+	password  string
+	clientID  string
+	tls       *tls.Config // nil for plain TCP
+	keepAlive time.Duration
+	timeout   time.Duration
+	metrics   *metricsRegistry
+	notifier  *notifier
+
+	wmu sync.Mutex // serializes writes to conn
+
+	mu       sync.Mutex
+	conn     net.Conn // nil while disconnected
+	packetID uint16
+	acks     map[uint16]chan error  // QoS 1 publishes awaiting PUBACK
+	messages map[string]mqttMessage // by topic
+	watchers map[string]map[string]bool
+}
+
+// newMQTTBridge prepares a bridge for s's mqtt config; run connects it.
+func (s *MCPServer) newMQTTBridge() (*mqttBridge, error) {
+	cfg := s.cfg.MQTT
+	addr, useTLS, err := cfg.broker()
+	if err != nil {
+		return nil, err
+	}
+	password, err := expandSecrets(cfg.Password, s.secrets)
+	if err != nil {
+		return nil, err
+	}
+	b := &mqttBridge{
+		cfg: cfg, addr: addr, password: password, clientID: cfg.ClientID,
+		keepAlive: orDefault(cfg.KeepAlive, defaultMQTTKeepAlive),
+		timeout:   orDefault(cfg.Timeout, defaultMQTTTimeout),
+		metrics:   s.metrics, notifier: s.notifier,
+		acks:     map[uint16]chan error{},
+		messages: map[string]mqttMessage{},
+		watchers: map[string]map[string]bool{},
+	}
+	if b.clientID == "" {
+		b.clientID = "mcp-server-" + randomID()[:12]
+	}
+	if useTLS {
+		if b.tls, err = s.cfg.Egress.TLS.clientConfig(nil); err != nil {
+			return nil, err
+		}
+		b.tls.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	s.sessions.onEnd(func(sess *Session) { b.unwatchAll(sess.ID) })
+	return b, nil
+}
+
+// run keeps the bridge connected until ctx is cancelled.
+func (b *mqttBridge) run(ctx context.Context) {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxMQTTRetryDelay {
+			delay = time.Second
+		}
+		log.Printf("mqtt: %v; reconnecting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxMQTTRetryDelay)
+	}
+}
+
+// session connects, subscribes and handles packets until the connection
+// fails or ctx is cancelled.
+func (b *mqttBridge) session(ctx context.Context) error {
+	d := net.Dialer{Timeout: b.timeout}
+	conn, err := d.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return err
+	}
+	if b.tls != nil {
+		tc := tls.Client(conn, b.tls)
+		hctx, cancel := context.WithTimeout(ctx, b.timeout)
+		err := tc.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tc
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if err := b.handshake(conn, r); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+	b.metrics.set(mqttConnectedMetric, 1)
+	log.Printf("mqtt: connected to %s", b.addr)
+	defer b.disconnected()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			b.write(conn, mqttPacket(mqttDisconnect<<4, nil))
+			conn.Close()
+		case <-done:
+		}
+	}()
+	go b.ping(conn, done)
+
+	subID := uint16(0)
+	if len(b.cfg.Subscribe) > 0 {
+		// QoS 0: messages are state to read, not commands to act on once.
+		var body []byte
+		subID = b.nextPacketID()
+		body = binary.BigEndian.AppendUint16(body, subID)
+		for _, f := range b.cfg.Subscribe {
+			body = append(appendMQTTString(body, f), 0)
+		}
+		if err := b.write(conn, mqttPacket(mqttSubscribe<<4|0x02, body)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		// The broker answers every PINGREQ, so silence means a dead link.
+		conn.SetReadDeadline(time.Now().Add(b.keepAlive * 3 / 2))
+		header, body, truncated, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			if err := b.received(conn, header, body, truncated); err != nil {
+				return err
+			}
+		case mqttPuback:
+			if len(body) == 2 {
+				b.acked(binary.BigEndian.Uint16(body), nil)
+			}
+		case mqttSuback:
+			if len(body) < 2 || binary.BigEndian.Uint16(body) != subID {
+				continue
+			}
+			for i, code := range body[2:] {
+				if code == 0x80 && i < len(b.cfg.Subscribe) {
+					log.Printf("mqtt: broker refused subscription to %s", b.cfg.Subscribe[i])
+				}
+			}
+		case mqttPingresp:
+		default:
+			return fmt.Errorf("unexpected packet type %d", header>>4)
+		}
+	}
+}
+
+// handshake sends CONNECT and waits for the broker's CONNACK.
+func (b *mqttBridge) handshake(conn net.Conn, r *bufio.Reader) error {
+	flags := byte(0x02) // clean session
+	if b.cfg.Username != "" {
+		flags |= 0x80
+		if b.cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(b.keepAlive/time.Second))
+	body = appendMQTTString(body, b.clientID)
+	if flags&0x80 != 0 {
+		body = appendMQTTString(body, b.cfg.Username)
+	}
+	if flags&0x40 != 0 {
+		body = appendMQTTString(body, b.password)
+	}
+	conn.SetDeadline(time.Now().Add(b.timeout))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := conn.Write(mqttPacket(mqttConnect<<4, body)); err != nil {
+		return err
+	}
+	header, ack, _, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if header>>4 != mqttConnack || len(ack) != 2 {
+		return errors.New("expected CONNACK")
+	}
+	if code := int(ack[1]); code != 0 {
+		if code < len(mqttRefusals) {
+			return fmt.Errorf("broker refused connection: %s", mqttRefusals[code])
+		}
+		return fmt.Errorf("broker refused connection with code %d", code)
+	}
+	return nil
+}
+
+// ping sends PINGREQ every keep-alive interval until done is closed.
+func (b *mqttBridge) ping(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(b.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if b.write(conn, mqttPacket(mqttPingreq<<4, nil)) != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// disconnected forgets the connection and fails publishes awaiting an
+// acknowledgement.
+func (b *mqttBridge) disconnected() {
+	b.mu.Lock()
+	b.conn = nil
+	for id, ch := range b.acks {
+		ch <- errors.New("mqtt: connection lost before the broker acknowledged the message")
+		delete(b.acks, id)
+	}
+	b.mu.Unlock()
+	b.metrics.set(mqttConnectedMetric, 0)
+}
+
+func (b *mqttBridge) write(conn net.Conn, packet []byte) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(b.timeout))
+	_, err := conn.Write(packet)
+	return err
+}
+
+// nextPacketID returns an identifier no publish is waiting on.
+func (b *mqttBridge) nextPacketID() uint16 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextPacketIDLocked()
+}
+
+func (b *mqttBridge) nextPacketIDLocked() uint16 {
+	for {
+		b.packetID++
+		if _, busy := b.acks[b.packetID]; b.packetID != 0 && !busy {
+			return b.packetID
+		}
+	}
+}
+
+func (b *mqttBridge) acked(id uint16, err error) {
+	b.mu.Lock()
+	if ch, ok := b.acks[id]; ok {
+		ch <- err
+		delete(b.acks, id)
+	}
+	b.mu.Unlock()
+}
+
+// received records a PUBLISH from the broker and notifies the sessions
+// watching its topic.
+func (b *mqttBridge) received(conn net.Conn, header byte, body []byte, truncated bool) error {
+	qos := header >> 1 & 3
+	topic, rest, ok := readMQTTString(body)
+	if !ok || (qos > 0 && len(rest) < 2) {
+		return errors.New("malformed PUBLISH")
+	}
+	if qos > 0 {
+		if qos == 1 {
+			if err := b.write(conn, mqttPacket(mqttPuback<<4, rest[:2])); err != nil {
+				return err
+			}
+		}
+		rest = rest[2:]
+	}
+	b.metrics.inc(mqttMessagesMetric, "direction", "in")
+	if !matchTopics(b.cfg.Subscribe, topic) {
+		return nil
+	}
+	msg := mqttMessage{retained: header&1 != 0, received: time.Now().UTC(), truncated: truncated}
+	if len(rest) > maxMQTTPayload {
+		rest, msg.truncated = rest[:maxMQTTPayload], true
+	}
+	msg.payload = append([]byte(nil), rest...)
+
+	b.mu.Lock()
+	if _, ok := b.messages[topic]; !ok && len(b.messages) >= maxMQTTTopics {
+		b.mu.Unlock()
+		return nil
+	}
+	b.messages[topic] = msg
+	sessions := make([]string, 0, len(b.watchers[topic]))
+	for id := range b.watchers[topic] {
+		sessions = append(sessions, id)
+	}
+	b.mu.Unlock()
+	for _, id := range sessions {
+		b.notifier.send(id, "notifications/resources/updated", map[string]interface{}{"uri": topicURI(topic)})
+	}
+	return nil
+}
+
+// publish sends payload to topic, waiting at QoS 1 for the broker to
+// acknowledge it.
+func (b *mqttBridge) publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	b.mu.Lock()
+	conn := b.conn
+	if conn == nil {
+		b.mu.Unlock()
+		return errors.New("mqtt: not connected to the broker")
+	}
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 1
+	}
+	body := appendMQTTString(nil, topic)
+	var id uint16
+	var ack chan error
+	if qos == 1 {
+		id, ack = b.nextPacketIDLocked(), make(chan error, 1)
+		b.acks[id] = ack
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	b.mu.Unlock()
+
+	if err := b.write(conn, mqttPacket(header, append(body, payload...))); err != nil {
+		conn.Close()
+		return err
+	}
+	b.metrics.inc(mqttMessagesMetric, "direction", "out")
+	if ack == nil {
+		return nil
+	}
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		b.acked(id, nil)
+		return ctx.Err()
+	case <-timer.C:
+		b.acked(id, nil)
+		return errors.New("mqtt: the broker did not acknowledge the message")
+	}
+}
+
+// mqttPacket frames body as a control packet with the fixed header byte.
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		p = append(p, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, bool) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, false
+	}
+	n := 2 + int(binary.BigEndian.Uint16(b))
+	return string(b[2:n]), b[n:], true
+}
+
+// readMQTTPacket reads a control packet. Bodies longer than a topic and
+// maxMQTTPayload are cut there, reporting truncated, and the rest skipped.
+func readMQTTPacket(r *bufio.Reader) (header byte, body []byte, truncated bool, err error) {
+	if header, err = r.ReadByte(); err != nil {
+		return 0, nil, false, err
+	}
+	n := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, false, errors.New("mqtt: malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, false, err
+		}
+		n |= int(digit&0x7f) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	const maxBody = 2 + 65535 + 2 + maxMQTTPayload
+	body = make([]byte, min(n, maxBody))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, false, err
+	}
+	if n > maxBody {
+		if _, err := r.Discard(n - maxBody); err != nil {
+			return 0, nil, false, err
+		}
+		truncated = true
+	}
+	return header, body, truncated, nil
+}
+
+// watch sends the session notifications of messages on topic.
+func (b *mqttBridge) watch(sessionID, topic string) {
+	b.mu.Lock()
+	if b.watchers[topic] == nil {
+		b.watchers[topic] = map[string]bool{}
+	}
+	b.watchers[topic][sessionID] = true
+	b.mu.Unlock()
+}
+
+func (b *mqttBridge) unwatch(sessionID, topic string) {
+	b.mu.Lock()
+	delete(b.watchers[topic], sessionID)
+	if len(b.watchers[topic]) == 0 {
+		delete(b.watchers, topic)
+	}
+	b.mu.Unlock()
+}
+
+func (b *mqttBridge) unwatchAll(sessionID string) {
+	b.mu.Lock()
+	for topic, ids := range b.watchers {
+		delete(ids, sessionID)
+		if len(ids) == 0 {
+			delete(b.watchers, topic)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// resources lists the topics with a message, sorted.
+func (b *mqttBridge) resources() []map[string]interface{} {
+	b.mu.Lock()
+	topics := make([]string, 0, len(b.messages))
+	received := make(map[string]time.Time, len(b.messages))
+	for topic, msg := range b.messages {
+		topics = append(topics, topic)
+		received[topic] = msg.received
+	}
+	b.mu.Unlock()
+	sort.Strings(topics)
+	out := make([]map[string]interface{}, len(topics))
+	for i, topic := range topics {
+		out[i] = map[string]interface{}{
+			"uri":         topicURI(topic),
+			"name":        topic,
+			"description": fmt.Sprintf("Last MQTT message on %s, received %s", topic, received[topic].Format(time.RFC3339)),
+		}
+	}
+	return out
+}
+
+// read returns the content of a topic's last message: JSON or text as
+// text, anything else base64-encoded.
+func (b *mqttBridge) read(topic string) (map[string]interface{}, bool) {
+	b.mu.Lock()
+	msg, ok := b.messages[topic]
+	b.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	content := map[string]interface{}{
+		"uri": topicURI(topic),
+		"_meta": map[string]interface{}{
+			"receivedAt": msg.received.Format(time.RFC3339Nano),
+			"retained":   msg.retained,
+			"truncated":  msg.truncated,
+		},
+	}
+	switch {
+	case !msg.truncated && json.Valid(msg.payload):
+		content["mimeType"], content["text"] = "application/json", string(msg.payload)
+	case utf8.Valid(msg.payload):
+		content["mimeType"], content["text"] = "text/plain", string(msg.payload)
+	default:
+		content["mimeType"], content["blob"] = "application/octet-stream", base64.StdEncoding.EncodeToString(msg.payload)
+	}
+	return content, true
+}
+
+// resourceCapabilities are the resources capabilities of initialize:
+// only MQTT topics change by themselves, so only they can be subscribed to.
+func (s *MCPServer) resourceCapabilities() map[string]bool {
+	return map[string]bool{"subscribe": s.mqtt != nil}
+}
+
+// handleResourceSubscription serves resources/subscribe and
+// resources/unsubscribe for MQTT topics. Notifications go to the
+// caller's session until it unsubscribes or ends.
+func (s *MCPServer) handleResourceSubscription(method string, raw json.RawMessage, caller *Caller, strict bool) (interface{}, *JSONRPCError) {
+	var params struct {
+		URI  string          `json:"uri"`
+		Meta json.RawMessage `json:"_meta"`
+	}
+	if rpcErr := decodeParams(raw, &params, strict); rpcErr != nil {
+		return nil, rpcErr
+	}
+	if params.URI == "" {
+		return nil, invalidParams("uri is required")
+	}
+	if caller.Session == nil {
+		return nil, invalidParams("subscriptions need a session; send initialize first")
+	}
+	topic, ok := uriTopic(params.URI)
+	if !ok || s.mqtt == nil || !matchTopics(s.mqtt.cfg.Subscribe, topic) {
+		return nil, &JSONRPCError{Code: codeResourceNotFound, Message: "Resource not found", Data: map[string]string{"uri": params.URI}}
+	}
+	if method == "resources/subscribe" {
+		s.mqtt.watch(caller.Session.ID, topic)
+	} else {
+		s.mqtt.unwatch(caller.Session.ID, topic)
+	}
+	return map[string]interface{}{}, nil
+}
+
+type mqttPublishArgs struct {
+	Topic   string `json:"topic" jsonschema:"required,description=Topic to publish to; it must match a configured publish filter"`
+	Payload string `json:"payload" jsonschema:"description=Message body\\, such as JSON or a plain value like ON"`
+	QoS     int    `json:"qos,omitempty" jsonschema:"minimum=0,maximum=1,description=0 to send once without confirmation (default) or 1 to wait for the broker's acknowledgement"`
+	Retain  bool   `json:"retain,omitempty" jsonschema:"description=Have the broker keep the message for future subscribers to the topic"`
+}
+
+func (s *MCPServer) setupMQTTTools() {
+	if len(s.cfg.MQTT.Publish) == 0 {
+		return
+	}
+	openWorld, destructive := true, false
+	publish, handler, _ := typedTool("mqtt_publish", "Publish a message to an MQTT topic, such as a command to a device", s.mqttPublishTool)
+	publish.Annotations = &ToolAnnotations{DestructiveHint: &destructive, OpenWorldHint: &openWorld}
+	s.addTool(publish, handler)
+}
+
+func (s *MCPServer) mqttPublishTool(ctx context.Context, args mqttPublishArgs) (interface{}, error) {
+	if s.mqtt == nil {
+		return nil, errors.New("mqtt: the bridge is not running; mqtt config changes take effect after a restart")
+	}
+	if err := checkTopic(args.Topic, false); err != nil {
+		return nil, err
+	}
+	if !matchTopics(s.mqtt.cfg.Publish, args.Topic) {
+		return nil, fmt.Errorf("topic %s matches no publish filter", args.Topic)
+	}
+	if args.QoS != 0 && args.QoS != 1 {
+		return nil, errors.New("qos must be 0 or 1")
+	}
+	if err := s.mqtt.publish(ctx, args.Topic, []byte(args.Payload), byte(args.QoS), args.Retain); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"topic": args.Topic, "bytes": len(args.Payload), "qos": args.QoS, "retained": args.Retain}, nil
+}
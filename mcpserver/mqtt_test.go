@@ -0,0 +1,229 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMQTTRemainingLength checks the variable length encoding against
+// the boundaries in MQTT 3.1.1 section 2.2.3.
+func TestMQTTRemainingLength(t *testing.T) {
+	const maxBody = 2 + 65535 + 2 + maxMQTTPayload
+	for n, enc := range map[int]string{
+		0:         "00",
+		127:       "7f",
+		128:       "8001",
+		16383:     "ff7f",
+		16384:     "808001",
+		2097151:   "ffff7f",
+		2097152:   "80808001",
+		268435455: "ffffff7f",
+	} {
+		if n <= 2097152 {
+			p := mqttPacket(0x30, make([]byte, n))
+			if got := hex.EncodeToString(p[1 : len(p)-n]); got != enc {
+				t.Errorf("length %d encoded as %s, want %s", n, got, enc)
+			}
+			header, body, truncated, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(p)))
+			if err != nil || header != 0x30 {
+				t.Fatalf("length %d: header %#x, %v", n, header, err)
+			}
+			if truncated != (n > maxBody) || len(body) != min(n, maxBody) {
+				t.Errorf("length %d read as %d bytes, truncated %v", n, len(body), truncated)
+			}
+		}
+	}
+	// A fifth length byte is malformed.
+	if _, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}))); err == nil {
+		t.Error("five length bytes accepted")
+	}
+	if _, _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 1, 2}))); err == nil {
+		t.Error("short body accepted")
+	}
+}
+
+func TestMQTTTopics(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"sport/tennis/player1/#", "sport/tennis/player1", true},
+		{"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+		{"sport/#", "sport", true},
+		{"sport/tennis/+", "sport/tennis/player1", true},
+		{"sport/tennis/+", "sport/tennis/player1/ranking", false},
+		{"sport/+", "sport", false},
+		{"sport/+", "sport/", true},
+		{"+/+", "/finance", true},
+		{"/+", "/finance", true},
+		{"+", "/finance", false},
+		{"#", "$SYS/broker/uptime", false},
+		{"+/monitor/Clients", "$SYS/monitor/Clients", false},
+		{"$SYS/#", "$SYS/broker/uptime", true},
+	} {
+		if got := topicMatches(tc.filter, tc.topic); got != tc.match {
+			t.Errorf("topicMatches(%q, %q) = %v", tc.filter, tc.topic, got)
+		}
+	}
+	for f, ok := range map[string]bool{"a/+/b": true, "a/#": true, "#": true, "a/b#": false, "a/#/b": false, "a+": false, "": false} {
+		if err := checkTopic(f, true); (err == nil) != ok {
+			t.Errorf("checkTopic(%q): %v", f, err)
+		}
+	}
+	if checkTopic("a/+", false) == nil {
+		t.Error("wildcard accepted in a topic name")
+	}
+	for _, topic := range []string{"home/living room/temp", "a/%/b", "/x"} {
+		if got, ok := uriTopic(topicURI(topic)); !ok || got != topic {
+			t.Errorf("topic %q round-trips as %q", topic, got)
+		}
+	}
+}
+
+// fakeMQTTBroker accepts one client and runs script with its connection.
+func fakeMQTTBroker(t *testing.T, script func(r *bufio.Reader, conn net.Conn) error) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- script(bufio.NewReader(conn), conn)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+// expectMQTT reads a packet and compares it with the hex of a fixture.
+func expectMQTT(r *bufio.Reader, what, want string) error {
+	header, body, _, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	got := hex.EncodeToString(mqttPacket(header, body))
+	if got != strings.ReplaceAll(want, " ", "") {
+		return fmt.Errorf("%s\n got %s\nwant %s", what, got, want)
+	}
+	return nil
+}
+
+func TestMQTTBridge(t *testing.T) {
+	received := make(chan struct{})
+	addr := fakeMQTTBroker(t, func(r *bufio.Reader, conn net.Conn) error {
+		// CONNECT: MQTT 3.1.1, clean session, username and password,
+		// keep-alive 30s, client ID "c1".
+		if err := expectMQTT(r, "CONNECT", "10 19 0004 4d515454 04 c2 001e 0002 6331 0005 616c696365 0002 7077"); err != nil {
+			return err
+		}
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		// SUBSCRIBE, packet 1, to home/# at QoS 0.
+		if err := expectMQTT(r, "SUBSCRIBE", "82 0b 0001 0006 686f6d652f23 00"); err != nil {
+			return err
+		}
+		conn.Write([]byte{0x90, 0x03, 0x00, 0x01, 0x00})
+
+		// A QoS 1 message, packet 7, which the client acknowledges.
+		pub, _ := hex.DecodeString("3215" + "0009686f6d652f74656d70" + "0007" + "7b2263223a32317d")
+		conn.Write(pub)
+		if err := expectMQTT(r, "PUBACK", "40 02 0007"); err != nil {
+			return err
+		}
+		close(received)
+
+		// The tool's QoS 1 publish, retained, as packet 2.
+		if err := expectMQTT(r, "PUBLISH", "33 0f 0009 686f6d652f6c616d70 0002 4f4e"); err != nil {
+			return err
+		}
+		conn.Write([]byte{0x40, 0x02, 0x00, 0x02})
+		// And a QoS 0 one, with no packet ID.
+		if err := expectMQTT(r, "PUBLISH", "30 0e 0009 686f6d652f6c616d70 4f4646"); err != nil {
+			return err
+		}
+		return expectMQTT(r, "DISCONNECT", "e0 00")
+	})
+
+	cfg := &Config{MQTT: MQTTConfig{URL: "mqtt://" + addr, ClientID: "c1", Username: "alice", Password: "pw",
+		Subscribe: []string{"home/#"}, Publish: []string{"home/lamp"}}}
+	s, err := newConfiguredServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.mqtt, err = s.newMQTTBridge(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.mqtt.run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	// The PUBACK went out after the message was stored.
+	content, ok := s.mqtt.read("home/temp")
+	if !ok || content["text"] != `{"c":21}` || content["mimeType"] != "application/json" {
+		t.Errorf("resource %v", content)
+	}
+
+	res, err := s.mqttPublishTool(ctx, mqttPublishArgs{Topic: "home/lamp", Payload: "ON", QoS: 1, Retain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.(map[string]interface{})["retained"] != true {
+		t.Errorf("publish result %v", res)
+	}
+	if _, err := s.mqttPublishTool(ctx, mqttPublishArgs{Topic: "home/lamp", Payload: "OFF"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.mqttPublishTool(ctx, mqttPublishArgs{Topic: "home/door", Payload: "OPEN"}); err == nil {
+		t.Error("published to a topic no filter allows")
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	addr := fakeMQTTBroker(t, func(r *bufio.Reader, conn net.Conn) error {
+		if _, _, _, err := readMQTTPacket(r); err != nil {
+			return err
+		}
+		_, err := conn.Write([]byte{0x20, 0x02, 0x00, 0x05})
+		return err
+	})
+	s, err := newConfiguredServer(&Config{MQTT: MQTTConfig{URL: "mqtt://" + addr, Subscribe: []string{"#"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.newMQTTBridge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.session(context.Background()); err == nil || err.Error() != "broker refused connection: not authorized" {
+		t.Errorf("session: %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NATSConfig publishes audit events to NATS on Subject + "." + the event
+// type, like "mcp.audit.tool.completed". With JetStream every message must
+// be stored by a stream covering those subjects before a batch counts as
+// sent, and carries its event ID as Nats-Msg-Id so the stream drops
+// resent duplicates; core NATS only confirms the server received it. A
+// tls:// URL, or a server requiring TLS, upgrades the connection.
+type NATSConfig struct {
+	URL       string   `json:"url" schema:"required"` // nats://host:4222
+	Subject   string   `json:"subject,omitempty"`     // default "mcp.audit"
+	JetStream bool     `json:"jetstream,omitempty"`
+	User      string   `json:"user,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	Timeout   Duration `json:"timeout,omitempty" schema:"format=duration"` // default 10s
+}
+
+const (
+	defaultNATSSubject = "mcp.audit"
+	defaultNATSTimeout = 10 * time.Second
+)
+
+// natsSink publishes over a single connection, redialled after any
+// failure. It is used by one goroutine at a time.
+type natsSink struct {
+	cfg     NATSConfig
+	url     *url.URL
+	subject string
+	timeout time.Duration
+
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	headers bool   // server supports HPUB
+	inbox   string // JetStream ack subject prefix
+}
+
+func newNATSSink(cfg NATSConfig) (*natsSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "nats" && u.Scheme != "tls") {
+		return nil, fmt.Errorf("nats: url must look like nats://host:4222 or tls://host:4222")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := strings.TrimSuffix(cfg.Subject, ".")
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultNATSTimeout
+	}
+	return &natsSink{cfg: cfg, url: u, subject: subject, timeout: timeout}, nil
+}
+
+func (n *natsSink) name() string { return "nats" }
+
+func (n *natsSink) send(ctx context.Context, batch []auditRecord) error {
+	err := n.publish(ctx, batch)
+	if err != nil {
+		n.close()
+	}
+	return err
+}
+
+func (n *natsSink) publish(ctx context.Context, batch []auditRecord) error {
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	n.conn.SetDeadline(deadline)
+
+	for i, rec := range batch {
+		subject := n.subject + "." + rec.Type
+		reply := ""
+		if n.cfg.JetStream {
+			reply = n.inbox + strconv.Itoa(i)
+		}
+		if n.headers {
+			hdr := "NATS/1.0\r\nNats-Msg-Id: " + rec.Key + "\r\n\r\n"
+			fmt.Fprintf(n.w, "HPUB %s %s %d %d\r\n%s", subject, reply, len(hdr), len(hdr)+len(rec.Value), hdr)
+		} else {
+			fmt.Fprintf(n.w, "PUB %s %s %d\r\n", subject, reply, len(rec.Value))
+		}
+		n.w.Write(rec.Value)
+		n.w.WriteString("\r\n")
+	}
+	if !n.cfg.JetStream {
+		n.w.WriteString("PING\r\n")
+	}
+	if err := n.w.Flush(); err != nil {
+		return err
+	}
+
+	if !n.cfg.JetStream {
+		return n.awaitPong()
+	}
+	for acked := 0; acked < len(batch); {
+		_, payload, status, err := n.readMsg()
+		if err != nil {
+			return err
+		}
+		if status == "503" {
+			return fmt.Errorf("nats: no JetStream stream for subject %s.>", n.subject)
+		}
+		var ack struct {
+			Error *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return fmt.Errorf("nats: bad JetStream ack: %w", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("nats: JetStream error %d: %s", ack.Error.Code, ack.Error.Description)
+		}
+		acked++
+	}
+	return nil
+}
+
+// connect dials the server and completes the handshake.
+func (n *natsSink) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: n.timeout}
+	conn, err := d.DialContext(ctx, "tcp", n.url.Host)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	n.conn, n.r, n.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+
+	line, err := n.readLine()
+	if err != nil {
+		n.close()
+		return err
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+		Headers     bool `json:"headers"`
+	}
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[5:]), &info) != nil {
+		n.close()
+		return fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+	if n.url.Scheme == "tls" || info.TLSRequired {
+		tconn := tls.Client(conn, &tls.Config{ServerName: n.url.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tconn.HandshakeContext(ctx); err != nil {
+			n.close()
+			return fmt.Errorf("nats: %w", err)
+		}
+		n.conn, n.r, n.w = tconn, bufio.NewReader(tconn), bufio.NewWriter(tconn)
+	}
+	n.headers = info.Headers
+
+	opts := map[string]interface{}{
+		"verbose": false, "pedantic": false, "lang": "go", "name": "mcp-server",
+		"protocol": 1, "headers": info.Headers, "no_responders": info.Headers,
+		"tls_required": n.url.Scheme == "tls" || info.TLSRequired,
+	}
+	user, pass := n.cfg.User, n.cfg.Password
+	if u := n.url.User; u != nil && user == "" {
+		user = u.Username()
+		pass, _ = u.Password()
+	}
+	if user != "" {
+		opts["user"], opts["pass"] = user, pass
+	}
+	if n.cfg.Token != "" {
+		opts["auth_token"] = n.cfg.Token
+	}
+	connect, _ := json.Marshal(opts)
+	fmt.Fprintf(n.w, "CONNECT %s\r\nPING\r\n", connect)
+	if n.cfg.JetStream {
+		n.inbox = "_INBOX." + randomID() + "."
+		fmt.Fprintf(n.w, "SUB %s* 1\r\n", n.inbox)
+	}
+	if err := n.w.Flush(); err != nil {
+		n.close()
+		return err
+	}
+	if err := n.awaitPong(); err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+// awaitPong reads until the server answers a PING.
+func (n *natsSink) awaitPong() error {
+	for {
+		line, err := n.readControl()
+		if err != nil {
+			return err
+		}
+		if line == "PONG" {
+			return nil
+		}
+	}
+}
+
+// readMsg reads the next message, returning its subject, payload and, for
+// a message with headers, the status code from the header line.
+func (n *natsSink) readMsg() (subject string, payload []byte, status string, err error) {
+	line, err := n.readControl()
+	if err != nil {
+		return "", nil, "", err
+	}
+	f := strings.Fields(line)
+	var hdrLen, total int
+	switch {
+	case f[0] == "MSG" && (len(f) == 4 || len(f) == 5):
+		total, err = strconv.Atoi(f[len(f)-1])
+	case f[0] == "HMSG" && (len(f) == 5 || len(f) == 6):
+		if hdrLen, err = strconv.Atoi(f[len(f)-2]); err == nil {
+			total, err = strconv.Atoi(f[len(f)-1])
+		}
+	default:
+		return "", nil, "", fmt.Errorf("nats: unexpected %q", line)
+	}
+	if err != nil || hdrLen > total {
+		return "", nil, "", fmt.Errorf("nats: bad message line %q", line)
+	}
+	buf := make([]byte, total+2)
+	if _, err := io.ReadFull(n.r, buf); err != nil {
+		return "", nil, "", err
+	}
+	if hdrLen > 0 {
+		// The header block starts "NATS/1.0" or "NATS/1.0 503".
+		first := strings.SplitN(string(buf[:hdrLen]), "\r\n", 2)[0]
+		status = strings.TrimSpace(strings.TrimPrefix(first, "NATS/1.0"))
+		if i := strings.IndexByte(status, ' '); i >= 0 {
+			status = status[:i]
+		}
+	}
+	return f[1], buf[hdrLen:total], status, nil
+}
+
+// readControl reads the next line that is not handled here: it answers
+// PINGs, skips +OK and INFO updates and turns -ERR into an error.
+func (n *natsSink) readControl() (string, error) {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case line == "PING":
+			n.w.WriteString("PONG\r\n")
+			if err := n.w.Flush(); err != nil {
+				return "", err
+			}
+		case line == "+OK", strings.HasPrefix(line, "INFO "), line == "":
+		case strings.HasPrefix(line, "-ERR"):
+			return "", fmt.Errorf("nats: %s", strings.Trim(strings.TrimSpace(line[4:]), "'"))
+		default:
+			return line, nil
+		}
+	}
+}
+
+func (n *natsSink) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *natsSink) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+}
@@ -0,0 +1,238 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNATSReadMsg(t *testing.T) {
+	for _, tc := range []struct {
+		name, in                         string
+		subject, payload, status, errMsg string
+	}{
+		{name: "msg", in: "MSG audit.ack 1 5\r\nhello\r\n", subject: "audit.ack", payload: "hello"},
+		{name: "msg with reply", in: "MSG a 1 _INBOX.x 2\r\nhi\r\n", subject: "a", payload: "hi"},
+		{name: "after ping and ok", in: "PING\r\n+OK\r\nMSG a 1 0\r\n\r\n", subject: "a"},
+		{name: "hmsg", in: "HMSG a 1 12 14\r\nNATS/1.0\r\n\r\nok\r\n", subject: "a", payload: "ok"},
+		{name: "no responders", in: "HMSG _INBOX.x.0 1 16 16\r\nNATS/1.0 503\r\n\r\n\r\n", subject: "_INBOX.x.0", status: "503"},
+		{name: "status with text", in: "HMSG a 1 28 28\r\nNATS/1.0 408 Request Timeout\r\n\r\n", subject: "a", status: "408"},
+		{name: "server error", in: "-ERR 'Authorization Violation'\r\n", errMsg: "nats: Authorization Violation"},
+		{name: "unknown", in: "FOO bar\r\n", errMsg: `nats: unexpected "FOO bar"`},
+		{name: "bad size", in: "MSG a 1 x\r\n", errMsg: `nats: bad message line "MSG a 1 x"`},
+		{name: "header longer than message", in: "HMSG a 1 9 4\r\n", errMsg: `nats: bad message line "HMSG a 1 9 4"`},
+		{name: "short payload", in: "MSG a 1 10\r\nabc", errMsg: "unexpected EOF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			n := &natsSink{r: bufio.NewReader(strings.NewReader(tc.in)), w: bufio.NewWriter(&out)}
+			subject, payload, status, err := n.readMsg()
+			if tc.errMsg != "" {
+				if err == nil || err.Error() != tc.errMsg {
+					t.Fatalf("err = %v, want %s", err, tc.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tc.subject || string(payload) != tc.payload || status != tc.status {
+				t.Errorf("got %q %q %q", subject, payload, status)
+			}
+			if strings.HasPrefix(tc.in, "PING") && out.String() != "PONG\r\n" {
+				t.Errorf("answered PING with %q", out.String())
+			}
+		})
+	}
+}
+
+// fakeNATS accepts one connection, greets it with info and hands it to
+// script, whose error fails the test.
+func fakeNATS(t *testing.T, info string, script func(r *bufio.Reader, w io.Writer) error) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO %s\r\n", info)
+		done <- script(bufio.NewReader(conn), conn)
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return "nats://" + ln.Addr().String()
+}
+
+// natsExpect reads the next line and checks it starts with prefix.
+func natsExpect(r *bufio.Reader, prefix string) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("got %q, want %s...", line, prefix)
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// natsHandshake reads CONNECT and PING and answers PONG, returning the
+// CONNECT options.
+func natsHandshake(r *bufio.Reader, w io.Writer) (map[string]interface{}, error) {
+	line, err := natsExpect(r, "CONNECT ")
+	if err != nil {
+		return nil, err
+	}
+	var opts map[string]interface{}
+	if err := json.Unmarshal([]byte(line[8:]), &opts); err != nil {
+		return nil, err
+	}
+	if _, err := natsExpect(r, "PING"); err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(w, "PONG\r\n")
+	return opts, err
+}
+
+var natsTestBatch = []auditRecord{
+	{Key: "e1", Type: "tool.called", Value: []byte(`{"a":1}`)},
+	{Key: "e2", Type: "tool.completed", Value: []byte(`{"a":2}`)},
+}
+
+func TestNATSPublish(t *testing.T) {
+	url := fakeNATS(t, `{"server_id":"test","headers":true}`, func(r *bufio.Reader, w io.Writer) error {
+		opts, err := natsHandshake(r, w)
+		if err != nil {
+			return err
+		}
+		if opts["user"] != "svc" || opts["pass"] != "pw" || opts["headers"] != true {
+			return fmt.Errorf("CONNECT options %v", opts)
+		}
+		want := "HPUB mcp.audit.tool.called  29 36\r\nNATS/1.0\r\nNats-Msg-Id: e1\r\n\r\n{\"a\":1}\r\n" +
+			"HPUB mcp.audit.tool.completed  29 36\r\nNATS/1.0\r\nNats-Msg-Id: e2\r\n\r\n{\"a\":2}\r\n" +
+			"PING\r\n"
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			return err
+		}
+		if string(got) != want {
+			return fmt.Errorf("published\n%q\nwant\n%q", got, want)
+		}
+		_, err = io.WriteString(w, "+OK\r\nPONG\r\n")
+		return err
+	})
+	n, err := newNATSSink(NATSConfig{URL: strings.Replace(url, "nats://", "nats://svc:pw@", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.close()
+	if err := n.send(context.Background(), natsTestBatch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNATSPublishWithoutHeaders(t *testing.T) {
+	url := fakeNATS(t, `{"server_id":"test"}`, func(r *bufio.Reader, w io.Writer) error {
+		if _, err := natsHandshake(r, w); err != nil {
+			return err
+		}
+		want := "PUB mcp.audit.tool.called  7\r\n{\"a\":1}\r\nPUB mcp.audit.tool.completed  7\r\n{\"a\":2}\r\nPING\r\n"
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil {
+			return err
+		}
+		if string(got) != want {
+			return fmt.Errorf("published\n%q\nwant\n%q", got, want)
+		}
+		_, err := io.WriteString(w, "PONG\r\n")
+		return err
+	})
+	n, _ := newNATSSink(NATSConfig{URL: url})
+	defer n.close()
+	if err := n.send(context.Background(), natsTestBatch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNATSJetStream(t *testing.T) {
+	url := fakeNATS(t, `{"server_id":"test","headers":true}`, func(r *bufio.Reader, w io.Writer) error {
+		if _, err := natsHandshake(r, w); err != nil {
+			return err
+		}
+		sub, err := natsExpect(r, "SUB _INBOX.")
+		if err != nil {
+			return err
+		}
+		inbox := strings.TrimSuffix(strings.Fields(sub)[1], "*")
+
+		// First batch: both stored. Second: no stream, so the server
+		// answers with no responders.
+		for batch := 0; batch < 2; batch++ {
+			for i := range natsTestBatch {
+				line, err := natsExpect(r, "HPUB ")
+				if err != nil {
+					return err
+				}
+				f := strings.Fields(line)
+				if len(f) != 5 || f[2] != inbox+strconv.Itoa(i) {
+					return fmt.Errorf("publish %q does not ask for an ack", line)
+				}
+				size, _ := strconv.Atoi(f[4])
+				if _, err := io.ReadFull(r, make([]byte, size+2)); err != nil {
+					return err
+				}
+				if batch == 0 {
+					ack := fmt.Sprintf(`{"stream":"AUDIT","seq":%d}`, i+1)
+					fmt.Fprintf(w, "MSG %s 1 %d\r\n%s\r\n", f[2], len(ack), ack)
+				} else {
+					fmt.Fprintf(w, "HMSG %s 1 16 16\r\nNATS/1.0 503\r\n\r\n\r\n", f[2])
+				}
+			}
+		}
+		return nil
+	})
+	n, _ := newNATSSink(NATSConfig{URL: url, JetStream: true})
+	defer n.close()
+	if err := n.send(context.Background(), natsTestBatch); err != nil {
+		t.Fatal(err)
+	}
+	err := n.send(context.Background(), natsTestBatch)
+	if err == nil || err.Error() != "nats: no JetStream stream for subject mcp.audit.>" {
+		t.Errorf("send without a stream: %v", err)
+	}
+	if n.conn != nil {
+		t.Error("failed send kept its connection")
+	}
+}
+
+func TestNATSConnectRejected(t *testing.T) {
+	url := fakeNATS(t, `{"server_id":"test","auth_required":true}`, func(r *bufio.Reader, w io.Writer) error {
+		if _, err := natsExpect(r, "CONNECT "); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "-ERR 'Authorization Violation'\r\n")
+		return err
+	})
+	n, _ := newNATSSink(NATSConfig{URL: url, Token: "wrong"})
+	err := n.send(context.Background(), natsTestBatch)
+	if err == nil || err.Error() != "nats: Authorization Violation" {
+		t.Errorf("send: %v", err)
+	}
+}
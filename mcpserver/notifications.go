@@ -0,0 +1,146 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often an idle notification stream sends a comment to
+// keep proxies from closing it.
+const sseKeepAlive = 30 * time.Second
+
+// notifier fans out server-to-client JSON-RPC notifications to the event
+// streams sessions have open. Messages for sessions without a stream are
+// dropped.
+type notifier struct {
+	mu      sync.Mutex
+	streams map[string]map[chan []byte]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{streams: map[string]map[chan []byte]struct{}{}}
+}
+
+func (n *notifier) subscribe(sessionID string) (chan []byte, func()) {
+	ch := make(chan []byte, 64)
+	n.mu.Lock()
+	if n.streams[sessionID] == nil {
+		n.streams[sessionID] = map[chan []byte]struct{}{}
+	}
+	n.streams[sessionID][ch] = struct{}{}
+	n.mu.Unlock()
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.streams[sessionID], ch)
+		if len(n.streams[sessionID]) == 0 {
+			delete(n.streams, sessionID)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// send delivers a notification to one session. Slow streams lose messages
+// rather than block the sender.
+func (n *notifier) send(sessionID, method string, params interface{}) {
+	msg, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.streams[sessionID] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// request sends a JSON-RPC request to one session and reports whether a
+// stream took it.
+func (n *notifier) request(sessionID, id, method string, params interface{}) bool {
+	msg, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delivered := false
+	for ch := range n.streams[sessionID] {
+		select {
+		case ch <- msg:
+			delivered = true
+		default:
+		}
+	}
+	return delivered
+}
+
+// broadcast delivers a notification to every open stream.
+func (n *notifier) broadcast(method string, params interface{}) {
+	n.mu.Lock()
+	ids := make([]string, 0, len(n.streams))
+	for id := range n.streams {
+		ids = append(ids, id)
+	}
+	n.mu.Unlock()
+	for _, id := range ids {
+		n.send(id, method, params)
+	}
+}
+
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// handleEventStream serves a session's notifications as server-sent events
+// until the client disconnects.
+func (s *MCPServer) handleEventStream(w http.ResponseWriter, r *http.Request, caller *Caller) {
+	if caller.Session == nil {
+		http.Error(w, "Missing "+sessionHeader, http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// Streams outlive the server's read and write timeouts.
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	defer s.trackStream()()
+	ch, unsubscribe := s.notifier.subscribe(caller.Session.ID)
+	defer unsubscribe()
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case msg := <-ch:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+		}
+		flusher.Flush()
+	}
+}
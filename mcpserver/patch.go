@@ -0,0 +1,526 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type applyPatchArgs struct {
+	Root   string     `json:"root,omitempty" jsonschema:"description=Workspace root the paths are in"`
+	Patch  string     `json:"patch,omitempty" jsonschema:"description=Unified diff as produced by diff -u or git diff"`
+	Edits  []lineEdit `json:"edits,omitempty" jsonschema:"description=Line range replacements\\, used instead of patch"`
+	DryRun bool       `json:"dryRun,omitempty" jsonschema:"description=Check the changes and report them without writing"`
+}
+
+// lineEdit replaces lines StartLine through EndLine, 1-based and
+// inclusive. EndLine StartLine-1 inserts before StartLine; inserting at
+// line 1 of a missing file creates it.
+type lineEdit struct {
+	Path        string  `json:"path" jsonschema:"required,minLength=1,description=Root-relative file path"`
+	StartLine   int     `json:"startLine" jsonschema:"required,minimum=1,description=First line replaced"`
+	EndLine     int     `json:"endLine" jsonschema:"required,minimum=0,description=Last line replaced; startLine-1 to insert"`
+	Expected    *string `json:"expected,omitempty" jsonschema:"description=Current text of the lines\\, checked before editing"`
+	Replacement string  `json:"replacement" jsonschema:"description=New text for the lines"`
+}
+
+// textFile is a file as lines without their endings.
+type textFile struct {
+	lines        []string
+	eol          string
+	finalNewline bool
+	exists       bool
+	mode         fs.FileMode
+}
+
+func readTextFile(full string) (*textFile, error) {
+	data, err := os.ReadFile(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &textFile{eol: "\n", finalNewline: true, mode: 0o644}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, errors.New("binary file")
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, err
+	}
+	f := &textFile{exists: true, mode: info.Mode().Perm(), eol: "\n"}
+	if bytes.Contains(data, []byte("\r\n")) {
+		f.eol = "\r\n"
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	f.finalNewline = strings.HasSuffix(text, "\n") || text == ""
+	if text = strings.TrimSuffix(text, "\n"); text != "" {
+		f.lines = strings.Split(text, "\n")
+	}
+	return f, nil
+}
+
+func (f *textFile) bytes() []byte {
+	if len(f.lines) == 0 {
+		return nil
+	}
+	text := strings.Join(f.lines, f.eol)
+	if f.finalNewline {
+		text += f.eol
+	}
+	return []byte(text)
+}
+
+// splitLines splits replacement text into lines; a trailing newline does
+// not start another line.
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// filePatch is the part of a unified diff for one file. Paths are empty
+// for /dev/null.
+type filePatch struct {
+	oldPath, newPath string
+	hunks            []hunk
+}
+
+type hunk struct {
+	oldStart, oldLines, newStart, newLines int
+	lines                                  []string // with their ' ', '-' or '+' prefix
+	noNewlineNew                           bool
+	noNewlineOld                           bool
+}
+
+func parseUnifiedDiff(text string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var patches []filePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") {
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			return nil, fmt.Errorf("line %d: \"---\" without \"+++\"", i+1)
+		}
+		fp := filePatch{oldPath: diffPath(lines[i][4:], "a/"), newPath: diffPath(lines[i+1][4:], "b/")}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			fp.hunks = append(fp.hunks, h)
+			i = next
+		}
+		if len(fp.hunks) == 0 {
+			return nil, fmt.Errorf("no hunks for %s", fp.name())
+		}
+		patches = append(patches, fp)
+		i--
+	}
+	if len(patches) == 0 {
+		return nil, errors.New("no file changes found in patch")
+	}
+	return patches, nil
+}
+
+func (fp filePatch) name() string {
+	if fp.newPath != "" {
+		return fp.newPath
+	}
+	return fp.oldPath
+}
+
+// diffPath reads a ---/+++ header: the path up to a tab, without git's
+// a/ or b/ prefix, "" for /dev/null.
+func diffPath(header, prefix string) string {
+	p, _, _ := strings.Cut(header, "\t")
+	p = strings.TrimSpace(p)
+	if p == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(p, prefix)
+}
+
+func parseHunk(lines []string, i int) (hunk, int, error) {
+	var h hunk
+	header := lines[i]
+	end := strings.Index(header[3:], " @@")
+	if end < 0 {
+		return h, 0, fmt.Errorf("line %d: malformed hunk header", i+1)
+	}
+	ranges := strings.Fields(header[3 : 3+end])
+	if len(ranges) != 2 || !strings.HasPrefix(ranges[0], "-") || !strings.HasPrefix(ranges[1], "+") {
+		return h, 0, fmt.Errorf("line %d: malformed hunk header", i+1)
+	}
+	var err error
+	if h.oldStart, h.oldLines, err = hunkRange(ranges[0][1:]); err == nil {
+		h.newStart, h.newLines, err = hunkRange(ranges[1][1:])
+	}
+	if err != nil {
+		return h, 0, fmt.Errorf("line %d: %v", i+1, err)
+	}
+	oldSeen, newSeen := 0, 0
+	for i++; i < len(lines) && (oldSeen < h.oldLines || newSeen < h.newLines); i++ {
+		line := lines[i]
+		if line == "" {
+			line = " " // blank context line with its space stripped
+		}
+		switch line[0] {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		case '\\':
+			continue
+		default:
+			return h, 0, fmt.Errorf("line %d: unexpected %q in hunk", i+1, line)
+		}
+		h.lines = append(h.lines, line)
+	}
+	if oldSeen != h.oldLines || newSeen != h.newLines {
+		return h, 0, fmt.Errorf("hunk at line %d is shorter than its header says", i)
+	}
+	// "\ No newline at end of file" follows the last line of either side.
+	for ; i < len(lines) && strings.HasPrefix(lines[i], `\`); i++ {
+		switch h.lines[len(h.lines)-1][0] {
+		case '-':
+			h.noNewlineOld = true
+		case '+':
+			h.noNewlineNew = true
+		default:
+			h.noNewlineOld, h.noNewlineNew = true, true
+		}
+	}
+	return h, i, nil
+}
+
+func hunkRange(s string) (start, count int, err error) {
+	first, rest, hasCount := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(first); err != nil {
+		return 0, 0, fmt.Errorf("bad hunk range %q", s)
+	}
+	count = 1
+	if hasCount {
+		if count, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, fmt.Errorf("bad hunk range %q", s)
+		}
+	}
+	return start, count, nil
+}
+
+// apply applies the hunks in order. A hunk whose pre-image is not at its
+// stated line is looked for elsewhere after the previous hunk, nearest
+// first; its lines must match exactly, apart from line endings.
+func (fp filePatch) apply(f *textFile) (offsets []int, err error) {
+	out := append([]string(nil), f.lines...)
+	delta, floor := 0, 0
+	for n, h := range fp.hunks {
+		var pre, post []string
+		for _, l := range h.lines {
+			if l[0] != '+' {
+				pre = append(pre, l[1:])
+			}
+			if l[0] != '-' {
+				post = append(post, l[1:])
+			}
+		}
+		want := h.oldStart - 1 + delta
+		if h.oldLines == 0 {
+			want++ // "-N,0" inserts after line N
+		}
+		at := findLines(out, pre, want, floor)
+		if at < 0 {
+			return nil, fmt.Errorf("hunk %d (@@ -%d,%d @@) does not match the file", n+1, h.oldStart, h.oldLines)
+		}
+		offsets = append(offsets, at-want)
+		out = append(out[:at], append(append([]string(nil), post...), out[at+len(pre):]...)...)
+		delta += len(post) - len(pre)
+		floor = at + len(post)
+		if h.noNewlineNew {
+			f.finalNewline = false
+		} else if h.noNewlineOld {
+			f.finalNewline = true
+		}
+	}
+	f.lines = out
+	return offsets, nil
+}
+
+// findLines returns where pre occurs in lines at or after floor, the
+// occurrence nearest want, or -1.
+func findLines(lines, pre []string, want, floor int) int {
+	matches := func(at int) bool {
+		if at < floor || at+len(pre) > len(lines) {
+			return false
+		}
+		for i, l := range pre {
+			if strings.TrimSuffix(lines[at+i], "\r") != strings.TrimSuffix(l, "\r") {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; d <= len(lines); d++ {
+		if matches(want - d) {
+			return want - d
+		}
+		if d > 0 && matches(want+d) {
+			return want + d
+		}
+	}
+	return -1
+}
+
+// applyEdits applies one file's line edits, which must not overlap.
+func applyEdits(f *textFile, edits []lineEdit) error {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].StartLine < edits[j].StartLine })
+	for i, e := range edits {
+		if e.EndLine < e.StartLine-1 || e.EndLine > len(f.lines) {
+			return fmt.Errorf("lines %d-%d are outside the file's %d lines", e.StartLine, e.EndLine, len(f.lines))
+		}
+		if i > 0 && e.StartLine <= edits[i-1].EndLine {
+			return fmt.Errorf("edits at lines %d and %d overlap", edits[i-1].StartLine, e.StartLine)
+		}
+		if e.Expected != nil {
+			got := strings.Join(f.lines[e.StartLine-1:e.EndLine], "\n")
+			if got != strings.Join(splitLines(*e.Expected), "\n") {
+				return fmt.Errorf("lines %d-%d do not hold the expected text; they are now:\n%s", e.StartLine, e.EndLine, got)
+			}
+		}
+	}
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		repl := splitLines(e.Replacement)
+		f.lines = append(f.lines[:e.StartLine-1], append(repl, f.lines[e.EndLine:]...)...)
+	}
+	return nil
+}
+
+// fileChange is the outcome of a patch for one file.
+type fileChange struct {
+	Path    string `json:"path"`
+	Action  string `json:"action"` // created, modified or deleted
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Offsets []int  `json:"hunkOffsets,omitempty"`
+
+	full    string
+	before  []byte
+	after   []byte
+	mode    fs.FileMode
+	existed bool
+}
+
+// patchMu serializes patches, so concurrent calls cannot interleave their
+// writes.
+var patchMu sync.Mutex
+
+// applyPatchTool validates every change in memory, then writes the files
+// and restores the ones already written if a later write fails.
+func (s *MCPServer) applyPatchTool(ctx context.Context, args applyPatchArgs) (map[string]interface{}, error) {
+	if (args.Patch == "") == (len(args.Edits) == 0) {
+		return nil, errors.New("give either patch or edits")
+	}
+	r, err := s.cfg.Workspace.root(args.Root)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Writable {
+		return nil, fmt.Errorf("workspace root %q is not writable", r.Name)
+	}
+	patchMu.Lock()
+	defer patchMu.Unlock()
+
+	changes := map[string]*fileChange{}
+	var order []string
+	load := func(rel string) (*fileChange, *textFile, error) {
+		rel = strings.Trim(path.Clean("/"+filepath.ToSlash(rel)), "/")
+		if rel == "" {
+			return nil, nil, errors.New("empty path")
+		}
+		if c, ok := changes[rel]; ok {
+			return nil, nil, fmt.Errorf("%s is changed twice", c.Path)
+		}
+		for p := rel; p != "."; p = path.Dir(p) {
+			if s.cfg.Workspace.excluded(p) {
+				return nil, nil, fmt.Errorf("%s is excluded from the workspace", rel)
+			}
+		}
+		full, err := r.resolve(rel)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := readTextFile(full)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		c := &fileChange{Path: rel, full: full, before: f.bytes(), mode: f.mode, existed: f.exists}
+		changes[rel] = c
+		order = append(order, rel)
+		return c, f, nil
+	}
+
+	if args.Patch != "" {
+		patches, err := parseUnifiedDiff(args.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch: %w", err)
+		}
+		for _, fp := range patches {
+			c, f, err := load(fp.name())
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case fp.oldPath == "" && f.exists:
+				return nil, fmt.Errorf("%s: patch creates it, but it exists", c.Path)
+			case fp.oldPath != "" && !f.exists:
+				return nil, fmt.Errorf("%s: no such file", c.Path)
+			}
+			if c.Offsets, err = fp.apply(f); err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Path, err)
+			}
+			for _, h := range fp.hunks {
+				for _, l := range h.lines {
+					switch l[0] {
+					case '+':
+						c.Added++
+					case '-':
+						c.Removed++
+					}
+				}
+			}
+			if fp.newPath == "" {
+				if len(f.lines) > 0 {
+					return nil, fmt.Errorf("%s: patch deletes it, but leaves lines in it", c.Path)
+				}
+				c.Action = "deleted"
+				continue
+			}
+			c.after = f.bytes()
+		}
+	} else {
+		byPath := map[string][]lineEdit{}
+		var paths []string
+		for _, e := range args.Edits {
+			if byPath[e.Path] == nil {
+				paths = append(paths, e.Path)
+			}
+			byPath[e.Path] = append(byPath[e.Path], e)
+		}
+		for _, p := range paths {
+			c, f, err := load(p)
+			if err != nil {
+				return nil, err
+			}
+			if err := applyEdits(f, byPath[p]); err != nil {
+				return nil, fmt.Errorf("%s: %w", c.Path, err)
+			}
+			for _, e := range byPath[p] {
+				c.Added += len(splitLines(e.Replacement))
+				c.Removed += e.EndLine - e.StartLine + 1
+			}
+			c.after = f.bytes()
+		}
+	}
+
+	for _, rel := range order {
+		c := changes[rel]
+		if c.Action == "" {
+			c.Action = "modified"
+			if !c.existed {
+				c.Action = "created"
+			}
+		}
+		if err := checkGoSyntax(c); err != nil {
+			return nil, err
+		}
+	}
+	result := make([]*fileChange, 0, len(order))
+	for _, rel := range order {
+		result = append(result, changes[rel])
+	}
+	if args.DryRun {
+		return map[string]interface{}{"files": result, "applied": false}, nil
+	}
+	if err := writeChanges(ctx, result); err != nil {
+		return nil, err
+	}
+	s.code.invalidate(r.Name)
+	return map[string]interface{}{"files": result, "applied": true}, nil
+}
+
+// checkGoSyntax refuses changes that leave a Go file that parsed before
+// unparsable.
+func checkGoSyntax(c *fileChange) error {
+	if path.Ext(c.Path) != ".go" || c.Action == "deleted" {
+		return nil
+	}
+	if c.existed {
+		if _, err := parser.ParseFile(token.NewFileSet(), c.Path, c.before, parser.SkipObjectResolution); err != nil {
+			return nil
+		}
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), c.Path, c.after, parser.SkipObjectResolution); err != nil {
+		return fmt.Errorf("the change leaves a syntax error: %w", err)
+	}
+	return nil
+}
+
+// writeChanges replaces each file by renaming a temporary file over it,
+// and undoes the files already changed when one fails.
+func writeChanges(ctx context.Context, changes []*fileChange) (err error) {
+	var done []*fileChange
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			c := done[i]
+			if c.existed {
+				// A deleted file is recreated with its old permissions.
+				if writeFileAtomic(c.full, c.before) == nil {
+					os.Chmod(c.full, c.mode)
+				}
+			} else {
+				os.Remove(c.full)
+			}
+		}
+	}()
+	for _, c := range changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.Action == "deleted" {
+			if err := os.Remove(c.full); err != nil {
+				return fmt.Errorf("%s: %w", c.Path, err)
+			}
+		} else {
+			if err := os.MkdirAll(filepath.Dir(c.full), 0o755); err != nil {
+				return fmt.Errorf("%s: %w", c.Path, err)
+			}
+			if err := writeFileAtomic(c.full, c.after); err != nil {
+				return fmt.Errorf("%s: %w", c.Path, err)
+			}
+		}
+		done = append(done, c)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// envVar selects the config profile.
+const envVar = "MCP_ENV"
+
+// builtinProfiles are the defaults of the well-known environments. A
+// profile of the same name in the config file is layered on top.
+var builtinProfiles = map[string]string{
+	"dev": `{
+		"logging": {"verbose": true},
+		"dashboard": {"enabled": true}
+	}`,
+	"staging": `{
+		"policy": {"requireAuth": true}
+	}`,
+	"prod": `{
+		"policy": {"requireAuth": true, "requireTLS": true, "readOnly": true}
+	}`,
+}
+
+// PolicyConfig holds deployment guarantees, normally set by a profile.
+// RequireAuth refuses to start without API keys. RequireTLS rejects plain
+// HTTP requests unless the server terminates TLS itself or a trusted
+// proxy reports https in X-Forwarded-Proto; /health stays reachable. ReadOnly
+// exposes only tools annotated with readOnlyHint.
+type PolicyConfig struct {
+	RequireAuth bool `json:"requireAuth,omitempty"`
+	RequireTLS  bool `json:"requireTLS,omitempty"`
+	ReadOnly    bool `json:"readOnly,omitempty"`
+}
+
+// TLSConfig makes the server terminate TLS itself, with a certificate
+// from files or from ACME.
+type TLSConfig struct {
+	CertFile string      `json:"certFile,omitempty"`
+	KeyFile  string      `json:"keyFile,omitempty"`
+	ACME     *ACMEConfig `json:"acme,omitempty"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" && c.KeyFile != "" || c.ACME != nil
+}
+
+// applyProfile overlays profile env onto the config document data. The
+// profile chain is resolved through "extends"; each profile is layered
+// over its parent and over the built-in profile of the same name.
+func applyProfile(data []byte, env string) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	profiles, _ := doc["profiles"].(map[string]interface{})
+	overlay, err := resolveProfile(env, profiles, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, "profiles")
+	merged := mergeJSON(doc, overlay)
+	merged.(map[string]interface{})["profile"] = env
+	return json.Marshal(merged)
+}
+
+func resolveProfile(name string, profiles map[string]interface{}, seen map[string]bool) (map[string]interface{}, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("profile %q: extends cycle", name)
+	}
+	seen[name] = true
+
+	result := map[string]interface{}{}
+	user, hasUser := profiles[name].(map[string]interface{})
+	builtin, hasBuiltin := builtinProfiles[name]
+	if !hasUser && !hasBuiltin {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	if parent, ok := user["extends"].(string); ok {
+		base, err := resolveProfile(parent, profiles, seen)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+		result = base
+	}
+	if hasBuiltin {
+		var defaults map[string]interface{}
+		if err := json.Unmarshal([]byte(builtin), &defaults); err != nil {
+			return nil, err
+		}
+		result = mergeJSON(result, defaults).(map[string]interface{})
+	}
+	if hasUser {
+		own := make(map[string]interface{}, len(user))
+		for k, v := range user {
+			if k != "extends" {
+				own[k] = v
+			}
+		}
+		result = mergeJSON(result, own).(map[string]interface{})
+	}
+	return result, nil
+}
+
+// mergeJSON layers over onto base: objects merge key by key, anything else
+// is replaced.
+func mergeJSON(base, over interface{}) interface{} {
+	b, ok1 := base.(map[string]interface{})
+	o, ok2 := over.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return over
+	}
+	out := make(map[string]interface{}, len(b)+len(o))
+	for k, v := range b {
+		out[k] = v
+	}
+	for k, v := range o {
+		if existing, ok := out[k]; ok {
+			out[k] = mergeJSON(existing, v)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// checkPolicy enforces the start-up side of cfg.Policy.
+func checkPolicy(cfg *Config) error {
+	if cfg.Policy.RequireAuth && !cfg.Auth.enabled() {
+		return fmt.Errorf("policy requires authentication but no API keys are configured")
+	}
+	return nil
+}
+
+// requireTLS wraps next so plain HTTP requests are refused.
+func requireTLS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isHTTPS(r) && r.URL.Path != "/health" {
+			http.Error(w, "HTTPS required", http.StatusUpgradeRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlyTool reports whether a tool is annotated as read-only. Tools
+// without the hint count as writing, as the MCP spec's defaults say.
+func readOnlyTool(tool Tool) bool {
+	return tool.Annotations != nil && tool.Annotations.ReadOnlyHint != nil && *tool.Annotations.ReadOnlyHint
+}
+
+// writableTools lists the registered tools read-only mode hides.
+func (s *MCPServer) writableTools() []string {
+	var out []string
+	for _, name := range s.toolNames() {
+		if !readOnlyTool(s.tools[name]) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,553 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusConfig points promql_query at the HTTP API of Prometheus or a
+// compatible server like Thanos, Mimir or VictoriaMetrics. URL is the
+// base the /api/v1 paths are appended to. BearerToken, Password and the
+// Headers values, such as an X-Scope-OrgID, may refer to ${secret:name}.
+type PrometheusConfig struct {
+	URL         string            `json:"url,omitempty"`
+	BearerToken string            `json:"bearerToken,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Timeout     Duration          `json:"timeout,omitempty" schema:"format=duration"` // default 30s
+}
+
+const (
+	defaultPromTimeout = 30 * time.Second
+	defaultPromSeries  = 100
+	maxPromSeries      = 1000
+	// maxPromPoints is Prometheus's own limit on points per series.
+	maxPromPoints   = 11000
+	promRangePoints = 250 // aimed for when step is left out
+	maxPromResponse = 16 << 20
+)
+
+func (c PrometheusConfig) enabled() bool {
+	return c.URL != ""
+}
+
+func (c PrometheusConfig) check(secrets map[string]string) error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return errors.New("url must be an http or https URL without credentials")
+	}
+	return c.credentials().check(secrets)
+}
+
+func (c PrometheusConfig) credentials() apiCredentials {
+	return apiCredentials{bearerToken: c.BearerToken, username: c.Username, password: c.Password, headers: c.Headers}
+}
+
+// apiCredentials authenticate requests to an HTTP API such as Prometheus
+// or a log store, with at most one of a bearer token, an Elasticsearch
+// API key and basic auth, plus extra headers. Every value may refer to
+// ${secret:name}.
+type apiCredentials struct {
+	bearerToken, apiKey, username, password string
+	headers                                 map[string]string
+}
+
+func (c apiCredentials) check(secrets map[string]string) error {
+	set := 0
+	for _, v := range []string{c.bearerToken, c.apiKey, c.username} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("give only one kind of credentials")
+	}
+	values := []string{c.bearerToken, c.apiKey, c.password}
+	for name, v := range c.headers {
+		if http.CanonicalHeaderKey(name) == "Authorization" {
+			return errors.New("set credentials in their own fields, not headers")
+		}
+		values = append(values, v)
+	}
+	for _, v := range values {
+		if _, err := expandSecrets(v, secrets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the headers and credentials on req.
+func (c apiCredentials) apply(req *http.Request, secrets map[string]string) error {
+	for name, v := range c.headers {
+		v, err := expandSecrets(v, secrets)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(name, v)
+	}
+	var scheme, value string
+	switch {
+	case c.bearerToken != "":
+		scheme, value = "Bearer ", c.bearerToken
+	case c.apiKey != "":
+		scheme, value = "ApiKey ", c.apiKey
+	case c.username != "":
+		password, err := expandSecrets(c.password, secrets)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(c.username, password)
+		return nil
+	default:
+		return nil
+	}
+	value, err := expandSecrets(value, secrets)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", scheme+value)
+	return nil
+}
+
+type promQueryArgs struct {
+	Query  string `json:"query" jsonschema:"required,description=PromQL expression\\, e.g. sum by (job) (rate(http_requests_total[5m]))"`
+	Time   string `json:"time,omitempty" jsonschema:"description=Evaluation time of an instant query: RFC 3339\\, Unix seconds\\, now or now-1h (default now)"`
+	Start  string `json:"start,omitempty" jsonschema:"description=Start of a range query\\, in the same forms as time; makes the query a range query"`
+	End    string `json:"end,omitempty" jsonschema:"description=End of a range query (default now)"`
+	Step   string `json:"step,omitempty" jsonschema:"description=Resolution of a range query\\, like 30s or 5m (default about 250 points)"`
+	Format string `json:"format,omitempty" jsonschema:"enum=json|table,description=json for structured series (default) or table for an ASCII table; range queries are summarized per series"`
+	Limit  int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=1000,description=Most series to return (default 100)"`
+}
+
+func (s *MCPServer) setupPrometheusTools() {
+	openWorld := true
+	query, handler, _ := typedTool("promql_query", "Run a PromQL instant or range query against Prometheus and return the series", s.promQueryTool)
+	query.Annotations = readOnlyAnnotations()
+	query.Annotations.OpenWorldHint = &openWorld
+	s.addTool(query, handler)
+}
+
+// promTime reads a time as RFC 3339, Unix seconds, "now" or "now-<duration>".
+func promTime(v string, now time.Time) (time.Time, error) {
+	switch {
+	case v == "" || v == "now":
+		return now, nil
+	case strings.HasPrefix(v, "now-"):
+		if d, err := promDuration(v[4:]); err == nil {
+			return now.Add(-d), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("time %q: want RFC 3339, Unix seconds, now or now-<duration> like now-6h or now-7d", v)
+}
+
+// promDuration reads a positive Go duration, or a whole number of days
+// or weeks like 7d or 2w as PromQL writes them.
+func promDuration(v string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	d, err := time.ParseDuration(v)
+	if err != nil && len(v) > 1 {
+		if unit, ok := units[v[len(v)-1]]; ok {
+			n, nerr := strconv.Atoi(v[:len(v)-1])
+			d, err = time.Duration(n)*unit, nerr
+		}
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a positive duration like 30s, 6h or 7d", v)
+	}
+	return d, nil
+}
+
+func promTimestamp(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', -1, 64)
+}
+
+func (s *MCPServer) promQueryTool(ctx context.Context, args promQueryArgs) (interface{}, error) {
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, errors.New("query is required")
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultPromSeries
+	}
+	limit = min(limit, maxPromSeries)
+	now := time.Now()
+	form := url.Values{"query": {args.Query}}
+	endpoint := "/api/v1/query"
+	if args.Start == "" {
+		if args.End != "" || args.Step != "" {
+			return nil, errors.New("end and step need start")
+		}
+		t, err := promTime(args.Time, now)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("time", promTimestamp(t))
+	} else {
+		if args.Time != "" {
+			return nil, errors.New("give time for an instant query or start for a range query, not both")
+		}
+		start, err := promTime(args.Start, now)
+		if err != nil {
+			return nil, err
+		}
+		end, err := promTime(args.End, now)
+		if err != nil {
+			return nil, err
+		}
+		if !end.After(start) {
+			return nil, errors.New("end must be after start")
+		}
+		step := (end.Sub(start) / promRangePoints).Round(time.Second)
+		if args.Step != "" {
+			if step, err = promDuration(args.Step); err != nil {
+				return nil, fmt.Errorf("step: %w", err)
+			}
+		}
+		step = max(step, time.Second)
+		if points := end.Sub(start) / step; points > maxPromPoints {
+			return nil, fmt.Errorf("%d points per series is over the %d Prometheus allows; raise step", points, maxPromPoints)
+		}
+		endpoint = "/api/v1/query_range"
+		form.Set("start", promTimestamp(start))
+		form.Set("end", promTimestamp(end))
+		form.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	}
+
+	data, warnings, err := s.promRequest(ctx, endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	result, err := decodePromResult(data)
+	if err != nil {
+		return nil, err
+	}
+	truncated := len(result.Series) > limit
+	if truncated {
+		result.Series = result.Series[:limit]
+	}
+	if args.Format == "table" {
+		text := result.table()
+		if truncated {
+			text += fmt.Sprintf("\n(first %d series)\n", limit)
+		}
+		for _, w := range warnings {
+			text += "\nwarning: " + w + "\n"
+		}
+		return text, nil
+	}
+	out := map[string]interface{}{"resultType": result.Type, "series": result.Series, "truncated": truncated}
+	if result.Type == "scalar" || result.Type == "string" {
+		out = map[string]interface{}{"resultType": result.Type, "time": result.Time, "value": result.Value}
+	}
+	if len(warnings) > 0 {
+		out["warnings"] = warnings
+	}
+	return out, nil
+}
+
+// promRequest posts form to an API endpoint and returns the data of a
+// successful reply with its warnings.
+func (s *MCPServer) promRequest(ctx context.Context, endpoint string, form url.Values) (json.RawMessage, []string, error) {
+	c := s.cfg.Prometheus
+	ctx, cancel := context.WithTimeout(ctx, orDefault(c.Timeout, defaultPromTimeout))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.URL, "/")+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if err := c.credentials().apply(req, s.secrets); err != nil {
+		return nil, nil, err
+	}
+	resp, err := ToolContextFrom(ctx).HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPromResponse+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxPromResponse {
+		return nil, nil, fmt.Errorf("response is over %d MiB; narrow the query or raise step", maxPromResponse>>20)
+	}
+	var reply struct {
+		Status    string          `json:"status"`
+		Data      json.RawMessage `json:"data"`
+		ErrorType string          `json:"errorType"`
+		Error     string          `json:"error"`
+		Warnings  []string        `json:"warnings"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, nil, fmt.Errorf("prometheus: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body[:min(len(body), 512)]))
+		}
+		return nil, nil, fmt.Errorf("prometheus: invalid response: %w", err)
+	}
+	if reply.Status != "success" {
+		return nil, nil, fmt.Errorf("prometheus: %s: %s", reply.ErrorType, reply.Error)
+	}
+	return reply.Data, reply.Warnings, nil
+}
+
+// promPoint is a sample. Values that JSON cannot hold, NaN and the
+// infinities, stay strings.
+type promPoint struct {
+	Time  string      `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+type promSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  *promPoint        `json:"value,omitempty"`  // of a vector
+	Points []promPoint       `json:"points,omitempty"` // of a matrix
+}
+
+type promResult struct {
+	Type   string
+	Series []promSeries
+	// Time and Value of a scalar or string result.
+	Time  string
+	Value interface{}
+}
+
+// decodePromResult reads the data of a query reply, sorting series by
+// their labels so the same data always gives the same output.
+func decodePromResult(data json.RawMessage) (*promResult, error) {
+	var raw struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("prometheus: invalid result: %w", err)
+	}
+	res := &promResult{Type: raw.ResultType, Series: []promSeries{}}
+	switch raw.ResultType {
+	case "vector", "matrix":
+		var series []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+			Values [][]interface{}   `json:"values"`
+		}
+		if err := json.Unmarshal(raw.Result, &series); err != nil {
+			return nil, fmt.Errorf("prometheus: invalid result: %w", err)
+		}
+		for _, s := range series {
+			ps := promSeries{Labels: s.Metric}
+			if ps.Labels == nil {
+				ps.Labels = map[string]string{}
+			}
+			if raw.ResultType == "vector" {
+				p := promSample(s.Value)
+				ps.Value = &p
+			} else {
+				ps.Points = make([]promPoint, len(s.Values))
+				for i, v := range s.Values {
+					ps.Points[i] = promSample(v)
+				}
+			}
+			res.Series = append(res.Series, ps)
+		}
+		sort.SliceStable(res.Series, func(i, j int) bool {
+			return promLabelString(res.Series[i].Labels) < promLabelString(res.Series[j].Labels)
+		})
+	case "scalar", "string":
+		var v []interface{}
+		if err := json.Unmarshal(raw.Result, &v); err != nil {
+			return nil, fmt.Errorf("prometheus: invalid result: %w", err)
+		}
+		p := promSample(v)
+		res.Time, res.Value = p.Time, p.Value
+		if raw.ResultType == "string" && len(v) == 2 {
+			res.Value, _ = v[1].(string)
+		}
+	default:
+		return nil, fmt.Errorf("prometheus: unknown result type %q", raw.ResultType)
+	}
+	return res, nil
+}
+
+// promSample converts a [unix seconds, "value"] pair.
+func promSample(pair []interface{}) promPoint {
+	if len(pair) != 2 {
+		return promPoint{}
+	}
+	secs, _ := pair[0].(float64)
+	p := promPoint{Time: time.UnixMilli(int64(math.Round(secs * 1000))).UTC().Format(time.RFC3339Nano)}
+	text, _ := pair[1].(string)
+	p.Value = text
+	if f, err := strconv.ParseFloat(text, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		p.Value = f
+	}
+	return p
+}
+
+// promLabelString writes labels as PromQL does: name{a="1", b="2"}.
+func promLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Quote(labels[name])
+	}
+	return labels["__name__"] + "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// table renders the result as an ASCII table. Labels every series shares
+// are stated above it rather than repeated in a column, and each series
+// of a range query is one row of statistics.
+func (r *promResult) table() string {
+	if r.Type == "scalar" || r.Type == "string" {
+		return asciiTable([]string{"time", "value"}, [][]string{{r.Time, promValueText(r.Value)}})
+	}
+	if len(r.Series) == 0 {
+		return "no series\n"
+	}
+	common := map[string]string{}
+	for name, v := range r.Series[0].Labels {
+		common[name] = v
+	}
+	names := map[string]bool{}
+	for _, s := range r.Series {
+		for name := range s.Labels {
+			names[name] = true
+		}
+		for name, v := range common {
+			if s.Labels[name] != v {
+				delete(common, name)
+			}
+		}
+	}
+	columns := []string{}
+	for name := range names {
+		if _, ok := common[name]; !ok || len(r.Series) == 1 {
+			columns = append(columns, name)
+		}
+	}
+	sort.Strings(columns)
+
+	header := append([]string{}, columns...)
+	if r.Type == "vector" {
+		header = append(header, "value")
+	} else {
+		header = append(header, "points", "min", "avg", "max", "last")
+	}
+	rows := make([][]string, len(r.Series))
+	for i, s := range r.Series {
+		row := make([]string, 0, len(header))
+		for _, name := range columns {
+			row = append(row, s.Labels[name])
+		}
+		if r.Type == "vector" {
+			row = append(row, promValueText(s.Value.Value))
+		} else {
+			row = append(row, promStats(s.Points)...)
+		}
+		rows[i] = row
+	}
+
+	var b strings.Builder
+	if len(r.Series) > 1 && len(common) > 0 {
+		b.WriteString("all series: " + promLabelString(common) + "\n\n")
+	}
+	b.WriteString(asciiTable(header, rows))
+	return b.String()
+}
+
+// promStats summarizes the points of a series: count, min, avg, max and
+// last, leaving out values that are not numbers.
+func promStats(points []promPoint) []string {
+	var n int
+	var sum float64
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		if f, ok := p.Value.(float64); ok {
+			n++
+			sum += f
+			lo, hi = math.Min(lo, f), math.Max(hi, f)
+		}
+	}
+	last := ""
+	if len(points) > 0 {
+		last = promValueText(points[len(points)-1].Value)
+	}
+	if n == 0 {
+		return []string{strconv.Itoa(len(points)), "", "", "", last}
+	}
+	return []string{strconv.Itoa(len(points)), promValueText(lo), promValueText(sum / float64(n)), promValueText(hi), last}
+}
+
+func promValueText(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'g', 6, 64)
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// asciiTable lays out rows under header in aligned columns:
+//
+//	job  | value
+//	-----+------
+//	api  | 12.5
+func asciiTable(header []string, rows [][]string) string {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	var b strings.Builder
+	line := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(cell)
+			if i < len(cells)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-len([]rune(cell))))
+			}
+		}
+		b.WriteString("\n")
+	}
+	line(header)
+	for i, w := range widths {
+		if i > 0 {
+			b.WriteString("-+-")
+		}
+		b.WriteString(strings.Repeat("-", w))
+	}
+	b.WriteString("\n")
+	for _, row := range rows {
+		line(row)
+	}
+	return b.String()
+}
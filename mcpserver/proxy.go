@@ -0,0 +1,161 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ProxyConfig describes the reverse proxy or ingress in front of the
+// server. Requests from TrustedProxies (IPs or CIDRs) have their client
+// address taken from X-Forwarded-For and their X-Forwarded-Proto
+// believed; X-Forwarded-* headers from other peers are dropped, so
+// clients cannot spoof their address or claim HTTPS. BasePath serves
+// every route under a prefix such as "/services/mcp"; /health also stays
+// reachable without it for probes that bypass the proxy.
+type ProxyConfig struct {
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	BasePath       string   `json:"basePath,omitempty"`
+}
+
+func (c ProxyConfig) trustedNets() ([]*net.IPNet, error) {
+	nets, err := parseNets(c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxy %w", err)
+	}
+	return nets, nil
+}
+
+// parseNets parses IPs and CIDRs; an IP is a network of one address.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range list {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("%q: not an IP or CIDR", p)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// basePath returns BasePath with a leading and without a trailing slash.
+func (c ProxyConfig) basePath() string {
+	p := strings.Trim(c.BasePath, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// wrap applies the proxy settings to next.
+func (c ProxyConfig) wrap(next http.Handler) (http.Handler, error) {
+	trusted, err := c.trustedNets()
+	if err != nil {
+		return nil, err
+	}
+	if len(trusted) == 0 && c.basePath() == "" {
+		return next, nil
+	}
+	base := c.basePath()
+	stripped := http.StripPrefix(base, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(trusted) > 0 {
+			if ipTrusted(trusted, clientIP(r)) {
+				if ip := forwardedClient(trusted, r.Header.Values("X-Forwarded-For")); ip != "" {
+					r.RemoteAddr = net.JoinHostPort(ip, "0")
+				}
+				r = r.WithContext(context.WithValue(r.Context(), viaTrustedProxyKey{}, true))
+			} else {
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("X-Forwarded-Proto")
+				r.Header.Del("X-Forwarded-Host")
+			}
+		}
+		switch {
+		case base == "" || r.URL.Path == "/health":
+			next.ServeHTTP(w, r)
+		case r.URL.Path == base:
+			target := base + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}), nil
+}
+
+type viaTrustedProxyKey struct{}
+
+// isHTTPS reports whether the client reached the server over HTTPS:
+// directly, or through a trusted proxy that says so in X-Forwarded-Proto.
+// Without trusted proxies the header is ignored.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	via, _ := r.Context().Value(viaTrustedProxyKey{}).(bool)
+	return via && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// forwardedClient returns the nearest address in X-Forwarded-For that is
+// not a trusted proxy. Entries further left were supplied by the client
+// and cannot be trusted.
+func forwardedClient(trusted []*net.IPNet, headers []string) string {
+	var hops []string
+	for _, h := range headers {
+		for _, hop := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			return ""
+		}
+		if !ipTrusted(trusted, hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	return ""
+}
+
+func ipTrusted(trusted []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent r, after any
+// trusted proxy has been accounted for.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,89 @@
+package mcpserver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireTLSBehindProxy(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(clientIP(r)))
+	})
+	for _, tc := range []struct {
+		name     string
+		trusted  []string
+		peer     string
+		tls      bool
+		proto    string
+		want     int
+		clientIP string
+	}{
+		{"plain HTTP", nil, "203.0.113.9:4000", false, "", http.StatusUpgradeRequired, ""},
+		{"forged header without trusted proxies", nil, "203.0.113.9:4000", false, "https", http.StatusUpgradeRequired, ""},
+		{"direct TLS", nil, "203.0.113.9:4000", true, "", http.StatusOK, "203.0.113.9"},
+		{"forged header from an untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.9:4000", false, "https", http.StatusUpgradeRequired, ""},
+		{"trusted proxy over HTTPS", []string{"10.0.0.0/8"}, "10.1.2.3:4000", false, "https", http.StatusOK, "198.51.100.7"},
+		{"trusted proxy over HTTP", []string{"10.0.0.0/8"}, "10.1.2.3:4000", false, "http", http.StatusUpgradeRequired, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := ProxyConfig{TrustedProxies: tc.trusted}.wrap(requireTLS(ok))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/mcp", nil)
+			r.RemoteAddr = tc.peer
+			r.Header.Set("X-Forwarded-For", "198.51.100.7")
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && w.Body.String() != tc.clientIP {
+				t.Errorf("client %s, want %s", w.Body, tc.clientIP)
+			}
+		})
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	for _, tc := range []struct {
+		name, peer, forwarded, want string
+	}{
+		{"untrusted peer keeps its address", "203.0.113.9:4000", "198.51.100.7", "203.0.113.9"},
+		{"trusted proxy", "10.1.2.3:4000", "198.51.100.7", "198.51.100.7"},
+		{"spoofed entries left of the client", "10.1.2.3:4000", "192.0.2.66, 198.51.100.7, 10.0.0.5", "198.51.100.7"},
+		{"only proxies", "10.1.2.3:4000", "10.0.0.4, 10.0.0.5", "10.0.0.4"},
+		{"garbage hop", "10.1.2.3:4000", "198.51.100.7, not-an-ip", "10.1.2.3"},
+		{"no header", "10.1.2.3:4000", "", "10.1.2.3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}}.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(clientIP(r)))
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/health", nil)
+			r.RemoteAddr = tc.peer
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if got := w.Body.String(); got != tc.want {
+				t.Errorf("client %s, want %s", got, tc.want)
+			}
+		})
+	}
+	if _, err := (ProxyConfig{TrustedProxies: []string{"10.0.0.0/33"}}).wrap(http.NotFoundHandler()); err == nil {
+		t.Error("bad CIDR accepted")
+	}
+}
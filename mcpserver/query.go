@@ -0,0 +1,304 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+)
+
+// QueryConfig configures query_json.
+type QueryConfig struct {
+	// URLs lists the URL prefixes query_json may fetch documents from;
+	// none may be fetched by default.
+	URLs []string `json:"urls,omitempty"`
+	// Timeout bounds fetching and evaluating a query (default 30s).
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"`
+	// MaxResults stops a query after this many results (default 1000).
+	MaxResults int `json:"maxResults,omitempty" schema:"minimum=1"`
+}
+
+const (
+	defaultQueryTimeout    = 30 * time.Second
+	defaultQueryMaxResults = 1000
+)
+
+func (c QueryConfig) check() error {
+	for _, prefix := range c.URLs {
+		u, err := url.Parse(prefix)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("query url %q: must be an http or https URL", prefix)
+		}
+		if u.User != nil {
+			return fmt.Errorf("query url %q: must not contain credentials", prefix)
+		}
+	}
+	return nil
+}
+
+func (c QueryConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return defaultQueryTimeout
+}
+
+func (c QueryConfig) maxResults() int {
+	if c.MaxResults > 0 {
+		return c.MaxResults
+	}
+	return defaultQueryMaxResults
+}
+
+// allowsURL reports whether u falls under a configured prefix. A prefix
+// ending in a host also ends at it, so https://api.example.com does not
+// allow https://api.example.com.evil.net, and paths with .. segments are
+// refused since servers may resolve them out of the prefix.
+func (c QueryConfig) allowsURL(u *url.URL) bool {
+	if u.User != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if strings.Contains("/"+u.Path+"/", "/../") {
+		return false
+	}
+	target := u.String()
+	for _, prefix := range c.URLs {
+		if p, err := url.Parse(prefix); err == nil && p.Path == "" {
+			prefix += "/"
+		}
+		if target+"/" == prefix || strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryJSONArgs struct {
+	Query     string                 `json:"query" jsonschema:"required,description=jq expression\\, e.g. .items[] | select(.state == \"open\") | .id"`
+	JSON      string                 `json:"json,omitempty" jsonschema:"description=Document text to query"`
+	Data      json.RawMessage        `json:"data,omitempty" jsonschema:"description=JSON value to query"`
+	Root      string                 `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path      string                 `json:"path,omitempty" jsonschema:"description=Root-relative file to query"`
+	URL       string                 `json:"url,omitempty" jsonschema:"description=URL to fetch the document from; only configured prefixes may be fetched"`
+	Format    string                 `json:"format,omitempty" jsonschema:"enum=json|jsonl|yaml|toml|csv|xml,description=Format of json\\, path or url (default json\\, or the one of path's extension); each jsonl line is a separate input"`
+	Variables map[string]interface{} `json:"variables,omitempty" jsonschema:"description=Values bound to $name in the query"`
+	Raw       bool                   `json:"raw,omitempty" jsonschema:"description=Return the results as text lines with strings unquoted\\, like jq -r"`
+}
+
+var queryVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *MCPServer) queryJSONTool(ctx context.Context, args queryJSONArgs) (interface{}, error) {
+	sources := 0
+	for _, set := range []bool{args.JSON != "", len(args.Data) > 0, args.Path != "", args.URL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return nil, errors.New("give exactly one of json, data, path and url")
+	}
+	parsed, err := gojq.Parse(args.Query)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	names := make([]string, 0, len(args.Variables))
+	values := make([]interface{}, 0, len(args.Variables))
+	for _, name := range sortedKeys(args.Variables) {
+		if !queryVariableName.MatchString(name) {
+			return nil, fmt.Errorf("variable %q: names are letters, digits and underscores", name)
+		}
+		names = append(names, "$"+name)
+		values = append(values, args.Variables[name])
+	}
+	// Without an environ loader, env and $ENV are empty.
+	code, err := gojq.Compile(parsed, gojq.WithVariables(names))
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Query.timeout())
+	defer cancel()
+	inputs, err := s.queryInputs(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := s.cfg.Query.maxResults()
+	results := []interface{}{}
+	truncated := false
+	for _, input := range inputs {
+		iter := code.RunWithContext(ctx, input, values...)
+		for {
+			v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := v.(error); ok {
+				var halt *gojq.HaltError
+				if errors.As(err, &halt) && halt.Value() == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("query stopped after %s", s.cfg.Query.timeout())
+				}
+				return nil, err
+			}
+			if len(results) == limit {
+				truncated = true
+				break
+			}
+			results = append(results, v)
+		}
+		if truncated {
+			break
+		}
+	}
+
+	if args.Raw {
+		var b strings.Builder
+		for _, v := range results {
+			if str, ok := v.(string); ok {
+				b.WriteString(str)
+			} else if text, err := gojq.Marshal(v); err == nil {
+				b.Write(text)
+			}
+			b.WriteByte('\n')
+		}
+		if truncated {
+			fmt.Fprintf(&b, "(stopped after %d results)\n", limit)
+		}
+		return b.String(), nil
+	}
+	result := map[string]interface{}{"results": results, "count": len(results)}
+	if truncated {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+// queryInputs reads the document to query: one input, or one per line of
+// JSON Lines.
+func (s *MCPServer) queryInputs(ctx context.Context, args queryJSONArgs) ([]interface{}, error) {
+	if len(args.Data) > 0 {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(args.Data))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return []interface{}{v}, nil
+	}
+	format := args.Format
+	var src io.Reader
+	switch {
+	case args.JSON != "":
+		src = strings.NewReader(args.JSON)
+	case args.Path != "":
+		r, err := s.cfg.Workspace.root(args.Root)
+		if err != nil {
+			return nil, err
+		}
+		full, err := r.resolve(args.Path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(full)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src = f
+		if format == "" {
+			format = convertFormatByExt[strings.ToLower(path.Ext(args.Path))]
+		}
+	default:
+		body, err := s.fetchQueryDocument(ctx, args.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		src = body
+	}
+	if format == "" {
+		format = "json"
+	}
+	v, err := readData(bufio.NewReader(src), format, convertOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if list, ok := v.([]interface{}); ok && format == "jsonl" {
+		for i := range list {
+			list[i] = plainData(list[i])
+		}
+		return list, nil
+	}
+	return []interface{}{plainData(v)}, nil
+}
+
+// fetchQueryDocument GETs rawURL, which must fall under a configured
+// prefix, as must every redirect.
+func (s *MCPServer) fetchQueryDocument(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !s.cfg.Query.allowsURL(u) {
+		return nil, fmt.Errorf("%s is not under a configured query url", u.Redacted())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json, */*;q=0.5")
+	client := *ToolContextFrom(ctx).HTTPClient
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !s.cfg.Query.allowsURL(next.URL) {
+			return fmt.Errorf("redirect to %s is not under a configured query url", next.URL.Redacted())
+		}
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return resp.Body, nil
+}
+
+// plainData turns the ordered tree of the data readers into the maps gojq
+// works on; gojq reads json.Number itself.
+func plainData(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *dataObject:
+		m := make(map[string]interface{}, len(v.keys))
+		for _, k := range v.keys {
+			m[k] = plainData(v.values[k])
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = plainData(v[i])
+		}
+		return v
+	}
+	return v
+}
@@ -0,0 +1,352 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// QuotaConfig caps an API key's usage. Zero means unlimited.
+type QuotaConfig struct {
+	DailyCalls   int64 `json:"dailyCalls,omitempty"`
+	MonthlyBytes int64 `json:"monthlyBytes,omitempty"`
+}
+
+// codeQuotaExceeded is the JSON-RPC error code for exhausted quotas.
+const codeQuotaExceeded = -32001
+
+const (
+	keyBytesMetric = "mcp_api_key_bytes_total"
+	keyCallsMetric = "mcp_api_key_tool_calls_total"
+)
+
+// QuotaError reports which quota was exhausted and when it resets.
+type QuotaError struct {
+	Quota   string    `json:"quota"`
+	Limit   int64     `json:"limit"`
+	ResetAt time.Time `json:"resetAt"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s quota of %d exceeded", e.Quota, e.Limit)
+}
+
+// KeyUsage is the accounting record of one API key.
+type KeyUsage struct {
+	Key        string `json:"key"`
+	BytesIn    int64  `json:"bytesIn"`
+	BytesOut   int64  `json:"bytesOut"`
+	Calls      int64  `json:"calls"`
+	Day        string `json:"day"`
+	DayCalls   int64  `json:"dayCalls"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"monthBytes"`
+}
+
+// roll resets the daily and monthly windows when they have passed.
+func (u *KeyUsage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayCalls = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+	}
+}
+
+// merge adds the counts of d to u. Window counts of d from a later day
+// or month replace those of u, and ones from an earlier window are dropped.
+func (u *KeyUsage) merge(d *KeyUsage) {
+	u.BytesIn += d.BytesIn
+	u.BytesOut += d.BytesOut
+	u.Calls += d.Calls
+	switch {
+	case u.Day == d.Day:
+		u.DayCalls += d.DayCalls
+	case u.Day < d.Day:
+		u.Day, u.DayCalls = d.Day, d.DayCalls
+	}
+	switch {
+	case u.Month == d.Month:
+		u.MonthBytes += d.MonthBytes
+	case u.Month < d.Month:
+		u.Month, u.MonthBytes = d.Month, d.MonthBytes
+	}
+}
+
+// quotaFlushInterval is how often counts are written to the state store.
+const quotaFlushInterval = 5 * time.Second
+
+// accountant tracks bytes and tool calls per API key and enforces quotas.
+// With a state store the counts are kept there, one record per key, so a
+// restart does not reset them. Requests only count in memory; flush adds
+// what was counted since the last flush to the stored record, so replicas
+// sharing a store add up their counts instead of overwriting each other's.
+type accountant struct {
+	mu      sync.Mutex
+	keys    map[string]*KeyUsage
+	pending map[string]*KeyUsage // counted since the last flush
+	metrics *metricsRegistry
+	now     func() time.Time
+	db      store.Store
+
+	flushMu sync.Mutex
+}
+
+func newAccountant(m *metricsRegistry) *accountant {
+	return &accountant{
+		keys:    map[string]*KeyUsage{},
+		metrics: m,
+		pending: map[string]*KeyUsage{},
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// attach makes db the counts' persistent store and loads the counts kept
+// there.
+func (a *accountant) attach(db store.Store) error {
+	ctx, cancel := storeContext()
+	defer cancel()
+	recs, err := db.List(ctx, nsQuota, "")
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.db = db
+	for _, rec := range recs {
+		u := &KeyUsage{}
+		if err := json.Unmarshal(rec.Value, u); err != nil || u.Key != rec.Key {
+			continue
+		}
+		a.keys[u.Key] = u
+	}
+	return nil
+}
+
+// flushLoop flushes the counts every quotaFlushInterval until ctx is
+// cancelled.
+func (a *accountant) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// flush adds the counts made since the last flush to the stored records
+// and takes the merged records, which include other replicas' counts, as
+// the local ones. Counts that fail to store are kept for the next flush.
+func (a *accountant) flush() {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+	a.mu.Lock()
+	db, pending := a.db, a.pending
+	a.pending = map[string]*KeyUsage{}
+	a.mu.Unlock()
+	if db == nil {
+		return
+	}
+	for name, d := range pending {
+		stored, err := a.addStored(db, name, d)
+		a.mu.Lock()
+		if err != nil {
+			log.Printf("quota: storing %s: %v", name, err)
+			a.pendingFor(name).merge(d)
+		} else {
+			if p := a.pending[name]; p != nil {
+				stored.merge(p)
+			}
+			stored.roll(a.now())
+			a.keys[name] = stored
+		}
+		a.mu.Unlock()
+	}
+}
+
+// addStored adds d to the stored record of key name and returns the sum.
+func (a *accountant) addStored(db store.Store, name string, d *KeyUsage) (*KeyUsage, error) {
+	ctx, cancel := storeContext()
+	defer cancel()
+	u := &KeyUsage{Key: name}
+	rec, err := db.Get(ctx, nsQuota, name)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(rec.Value, u); err != nil || u.Key != name {
+			u = &KeyUsage{Key: name}
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+	u.merge(d)
+	if err := putJSON(db, nsQuota, name, name, u, time.Time{}); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (a *accountant) usage(name string) *KeyUsage {
+	u := a.keys[name]
+	if u == nil {
+		u = &KeyUsage{Key: name}
+		a.keys[name] = u
+	}
+	u.roll(a.now())
+	return u
+}
+
+// pendingFor returns the counts of key name not yet flushed.
+func (a *accountant) pendingFor(name string) *KeyUsage {
+	d := a.pending[name]
+	if d == nil {
+		d = &KeyUsage{Key: name}
+		a.pending[name] = d
+	}
+	d.roll(a.now())
+	return d
+}
+
+// checkBytes fails once the key's monthly byte quota is used up.
+func (a *accountant) checkBytes(key *APIKeyConfig) error {
+	if key == nil || key.Quota.MonthlyBytes <= 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u := a.usage(key.Name)
+	if u.MonthBytes >= key.Quota.MonthlyBytes {
+		now := a.now()
+		return &QuotaError{
+			Quota:   "monthlyBytes",
+			Limit:   key.Quota.MonthlyBytes,
+			ResetAt: time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	return nil
+}
+
+// chargeCall counts a tool invocation, failing if it would exceed the
+// key's daily call quota.
+func (a *accountant) chargeCall(key *APIKeyConfig) error {
+	name := key.nameOrAnonymous()
+	a.mu.Lock()
+	u := a.usage(name)
+	if key != nil && key.Quota.DailyCalls > 0 && u.DayCalls >= key.Quota.DailyCalls {
+		a.mu.Unlock()
+		now := a.now()
+		return &QuotaError{
+			Quota:   "dailyCalls",
+			Limit:   key.Quota.DailyCalls,
+			ResetAt: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	u.Calls++
+	u.DayCalls++
+	if a.db != nil {
+		d := a.pendingFor(name)
+		d.Calls++
+		d.DayCalls++
+	}
+	a.mu.Unlock()
+	a.metrics.inc(keyCallsMetric, "key", name)
+	return nil
+}
+
+func (a *accountant) addBytes(key *APIKeyConfig, in, out int64) {
+	name := key.nameOrAnonymous()
+	a.mu.Lock()
+	u := a.usage(name)
+	u.BytesIn += in
+	u.BytesOut += out
+	u.MonthBytes += in + out
+	if a.db != nil {
+		d := a.pendingFor(name)
+		d.BytesIn += in
+		d.BytesOut += out
+		d.MonthBytes += in + out
+	}
+	a.mu.Unlock()
+	a.metrics.add(keyBytesMetric, float64(in), "key", name, "direction", "in")
+	a.metrics.add(keyBytesMetric, float64(out), "key", name, "direction", "out")
+}
+
+func (a *accountant) snapshot() []KeyUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]KeyUsage, 0, len(a.keys))
+	for _, u := range a.keys {
+		u.roll(a.now())
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// anonymousKeyName accounts unauthenticated callers; no key may have it.
+const anonymousKeyName = "anonymous"
+
+func (k *APIKeyConfig) nameOrAnonymous() string {
+	if k == nil {
+		return anonymousKeyName
+	}
+	return k.Name
+}
+
+// countingWriter counts response bytes for accounting.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it and keeps the first
+// read error.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func quotaError(err error) *JSONRPCError {
+	return &JSONRPCError{
+		Code:    codeQuotaExceeded,
+		Message: "Quota exceeded: " + err.Error(),
+		Data:    err,
+	}
+}
+
+// handleAdminAccounting serves GET /admin/accounting.
+func (s *MCPServer) handleAdminAccounting(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": s.accounting.snapshot()})
+}
@@ -0,0 +1,117 @@
+package mcpserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mcp-server/store"
+)
+
+func TestQuotaPersisted(t *testing.T) {
+	dir := t.TempDir()
+	db, err := store.Open(store.Config{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	key := &APIKeyConfig{Name: "team-a", Quota: QuotaConfig{DailyCalls: 2}}
+	day := time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)
+
+	a := newAccountant(newMetricsRegistry())
+	a.now = func() time.Time { return day }
+	if err := a.attach(db); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := a.chargeCall(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.addBytes(key, 100, 900)
+	a.flush()
+
+	// A restarted server loads the counts and keeps enforcing the quota.
+	b := newAccountant(newMetricsRegistry())
+	b.now = a.now
+	if err := b.attach(db); err != nil {
+		t.Fatal(err)
+	}
+	var qe *QuotaError
+	if err := b.chargeCall(key); !errors.As(err, &qe) || qe.Quota != "dailyCalls" {
+		t.Fatalf("third call: %v", err)
+	}
+	usage := b.snapshot()
+	if len(usage) != 1 || usage[0].Calls != 2 || usage[0].MonthBytes != 1000 || usage[0].BytesOut != 900 {
+		t.Errorf("loaded %+v", usage)
+	}
+
+	// The next day's window starts from zero.
+	b.now = func() time.Time { return day.Add(24 * time.Hour) }
+	if err := b.chargeCall(key); err != nil {
+		t.Fatal(err)
+	}
+	b.flush()
+	c := newAccountant(newMetricsRegistry())
+	c.now = b.now
+	if err := c.attach(db); err != nil {
+		t.Fatal(err)
+	}
+	if u := c.snapshot(); len(u) != 1 || u[0].Calls != 3 || u[0].DayCalls != 1 || u[0].Day != "2026-10-16" {
+		t.Errorf("after a day %+v", u)
+	}
+}
+
+func TestQuotaReplicasAddUp(t *testing.T) {
+	db, err := store.Open(store.Config{}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	key := &APIKeyConfig{Name: "team-a", Quota: QuotaConfig{DailyCalls: 5}}
+	day := func() time.Time { return time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC) }
+
+	replicas := make([]*accountant, 2)
+	for i := range replicas {
+		replicas[i] = newAccountant(newMetricsRegistry())
+		replicas[i].now = day
+		if err := replicas[i].attach(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := replicas[0], replicas[1]
+	for i := 0; i < 2; i++ {
+		if err := a.chargeCall(key); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.chargeCall(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.addBytes(key, 10, 20)
+	a.flush()
+	b.flush()
+
+	// b now sees a's calls too, and a picks up b's on its next flush.
+	if u := b.snapshot(); len(u) != 1 || u[0].Calls != 4 || u[0].DayCalls != 4 || u[0].MonthBytes != 30 {
+		t.Errorf("b after flush %+v", u)
+	}
+	if err := b.chargeCall(key); err != nil {
+		t.Fatal(err)
+	}
+	var qe *QuotaError
+	if err := b.chargeCall(key); !errors.As(err, &qe) {
+		t.Errorf("sixth call: %v", err)
+	}
+	b.flush()
+	if err := a.chargeCall(key); err != nil {
+		t.Fatal(err)
+	}
+	a.flush()
+	if u := a.snapshot(); len(u) != 1 || u[0].Calls != 6 || u[0].DayCalls != 6 {
+		t.Errorf("a after flush %+v", u)
+	}
+	if err := a.chargeCall(key); !errors.As(err, &qe) {
+		t.Errorf("a over quota: %v", err)
+	}
+}
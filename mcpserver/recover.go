@@ -0,0 +1,103 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+const panicsMetric = "mcp_panics_total"
+
+// recovered logs a recovered panic with its stack under a new correlation
+// ID and returns the ID. Clients only ever see the ID, which operators can
+// look up in the log.
+func recovered(metrics *metricsRegistry, where string, p interface{}) string {
+	id := randomHex(8)
+	log.Printf("panic %s in %s: %v\n%s", id, where, p, debug.Stack())
+	metrics.inc(panicsMetric, "where", where)
+	return id
+}
+
+// internalError is the JSON-RPC error for a recovered panic.
+func internalError(correlationID string) *JSONRPCError {
+	return &JSONRPCError{
+		Code:    codeInternalError,
+		Message: "Internal error",
+		Data:    map[string]string{"correlationId": correlationID},
+	}
+}
+
+// recoverPanics keeps a panicking handler from taking the connection down
+// with a bare EOF: the client gets a 500, or a JSON-RPC -32603 error on
+// /mcp. Panics inside a JSON-RPC call are normally caught earlier, where
+// the request ID is known.
+func recoverPanics(metrics *metricsRegistry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			id := recovered(metrics, r.URL.Path, p)
+			if sw.wrote {
+				return // too late to replace the response
+			}
+			w.Header().Set("X-Correlation-Id", id)
+			if r.Method == http.MethodPost && r.URL.Path == "/mcp" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				writeResponse(w, nil, nil, internalError(id))
+				return
+			}
+			http.Error(w, fmt.Sprintf("Internal error (correlation ID %s)", id), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records whether the response has been started.
+type statusWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	w.wrote = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// executeJob runs a tool for the job queue, where no request is left to
+// fail: a panic becomes an error result carrying the correlation ID.
+func (s *MCPServer) executeJob(ctx context.Context, name string, args json.RawMessage) (result interface{}) {
+	defer func() {
+		if p := recover(); p != nil {
+			id := recovered(s.metrics, "job:"+name, p)
+			result = errorResult(fmt.Errorf("internal error (correlation ID %s)", id))
+		}
+	}()
+	return s.executeTool(ctx, name, args)
+}
@@ -0,0 +1,261 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const configReloadsMetric = "mcp_config_reloads_total"
+
+// liveServer holds the active server generation. A reload builds a
+// complete new generation, sharing sessions, metrics, usage, jobs and the
+// other long-lived components with the current one, and swaps it in
+// under the lock. Requests already running finish on the generation they
+// started with; if anything fails to build, the current generation stays.
+type liveServer struct {
+	mu      sync.RWMutex
+	current *MCPServer
+
+	load      func() (*Config, error)
+	path, env string
+	opts      options
+
+	reloadMu sync.Mutex // serializes reloads
+	status   ReloadStatus
+}
+
+// ReloadStatus describes the outcome of the most recent reload.
+type ReloadStatus struct {
+	Generation      int       `json:"generation"`
+	LoadedAt        time.Time `json:"loadedAt"`
+	LastAttempt     time.Time `json:"lastAttempt,omitempty"`
+	LastError       string    `json:"lastError,omitempty"`
+	RestartRequired []string  `json:"restartRequired,omitempty"`
+}
+
+func newLiveServer(s *MCPServer, b *Builder) *liveServer {
+	l := &liveServer{current: s, load: b.loadConfig, path: b.path, env: b.env, opts: b.opts}
+	l.status = ReloadStatus{Generation: 1, LoadedAt: time.Now().UTC()}
+	s.live = l
+	return l
+}
+
+func (l *liveServer) server() *MCPServer {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current
+}
+
+// handle adapts a server method to an HTTP handler that always runs on the
+// current generation.
+func (l *liveServer) handle(h func(*MCPServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(l.server(), w, r)
+	}
+}
+
+// current returns the active generation of the server: s itself unless a
+// reload has replaced it.
+func (s *MCPServer) current() *MCPServer {
+	if s.live == nil {
+		return s
+	}
+	return s.live.server()
+}
+
+// reload re-reads and validates the config and, if every tool and
+// subsystem builds, makes it the active configuration.
+func (l *liveServer) reload() error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	return l.reloadLocked()
+}
+
+// reloadLocked is reload for callers holding reloadMu.
+func (l *liveServer) reloadLocked() error {
+	old := l.server()
+	now := time.Now().UTC()
+	next, err := l.build(old)
+
+	l.mu.Lock()
+	l.status.LastAttempt = now
+	if err != nil {
+		l.status.LastError = err.Error()
+		l.mu.Unlock()
+		old.metrics.inc(configReloadsMetric, "outcome", "error")
+		old.publish(EventConfigReloadFailed, map[string]interface{}{"generation": l.status.Generation, "error": err.Error()})
+		log.Printf("config reload failed, keeping generation %d: %v", l.status.Generation, err)
+		return err
+	}
+	l.current = next
+	l.status.Generation++
+	l.status.LoadedAt = now
+	l.status.LastError = ""
+	l.status.RestartRequired = restartRequired(old.cfg, next.cfg)
+	generation, restart := l.status.Generation, l.status.RestartRequired
+	l.mu.Unlock()
+
+	next.flags.setConfig(next.cfg.Features.Flags)
+	next.metrics.inc(configReloadsMetric, "outcome", "ok")
+	event := map[string]interface{}{"generation": generation}
+	if len(restart) > 0 {
+		event["restartRequired"] = restart
+	}
+	next.publish(EventConfigReloaded, event)
+	if next.toolsFingerprint() != old.toolsFingerprint() {
+		next.publish(EventToolsChanged, map[string]interface{}{"generation": generation})
+	}
+	log.Printf("config reloaded (generation %d)", generation)
+	if len(restart) > 0 {
+		log.Printf("config reload: changes to %v take effect after a restart", restart)
+	}
+	return nil
+}
+
+func (l *liveServer) build(old *MCPServer) (*MCPServer, error) {
+	cfg, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	return l.buildFrom(old, cfg)
+}
+
+// buildFrom configures a new generation for cfg.
+func (l *liveServer) buildFrom(old *MCPServer, cfg *Config) (*MCPServer, error) {
+	l.opts.apply(cfg)
+	next := old.newGeneration(cfg)
+	if err := next.configure(); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (l *liveServer) reloadStatus() ReloadStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.status
+}
+
+// newGeneration returns an unconfigured server for cfg that shares s's
+// long-lived components.
+func (s *MCPServer) newGeneration(cfg *Config) *MCPServer {
+	return &MCPServer{
+		cfg:         cfg,
+		tools:       make(map[string]Tool),
+		handlers:    make(map[string]ToolHandler),
+		sessions:    s.sessions,
+		scratch:     s.scratch,
+		mqtt:        s.mqtt,
+		flags:       s.flags,
+		metrics:     s.metrics,
+		usage:       s.usage,
+		accounting:  s.accounting,
+		notifier:    s.notifier,
+		jobs:        s.jobs,
+		audit:       s.audit,
+		store:       s.store,
+		telemetry:   s.telemetry,
+		memory:      s.memory,
+		shedder:     s.shedder,
+		custom:      s.custom,
+		subscribers: s.subscribers,
+		services:    s.services,
+		canaries:    s.canaries,
+		elicitor:    s.elicitor,
+		approvals:   s.approvals,
+		logins:      s.logins,
+		nonces:      s.nonces,
+		clients:     s.clients,
+		calls:       s.calls,
+		toolLists:   newToolListCache(),
+		live:        s.live,
+	}
+}
+
+// restartRequired lists config sections that differ between old and next
+// but are only read at start-up.
+func restartRequired(old, next *Config) []string {
+	// The body limit is read per request.
+	oldServer, nextServer := old.Server, next.Server
+	oldServer.MaxBodyBytes, nextServer.MaxBodyBytes = "", ""
+	sections := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"memory", old.Memory, next.Memory},
+		{"shedding", old.Shedding, next.Shedding},
+		{"sessions", old.Sessions, next.Sessions},
+		{"jobs", old.Jobs, next.Jobs},
+		{"audit", old.Audit, next.Audit},
+		{"retention", old.Retention, next.Retention},
+		{"telemetry", old.Telemetry, next.Telemetry},
+		{"dataDir", old.dataDir(), next.dataDir()},
+		{"store", old.Store, next.Store},
+		{"usage", old.Usage, next.Usage},
+		{"diagnostics", old.Diagnostics, next.Diagnostics},
+		{"tls", old.TLS, next.TLS},
+		{"listen", old.Listen, next.Listen},
+		{"listeners", old.Listeners, next.Listeners},
+		{"proxy", old.Proxy, next.Proxy},
+		{"server", oldServer, nextServer},
+		{"dashboard", old.Dashboard, next.Dashboard},
+		{"policy.requireTLS", old.Policy.RequireTLS, next.Policy.RequireTLS},
+		{"features.remote", old.Features.Remote, next.Features.Remote},
+		{"mqtt", old.MQTT, next.MQTT},
+	}
+	var out []string
+	for _, sec := range sections {
+		if !reflect.DeepEqual(sec.old, sec.new) {
+			out = append(out, sec.name)
+		}
+	}
+	return out
+}
+
+// reloadOnSignal reloads the config on every SIGHUP until ctx is
+// cancelled.
+func (l *liveServer) reloadOnSignal(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ch:
+			l.reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleAdminConfig serves GET /admin/config (reload status) and POST
+// /admin/config/reload.
+func (s *MCPServer) handleAdminConfig(w http.ResponseWriter, r *http.Request, rest string) {
+	if s.live == nil {
+		http.Error(w, "Reload not available", http.StatusNotFound)
+		return
+	}
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.live.reloadStatus())
+	case rest == "reload" && r.Method == http.MethodPost:
+		if err := s.live.reload(); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+				"error":  fmt.Sprintf("reload failed, previous configuration still active: %v", err),
+				"status": s.live.reloadStatus(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, s.live.reloadStatus())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,539 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TemplatesConfig limits render_template.
+type TemplatesConfig struct {
+	// MaxOutputBytes stops rendering at this size (default 1MiB).
+	MaxOutputBytes int `json:"maxOutputBytes,omitempty" schema:"minimum=1"`
+	// Functions allows only these functions and filters; by default all
+	// of templateLibrary is allowed.
+	Functions []string `json:"functions,omitempty"`
+}
+
+const defaultTemplateOutput = 1 << 20
+
+func (c TemplatesConfig) check() error {
+	for _, name := range c.Functions {
+		if _, ok := templateLibrary[name]; !ok {
+			return fmt.Errorf("unknown template function %q", name)
+		}
+	}
+	return nil
+}
+
+// functions returns the allowed part of templateLibrary.
+func (c TemplatesConfig) functions() map[string]templateFunc {
+	if c.Functions == nil {
+		return templateLibrary
+	}
+	allowed := make(map[string]templateFunc, len(c.Functions))
+	for _, name := range c.Functions {
+		allowed[name] = templateLibrary[name]
+	}
+	return allowed
+}
+
+func (c TemplatesConfig) maxOutput() int {
+	if c.MaxOutputBytes > 0 {
+		return c.MaxOutputBytes
+	}
+	return defaultTemplateOutput
+}
+
+// templateFunc is a function of both template syntaxes. The value it
+// works on comes first, as a Jinja filter receives it; Go templates pass
+// it last, so functions chain in pipelines.
+type templateFunc func(v interface{}, args ...interface{}) (interface{}, error)
+
+// templateLibrary holds functions that only compute on their arguments:
+// none reads files, the environment or the network.
+var templateLibrary = map[string]templateFunc{
+	"upper": stringFunc(strings.ToUpper),
+	"lower": stringFunc(strings.ToLower),
+	"trim":  stringFunc(strings.TrimSpace),
+	"title": stringFunc(func(s string) string {
+		prev := ' '
+		return strings.Map(func(r rune) rune {
+			defer func() { prev = r }()
+			if unicode.IsSpace(prev) || prev == '-' {
+				return unicode.ToTitle(r)
+			}
+			return unicode.ToLower(r)
+		}, s)
+	}),
+	"capitalize": stringFunc(func(s string) string {
+		r, n := utf8.DecodeRuneInString(s)
+		return string(unicode.ToTitle(r)) + strings.ToLower(s[n:])
+	}),
+	"escape":    stringFunc(html.EscapeString),
+	"urlencode": stringFunc(url.QueryEscape),
+	"b64encode": stringFunc(func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }),
+	"b64decode": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		b, err := base64.StdEncoding.DecodeString(templateString(v))
+		return string(b), err
+	},
+	"quote": stringFunc(strconv.Quote),
+	"replace": func(v interface{}, args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, errors.New("replace takes the old and new text")
+		}
+		return strings.ReplaceAll(templateString(v), templateString(args[0]), templateString(args[1])), nil
+	},
+	"split": func(v interface{}, args ...interface{}) (interface{}, error) {
+		sep := ""
+		if len(args) > 0 {
+			sep = templateString(args[0])
+		}
+		var parts []string
+		if sep == "" {
+			parts = strings.Fields(templateString(v))
+		} else {
+			parts = strings.Split(templateString(v), sep)
+		}
+		out := make([]interface{}, len(parts))
+		for i, p := range parts {
+			out[i] = p
+		}
+		return out, nil
+	},
+	"join": func(v interface{}, args ...interface{}) (interface{}, error) {
+		sep := ""
+		if len(args) > 0 {
+			sep = templateString(args[0])
+		}
+		list, err := templateList(v)
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, len(list))
+		for i, item := range list {
+			parts[i] = templateString(item)
+		}
+		return strings.Join(parts, sep), nil
+	},
+	"indent": func(v interface{}, args ...interface{}) (interface{}, error) {
+		n := 4
+		if len(args) > 0 {
+			n = int(templateNumber(args[0]))
+		}
+		pad := strings.Repeat(" ", templateIndent(n))
+		lines := strings.Split(templateString(v), "\n")
+		for i := 1; i < len(lines); i++ {
+			if lines[i] != "" {
+				lines[i] = pad + lines[i]
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	},
+	"truncate": func(v interface{}, args ...interface{}) (interface{}, error) {
+		s, n := templateString(v), 255
+		if len(args) > 0 {
+			n = int(templateNumber(args[0]))
+		}
+		if utf8.RuneCountInString(s) <= n {
+			return s, nil
+		}
+		return string([]rune(s)[:max(n-1, 0)]) + "…", nil
+	},
+	"default": func(v interface{}, args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("default takes the default value")
+		}
+		if v == nil || v == jinjaUndefined || v == "" {
+			return args[0], nil
+		}
+		return v, nil
+	},
+	"length": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case string:
+			return float64(utf8.RuneCountInString(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		list, err := templateList(v)
+		return float64(len(list)), err
+	},
+	"first": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		list, err := templateList(v)
+		if err != nil || len(list) == 0 {
+			return nil, err
+		}
+		return list[0], nil
+	},
+	"last": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		list, err := templateList(v)
+		if err != nil || len(list) == 0 {
+			return nil, err
+		}
+		return list[len(list)-1], nil
+	},
+	"reverse": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		if s, ok := v.(string); ok {
+			r := []rune(s)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r), nil
+		}
+		list, err := templateList(v)
+		out := make([]interface{}, len(list))
+		for i, item := range list {
+			out[len(list)-1-i] = item
+		}
+		return out, err
+	},
+	"sort": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		list, err := templateList(v)
+		out := append([]interface{}(nil), list...)
+		sort.SliceStable(out, func(i, j int) bool { return templateCompare(out[i], out[j]) < 0 })
+		return out, err
+	},
+	"keys": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("keys of %s", templateType(v))
+		}
+		return templateKeys(m), nil
+	},
+	"tojson": func(v interface{}, args ...interface{}) (interface{}, error) {
+		var b []byte
+		var err error
+		if len(args) > 0 {
+			b, err = json.MarshalIndent(templateJSON(v), "", strings.Repeat(" ", templateIndent(int(templateNumber(args[0])))))
+		} else {
+			b, err = json.Marshal(templateJSON(v))
+		}
+		return string(b), err
+	},
+	"int": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		return math.Trunc(templateNumber(v)), nil
+	},
+	"float": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		return templateNumber(v), nil
+	},
+	"string": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		return templateString(v), nil
+	},
+	"abs": func(v interface{}, _ ...interface{}) (interface{}, error) {
+		return math.Abs(templateNumber(v)), nil
+	},
+	"round": func(v interface{}, args ...interface{}) (interface{}, error) {
+		scale := 1.0
+		if len(args) > 0 {
+			scale = math.Pow(10, math.Trunc(templateNumber(args[0])))
+		}
+		return math.Round(templateNumber(v)*scale) / scale, nil
+	},
+}
+
+// templateIndent bounds an indentation width.
+func templateIndent(n int) int {
+	return min(max(n, 0), 64)
+}
+
+func stringFunc(fn func(string) string) templateFunc {
+	return func(v interface{}, _ ...interface{}) (interface{}, error) {
+		return fn(templateString(v)), nil
+	}
+}
+
+// templateString renders a value as both syntaxes print it: numbers
+// without needless decimals, null and missing values as nothing, and
+// lists and objects as JSON.
+func templateString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case safeString:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}, map[string]interface{}:
+		b, _ := json.Marshal(templateJSON(v))
+		return string(b)
+	}
+	if v == jinjaUndefined {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// templateJSON strips the template engines' own types from v.
+func templateJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case safeString:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = templateJSON(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = templateJSON(item)
+		}
+		return out
+	}
+	if v == jinjaUndefined {
+		return nil
+	}
+	return v
+}
+
+func templateNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string, safeString:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(templateString(v)), 64)
+		return f
+	}
+	return 0
+}
+
+func templateList(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return templateKeys(v), nil
+	}
+	if v == jinjaUndefined {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = rv.Index(i).Interface()
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s is not a list", templateType(v))
+}
+
+// templateKeys lists the keys of m in order.
+func templateKeys(m map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i] = k
+	}
+	return out
+}
+
+// templateCompare orders numbers numerically and everything else as text.
+func templateCompare(a, b interface{}) int {
+	fa, aNum := a.(float64)
+	fb, bNum := b.(float64)
+	if aNum && bNum {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(templateString(a), templateString(b))
+}
+
+func templateType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string, safeString:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	if v == jinjaUndefined {
+		return "an undefined value"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// templateOutput collects rendered text, failing once it passes its
+// limit or the call's context ends.
+type templateOutput struct {
+	ctx context.Context
+	buf strings.Builder
+	max int
+}
+
+var errTemplateOutput = errors.New("template output exceeds the size limit")
+
+func (o *templateOutput) Write(p []byte) (int, error) {
+	if err := o.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if o.buf.Len()+len(p) > o.max {
+		return 0, fmt.Errorf("%w of %d bytes", errTemplateOutput, o.max)
+	}
+	return o.buf.Write(p)
+}
+
+func (o *templateOutput) WriteString(s string) (int, error) {
+	return o.Write([]byte(s))
+}
+
+type renderTemplateArgs struct {
+	Template string                 `json:"template" jsonschema:"required,description=Template text"`
+	Syntax   string                 `json:"syntax,omitempty" jsonschema:"enum=go|jinja,description=Template language (default go)"`
+	Data     map[string]interface{} `json:"data,omitempty" jsonschema:"description=Values the template refers to"`
+	Escape   string                 `json:"escape,omitempty" jsonschema:"enum=none|html,description=Escape inserted values for HTML (default none)"`
+}
+
+func (s *MCPServer) setupTemplateTool() {
+	render, renderHandler, _ := typedTool("render_template", "Render a Go or Jinja-style template with the given data, for example to write a config file or an email", s.renderTemplateTool)
+	render.Annotations = readOnlyAnnotations()
+	s.addTool(render, renderHandler)
+}
+
+func (s *MCPServer) renderTemplateTool(ctx context.Context, args renderTemplateArgs) (string, error) {
+	out := &templateOutput{ctx: ctx, max: s.cfg.Templates.maxOutput()}
+	funcs := s.cfg.Templates.functions()
+	data := map[string]interface{}{}
+	for k, v := range args.Data {
+		data[k] = v
+	}
+	var err error
+	if args.Syntax == "jinja" {
+		err = renderJinja(ctx, args.Template, data, funcs, args.Escape == "html", out)
+	} else {
+		err = renderGoTemplate(ctx, args.Template, data, funcs, args.Escape == "html", out)
+	}
+	if err != nil {
+		return "", err
+	}
+	return out.buf.String(), nil
+}
+
+// renderGoTemplate renders with text/template, or html/template, which
+// escapes by context, for HTML. Library functions take their value last.
+// Go templates do not see ctx, so every range body and template starts by
+// counting a step, as in Jinja templates, and stops once ctx is done.
+func renderGoTemplate(ctx context.Context, text string, data map[string]interface{}, funcs map[string]templateFunc, escapeHTML bool, out *templateOutput) error {
+	fm := make(map[string]interface{}, len(funcs)+1)
+	steps := 0
+	fm[stepFunc] = func() (string, error) {
+		steps++
+		if steps > maxJinjaSteps {
+			return "", errors.New("template takes too many steps")
+		}
+		if steps%1024 == 0 {
+			return "", ctx.Err()
+		}
+		return "", nil
+	}
+	for name, fn := range funcs {
+		fn := fn
+		fm[name] = func(args ...interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s needs a value", name)
+			}
+			return fn(args[len(args)-1], args[:len(args)-1]...)
+		}
+	}
+	if escapeHTML {
+		t, err := htmltemplate.New("template").Option("missingkey=error").Funcs(fm).Parse(text)
+		if err != nil {
+			return err
+		}
+		for _, tt := range t.Templates() {
+			countSteps(tt.Tree)
+		}
+		return t.Execute(out, data)
+	}
+	t, err := template.New("template").Option("missingkey=error").Funcs(fm).Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, tt := range t.Templates() {
+		countSteps(tt.Tree)
+	}
+	return t.Execute(out, data)
+}
+
+// stepFunc is the function renderGoTemplate calls to count a step.
+const stepFunc = "_step"
+
+// stepAction is {{_step}}, copied into the templates by countSteps.
+var stepAction = template.Must(template.New("step").Funcs(template.FuncMap{stepFunc: func() string { return "" }}).Parse("{{" + stepFunc + "}}")).Tree.Root.Nodes[0]
+
+// countSteps makes the body of every range in tree, and tree itself,
+// start with a call of stepFunc.
+func countSteps(tree *parse.Tree) {
+	if tree == nil || tree.Root == nil {
+		return
+	}
+	var walk func(list *parse.ListNode)
+	walk = func(list *parse.ListNode) {
+		if list == nil {
+			return
+		}
+		for _, n := range list.Nodes {
+			switch n := n.(type) {
+			case *parse.IfNode:
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.WithNode:
+				walk(n.List)
+				walk(n.ElseList)
+			case *parse.RangeNode:
+				walk(n.List)
+				walk(n.ElseList)
+				n.List.Nodes = append([]parse.Node{stepAction.Copy()}, n.List.Nodes...)
+			case *parse.ListNode:
+				walk(n)
+			}
+		}
+	}
+	walk(tree.Root)
+	tree.Root.Nodes = append([]parse.Node{stepAction.Copy()}, tree.Root.Nodes...)
+}
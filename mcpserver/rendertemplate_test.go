@@ -0,0 +1,44 @@
+package mcpserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderGoTemplateSteps(t *testing.T) {
+	for _, escape := range []bool{false, true} {
+		for _, tc := range []struct {
+			src, want, err string
+		}{
+			{src: `{{range .items}}{{.}},{{else}}none{{end}}`, want: "a,b,"},
+			{src: `{{define "x"}}[{{.}}]{{end}}{{range 3}}{{template "x" .}}{{end}}`, want: "[0][1][2]"},
+			{src: `{{if true}}{{range 2}}{{with 1}}{{range 2}}.{{end}}{{end}}{{end}}{{end}}`, want: "...."},
+			{src: `{{range 100000}}{{range 100000}}{{end}}{{end}}`, err: "too many steps"},
+		} {
+			out := &templateOutput{ctx: context.Background(), max: 1 << 20}
+			err := renderGoTemplate(context.Background(), tc.src, map[string]interface{}{"items": []interface{}{"a", "b"}}, templateLibrary, escape, out)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("%s (escape %v): err = %v, want %s", tc.src, escape, err, tc.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s (escape %v): %v", tc.src, escape, err)
+			} else if got := out.buf.String(); got != tc.want {
+				t.Errorf("%s (escape %v) = %q, want %q", tc.src, escape, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestRenderGoTemplateCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := &templateOutput{ctx: ctx, max: 1 << 20}
+	err := renderGoTemplate(ctx, `{{range 1000}}{{range 1000}}{{end}}{{end}}`, nil, templateLibrary, false, out)
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("err = %v", err)
+	}
+}
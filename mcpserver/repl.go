@@ -0,0 +1,637 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// replConn is a connection to an MCP server over one transport.
+type replConn interface {
+	call(ctx context.Context, method string, params interface{}) (json.RawMessage, error)
+	notify(ctx context.Context, method string) error
+	close()
+}
+
+// httpConn speaks streamable HTTP, over TCP or a Unix socket.
+type httpConn struct {
+	client  *mcpClient
+	session string
+}
+
+func (c *httpConn) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	reply, err := c.client.call(ctx, c.session, method, params)
+	if msg := failure(reply, err); msg != "" {
+		return nil, errors.New(msg)
+	}
+	if method == "initialize" {
+		c.session = reply.Session
+	}
+	return reply.Result, nil
+}
+
+func (c *httpConn) notify(ctx context.Context, method string) error {
+	_, err := c.client.notify(ctx, c.session, method)
+	return err
+}
+
+func (c *httpConn) close() {
+	c.client.endSession(c.session)
+}
+
+// stdioConn runs a server as a subprocess and exchanges newline-delimited
+// JSON-RPC messages over its stdin and stdout.
+type stdioConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	nextID int64
+}
+
+func dialStdio(command string) (*stdioConn, error) {
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		return nil, errors.New("empty command")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &stdioConn{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 1<<20)}, nil
+}
+
+func (c *stdioConn) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	c.nextID++
+	id := c.nextID
+	msg := map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	if err := c.write(msg); err != nil {
+		return nil, err
+	}
+	// Skip notifications and requests from the server until the reply.
+	for {
+		line, err := c.stdout.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("server closed its output: %w", err)
+		}
+		var resp struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Error  *JSONRPCError   `json:"error"`
+		}
+		if json.Unmarshal(line, &resp) != nil || resp.Method != "" || string(resp.ID) != strconv.FormatInt(id, 10) {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("JSON-RPC error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+		return resp.Result, nil
+	}
+}
+
+func (c *stdioConn) notify(ctx context.Context, method string) error {
+	return c.write(map[string]string{"jsonrpc": "2.0", "method": method})
+}
+
+func (c *stdioConn) write(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (c *stdioConn) close() {
+	c.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		c.cmd.Process.Kill()
+	}
+}
+
+// replTool is a tool as listed by the server.
+type replTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// repl is the interactive shell.
+type repl struct {
+	conn  replConn
+	in    lineReader
+	out   io.Writer
+	mu    sync.Mutex // guards tools, read by tab completion
+	tools map[string]replTool
+}
+
+var replCommands = []string{"help", "tools", "describe", "call", "raw", "reload", "quit", "exit"}
+
+// runREPLCommand implements the "repl" subcommand.
+func runREPLCommand(args []string) int {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	target := fs.String("target", defaultTarget(), "MCP endpoint URL")
+	socket := fs.String("socket", "", "connect to the server's Unix socket instead of -target")
+	command := fs.String("command", "", "run this MCP server command and talk to it over stdio")
+	apiKey := fs.String("api-key", os.Getenv("MCP_API_KEY"), "API key sent as a bearer token (default $MCP_API_KEY)")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var conn replConn
+	switch {
+	case *command != "":
+		c, err := dialStdio(*command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "repl: %v\n", err)
+			return 1
+		}
+		conn = c
+	case *socket != "":
+		path := *socket
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}}
+		conn = &httpConn{client: &mcpClient{target: "http://localhost/mcp", apiKey: *apiKey, http: client}}
+	default:
+		transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure}}
+		conn = &httpConn{client: &mcpClient{target: *target, apiKey: *apiKey, http: &http.Client{Transport: transport}}}
+	}
+	defer conn.close()
+
+	r := &repl{conn: conn, out: os.Stdout, tools: map[string]replTool{}}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "repl: %v\n", err)
+			return 1
+		}
+		defer term.Restore(fd, state)
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		t.AutoCompleteCallback = r.complete
+		r.in, r.out = termReader{t}, t
+	} else {
+		r.in = scanReader{bufio.NewScanner(os.Stdin)}
+	}
+
+	ctx := context.Background()
+	result, err := conn.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": doctorProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "mcp-server-repl", "version": "1.0.0"},
+	})
+	if err != nil {
+		fmt.Fprintf(r.out, "initialize failed: %v\n", err)
+		return 1
+	}
+	conn.notify(ctx, "notifications/initialized")
+	var info struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	json.Unmarshal(result, &info)
+	fmt.Fprintf(r.out, "Connected to %s %s (protocol %s)\n", info.ServerInfo.Name, info.ServerInfo.Version, info.ProtocolVersion)
+	if err := r.reload(ctx); err != nil {
+		fmt.Fprintf(r.out, "tools/list failed: %v\n", err)
+	}
+	fmt.Fprintln(r.out, `Type "help" for commands; Tab completes tool names.`)
+	r.loop(ctx)
+	return 0
+}
+
+func (r *repl) loop(ctx context.Context) {
+	for {
+		line, err := r.in.readLine("mcp> ")
+		if err != nil {
+			return
+		}
+		cmd, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		rest = strings.TrimSpace(rest)
+		switch cmd {
+		case "":
+		case "quit", "exit":
+			return
+		case "help":
+			fmt.Fprint(r.out, replHelp)
+		case "tools":
+			r.listTools()
+		case "reload":
+			if err := r.reload(ctx); err != nil {
+				fmt.Fprintf(r.out, "error: %v\n", err)
+			}
+		case "describe":
+			r.describe(rest)
+		case "raw":
+			method, params, _ := strings.Cut(rest, " ")
+			r.raw(ctx, method, strings.TrimSpace(params))
+		case "call":
+			name, args, _ := strings.Cut(rest, " ")
+			r.callTool(ctx, name, strings.TrimSpace(args))
+		default:
+			if _, ok := r.tool(cmd); ok {
+				r.callTool(ctx, cmd, rest)
+			} else {
+				fmt.Fprintf(r.out, "unknown command %q; type \"help\"\n", cmd)
+			}
+		}
+	}
+}
+
+const replHelp = `Commands:
+  tools                   list the tools
+  describe <tool>         show a tool's description and arguments
+  call <tool> [json]      call a tool; without JSON, prompt for each argument
+  <tool> [json]           same as call
+  raw <method> [json]     send any JSON-RPC request
+  reload                  list the tools again
+  quit                    leave
+`
+
+func (r *repl) reload(ctx context.Context) error {
+	tools := map[string]replTool{}
+	var params interface{}
+	for {
+		result, err := r.conn.call(ctx, "tools/list", params)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Tools      []replTool `json:"tools"`
+			NextCursor string     `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(result, &page); err != nil {
+			return err
+		}
+		for _, t := range page.Tools {
+			tools[t.Name] = t
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params = map[string]string{"cursor": page.NextCursor}
+	}
+	r.mu.Lock()
+	r.tools = tools
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *repl) tool(name string) (replTool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+func (r *repl) toolNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *repl) listTools() {
+	for _, name := range r.toolNames() {
+		t, _ := r.tool(name)
+		fmt.Fprintf(r.out, "  %-24s %s\n", name, t.Description)
+	}
+}
+
+func (r *repl) describe(name string) {
+	t, ok := r.tool(name)
+	if !ok {
+		fmt.Fprintf(r.out, "unknown tool %q\n", name)
+		return
+	}
+	fmt.Fprintf(r.out, "%s: %s\n", t.Name, t.Description)
+	for _, p := range schemaProperties(t.InputSchema) {
+		fmt.Fprintf(r.out, "  %s\n", p.label())
+		if p.Description != "" {
+			fmt.Fprintf(r.out, "      %s\n", p.Description)
+		}
+	}
+}
+
+// replProperty is an argument of a tool, from its input schema.
+type replProperty struct {
+	Name        string
+	Type        string
+	Description string
+	Enum        []interface{}
+	Default     interface{}
+	Required    bool
+}
+
+func (p replProperty) label() string {
+	s := p.Name
+	if p.Type != "" {
+		s += " (" + p.Type + ")"
+	}
+	if p.Required {
+		s += " required"
+	}
+	if len(p.Enum) > 0 {
+		s += fmt.Sprintf(" one of %v", p.Enum)
+	}
+	if p.Default != nil {
+		s += fmt.Sprintf(" default %v", p.Default)
+	}
+	return s
+}
+
+// schemaProperties lists the properties of an object schema, required
+// ones first.
+func schemaProperties(schema map[string]interface{}) []replProperty {
+	props, _ := schema["properties"].(map[string]interface{})
+	required := map[string]bool{}
+	if list, ok := schema["required"].([]interface{}); ok {
+		for _, name := range list {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+	var out []replProperty
+	for name, v := range props {
+		m, _ := v.(map[string]interface{})
+		p := replProperty{Name: name, Required: required[name], Default: m["default"]}
+		p.Type, _ = m["type"].(string)
+		p.Description, _ = m["description"].(string)
+		p.Enum, _ = m["enum"].([]interface{})
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Required != out[j].Required {
+			return out[i].Required
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func (r *repl) callTool(ctx context.Context, name, rawArgs string) {
+	t, ok := r.tool(name)
+	if !ok {
+		fmt.Fprintf(r.out, "unknown tool %q\n", name)
+		return
+	}
+	var args interface{}
+	if rawArgs != "" {
+		if err := json.Unmarshal([]byte(rawArgs), &args); err != nil {
+			fmt.Fprintf(r.out, "invalid JSON arguments: %v\n", err)
+			return
+		}
+	} else {
+		prompted, err := r.promptArguments(t)
+		if err != nil {
+			fmt.Fprintf(r.out, "cancelled: %v\n", err)
+			return
+		}
+		args = prompted
+	}
+	start := time.Now()
+	result, err := r.conn.call(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": args})
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	r.printResult(result)
+	fmt.Fprintf(r.out, "(%v)\n", time.Since(start).Round(time.Millisecond))
+}
+
+// promptArguments asks for each argument of t in turn. An empty answer
+// leaves an optional argument out.
+func (r *repl) promptArguments(t replTool) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, p := range schemaProperties(t.InputSchema) {
+		if p.Description != "" {
+			fmt.Fprintf(r.out, "  %s\n", p.Description)
+		}
+		for {
+			line, err := r.in.readLine(p.label() + ": ")
+			if err != nil {
+				return nil, err
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				if p.Required {
+					fmt.Fprintln(r.out, "  required")
+					continue
+				}
+				break
+			}
+			v, err := parseArgument(p.Type, line)
+			if err != nil {
+				fmt.Fprintf(r.out, "  %v\n", err)
+				continue
+			}
+			args[p.Name] = v
+			break
+		}
+	}
+	return args, nil
+}
+
+// parseArgument converts typed input to the JSON type of a property.
+func parseArgument(typ, s string) (interface{}, error) {
+	switch typ {
+	case "string":
+		return s, nil
+	case "integer":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.New("enter an integer")
+		}
+		return n, nil
+	case "number":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, errors.New("enter a number")
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("enter true or false")
+		}
+		return b, nil
+	default:
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			if typ == "" {
+				return s, nil
+			}
+			return nil, errors.New("enter JSON")
+		}
+		return v, nil
+	}
+}
+
+func (r *repl) raw(ctx context.Context, method, rawParams string) {
+	if method == "" {
+		fmt.Fprintln(r.out, "usage: raw <method> [json]")
+		return
+	}
+	var params interface{}
+	if rawParams != "" {
+		if err := json.Unmarshal([]byte(rawParams), &params); err != nil {
+			fmt.Fprintf(r.out, "invalid JSON params: %v\n", err)
+			return
+		}
+	}
+	result, err := r.conn.call(ctx, method, params)
+	if err != nil {
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return
+	}
+	r.printJSON(result)
+}
+
+// printResult shows the text of a tool result, or its JSON otherwise.
+func (r *repl) printResult(result json.RawMessage) {
+	var res struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if json.Unmarshal(result, &res) != nil || len(res.Content) == 0 {
+		r.printJSON(result)
+		return
+	}
+	if res.IsError {
+		fmt.Fprintln(r.out, "tool error:")
+	}
+	for _, c := range res.Content {
+		if c.Type == "text" {
+			fmt.Fprintln(r.out, c.Text)
+		} else {
+			fmt.Fprintf(r.out, "[%s content]\n", c.Type)
+		}
+	}
+}
+
+func (r *repl) printJSON(raw json.RawMessage) {
+	var v interface{}
+	if json.Unmarshal(raw, &v) != nil {
+		fmt.Fprintln(r.out, string(raw))
+		return
+	}
+	out, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintln(r.out, string(out))
+}
+
+// complete is the terminal's tab completion: commands and tool names for
+// the first word, tool names after call and describe.
+func (r *repl) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	var candidates []string
+	word := head
+	if i := strings.LastIndexByte(head, ' '); i >= 0 {
+		first := strings.Fields(head)
+		if len(first) > 2 || len(first) == 2 && strings.HasSuffix(head, " ") || first[0] != "call" && first[0] != "describe" {
+			return "", 0, false
+		}
+		word = head[i+1:]
+		candidates = r.toolNames()
+	} else {
+		candidates = append(append([]string{}, replCommands...), r.toolNames()...)
+	}
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completion += " "
+	}
+	newHead := head[:len(head)-len(word)] + completion
+	return newHead + line[pos:], len(newHead), true
+}
+
+// lineReader reads input lines, showing prompt where there is a terminal.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+type termReader struct{ t *term.Terminal }
+
+func (r termReader) readLine(prompt string) (string, error) {
+	r.t.SetPrompt(prompt)
+	return r.t.ReadLine()
+}
+
+type scanReader struct{ s *bufio.Scanner }
+
+func (r scanReader) readLine(string) (string, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.s.Text(), nil
+}
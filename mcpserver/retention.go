@@ -0,0 +1,164 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"mcp-server/store"
+)
+
+// RetentionConfig bounds how long data is kept, per category, so stored
+// agent activity does not outlive what a data-handling policy allows.
+// Zero keeps a category's default.
+type RetentionConfig struct {
+	// Audit drops spooled audit events not delivered within this long.
+	// By default they are kept until delivered.
+	Audit Duration `json:"audit,omitempty"`
+	// Jobs keeps finished jobs, with their arguments and results, this
+	// long (default 24h).
+	Jobs Duration `json:"jobs,omitempty"`
+	// Memory forgets memory tool values not written for this long; a ttl
+	// given with a value can only shorten it.
+	Memory Duration `json:"memory,omitempty"`
+	// Errors forgets failed calls in /admin/usage/errors after this long.
+	// By default the 50 most recent are kept.
+	Errors Duration `json:"errors,omitempty"`
+}
+
+const (
+	retentionInterval = 10 * time.Minute
+	purgedMetric      = "mcp_retention_purged_total"
+)
+
+// jobRetention is how long finished jobs are kept; jobs.retention is the
+// deprecated spelling.
+func (c *Config) jobRetention() Duration {
+	if c.Retention.Jobs > 0 {
+		return c.Retention.Jobs
+	}
+	return c.Jobs.Retention
+}
+
+// retentionLoop enforces retention until ctx is cancelled. Expired
+// records go too; finished jobs are swept by the job queue itself.
+func (s *MCPServer) retentionLoop(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		s.enforceRetention()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *MCPServer) enforceRetention() {
+	cfg, now := s.cfg.Retention, time.Now()
+	if cfg.Errors > 0 {
+		cutoff := now.Add(-time.Duration(cfg.Errors))
+		n := s.usage.forgetErrors(func(e ToolError) bool { return e.Time.Before(cutoff) })
+		s.metrics.add(purgedMetric, float64(n), "category", "errors")
+	}
+	if s.store == nil {
+		return
+	}
+	ctx, cancel := storeContext()
+	defer cancel()
+	if _, err := s.store.PurgeExpired(ctx); err != nil {
+		log.Printf("store: purging expired records: %v", err)
+	}
+	for _, c := range []struct {
+		category, namespace string
+		window              Duration
+	}{
+		{"audit", nsAudit, cfg.Audit},
+		{"memory", nsMemory, cfg.Memory},
+	} {
+		if c.window <= 0 {
+			continue
+		}
+		n, err := s.store.Purge(ctx, store.Filter{Namespace: c.namespace, UpdatedBefore: now.Add(-time.Duration(c.window))})
+		if err != nil {
+			log.Printf("retention: purging %s: %v", c.category, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("retention: purged %d %s records older than %s", n, c.category, time.Duration(c.window))
+		}
+		s.metrics.add(purgedMetric, float64(n), "category", c.category)
+	}
+}
+
+// purgeRequest selects whose data POST /admin/purge deletes: everything
+// of an API key, or one session and its jobs.
+type purgeRequest struct {
+	Key     string `json:"key,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+// purge deletes the data req selects and returns how much per category.
+func (s *MCPServer) purge(ctx context.Context, req purgeRequest) (map[string]int64, error) {
+	purged := map[string]int64{}
+	if req.Session != "" {
+		ended := s.sessions.purge(func(sess *Session) bool { return sess.ID == req.Session })
+		purged["sessions"] += int64(len(ended))
+		purged["jobs"] += int64(s.jobs.purge(func(job *Job) bool { return job.SessionID == req.Session }))
+	}
+	if req.Key == "" {
+		return purged, nil
+	}
+	ended := s.sessions.purge(func(sess *Session) bool { return sess.KeyName == req.Key })
+	purged["sessions"] += int64(len(ended))
+	purged["jobs"] += int64(s.jobs.purge(func(job *Job) bool { return job.KeyName == req.Key }))
+	purged["errors"] = int64(s.usage.forgetErrors(func(e ToolError) bool { return e.Key == req.Key }))
+	if s.audit != nil {
+		n, err := s.audit.purgeKey(ctx, req.Key)
+		purged["audit"] = int64(n)
+		if err != nil {
+			return purged, err
+		}
+	}
+	if s.store == nil {
+		return purged, nil
+	}
+	for category, ns := range map[string]string{"memory": nsMemory, "idempotency": nsIdempotency, "sessions": nsSessions, "jobs": nsJobs} {
+		// Sessions and jobs were deleted above; this catches records the
+		// server had not loaded.
+		n, err := s.store.Purge(ctx, store.Filter{Namespace: ns, Owner: req.Key})
+		purged[category] += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// handleAdminPurge serves POST /admin/purge.
+func (s *MCPServer) handleAdminPurge(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req purgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Key == "" && req.Session == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "give a key or a session"})
+		return
+	}
+	purged, err := s.purge(r.Context(), req)
+	// Session IDs are bearer tokens and stay out of events.
+	s.publish(EventDataPurged, map[string]interface{}{"key": req.Key, "session": req.Session != "", "purged": purged})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "purged": purged})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purged})
+}
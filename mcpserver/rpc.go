@@ -0,0 +1,198 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// parseRequest decodes and validates a JSON-RPC 2.0 request object read
+// from body. Members are decoded one at a time as they arrive, so only the
+// member being read is buffered and a batch is refused at its first byte.
+// In strict mode, duplicate keys and unknown members are rejected too. On
+// failure the returned request, when non-nil, carries the ID to reply to.
+func parseRequest(body io.Reader, strict bool) (*JSONRPCRequest, *JSONRPCError) {
+	dec := json.NewDecoder(body)
+	parseError := &JSONRPCError{Code: codeParseError, Message: "Parse error"}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, parseError
+	}
+	switch tok {
+	case json.Delim('{'):
+	case json.Delim('['):
+		return nil, invalidRequest("batch requests are not supported")
+	default:
+		if _, ok := tok.(json.Delim); ok {
+			return nil, parseError
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, parseError
+		}
+		return nil, invalidRequest("request must be a JSON object")
+	}
+
+	fields := map[string]json.RawMessage{}
+	var duplicate error
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, parseError
+		}
+		name := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, parseError
+		}
+		if strict && duplicate == nil {
+			if _, ok := fields[name]; ok {
+				duplicate = fmt.Errorf("duplicate key %q at $", name)
+			} else {
+				member := json.NewDecoder(bytes.NewReader(raw))
+				member.UseNumber()
+				duplicate = walkJSON(member, "."+name)
+			}
+		}
+		fields[name] = raw
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, parseError
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, parseError // trailing data
+	}
+	if duplicate != nil {
+		return nil, invalidRequest(duplicate.Error())
+	}
+
+	req := &JSONRPCRequest{}
+	if raw, ok := fields["id"]; ok {
+		switch jsonKind(raw) {
+		case '"', '0':
+			req.ID = raw
+		default:
+			return nil, invalidRequest("id must be a string or number")
+		}
+	}
+
+	var version string
+	if err := json.Unmarshal(fields["jsonrpc"], &version); err != nil || version != "2.0" {
+		return req, invalidRequest(`jsonrpc must be "2.0"`)
+	}
+	req.JSONRPC = version
+
+	_, hasResult := fields["result"]
+	_, hasError := fields["error"]
+	if _, hasMethod := fields["method"]; !hasMethod && (hasResult || hasError) {
+		if req.ID == nil {
+			return req, invalidRequest("response id must be a string or number")
+		}
+		resp := &clientResponse{Result: fields["result"]}
+		if hasError {
+			resp.Error = &JSONRPCError{}
+			if err := json.Unmarshal(fields["error"], resp.Error); err != nil {
+				return req, invalidRequest("error must be an object")
+			}
+		}
+		req.response = resp
+		return req, nil
+	}
+
+	if err := json.Unmarshal(fields["method"], &req.Method); err != nil || req.Method == "" {
+		return req, invalidRequest("method must be a non-empty string")
+	}
+
+	if raw, ok := fields["params"]; ok {
+		if k := jsonKind(raw); k != '{' && k != '[' {
+			return req, invalidRequest("params must be an object or array")
+		}
+		req.Params = raw
+	}
+
+	if strict {
+		for name := range fields {
+			switch name {
+			case "jsonrpc", "id", "method", "params":
+			default:
+				return req, invalidRequest(fmt.Sprintf("unknown member %q", name))
+			}
+		}
+	}
+	return req, nil
+}
+
+// jsonKind classifies a JSON value by its first byte, folding all numbers
+// to '0'. It returns 'n' for null, 't' for booleans and 0 for empty input.
+func jsonKind(raw json.RawMessage) byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return 0
+	}
+	switch c := raw[0]; c {
+	case '{', '[', '"', 'n':
+		return c
+	case 't', 'f':
+		return 't'
+	default:
+		return '0'
+	}
+}
+
+func invalidRequest(reason string) *JSONRPCError {
+	return &JSONRPCError{Code: codeInvalidRequest, Message: "Invalid Request", Data: reason}
+}
+
+// decodeParams unmarshals method params into v. Absent params leave v
+// untouched. In strict mode, fields v does not declare are rejected.
+func decodeParams(raw json.RawMessage, v interface{}, strict bool) *JSONRPCError {
+	if len(raw) == 0 {
+		return nil
+	}
+	decode := json.Unmarshal
+	if strict {
+		decode = decodeStrict
+	}
+	if err := decode(raw, v); err != nil {
+		return invalidParams("%v", err)
+	}
+	return nil
+}
+
+// writeResponse encodes a JSON-RPC response carrying either result or
+// rpcErr. A result that cannot be encoded is replaced by a -32603 error,
+// so the client never gets an empty body; the encoding or write error is
+// returned for logging.
+func writeResponse(w io.Writer, id interface{}, result interface{}, rpcErr *JSONRPCError) error {
+	data, encErr := json.Marshal(&JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Result:  result,
+		Error:   rpcErr,
+	})
+	if encErr != nil {
+		encErr = fmt.Errorf("encoding response: %w", encErr)
+		data, _ = json.Marshal(&JSONRPCResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Error:   &JSONRPCError{Code: codeInternalError, Message: "Internal error", Data: encErr.Error()},
+		})
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing response: %w", err)
+	}
+	return encErr
+}
+
+func invalidParams(format string, args ...interface{}) *JSONRPCError {
+	return &JSONRPCError{Code: codeInvalidParams, Message: "Invalid params", Data: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,44 @@
+package mcpserver
+
+import (
+	"os"
+	"os/exec"
+)
+
+// RunAsConfig names the OS user, and optionally group, that unsandboxed
+// exec backends run as. The server itself then runs as root, while the
+// tools keep only the rights of the unprivileged account.
+type RunAsConfig struct {
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// runAsUser is a resolved RunAsConfig.
+type runAsUser struct {
+	name     string
+	home     string
+	uid, gid uint32
+}
+
+// apply makes cmd run as u. Session scratch directories are created by
+// the server and handed over to u so the tool can write to them.
+func (u *runAsUser) apply(cmd *exec.Cmd, sessionDir string) error {
+	if u == nil {
+		return nil
+	}
+	if sessionDir != "" {
+		if err := os.Chown(sessionDir, int(u.uid), int(u.gid)); err != nil {
+			return err
+		}
+	}
+	setCredential(cmd, u)
+	return nil
+}
+
+// env returns the identity variables of u, replacing the server's.
+func (u *runAsUser) env() []string {
+	if u == nil {
+		return nil
+	}
+	return []string{"USER=" + u.name, "LOGNAME=" + u.name, "HOME=" + u.home}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package mcpserver
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func (c RunAsConfig) resolve() (*runAsUser, error) {
+	if c.User == "" {
+		return nil, nil
+	}
+	return nil, errors.New("runAs is only supported on Unix")
+}
+
+func setCredential(cmd *exec.Cmd, u *runAsUser) {}
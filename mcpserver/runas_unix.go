@@ -0,0 +1,58 @@
+//go:build unix
+
+package mcpserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// resolve looks up the configured user and group. It returns nil when no
+// user is configured.
+func (c RunAsConfig) resolve() (*runAsUser, error) {
+	if c.User == "" {
+		return nil, nil
+	}
+	u, err := user.Lookup(c.User)
+	if _, unknown := err.(user.UnknownUserError); unknown {
+		u, err = user.LookupId(c.User)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("runAs user: %w", err)
+	}
+	gid := u.Gid
+	if c.Group != "" {
+		g, err := user.LookupGroup(c.Group)
+		if _, unknown := err.(user.UnknownGroupError); unknown {
+			g, err = user.LookupGroupId(c.Group)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("runAs group: %w", err)
+		}
+		gid = g.Gid
+	}
+	uidN, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("runAs user: %w", err)
+	}
+	gidN, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("runAs group: %w", err)
+	}
+	if uid := os.Geteuid(); uid != 0 && uint64(uid) != uidN {
+		return nil, fmt.Errorf("runAs user %s: the server must run as root to switch users", u.Username)
+	}
+	return &runAsUser{name: u.Username, home: u.HomeDir, uid: uint32(uidN), gid: uint32(gidN)}, nil
+}
+
+func setCredential(cmd *exec.Cmd, u *runAsUser) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// An empty group list drops the server's supplementary groups.
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: u.uid, Gid: u.gid, Groups: []uint32{}}
+}
@@ -0,0 +1,672 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProjectConfig is a build and test profile for run_build and run_tests:
+// the commands to run in a directory of a workspace root and how to read
+// their results. A preset fills in whatever is left empty.
+type ProjectConfig struct {
+	Name string `json:"name" schema:"required"`
+	// Root is the workspace root; empty selects the only one.
+	Root string `json:"root,omitempty"`
+	// Dir is the root-relative directory the commands run in.
+	Dir    string   `json:"dir,omitempty"`
+	Preset string   `json:"preset,omitempty" schema:"enum=go|npm|cargo|maven|gradle|pytest"`
+	Build  []string `json:"build,omitempty"`
+	Test   []string `json:"test,omitempty"`
+	// Filter is appended to Test when run_tests names tests, with
+	// "{filter}" replaced by the caller's pattern.
+	Filter []string `json:"filter,omitempty"`
+	// Format is how test results are read: "go-json" from go test -json
+	// output, "junit" from the XML files matching Reports, or "text" from
+	// the exit status alone.
+	Format string `json:"format,omitempty" schema:"enum=go-json|junit|text"`
+	// Reports is a glob, relative to Dir, of the JUnit XML files the tests
+	// write. Files older than the run are ignored.
+	Reports string            `json:"reports,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// Timeout bounds each run (default 10m).
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"`
+}
+
+var projectPresets = map[string]ProjectConfig{
+	"go": {
+		Build:  []string{"go", "build", "./..."},
+		Test:   []string{"go", "test", "-json", "./..."},
+		Filter: []string{"-run", "{filter}"},
+		Format: "go-json",
+	},
+	"npm": {
+		Build:  []string{"npm", "run", "build"},
+		Test:   []string{"npm", "test"},
+		Format: "text",
+	},
+	"cargo": {
+		Build:  []string{"cargo", "build"},
+		Test:   []string{"cargo", "test"},
+		Filter: []string{"{filter}"},
+		Format: "text",
+	},
+	"maven": {
+		Build:   []string{"mvn", "-B", "-q", "-DskipTests", "package"},
+		Test:    []string{"mvn", "-B", "test"},
+		Filter:  []string{"-Dtest={filter}"},
+		Format:  "junit",
+		Reports: "target/surefire-reports/TEST-*.xml",
+	},
+	"gradle": {
+		Build:   []string{"./gradlew", "assemble"},
+		Test:    []string{"./gradlew", "test"},
+		Filter:  []string{"--tests", "{filter}"},
+		Format:  "junit",
+		Reports: "build/test-results/test/*.xml",
+	},
+	"pytest": {
+		Test:    []string{"python", "-m", "pytest", "--junitxml=.pytest-results.xml"},
+		Filter:  []string{"-k", "{filter}"},
+		Format:  "junit",
+		Reports: ".pytest-results.xml",
+	},
+}
+
+const (
+	defaultProjectTimeout = 10 * time.Minute
+	// maxRunOutputLines is how much of the end of a run's output is
+	// returned; maxTestOutput bounds the output kept per failed test.
+	maxRunOutputLines = 200
+	maxTestOutput     = 8 << 10
+	maxRunFailures    = 50
+	maxDiagnostics    = 100
+	progressInterval  = 500 * time.Millisecond
+)
+
+// withPreset returns p with empty fields taken from its preset.
+func (p ProjectConfig) withPreset() ProjectConfig {
+	preset := projectPresets[p.Preset]
+	if p.Build == nil {
+		p.Build = preset.Build
+	}
+	if p.Test == nil {
+		p.Test = preset.Test
+	}
+	if p.Filter == nil {
+		p.Filter = preset.Filter
+	}
+	if p.Format == "" {
+		p.Format = preset.Format
+	}
+	if p.Reports == "" {
+		p.Reports = preset.Reports
+	}
+	if p.Format == "" {
+		p.Format = "text"
+	}
+	return p
+}
+
+func (c WorkspaceConfig) checkProjects() error {
+	seen := map[string]bool{}
+	for _, p := range c.Projects {
+		if p.Name == "" {
+			return errors.New("projects need a name")
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("duplicate project %q", p.Name)
+		}
+		seen[p.Name] = true
+		if _, ok := projectPresets[p.Preset]; p.Preset != "" && !ok {
+			return fmt.Errorf("project %q: unknown preset %q", p.Name, p.Preset)
+		}
+		if _, err := c.root(p.Root); err != nil {
+			return fmt.Errorf("project %q: %w", p.Name, err)
+		}
+		p = p.withPreset()
+		if len(p.Build) == 0 && len(p.Test) == 0 {
+			return fmt.Errorf("project %q has neither build nor test command", p.Name)
+		}
+		switch p.Format {
+		case "go-json", "text":
+		case "junit":
+			if p.Reports == "" {
+				return fmt.Errorf("project %q: junit format needs reports", p.Name)
+			}
+			if _, err := filepath.Match(p.Reports, ""); err != nil {
+				return fmt.Errorf("project %q: reports: %w", p.Name, err)
+			}
+		default:
+			return fmt.Errorf("project %q: unknown format %q", p.Name, p.Format)
+		}
+	}
+	return nil
+}
+
+// project returns the project called name; "" selects the only one.
+func (c WorkspaceConfig) project(name string) (ProjectConfig, error) {
+	if name == "" && len(c.Projects) == 1 {
+		return c.Projects[0].withPreset(), nil
+	}
+	for _, p := range c.Projects {
+		if p.Name == name {
+			return p.withPreset(), nil
+		}
+	}
+	if name == "" {
+		return ProjectConfig{}, errors.New("several projects are configured; name one")
+	}
+	return ProjectConfig{}, fmt.Errorf("no project %q", name)
+}
+
+type runBuildArgs struct {
+	Project string `json:"project,omitempty" jsonschema:"description=Project to build; may be left out when only one is configured"`
+}
+
+type runTestsArgs struct {
+	Project string `json:"project,omitempty" jsonschema:"description=Project to test; may be left out when only one is configured"`
+	Filter  string `json:"filter,omitempty" jsonschema:"pattern=^[^-],description=Only run tests matching this pattern\\, in the test runner's own syntax"`
+}
+
+// projectRuns keeps one run per project at a time, since concurrent
+// builds in one directory trip over each other's output files.
+var projectRuns sync.Map // project name -> *sync.Mutex
+
+// runResult is the outcome of a project command.
+type runResult struct {
+	exitCode int
+	took     time.Duration
+	started  time.Time
+	dir      string
+	tail     []string
+	events   *goTestEvents
+}
+
+func (s *MCPServer) runBuildTool(ctx context.Context, args runBuildArgs) (map[string]interface{}, error) {
+	p, err := s.cfg.Workspace.project(args.Project)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Build) == 0 {
+		return nil, fmt.Errorf("project %q has no build command", p.Name)
+	}
+	res, err := s.runProject(ctx, p, p.Build, false)
+	if err != nil {
+		return nil, err
+	}
+	out := res.summary(p, p.Build)
+	out["diagnostics"] = parseDiagnostics(res.tail)
+	return out, nil
+}
+
+func (s *MCPServer) runTestsTool(ctx context.Context, args runTestsArgs) (map[string]interface{}, error) {
+	p, err := s.cfg.Workspace.project(args.Project)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Test) == 0 {
+		return nil, fmt.Errorf("project %q has no test command", p.Name)
+	}
+	argv := append([]string(nil), p.Test...)
+	if args.Filter != "" {
+		if len(p.Filter) == 0 {
+			return nil, fmt.Errorf("project %q does not support test filters", p.Name)
+		}
+		for _, a := range p.Filter {
+			argv = append(argv, strings.ReplaceAll(a, "{filter}", args.Filter))
+		}
+	}
+	res, err := s.runProject(ctx, p, argv, p.Format == "go-json")
+	if err != nil {
+		return nil, err
+	}
+	out := res.summary(p, argv)
+	var tests testSummary
+	switch p.Format {
+	case "go-json":
+		tests = res.events.summary()
+	case "junit":
+		if tests, err = readJUnitReports(filepath.Join(res.dir, filepath.FromSlash(p.Reports)), res.started); err != nil {
+			return nil, err
+		}
+	}
+	if p.Format != "text" {
+		out["tests"] = tests
+		// A run whose tests all passed can still fail to build a package.
+		out["success"] = res.exitCode == 0 && tests.Failed == 0
+	}
+	return out, nil
+}
+
+// runProject runs argv in p's directory, sending its output as progress
+// notifications while it runs. With goJSON, output lines are go test -json
+// events, which are collected and streamed as the test output they carry.
+func (s *MCPServer) runProject(ctx context.Context, p ProjectConfig, argv []string, goJSON bool) (*runResult, error) {
+	mu, _ := projectRuns.LoadOrStore(p.Name, &sync.Mutex{})
+	if !mu.(*sync.Mutex).TryLock() {
+		return nil, fmt.Errorf("project %q is already running", p.Name)
+	}
+	defer mu.(*sync.Mutex).Unlock()
+
+	r, err := s.cfg.Workspace.root(p.Root)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := r.resolve(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultProjectTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var env []string
+	for k, v := range p.Env {
+		env = append(env, k+"="+v)
+	}
+	cmd, err := s.commands.command(ctx, r.Path, r.Writable, dir, argv[0], argv[1:], env...)
+	if err != nil {
+		return nil, err
+	}
+	// Test binaries can outlive a killed go test and hold the pipe open.
+	cmd.WaitDelay = 5 * time.Second
+	pr, pw := io.Pipe()
+	cmd.Stdout, cmd.Stderr = pw, pw
+
+	res := &runResult{started: time.Now(), dir: dir}
+	if goJSON {
+		res.events = newGoTestEvents()
+	}
+	stream := newProgressStream(ToolContextFrom(ctx))
+	defer stream.stop()
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		sc := bufio.NewScanner(pr)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for sc.Scan() {
+			for _, line := range res.consume(sc.Text()) {
+				stream.add(line)
+			}
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return nil, fmt.Errorf("%s: %w", argv[0], err)
+	}
+	err = cmd.Wait()
+	pw.Close()
+	<-read
+	res.took = time.Since(res.started)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s did not finish within %v", argv[0], timeout)
+	}
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exit):
+		res.exitCode = exit.ExitCode()
+	default:
+		return nil, err
+	}
+	return res, nil
+}
+
+// consume records one line of output and returns the lines to show for it.
+func (r *runResult) consume(line string) []string {
+	var shown []string
+	if r.events != nil {
+		if out, ok := r.events.add(line); ok {
+			shown = strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+			if out == "" {
+				shown = nil
+			}
+		} else {
+			shown = []string{line} // build errors are not JSON
+		}
+	} else {
+		shown = []string{line}
+	}
+	r.tail = append(r.tail, shown...)
+	if n := len(r.tail) - maxRunOutputLines; n > 0 {
+		r.tail = append(r.tail[:0:0], r.tail[n:]...)
+	}
+	return shown
+}
+
+func (r *runResult) summary(p ProjectConfig, argv []string) map[string]interface{} {
+	return map[string]interface{}{
+		"project":    p.Name,
+		"command":    argv,
+		"exitCode":   r.exitCode,
+		"success":    r.exitCode == 0,
+		"durationMs": r.took.Milliseconds(),
+		"output":     strings.Join(r.tail, "\n"),
+	}
+}
+
+// progressStream batches output lines into progress notifications, at
+// most one per progressInterval.
+type progressStream struct {
+	tc      *ToolContext
+	mu      sync.Mutex
+	pending []string
+	lines   int
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newProgressStream(tc *ToolContext) *progressStream {
+	st := &progressStream{tc: tc, done: make(chan struct{})}
+	if len(tc.ProgressToken) == 0 {
+		return st
+	}
+	st.stopped.Add(1)
+	go func() {
+		defer st.stopped.Done()
+		tick := time.NewTicker(progressInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				st.flush()
+			case <-st.done:
+				st.flush()
+				return
+			}
+		}
+	}()
+	return st
+}
+
+func (st *progressStream) add(line string) {
+	if len(st.tc.ProgressToken) == 0 {
+		return
+	}
+	st.mu.Lock()
+	st.pending = append(st.pending, line)
+	st.lines++
+	st.mu.Unlock()
+}
+
+func (st *progressStream) flush() {
+	st.mu.Lock()
+	pending, lines := st.pending, st.lines
+	st.pending = nil
+	st.mu.Unlock()
+	if len(pending) > 0 {
+		st.tc.Progress(float64(lines), 0, strings.Join(pending, "\n"))
+	}
+}
+
+func (st *progressStream) stop() {
+	close(st.done)
+	st.stopped.Wait()
+}
+
+// testSummary is the structured result of a test run.
+type testSummary struct {
+	Total    int           `json:"total"`
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"`
+	Skipped  int           `json:"skipped"`
+	Failures []testFailure `json:"failures"`
+	// Packages lists packages that failed without a failing test, such as
+	// ones that did not build.
+	Packages []string `json:"failedPackages,omitempty"`
+}
+
+type testFailure struct {
+	Name       string `json:"name"`
+	Suite      string `json:"suite,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Output     string `json:"output,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+func (t *testSummary) fail(f testFailure) {
+	t.Failed++
+	if len(t.Failures) < maxRunFailures {
+		f.Output = tailString(f.Output, maxTestOutput)
+		t.Failures = append(t.Failures, f)
+	}
+}
+
+// tailString keeps the last n bytes of s.
+func tailString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "…" + s[len(s)-n:]
+}
+
+// goTestEvents collects the events of go test -json.
+type goTestEvents struct {
+	output  map[string]*strings.Builder // package/test -> output
+	results map[string]goTestResult
+	order   []string
+}
+
+type goTestResult struct {
+	pkg, test, action string
+	elapsed           float64
+}
+
+func newGoTestEvents() *goTestEvents {
+	return &goTestEvents{output: map[string]*strings.Builder{}, results: map[string]goTestResult{}}
+}
+
+// add records one line of go test -json and returns the output it
+// carries; ok is false when the line is not an event.
+func (e *goTestEvents) add(line string) (out string, ok bool) {
+	var ev struct {
+		Action  string
+		Package string
+		Test    string
+		Elapsed float64
+		Output  string
+	}
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+		return "", false
+	}
+	key := ev.Package + "\x00" + ev.Test
+	switch ev.Action {
+	case "output":
+		b := e.output[key]
+		if b == nil {
+			b = &strings.Builder{}
+			e.output[key] = b
+		}
+		if b.Len() < 4*maxTestOutput {
+			b.WriteString(ev.Output)
+		}
+		return ev.Output, true
+	case "build-output":
+		// Go 1.24 reports compiler errors as events too.
+		return ev.Output, true
+	case "pass", "fail", "skip":
+		if _, seen := e.results[key]; !seen {
+			e.order = append(e.order, key)
+		}
+		e.results[key] = goTestResult{ev.Package, ev.Test, ev.Action, ev.Elapsed}
+	}
+	return "", true
+}
+
+func (e *goTestEvents) summary() testSummary {
+	sum := testSummary{Failures: []testFailure{}}
+	failedTests := map[string]bool{}
+	for _, key := range e.order {
+		r := e.results[key]
+		if r.test == "" {
+			continue
+		}
+		sum.Total++
+		switch r.action {
+		case "pass":
+			sum.Passed++
+		case "skip":
+			sum.Skipped++
+		case "fail":
+			failedTests[r.pkg] = true
+			// A parent fails with its subtests; only the subtests say why.
+			if e.failedChild(r) {
+				sum.Failed++
+				continue
+			}
+			var out string
+			if b := e.output[key]; b != nil {
+				out = b.String()
+			}
+			sum.fail(testFailure{Name: r.test, Suite: r.pkg, Output: out, DurationMs: int64(r.elapsed * 1000)})
+		}
+	}
+	for _, key := range e.order {
+		if r := e.results[key]; r.test == "" && r.action == "fail" && !failedTests[r.pkg] {
+			sum.Packages = append(sum.Packages, r.pkg)
+		}
+	}
+	return sum
+}
+
+func (e *goTestEvents) failedChild(parent goTestResult) bool {
+	for _, r := range e.results {
+		if r.pkg == parent.pkg && r.action == "fail" && strings.HasPrefix(r.test, parent.test+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// junitSuite decodes both <testsuites> and <testsuite> documents, which
+// may nest.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// readJUnitReports sums up the JUnit XML files matching pattern that were
+// written since the run started.
+func readJUnitReports(pattern string, since time.Time) (testSummary, error) {
+	sum := testSummary{Failures: []testFailure{}}
+	files, _ := filepath.Glob(pattern)
+	sort.Strings(files)
+	read := 0
+	for _, name := range files {
+		// Allow for file systems with coarse modification times.
+		if st, err := os.Stat(name); err != nil || st.ModTime().Before(since.Add(-2*time.Second)) {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return sum, err
+		}
+		var suite junitSuite
+		if err := xml.Unmarshal(data, &suite); err != nil {
+			return sum, fmt.Errorf("%s: %w", filepath.Base(name), err)
+		}
+		suite.addTo(&sum, "")
+		read++
+	}
+	if read == 0 {
+		return sum, fmt.Errorf("the tests wrote no reports matching %s", pattern)
+	}
+	return sum, nil
+}
+
+func (s junitSuite) addTo(sum *testSummary, parent string) {
+	name := s.Name
+	if name == "" {
+		name = parent
+	}
+	for _, child := range s.Suites {
+		child.addTo(sum, name)
+	}
+	for _, c := range s.Cases {
+		sum.Total++
+		suite := c.ClassName
+		if suite == "" {
+			suite = name
+		}
+		secs, _ := strconv.ParseFloat(c.Time, 64)
+		problem := c.Failure
+		if problem == nil {
+			problem = c.Error
+		}
+		switch {
+		case problem != nil:
+			out := strings.TrimSpace(problem.Text)
+			if c.SystemOut != "" {
+				out += "\n" + strings.TrimSpace(c.SystemOut)
+			}
+			sum.fail(testFailure{Name: c.Name, Suite: suite, Message: problem.Message, Output: out, DurationMs: int64(secs * 1000)})
+		case c.Skipped != nil:
+			sum.Skipped++
+		default:
+			sum.Passed++
+		}
+	}
+}
+
+// diagnosticLine matches compiler messages of the file:line[:col]: form
+// most toolchains print.
+var diagnosticLine = regexp.MustCompile(`^\s*([^\s:][^:]*\.[A-Za-z0-9]+):(\d+)(?::(\d+))?:\s*(.+)$`)
+
+type buildDiagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func parseDiagnostics(lines []string) []buildDiagnostic {
+	diags := []buildDiagnostic{}
+	for _, line := range lines {
+		m := diagnosticLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := buildDiagnostic{File: filepath.ToSlash(m[1]), Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if diags = append(diags, d); len(diags) == maxDiagnostics {
+			break
+		}
+	}
+	return diags
+}
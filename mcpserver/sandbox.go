@@ -0,0 +1,102 @@
+package mcpserver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SandboxConfig runs an exec backend in an ephemeral container through a
+// Docker-compatible CLI (docker, podman or nerdctl). The container has no
+// network unless Network says otherwise, and is removed when the call
+// ends or times out. A session's scratch directory is mounted at /work.
+type SandboxConfig struct {
+	Runtime   string  `json:"runtime,omitempty" schema:"enum=docker|podman|nerdctl"` // default docker
+	Image     string  `json:"image" schema:"required"`
+	CPUs      float64 `json:"cpus,omitempty" schema:"minimum=0"`
+	Memory    string  `json:"memory,omitempty" schema:"format=byteSize"`
+	PidsLimit int     `json:"pidsLimit,omitempty" schema:"minimum=0"`
+	Network   string  `json:"network,omitempty"` // default "none"
+	ReadOnly  bool    `json:"readOnlyRootfs,omitempty"`
+	User      string  `json:"user,omitempty"`
+}
+
+// sandboxWorkdir is where a session's scratch directory appears inside
+// the container.
+const sandboxWorkdir = "/work"
+
+// hostOnlyEnv are session variables that name host paths.
+var hostOnlyEnv = []string{"HOME", "TMPDIR", "TMP", "TEMP"}
+
+// command builds the container invocation of b's command. Only env and
+// toolEnv are passed in; the server's own environment stays outside.
+// toolEnv reaches the container by name through the CLI's environment,
+// keeping secret values off its command line.
+func (c *SandboxConfig) command(ctx context.Context, b BackendConfig, argv []string, sessionDir string, env, toolEnv []string) *exec.Cmd {
+	runtime := c.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+	network := c.Network
+	if network == "" {
+		network = "none"
+	}
+	name := "mcp-" + randomID()
+
+	args := []string{"run", "--rm", "-i", "--name", name, "--network", network}
+	if c.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(c.CPUs, 'f', -1, 64))
+	}
+	if c.Memory != "" {
+		if n, err := parseByteSize(c.Memory); err == nil {
+			args = append(args, "--memory", strconv.FormatInt(n, 10))
+		}
+	}
+	if c.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(c.PidsLimit))
+	}
+	if c.ReadOnly {
+		args = append(args, "--read-only", "--tmpfs", "/tmp")
+	}
+	if c.User != "" {
+		args = append(args, "--user", c.User)
+	}
+
+	for _, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); sessionDir != "" && contains(hostOnlyEnv, k) {
+			continue
+		}
+		args = append(args, "-e", kv)
+	}
+	for _, kv := range toolEnv {
+		name, _, _ := strings.Cut(kv, "=")
+		args = append(args, "-e", name)
+	}
+	workdir := b.Dir
+	if sessionDir != "" {
+		args = append(args, "-v", sessionDir+":"+sandboxWorkdir,
+			"-e", "HOME="+sandboxWorkdir, "-e", "TMPDIR="+sandboxWorkdir)
+		if !path.IsAbs(workdir) {
+			workdir = path.Join(sandboxWorkdir, workdir)
+		}
+	}
+	if workdir != "" {
+		args = append(args, "-w", workdir)
+	}
+	args = append(args, c.Image, b.Command)
+	args = append(args, argv...)
+
+	cmd := exec.CommandContext(ctx, runtime, args...)
+	if len(toolEnv) > 0 {
+		cmd.Env = append(os.Environ(), toolEnv...)
+	}
+	// Killing the CLI client does not stop the container; remove it too.
+	cmd.Cancel = func() error {
+		exec.Command(runtime, "rm", "-f", name).Run()
+		return cmd.Process.Kill()
+	}
+	return cmd
+}
@@ -0,0 +1,237 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Zone data is embedded so schedule_task knows IANA zones on hosts
+	// without a zoneinfo database, such as scratch containers.
+	_ "time/tzdata"
+)
+
+// codeResourceNotFound is the JSON-RPC error MCP uses for unknown
+// resource URIs.
+const codeResourceNotFound = -32002
+
+const scheduleURIPrefix = "schedule://tasks/"
+
+type scheduleTaskArgs struct {
+	Tool      string          `json:"tool" jsonschema:"required,description=Tool to call"`
+	Arguments json.RawMessage `json:"arguments,omitempty" jsonschema:"description=Arguments of the call"`
+	Delay     string          `json:"delay,omitempty" jsonschema:"description=How long from now to call the tool\\, e.g. 90s or 2h30m"`
+	At        string          `json:"at,omitempty" jsonschema:"description=When to call the tool: an RFC 3339 time\\, or a wall-clock time like 2026-03-01 09:30 in timezone"`
+	Timezone  string          `json:"timezone,omitempty" jsonschema:"description=IANA time zone of at\\, e.g. Europe/Berlin (default UTC)"`
+}
+
+// wallClockLayouts are the forms of at without a UTC offset, read in the
+// task's time zone.
+var wallClockLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// setupScheduleTools registers schedule_task. Scheduled tasks are jobs, so
+// they are listed and cancelled with the jobs/* methods and read as
+// schedule:// resources. Tasks belong to the API key that scheduled them;
+// without auth every caller would share them, so there is no tool.
+func (s *MCPServer) setupScheduleTools() {
+	if !s.cfg.Auth.enabled() {
+		return
+	}
+	schedule, handler, _ := typedTool("schedule_task", "Call a tool once at a later time, given as a delay or as a time in a time zone; the result is kept as a job", s.scheduleTaskTool)
+	s.addTool(schedule, handler)
+}
+
+func (s *MCPServer) scheduleTaskTool(ctx context.Context, args scheduleTaskArgs) (interface{}, error) {
+	caller := callerFrom(ctx)
+	if caller == nil {
+		caller = &Caller{}
+	}
+	tool := s.resolveTool(args.Tool)
+	// Hidden tools are reported like unknown ones, as by tools/call.
+	if _, ok := s.handlers[tool]; !ok || !s.toolVisible(tool, caller) {
+		return nil, fmt.Errorf("unknown tool %q", args.Tool)
+	}
+	if tool == "schedule_task" {
+		return nil, errors.New("schedule_task cannot schedule itself")
+	}
+	if len(args.Arguments) == 0 || string(args.Arguments) == "null" {
+		args.Arguments = json.RawMessage("{}")
+	}
+	if schema := schemaMap(s.tools[tool].InputSchema); schema != nil {
+		for _, issue := range validateJSON(args.Arguments, schema) {
+			if !issue.Warning {
+				return nil, fmt.Errorf("arguments of %s: %s: %s", tool, issue.Path, issue.Message)
+			}
+		}
+	}
+
+	loc := time.UTC
+	if args.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(args.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", args.Timezone)
+		}
+	}
+	now := time.Now()
+	runAt, err := scheduleTime(args, now, loc)
+	if err != nil {
+		return nil, err
+	}
+	if runAt.Before(now) {
+		return nil, fmt.Errorf("%s is in the past", runAt.In(loc).Format(time.RFC3339))
+	}
+	if max := time.Duration(s.jobs.cfg.MaxDelay); runAt.Sub(now) > max {
+		return nil, fmt.Errorf("tasks can be scheduled at most %s ahead", max)
+	}
+
+	if ToolContextFrom(ctx).DryRun {
+		return dryRunResult("call %s with %s at %s", tool, args.Arguments, runAt.In(loc).Format(time.RFC3339))
+	}
+	job, err := s.jobs.schedule(ctx, caller, tool, args.Arguments, runAt, loc.String())
+	if err != nil {
+		return nil, err
+	}
+	return scheduledTask(job), nil
+}
+
+// scheduleTime resolves the delay or at of args against now.
+func scheduleTime(args scheduleTaskArgs, now time.Time, loc *time.Location) (time.Time, error) {
+	switch {
+	case (args.Delay == "") == (args.At == ""):
+		return time.Time{}, errors.New("give exactly one of delay and at")
+	case args.Delay != "":
+		d, err := time.ParseDuration(args.Delay)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("delay %q: want a positive duration like 90s or 2h30m", args.Delay)
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, args.At); err == nil {
+		return t, nil
+	}
+	for _, layout := range wallClockLayouts {
+		if t, err := time.ParseInLocation(layout, args.At, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("at %q: want an RFC 3339 time or YYYY-MM-DD HH:MM[:SS]", args.At)
+}
+
+// scheduledTask describes a scheduled job in its own time zone: when it
+// runs next, while it waits, and how its run ended, once it has.
+func scheduledTask(job *Job) map[string]interface{} {
+	out := jobStatus(job)
+	out["uri"] = scheduleURIPrefix + job.ID
+	out["arguments"] = job.Arguments
+	loc, err := time.LoadLocation(job.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	out["timezone"] = loc.String()
+	out["localRunAt"] = job.RunAt.In(loc).Format(time.RFC3339)
+	if job.Status == jobScheduled {
+		out["nextRun"] = out["localRunAt"]
+	}
+	if job.done() {
+		last := map[string]interface{}{"status": job.Status}
+		if job.Started != nil {
+			last["startedAt"] = job.Started.In(loc).Format(time.RFC3339)
+		}
+		if job.Finished != nil {
+			last["finishedAt"] = job.Finished.In(loc).Format(time.RFC3339)
+		}
+		if job.Result != nil {
+			last["result"] = job.Result
+		}
+		out["lastResult"] = last
+	}
+	return out
+}
+
+// scheduledJobs returns the caller's scheduled tasks, newest first.
+func (s *MCPServer) scheduledJobs(caller *Caller) []*Job {
+	out := []*Job{}
+	for _, job := range s.jobs.list(caller.KeyName(), false, "") {
+		if job.RunAt != nil {
+			out = append(out, job)
+		}
+	}
+	return out
+}
+
+// handleResourcesMethod serves resources/list and resources/read. The
+// resources are the caller's scheduled tasks, one schedule://tasks/{id}
+// each, and schedule://tasks listing them all, then the MQTT topics with
+// a message.
+func (s *MCPServer) handleResourcesMethod(method string, raw json.RawMessage, caller *Caller, strict bool) (interface{}, *JSONRPCError) {
+	var params struct {
+		URI    string          `json:"uri"`
+		Cursor string          `json:"cursor"`
+		Meta   json.RawMessage `json:"_meta"`
+	}
+	if rpcErr := decodeParams(raw, &params, strict); rpcErr != nil {
+		return nil, rpcErr
+	}
+	jobs := s.scheduledJobs(caller)
+
+	if method == "resources/list" {
+		resources := []map[string]interface{}{{
+			"uri":         strings.TrimSuffix(scheduleURIPrefix, "/"),
+			"name":        "Scheduled tasks",
+			"description": "Your scheduled tool calls with their next run and last result",
+			"mimeType":    "application/json",
+		}}
+		for _, job := range jobs {
+			resources = append(resources, map[string]interface{}{
+				"uri":         scheduleURIPrefix + job.ID,
+				"name":        fmt.Sprintf("%s at %s", job.Tool, job.RunAt.Format(time.RFC3339)),
+				"description": fmt.Sprintf("Scheduled call of %s (%s)", job.Tool, job.Status),
+				"mimeType":    "application/json",
+			})
+		}
+		if s.mqtt != nil {
+			resources = append(resources, s.mqtt.resources()...)
+		}
+		return map[string]interface{}{"resources": resources}, nil
+	}
+
+	if params.URI == "" {
+		return nil, invalidParams("uri is required")
+	}
+	if topic, ok := uriTopic(params.URI); ok && s.mqtt != nil {
+		if content, ok := s.mqtt.read(topic); ok {
+			return map[string]interface{}{"contents": []map[string]interface{}{content}}, nil
+		}
+	}
+	var view interface{}
+	if params.URI == strings.TrimSuffix(scheduleURIPrefix, "/") {
+		tasks := make([]map[string]interface{}, len(jobs))
+		for i, job := range jobs {
+			tasks[i] = scheduledTask(job)
+		}
+		view = map[string]interface{}{"tasks": tasks}
+	} else if id := strings.TrimPrefix(params.URI, scheduleURIPrefix); id != params.URI {
+		job, ok := s.jobs.get(id, caller.KeyName(), false)
+		if ok && job.RunAt != nil {
+			view = scheduledTask(job)
+		}
+	}
+	if view == nil {
+		return nil, &JSONRPCError{Code: codeResourceNotFound, Message: "Resource not found", Data: map[string]string{"uri": params.URI}}
+	}
+	text, _ := json.MarshalIndent(view, "", "  ")
+	return map[string]interface{}{
+		"contents": []map[string]interface{}{{
+			"uri":      params.URI,
+			"mimeType": "application/json",
+			"text":     string(text),
+		}},
+	}, nil
+}
@@ -0,0 +1,158 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	durationType   = reflect.TypeOf(Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// schemaFor derives a JSON Schema from a Go type using its json tags.
+// Struct fields may refine their schema with a `schema` or `jsonschema`
+// tag holding comma-separated options: required, enum=a|b|c (or repeated
+// enum=), minimum=N, maximum=N, minLength=N, maxLength=N, pattern=re,
+// description=text and format=name (the validator knows "duration" and
+// "byteSize"). A comma inside a value is escaped as \\, in the tag. A
+// `validate` tag in the style of go-playground/validator is understood
+// too: required, min=N, max=N, len=N and oneof=a b c.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case durationType:
+		return map[string]interface{}{"type": "string", "format": "duration"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			prop := schemaFor(f.Type)
+			req := applySchemaTag(prop, f.Tag.Get("schema"))
+			req = applySchemaTag(prop, f.Tag.Get("jsonschema")) || req
+			req = applyValidateTag(prop, f.Tag.Get("validate")) || req
+			if req {
+				required = append(required, name)
+			}
+			props[name] = prop
+		}
+		out := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			out["required"] = required
+		}
+		return out
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaFor(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// applySchemaTag adds the options of a `schema` tag to prop and reports
+// whether the field is required.
+func applySchemaTag(prop map[string]interface{}, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	for _, opt := range splitTag(tag) {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "required":
+			required = true
+		case "enum":
+			enum, _ := prop["enum"].([]string)
+			prop["enum"] = append(enum, strings.Split(value, "|")...)
+		case "minimum", "maximum", "minLength", "maxLength":
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				prop[key] = n
+			}
+		case "pattern", "description", "format":
+			prop[key] = value
+		}
+	}
+	return required
+}
+
+// applyValidateTag adds the rules of a `validate` tag to prop and reports
+// whether the field is required. min, max and len bound the value of
+// numbers and the length of strings and arrays.
+func applyValidateTag(prop map[string]interface{}, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	lower, upper := "minimum", "maximum"
+	switch prop["type"] {
+	case "string":
+		lower, upper = "minLength", "maxLength"
+	case "array":
+		lower, upper = "minItems", "maxItems"
+	}
+	for _, opt := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		n, err := strconv.ParseFloat(value, 64)
+		switch {
+		case key == "required":
+			required = true
+		case key == "oneof":
+			prop["enum"] = strings.Fields(value)
+		case key == "min" && err == nil:
+			prop[lower] = n
+		case key == "max" && err == nil:
+			prop[upper] = n
+		case key == "len" && err == nil:
+			prop[lower], prop[upper] = n, n
+		}
+	}
+	return required
+}
+
+// splitTag splits a tag at commas not escaped with a backslash.
+func splitTag(tag string) []string {
+	var opts []string
+	var cur strings.Builder
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			cur.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			opts = append(opts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(tag[i])
+		}
+	}
+	return append(opts, cur.String())
+}
@@ -0,0 +1,527 @@
+package mcpserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ScratchDBConfig limits the per-session SQLite databases of sqlite_exec
+// and sqlite_query.
+type ScratchDBConfig struct {
+	// Disabled leaves the sqlite tools out.
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxSize bounds each database (default 64MiB).
+	MaxSize string `json:"maxSize,omitempty" schema:"format=byteSize"`
+	// Timeout stops a statement that runs longer (default 30s).
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"`
+}
+
+const (
+	defaultScratchDBSize    = 64 << 20
+	defaultScratchDBTimeout = 30 * time.Second
+	defaultScratchDBRows    = 500
+)
+
+func (c ScratchDBConfig) check() error {
+	if c.MaxSize != "" {
+		if _, err := parseByteSize(c.MaxSize); err != nil {
+			return fmt.Errorf("maxSize: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c ScratchDBConfig) maxSize() int64 {
+	if n, err := parseByteSize(c.MaxSize); err == nil && n > 0 {
+		return n
+	}
+	return defaultScratchDBSize
+}
+
+func (c ScratchDBConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return defaultScratchDBTimeout
+}
+
+// scratchDBs holds each session's database: a file in the session's
+// scratch directory, or in memory without a scratch root. It is closed
+// when the session ends.
+type scratchDBs struct {
+	mu  sync.Mutex
+	dbs map[string]*scratchDB
+}
+
+// scratchDB is one connection, used by one statement at a time.
+type scratchDB struct {
+	mu   sync.Mutex
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func newScratchDBs(sessions *sessionStore) *scratchDBs {
+	d := &scratchDBs{dbs: map[string]*scratchDB{}}
+	sessions.onEnd(d.close)
+	return d
+}
+
+func (d *scratchDBs) get(ctx context.Context, sess *Session, cfg ScratchDBConfig) (*scratchDB, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sdb, ok := d.dbs[sess.ID]; ok {
+		return sdb, nil
+	}
+	dsn := ":memory:"
+	if sess.Dir != "" {
+		dir, err := sess.workdir()
+		if err != nil {
+			return nil, err
+		}
+		dsn = "file:" + filepath.Join(dir, "scratch.sqlite")
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// An in-memory database lives as long as its connection.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxIdleTime(0)
+	db.SetConnMaxLifetime(0)
+	conn, err := db.Conn(ctx)
+	if err == nil {
+		err = limitScratchDB(ctx, conn, cfg.maxSize())
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	sdb := &scratchDB{db: db, conn: conn}
+	d.dbs[sess.ID] = sdb
+	return sdb, nil
+}
+
+// limitScratchDB caps the size of the database and of its temporary
+// tables and the length of its values, and allows no attached databases,
+// which would reach the file system.
+func limitScratchDB(ctx context.Context, conn *sql.Conn, size int64) error {
+	var pageSize int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return err
+	}
+	for _, schema := range []string{"main", "temp"} {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s.max_page_count = %d", schema, max(size/pageSize, 1))); err != nil {
+			return err
+		}
+	}
+	if _, err := sqlite.Limit(conn, sqlite3.SQLITE_LIMIT_ATTACHED, 0); err != nil {
+		return err
+	}
+	_, err := sqlite.Limit(conn, sqlite3.SQLITE_LIMIT_LENGTH, int(min(size, 1e9)))
+	return err
+}
+
+func (d *scratchDBs) close(sess *Session) {
+	d.mu.Lock()
+	sdb, ok := d.dbs[sess.ID]
+	delete(d.dbs, sess.ID)
+	d.mu.Unlock()
+	if !ok {
+		return
+	}
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+	sdb.conn.Close()
+	if err := sdb.db.Close(); err != nil {
+		log.Printf("sqlite: closing the database of session %s: %v", sess.ID, err)
+	}
+}
+
+// sqlWords returns the bare words of a statement in upper case and its
+// semicolons, skipping literals, quoted identifiers and comments.
+func sqlWords(stmt string) []string {
+	var words []string
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			i++
+			for i < len(stmt) && stmt[i] != end {
+				i++
+			}
+			i++
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			for i < len(stmt) && stmt[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			if end := strings.Index(stmt[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(stmt)
+			}
+		case c == ';':
+			words = append(words, ";")
+			i++
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i < len(stmt) && (stmt[i] == '_' || stmt[i] == '$' || stmt[i] >= '0' && stmt[i] <= '9' || stmt[i] >= 'A' && stmt[i] <= 'Z' || stmt[i] >= 'a' && stmt[i] <= 'z' || stmt[i] >= 0x80) {
+				i++
+			}
+			words = append(words, strings.ToUpper(stmt[start:i]))
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// forbiddenSQL are the statements that could reach past the session's
+// database or lift its limits: attaching files, changing pragmas and
+// vacuuming into a file.
+var forbiddenSQL = map[string]bool{
+	"ATTACH": true, "DETACH": true, "VACUUM": true,
+}
+
+func checkScratchSQL(stmt string, query bool) error {
+	words := sqlWords(stmt)
+	for len(words) > 0 && words[len(words)-1] == ";" {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 {
+		return errors.New("sql is empty")
+	}
+	for _, w := range words {
+		switch {
+		case w == "PRAGMA":
+			return errors.New("PRAGMA statements are not allowed; query pragma table functions such as pragma_table_info('t') instead")
+		case forbiddenSQL[w]:
+			return fmt.Errorf("%s statements are not allowed", w)
+		case w == ";" && query:
+			return errors.New("sqlite_query runs a single statement")
+		}
+	}
+	if query {
+		switch words[0] {
+		case "SELECT", "WITH", "VALUES", "EXPLAIN":
+		default:
+			return fmt.Errorf("sqlite_query runs SELECT statements; use sqlite_exec for %s", words[0])
+		}
+	}
+	return nil
+}
+
+// sqlParams binds JSON values: whole numbers as integers, objects and
+// lists as JSON text.
+func sqlParams(params []interface{}) []interface{} {
+	out := make([]interface{}, len(params))
+	for i, p := range params {
+		switch v := p.(type) {
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				out[i] = int64(v)
+			} else {
+				out[i] = v
+			}
+		case map[string]interface{}, []interface{}:
+			b, _ := json.Marshal(v)
+			out[i] = string(b)
+		default:
+			out[i] = v
+		}
+	}
+	return out
+}
+
+type sqliteImport struct {
+	Table     string `json:"table" jsonschema:"required,description=Table to load the rows into; created from the header if missing"`
+	Root      string `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path      string `json:"path,omitempty" jsonschema:"description=Root-relative .csv\\, .tsv or .xlsx file"`
+	Sheet     string `json:"sheet,omitempty" jsonschema:"description=Workbook sheet (default the first)"`
+	Content   string `json:"content,omitempty" jsonschema:"description=CSV text with a header row\\, instead of path"`
+	Delimiter string `json:"delimiter,omitempty" jsonschema:"maxLength=1,description=CSV field delimiter"`
+	Replace   bool   `json:"replace,omitempty" jsonschema:"description=Drop the table first if it exists"`
+}
+
+type sqliteExecArgs struct {
+	SQL    string        `json:"sql,omitempty" jsonschema:"description=Statements to run\\, separated by semicolons"`
+	Params []interface{} `json:"params,omitempty" jsonschema:"description=Values for the ? placeholders"`
+	Import *sqliteImport `json:"import,omitempty" jsonschema:"description=Load a CSV file\\, CSV text or sheet into a table before running sql"`
+}
+
+type sqliteQueryArgs struct {
+	SQL    string        `json:"sql" jsonschema:"required,description=SELECT statement"`
+	Params []interface{} `json:"params,omitempty" jsonschema:"description=Values for the ? placeholders"`
+	Limit  int           `json:"limit,omitempty" jsonschema:"minimum=1,maximum=5000,description=Rows to return (default 500)"`
+}
+
+func (s *MCPServer) setupScratchDBTools() {
+	exec, execHandler, _ := typedTool("sqlite_exec", "Create tables, load CSV files or sheets and change data in this session's scratch SQLite database", s.sqliteExecTool)
+	s.addTool(exec, execHandler)
+
+	query, queryHandler, _ := typedTool("sqlite_query", "Run a SELECT against this session's scratch SQLite database and return the rows", s.sqliteQueryTool)
+	query.Annotations = readOnlyAnnotations()
+	s.addTool(query, queryHandler)
+}
+
+func (s *MCPServer) scratchDB(ctx context.Context) (*scratchDB, error) {
+	sess := sessionFrom(ctx)
+	if sess == nil {
+		return nil, errors.New("the scratch database belongs to a session; initialize one first")
+	}
+	return s.scratch.get(ctx, sess, s.cfg.ScratchDB)
+}
+
+func (s *MCPServer) sqliteExecTool(ctx context.Context, args sqliteExecArgs) (interface{}, error) {
+	if args.SQL == "" && args.Import == nil {
+		return nil, errors.New("give sql, import or both")
+	}
+	if args.SQL != "" {
+		if err := checkScratchSQL(args.SQL, false); err != nil {
+			return nil, err
+		}
+	}
+	sdb, err := s.scratchDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ScratchDB.timeout())
+	defer cancel()
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+
+	result := map[string]interface{}{}
+	if args.Import != nil {
+		n, columns, err := s.importScratchTable(ctx, sdb.conn, *args.Import)
+		if err != nil {
+			return nil, scratchError(ctx, err, s.cfg.ScratchDB)
+		}
+		result["imported"] = map[string]interface{}{"table": args.Import.Table, "rows": n, "columns": columns}
+	}
+	if args.SQL != "" {
+		res, err := sdb.conn.ExecContext(ctx, args.SQL, sqlParams(args.Params)...)
+		if err != nil {
+			return nil, scratchError(ctx, err, s.cfg.ScratchDB)
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			result["rowsAffected"] = n
+		}
+		if id, err := res.LastInsertId(); err == nil && id > 0 {
+			result["lastInsertId"] = id
+		}
+	}
+	return result, nil
+}
+
+func (s *MCPServer) sqliteQueryTool(ctx context.Context, args sqliteQueryArgs) (interface{}, error) {
+	if err := checkScratchSQL(args.SQL, true); err != nil {
+		return nil, err
+	}
+	sdb, err := s.scratchDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultScratchDBRows
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ScratchDB.timeout())
+	defer cancel()
+	sdb.mu.Lock()
+	defer sdb.mu.Unlock()
+
+	// Whatever the statement does is rolled back.
+	tx, err := sdb.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, args.SQL, sqlParams(args.Params)...)
+	if err != nil {
+		return nil, scratchError(ctx, err, s.cfg.ScratchDB)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	out := [][]interface{}{}
+	truncated := false
+	for rows.Next() {
+		if len(out) == limit {
+			truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			values[i] = sqlValue(v)
+		}
+		out = append(out, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, scratchError(ctx, err, s.cfg.ScratchDB)
+	}
+	result := map[string]interface{}{"columns": columns, "rows": out}
+	if truncated {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+// sqlValue makes a column value JSON-friendly: text blobs become
+// strings, other blobs base64.
+func sqlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return map[string]string{"base64": base64.StdEncoding.EncodeToString(v)}
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// scratchError explains the errors the limits cause.
+func scratchError(ctx context.Context, err error, cfg ScratchDBConfig) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("statement stopped after %s", cfg.timeout())
+	case strings.Contains(err.Error(), "database or disk is full"):
+		return fmt.Errorf("the scratch database is full at %d bytes; drop tables to make room", cfg.maxSize())
+	}
+	return err
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// importScratchTable loads a spreadsheet into table, creating it from the
+// header row when it does not exist. Columns have no declared type, so
+// values keep the type they were read as.
+func (s *MCPServer) importScratchTable(ctx context.Context, conn *sql.Conn, imp sqliteImport) (int, []string, error) {
+	var sp *spreadsheet
+	var err error
+	switch {
+	case (imp.Path == "") == (imp.Content == ""):
+		return 0, nil, errors.New("import needs either path or content")
+	case imp.Content != "":
+		comma := ','
+		if imp.Delimiter != "" {
+			comma = []rune(imp.Delimiter)[0]
+		}
+		sp = csvSpreadsheet(strings.NewReader(imp.Content), comma, func() error { return nil })
+	default:
+		if sp, err = s.openSpreadsheet(imp.Root, imp.Path, imp.Sheet, imp.Delimiter); err != nil {
+			return 0, nil, err
+		}
+	}
+	defer sp.close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	table := quoteIdent(imp.Table)
+	if imp.Replace {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return 0, nil, err
+		}
+	}
+	var insert *sql.Stmt
+	n := 0
+	t, err := scanSheetTable(sp, sheetRange{}, true, func(t *sheetTable, row int, cells []interface{}) error {
+		if insert == nil {
+			quoted := make([]string, len(t.columns))
+			for i, c := range t.columns {
+				quoted[i] = quoteIdent(c)
+			}
+			cols := strings.Join(quoted, ", ")
+			if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+" ("+cols+")"); err != nil {
+				return err
+			}
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ")
+			var err error
+			if insert, err = tx.PrepareContext(ctx, "INSERT INTO "+table+" ("+cols+") VALUES ("+marks+")"); err != nil {
+				return err
+			}
+		}
+		values := make([]interface{}, len(t.columns))
+		for i := range values {
+			values[i] = sqlCell(cellAt(cells, i))
+		}
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			return fmt.Errorf("row %d: %w", row, err)
+		}
+		n++
+		return nil
+	})
+	if insert != nil {
+		insert.Close()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(t.columns) == 0 {
+		return 0, nil, errors.New("import has no header row")
+	}
+	if insert == nil {
+		quoted := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			quoted[i] = quoteIdent(c)
+		}
+		if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+table+" ("+strings.Join(quoted, ", ")+")"); err != nil {
+			return 0, nil, err
+		}
+	}
+	return n, t.columns, tx.Commit()
+}
+
+// sqlCell stores a spreadsheet cell: numbers as integers or reals and
+// booleans as 1 and 0, as SQLite does.
+func sqlCell(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return v
+}
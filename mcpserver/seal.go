@@ -0,0 +1,186 @@
+package mcpserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups are a header of sealMagic and a random salt, then
+// chunks of at most sealChunk bytes, each sealed with AES-256-GCM under a
+// key derived from the passphrase with scrypt. A chunk is a flag byte, its
+// ciphertext length and the ciphertext; the flag marks the last chunk and
+// is authenticated with it, and the nonce is the chunk's sequence number,
+// so chunks cannot be dropped, reordered or truncated unnoticed. The salt
+// makes every archive's key, and so its nonces, unique.
+const (
+	sealMagic = "MCPBACKUP-SEALED-1\n"
+	sealChunk = 64 << 10
+)
+
+func sealAEAD(pass string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// sealWriter encrypts what is written to it; Close writes the last chunk
+// and must be called.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	seq    uint64
+	buf    []byte
+	closed bool
+}
+
+func newSealWriter(w io.Writer, pass string) (*sealWriter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := sealAEAD(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, sealMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, buf: make([]byte, 0, sealChunk)}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		take := sealChunk - len(s.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		s.buf = append(s.buf, p[:take]...)
+		p, n = p[take:], n+take
+		if len(s.buf) == sealChunk {
+			if err := s.flush(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *sealWriter) flush(last bool) error {
+	flag := []byte{0}
+	if last {
+		flag[0] = 1
+	}
+	ct := s.aead.Seal(nil, sealNonce(s.aead, s.seq), s.buf, flag)
+	s.seq++
+	s.buf = s.buf[:0]
+	var hdr [5]byte
+	hdr[0] = flag[0]
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(ct)))
+	if _, err := s.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(ct)
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+// sealReader decrypts a sealWriter's output.
+type sealReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	done bool
+}
+
+func newSealReader(r io.Reader, pass string) (*sealReader, error) {
+	head := make([]byte, len(sealMagic)+16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	if string(head[:len(sealMagic)]) != sealMagic {
+		return nil, errors.New("not an encrypted backup")
+	}
+	aead, err := sealAEAD(pass, head[len(sealMagic):])
+	if err != nil {
+		return nil, err
+	}
+	s := &sealReader{r: r, aead: aead}
+	// Opening the first chunk now checks the passphrase up front.
+	if err := s.next(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *sealReader) next() error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("encrypted backup is truncated")
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if hdr[0] > 1 || size > sealChunk+uint32(s.aead.Overhead()) {
+		return errors.New("encrypted backup is corrupt")
+	}
+	ct := make([]byte, size)
+	if _, err := io.ReadFull(s.r, ct); err != nil {
+		return errors.New("encrypted backup is truncated")
+	}
+	pt, err := s.aead.Open(ct[:0], sealNonce(s.aead, s.seq), ct, hdr[:1])
+	if err != nil {
+		if s.seq == 0 {
+			return errors.New("wrong passphrase or corrupt backup")
+		}
+		return fmt.Errorf("encrypted backup is corrupt at chunk %d", s.seq)
+	}
+	s.seq++
+	s.buf, s.done = pt, hdr[0] == 1
+	return nil
+}
@@ -0,0 +1,145 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SecretConfig is a named value for tool env, given inline or read from a
+// file, an environment variable of the server, or the output of Command,
+// e.g. ["vault", "kv", "get", "-field=token", "secret/github"]. Secrets
+// are read when the config is loaded or reloaded.
+type SecretConfig struct {
+	Value   string   `json:"value,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Env     string   `json:"env,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// secretCommandTimeout bounds each secret command.
+const secretCommandTimeout = 30 * time.Second
+
+func (c SecretConfig) resolve() (string, error) {
+	sources := 0
+	for _, set := range []bool{c.Value != "", c.File != "", c.Env != "", len(c.Command) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", errors.New("set exactly one of value, file, env and command")
+	}
+	switch {
+	case c.Value != "":
+		return c.Value, nil
+	case c.File != "":
+		data, err := os.ReadFile(c.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case c.Env != "":
+		v, ok := os.LookupEnv(c.Env)
+		if !ok {
+			return "", fmt.Errorf("$%s is not set", c.Env)
+		}
+		return v, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v: %s", c.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// resolveSecrets reads every configured secret.
+func resolveSecrets(secrets map[string]SecretConfig) (map[string]string, error) {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make(map[string]string, len(secrets))
+	for _, name := range names {
+		v, err := secrets[name].resolve()
+		if err != nil {
+			return nil, fmt.Errorf("secret %q: %w", name, err)
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+// expandSecrets replaces ${secret:name} in text with the secret and $NAME
+// or ${NAME} with the server's environment variable.
+func expandSecrets(text string, secrets map[string]string) (string, error) {
+	var missing string
+	out := os.Expand(text, func(v string) string {
+		if secret, ok := strings.CutPrefix(v, "secret:"); ok {
+			value, ok := secrets[secret]
+			if !ok && missing == "" {
+				missing = secret
+			}
+			return value
+		}
+		return os.Getenv(v)
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown secret %q", missing)
+	}
+	return out, nil
+}
+
+// toolEnv expands a backend's env with expandSecrets. Only the variables
+// named in env reach the tool.
+func toolEnv(env map[string]string, secrets map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(env))
+	for name, value := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("env %q: invalid variable name", name)
+		}
+		var err error
+		if out[name], err = expandSecrets(value, secrets); err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+	return out, nil
+}
+
+// envList turns env into NAME=value entries, sorted by name.
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for name, v := range env {
+		list = append(list, name+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// envFuncs gives header templates the env function reading the tool's
+// env. Other templates keep the default, which fails, so secrets do not
+// end up in URLs, bodies or dry-run previews.
+func envFuncs(env map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"env": func(name string) (string, error) {
+			v, ok := env[name]
+			if !ok {
+				return "", fmt.Errorf("env %q is not set for this tool", name)
+			}
+			return v, nil
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package mcpserver
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultHSTS is the Strict-Transport-Security max-age sent when
+// security.hsts is not set.
+const defaultHSTS = 365 * 24 * time.Hour
+
+// htmlCSP confines the dashboard and sign-in pages to their own inline
+// styles and forbids framing them.
+const htmlCSP = "default-src 'none'; style-src 'unsafe-inline'; base-uri 'none'; frame-ancestors 'none'"
+
+// SecurityConfig tunes the headers and checks every HTTP response and
+// request gets. The defaults are strict; each setting loosens one.
+type SecurityConfig struct {
+	// HSTS is the max-age of Strict-Transport-Security on HTTPS responses
+	// (default 1 year); a negative value sends none.
+	HSTS Duration `json:"hsts,omitempty" schema:"format=duration"`
+	// HSTSSubdomains adds includeSubDomains.
+	HSTSSubdomains bool `json:"hstsSubdomains,omitempty"`
+	// AnyContentType accepts POST /mcp bodies not declared as
+	// application/json, for clients that send text/plain.
+	AnyContentType bool `json:"anyContentType,omitempty"`
+	// AllowedOrigins are browser origins, like "https://app.example.com",
+	// that may call a server listening on loopback besides loopback pages
+	// and pages on AllowedHosts; "*" allows any.
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	// AllowedHosts are the names, besides localhost and loopback IPs, a
+	// server listening on loopback answers in the Host header, written
+	// like egress.noProxy. ACME domains are always allowed.
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	// HostCheck is where Host and Origin are checked: "loopback" (the
+	// default) for requests arriving on a loopback address, "always", or
+	// "off".
+	HostCheck string `json:"hostCheck,omitempty" schema:"enum=loopback|always|off"`
+}
+
+func (c SecurityConfig) check() error {
+	switch c.HostCheck {
+	case "", "loopback", "always", "off":
+	default:
+		return fmt.Errorf("hostCheck %q: want loopback, always or off", c.HostCheck)
+	}
+	if err := checkHosts(c.AllowedHosts); err != nil {
+		return fmt.Errorf("allowedHosts: %w", err)
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("allowedOrigins: %q is not an origin like https://app.example.com", o)
+		}
+	}
+	return nil
+}
+
+func (c SecurityConfig) hsts() string {
+	age := defaultHSTS
+	if c.HSTS != 0 {
+		age = time.Duration(c.HSTS)
+	}
+	if age < 0 {
+		return ""
+	}
+	v := fmt.Sprintf("max-age=%d", int64(age.Seconds()))
+	if c.HSTSSubdomains {
+		v += "; includeSubDomains"
+	}
+	return v
+}
+
+// hostAllowed reports whether the Host header host names this server.
+// A rebinding attack arrives with the attacker's domain as its Host.
+func (c SecurityConfig) hostAllowed(host string, extra []string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return loopbackHost(host) || matchHosts(c.AllowedHosts, host) || matchHosts(extra, host)
+}
+
+// originAllowed reports whether a page at origin may call the server.
+// Pages on loopback and on the allowed hosts may, so local browser
+// clients keep working.
+func (c SecurityConfig) originAllowed(origin string, extra []string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && c.hostAllowed(u.Host, extra)
+}
+
+// checksHost reports whether r is subject to the Host and Origin checks.
+func (c SecurityConfig) checksHost(r *http.Request) bool {
+	switch c.HostCheck {
+	case "off":
+		return false
+	case "always":
+		return true
+	}
+	return onLoopback(r)
+}
+
+func loopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// onLoopback reports whether r arrived on a loopback address, where web
+// pages the user visits could reach the server through DNS rebinding.
+func onLoopback(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+// secureHTTP wraps next with the current generation's security settings:
+// response headers for every path, the Host check, and the Content-Type
+// and Origin checks of /mcp and the admin surfaces.
+func (l *liveServer) secureHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := l.server().cfg.Security
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "no-referrer")
+		if v := cfg.hsts(); isHTTPS(r) && v != "" {
+			h.Set("Strict-Transport-Security", v)
+		}
+		surface := inboundSurface(r.URL.Path)
+		if surface == "admin" {
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Content-Security-Policy", htmlCSP)
+		}
+
+		if cfg.checksHost(r) {
+			var acme []string
+			if a := l.server().cfg.TLS.ACME; a != nil {
+				acme = a.Domains
+			}
+			// /health stays reachable under any name for load balancers
+			// and container probes.
+			if r.URL.Path != "/health" && !cfg.hostAllowed(r.Host, acme) {
+				http.Error(w, "Host not allowed", http.StatusForbidden)
+				return
+			}
+			if origin := r.Header.Get("Origin"); origin != "" && (surface == "mcp" || surface == "admin") && !cfg.originAllowed(origin, acme) {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		if surface == "mcp" && r.Method == http.MethodPost && !cfg.AnyContentType {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
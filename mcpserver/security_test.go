@@ -0,0 +1,67 @@
+package mcpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHostAndOriginChecks(t *testing.T) {
+	_, h := testHandler(t, &Config{Security: SecurityConfig{
+		AllowedHosts:   []string{"mcp.internal"},
+		AllowedOrigins: []string{"https://app.example.com"},
+	}})
+	const ping = `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`
+	for _, tc := range []struct {
+		name, path, host, origin, contentType string
+		want                                  int
+	}{
+		{"localhost", "/mcp", "localhost:8080", "", "", http.StatusOK},
+		{"loopback IP", "/mcp", "127.0.0.1:8080", "", "", http.StatusOK},
+		{"allowed host", "/mcp", "mcp.internal", "", "", http.StatusOK},
+		{"rebound host", "/mcp", "attacker.example:8080", "", "", http.StatusForbidden},
+		{"health under any host", "/health", "attacker.example", "", "", http.StatusOK},
+		{"local page", "/mcp", "localhost:8080", "http://localhost:3000", "", http.StatusOK},
+		{"allowed origin", "/mcp", "localhost:8080", "https://app.example.com", "", http.StatusOK},
+		{"foreign origin", "/mcp", "localhost:8080", "https://attacker.example", "", http.StatusForbidden},
+		{"foreign origin on admin", "/admin/usage", "localhost:8080", "https://attacker.example", "", http.StatusForbidden},
+		{"form post", "/mcp", "localhost:8080", "", "text/plain", http.StatusUnsupportedMediaType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRequest("POST", tc.path, "127.0.0.1:5000", ping)
+			if tc.path == "/health" {
+				r.Method = "GET"
+			}
+			r.Host = tc.host
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := serve(h, r)
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("no nosniff header")
+			}
+		})
+	}
+
+	// Off loopback, the Host check applies only with hostCheck always.
+	for check, want := range map[string]int{"": http.StatusOK, "always": http.StatusForbidden} {
+		_, h := testHandler(t, &Config{Security: SecurityConfig{HostCheck: check}})
+		r := httptest.NewRequest("POST", "/mcp", strings.NewReader(ping))
+		r.Host = "attacker.example"
+		r.Header.Set("Content-Type", "application/json")
+		if w := serve(h, r); w.Code != want {
+			t.Errorf("hostCheck %q off loopback: %d, want %d", check, w.Code, want)
+		}
+	}
+
+	if _, err := newConfiguredServer(&Config{Security: SecurityConfig{AllowedOrigins: []string{"app.example.com"}}}); err == nil || !strings.Contains(err.Error(), "not an origin") {
+		t.Errorf("origin without scheme: %v", err)
+	}
+}
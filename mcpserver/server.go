@@ -0,0 +1,788 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+
+	"mcp-server/store"
+)
+
+// MCP Server Types
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      interface{}     `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+
+	// response is set when the message answers a request of the server.
+	response *clientResponse
+}
+
+type JSONRPCResponse struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      interface{}   `json:"id"`
+	Result  interface{}   `json:"result,omitempty"`
+	Error   *JSONRPCError `json:"error,omitempty"`
+}
+
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Simple MCP Server
+type MCPServer struct {
+	cfg         *Config
+	tools       map[string]Tool
+	handlers    map[string]ToolHandler
+	sessions    *sessionStore
+	flags       *flagStore
+	metrics     *metricsRegistry
+	usage       *usageTracker
+	accounting  *accountant
+	notifier    *notifier
+	jobs        *jobQueue
+	memory      *memoryGuard
+	shedder     *loadShedder
+	canaries    *canaryStore
+	elicitor    *elicitor
+	approvals   *approvalQueue
+	logins      *adminLogins
+	clients     *clientTracker
+	calls       *callGroup
+	toolLists   *toolListCache
+	signing     map[string][]byte // HMAC secrets by key name
+	nonces      *nonceCache
+	custom      []customTool
+	services    Services
+	vcrClient   *http.Client
+	webhooks    []*webhook
+	bus         *eventBus
+	audit       *auditExporter
+	store       store.Store
+	telemetry   *telemetry // nil when off
+	code        *codeIndex // nil without workspace roots
+	commands    *workspaceCommands
+	scratch     *scratchDBs
+	mqtt        *mqttBridge // nil until started, or without mqtt config
+	secrets     map[string]string
+	egress      *http.Client // nil without egress config
+	inbound     *inboundACL
+	subscribers []eventSubscriber
+	i18n        *localizer
+	live        *liveServer
+	adminRoutes map[string]adminHandler
+}
+
+type Tool struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	InputSchema interface{}      `json:"inputSchema"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations are behavioral hints about a tool. Deprecated and
+// ReplacedBy extend the MCP-defined hints.
+type ToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    *bool  `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool  `json:"destructiveHint,omitempty"`
+	IdempotentHint  *bool  `json:"idempotentHint,omitempty"`
+	OpenWorldHint   *bool  `json:"openWorldHint,omitempty"`
+	Deprecated      bool   `json:"deprecated,omitempty"`
+	ReplacedBy      string `json:"replacedBy,omitempty"`
+}
+
+// readOnlyAnnotations marks a built-in tool as free of side effects.
+func readOnlyAnnotations() *ToolAnnotations {
+	readOnly := true
+	return &ToolAnnotations{ReadOnlyHint: &readOnly}
+}
+
+// ToolHandler executes a tool call and returns its MCP result.
+type ToolHandler func(ctx context.Context, args json.RawMessage) (interface{}, error)
+
+func NewMCPServer(cfg *Config) *MCPServer {
+	s := &MCPServer{
+		cfg:       cfg,
+		tools:     make(map[string]Tool),
+		handlers:  make(map[string]ToolHandler),
+		sessions:  newSessionStore(cfg.Sessions),
+		flags:     newFlagStore(cfg.Features),
+		metrics:   newMetricsRegistry(),
+		usage:     newUsageTracker(),
+		notifier:  newNotifier(),
+		memory:    newMemoryGuard(cfg.Memory),
+		canaries:  newCanaryStore(),
+		elicitor:  newElicitor(),
+		approvals: newApprovalQueue(),
+		logins:    newAdminLogins(),
+		nonces:    newNonceCache(),
+		clients:   newClientTracker(),
+		calls:     newCallGroup(),
+		toolLists: newToolListCache(),
+		services:  Services{}.withDefaults(),
+	}
+	s.accounting = newAccountant(s.metrics)
+	s.shedder = newLoadShedder(cfg.Shedding, s.metrics)
+	s.scratch = newScratchDBs(s.sessions)
+	jobs := cfg.Jobs
+	jobs.Retention = cfg.jobRetention()
+	s.jobs = newJobQueue(s, jobs)
+	return s
+}
+
+// addTool registers a tool definition together with its handler.
+func (s *MCPServer) addTool(tool Tool, handler ToolHandler) {
+	tool.InputSchema = canonicalSchema(tool.InputSchema)
+	s.tools[tool.Name] = tool
+	s.handlers[tool.Name] = handler
+}
+
+func (s *MCPServer) setupTools() {
+	// Add basic tools
+	s.addTool(Tool{
+		Name:        "system_info",
+		Description: "Get system information",
+		Annotations: readOnlyAnnotations(),
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, systemInfoTool)
+
+	echo, echoHandler, _ := typedTool("echo", "Echo back a message", echoTool)
+	echo.Annotations = readOnlyAnnotations()
+	s.addTool(echo, echoHandler)
+
+	s.addTool(Tool{
+		Name:        "usage_stats",
+		Description: "Get per-tool call counts, error rates, latency percentiles and last use",
+		Annotations: readOnlyAnnotations(),
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"tool": map[string]interface{}{
+					"type":        "string",
+					"description": "Only report this tool",
+				},
+			},
+		},
+	}, s.usageStatsTool)
+
+	s.addTool(Tool{
+		Name:        "server_capabilities",
+		Description: "Report transports, auth mode, tool packages, limits and feature flags of this server",
+		Annotations: readOnlyAnnotations(),
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, s.serverCapabilitiesTool)
+}
+
+// newConfiguredServer builds a server with all tools and subsystems set
+// up from cfg, without starting anything.
+func newConfiguredServer(cfg *Config) (*MCPServer, error) {
+	server := NewMCPServer(cfg)
+	if err := server.configure(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// configure checks s.cfg and registers tools, admin routes, metrics and
+// translations from it. A configured server is not modified afterwards;
+// reloads build a new one.
+func (s *MCPServer) configure() error {
+	if err := checkPolicy(s.cfg); err != nil {
+		return err
+	}
+	if err := s.cfg.TLS.check(); err != nil {
+		return fmt.Errorf("invalid tls config: %w", err)
+	}
+	if size := s.cfg.Server.MaxBodyBytes; size != "" {
+		if _, err := parseByteSize(size); err != nil {
+			return fmt.Errorf("invalid server.maxBodyBytes: %w", err)
+		}
+	}
+	if _, err := s.cfg.Proxy.trustedNets(); err != nil {
+		return fmt.Errorf("invalid proxy config: %w", err)
+	}
+	inbound, err := s.cfg.Inbound.acl()
+	if err != nil {
+		return fmt.Errorf("invalid inbound config: %w", err)
+	}
+	s.inbound = inbound
+	if err := s.cfg.checkListeners(); err != nil {
+		return fmt.Errorf("invalid listeners config: %w", err)
+	}
+	if err := s.cfg.Shedding.check(); err != nil {
+		return fmt.Errorf("invalid shedding config: %w", err)
+	}
+	if err := s.cfg.Security.check(); err != nil {
+		return fmt.Errorf("invalid security config: %w", err)
+	}
+	if err := s.cfg.Admin.Login.check(); err != nil {
+		return fmt.Errorf("invalid admin.login config: %w", err)
+	}
+	if _, _, err := normalizationForm(s.cfg.Text.Normalize); err != nil {
+		return fmt.Errorf("invalid text config: %w", err)
+	}
+	if s.cfg.Hardening.Enabled {
+		if err := hardeningSupported(s.cfg.Hardening); err != nil {
+			return fmt.Errorf("invalid hardening config: %w", err)
+		}
+	}
+	runAs, err := s.cfg.RunAs.resolve()
+	if err != nil {
+		return fmt.Errorf("invalid runAs config: %w", err)
+	}
+	s.commands = &workspaceCommands{hardening: s.cfg.Hardening, runAs: runAs}
+	secrets, err := resolveSecrets(s.cfg.Secrets)
+	if err != nil {
+		return fmt.Errorf("invalid secrets config: %w", err)
+	}
+	s.secrets = secrets
+	if s.cfg.Auth.MintKeys && s.store == nil {
+		return errors.New("invalid auth config: mintKeys needs a state store (dataDir or store)")
+	}
+	if s.signing, err = signingSecrets(s.cfg.Auth.APIKeys, s.secrets); err != nil {
+		return fmt.Errorf("invalid auth config: %w", err)
+	}
+	if s.egress, err = s.cfg.Egress.client(s.services.HTTPClient, s.secrets); err != nil {
+		return fmt.Errorf("invalid egress config: %w", err)
+	}
+	vcr, err := newVCRClient(s.cfg.VCR, s.httpClient())
+	if err != nil {
+		return fmt.Errorf("invalid vcr config: %w", err)
+	}
+	s.vcrClient = vcr
+	if s.webhooks, err = newWebhooks(s.cfg.Webhooks); err != nil {
+		return fmt.Errorf("invalid webhooks config: %w", err)
+	}
+	s.setupTools()
+	if err := s.cfg.Templates.check(); err != nil {
+		return fmt.Errorf("invalid templates config: %w", err)
+	}
+	if err := s.cfg.Query.check(); err != nil {
+		return fmt.Errorf("invalid query config: %w", err)
+	}
+	if err := s.cfg.ScratchDB.check(); err != nil {
+		return fmt.Errorf("invalid scratchDB config: %w", err)
+	}
+	s.setupTemplateTool()
+	s.setupDataTools()
+	s.setupScheduleTools()
+	if !s.cfg.ScratchDB.Disabled {
+		s.setupScratchDBTools()
+	}
+	if s.store != nil {
+		s.setupMemoryTools()
+	}
+	if err := s.cfg.Workspace.check(); err != nil {
+		return fmt.Errorf("invalid workspace config: %w", err)
+	}
+	if s.cfg.Workspace.enabled() {
+		s.setupCodeTools()
+		s.setupSpreadsheetTools()
+		s.setupChecksumTools()
+	}
+	if err := s.cfg.Transfer.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid transfer config: %w", err)
+	}
+	if s.cfg.Transfer.enabled() {
+		s.setupTransferTools()
+	}
+	if err := s.cfg.SNMP.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid snmp config: %w", err)
+	}
+	if s.cfg.SNMP.enabled() {
+		s.setupSNMPTools()
+	}
+	if err := s.cfg.MQTT.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid mqtt config: %w", err)
+	}
+	if s.cfg.MQTT.enabled() {
+		s.setupMQTTTools()
+	}
+	if err := s.cfg.HomeAssistant.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid homeAssistant config: %w", err)
+	}
+	if s.cfg.HomeAssistant.enabled() {
+		s.setupHomeAssistantTools()
+	}
+	if err := s.cfg.Prometheus.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid prometheus config: %w", err)
+	}
+	if s.cfg.Prometheus.enabled() {
+		s.setupPrometheusTools()
+	}
+	if err := s.cfg.Logs.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid logs config: %w", err)
+	}
+	if s.cfg.Logs.enabled() {
+		s.setupLogsTools()
+	}
+	if err := s.cfg.Cloud.check(); err != nil {
+		return fmt.Errorf("invalid cloud config: %w", err)
+	}
+	if s.cfg.Cloud.enabled() {
+		s.setupCloudTools()
+	}
+	if err := s.cfg.Terraform.check(s.secrets); err != nil {
+		return fmt.Errorf("invalid terraform config: %w", err)
+	}
+	if s.cfg.Terraform.enabled() {
+		s.setupTerraformTools()
+	}
+	for _, t := range s.custom {
+		s.addTool(t.tool, t.handler)
+	}
+	s.setupAdmin()
+	s.setupMetrics()
+	s.setupEvents()
+	if err := s.setupI18n(); err != nil {
+		return fmt.Errorf("invalid i18n config: %w", err)
+	}
+	if err := s.loadDeclarativeTools(s.cfg.Tools); err != nil {
+		return fmt.Errorf("failed to load tools: %w", err)
+	}
+	if err := s.applyDeprecations(); err != nil {
+		return fmt.Errorf("failed to apply deprecations: %w", err)
+	}
+	s.gateApprovals()
+	if err := s.cfg.Chaos.check(); err != nil {
+		return fmt.Errorf("invalid chaos config: %w", err)
+	}
+	if err := s.cfg.Telemetry.check(); err != nil {
+		return fmt.Errorf("invalid telemetry config: %w", err)
+	}
+	s.injectChaos()
+	return nil
+}
+
+func (s *MCPServer) handleMCP(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, "+sessionHeader+", "+
+		signatureKeyHeader+", "+signatureTimestampHeader+", "+signatureNonceHeader+", "+signatureHeader)
+	w.Header().Set("Access-Control-Expose-Headers", sessionHeader)
+
+	// Handle preflight
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Handle GET with an event stream - session notifications
+	if r.Method == "GET" && wantsEventStream(r) {
+		caller, ok := s.resolveCaller(w, r)
+		if !ok {
+			return
+		}
+		s.handleEventStream(w, r, caller)
+		return
+	}
+
+	// Handle GET - return server info. Authenticated callers also get
+	// their view of tools, limits and flags.
+	if r.Method == "GET" {
+		info := map[string]interface{}{
+			"name":     "Go MCP Server",
+			"version":  serverVersion,
+			"protocol": "2024-11-05",
+			"capabilities": map[string]interface{}{
+				"tools": map[string]bool{
+					"listChanged": true,
+				},
+				"resources": s.resourceCapabilities(),
+			},
+		}
+		deployment := s.publicCapabilities()
+		if key, err := s.authenticate(r); err == nil {
+			deployment = s.capabilities(&Caller{Key: key})
+		}
+		info["deployment"] = deployment
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+
+	// Handle DELETE - end the session
+	if r.Method == "DELETE" {
+		caller, ok := s.resolveCaller(w, r)
+		if !ok {
+			return
+		}
+		if caller.Session == nil {
+			http.Error(w, sessionHeader+" header required", http.StatusBadRequest)
+			return
+		}
+		if s.sessions.remove(caller.Session.ID) {
+			s.publish(EventSessionClosed, sessionEvent(caller.Session))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Handle POST - JSON-RPC
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w, ok := s.chaosTransport(w)
+	if !ok {
+		return
+	}
+
+	caller, ok := s.resolveCaller(w, r)
+	if !ok {
+		return
+	}
+	key := caller.Key
+	ctx := withTrace(withCaller(r.Context(), caller), traceFromRequest(r))
+	sessionLocale := ""
+	if caller.Session != nil {
+		sessionLocale = caller.Session.Locale
+	}
+	locale := s.i18n.negotiate(r.Header.Get("Accept-Language"), sessionLocale)
+
+	// Oversized bodies are refused before reading when the client declares
+	// their length, and as soon as the limit is crossed otherwise.
+	limit := s.cfg.Server.bodyLimit()
+	if r.ContentLength > limit {
+		http.Error(w, s.i18n.translate(locale, "Request body too large"), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Refuse work under memory pressure instead of risking an OOM kill.
+	size := r.ContentLength
+	if size < 0 {
+		size = 0
+	}
+	release, ok := s.memory.admit(size)
+	if !ok {
+		s.metrics.inc(backpressureMetric)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, s.i18n.translate(locale, "Server busy"), http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	body := &countingReader{r: http.MaxBytesReader(w, r.Body, limit)}
+	defer r.Body.Close()
+
+	cw := &countingWriter{ResponseWriter: w}
+	w = cw
+	defer func() { s.accounting.addBytes(key, body.n, cw.n) }()
+	reply := func(id, result interface{}, rpcErr *JSONRPCError) {
+		if err := writeResponse(w, id, s.i18n.result(locale, result), s.i18n.error(locale, rpcErr)); err != nil {
+			log.Printf("rpc id=%v: %v", id, err)
+		}
+	}
+
+	strict := s.strictFor(r.URL.Path)
+	req, rpcErr := parseRequest(body, strict)
+	var tooLarge *http.MaxBytesError
+	if errors.As(body.err, &tooLarge) {
+		http.Error(w, s.i18n.translate(locale, "Request body too large"), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if rpcErr != nil {
+		var id interface{}
+		if req != nil {
+			id = req.ID
+		}
+		reply(id, nil, rpcErr)
+		return
+	}
+	if s.cfg.Logging.Verbose {
+		start := time.Now()
+		defer func() {
+			client := ""
+			if caller.Session != nil {
+				client = caller.Session.ClientName + "/" + caller.Session.ClientVersion
+			}
+			log.Printf("rpc %s id=%s key=%s client=%q ip=%s %v", req.Method, req.ID, caller.KeyName(), client, clientIP(r), time.Since(start))
+		}()
+	}
+	if s.cfg.Logging.SlowRequest > 0 {
+		start := time.Now()
+		defer func() { s.reportSlow(req, caller, r, time.Since(start)) }()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			reply(req.ID, nil, internalError(recovered(s.metrics, req.Method, p)))
+		}
+	}()
+	if req.response != nil {
+		// Responses to server requests, like notifications, get no reply.
+		var id string
+		json.Unmarshal(req.ID.(json.RawMessage), &id)
+		if caller.Session != nil {
+			s.elicitor.resolve(caller.Session.ID, id, *req.response)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if req.ID == nil {
+		// Notifications are acknowledged without a JSON-RPC response.
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Under overload, refuse low-priority requests so the rest stay fast.
+	priority := requestPriority(req.Method)
+	done, ok := s.shedder.admit(priority)
+	if !ok {
+		s.metrics.inc(shedMetric, "class", priorityNames[priority])
+		w.Header().Set("Retry-After", "1")
+		reply(req.ID, nil, overloadedError(priority))
+		return
+	}
+	defer done()
+
+	if err := s.accounting.checkBytes(key); err != nil {
+		reply(req.ID, nil, quotaError(err))
+		return
+	}
+
+	// Handle different methods
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string          `json:"protocolVersion"`
+			Capabilities    json.RawMessage `json:"capabilities"`
+			ClientInfo      struct {
+				Name    string `json:"name"`
+				Title   string `json:"title"`
+				Version string `json:"version"`
+				Locale  string `json:"locale"`
+			} `json:"clientInfo"`
+			Meta json.RawMessage `json:"_meta"`
+		}
+		if rpcErr := decodeParams(req.Params, &params, strict); rpcErr != nil {
+			reply(req.ID, nil, rpcErr)
+			return
+		}
+		locale = s.i18n.negotiate(r.Header.Get("Accept-Language"), params.ClientInfo.Locale)
+		sess := s.sessions.create(params.ClientInfo.Name, params.ClientInfo.Version, caller.KeyName(), locale.String(), params.Capabilities)
+		s.publish(EventSessionCreated, sessionEvent(sess))
+		w.Header().Set(sessionHeader, sess.ID)
+		reply(req.ID, map[string]interface{}{
+			"protocolVersion": "2024-11-05",
+			"capabilities": map[string]interface{}{
+				"tools": map[string]bool{
+					"listChanged": true,
+				},
+				"resources": s.resourceCapabilities(),
+			},
+			"serverInfo": map[string]interface{}{
+				"name":    "Go MCP Server",
+				"version": serverVersion,
+			},
+		}, nil)
+
+	case "ping":
+		reply(req.ID, map[string]interface{}{}, nil)
+
+	case "tools/list":
+		reply(req.ID, s.listTools(caller, locale), nil)
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+			Async     bool            `json:"async"`
+			Meta      json.RawMessage `json:"_meta"`
+		}
+		if rpcErr := decodeParams(req.Params, &params, strict); rpcErr != nil {
+			reply(req.ID, nil, rpcErr)
+			return
+		}
+		if params.Name == "" {
+			reply(req.ID, nil, invalidParams("name is required"))
+			return
+		}
+		if k := jsonKind(params.Arguments); k != 0 && k != '{' && k != 'n' {
+			reply(req.ID, nil, invalidParams("arguments must be an object"))
+			return
+		}
+
+		if err := s.accounting.chargeCall(key); err != nil {
+			reply(req.ID, nil, quotaError(err))
+			return
+		}
+		params.Name = s.resolveTool(params.Name)
+		ctx := withProgressToken(ctx, params.Meta)
+
+		// Hidden tools are indistinguishable from unknown ones.
+		var result interface{} = unknownTool()
+		if s.toolVisible(params.Name, caller) {
+			call := func() interface{} {
+				if !params.Async {
+					return s.executeShared(ctx, params.Name, params.Arguments)
+				}
+				job, err := s.jobs.submit(ctx, caller, params.Name, params.Arguments)
+				if err != nil {
+					return errorResult(err)
+				}
+				return asyncAccepted(job)
+			}
+			if key := idempotencyKeyFrom(params.Meta); key != "" && s.store != nil {
+				result = s.callIdempotent(ctx, key, params.Name, params.Arguments, call)
+			} else {
+				result = call()
+			}
+		}
+		reply(req.ID, result, nil)
+
+	case "tools/validate":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if rpcErr := decodeParams(req.Params, &params, strict); rpcErr != nil {
+			reply(req.ID, nil, rpcErr)
+			return
+		}
+		if params.Name == "" {
+			reply(req.ID, nil, invalidParams("name is required"))
+			return
+		}
+		params.Name = s.resolveTool(params.Name)
+		if _, ok := s.handlers[params.Name]; !ok || !s.toolVisible(params.Name, caller) {
+			reply(req.ID, unknownTool(), nil)
+			return
+		}
+		reply(req.ID, s.validateToolCall(ctx, params.Name, params.Arguments), nil)
+
+	case "jobs/status", "jobs/get", "jobs/list", "jobs/cancel", "jobs/result":
+		result, rpcErr := s.handleJobsMethod(req.Method, req.Params, caller, strict)
+		reply(req.ID, result, rpcErr)
+
+	case "resources/list", "resources/read":
+		result, rpcErr := s.handleResourcesMethod(req.Method, req.Params, caller, strict)
+		reply(req.ID, result, rpcErr)
+
+	case "resources/subscribe", "resources/unsubscribe":
+		result, rpcErr := s.handleResourceSubscription(req.Method, req.Params, caller, strict)
+		reply(req.ID, result, rpcErr)
+
+	default:
+		reply(req.ID, nil, &JSONRPCError{
+			Code:    codeMethodNotFound,
+			Message: "Method not found",
+		})
+	}
+}
+
+// resolveCaller authenticates the request and attaches its session. It
+// writes an HTTP error and returns false when the request is rejected.
+func (s *MCPServer) resolveCaller(w http.ResponseWriter, r *http.Request) (*Caller, bool) {
+	locale := s.i18n.negotiate(r.Header.Get("Accept-Language"))
+	key, err := s.authenticate(r)
+	if err != nil {
+		s.publish(EventAuthFailed, map[string]interface{}{"realm": "mcp", "remoteAddr": clientIP(r), "path": r.URL.Path, "reason": err.Error()})
+		http.Error(w, s.i18n.translate(locale, err.Error()), http.StatusUnauthorized)
+		return nil, false
+	}
+	caller := &Caller{Key: key}
+	if id := r.Header.Get(sessionHeader); id != "" {
+		sess, ok := s.sessions.get(id)
+		if !ok || sess.KeyName != caller.KeyName() {
+			http.Error(w, s.i18n.translate(locale, "Session not found"), http.StatusNotFound)
+			return nil, false
+		}
+		caller.Session = sess
+	}
+	return caller, true
+}
+
+func (s *MCPServer) executeTool(ctx context.Context, name string, args json.RawMessage) interface{} {
+	handler, ok := s.handlers[name]
+	if !ok {
+		return unknownTool()
+	}
+	start := time.Now()
+	result, err := handler(s.toolContext(ctx, name), args)
+	if err != nil {
+		result = errorResult(err)
+	}
+	result = sanitizeResult(result, s.cfg.Text)
+	elapsed := time.Since(start)
+	event := map[string]interface{}{"tool": name, "durationMs": float64(elapsed.Microseconds()) / 1000}
+	if c := callerFrom(ctx); c != nil {
+		event["key"] = c.KeyName()
+		if c.Session != nil {
+			event["client"] = c.Session.ClientName
+			event["clientVersion"] = c.Session.ClientVersion
+		}
+	}
+	ev := Event{Type: EventToolCompleted, Data: event, Arguments: args}
+	if err != nil || isErrorResult(result) {
+		event["error"] = toolErrorMessage(err, result)
+		ev.Type = EventToolFailed
+	}
+	s.publishEvent(ev)
+	return result
+}
+
+func unknownTool() interface{} {
+	return map[string]interface{}{
+		"error": "Unknown tool",
+	}
+}
+
+// textResult wraps text in an MCP tool result.
+func textResult(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]interface{}{
+			{
+				"type": "text",
+				"text": text,
+			},
+		},
+	}
+}
+
+// errorResult reports a tool failure inside the result, as MCP expects.
+func errorResult(err error) map[string]interface{} {
+	result := textResult(err.Error())
+	result["isError"] = true
+	return result
+}
+
+func systemInfoTool(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	return textResult(fmt.Sprintf("OS: %s\nArch: %s\nGo Version: %s\nCPUs: %d\nUptime: %s",
+		runtime.GOOS, runtime.GOARCH, runtime.Version(), runtime.NumCPU(), uptime().Round(time.Second))), nil
+}
+
+type echoArgs struct {
+	Message   string `json:"message" jsonschema:"required,description=Message to echo"`
+	Normalize string `json:"normalize,omitempty" jsonschema:"enum=NFC|NFD|NFKC|NFKD,description=Unicode normalization form to apply"`
+	MaxRunes  int    `json:"maxRunes,omitempty" jsonschema:"minimum=1,description=Truncate the echo to this many characters"`
+}
+
+func echoTool(ctx context.Context, args echoArgs) (string, error) {
+	message, err := sanitizeText(args.Message, TextConfig{
+		Normalize: args.Normalize,
+		MaxRunes:  args.MaxRunes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Echo: %s", message), nil
+}
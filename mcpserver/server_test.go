@@ -0,0 +1,84 @@
+package mcpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testHandler configures a server from cfg and returns it with the HTTP
+// handler its listener serves.
+func testHandler(t *testing.T, cfg *Config) (*MCPServer, http.Handler) {
+	t.Helper()
+	s, err := newConfiguredServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, liveHandler(t, s)
+}
+
+// liveHandler returns the HTTP handler of a configured server.
+func liveHandler(t *testing.T, s *MCPServer) http.Handler {
+	t.Helper()
+	h, err := newLiveServer(s, &Builder{}).handler(s.cfg, ListenerConfig{Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// testRequest builds a request from peer that arrived on a loopback
+// listener, as JSON when body is set.
+func testRequest(method, target, peer, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = peer
+	r.Host = "localhost:8080"
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestStrictJSONRPC(t *testing.T) {
+	_, h := testHandler(t, &Config{StrictDecoding: map[string]bool{"/mcp": true}})
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"valid", `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`, `"result"`},
+		{"wrong version", `{"jsonrpc": "1.0", "id": 1, "method": "ping"}`, `jsonrpc must be \"2.0\"`},
+		{"object id", `{"jsonrpc": "2.0", "id": {}, "method": "ping"}`, "id must be a string or number"},
+		{"scalar params", `{"jsonrpc": "2.0", "id": 1, "method": "ping", "params": 3}`, "params must be an object or array"},
+		{"duplicate key", `{"jsonrpc": "2.0", "id": 1, "method": "ping", "method": "tools/list"}`, `duplicate key \"method\"`},
+		{"nested duplicate", `{"jsonrpc": "2.0", "id": 1, "method": "ping", "params": {"a": 1, "a": 2}}`, `duplicate key \"a\" at $.params`},
+		{"unknown member", `{"jsonrpc": "2.0", "id": 1, "method": "ping", "extra": true}`, `unknown member \"extra\"`},
+		{"batch", `[{"jsonrpc": "2.0", "id": 1, "method": "ping"}]`, "batch requests are not supported"},
+		{"trailing data", `{"jsonrpc": "2.0", "id": 1, "method": "ping"} {}`, "Parse error"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(h, testRequest("POST", "/mcp", "127.0.0.1:5000", tc.body))
+			if !strings.Contains(w.Body.String(), tc.want) {
+				t.Errorf("%d %s, want %s", w.Code, w.Body, tc.want)
+			}
+			if tc.want != `"result"` && strings.Contains(w.Body.String(), `"result"`) {
+				t.Errorf("accepted: %s", w.Body)
+			}
+		})
+	}
+
+	// Without strict decoding, extra members and repeated keys pass.
+	_, lax := testHandler(t, &Config{})
+	w := serve(lax, testRequest("POST", "/mcp", "127.0.0.1:5000", `{"jsonrpc": "2.0", "id": 1, "method": "ping", "extra": true, "id": 2}`))
+	if !strings.Contains(w.Body.String(), `"result"`) {
+		t.Errorf("lax: %d %s", w.Code, w.Body)
+	}
+}
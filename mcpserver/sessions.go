@@ -0,0 +1,357 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// sessionHeader carries the session ID assigned at initialize.
+const sessionHeader = "Mcp-Session-Id"
+
+const (
+	defaultSessionIdleTimeout = 24 * time.Hour
+	sessionSweepInterval      = time.Minute
+)
+
+// SessionsConfig controls session lifetime and isolation. Sessions idle
+// for IdleTimeout (default 24h) expire; with a state store they survive
+// restarts until then. With ScratchRoot set, every
+// session gets its own directory below it: exec tools run there, HOME and
+// TMPDIR point there, and it is removed when the session ends. Env is
+// added to the environment of exec tools; ${SESSION_ID}, ${SESSION_DIR}
+// and ${CLIENT_NAME} expand to the session's values and other ${VAR}s to
+// the server's environment.
+type SessionsConfig struct {
+	IdleTimeout Duration          `json:"idleTimeout,omitempty"`
+	ScratchRoot string            `json:"scratchRoot,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// Session holds what a client negotiated during initialize.
+type Session struct {
+	ID            string
+	ClientName    string
+	ClientVersion string
+	KeyName       string
+	Locale        string
+	Capabilities  json.RawMessage
+	Created       time.Time
+	LastSeen      time.Time
+
+	// Dir is the session's scratch directory, created on first use; empty
+	// without a scratch root. Env is the session's environment overlay.
+	// Both are derived again when a stored session is loaded.
+	Dir string   `json:"-"`
+	Env []string `json:"-"`
+
+	saved time.Time // LastSeen as last stored
+}
+
+type sessionStore struct {
+	cfg      SessionsConfig
+	db       store.Store // nil keeps sessions in memory only
+	mu       sync.Mutex
+	sessions map[string]*Session
+	ended    []func(*Session)
+}
+
+func newSessionStore(cfg SessionsConfig) *sessionStore {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = Duration(defaultSessionIdleTimeout)
+	}
+	return &sessionStore{cfg: cfg, sessions: make(map[string]*Session)}
+}
+
+func (st *sessionStore) create(clientName, clientVersion, keyName, locale string, capabilities json.RawMessage) *Session {
+	now := time.Now()
+	sess := &Session{
+		ID:            randomID(),
+		ClientName:    clientName,
+		ClientVersion: clientVersion,
+		KeyName:       keyName,
+		Locale:        locale,
+		Capabilities:  capabilities,
+		Created:       now,
+		LastSeen:      now,
+	}
+	st.isolate(sess)
+	st.mu.Lock()
+	st.sessions[sess.ID] = sess
+	st.mu.Unlock()
+	st.save(sess)
+	return sess
+}
+
+// attach makes db the sessions' persistent store and loads the sessions
+// kept there that have not expired in the meantime.
+func (st *sessionStore) attach(db store.Store) error {
+	ctx, cancel := storeContext()
+	defer cancel()
+	recs, err := db.List(ctx, nsSessions, "")
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-time.Duration(st.cfg.IdleTimeout))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.db = db
+	for _, rec := range recs {
+		sess := &Session{}
+		if err := json.Unmarshal(rec.Value, sess); err != nil || sess.LastSeen.Before(cutoff) {
+			db.Delete(ctx, nsSessions, rec.Key)
+			continue
+		}
+		sess.saved = sess.LastSeen
+		st.isolate(sess)
+		st.sessions[sess.ID] = sess
+	}
+	return nil
+}
+
+// save stores sess, if sessions are persisted.
+func (st *sessionStore) save(sess *Session) {
+	st.mu.Lock()
+	if st.db == nil {
+		st.mu.Unlock()
+		return
+	}
+	db, snapshot := st.db, *sess
+	sess.saved = sess.LastSeen
+	st.mu.Unlock()
+	if err := putJSON(db, nsSessions, snapshot.ID, snapshot.KeyName, &snapshot, time.Time{}); err != nil {
+		log.Printf("sessions: storing %s: %v", snapshot.ID, err)
+	}
+}
+
+// isolate assigns the session its scratch directory and environment.
+func (st *sessionStore) isolate(sess *Session) {
+	if st.cfg.ScratchRoot != "" {
+		sess.Dir = filepath.Join(st.cfg.ScratchRoot, sess.ID)
+		sess.Env = append(sess.Env,
+			"HOME="+sess.Dir, "TMPDIR="+sess.Dir, "TMP="+sess.Dir, "TEMP="+sess.Dir)
+	}
+	sess.Env = append(sess.Env, "MCP_SESSION_ID="+sess.ID)
+	vars := map[string]string{
+		"SESSION_ID":  sess.ID,
+		"SESSION_DIR": sess.Dir,
+		"CLIENT_NAME": sess.ClientName,
+	}
+	names := make([]string, 0, len(st.cfg.Env))
+	for name := range st.cfg.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := os.Expand(st.cfg.Env[name], func(v string) string {
+			if val, ok := vars[v]; ok {
+				return val
+			}
+			return os.Getenv(v)
+		})
+		sess.Env = append(sess.Env, name+"="+value)
+	}
+}
+
+// workdir returns the session's scratch directory, creating it if needed.
+// The root is traversable but not listable, so tools running as another
+// user reach their own directory without seeing the others.
+func (sess *Session) workdir() (string, error) {
+	if err := os.MkdirAll(filepath.Dir(sess.Dir), 0o711); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(sess.Dir, 0o700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	return sess.Dir, nil
+}
+
+// get looks up a session and marks it as recently used.
+func (st *sessionStore) get(id string) (*Session, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[id]
+	if ok {
+		sess.LastSeen = time.Now()
+	}
+	return sess, ok
+}
+
+// remove ends a session and deletes its scratch directory.
+func (st *sessionStore) remove(id string) bool {
+	st.mu.Lock()
+	sess, ok := st.sessions[id]
+	delete(st.sessions, id)
+	st.mu.Unlock()
+	if ok {
+		st.cleanup(sess)
+	}
+	return ok
+}
+
+// purge ends the sessions match selects, like remove, and returns them.
+func (st *sessionStore) purge(match func(*Session) bool) []*Session {
+	var ended []*Session
+	st.mu.Lock()
+	for id, sess := range st.sessions {
+		if match(sess) {
+			ended = append(ended, sess)
+			delete(st.sessions, id)
+		}
+	}
+	st.mu.Unlock()
+	for _, sess := range ended {
+		st.cleanup(sess)
+	}
+	return ended
+}
+
+// onEnd registers fn to release what a session holds when it ends.
+func (st *sessionStore) onEnd(fn func(*Session)) {
+	st.mu.Lock()
+	st.ended = append(st.ended, fn)
+	st.mu.Unlock()
+}
+
+// cleanup deletes what an ended session leaves behind.
+func (st *sessionStore) cleanup(sess *Session) {
+	st.mu.Lock()
+	ended := st.ended
+	st.mu.Unlock()
+	for _, fn := range ended {
+		fn(sess)
+	}
+	if st.db != nil {
+		deleteRecord(st.db, nsSessions, sess.ID)
+	}
+	if sess.Dir == "" {
+		return
+	}
+	if err := os.RemoveAll(sess.Dir); err != nil {
+		log.Printf("sessions: removing %s: %v", sess.Dir, err)
+	}
+}
+
+// expireLoop ends idle sessions until ctx is cancelled, calling expired
+// for each. Stored sessions' last use is brought up to date on the same
+// schedule rather than on every request.
+func (st *sessionStore) expireLoop(ctx context.Context, expired func(*Session)) {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cutoff := time.Now().Add(-time.Duration(st.cfg.IdleTimeout))
+		var idle, seen []*Session
+		st.mu.Lock()
+		for id, sess := range st.sessions {
+			switch {
+			case sess.LastSeen.Before(cutoff):
+				idle = append(idle, sess)
+				delete(st.sessions, id)
+			case st.db != nil && sess.LastSeen.After(sess.saved):
+				seen = append(seen, sess)
+			}
+		}
+		st.mu.Unlock()
+		for _, sess := range idle {
+			st.cleanup(sess)
+			expired(sess)
+		}
+		for _, sess := range seen {
+			st.save(sess)
+		}
+	}
+}
+
+// removeStale deletes scratch directories left by a previous run, except
+// those of stored sessions that are still open. Only names that look like
+// session IDs are touched.
+func (st *sessionStore) removeStale() {
+	if st.cfg.ScratchRoot == "" {
+		return
+	}
+	entries, err := os.ReadDir(st.cfg.ScratchRoot)
+	if err != nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, e := range entries {
+		if _, open := st.sessions[e.Name()]; open {
+			continue
+		}
+		if _, err := hex.DecodeString(e.Name()); err == nil && len(e.Name()) == 32 && e.IsDir() {
+			os.RemoveAll(filepath.Join(st.cfg.ScratchRoot, e.Name()))
+		}
+	}
+}
+
+// list returns the open sessions, most recently used first.
+func (st *sessionStore) list() []Session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]Session, 0, len(st.sessions))
+	for _, sess := range st.sessions {
+		out = append(out, *sess)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+// handleAdminSessions serves GET /admin/sessions.
+func (s *MCPServer) handleAdminSessions(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := []map[string]interface{}{}
+	for _, sess := range s.sessions.list() {
+		out = append(out, map[string]interface{}{
+			"id":            sess.ID,
+			"clientName":    sess.ClientName,
+			"clientVersion": sess.ClientVersion,
+			"key":           sess.KeyName,
+			"locale":        sess.Locale,
+			"created":       sess.Created.UTC(),
+			"lastSeen":      sess.LastSeen.UTC(),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": out})
+}
+
+// sessionEvent is the data of a session event.
+func sessionEvent(sess *Session) map[string]interface{} {
+	return map[string]interface{}{
+		"clientName":    sess.ClientName,
+		"clientVersion": sess.ClientVersion,
+		"key":           sess.KeyName,
+	}
+}
+
+// sessionFrom returns the session of the caller on ctx, if any.
+func sessionFrom(ctx context.Context) *Session {
+	if c := callerFrom(ctx); c != nil {
+		return c.Session
+	}
+	return nil
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,504 @@
+package mcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP packet types and flags of version 3, the version OpenSSH speaks.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpName     = 104
+	sftpAttrs    = 105
+
+	sftpReadFlag  = 0x01
+	sftpWriteFlag = 0x02
+	sftpCreat     = 0x08
+	sftpTrunc     = 0x10
+	sftpExcl      = 0x20
+
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrTimes       = 0x08
+	sftpAttrExtended    = 0x80000000
+
+	// sftpChunk is the READ and WRITE size every server accepts.
+	sftpChunk = 32 << 10
+	// maxSFTPPacket bounds a reply; OpenSSH sends at most 256KiB.
+	maxSFTPPacket = 1 << 20
+)
+
+// sftpStatuses names the status codes of version 3.
+var sftpStatuses = map[uint32]string{
+	4: "failure",
+	5: "bad message",
+	6: "no connection",
+	7: "connection lost",
+	8: "operation unsupported",
+}
+
+// sftpClient is an SFTP session over SSH. It sends one request at a time,
+// and is used by one goroutine.
+type sftpClient struct {
+	conn    net.Conn
+	ssh     *ssh.Client
+	session *ssh.Session
+	w       io.WriteCloser
+	r       *bufio.Reader
+	id      uint32
+}
+
+// dialSFTP connects and authenticates to e, whose host key must be one
+// of the pinned ones, and starts the sftp subsystem.
+func dialSFTP(ctx context.Context, e TransferEndpoint, secrets map[string]string) (*sftpClient, error) {
+	auth, err := e.sshAuth(secrets)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := pinnedHostKeys(e.HostKeys)
+	if err != nil {
+		return nil, err
+	}
+	addr := e.address()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// The handshake has no context of its own.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(e.timeout()))
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            e.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		ClientVersion:   "SSH-2.0-mcp-server",
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &sftpClient{conn: conn, ssh: ssh.NewClient(sc, chans, reqs)}
+	if err := c.start(); err != nil {
+		c.close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *sftpClient) start() error {
+	var err error
+	if c.session, err = c.ssh.NewSession(); err != nil {
+		return err
+	}
+	if c.w, err = c.session.StdinPipe(); err != nil {
+		return err
+	}
+	out, err := c.session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	c.r = bufio.NewReaderSize(out, 64<<10)
+	if err := c.session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("sftp subsystem: %w", err)
+	}
+	var init sftpWriter
+	init.uint32(3)
+	if err := c.send(sftpInit, init.Bytes()); err != nil {
+		return err
+	}
+	typ, resp, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if typ != sftpVersion {
+		return fmt.Errorf("sftp: unexpected packet %d instead of version", typ)
+	}
+	if v := resp.uint32(); resp.err == nil && v < 3 {
+		return fmt.Errorf("sftp: server speaks version %d; 3 is needed", v)
+	}
+	return resp.err
+}
+
+func (c *sftpClient) close() error {
+	if c.session != nil {
+		c.session.Close()
+	}
+	if c.ssh != nil {
+		return c.ssh.Close()
+	}
+	return c.conn.Close()
+}
+
+func (c *sftpClient) send(typ byte, body []byte) error {
+	packet := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(packet, uint32(1+len(body)))
+	packet[4] = typ
+	_, err := c.w.Write(append(packet, body...))
+	return err
+}
+
+func (c *sftpClient) receive() (byte, *sftpReader, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:4])
+	if n < 1 || n > maxSFTPPacket {
+		return 0, nil, fmt.Errorf("sftp: packet of %d bytes", n)
+	}
+	body := make([]byte, n-1)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return head[4], &sftpReader{b: body}, nil
+}
+
+// request sends a request and returns the type and body of its reply,
+// turning a status other than OK into an error.
+func (c *sftpClient) request(typ byte, body []byte) (byte, *sftpReader, error) {
+	c.id++
+	id := c.id
+	var req sftpWriter
+	req.uint32(id)
+	req.Write(body)
+	if err := c.send(typ, req.Bytes()); err != nil {
+		return 0, nil, err
+	}
+	rtyp, resp, err := c.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if got := resp.uint32(); got != id {
+		return 0, nil, fmt.Errorf("sftp: reply to request %d instead of %d", got, id)
+	}
+	if rtyp == sftpStatus {
+		if err := resp.status(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return rtyp, resp, resp.err
+}
+
+// expect is request for replies that must have type want.
+func (c *sftpClient) expect(want, typ byte, body []byte) (*sftpReader, error) {
+	rtyp, resp, err := c.request(typ, body)
+	if err != nil {
+		return nil, err
+	}
+	if rtyp != want {
+		return nil, fmt.Errorf("sftp: unexpected packet %d", rtyp)
+	}
+	return resp, nil
+}
+
+func (c *sftpClient) stat(p string) (remoteEntry, error) {
+	var req sftpWriter
+	req.string(p)
+	resp, err := c.expect(sftpAttrs, sftpStat, req.Bytes())
+	if err != nil {
+		return remoteEntry{}, err
+	}
+	e := resp.attrs()
+	e.Name = p[strings.LastIndex(p, "/")+1:]
+	return e, resp.err
+}
+
+func (c *sftpClient) list(dir string) ([]remoteEntry, error) {
+	var req sftpWriter
+	req.string(dir)
+	resp, err := c.expect(sftpHandle, sftpOpendir, req.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	handle := resp.string()
+	defer c.closeHandle(handle)
+	var entries []remoteEntry
+	for len(entries) < maxTransferEntries {
+		var req sftpWriter
+		req.string(handle)
+		resp, err := c.expect(sftpName, sftpReaddir, req.Bytes())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n := resp.uint32(); n > 0 && resp.err == nil; n-- {
+			name := resp.string()
+			resp.string() // ls -l style long name
+			e := resp.attrs()
+			if name != "." && name != ".." {
+				e.Name = name
+				entries = append(entries, e)
+			}
+		}
+		if resp.err != nil {
+			return nil, resp.err
+		}
+	}
+	return entries, nil
+}
+
+func (c *sftpClient) closeHandle(handle string) error {
+	var req sftpWriter
+	req.string(handle)
+	_, _, err := c.request(sftpClose, req.Bytes())
+	return err
+}
+
+// open opens p for reading.
+func (c *sftpClient) open(p string) (io.ReadCloser, error) {
+	handle, err := c.openHandle(p, sftpReadFlag)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{c: c, handle: handle}, nil
+}
+
+// create opens p for writing, failing if it exists unless overwrite.
+// Servers report an exclusive open of an existing file as a plain
+// failure, so it is looked for first.
+func (c *sftpClient) create(p string, overwrite bool) (io.WriteCloser, error) {
+	flags := uint32(sftpWriteFlag | sftpCreat | sftpExcl)
+	if !overwrite {
+		if _, err := c.stat(p); err == nil {
+			return nil, fmt.Errorf("sftp: %w", os.ErrExist)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	} else {
+		flags = sftpWriteFlag | sftpCreat | sftpTrunc
+	}
+	handle, err := c.openHandle(p, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{c: c, handle: handle}, nil
+}
+
+func (c *sftpClient) openHandle(p string, flags uint32) (string, error) {
+	var req sftpWriter
+	req.string(p)
+	req.uint32(flags)
+	req.uint32(0) // no attributes
+	resp, err := c.expect(sftpHandle, sftpOpen, req.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return resp.string(), resp.err
+}
+
+// sftpFile reads or writes a remote file sequentially.
+type sftpFile struct {
+	c      *sftpClient
+	handle string
+	offset uint64
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(p) > sftpChunk {
+		p = p[:sftpChunk]
+	}
+	var req sftpWriter
+	req.string(f.handle)
+	req.uint64(f.offset)
+	req.uint32(uint32(len(p)))
+	resp, err := f.c.expect(sftpData, sftpRead, req.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	data := resp.string()
+	if resp.err != nil {
+		return 0, resp.err
+	}
+	n := copy(p, data)
+	f.offset += uint64(n)
+	return n, nil
+}
+
+func (f *sftpFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > sftpChunk {
+			chunk = chunk[:sftpChunk]
+		}
+		var req sftpWriter
+		req.string(f.handle)
+		req.uint64(f.offset)
+		req.string(string(chunk))
+		if _, _, err := f.c.request(sftpWrite, req.Bytes()); err != nil {
+			return written, err
+		}
+		f.offset += uint64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (f *sftpFile) Close() error {
+	return f.c.closeHandle(f.handle)
+}
+
+type sftpWriter struct{ bytes.Buffer }
+
+func (w *sftpWriter) uint32(v uint32) { w.Write(binary.BigEndian.AppendUint32(nil, v)) }
+func (w *sftpWriter) uint64(v uint64) { w.Write(binary.BigEndian.AppendUint64(nil, v)) }
+
+func (w *sftpWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.WriteString(s)
+}
+
+// sftpReader decodes a packet body; the first error sticks.
+type sftpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sftpReader) take(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		if r.err == nil {
+			r.err = errors.New("sftp: short packet")
+		}
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *sftpReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *sftpReader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *sftpReader) string() string {
+	n := r.uint32()
+	if n > uint32(len(r.b)) {
+		r.take(-1)
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+// attrs decodes the attributes of a file.
+func (r *sftpReader) attrs() remoteEntry {
+	var e remoteEntry
+	flags := r.uint32()
+	if flags&sftpAttrSize != 0 {
+		e.Size = int64(r.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&sftpAttrPermissions != 0 {
+		mode := r.uint32()
+		e.Dir = mode&0o170000 == 0o040000
+		e.Mode = fmt.Sprintf("%04o", mode&0o7777)
+	}
+	if flags&sftpAttrTimes != 0 {
+		r.uint32() // access time
+		e.ModTime = time.Unix(int64(r.uint32()), 0).UTC()
+	}
+	if flags&sftpAttrExtended != 0 {
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.string()
+		}
+	}
+	return e
+}
+
+// status turns a status reply into an error: nil for OK, io.EOF at the
+// end of a file or directory, and errors matching os.ErrNotExist and
+// os.ErrPermission where those apply.
+func (r *sftpReader) status() error {
+	code := r.uint32()
+	msg := r.string()
+	if r.err != nil {
+		return r.err
+	}
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return io.EOF
+	case 2:
+		return fmt.Errorf("sftp: %w", os.ErrNotExist)
+	case 3:
+		return fmt.Errorf("sftp: %w", os.ErrPermission)
+	}
+	if msg == "" {
+		msg = sftpStatuses[code]
+	}
+	return fmt.Errorf("sftp: %s (status %d)", msg, code)
+}
+
+// pinnedHostKeys accepts only the listed host keys: authorized_keys or
+// known_hosts lines, or SHA256 fingerprints as ssh-keygen -l prints them.
+func pinnedHostKeys(pins []string) (ssh.HostKeyCallback, error) {
+	if len(pins) == 0 {
+		return nil, errors.New("hostKeys is required")
+	}
+	fingerprints := map[string]bool{}
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if strings.HasPrefix(pin, "SHA256:") {
+			fingerprints[pin] = true
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pin))
+		if err != nil {
+			if _, _, key, _, _, err = ssh.ParseKnownHosts([]byte(pin)); err != nil {
+				return nil, fmt.Errorf("host key %q: not a public key or SHA256 fingerprint", pin)
+			}
+		}
+		fingerprints[ssh.FingerprintSHA256(key)] = true
+	}
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		fp := ssh.FingerprintSHA256(key)
+		if !fingerprints[fp] {
+			return fmt.Errorf("host key %s %s of %s is not pinned", key.Type(), fp, hostname)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,229 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SheddingConfig turns on adaptive load shedding. While the p99 latency of
+// recent requests is above LatencyP99, or MaxInFlight JSON-RPC requests
+// are already being handled, the lowest-priority requests are refused with
+// a retriable error: listings first, then reads at 1.5 times a threshold,
+// and tool calls only at twice it. initialize, ping, notifications and
+// cancellations are never shed.
+type SheddingConfig struct {
+	LatencyP99  Duration `json:"latencyP99,omitempty"`
+	MaxInFlight int      `json:"maxInFlight,omitempty" schema:"minimum=1"`
+	// Window is how far back the p99 looks; default 30s.
+	Window Duration `json:"window,omitempty"`
+}
+
+func (c SheddingConfig) enabled() bool {
+	return c.LatencyP99 > 0 || c.MaxInFlight > 0
+}
+
+func (c SheddingConfig) check() error {
+	if c.LatencyP99 < 0 || c.MaxInFlight < 0 || c.Window < 0 {
+		return errors.New("latencyP99, maxInFlight and window must not be negative")
+	}
+	if c.Window > 0 && time.Duration(c.Window) < shedSampleInterval {
+		return errors.New("window must be at least 1s")
+	}
+	return nil
+}
+
+func (c SheddingConfig) window() time.Duration {
+	return orDefault(c.Window, defaultShedWindow)
+}
+
+// codeOverloaded is the JSON-RPC error code for shed requests.
+const codeOverloaded = -32003
+
+const (
+	shedMetric     = "mcp_shed_requests_total"
+	inFlightMetric = "mcp_requests_in_flight"
+)
+
+const (
+	defaultShedWindow  = 30 * time.Second
+	shedSampleInterval = time.Second
+	// Below minShedSamples requests in the window the p99 means little,
+	// so latency alone does not shed.
+	minShedSamples = 20
+	maxShedSamples = 4096
+)
+
+// Request priority classes, lowest first.
+const (
+	priorityList = iota
+	priorityRead
+	priorityCall
+	priorityControl
+)
+
+var priorityNames = []string{"list", "read", "call", "control"}
+
+// shedAt is the overload, as a multiple of the thresholds, from which each
+// class below priorityControl is refused.
+var shedAt = []float64{1, 1.5, 2}
+
+// requestPriority classes a JSON-RPC method for shedding.
+func requestPriority(method string) int {
+	switch {
+	case method == "initialize" || method == "ping" ||
+		strings.HasPrefix(method, "notifications/") || strings.HasSuffix(method, "/cancel"):
+		return priorityControl
+	case method == "tools/call":
+		return priorityCall
+	case strings.HasSuffix(method, "/list"):
+		return priorityList
+	}
+	return priorityRead
+}
+
+type latencySample struct {
+	at time.Time
+	d  time.Duration
+}
+
+// loadShedder admits JSON-RPC requests by priority. It is shared by all
+// generations, so requests in flight across a reload are still counted.
+type loadShedder struct {
+	cfg      SheddingConfig
+	metrics  *metricsRegistry
+	inFlight atomic.Int64
+	latency  atomic.Uint64 // p99 over LatencyP99, as float64 bits
+
+	mu      sync.Mutex
+	samples []latencySample // ring of recent latencies
+	next    int
+	shed    int // classes shed at the last sample, for logging
+}
+
+func newLoadShedder(cfg SheddingConfig, m *metricsRegistry) *loadShedder {
+	return &loadShedder{cfg: cfg, metrics: m}
+}
+
+// overload returns how far past its thresholds the server is with n
+// requests in flight, 1 being exactly at a threshold.
+func (l *loadShedder) overload(n int64) float64 {
+	r := math.Float64frombits(l.latency.Load())
+	if l.cfg.MaxInFlight > 0 {
+		if q := float64(n) / float64(l.cfg.MaxInFlight); q > r {
+			r = q
+		}
+	}
+	return r
+}
+
+// admit reports whether a request of the given priority is handled now.
+// The returned func is called when it is done and records its latency.
+func (l *loadShedder) admit(priority int) (done func(), ok bool) {
+	if !l.cfg.enabled() {
+		return func() {}, true
+	}
+	n := l.inFlight.Add(1)
+	// n counts this request, which is not yet being handled.
+	if priority < priorityControl && l.overload(n-1) >= shedAt[priority] {
+		l.inFlight.Add(-1)
+		return nil, false
+	}
+	l.metrics.add(inFlightMetric, 1)
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.inFlight.Add(-1)
+			l.metrics.add(inFlightMetric, -1)
+			l.record(time.Since(start))
+		})
+	}, true
+}
+
+func (l *loadShedder) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := latencySample{at: time.Now(), d: d}
+	if len(l.samples) < maxShedSamples {
+		l.samples = append(l.samples, s)
+		return
+	}
+	l.samples[l.next] = s
+	l.next = (l.next + 1) % maxShedSamples
+}
+
+// p99 returns the 99th percentile latency of the requests finished within
+// the window, and how many there were.
+func (l *loadShedder) p99() (time.Duration, int) {
+	since := time.Now().Add(-l.cfg.window())
+	l.mu.Lock()
+	recent := make([]time.Duration, 0, len(l.samples))
+	for _, s := range l.samples {
+		if s.at.After(since) {
+			recent = append(recent, s.d)
+		}
+	}
+	l.mu.Unlock()
+	if len(recent) == 0 {
+		return 0, 0
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+	return recent[(len(recent)*99)/100], len(recent)
+}
+
+// monitor recomputes the p99 every second until ctx is cancelled and logs
+// when the classes being shed change.
+func (l *loadShedder) monitor(ctx context.Context) {
+	if !l.cfg.enabled() {
+		return
+	}
+	ticker := time.NewTicker(shedSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p99, n := l.p99()
+		ratio := 0.0
+		if l.cfg.LatencyP99 > 0 && n >= minShedSamples {
+			ratio = float64(p99) / float64(l.cfg.LatencyP99)
+		}
+		l.latency.Store(math.Float64bits(ratio))
+
+		inFlight := l.inFlight.Load()
+		r := l.overload(inFlight)
+		shed := 0
+		for shed < len(shedAt) && r >= shedAt[shed] {
+			shed++
+		}
+		l.mu.Lock()
+		changed := shed != l.shed
+		l.shed = shed
+		l.mu.Unlock()
+		if changed {
+			if shed == 0 {
+				log.Printf("load shedding stopped (p99 %v, %d in flight)", p99, inFlight)
+			} else {
+				log.Printf("load shedding %s requests (p99 %v, %d in flight)", strings.Join(priorityNames[:shed], ", "), p99, inFlight)
+			}
+		}
+	}
+}
+
+// overloadedError is the retriable error for a shed request.
+func overloadedError(priority int) *JSONRPCError {
+	return &JSONRPCError{
+		Code:    codeOverloaded,
+		Message: "Server busy",
+		Data:    map[string]interface{}{"class": priorityNames[priority], "retryAfter": 1},
+	}
+}
@@ -0,0 +1,158 @@
+package mcpserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request. The signature is the hex HMAC-SHA256, with
+// the key's secret, of the timestamp, the nonce, the method and the hex
+// SHA-256 of the body, joined by newlines.
+const (
+	signatureKeyHeader       = "X-MCP-Key"
+	signatureTimestampHeader = "X-MCP-Timestamp"
+	signatureNonceHeader     = "X-MCP-Nonce"
+	signatureHeader          = "X-MCP-Signature"
+	defaultSignatureWindow   = 5 * time.Minute
+)
+
+var errBadSignature = errors.New("invalid request signature")
+
+// signingSecrets checks that every static key has its own name and
+// expands the secrets of the keys that sign requests, by key name.
+func signingSecrets(keys []APIKeyConfig, secrets map[string]string) (map[string][]byte, error) {
+	out := map[string][]byte{}
+	names := map[string]bool{}
+	for i, k := range keys {
+		if k.Key == "" && k.Secret == "" {
+			return nil, fmt.Errorf("apiKeys[%d]: set key or secret", i)
+		}
+		// Sessions, jobs, quotas and audit records belong to a key by
+		// name, so every key needs its own.
+		switch {
+		case k.Name == "":
+			return nil, fmt.Errorf("apiKeys[%d]: name is required", i)
+		case k.Name == anonymousKeyName:
+			return nil, fmt.Errorf("apiKeys[%d]: name %q is reserved for unauthenticated callers", i, k.Name)
+		case names[k.Name]:
+			return nil, fmt.Errorf("apiKeys[%d]: name %q is used by another key", i, k.Name)
+		}
+		names[k.Name] = true
+		if k.Secret == "" {
+			continue
+		}
+		secret, err := expandSecrets(k.Secret, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("apiKeys[%d]: %w", i, err)
+		}
+		if len(secret) < 16 {
+			return nil, fmt.Errorf("apiKeys[%d]: secret must be at least 16 characters", i)
+		}
+		out[k.Name] = []byte(secret)
+	}
+	return out, nil
+}
+
+// nonceCache remembers the nonces of signed requests until their
+// timestamps leave the window, so each signature is accepted once.
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	prune time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: map[string]time.Time{}}
+}
+
+// use records id until expires and reports whether it was new.
+func (c *nonceCache) use(id string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.After(c.prune) {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.prune = now.Add(time.Minute)
+	}
+	if exp, ok := c.seen[id]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[id] = expires
+	return true
+}
+
+// verifySignature authenticates a signed request. The body is read to be
+// hashed and put back for the handler.
+func (s *MCPServer) verifySignature(r *http.Request) (*APIKeyConfig, error) {
+	name := r.Header.Get(signatureKeyHeader)
+	secret, ok := s.signing[name]
+	if !ok {
+		return nil, errBadSignature
+	}
+	window := time.Duration(s.cfg.Auth.SignatureWindow)
+	if window <= 0 {
+		window = defaultSignatureWindow
+	}
+	unix, err := strconv.ParseInt(r.Header.Get(signatureTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, errBadSignature
+	}
+	ts := time.Unix(unix, 0)
+	if skew := time.Since(ts); skew > window || skew < -window {
+		return nil, errors.New("request signature timestamp outside the allowed window")
+	}
+	nonce := r.Header.Get(signatureNonceHeader)
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return nil, errBadSignature
+	}
+	given, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(signatureHeader), "v1="))
+	if err != nil {
+		return nil, errBadSignature
+	}
+
+	limit := s.cfg.Server.bodyLimit()
+	if r.ContentLength > limit {
+		return nil, errors.New("Request body too large")
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(io.LimitReader(r.Body, limit+1)); err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		if int64(len(body)) > limit {
+			return nil, errors.New("Request body too large")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s", unix, nonce, r.Method, hex.EncodeToString(sum[:]))
+	if !hmac.Equal(mac.Sum(nil), given) {
+		return nil, errBadSignature
+	}
+	// Checked after the signature, so forged requests cannot use up nonces.
+	if !s.nonces.use(name+"\n"+nonce, ts.Add(window)) {
+		return nil, errors.New("request signature already used")
+	}
+	for i := range s.cfg.Auth.APIKeys {
+		if key := &s.cfg.Auth.APIKeys[i]; key.Name == name && key.Secret != "" {
+			return key, nil
+		}
+	}
+	return nil, errBadSignature
+}
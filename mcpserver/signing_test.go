@@ -0,0 +1,72 @@
+package mcpserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedRequests(t *testing.T) {
+	const secret = "0123456789abcdef0123"
+	_, h := testHandler(t, &Config{Auth: AuthConfig{APIKeys: []APIKeyConfig{
+		{Name: "signer", Secret: secret},
+		{Name: "plain", Key: "plain-key"},
+	}}})
+	const ping = `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`
+	now := time.Now().Unix()
+	send := func(key, secret, body, signedBody string, ts int64, nonce string) int {
+		sum := sha256.Sum256([]byte(signedBody))
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "%d\n%s\n%s\n%s", ts, nonce, "POST", hex.EncodeToString(sum[:]))
+		r := testRequest("POST", "/mcp", "127.0.0.1:5000", body)
+		r.Header.Set(signatureKeyHeader, key)
+		r.Header.Set(signatureTimestampHeader, strconv.FormatInt(ts, 10))
+		r.Header.Set(signatureNonceHeader, nonce)
+		r.Header.Set(signatureHeader, "v1="+hex.EncodeToString(mac.Sum(nil)))
+		return serve(h, r).Code
+	}
+	nonce := func(i int) string { return fmt.Sprintf("nonce-%016d", i) }
+
+	for i, tc := range []struct {
+		name                          string
+		key, secret, body, signedBody string
+		ts                            int64
+		nonce                         string
+		want                          int
+	}{
+		{"valid", "signer", secret, ping, ping, now, nonce(1), http.StatusOK},
+		{"replayed nonce", "signer", secret, ping, ping, now, nonce(1), http.StatusUnauthorized},
+		{"tampered body", "signer", secret, strings.Replace(ping, "ping", "tools/list", 1), ping, now, nonce(2), http.StatusUnauthorized},
+		{"wrong secret", "signer", "fedcba9876543210fedc", ping, ping, now, nonce(3), http.StatusUnauthorized},
+		{"unknown key", "nobody", secret, ping, ping, now, nonce(4), http.StatusUnauthorized},
+		{"key without a secret", "plain", secret, ping, ping, now, nonce(5), http.StatusUnauthorized},
+		{"stale timestamp", "signer", secret, ping, ping, now - 3600, nonce(6), http.StatusUnauthorized},
+		{"future timestamp", "signer", secret, ping, ping, now + 3600, nonce(7), http.StatusUnauthorized},
+		{"short nonce", "signer", secret, ping, ping, now, "abc", http.StatusUnauthorized},
+		{"fresh nonce", "signer", secret, ping, ping, now, nonce(8), http.StatusOK},
+	} {
+		if got := send(tc.key, tc.secret, tc.body, tc.signedBody, tc.ts, tc.nonce); got != tc.want {
+			t.Errorf("%d %s: status %d, want %d", i, tc.name, got, tc.want)
+		}
+	}
+
+	// A forged request does not use up the nonce of a later valid one.
+	if got := send("signer", "fedcba9876543210fedc", ping, ping, now, nonce(9)); got != http.StatusUnauthorized {
+		t.Errorf("forged: %d", got)
+	}
+	if got := send("signer", secret, ping, ping, now, nonce(9)); got != http.StatusOK {
+		t.Errorf("valid after forged: %d", got)
+	}
+}
+
+func TestSigningSecretLength(t *testing.T) {
+	if _, err := newConfiguredServer(&Config{Auth: AuthConfig{APIKeys: []APIKeyConfig{{Name: "signer", Secret: "short"}}}}); err == nil || !strings.Contains(err.Error(), "at least 16") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,1119 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SNMPConfig gives the snmp tools read access to network devices. Tools
+// name a device by host, and the first group listing it supplies the
+// version and credentials, so agents never handle communities or keys.
+type SNMPConfig struct {
+	Groups []SNMPGroup `json:"groups,omitempty"`
+}
+
+// SNMPGroup is a set of devices sharing credentials. Community,
+// AuthPassword and PrivPassword may refer to ${secret:name}. Version 3
+// uses the user-based security model: authNoPriv with AuthProtocol,
+// authPriv with PrivProtocol too, and noAuthNoPriv with neither.
+type SNMPGroup struct {
+	Name string `json:"name" schema:"required"`
+	// Devices are host names, IPs or CIDRs, written like egress.noProxy.
+	Devices   []string `json:"devices" schema:"required"`
+	Version   string   `json:"version,omitempty" schema:"enum=2c|3"` // default 2c
+	Port      int      `json:"port,omitempty"`                       // default 161
+	Community string   `json:"community,omitempty"`
+
+	Username     string `json:"username,omitempty"`
+	AuthProtocol string `json:"authProtocol,omitempty" schema:"enum=MD5|SHA|SHA-224|SHA-256|SHA-384|SHA-512"`
+	AuthPassword string `json:"authPassword,omitempty"`
+	PrivProtocol string `json:"privProtocol,omitempty" schema:"enum=DES|AES"`
+	PrivPassword string `json:"privPassword,omitempty"`
+	Context      string `json:"context,omitempty"`
+
+	Timeout Duration `json:"timeout,omitempty" schema:"format=duration"` // per attempt; default 2s
+	Retries *int     `json:"retries,omitempty"`                          // default 2
+}
+
+const (
+	defaultSNMPTimeout = 2 * time.Second
+	defaultSNMPRetries = 2
+	defaultSNMPWalk    = 500
+	maxSNMPWalk        = 10000
+	maxSNMPGetOIDs     = 64
+	// snmpBulkSize is the max-repetitions of each GETBULK of a walk.
+	snmpBulkSize = 25
+)
+
+// PDU types, and the tags of values in variable bindings.
+const (
+	snmpGet      = 0xa0
+	snmpGetNext  = 0xa1
+	snmpResponse = 0xa2
+	snmpGetBulk  = 0xa5
+	snmpReport   = 0xa8
+
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	snmpIPAddress  = 0x40
+	snmpCounter32  = 0x41
+	snmpGauge32    = 0x42
+	snmpTimeTicks  = 0x43
+	snmpOpaque     = 0x44
+	snmpCounter64  = 0x46
+	snmpNoObject   = 0x80
+	snmpNoInstance = 0x81
+	snmpEndOfView  = 0x82
+)
+
+// v3 message flags.
+const (
+	snmpFlagAuth       = 0x01
+	snmpFlagPriv       = 0x02
+	snmpFlagReportable = 0x04
+)
+
+var snmpErrors = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// snmpReports explains the usmStats counters agents report v3 failures
+// with, by the OID under usmStats (1.3.6.1.6.3.15.1.1).
+var snmpReports = map[string]string{
+	"1": "unsupported security level",
+	"2": "not in time window",
+	"3": "unknown user name",
+	"4": "unknown engine ID",
+	"5": "wrong digest; check authProtocol and authPassword",
+	"6": "decryption error; check privProtocol and privPassword",
+}
+
+const usmStatsPrefix = "1.3.6.1.6.3.15.1.1."
+
+// snmpAuthProtocols are the HMACs of RFC 3414 and RFC 7860 with the
+// length their digests are truncated to.
+var snmpAuthProtocols = map[string]struct {
+	hash   func() hash.Hash
+	macLen int
+}{
+	"MD5":     {md5.New, 12},
+	"SHA":     {sha1.New, 12},
+	"SHA-224": {sha256.New224, 16},
+	"SHA-256": {sha256.New, 24},
+	"SHA-384": {sha512.New384, 32},
+	"SHA-512": {sha512.New, 48},
+}
+
+// snmpNames are MIB-2 objects tools accept by name, as in "sysName.0".
+var snmpNames = map[string]string{
+	"system":        "1.3.6.1.2.1.1",
+	"sysDescr":      "1.3.6.1.2.1.1.1",
+	"sysObjectID":   "1.3.6.1.2.1.1.2",
+	"sysUpTime":     "1.3.6.1.2.1.1.3",
+	"sysContact":    "1.3.6.1.2.1.1.4",
+	"sysName":       "1.3.6.1.2.1.1.5",
+	"sysLocation":   "1.3.6.1.2.1.1.6",
+	"sysServices":   "1.3.6.1.2.1.1.7",
+	"interfaces":    "1.3.6.1.2.1.2",
+	"ifNumber":      "1.3.6.1.2.1.2.1",
+	"ifTable":       "1.3.6.1.2.1.2.2",
+	"ifIndex":       "1.3.6.1.2.1.2.2.1.1",
+	"ifDescr":       "1.3.6.1.2.1.2.2.1.2",
+	"ifType":        "1.3.6.1.2.1.2.2.1.3",
+	"ifMtu":         "1.3.6.1.2.1.2.2.1.4",
+	"ifSpeed":       "1.3.6.1.2.1.2.2.1.5",
+	"ifPhysAddress": "1.3.6.1.2.1.2.2.1.6",
+	"ifAdminStatus": "1.3.6.1.2.1.2.2.1.7",
+	"ifOperStatus":  "1.3.6.1.2.1.2.2.1.8",
+	"ifLastChange":  "1.3.6.1.2.1.2.2.1.9",
+	"ifInOctets":    "1.3.6.1.2.1.2.2.1.10",
+	"ifInDiscards":  "1.3.6.1.2.1.2.2.1.13",
+	"ifInErrors":    "1.3.6.1.2.1.2.2.1.14",
+	"ifOutOctets":   "1.3.6.1.2.1.2.2.1.16",
+	"ifOutDiscards": "1.3.6.1.2.1.2.2.1.19",
+	"ifOutErrors":   "1.3.6.1.2.1.2.2.1.20",
+	"ifXTable":      "1.3.6.1.2.1.31.1.1",
+	"ifName":        "1.3.6.1.2.1.31.1.1.1.1",
+	"ifHCInOctets":  "1.3.6.1.2.1.31.1.1.1.6",
+	"ifHCOutOctets": "1.3.6.1.2.1.31.1.1.1.10",
+	"ifHighSpeed":   "1.3.6.1.2.1.31.1.1.1.15",
+	"ifAlias":       "1.3.6.1.2.1.31.1.1.1.18",
+	"ipAddrTable":   "1.3.6.1.2.1.4.20",
+	"hrSystem":      "1.3.6.1.2.1.25.1",
+	"hrStorage":     "1.3.6.1.2.1.25.2",
+}
+
+func (c SNMPConfig) enabled() bool {
+	return len(c.Groups) > 0
+}
+
+func (c SNMPConfig) check(secrets map[string]string) error {
+	seen := map[string]bool{}
+	for _, g := range c.Groups {
+		if g.Name == "" || seen[g.Name] {
+			return fmt.Errorf("group %q: names must be unique and non-empty", g.Name)
+		}
+		seen[g.Name] = true
+		if err := g.check(secrets); err != nil {
+			return fmt.Errorf("group %q: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+func (g SNMPGroup) check(secrets map[string]string) error {
+	if len(g.Devices) == 0 {
+		return errors.New("devices is required")
+	}
+	if err := checkHosts(g.Devices); err != nil {
+		return err
+	}
+	if g.Port < 0 || g.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", g.Port)
+	}
+	for _, v := range []string{g.Community, g.AuthPassword, g.PrivPassword} {
+		if _, err := expandSecrets(v, secrets); err != nil {
+			return err
+		}
+	}
+	switch g.Version {
+	case "", "2c":
+		if g.Community == "" {
+			return errors.New("community is required for version 2c")
+		}
+	case "3":
+		if g.Username == "" {
+			return errors.New("username is required for version 3")
+		}
+		if g.AuthProtocol != "" {
+			if _, ok := snmpAuthProtocols[g.AuthProtocol]; !ok {
+				return fmt.Errorf("unknown authProtocol %q", g.AuthProtocol)
+			}
+			if g.AuthPassword == "" {
+				return errors.New("authPassword is required with authProtocol")
+			}
+		}
+		switch g.PrivProtocol {
+		case "":
+		case "DES", "AES":
+			if g.AuthProtocol == "" {
+				return errors.New("privProtocol needs authProtocol")
+			}
+			if g.PrivPassword == "" {
+				return errors.New("privPassword is required with privProtocol")
+			}
+		default:
+			return fmt.Errorf("unknown privProtocol %q", g.PrivProtocol)
+		}
+	default:
+		return fmt.Errorf("version %q must be 2c or 3", g.Version)
+	}
+	return nil
+}
+
+// group returns the group of device, a host name or IP.
+func (c SNMPConfig) group(device string) (SNMPGroup, error) {
+	if device == "" || strings.ContainsAny(device, "/ ") {
+		return SNMPGroup{}, fmt.Errorf("invalid device %q", device)
+	}
+	for _, g := range c.Groups {
+		if matchHosts(g.Devices, device) {
+			return g, nil
+		}
+	}
+	return SNMPGroup{}, fmt.Errorf("device %s is in no snmp group", device)
+}
+
+type snmpGetArgs struct {
+	Device string   `json:"device" jsonschema:"required,description=Host name or IP of a device in a configured group"`
+	OIDs   []string `json:"oids" jsonschema:"required,minItems=1,maxItems=64,description=Object instances to read\\, numeric like 1.3.6.1.2.1.1.5.0 or MIB-2 names like sysName.0"`
+}
+
+type snmpWalkArgs struct {
+	Device string `json:"device" jsonschema:"required,description=Host name or IP of a device in a configured group"`
+	OID    string `json:"oid" jsonschema:"required,description=Subtree to walk\\, numeric or a MIB-2 name like ifTable or system"`
+	Limit  int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=10000,description=Most objects to return (default 500)"`
+}
+
+func (s *MCPServer) setupSNMPTools() {
+	openWorld := true
+	get, getHandler, _ := typedTool("snmp_get", "Read object instances from a network device over SNMP", s.snmpGetTool)
+	get.Annotations = readOnlyAnnotations()
+	get.Annotations.OpenWorldHint = &openWorld
+	s.addTool(get, getHandler)
+
+	walk, walkHandler, _ := typedTool("snmp_walk", "Read every object under an OID of a network device over SNMP, such as an interface table", s.snmpWalkTool)
+	walk.Annotations = readOnlyAnnotations()
+	walk.Annotations.OpenWorldHint = &openWorld
+	s.addTool(walk, walkHandler)
+}
+
+func (s *MCPServer) snmpGetTool(ctx context.Context, args snmpGetArgs) (interface{}, error) {
+	if len(args.OIDs) == 0 || len(args.OIDs) > maxSNMPGetOIDs {
+		return nil, fmt.Errorf("give 1 to %d oids", maxSNMPGetOIDs)
+	}
+	oids := make([][]uint32, len(args.OIDs))
+	for i, name := range args.OIDs {
+		oid, err := parseOID(name)
+		if err != nil {
+			return nil, err
+		}
+		oids[i] = oid
+	}
+	sess, err := s.dialSNMP(ctx, args.Device)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+	binds, err := sess.request(ctx, snmpGet, 0, 0, oids)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, len(binds))
+	for i, b := range binds {
+		results[i] = b.result()
+	}
+	return map[string]interface{}{"device": args.Device, "group": sess.group.Name, "results": results}, nil
+}
+
+func (s *MCPServer) snmpWalkTool(ctx context.Context, args snmpWalkArgs) (interface{}, error) {
+	root, err := parseOID(args.OID)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultSNMPWalk
+	}
+	limit = min(limit, maxSNMPWalk)
+	sess, err := s.dialSNMP(ctx, args.Device)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	results := []map[string]interface{}{}
+	last := root
+	truncated := false
+walk:
+	for {
+		binds, err := sess.request(ctx, snmpGetBulk, 0, min(snmpBulkSize, limit-len(results)+1), [][]uint32{last})
+		if err != nil {
+			return nil, err
+		}
+		if len(binds) == 0 {
+			break
+		}
+		for _, b := range binds {
+			if b.tag == snmpEndOfView || !oidHasPrefix(b.oid, root) {
+				break walk
+			}
+			if compareOIDs(b.oid, last) <= 0 {
+				return nil, fmt.Errorf("device returned %s after %s; its OIDs are not increasing", formatOID(b.oid), formatOID(last))
+			}
+			if len(results) == limit {
+				truncated = true
+				break walk
+			}
+			results = append(results, b.result())
+			last = b.oid
+		}
+	}
+	result := map[string]interface{}{"device": args.Device, "group": sess.group.Name, "oid": formatOID(root), "results": results}
+	if truncated {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+// snmpSession talks to one agent over UDP.
+type snmpSession struct {
+	conn    net.Conn
+	group   SNMPGroup
+	timeout time.Duration
+	retries int
+	reqID   int32
+
+	community string
+	// Version 3: the agent's engine and the keys localized to it.
+	user       string
+	auth       string
+	priv       string
+	authPass   string
+	privPass   string
+	engineID   []byte
+	boots      int32
+	engineTime int32
+	discovered time.Time
+	authKey    []byte
+	privKey    []byte
+	salt       uint64
+}
+
+func (s *MCPServer) dialSNMP(ctx context.Context, device string) (*snmpSession, error) {
+	g, err := s.cfg.SNMP.group(device)
+	if err != nil {
+		return nil, err
+	}
+	port := g.Port
+	if port == 0 {
+		port = 161
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(device, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	sess := &snmpSession{conn: conn, group: g, timeout: orDefault(g.Timeout, defaultSNMPTimeout), retries: defaultSNMPRetries}
+	if g.Retries != nil {
+		sess.retries = max(*g.Retries, 0)
+	}
+	var b [12]byte
+	rand.Read(b[:])
+	sess.reqID = int32(binary.BigEndian.Uint32(b[:4]) & 0x7fffffff)
+	sess.salt = binary.BigEndian.Uint64(b[4:])
+	if sess.community, err = expandSecrets(g.Community, s.secrets); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if g.Version == "3" {
+		sess.user, sess.auth, sess.priv = g.Username, g.AuthProtocol, g.PrivProtocol
+		if sess.authPass, err = expandSecrets(g.AuthPassword, s.secrets); err == nil {
+			sess.privPass, err = expandSecrets(g.PrivPassword, s.secrets)
+		}
+		if err == nil {
+			err = sess.discover(ctx)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", device, err)
+		}
+	}
+	return sess, nil
+}
+
+func (sess *snmpSession) close() {
+	sess.conn.Close()
+}
+
+// snmpBinding is a variable binding of a response.
+type snmpBinding struct {
+	oid   []uint32
+	tag   byte
+	value []byte
+}
+
+// result renders the binding for a tool result.
+func (b snmpBinding) result() map[string]interface{} {
+	oid := formatOID(b.oid)
+	typ, value := snmpValue(b.tag, b.value)
+	r := map[string]interface{}{"oid": oid, "type": typ, "value": value}
+	if name := oidName(b.oid); name != "" {
+		r["name"] = name
+	}
+	return r
+}
+
+// request sends a PDU and returns the response's bindings, retrying
+// after a timeout. For GETBULK, a and b are non-repeaters and
+// max-repetitions.
+func (sess *snmpSession) request(ctx context.Context, pduType byte, a, b int, oids [][]uint32) ([]snmpBinding, error) {
+	for resynced := false; ; resynced = true {
+		binds, err := sess.exchange(ctx, pduType, a, b, oids)
+		var rep *snmpReportError
+		if errors.As(err, &rep) && rep.oid == usmStatsPrefix+"2.0" && !resynced {
+			// The report carried the engine's current boots and time.
+			continue
+		}
+		return binds, err
+	}
+}
+
+func (sess *snmpSession) exchange(ctx context.Context, pduType byte, a, b int, oids [][]uint32) ([]snmpBinding, error) {
+	sess.reqID++
+	id := sess.reqID
+	pdu := encodePDU(pduType, id, a, b, oids)
+	var msg []byte
+	var err error
+	if sess.group.Version == "3" {
+		msg, err = sess.encodeV3(pdu, id, sess.flags())
+	} else {
+		msg = berTLV(berSequence, berInt(berInteger, 1), berTLV(berOctetString, []byte(sess.community)), pdu)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { sess.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	buf := make([]byte, 65535)
+	for attempt := 0; attempt <= sess.retries; attempt++ {
+		if _, err := sess.conn.Write(msg); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(sess.timeout)
+		sess.conn.SetReadDeadline(deadline)
+		for {
+			n, err := sess.conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			binds, rid, err := sess.decode(append([]byte(nil), buf[:n]...))
+			if err != nil {
+				return nil, err
+			}
+			if rid == id {
+				return binds, nil
+			}
+			// A late answer to an earlier attempt.
+		}
+	}
+	return nil, fmt.Errorf("no response from %s after %d attempts", sess.conn.RemoteAddr(), sess.retries+1)
+}
+
+func (sess *snmpSession) flags() byte {
+	flags := byte(snmpFlagReportable)
+	if sess.auth != "" {
+		flags |= snmpFlagAuth
+	}
+	if sess.priv != "" {
+		flags |= snmpFlagPriv
+	}
+	return flags
+}
+
+// decode parses a response message, returning its bindings and request ID.
+func (sess *snmpSession) decode(msg []byte) ([]snmpBinding, int32, error) {
+	r := newBERReader(msg)
+	top := r.sequence(berSequence)
+	version := top.integer(berInteger)
+	if sess.group.Version != "3" {
+		if version != 1 {
+			return nil, 0, fmt.Errorf("snmp: unexpected message version %d", version)
+		}
+		top.read(berOctetString) // community
+		if top.err != nil {
+			return nil, 0, top.err
+		}
+		return decodePDU(top)
+	}
+	if version != 3 {
+		return nil, 0, fmt.Errorf("snmp: unexpected message version %d", version)
+	}
+	pdu, msgID, err := sess.decodeV3(msg, top)
+	if err != nil {
+		return nil, 0, err
+	}
+	binds, _, err := decodePDU(pdu)
+	return binds, msgID, err
+}
+
+func decodePDU(r *berReader) ([]snmpBinding, int32, error) {
+	tag, content := r.next()
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if tag != snmpResponse && tag != snmpReport {
+		return nil, 0, fmt.Errorf("snmp: unexpected PDU type %#x", tag)
+	}
+	p := subReader(r, content)
+	id := int32(p.integer(berInteger))
+	status := p.integer(berInteger)
+	index := p.integer(berInteger)
+	list := p.sequence(berSequence)
+	var binds []snmpBinding
+	for list.more() {
+		vb := list.sequence(berSequence)
+		oid := decodeOID(vb.read(berOID))
+		tag, value := vb.next()
+		binds = append(binds, snmpBinding{oid: oid, tag: tag, value: value})
+	}
+	if err := errors.Join(p.err, list.err); err != nil {
+		return nil, 0, err
+	}
+	if tag == snmpReport {
+		oid := ""
+		if len(binds) > 0 {
+			oid = formatOID(binds[0].oid)
+		}
+		return nil, id, &snmpReportError{oid: oid}
+	}
+	if status != 0 {
+		name := fmt.Sprintf("error %d", status)
+		if status > 0 && int(status) < len(snmpErrors) {
+			name = snmpErrors[status]
+		}
+		if index > 0 && int(index) <= len(binds) {
+			return nil, id, fmt.Errorf("snmp: %s at %s", name, formatOID(binds[index-1].oid))
+		}
+		return nil, id, fmt.Errorf("snmp: %s", name)
+	}
+	return binds, id, nil
+}
+
+// snmpReportError is a v3 report, by the OID of the counter it carries.
+type snmpReportError struct{ oid string }
+
+func (e *snmpReportError) Error() string {
+	if why, ok := snmpReports[strings.TrimSuffix(strings.TrimPrefix(e.oid, usmStatsPrefix), ".0")]; ok {
+		return "snmp: " + why
+	}
+	return "snmp: report " + e.oid
+}
+
+// discover learns the agent's engine ID, boots and time from the report
+// to an unauthenticated request, then localizes the keys to that engine.
+func (sess *snmpSession) discover(ctx context.Context) error {
+	user, auth, priv := sess.user, sess.auth, sess.priv
+	sess.user, sess.auth, sess.priv = "", "", ""
+	_, err := sess.exchange(ctx, snmpGet, 0, 0, nil)
+	sess.user, sess.auth, sess.priv = user, auth, priv
+	var rep *snmpReportError
+	if err != nil && !errors.As(err, &rep) {
+		return err
+	}
+	if len(sess.engineID) == 0 {
+		return errors.New("snmp: agent did not report its engine ID")
+	}
+	if sess.auth != "" {
+		// Shorter passwords are refused by RFC 3414, and by agents.
+		if len(sess.authPass) < 8 || sess.priv != "" && len(sess.privPass) < 8 {
+			return errors.New("snmp: passwords need at least 8 characters")
+		}
+		proto := snmpAuthProtocols[sess.auth]
+		sess.authKey = snmpLocalKey(proto.hash, sess.authPass, sess.engineID)
+		if sess.priv != "" {
+			sess.privKey = snmpLocalKey(proto.hash, sess.privPass, sess.engineID)
+			if len(sess.privKey) < 16 {
+				return errors.New("snmp: privacy key too short")
+			}
+		}
+	}
+	return nil
+}
+
+// encodeV3 wraps a PDU in a v3 message, encrypting and signing it per flags.
+func (sess *snmpSession) encodeV3(pdu []byte, msgID int32, flags byte) ([]byte, error) {
+	scoped := berTLV(berSequence, berTLV(berOctetString, sess.engineID), berTLV(berOctetString, []byte(sess.group.Context)), pdu)
+	boots, engineTime := sess.boots, sess.engineTime
+	if !sess.discovered.IsZero() {
+		engineTime += int32(time.Since(sess.discovered) / time.Second)
+	}
+	var privParams []byte
+	if flags&snmpFlagPriv != 0 {
+		var err error
+		if scoped, privParams, err = sess.encrypt(scoped, boots, engineTime); err != nil {
+			return nil, err
+		}
+		scoped = berTLV(berOctetString, scoped)
+	}
+	var authParams []byte
+	if flags&snmpFlagAuth != 0 {
+		authParams = make([]byte, snmpAuthProtocols[sess.auth].macLen)
+	}
+	privTLV := berTLV(berOctetString, privParams)
+	secParams := berTLV(berSequence,
+		berTLV(berOctetString, sess.engineID),
+		berInt(berInteger, int64(boots)),
+		berInt(berInteger, int64(engineTime)),
+		berTLV(berOctetString, []byte(sess.user)),
+		berTLV(berOctetString, authParams),
+		privTLV)
+	msg := berTLV(berSequence,
+		berInt(berInteger, 3),
+		berTLV(berSequence, berInt(berInteger, int64(msgID)), berInt(berInteger, 65507), berTLV(berOctetString, []byte{flags}), berInt(berInteger, 3)),
+		berTLV(berOctetString, secParams),
+		scoped)
+	if flags&snmpFlagAuth != 0 {
+		// The digest covers the message with its own place zeroed, just
+		// before the privacy parameters at the end of secParams.
+		off := bytes.Index(msg, secParams) + len(secParams) - len(privTLV) - len(authParams)
+		mac := hmac.New(snmpAuthProtocols[sess.auth].hash, sess.authKey)
+		mac.Write(msg)
+		copy(msg[off:], mac.Sum(nil)[:len(authParams)])
+	}
+	return msg, nil
+}
+
+// decodeV3 checks the security parameters of a response, keeping the
+// engine's identity and clock, and returns the reader of its PDU and the
+// message ID, which unlike the request ID is readable in every report.
+func (sess *snmpSession) decodeV3(msg []byte, top *berReader) (*berReader, int32, error) {
+	global := top.sequence(berSequence)
+	msgID := int32(global.integer(berInteger))
+	global.integer(berInteger) // max size
+	flags := global.read(berOctetString)
+	global.integer(berInteger) // security model
+	secRaw := top.read(berOctetString)
+	if err := errors.Join(global.err, top.err); err != nil {
+		return nil, 0, err
+	}
+	sec := subReader(top, secRaw)
+	sp := sec.sequence(berSequence)
+	engineID := sp.read(berOctetString)
+	boots := int32(sp.integer(berInteger))
+	engineTime := int32(sp.integer(berInteger))
+	sp.read(berOctetString) // user
+	authPos := sp.pos
+	authParams := sp.read(berOctetString)
+	privParams := sp.read(berOctetString)
+	if sp.err != nil {
+		return nil, 0, sp.err
+	}
+	if len(flags) != 1 {
+		return nil, 0, errors.New("snmp: malformed message flags")
+	}
+	if flags[0]&snmpFlagAuth != 0 {
+		if sess.authKey == nil {
+			return nil, 0, errors.New("snmp: authenticated response to an unauthenticated request")
+		}
+		if len(authParams) != snmpAuthProtocols[sess.auth].macLen {
+			return nil, 0, errors.New("snmp: response failed authentication")
+		}
+		zeroed := append([]byte(nil), msg...)
+		// The OCTET STRING's tag and one length byte precede the digest.
+		start := authPos + 2
+		for i := range authParams {
+			zeroed[start+i] = 0
+		}
+		mac := hmac.New(snmpAuthProtocols[sess.auth].hash, sess.authKey)
+		mac.Write(zeroed)
+		if !hmac.Equal(mac.Sum(nil)[:len(authParams)], authParams) {
+			return nil, 0, errors.New("snmp: response failed authentication")
+		}
+	}
+	// Once keys are set, only authenticated engine clocks are believed;
+	// reports of authentication failures come unauthenticated.
+	if flags[0]&snmpFlagAuth != 0 || sess.authKey == nil {
+		if len(engineID) > 0 {
+			sess.engineID = append([]byte(nil), engineID...)
+		}
+		sess.boots, sess.engineTime, sess.discovered = boots, engineTime, time.Now()
+	}
+
+	if flags[0]&snmpFlagPriv == 0 {
+		scoped := top.sequence(berSequence)
+		scoped.read(berOctetString) // context engine ID
+		scoped.read(berOctetString) // context name
+		return scoped, msgID, top.err
+	}
+	if sess.privKey == nil {
+		return nil, 0, errors.New("snmp: encrypted response to an unencrypted request")
+	}
+	plain, err := sess.decrypt(top.read(berOctetString), privParams, boots, engineTime)
+	if err != nil {
+		return nil, 0, err
+	}
+	pr := newBERReader(plain)
+	scoped := pr.sequence(berSequence)
+	scoped.read(berOctetString)
+	scoped.read(berOctetString)
+	return scoped, msgID, errors.Join(top.err, scoped.err)
+}
+
+// encrypt applies CBC-DES (RFC 3414) or CFB-AES-128 (RFC 3826) to the
+// scoped PDU, returning it and the salt for msgPrivacyParameters.
+func (sess *snmpSession) encrypt(scoped []byte, boots, engineTime int32) ([]byte, []byte, error) {
+	sess.salt++
+	switch sess.priv {
+	case "DES":
+		salt := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(boots)), uint32(sess.salt))
+		block, err := des.NewCipher(sess.privKey[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = sess.privKey[8+i] ^ salt[i]
+		}
+		padded := append(scoped, make([]byte, (8-len(scoped)%8)%8)...)
+		out := make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+		return out, salt, nil
+	default:
+		salt := binary.BigEndian.AppendUint64(nil, sess.salt)
+		block, err := aes.NewCipher(sess.privKey[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]byte, len(scoped))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, scoped)
+		return out, salt, nil
+	}
+}
+
+func (sess *snmpSession) decrypt(data, salt []byte, boots, engineTime int32) ([]byte, error) {
+	if len(salt) != 8 {
+		return nil, errors.New("snmp: malformed privacy parameters")
+	}
+	switch sess.priv {
+	case "DES":
+		if len(data)%8 != 0 {
+			return nil, errors.New("snmp: malformed encrypted PDU")
+		}
+		block, err := des.NewCipher(sess.privKey[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = sess.privKey[8+i] ^ salt[i]
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		return out, nil
+	default:
+		block, err := aes.NewCipher(sess.privKey[:16])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime, salt)).XORKeyStream(out, data)
+		return out, nil
+	}
+}
+
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := binary.BigEndian.AppendUint32(nil, uint32(boots))
+	iv = binary.BigEndian.AppendUint32(iv, uint32(engineTime))
+	return append(iv, salt...)
+}
+
+// snmpLocalKey turns a password into a key localized to an engine, per
+// RFC 3414 A.2: a megabyte of the repeated password is hashed, and the
+// digest hashed again around the engine ID.
+func snmpLocalKey(newHash func() hash.Hash, password string, engineID []byte) []byte {
+	h := newHash()
+	buf := make([]byte, 64)
+	for i, n := 0, 0; n < 1<<20; n += len(buf) {
+		for j := range buf {
+			buf[j] = password[i%len(password)]
+			i++
+		}
+		h.Write(buf)
+	}
+	ku := h.Sum(nil)
+	h.Reset()
+	h.Write(ku)
+	h.Write(engineID)
+	h.Write(ku)
+	return h.Sum(nil)
+}
+
+func encodePDU(pduType byte, id int32, a, b int, oids [][]uint32) []byte {
+	binds := make([][]byte, len(oids))
+	for i, oid := range oids {
+		binds[i] = berTLV(berSequence, berTLV(berOID, encodeOID(oid)), berTLV(berNull))
+	}
+	return berTLV(pduType, berInt(berInteger, int64(id)), berInt(berInteger, int64(a)), berInt(berInteger, int64(b)),
+		berTLV(berSequence, binds...))
+}
+
+// snmpValue converts a value to its type name and a JSON value. Octet
+// strings are text when printable and colon-separated hex otherwise, the
+// way MAC addresses are written.
+func snmpValue(tag byte, v []byte) (string, interface{}) {
+	switch tag {
+	case berInteger:
+		return "Integer", berSigned(v)
+	case berOctetString:
+		if utf8.Valid(v) && strings.IndexFunc(string(v), func(r rune) bool {
+			return !unicode.IsPrint(r) && r != '\n' && r != '\r' && r != '\t'
+		}) < 0 {
+			return "OctetString", string(v)
+		}
+		hex := make([]string, len(v))
+		for i, c := range v {
+			hex[i] = fmt.Sprintf("%02x", c)
+		}
+		return "OctetString", strings.Join(hex, ":")
+	case berNull:
+		return "Null", nil
+	case berOID:
+		return "ObjectIdentifier", formatOID(decodeOID(v))
+	case snmpIPAddress:
+		if len(v) == 4 {
+			return "IpAddress", net.IP(v).String()
+		}
+		return "IpAddress", nil
+	case snmpCounter32:
+		return "Counter32", berUnsigned(v)
+	case snmpGauge32:
+		return "Gauge32", berUnsigned(v)
+	case snmpTimeTicks:
+		return "TimeTicks", berUnsigned(v)
+	case snmpCounter64:
+		return "Counter64", berUnsigned(v)
+	case snmpOpaque:
+		return "Opaque", fmt.Sprintf("%x", v)
+	case snmpNoObject:
+		return "NoSuchObject", nil
+	case snmpNoInstance:
+		return "NoSuchInstance", nil
+	case snmpEndOfView:
+		return "EndOfMibView", nil
+	}
+	return fmt.Sprintf("Tag%#x", tag), fmt.Sprintf("%x", v)
+}
+
+// parseOID reads a dotted OID, optionally with a leading dot, or a name
+// from snmpNames followed by an instance suffix.
+func parseOID(s string) ([]uint32, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(s), ".")
+	name, rest, _ := strings.Cut(text, ".")
+	if prefix, ok := snmpNames[name]; ok {
+		text = prefix
+		if rest != "" {
+			text += "." + rest
+		}
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make([]uint32, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = uint32(n)
+	}
+	if oid[0] > 2 || oid[0] < 2 && oid[1] >= 40 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+func formatOID(oid []uint32) string {
+	parts := make([]string, len(oid))
+	for i, n := range oid {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// oidName writes oid with the longest matching name of snmpNames, or
+// returns "" when none matches.
+func oidName(oid []uint32) string {
+	text := formatOID(oid)
+	best, bestPrefix := "", ""
+	for name, prefix := range snmpNames {
+		if (text == prefix || strings.HasPrefix(text, prefix+".")) && len(prefix) > len(bestPrefix) {
+			best, bestPrefix = name, prefix
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return best + strings.TrimPrefix(text, bestPrefix)
+}
+
+func oidHasPrefix(oid, prefix []uint32) bool {
+	return len(oid) >= len(prefix) && compareOIDs(oid[:len(prefix)], prefix) == 0
+}
+
+func compareOIDs(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// encodeOID encodes oid, whose first two arcs share a subidentifier.
+func encodeOID(oid []uint32) []byte {
+	var out []byte
+	for _, n := range append([]uint32{oid[0]*40 + oid[1]}, oid[2:]...) {
+		var enc []byte
+		enc = append(enc, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+		}
+		out = append(out, enc...)
+	}
+	return out
+}
+
+func decodeOID(b []byte) []uint32 {
+	if len(b) == 0 {
+		return nil
+	}
+	var oid []uint32
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			continue
+		}
+		if oid == nil {
+			// The first byte holds the first two arcs.
+			first := min(n/40, 2)
+			oid = append(oid, first, n-first*40)
+		} else {
+			oid = append(oid, n)
+		}
+		n = 0
+	}
+	return oid
+}
+
+// berTLV encodes a tag with the concatenated content.
+func berTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	out := []byte{tag}
+	if n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var l []byte
+		for m := n; m > 0; m >>= 8 {
+			l = append([]byte{byte(m)}, l...)
+		}
+		out = append(append(out, 0x80|byte(len(l))), l...)
+	}
+	for _, c := range content {
+		out = append(out, c...)
+	}
+	return out
+}
+
+// berInt encodes v in the fewest two's complement bytes.
+func berInt(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for v > 127 || v < -128 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berSigned(b []byte) int64 {
+	var v int64
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, c := range b {
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func berUnsigned(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// berReader decodes BER with single-byte tags and definite lengths. It
+// tracks positions in the whole message, for the digest of v3. The
+// first error sticks.
+type berReader struct {
+	data     []byte
+	pos, end int
+	err      error
+}
+
+func newBERReader(b []byte) *berReader {
+	return &berReader{data: b, end: len(b)}
+}
+
+// subReader reads content, which must be what r read last.
+func subReader(r *berReader, content []byte) *berReader {
+	return &berReader{data: r.data, pos: r.pos - len(content), end: r.pos, err: r.err}
+}
+
+func (r *berReader) more() bool {
+	return r.err == nil && r.pos < r.end
+}
+
+func (r *berReader) fail() {
+	if r.err == nil {
+		r.err = errors.New("snmp: malformed message")
+	}
+}
+
+func (r *berReader) next() (byte, []byte) {
+	if r.err != nil || r.pos+2 > r.end {
+		r.fail()
+		return 0, nil
+	}
+	tag := r.data[r.pos]
+	n := int(r.data[r.pos+1])
+	r.pos += 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || r.pos+size > r.end {
+			r.fail()
+			return 0, nil
+		}
+		n = 0
+		for _, c := range r.data[r.pos : r.pos+size] {
+			n = n<<8 | int(c)
+		}
+		r.pos += size
+	}
+	if r.pos+n > r.end {
+		r.fail()
+		return 0, nil
+	}
+	content := r.data[r.pos : r.pos+n : r.pos+n]
+	r.pos += n
+	return tag, content
+}
+
+func (r *berReader) read(want byte) []byte {
+	tag, content := r.next()
+	if r.err == nil && tag != want {
+		r.fail()
+	}
+	return content
+}
+
+func (r *berReader) integer(tag byte) int64 {
+	return berSigned(r.read(tag))
+}
+
+func (r *berReader) sequence(tag byte) *berReader {
+	content := r.read(tag)
+	return subReader(r, content)
+}
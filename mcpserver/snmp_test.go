@@ -0,0 +1,233 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// TestSNMPLocalKey checks key localization against RFC 3414 A.3.1 and
+// A.3.2.
+func TestSNMPLocalKey(t *testing.T) {
+	engineID, _ := hex.DecodeString("000000000000000000000002")
+	if got := hex.EncodeToString(snmpLocalKey(md5.New, "maplesyrup", engineID)); got != "526f5eed9fcce26f8964c2930787d82b" {
+		t.Errorf("MD5 key %s", got)
+	}
+	if got := hex.EncodeToString(snmpLocalKey(sha1.New, "maplesyrup", engineID)); got != "6695febc9288e36282235fc7151f128497b38f3f" {
+		t.Errorf("SHA key %s", got)
+	}
+}
+
+func TestSNMPOID(t *testing.T) {
+	for text, enc := range map[string]string{
+		"1.3.6.1.2.1.1.5.0":         "2b06010201010500",
+		"1.3.6.1.4.1.311.21.20":     "2b0601040182371514",
+		"2.999.3":                   "883703",
+		"1.3.6.1.6.3.15.1.1.4.0":    "2b060106030f01010400",
+		"1.3.6.1.4.1.2636.3.1.13.1": "2b06010401944c03010d01",
+	} {
+		oid, err := parseOID(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(encodeOID(oid)); got != enc {
+			t.Errorf("encodeOID(%s) = %s, want %s", text, got, enc)
+		}
+		b, _ := hex.DecodeString(enc)
+		if got := formatOID(decodeOID(b)); got != text {
+			t.Errorf("decodeOID(%s) = %s", enc, got)
+		}
+	}
+	if oid, _ := parseOID("sysName.0"); formatOID(oid) != "1.3.6.1.2.1.1.5.0" {
+		t.Errorf("sysName.0 is %v", oid)
+	}
+	for _, bad := range []string{"", "1", "3.1", "1.40", "1.3.x", "1.3.4294967296"} {
+		if _, err := parseOID(bad); err == nil {
+			t.Errorf("parseOID(%q) accepted", bad)
+		}
+	}
+}
+
+// fakeSNMPAgent answers SNMPv3 requests for sysName.0 as a USM agent
+// with the given protocols. The first authenticated request gets a
+// notInTimeWindow report carrying a later boot count.
+type fakeSNMPAgent struct {
+	conn     net.PacketConn
+	sess     *snmpSession
+	requests atomic.Int32
+	errs     chan error
+}
+
+func newFakeSNMPAgent(t *testing.T, user, auth, authPass, priv, privPass string) *fakeSNMPAgent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engineID, _ := hex.DecodeString("80001f8880e9630000d61ff449")
+	sess := &snmpSession{group: SNMPGroup{Version: "3"}, user: user, auth: auth, priv: priv, engineID: engineID, boots: 1, engineTime: 1000}
+	if auth != "" {
+		sess.authKey = snmpLocalKey(snmpAuthProtocols[auth].hash, authPass, engineID)
+	}
+	if priv != "" {
+		sess.privKey = snmpLocalKey(snmpAuthProtocols[auth].hash, privPass, engineID)
+	}
+	a := &fakeSNMPAgent{conn: conn, sess: sess, errs: make(chan error, 10)}
+	go a.serve()
+	t.Cleanup(func() {
+		conn.Close()
+		select {
+		case err := <-a.errs:
+			t.Error(err)
+		default:
+		}
+	})
+	return a
+}
+
+func (a *fakeSNMPAgent) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if err := a.answer(buf[:n], from); err != nil {
+			a.errs <- err
+		}
+	}
+}
+
+func (a *fakeSNMPAgent) answer(msg []byte, from net.Addr) error {
+	sess := a.sess
+	top := newBERReader(msg).sequence(berSequence)
+	if v := top.integer(berInteger); v != 3 {
+		return fmt.Errorf("version %d", v)
+	}
+	flags, user := snmpTestHeader(msg)
+	scoped, msgID, err := sess.decodeV3(append([]byte(nil), msg...), top)
+	if err != nil {
+		return err
+	}
+	tag, content := scoped.next()
+	p := subReader(scoped, content)
+	reqID := p.integer(berInteger)
+	p.integer(berInteger)
+	p.integer(berInteger)
+	list := p.sequence(berSequence)
+	var oids [][]uint32
+	for list.more() {
+		vb := list.sequence(berSequence)
+		oids = append(oids, decodeOID(vb.read(berOID)))
+	}
+	if err := scoped.err; err != nil || tag != snmpGet {
+		return fmt.Errorf("request PDU %#x: %v", tag, err)
+	}
+
+	var pdu []byte
+	var replyFlags byte
+	switch {
+	case user == "":
+		// Discovery: report the engine, unauthenticated.
+		user, auth, priv := sess.user, sess.auth, sess.priv
+		sess.user, sess.auth, sess.priv = "", "", ""
+		defer func() { sess.user, sess.auth, sess.priv = user, auth, priv }()
+		pdu = snmpTestPDU(snmpReport, reqID, "1.3.6.1.6.3.15.1.1.4.0", berInt(snmpCounter32, 1))
+	case flags&snmpFlagAuth != 0 && a.requests.Add(1) == 1:
+		sess.boots++
+		replyFlags = snmpFlagAuth
+		pdu = snmpTestPDU(snmpReport, reqID, "1.3.6.1.6.3.15.1.1.2.0", berInt(snmpCounter32, 1))
+	default:
+		replyFlags = flags &^ snmpFlagReportable
+		if len(oids) != 1 || formatOID(oids[0]) != "1.3.6.1.2.1.1.5.0" {
+			return fmt.Errorf("asked for %v", oids)
+		}
+		pdu = snmpTestPDU(snmpResponse, reqID, "1.3.6.1.2.1.1.5.0", berTLV(berOctetString, []byte("core-sw1")))
+	}
+	reply, err := sess.encodeV3(pdu, msgID, replyFlags)
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteTo(reply, from)
+	return err
+}
+
+// snmpTestHeader returns the msgFlags and user name of a v3 message.
+func snmpTestHeader(msg []byte) (byte, string) {
+	top := newBERReader(msg).sequence(berSequence)
+	top.integer(berInteger)
+	global := top.sequence(berSequence)
+	global.integer(berInteger)
+	global.integer(berInteger)
+	flags := global.read(berOctetString)
+	global.integer(berInteger)
+	sp := subReader(top, top.read(berOctetString)).sequence(berSequence)
+	sp.read(berOctetString)
+	sp.integer(berInteger)
+	sp.integer(berInteger)
+	user := sp.read(berOctetString)
+	if len(flags) != 1 {
+		return 0, ""
+	}
+	return flags[0], string(user)
+}
+
+func snmpTestPDU(pduType byte, id int64, oid string, value []byte) []byte {
+	o, _ := parseOID(oid)
+	return berTLV(pduType, berInt(berInteger, id), berInt(berInteger, 0), berInt(berInteger, 0),
+		berTLV(berSequence, berTLV(berSequence, berTLV(berOID, encodeOID(o)), value)))
+}
+
+func TestSNMPv3Get(t *testing.T) {
+	for _, tc := range []struct{ auth, priv string }{
+		{"", ""},
+		{"MD5", ""},
+		{"SHA", "DES"},
+		{"SHA", "AES"},
+		{"SHA-256", "AES"},
+	} {
+		t.Run(tc.auth+"/"+tc.priv, func(t *testing.T) {
+			agent := newFakeSNMPAgent(t, "monitor", tc.auth, "authpass1", tc.priv, "privpass1")
+			port := agent.conn.LocalAddr().(*net.UDPAddr).Port
+			group := SNMPGroup{Name: "core", Devices: []string{"127.0.0.1"}, Version: "3", Port: port, Username: "monitor"}
+			if tc.auth != "" {
+				group.AuthProtocol, group.AuthPassword = tc.auth, "authpass1"
+			}
+			if tc.priv != "" {
+				group.PrivProtocol, group.PrivPassword = tc.priv, "privpass1"
+			}
+			s := &MCPServer{cfg: &Config{SNMP: SNMPConfig{Groups: []SNMPGroup{group}}}}
+			res, err := s.snmpGetTool(context.Background(), snmpGetArgs{Device: "127.0.0.1", OIDs: []string{"sysName.0"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results := res.(map[string]interface{})["results"].([]map[string]interface{})
+			if len(results) != 1 || results[0]["value"] != "core-sw1" || results[0]["name"] != "sysName.0" {
+				t.Errorf("results %v", results)
+			}
+			if n := agent.requests.Load(); tc.auth != "" && n != 2 {
+				t.Errorf("agent answered %d authenticated requests; the client did not resync its clock", n)
+			}
+		})
+	}
+}
+
+func TestSNMPv3WrongPassword(t *testing.T) {
+	agent := newFakeSNMPAgent(t, "monitor", "SHA", "authpass1", "", "")
+	// The agent cannot verify the request, so it stays silent.
+	port := agent.conn.LocalAddr().(*net.UDPAddr).Port
+	retries := 0
+	group := SNMPGroup{Name: "core", Devices: []string{"127.0.0.1"}, Version: "3", Port: port, Username: "monitor",
+		AuthProtocol: "SHA", AuthPassword: "wrongpass", Timeout: Duration(50e6), Retries: &retries}
+	s := &MCPServer{cfg: &Config{SNMP: SNMPConfig{Groups: []SNMPGroup{group}}}}
+	if _, err := s.snmpGetTool(context.Background(), snmpGetArgs{Device: "127.0.0.1", OIDs: []string{"sysName.0"}}); err == nil {
+		t.Fatal("get with the wrong password succeeded")
+	}
+	if err := <-agent.errs; err == nil || err.Error() != "snmp: response failed authentication" {
+		t.Errorf("agent: %v", err)
+	}
+}
@@ -0,0 +1,916 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxSheetColumns is Excel's column limit, XFD.
+	maxSheetColumns        = 16384
+	defaultSheetRowLimit   = 100
+	maxSheetQueryRows      = 200000
+	maxSpreadsheetGroups   = 100000
+	defaultSpreadsheetName = "Sheet1"
+)
+
+// sheetRange is a block of cells; zero bounds are open.
+type sheetRange struct {
+	r1, c1, r2, c2 int
+}
+
+// parseSheetRange reads A1 notation: a cell (B2), a block (A1:D20),
+// columns (B:D) or rows (2:50); the end of a block may leave out its row
+// or column (A2:D).
+func parseSheetRange(s string) (sheetRange, error) {
+	if s == "" {
+		return sheetRange{}, nil
+	}
+	from, to, isBlock := strings.Cut(strings.ReplaceAll(s, "$", ""), ":")
+	if !isBlock {
+		to = from
+	}
+	r1, c1, ok1 := parseCellRef(from)
+	r2, c2, ok2 := parseCellRef(to)
+	if !ok1 || !ok2 || (r2 != 0 && r1 > r2) || (c2 != 0 && c1 > c2) {
+		return sheetRange{}, fmt.Errorf("range %q: use A1 notation such as A1:D20, B:D or 2:50", s)
+	}
+	return sheetRange{r1, c1, r2, c2}, nil
+}
+
+// spreadsheet is an open CSV file or workbook sheet.
+type spreadsheet struct {
+	sheets []string
+	sheet  string
+	scan   func(fn func(row int, cells []interface{}) error) error
+	close  func() error
+}
+
+func (s *MCPServer) openSpreadsheet(root, rel, sheet, delimiter string) (*spreadsheet, error) {
+	r, err := s.cfg.Workspace.root(root)
+	if err != nil {
+		return nil, err
+	}
+	full, err := r.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	switch ext := strings.ToLower(path.Ext(rel)); ext {
+	case ".xlsx", ".xlsm":
+		wb, err := openXLSX(full)
+		if err != nil {
+			return nil, err
+		}
+		if len(wb.sheets) == 0 {
+			wb.Close()
+			return nil, errors.New("the workbook has no sheets")
+		}
+		ref := wb.sheets[0]
+		if sheet != "" {
+			found := false
+			for _, s := range wb.sheets {
+				if s.name == sheet {
+					ref, found = s, true
+				}
+			}
+			if !found {
+				wb.Close()
+				return nil, fmt.Errorf("no sheet %q; the workbook has %s", sheet, strings.Join(wb.sheetNames(), ", "))
+			}
+		}
+		return &spreadsheet{
+			sheets: wb.sheetNames(),
+			sheet:  ref.name,
+			scan: func(fn func(int, []interface{}) error) error {
+				return wb.scanSheet(ref.part, fn)
+			},
+			close: wb.Close,
+		}, nil
+	case ".csv", ".tsv", ".txt":
+		f, err := os.Open(full)
+		if err != nil {
+			return nil, err
+		}
+		comma := ','
+		if ext == ".tsv" {
+			comma = '\t'
+		}
+		if delimiter != "" {
+			comma = []rune(delimiter)[0]
+		}
+		return csvSpreadsheet(f, comma, f.Close), nil
+	}
+	return nil, fmt.Errorf("%s is not a .csv, .tsv or .xlsx file", rel)
+}
+
+// csvSpreadsheet reads CSV from r, which can be scanned once.
+func csvSpreadsheet(r io.Reader, comma rune, close func() error) *spreadsheet {
+	return &spreadsheet{
+		scan: func(fn func(int, []interface{}) error) error {
+			cr := csv.NewReader(r)
+			cr.Comma = comma
+			cr.FieldsPerRecord = -1
+			cr.LazyQuotes = true
+			cr.ReuseRecord = true
+			var cells []interface{}
+			for row := 1; ; row++ {
+				rec, err := cr.Read()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if len(rec) > maxSheetColumns {
+					return fmt.Errorf("row %d has more than %d columns", row, maxSheetColumns)
+				}
+				cells = cells[:0]
+				for _, cell := range rec {
+					cells = append(cells, inferScalar(cell))
+				}
+				if err := fn(row, cells); err == errStopScan {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		},
+		close: close,
+	}
+}
+
+// sheetTable scans the range of a spreadsheet as a table whose first row
+// names the columns when header is set; otherwise the columns are named
+// by their letters.
+type sheetTable struct {
+	columns []string
+	first   int // column number of columns[0]
+}
+
+func (t *sheetTable) index(name string) (int, error) {
+	for i, c := range t.columns {
+		if c == name {
+			return i, nil
+		}
+	}
+	// Fall back to the column's letters.
+	if row, col, ok := parseCellRef(name); ok && row == 0 && col >= t.first && col-t.first < len(t.columns) && name == strings.ToUpper(name) {
+		return col - t.first, nil
+	}
+	return 0, fmt.Errorf("no column %q; the columns are %s", name, strings.Join(t.columns, ", "))
+}
+
+func scanSheetTable(sp *spreadsheet, rng sheetRange, header bool, fn func(t *sheetTable, row int, cells []interface{}) error) (*sheetTable, error) {
+	first := rng.c1
+	if first == 0 {
+		first = 1
+	}
+	t := &sheetTable{first: first}
+	named := false
+	err := sp.scan(func(row int, cells []interface{}) error {
+		if rng.r1 != 0 && row < rng.r1 {
+			return nil
+		}
+		if rng.r2 != 0 && row > rng.r2 {
+			return errStopScan
+		}
+		if len(cells) >= first {
+			cells = cells[first-1:]
+		} else {
+			cells = nil
+		}
+		if rng.c2 != 0 && len(cells) > rng.c2-first+1 {
+			cells = cells[:rng.c2-first+1]
+		}
+		for len(cells) > 0 && cells[len(cells)-1] == nil {
+			cells = cells[:len(cells)-1]
+		}
+		if header && !named {
+			named = true
+			t.name(cells, rng)
+			return nil
+		}
+		if !header {
+			for len(t.columns) < len(cells) {
+				t.columns = append(t.columns, columnName(first+len(t.columns)))
+			}
+		}
+		return fn(t, row, cells)
+	})
+	return t, err
+}
+
+// name takes the column names from a header row: blank names become the
+// column's letters and repeated ones get a suffix.
+func (t *sheetTable) name(cells []interface{}, rng sheetRange) {
+	width := len(cells)
+	if rng.c2 != 0 {
+		width = rng.c2 - t.first + 1
+	}
+	seen := map[string]int{}
+	for i := 0; i < width; i++ {
+		name := ""
+		if i < len(cells) && cells[i] != nil {
+			name = strings.TrimSpace(cellText(cells[i]))
+		}
+		if name == "" {
+			name = columnName(t.first + i)
+		}
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, seen[name])
+		}
+		t.columns = append(t.columns, name)
+	}
+}
+
+// cellText is a cell as text.
+func cellText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// cellNumber reads a cell, or a numeric string, as a number.
+func cellNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	case string:
+		if t := strings.TrimSpace(v); numberText.MatchString(t) {
+			f, err := strconv.ParseFloat(t, 64)
+			return f, err == nil
+		}
+	}
+	return 0, false
+}
+
+// compareCells orders empty cells first, then numbers, then text.
+func compareCells(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	fa, aok := cellNumber(a)
+	fb, bok := cellNumber(b)
+	switch {
+	case aok && bok:
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case aok:
+		return -1
+	case bok:
+		return 1
+	}
+	return strings.Compare(cellText(a), cellText(b))
+}
+
+func cellAt(cells []interface{}, i int) interface{} {
+	if i < len(cells) {
+		return cells[i]
+	}
+	return nil
+}
+
+type sheetFilter struct {
+	Column     string      `json:"column" jsonschema:"required,description=Column name (or letter without a header)"`
+	Op         string      `json:"op" jsonschema:"required,enum=eq|ne|lt|le|gt|ge|contains|startsWith|endsWith|in|matches|empty|notEmpty"`
+	Value      interface{} `json:"value,omitempty" jsonschema:"description=Value to compare with; a list for in\\, a regular expression for matches"`
+	IgnoreCase bool        `json:"ignoreCase,omitempty"`
+}
+
+type sheetAggregate struct {
+	Func   string `json:"func" jsonschema:"required,enum=count|sum|avg|min|max|distinct"`
+	Column string `json:"column,omitempty" jsonschema:"description=Column to aggregate; count without one counts rows"`
+	As     string `json:"as,omitempty" jsonschema:"description=Result column name (default func(column))"`
+}
+
+func (a sheetAggregate) name() string {
+	if a.As != "" {
+		return a.As
+	}
+	return a.Func + "(" + a.Column + ")"
+}
+
+type sheetOrder struct {
+	Column string `json:"column" jsonschema:"required"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+type readSpreadsheetArgs struct {
+	Root      string `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path      string `json:"path" jsonschema:"required,description=Root-relative .csv\\, .tsv or .xlsx file"`
+	Sheet     string `json:"sheet,omitempty" jsonschema:"description=Workbook sheet (default the first)"`
+	Range     string `json:"range,omitempty" jsonschema:"description=Cells in A1 notation\\, e.g. A1:D20\\, B:D or 2:50 (default all)"`
+	NoHeader  bool   `json:"noHeader,omitempty" jsonschema:"description=The range's first row is data\\, not column names; columns are named by letter"`
+	Delimiter string `json:"delimiter,omitempty" jsonschema:"maxLength=1,description=CSV field delimiter (default comma\\, tab for .tsv)"`
+	Offset    int    `json:"offset,omitempty" jsonschema:"minimum=0,description=Data rows to skip"`
+	Limit     int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=5000,description=Data rows to return (default 100)"`
+}
+
+type querySpreadsheetArgs struct {
+	Root       string           `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path       string           `json:"path" jsonschema:"required,description=Root-relative .csv\\, .tsv or .xlsx file"`
+	Sheet      string           `json:"sheet,omitempty" jsonschema:"description=Workbook sheet (default the first)"`
+	Range      string           `json:"range,omitempty" jsonschema:"description=Cells in A1 notation (default all)"`
+	NoHeader   bool             `json:"noHeader,omitempty" jsonschema:"description=The range's first row is data; columns are named by letter"`
+	Delimiter  string           `json:"delimiter,omitempty" jsonschema:"maxLength=1,description=CSV field delimiter"`
+	Where      []sheetFilter    `json:"where,omitempty" jsonschema:"description=Conditions every row must meet"`
+	Select     []string         `json:"select,omitempty" jsonschema:"description=Columns to return (default all); ignored when grouping"`
+	GroupBy    []string         `json:"groupBy,omitempty" jsonschema:"description=Columns to group rows by for aggregates"`
+	Aggregates []sheetAggregate `json:"aggregates,omitempty" jsonschema:"description=Values computed over each group\\, or over all matching rows without groupBy"`
+	OrderBy    []sheetOrder     `json:"orderBy,omitempty" jsonschema:"description=Result columns to sort by"`
+	Limit      int              `json:"limit,omitempty" jsonschema:"minimum=1,maximum=5000,description=Result rows to return (default 100)"`
+}
+
+type writeSpreadsheetArgs struct {
+	Root      string          `json:"root,omitempty" jsonschema:"description=Writable workspace root of path"`
+	Path      string          `json:"path" jsonschema:"required,description=Root-relative .csv\\, .tsv or .xlsx file"`
+	Sheet     string          `json:"sheet,omitempty" jsonschema:"description=Workbook sheet to write (default Sheet1); other sheets of an existing workbook keep their values"`
+	Columns   []string        `json:"columns,omitempty" jsonschema:"description=Header row"`
+	Rows      [][]interface{} `json:"rows" jsonschema:"required,description=Rows of cell values: strings\\, numbers\\, booleans or null"`
+	Append    bool            `json:"append,omitempty" jsonschema:"description=Add the rows to the end of an existing CSV file\\, without a header"`
+	Overwrite bool            `json:"overwrite,omitempty" jsonschema:"description=Replace the file\\, or for a workbook the sheet\\, if it exists"`
+	Delimiter string          `json:"delimiter,omitempty" jsonschema:"maxLength=1,description=CSV field delimiter"`
+}
+
+func (s *MCPServer) setupSpreadsheetTools() {
+	read, readHandler, _ := typedTool("read_spreadsheet", "Read rows of a CSV file or an Excel sheet in the workspace, by sheet and A1 range, with the column names", s.readSpreadsheetTool)
+	read.Annotations = readOnlyAnnotations()
+	s.addTool(read, readHandler)
+
+	query, queryHandler, _ := typedTool("query_spreadsheet", "Filter, group, aggregate and sort the rows of a CSV file or an Excel sheet in the workspace", s.querySpreadsheetTool)
+	query.Annotations = readOnlyAnnotations()
+	s.addTool(query, queryHandler)
+
+	if s.cfg.Workspace.writable() {
+		destructive := true
+		write, writeHandler, _ := typedTool("write_spreadsheet", "Write rows to a CSV file or an Excel sheet in a writable workspace root, or append them to a CSV file", s.writeSpreadsheetTool)
+		write.Annotations = &ToolAnnotations{DestructiveHint: &destructive}
+		s.addTool(write, writeHandler)
+	}
+}
+
+func (s *MCPServer) readSpreadsheetTool(ctx context.Context, args readSpreadsheetArgs) (interface{}, error) {
+	rng, err := parseSheetRange(args.Range)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := s.openSpreadsheet(args.Root, args.Path, args.Sheet, args.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	defer sp.close()
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultSheetRowLimit
+	}
+	rows := [][]interface{}{}
+	total := 0
+	t, err := scanSheetTable(sp, rng, !args.NoHeader, func(t *sheetTable, row int, cells []interface{}) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		total++
+		if total > args.Offset && len(rows) < limit {
+			rows = append(rows, append([]interface{}(nil), cells...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		for len(row) < len(t.columns) {
+			row = append(row, nil)
+		}
+		rows[i] = row[:len(t.columns)]
+	}
+	result := map[string]interface{}{"columns": t.columns, "rows": rows, "totalRows": total}
+	if sp.sheet != "" {
+		result["sheet"], result["sheets"] = sp.sheet, sp.sheets
+	}
+	if args.Offset+len(rows) < total {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+// rowFilter is a compiled condition on a table row.
+type rowFilter func(cells []interface{}) bool
+
+func compileSheetFilter(t *sheetTable, f sheetFilter) (rowFilter, error) {
+	i, err := t.index(f.Column)
+	if err != nil {
+		return nil, err
+	}
+	text := func(v interface{}) string {
+		if f.IgnoreCase {
+			return strings.ToLower(cellText(v))
+		}
+		return cellText(v)
+	}
+	want := text(f.Value)
+	compare := func(test func(int) bool) rowFilter {
+		return func(cells []interface{}) bool {
+			v := cellAt(cells, i)
+			if f.IgnoreCase {
+				if s, ok := v.(string); ok {
+					v = strings.ToLower(s)
+				}
+			}
+			value := f.Value
+			if s, ok := value.(string); ok && f.IgnoreCase {
+				value = strings.ToLower(s)
+			}
+			return v != nil && test(compareCells(v, value))
+		}
+	}
+	switch f.Op {
+	case "eq":
+		return compare(func(c int) bool { return c == 0 }), nil
+	case "ne":
+		eq := compare(func(c int) bool { return c == 0 })
+		return func(cells []interface{}) bool { return !eq(cells) }, nil
+	case "lt":
+		return compare(func(c int) bool { return c < 0 }), nil
+	case "le":
+		return compare(func(c int) bool { return c <= 0 }), nil
+	case "gt":
+		return compare(func(c int) bool { return c > 0 }), nil
+	case "ge":
+		return compare(func(c int) bool { return c >= 0 }), nil
+	case "contains":
+		return func(cells []interface{}) bool { return strings.Contains(text(cellAt(cells, i)), want) }, nil
+	case "startsWith":
+		return func(cells []interface{}) bool { return strings.HasPrefix(text(cellAt(cells, i)), want) }, nil
+	case "endsWith":
+		return func(cells []interface{}) bool { return strings.HasSuffix(text(cellAt(cells, i)), want) }, nil
+	case "empty":
+		return func(cells []interface{}) bool { return cellText(cellAt(cells, i)) == "" }, nil
+	case "notEmpty":
+		return func(cells []interface{}) bool { return cellText(cellAt(cells, i)) != "" }, nil
+	case "in":
+		values, ok := f.Value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("column %q: in needs a list value", f.Column)
+		}
+		return func(cells []interface{}) bool {
+			v := cellAt(cells, i)
+			for _, value := range values {
+				if v != nil && compareCells(v, value) == 0 {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "matches":
+		pattern := cellText(f.Value)
+		if f.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", f.Column, err)
+		}
+		return func(cells []interface{}) bool { return re.MatchString(cellText(cellAt(cells, i))) }, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", f.Op)
+}
+
+// sheetGroup accumulates the aggregates of one group.
+type sheetGroup struct {
+	key      []interface{}
+	count    []int
+	numbers  []int
+	sum      []float64
+	min, max []interface{}
+	distinct []map[string]bool
+}
+
+func (s *MCPServer) querySpreadsheetTool(ctx context.Context, args querySpreadsheetArgs) (interface{}, error) {
+	rng, err := parseSheetRange(args.Range)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range args.Aggregates {
+		if a.Column == "" && a.Func != "count" {
+			return nil, fmt.Errorf("%s needs a column", a.Func)
+		}
+	}
+	sp, err := s.openSpreadsheet(args.Root, args.Path, args.Sheet, args.Delimiter)
+	if err != nil {
+		return nil, err
+	}
+	defer sp.close()
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultSheetRowLimit
+	}
+	grouping := len(args.GroupBy) > 0 || len(args.Aggregates) > 0
+
+	var (
+		prepared           bool
+		filters            []rowFilter
+		groupCols, aggCols []int
+		selectCols         []int
+		groups             []*sheetGroup
+		byKey              = map[string]*sheetGroup{}
+		rows               [][]interface{}
+		matched            int
+	)
+	prepare := func(t *sheetTable) error {
+		prepared = true
+		for _, f := range args.Where {
+			fn, err := compileSheetFilter(t, f)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, fn)
+		}
+		for _, name := range args.GroupBy {
+			i, err := t.index(name)
+			if err != nil {
+				return err
+			}
+			groupCols = append(groupCols, i)
+		}
+		for _, a := range args.Aggregates {
+			i := -1
+			if a.Column != "" {
+				if i, err = t.index(a.Column); err != nil {
+					return err
+				}
+			}
+			aggCols = append(aggCols, i)
+		}
+		for _, name := range args.Select {
+			i, err := t.index(name)
+			if err != nil {
+				return err
+			}
+			selectCols = append(selectCols, i)
+		}
+		return nil
+	}
+	t, err := scanSheetTable(sp, rng, !args.NoHeader, func(t *sheetTable, row int, cells []interface{}) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !prepared {
+			if err := prepare(t); err != nil {
+				return err
+			}
+		}
+		for _, f := range filters {
+			if !f(cells) {
+				return nil
+			}
+		}
+		matched++
+		if !grouping {
+			// Without sorting only the rows returned are needed.
+			if len(args.OrderBy) == 0 && len(rows) >= limit {
+				return nil
+			}
+			if len(rows) >= maxSheetQueryRows {
+				return fmt.Errorf("more than %d rows match; narrow the query or aggregate", maxSheetQueryRows)
+			}
+			rows = append(rows, append([]interface{}(nil), cells...))
+			return nil
+		}
+		key := make([]interface{}, len(groupCols))
+		for k, i := range groupCols {
+			key[k] = cellAt(cells, i)
+		}
+		id, _ := json.Marshal(key)
+		g := byKey[string(id)]
+		if g == nil {
+			if len(groups) >= maxSpreadsheetGroups {
+				return fmt.Errorf("more than %d groups", maxSpreadsheetGroups)
+			}
+			n := len(aggCols)
+			g = &sheetGroup{key: key, count: make([]int, n), numbers: make([]int, n), sum: make([]float64, n), min: make([]interface{}, n), max: make([]interface{}, n), distinct: make([]map[string]bool, n)}
+			byKey[string(id)] = g
+			groups = append(groups, g)
+		}
+		for k, i := range aggCols {
+			if i < 0 {
+				g.count[k]++
+				continue
+			}
+			v := cellAt(cells, i)
+			if v == nil || cellText(v) == "" {
+				continue
+			}
+			if f, ok := cellNumber(v); ok {
+				g.sum[k] += f
+				g.numbers[k]++
+			}
+			g.count[k]++
+			if g.min[k] == nil || compareCells(v, g.min[k]) < 0 {
+				g.min[k] = v
+			}
+			if g.max[k] == nil || compareCells(v, g.max[k]) > 0 {
+				g.max[k] = v
+			}
+			if args.Aggregates[k].Func == "distinct" {
+				if g.distinct[k] == nil {
+					g.distinct[k] = map[string]bool{}
+				}
+				g.distinct[k][cellText(v)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !prepared {
+		// No data rows; still report unknown columns.
+		if err := prepare(t); err != nil {
+			return nil, err
+		}
+	}
+
+	var columns []string
+	var out [][]interface{}
+	if grouping {
+		columns = append(columns, args.GroupBy...)
+		for _, a := range args.Aggregates {
+			columns = append(columns, a.name())
+		}
+		if len(args.GroupBy) == 0 && len(groups) == 0 {
+			// Aggregates over no rows still give one row.
+			n := len(aggCols)
+			groups = append(groups, &sheetGroup{count: make([]int, n), numbers: make([]int, n), sum: make([]float64, n), min: make([]interface{}, n), max: make([]interface{}, n), distinct: make([]map[string]bool, n)})
+		}
+		for _, g := range groups {
+			row := append([]interface{}(nil), g.key...)
+			for k, a := range args.Aggregates {
+				var v interface{}
+				switch a.Func {
+				case "count":
+					v = g.count[k]
+				case "sum":
+					v = g.sum[k]
+				case "avg":
+					if g.numbers[k] > 0 {
+						v = g.sum[k] / float64(g.numbers[k])
+					}
+				case "min":
+					v = g.min[k]
+				case "max":
+					v = g.max[k]
+				case "distinct":
+					v = len(g.distinct[k])
+				}
+				row = append(row, v)
+			}
+			out = append(out, row)
+		}
+	} else {
+		columns = t.columns
+		if len(selectCols) > 0 {
+			columns = args.Select
+		}
+		for _, cells := range rows {
+			var row []interface{}
+			if len(selectCols) > 0 {
+				for _, i := range selectCols {
+					row = append(row, cellAt(cells, i))
+				}
+			} else {
+				for i := range t.columns {
+					row = append(row, cellAt(cells, i))
+				}
+			}
+			out = append(out, row)
+		}
+	}
+
+	if len(args.OrderBy) > 0 {
+		result := &sheetTable{columns: columns, first: 1}
+		keys := make([]int, len(args.OrderBy))
+		for k, o := range args.OrderBy {
+			if keys[k], err = result.index(o.Column); err != nil {
+				return nil, err
+			}
+		}
+		sort.SliceStable(out, func(a, b int) bool {
+			for k, o := range args.OrderBy {
+				c := compareCells(out[a][keys[k]], out[b][keys[k]])
+				if o.Desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+	truncated := len(out) > limit
+	if truncated {
+		out = out[:limit]
+	}
+	if out == nil {
+		out = [][]interface{}{}
+	}
+	result := map[string]interface{}{"columns": columns, "rows": out, "matchedRows": matched}
+	if sp.sheet != "" {
+		result["sheet"] = sp.sheet
+	}
+	if truncated || (!grouping && matched > len(out)) {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+func (s *MCPServer) writeSpreadsheetTool(ctx context.Context, args writeSpreadsheetArgs) (interface{}, error) {
+	ext := strings.ToLower(path.Ext(args.Path))
+	isCSV := ext == ".csv" || ext == ".tsv"
+	if !isCSV && ext != ".xlsx" {
+		return nil, fmt.Errorf("%s is not a .csv, .tsv or .xlsx file", args.Path)
+	}
+	if args.Append && !isCSV {
+		return nil, errors.New("only CSV files can be appended to; write the workbook sheet instead")
+	}
+	sheet := args.Sheet
+	if sheet == "" {
+		sheet = defaultSpreadsheetName
+	}
+	if !isCSV {
+		if err := validSheetName(sheet); err != nil {
+			return nil, err
+		}
+	}
+	// Existing files are checked below: a workbook may gain a sheet and
+	// a CSV file may be appended to without overwrite.
+	r, rel, full, err := s.outputFile(args.Root, args.Path, true)
+	if err != nil {
+		return nil, err
+	}
+	_, statErr := os.Stat(full)
+	exists := statErr == nil
+
+	patchMu.Lock()
+	defer patchMu.Unlock()
+	result := map[string]interface{}{"path": rel, "rows": len(args.Rows)}
+	switch {
+	case isCSV:
+		comma := ','
+		if ext == ".tsv" {
+			comma = '\t'
+		}
+		if args.Delimiter != "" {
+			comma = []rune(args.Delimiter)[0]
+		}
+		if exists && !args.Append && !args.Overwrite {
+			return nil, fmt.Errorf("%s exists; set overwrite to replace it or append to add rows", rel)
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if args.Append {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		write := func(w io.Writer) error {
+			cw := csv.NewWriter(w)
+			cw.Comma = comma
+			if len(args.Columns) > 0 && !(args.Append && exists) {
+				cw.Write(args.Columns)
+			}
+			for _, row := range args.Rows {
+				rec := make([]string, len(row))
+				for i, v := range row {
+					rec[i] = csvCell(jsonCell(v))
+				}
+				cw.Write(rec)
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+		if args.Append {
+			f, err := os.OpenFile(full, flags, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			if err := write(f); err != nil {
+				f.Close()
+				return nil, err
+			}
+			if err := f.Close(); err != nil {
+				return nil, err
+			}
+		} else if err := writeFileVia(full, write); err != nil {
+			return nil, err
+		}
+	default:
+		var sheets []xlsxSheetData
+		if exists {
+			if sheets, err = readXLSXValues(ctx, full); err != nil {
+				return nil, err
+			}
+		}
+		data := xlsxSheetData{name: sheet, boldFirst: len(args.Columns) > 0}
+		if len(args.Columns) > 0 {
+			header := make([]interface{}, len(args.Columns))
+			for i, c := range args.Columns {
+				header[i] = c
+			}
+			data.rows = append(data.rows, header)
+		}
+		data.rows = append(data.rows, args.Rows...)
+		replaced := false
+		for i := range sheets {
+			if sheets[i].name == sheet {
+				if !args.Overwrite {
+					return nil, fmt.Errorf("sheet %q exists; set overwrite to replace it", sheet)
+				}
+				sheets[i], replaced = data, true
+			}
+		}
+		if !replaced {
+			sheets = append(sheets, data)
+		}
+		if err := writeFileVia(full, func(w io.Writer) error { return writeXLSX(w, sheets) }); err != nil {
+			return nil, err
+		}
+		result["sheet"] = sheet
+		if len(sheets) > 1 {
+			result["sheets"] = len(sheets)
+		}
+	}
+	if st, err := os.Stat(full); err == nil {
+		result["bytes"] = st.Size()
+	}
+	s.code.invalidate(r.Name)
+	return result, nil
+}
+
+// jsonCell gives numbers decoded from JSON their shortest text.
+func jsonCell(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return v
+}
+
+// readXLSXValues reads the values of every sheet of a workbook, so that
+// rewriting one sheet keeps the others.
+func readXLSXValues(ctx context.Context, name string) ([]xlsxSheetData, error) {
+	wb, err := openXLSX(name)
+	if err != nil {
+		return nil, err
+	}
+	defer wb.Close()
+	var sheets []xlsxSheetData
+	for _, ref := range wb.sheets {
+		data := xlsxSheetData{name: ref.name}
+		err := wb.scanSheet(ref.part, func(row int, cells []interface{}) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if row > maxSheetQueryRows {
+				return fmt.Errorf("sheet %q has more than %d rows to keep", ref.name, maxSheetQueryRows)
+			}
+			for len(data.rows) < row-1 {
+				data.rows = append(data.rows, nil)
+			}
+			data.rows = append(data.rows, append([]interface{}(nil), cells...))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sheets = append(sheets, data)
+	}
+	return sheets, nil
+}
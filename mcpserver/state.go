@@ -0,0 +1,99 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"mcp-server/store"
+)
+
+// Namespaces of the state store.
+const (
+	nsSessions    = "sessions"
+	nsJobs        = "jobs"
+	nsAudit       = "audit"
+	nsMemory      = "memory"
+	nsIdempotency = "idempotency"
+	nsKeys        = "keys"
+	nsQuota       = "quota"
+)
+
+const storeTimeout = 10 * time.Second
+
+// dataDir is where persistent state lives. jobs.dir, which predates the
+// state store, still works as one.
+func (c *Config) dataDir() string {
+	if c.DataDir != "" {
+		return c.DataDir
+	}
+	return c.Jobs.Dir
+}
+
+// Session IDs are bearer tokens, so with encryption on they are only
+// stored hashed.
+var hashedNamespaces = []string{nsSessions}
+
+// openStore opens the state store, encrypting values if keys are
+// configured, or returns nil when state is kept in memory.
+func openStore(cfg *Config) (store.Store, error) {
+	db, err := openDatabase(cfg)
+	if err != nil || db == nil || !cfg.Store.Encryption.Enabled() {
+		return db, err
+	}
+	ring, err := store.NewKeyring(cfg.Store.Encryption)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store.Encrypt(db, ring, hashedNamespaces...), nil
+}
+
+// openDatabase opens the state store as stored, sealed values and all,
+// after applying pending migrations, or returns nil when neither a data
+// directory nor a database is configured.
+func openDatabase(cfg *Config) (store.Store, error) {
+	dir := cfg.dataDir()
+	if dir == "" && !cfg.Store.Enabled() {
+		return nil, nil
+	}
+	m, err := store.NewMigrator(cfg.Store, dir)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err = migrateUp(ctx, m)
+	m.Close()
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(cfg.Store, dir)
+}
+
+// storeContext bounds a store operation made outside a request.
+func storeContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), storeTimeout)
+}
+
+// putJSON stores v as JSON under namespace and key.
+func putJSON(st store.Store, namespace, key, owner string, v interface{}, expires time.Time) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := storeContext()
+	defer cancel()
+	return st.Put(ctx, &store.Record{Namespace: namespace, Key: key, Owner: owner, Value: data, Expires: expires})
+}
+
+// deleteRecord removes a record, logging failures; what is left behind
+// is cleaned up on the next start.
+func deleteRecord(st store.Store, namespace, key string) {
+	ctx, cancel := storeContext()
+	defer cancel()
+	if err := st.Delete(ctx, namespace, key); err != nil {
+		log.Printf("store: deleting %s/%s: %v", namespace, key, err)
+	}
+}
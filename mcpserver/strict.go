@@ -0,0 +1,99 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// strictFor reports whether strict decoding is enabled for path. Keys of
+// Config.StrictDecoding are path prefixes; the longest match wins.
+func (s *MCPServer) strictFor(path string) bool {
+	best, strict := -1, false
+	for prefix, on := range s.cfg.StrictDecoding {
+		if strings.HasPrefix(path, prefix) && len(prefix) > best {
+			best, strict = len(prefix), on
+		}
+	}
+	return strict
+}
+
+// checkDuplicateKeys rejects JSON documents where an object repeats a key.
+// encoding/json silently keeps the last value, which lets a payload show
+// one value to a validating proxy and another to this server.
+func checkDuplicateKeys(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return walkJSON(dec, "")
+}
+
+func walkJSON(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	switch delim {
+	case '{':
+		seen := map[string]bool{}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			if seen[key] {
+				return fmt.Errorf("duplicate key %q at %s", key, pathOrRoot(path))
+			}
+			seen[key] = true
+			if err := walkJSON(dec, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for i := 0; dec.More(); i++ {
+			if err := walkJSON(dec, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = dec.Token() // closing delimiter
+	return err
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "$"
+	}
+	return "$" + path
+}
+
+// decodeStrict unmarshals data into v, rejecting fields v does not declare
+// and duplicate keys.
+func decodeStrict(data []byte, v interface{}) error {
+	if err := checkDuplicateKeys(data); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// decodeBody decodes a JSON request body, strictly when the request path
+// is configured for it.
+func (s *MCPServer) decodeBody(r *http.Request, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBackendOutput))
+	if err != nil {
+		return err
+	}
+	if s.strictFor(r.URL.Path) {
+		return decodeStrict(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
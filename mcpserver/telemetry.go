@@ -0,0 +1,260 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mcp-server/store"
+)
+
+// serverVersion is reported in serverInfo, /health and telemetry. Release
+// builds set it with -ldflags "-X mcp-server/mcpserver.serverVersion=1.2.0".
+var serverVersion = "1.0.0"
+
+// TelemetryConfig sends anonymous usage reports to Endpoint, so the
+// maintainers of a deployment's tooling can see which features are used.
+// It is off unless Enabled, and MCP_TELEMETRY=off or DO_NOT_TRACK=1 turn
+// it off regardless. Reports hold counts and feature names only: no tool
+// names, arguments, API keys, addresses or hostnames. GET /admin/telemetry
+// shows the next report before it is sent.
+type TelemetryConfig struct {
+	Enabled  bool     `json:"enabled,omitempty"`
+	Endpoint string   `json:"endpoint,omitempty" schema:"format=uri"`
+	Interval Duration `json:"interval,omitempty"` // default 24h
+}
+
+const (
+	defaultTelemetryInterval = 24 * time.Hour
+	// The first report waits, so short-lived runs send nothing.
+	telemetryFirstDelay = time.Hour
+	telemetryTimeout    = 10 * time.Second
+	nsTelemetry         = "telemetry"
+)
+
+func (c TelemetryConfig) check() error {
+	if c.Enabled && c.Endpoint == "" {
+		return errors.New("telemetry needs an endpoint")
+	}
+	return nil
+}
+
+// telemetryOptOut reports whether the environment turns telemetry off.
+func telemetryOptOut() bool {
+	switch strings.ToLower(os.Getenv("MCP_TELEMETRY")) {
+	case "off", "0", "false", "no":
+		return true
+	}
+	return os.Getenv("DO_NOT_TRACK") == "1"
+}
+
+// telemetry builds and sends reports. Call volumes are reported as the
+// change since the previous report.
+type telemetry struct {
+	cfg       TelemetryConfig
+	installID string
+	client    *http.Client
+
+	mu        sync.Mutex
+	lastCalls map[string]int64
+	lastSent  time.Time
+	lastError string
+}
+
+// newTelemetry returns nil when telemetry is off. The install ID is a
+// random value kept in the state store, or one per process without it.
+func newTelemetry(cfg TelemetryConfig, db store.Store) *telemetry {
+	if !cfg.Enabled || telemetryOptOut() {
+		return nil
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = Duration(defaultTelemetryInterval)
+	}
+	t := &telemetry{cfg: cfg, installID: randomID(), client: &http.Client{Timeout: telemetryTimeout}, lastCalls: map[string]int64{}}
+	if db != nil {
+		ctx, cancel := storeContext()
+		defer cancel()
+		if rec, err := db.Get(ctx, nsTelemetry, "install"); err == nil {
+			t.installID = string(rec.Value)
+		} else if err := db.Put(ctx, &store.Record{Namespace: nsTelemetry, Key: "install", Value: []byte(t.installID)}); err != nil {
+			log.Printf("telemetry: storing install ID: %v", err)
+		}
+	}
+	return t
+}
+
+// report builds the next report from s; commit records its call counts
+// as sent.
+func (t *telemetry) report(s *MCPServer, commit bool) map[string]interface{} {
+	backends := map[string]string{}
+	for _, tc := range s.cfg.Tools {
+		backends[tc.Name] = "declarative:" + tc.Backend.Type
+	}
+	tools := map[string]int{}
+	calls := map[string]int64{}
+	errs := map[string]int64{}
+	for _, st := range s.usage.stats(s.toolNames()) {
+		pkg := backends[st.Tool]
+		if pkg == "" {
+			pkg = "builtin"
+		}
+		tools[pkg]++
+		calls[pkg] += st.Calls
+		errs[pkg] += st.Errors
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	callDelta := map[string]int64{}
+	errDelta := map[string]int64{}
+	for pkg, n := range calls {
+		callDelta[pkg] = delta(n, t.lastCalls[pkg])
+		errDelta[pkg] = delta(errs[pkg], t.lastCalls[pkg+":errors"])
+	}
+	if commit {
+		for pkg, n := range calls {
+			t.lastCalls[pkg], t.lastCalls[pkg+":errors"] = n, errs[pkg]
+		}
+	}
+	return map[string]interface{}{
+		"installId":     t.installID,
+		"version":       serverVersion,
+		"go":            runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"uptimeSeconds": int64(uptime().Seconds()),
+		"features":      telemetryFeatures(s.cfg),
+		"tools":         tools,
+		"calls":         callDelta,
+		"errors":        errDelta,
+		"sessions":      len(s.sessions.list()),
+		"apiKeys":       len(s.cfg.Auth.APIKeys),
+	}
+}
+
+// delta is how much a counter grew, or its value after a reset.
+func delta(now, last int64) int64 {
+	if now < last {
+		return now
+	}
+	return now - last
+}
+
+// telemetryFeatures names the optional features cfg turns on.
+func telemetryFeatures(cfg *Config) []string {
+	var out []string
+	add := func(on bool, name string) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	if cfg.dataDir() != "" || cfg.Store.Enabled() {
+		driver := cfg.Store.Driver
+		if driver == "" {
+			driver = "sqlite"
+		}
+		out = append(out, "store:"+driver)
+	}
+	add(cfg.Store.Encryption.Enabled(), "encryption")
+	add(cfg.Audit.Kafka != nil, "audit:kafka")
+	add(cfg.Audit.NATS != nil, "audit:nats")
+	add(len(cfg.Webhooks) > 0, "webhooks")
+	add(cfg.TLS.enabled(), "tls")
+	add(cfg.TLS.ACME != nil, "acme")
+	add(cfg.Listen.Socket != "", "unixSocket")
+	add(cfg.VCR.Mode != "", "vcr")
+	add(cfg.Chaos.Enabled, "chaos")
+	add(cfg.Approval.Enabled, "approval")
+	add(cfg.Hardening.Enabled, "hardening")
+	add(cfg.Dashboard.Enabled, "dashboard")
+	add(cfg.Diagnostics.Addr != "", "diagnostics")
+	add(cfg.Features.Remote != nil, "remoteFlags")
+	add(cfg.Policy.ReadOnly, "readOnly")
+	add(len(cfg.Exposure) > 0, "exposure")
+	add(cfg.Retention != RetentionConfig{}, "retention")
+	add(cfg.Sessions.ScratchRoot != "", "scratch")
+	sort.Strings(out)
+	return out
+}
+
+// loop sends a report every interval until ctx is cancelled.
+func (t *telemetry) loop(ctx context.Context, server func() *MCPServer) {
+	delay := telemetryFirstDelay
+	if d := time.Duration(t.cfg.Interval); d < delay {
+		delay = d
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		err := t.send(ctx, t.report(server(), true))
+		t.mu.Lock()
+		t.lastSent, t.lastError = time.Now().UTC(), ""
+		if err != nil {
+			t.lastError = err.Error()
+			log.Printf("telemetry: %v", err)
+		}
+		t.mu.Unlock()
+		timer.Reset(time.Duration(t.cfg.Interval))
+	}
+}
+
+func (t *telemetry) send(ctx context.Context, report map[string]interface{}) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mcp-server-telemetry/"+serverVersion)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", t.cfg.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// handleAdminTelemetry serves GET /admin/telemetry: whether telemetry is
+// on and, if so, the report that would be sent next.
+func (s *MCPServer) handleAdminTelemetry(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t := s.telemetry
+	if t == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "optedOut": telemetryOptOut()})
+		return
+	}
+	out := map[string]interface{}{"enabled": true, "endpoint": t.cfg.Endpoint, "interval": t.cfg.Interval, "next": t.report(s, false)}
+	t.mu.Lock()
+	if !t.lastSent.IsZero() {
+		out["lastSent"] = t.lastSent
+	}
+	if t.lastError != "" {
+		out["lastError"] = t.lastError
+	}
+	t.mu.Unlock()
+	writeJSON(w, http.StatusOK, out)
+}
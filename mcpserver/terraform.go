@@ -0,0 +1,785 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TerraformConfig lists the Terraform states the tf_ tools inspect.
+type TerraformConfig struct {
+	States []TerraformState `json:"states,omitempty"`
+}
+
+// TerraformState is a state the tf_ tools read but never write. Backend
+// says where it is: "local" reads the file Path; "http" fetches URL as
+// Terraform's http backend does; "s3" and "gcs" fetch Key from Bucket
+// with the ambient cloud credentials of the cloud_ tools; "remote" reads
+// the current state of Workspace in Organization from HCP Terraform, or
+// the Terraform Enterprise at URL. Dir, the initialized configuration
+// the state belongs to, lets tf_drift run a refresh-only plan.
+type TerraformState struct {
+	Name         string            `json:"name" schema:"required"`
+	Backend      string            `json:"backend" schema:"required,enum=local|http|s3|gcs|remote"`
+	Path         string            `json:"path,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	Bucket       string            `json:"bucket,omitempty"`
+	Key          string            `json:"key,omitempty"`    // object name, like env/prod/terraform.tfstate
+	Region       string            `json:"region,omitempty"` // s3; default $AWS_REGION
+	Organization string            `json:"organization,omitempty"`
+	Workspace    string            `json:"workspace,omitempty"`
+	Token        string            `json:"token,omitempty"` // http bearer token, or the remote API token
+	Username     string            `json:"username,omitempty"`
+	Password     string            `json:"password,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Dir          string            `json:"dir,omitempty"`
+	Binary       string            `json:"binary,omitempty"`                            // default terraform; tofu works too
+	CacheFor     Duration          `json:"cacheFor,omitempty" schema:"format=duration"` // default 1m
+	Timeout      Duration          `json:"timeout,omitempty" schema:"format=duration"`  // default 30s
+}
+
+const (
+	defaultTFCache     = time.Minute
+	defaultTFTimeout   = 30 * time.Second
+	defaultTFResources = 200
+	maxTFResources     = 2000
+	maxTFInstances     = 20
+	maxTFChanges       = 20
+	// maxTFValue bounds each string in attributes, in runes; policies and
+	// rendered templates can be huge.
+	maxTFValue     = 4096
+	tfSensitive    = "(sensitive)"
+	defaultTFCHost = "https://app.terraform.io"
+)
+
+var (
+	// tfSecretName matches attribute names whose string values are
+	// hidden even when the provider did not mark them sensitive.
+	tfSecretName = regexp.MustCompile(`(?i)(^|_)(password|passwd|secret|token|private_key(_pem)?|secret_key|api_key|connection_string|credentials?)$`)
+	// tfTarget is a resource or module address for -target.
+	tfTarget = regexp.MustCompile(`^[a-zA-Z_][\w.\-\[\]"]*$`)
+)
+
+func (c TerraformConfig) enabled() bool {
+	return len(c.States) > 0
+}
+
+func (c TerraformConfig) check(secrets map[string]string) error {
+	seen := map[string]bool{}
+	for _, st := range c.States {
+		if st.Name == "" || seen[st.Name] {
+			return fmt.Errorf("state %q: names must be unique and non-empty", st.Name)
+		}
+		seen[st.Name] = true
+		if err := st.check(secrets); err != nil {
+			return fmt.Errorf("state %q: %w", st.Name, err)
+		}
+	}
+	return nil
+}
+
+func (st TerraformState) check(secrets map[string]string) error {
+	switch st.Backend {
+	case "local":
+		if st.Path == "" {
+			return errors.New("path is required")
+		}
+	case "http":
+		u, err := url.Parse(st.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+			return errors.New("url must be an http or https URL without credentials")
+		}
+	case "s3", "gcs":
+		if st.Bucket == "" || st.Key == "" {
+			return errors.New("bucket and key are required")
+		}
+		if st.Backend == "s3" && !awsRegionPattern.MatchString(st.s3Region()) {
+			return errors.New("region is required when AWS_REGION is not set")
+		}
+	case "remote":
+		if st.Organization == "" || st.Workspace == "" {
+			return errors.New("organization and workspace are required")
+		}
+		if u, err := url.Parse(st.remoteURL()); err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("url must be the https URL of Terraform Enterprise")
+		}
+	default:
+		return fmt.Errorf("backend %q must be local, http, s3, gcs or remote", st.Backend)
+	}
+	if st.Dir != "" {
+		if fi, err := os.Stat(st.Dir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("dir %q is not a directory", st.Dir)
+		}
+	}
+	return st.credentials().check(secrets)
+}
+
+func (st TerraformState) credentials() apiCredentials {
+	return apiCredentials{bearerToken: st.Token, username: st.Username, password: st.Password, headers: st.Headers}
+}
+
+func (st TerraformState) s3Region() string {
+	if st.Region != "" {
+		return st.Region
+	}
+	if r := (CloudAccount{}).awsRegions(); len(r) > 0 {
+		return r[0]
+	}
+	return ""
+}
+
+func (st TerraformState) remoteURL() string {
+	if st.URL == "" {
+		return defaultTFCHost
+	}
+	return strings.TrimSuffix(st.URL, "/")
+}
+
+func (s *MCPServer) setupTerraformTools() {
+	ts := &terraformStates{
+		cfg:     s.cfg.Terraform,
+		secrets: s.secrets,
+		cache:   map[string]tfCached{},
+		aws:     map[string]*awsAccount{},
+		gcp:     &gcpAccount{},
+	}
+	drift := false
+	for _, st := range ts.cfg.States {
+		if st.Backend == "s3" {
+			ts.aws[st.Name] = &awsAccount{account: CloudAccount{Name: st.Name, Provider: "aws", Regions: []string{st.s3Region()}}}
+		}
+		drift = drift || st.Dir != ""
+	}
+
+	openWorld := true
+	list, listHandler, _ := typedTool("tf_list_resources", "List the resources in a Terraform state by address, with their type, provider and ID", ts.listResources)
+	list.Annotations = readOnlyAnnotations()
+	list.Annotations.OpenWorldHint = &openWorld
+	s.addTool(list, listHandler)
+
+	get, getHandler, _ := typedTool("tf_get_resource", "Get the attributes of a resource in a Terraform state, with sensitive values hidden", ts.getResource)
+	get.Annotations = readOnlyAnnotations()
+	get.Annotations.OpenWorldHint = &openWorld
+	s.addTool(get, getHandler)
+
+	outputs, outputsHandler, _ := typedTool("tf_outputs", "Get the outputs of a Terraform state, with sensitive values hidden", ts.outputs)
+	outputs.Annotations = readOnlyAnnotations()
+	outputs.Annotations.OpenWorldHint = &openWorld
+	s.addTool(outputs, outputsHandler)
+
+	if drift {
+		check, checkHandler, _ := typedTool("tf_drift", "Find the resources of a Terraform state changed or deleted outside Terraform, with a refresh-only plan that changes nothing", ts.drift)
+		check.Annotations = readOnlyAnnotations()
+		check.Annotations.OpenWorldHint = &openWorld
+		s.addTool(check, checkHandler)
+	}
+}
+
+// terraformStates serves the tf_ tools, keeping fetched states for
+// CacheFor and the cloud credentials of the s3 and gcs backends.
+type terraformStates struct {
+	cfg     TerraformConfig
+	secrets map[string]string
+	mu      sync.Mutex
+	cache   map[string]tfCached
+	aws     map[string]*awsAccount
+	gcp     *gcpAccount
+}
+
+type tfCached struct {
+	state   *tfState
+	fetched time.Time
+}
+
+// tfState is a state file, format version 4 as Terraform 0.12 and later
+// write it.
+type tfState struct {
+	Version          int                 `json:"version"`
+	TerraformVersion string              `json:"terraform_version"`
+	Serial           int64               `json:"serial"`
+	Lineage          string              `json:"lineage"`
+	Outputs          map[string]tfOutput `json:"outputs"`
+	Resources        []tfResource        `json:"resources"`
+}
+
+type tfOutput struct {
+	Value     interface{} `json:"value"`
+	Type      interface{} `json:"type"`
+	Sensitive bool        `json:"sensitive"`
+}
+
+type tfResource struct {
+	Module    string       `json:"module"`
+	Mode      string       `json:"mode"`
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Provider  string       `json:"provider"`
+	Instances []tfInstance `json:"instances"`
+}
+
+type tfInstance struct {
+	IndexKey     interface{}            `json:"index_key"`
+	Status       string                 `json:"status"`
+	Deposed      string                 `json:"deposed"`
+	Attributes   map[string]interface{} `json:"attributes"`
+	Sensitive    json.RawMessage        `json:"sensitive_attributes"`
+	Dependencies []string               `json:"dependencies"`
+}
+
+// tfPath is a path to a value in attributes, a list of get_attr and
+// index steps.
+type tfPath []struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func (r tfResource) address() string {
+	addr := r.Type + "." + r.Name
+	if r.Mode == "data" {
+		addr = "data." + addr
+	}
+	if r.Module != "" {
+		addr = r.Module + "." + addr
+	}
+	return addr
+}
+
+// provider shortens provider["registry.terraform.io/hashicorp/aws"].west
+// to hashicorp/aws.west.
+func (r tfResource) provider() string {
+	i := strings.Index(r.Provider, `provider["`)
+	if i < 0 {
+		return r.Provider
+	}
+	name, alias, _ := strings.Cut(r.Provider[i+len(`provider["`):], `"]`)
+	return strings.TrimPrefix(name, "registry.terraform.io/") + alias
+}
+
+func (in tfInstance) address(base string) string {
+	switch k := in.IndexKey.(type) {
+	case json.Number:
+		return base + "[" + k.String() + "]"
+	case string:
+		return base + "[" + strconv.Quote(k) + "]"
+	}
+	return base
+}
+
+// status is "tainted" or "deposed" for instances Terraform will replace,
+// and empty otherwise.
+func (in tfInstance) status() string {
+	if in.Deposed != "" {
+		return "deposed"
+	}
+	return in.Status
+}
+
+// attributes returns a copy of the attributes with sensitive values
+// replaced and long strings cut.
+func (in tfInstance) attributes() map[string]interface{} {
+	attrs, _ := tfValue(in.Attributes).(map[string]interface{})
+	var paths []tfPath
+	if json.Unmarshal(in.Sensitive, &paths) == nil {
+		for _, p := range paths {
+			redactTFPath(attrs, p)
+		}
+	}
+	redactTFSecrets(attrs)
+	return attrs
+}
+
+// tfValue deep-copies a decoded JSON value, cutting long strings.
+func tfValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = tfValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = tfValue(e)
+		}
+		return out
+	case string:
+		return truncateRunes(v, maxTFValue)
+	}
+	return v
+}
+
+// redactTFPath hides the value at p, if there is one.
+func redactTFPath(v interface{}, p tfPath) {
+	for i, step := range p {
+		key := step.Value
+		if m, ok := key.(map[string]interface{}); ok {
+			key = m["value"] // index steps hold a typed value
+		}
+		last := i == len(p)-1
+		switch c := v.(type) {
+		case map[string]interface{}:
+			k, ok := key.(string)
+			if !ok || c[k] == nil {
+				return
+			}
+			if last {
+				c[k] = tfSensitive
+				return
+			}
+			v = c[k]
+		case []interface{}:
+			n, err := strconv.Atoi(fmt.Sprint(key))
+			if err != nil || n < 0 || n >= len(c) || c[n] == nil {
+				return
+			}
+			if last {
+				c[n] = tfSensitive
+				return
+			}
+			v = c[n]
+		default:
+			return
+		}
+	}
+}
+
+// redactTFSecrets hides the strings of attributes named like secrets.
+func redactTFSecrets(v interface{}) {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		for k, e := range c {
+			if s, ok := e.(string); ok && s != "" && tfSecretName.MatchString(k) {
+				c[k] = tfSensitive
+			} else {
+				redactTFSecrets(e)
+			}
+		}
+	case []interface{}:
+		for _, e := range c {
+			redactTFSecrets(e)
+		}
+	}
+}
+
+// state returns the named state, or the only one when name is empty.
+func (c TerraformConfig) state(name string) (TerraformState, error) {
+	if name == "" && len(c.States) == 1 {
+		return c.States[0], nil
+	}
+	names := make([]string, len(c.States))
+	for i, st := range c.States {
+		if st.Name == name {
+			return st, nil
+		}
+		names[i] = st.Name
+	}
+	return TerraformState{}, fmt.Errorf("unknown terraform state %q; configured: %s", name, strings.Join(names, ", "))
+}
+
+// load returns a state, fetching it again when the cached copy is older
+// than CacheFor.
+func (ts *terraformStates) load(ctx context.Context, name string) (TerraformState, *tfState, error) {
+	st, err := ts.cfg.state(name)
+	if err != nil {
+		return st, nil, err
+	}
+	ts.mu.Lock()
+	cached, ok := ts.cache[st.Name]
+	ts.mu.Unlock()
+	if ok && time.Since(cached.fetched) < orDefault(st.CacheFor, defaultTFCache) {
+		return st, cached.state, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, orDefault(st.Timeout, defaultTFTimeout))
+	defer cancel()
+	data, err := ts.fetch(ctx, st)
+	if err != nil {
+		return st, nil, fmt.Errorf("%s: %w", st.Name, err)
+	}
+	state, err := parseTFState(data)
+	if err != nil {
+		return st, nil, fmt.Errorf("%s: %w", st.Name, err)
+	}
+	ts.mu.Lock()
+	ts.cache[st.Name] = tfCached{state: state, fetched: time.Now()}
+	ts.mu.Unlock()
+	return st, state, nil
+}
+
+func parseTFState(data []byte) (*tfState, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("no state has been stored yet")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keeps large IDs and sizes exact
+	var state tfState
+	if err := dec.Decode(&state); err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("state format version %d is not supported; Terraform 0.12 and later write version 4", state.Version)
+	}
+	return &state, nil
+}
+
+// fetch reads the state from its backend.
+func (ts *terraformStates) fetch(ctx context.Context, st TerraformState) ([]byte, error) {
+	switch st.Backend {
+	case "local":
+		f, err := os.Open(st.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxCloudResponse+1))
+		if err == nil && len(data) > maxCloudResponse {
+			err = fmt.Errorf("the state is over %d MiB", maxCloudResponse>>20)
+		}
+		return data, err
+	case "http":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, st.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := st.credentials().apply(req, ts.secrets); err != nil {
+			return nil, err
+		}
+		return cloudDo(ToolContextFrom(ctx).HTTPClient, req)
+	case "s3":
+		region := st.s3Region()
+		host, key := st.Bucket+".s3."+region+".amazonaws.com", "/"+strings.TrimPrefix(st.Key, "/")
+		if strings.Contains(st.Bucket, ".") {
+			// Dotted bucket names don't match the wildcard certificate.
+			host, key = "s3."+region+".amazonaws.com", "/"+st.Bucket+key
+		}
+		return ts.aws[st.Name].get(ctx, "s3", region, host, key, nil)
+	case "gcs":
+		token, _, err := ts.gcp.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var state json.RawMessage
+		err = getJSON(ctx, "https://storage.googleapis.com/storage/v1/b/"+url.PathEscape(st.Bucket)+"/o/"+url.PathEscape(st.Key)+"?alt=media", token, &state)
+		return state, err
+	default:
+		return ts.fetchRemote(ctx, st)
+	}
+}
+
+// fetchRemote downloads the current state version of an HCP Terraform or
+// Terraform Enterprise workspace. Without a configured token it uses the
+// TF_TOKEN_ variable Terraform itself reads, like TF_TOKEN_app_terraform_io.
+func (ts *terraformStates) fetchRemote(ctx context.Context, st TerraformState) ([]byte, error) {
+	base := st.remoteURL()
+	u, _ := url.Parse(base)
+	token, err := expandSecrets(st.Token, ts.secrets)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		env := "TF_TOKEN_" + strings.NewReplacer(".", "_", "-", "__").Replace(u.Hostname())
+		if token = os.Getenv(env); token == "" {
+			return nil, fmt.Errorf("no API token; set token or %s", env)
+		}
+	}
+	var ws struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, base+"/api/v2/organizations/"+url.PathEscape(st.Organization)+"/workspaces/"+url.PathEscape(st.Workspace), token, &ws); err != nil {
+		return nil, err
+	}
+	var version struct {
+		Data struct {
+			Attributes struct {
+				DownloadURL string `json:"hosted-state-download-url"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, base+"/api/v2/workspaces/"+url.PathEscape(ws.Data.ID)+"/current-state-version", token, &version); err != nil {
+		return nil, err
+	}
+	download, err := url.Parse(version.Data.Attributes.DownloadURL)
+	if err != nil || download.Scheme != "https" {
+		return nil, errors.New("the workspace has no state to download")
+	}
+	// The download URL is signed; the token goes only to the API host.
+	if download.Host != u.Host {
+		token = ""
+	}
+	var state json.RawMessage
+	err = getJSON(ctx, download.String(), token, &state)
+	return state, err
+}
+
+// tfMatcher turns a glob where * matches anything, brackets included,
+// into a matcher of addresses.
+func tfMatcher(glob string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*") + "$")
+}
+
+type tfListArgs struct {
+	State  string `json:"state,omitempty" jsonschema:"description=Configured state; may be left out when there is only one"`
+	Filter string `json:"filter,omitempty" jsonschema:"description=Address pattern where * matches anything\\, like module.network.* or *aws_instance.*"`
+	Type   string `json:"type,omitempty" jsonschema:"description=Only resources of this type\\, like aws_s3_bucket"`
+	Limit  int    `json:"limit,omitempty" jsonschema:"minimum=1,maximum=2000,description=Most resources to return (default 200)"`
+}
+
+func (ts *terraformStates) listResources(ctx context.Context, args tfListArgs) (interface{}, error) {
+	st, state, err := ts.load(ctx, args.State)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultTFResources
+	}
+	limit = min(limit, maxTFResources)
+	var match *regexp.Regexp
+	if args.Filter != "" {
+		match = tfMatcher(args.Filter)
+	}
+	resources := []map[string]interface{}{}
+	byType := map[string]int{}
+	matched := 0
+	for _, r := range state.Resources {
+		if args.Type != "" && r.Type != args.Type {
+			continue
+		}
+		base := r.address()
+		for _, in := range r.Instances {
+			addr := in.address(base)
+			if match != nil && !match.MatchString(addr) {
+				continue
+			}
+			matched++
+			byType[r.Type]++
+			if len(resources) == limit {
+				continue
+			}
+			id, _ := in.Attributes["id"].(string)
+			resources = append(resources, cloudItem(map[string]interface{}{
+				"address":  addr,
+				"type":     r.Type,
+				"provider": r.provider(),
+				"id":       id,
+				"status":   in.status(),
+			}))
+		}
+	}
+	return map[string]interface{}{
+		"state":             st.Name,
+		"terraform_version": state.TerraformVersion,
+		"serial":            state.Serial,
+		"resources":         resources,
+		"count":             matched,
+		"by_type":           byType,
+		"truncated":         matched > len(resources),
+	}, nil
+}
+
+type tfGetArgs struct {
+	State   string `json:"state,omitempty" jsonschema:"description=Configured state; may be left out when there is only one"`
+	Address string `json:"address" jsonschema:"required,description=Resource address\\, like aws_instance.web or module.db.aws_db_instance.main[0]; without an index every instance is returned"`
+}
+
+func (ts *terraformStates) getResource(ctx context.Context, args tfGetArgs) (interface{}, error) {
+	st, state, err := ts.load(ctx, args.State)
+	if err != nil {
+		return nil, err
+	}
+	instances := []map[string]interface{}{}
+	found := 0
+	for _, r := range state.Resources {
+		base := r.address()
+		for _, in := range r.Instances {
+			addr := in.address(base)
+			if addr != args.Address && base != args.Address {
+				continue
+			}
+			found++
+			if len(instances) == maxTFInstances {
+				continue
+			}
+			item := cloudItem(map[string]interface{}{
+				"address":    addr,
+				"type":       r.Type,
+				"provider":   r.provider(),
+				"status":     in.status(),
+				"attributes": in.attributes(),
+			})
+			if len(in.Dependencies) > 0 {
+				item["dependencies"] = in.Dependencies
+			}
+			instances = append(instances, item)
+		}
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("state %s has no resource %s; tf_list_resources lists the addresses", st.Name, args.Address)
+	}
+	return map[string]interface{}{"state": st.Name, "instances": instances, "truncated": found > len(instances)}, nil
+}
+
+type tfOutputsArgs struct {
+	State string `json:"state,omitempty" jsonschema:"description=Configured state; may be left out when there is only one"`
+	Name  string `json:"name,omitempty" jsonschema:"description=Only this output"`
+}
+
+func (ts *terraformStates) outputs(ctx context.Context, args tfOutputsArgs) (interface{}, error) {
+	st, state, err := ts.load(ctx, args.State)
+	if err != nil {
+		return nil, err
+	}
+	if args.Name != "" {
+		if _, ok := state.Outputs[args.Name]; !ok {
+			return nil, fmt.Errorf("state %s has no output %s", st.Name, args.Name)
+		}
+	}
+	outputs := map[string]interface{}{}
+	for name, out := range state.Outputs {
+		if args.Name != "" && name != args.Name {
+			continue
+		}
+		value := tfValue(out.Value)
+		if out.Sensitive {
+			value = tfSensitive
+		}
+		outputs[name] = map[string]interface{}{"value": value, "type": out.Type, "sensitive": out.Sensitive}
+	}
+	return map[string]interface{}{"state": st.Name, "outputs": outputs}, nil
+}
+
+type tfDriftArgs struct {
+	State   string   `json:"state,omitempty" jsonschema:"description=Configured state with a dir; may be left out when there is only one"`
+	Targets []string `json:"targets,omitempty" jsonschema:"description=Only check these resource or module addresses"`
+}
+
+// tfPlan is the part of terraform show -json of a plan tf_drift reads.
+type tfPlan struct {
+	Drift []struct {
+		Address string `json:"address"`
+		Type    string `json:"type"`
+		Change  struct {
+			Actions         []string               `json:"actions"`
+			Before          map[string]interface{} `json:"before"`
+			After           map[string]interface{} `json:"after"`
+			BeforeSensitive interface{}            `json:"before_sensitive"`
+			AfterSensitive  interface{}            `json:"after_sensitive"`
+		} `json:"change"`
+	} `json:"resource_drift"`
+}
+
+// drift runs terraform plan -refresh-only without a lock and reads the
+// plan back with terraform show. Neither writes the state.
+func (ts *terraformStates) drift(ctx context.Context, args tfDriftArgs) (interface{}, error) {
+	st, err := ts.cfg.state(args.State)
+	if err != nil {
+		return nil, err
+	}
+	if st.Dir == "" {
+		return nil, fmt.Errorf("state %s has no dir to plan in", st.Name)
+	}
+	binary := st.Binary
+	if binary == "" {
+		binary = "terraform"
+	}
+	tmp, err := os.MkdirTemp("", "tf-drift")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	planFile := filepath.Join(tmp, "drift.tfplan")
+	argv := []string{"plan", "-refresh-only", "-input=false", "-lock=false", "-no-color", "-detailed-exitcode", "-out=" + planFile}
+	for _, t := range args.Targets {
+		if !tfTarget.MatchString(t) {
+			return nil, fmt.Errorf("invalid target %q", t)
+		}
+		argv = append(argv, "-target="+t)
+	}
+	// Exit code 2 means the plan found changes.
+	if _, err := runScanner(ctx, nil, "", st.Dir, binary, argv, 2); err != nil {
+		return nil, err
+	}
+	data, err := runScanner(ctx, nil, "", st.Dir, binary, []string{"show", "-json", planFile})
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var plan tfPlan
+	if err := dec.Decode(&plan); err != nil {
+		return nil, fmt.Errorf("%s show: %w", filepath.Base(binary), err)
+	}
+	resources := []map[string]interface{}{}
+	for _, d := range plan.Drift {
+		c := d.Change
+		keys := map[string]bool{}
+		for k := range c.Before {
+			keys[k] = true
+		}
+		for k := range c.After {
+			keys[k] = true
+		}
+		names := make([]string, 0, len(keys))
+		for k := range keys {
+			if !reflect.DeepEqual(c.Before[k], c.After[k]) {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		changes := []map[string]interface{}{}
+		for _, k := range names[:min(len(names), maxTFChanges)] {
+			before, after := tfValue(c.Before[k]), tfValue(c.After[k])
+			if tfMarked(c.BeforeSensitive, k) || tfMarked(c.AfterSensitive, k) || tfSecretName.MatchString(k) {
+				before, after = tfSensitive, tfSensitive
+			}
+			changes = append(changes, map[string]interface{}{"attribute": k, "before": before, "after": after})
+		}
+		item := map[string]interface{}{"address": d.Address, "type": d.Type, "action": strings.Join(c.Actions, ",")}
+		if c.After != nil {
+			item["changes"] = changes
+			if len(names) > maxTFChanges {
+				item["more_changes"] = len(names) - maxTFChanges
+			}
+		}
+		resources = append(resources, item)
+	}
+	return map[string]interface{}{
+		"state":     st.Name,
+		"drifted":   len(resources) > 0,
+		"resources": resources,
+	}, nil
+}
+
+// tfMarked reports whether the sensitivity map of a plan marks any of
+// attribute k sensitive.
+func tfMarked(marks interface{}, k string) bool {
+	m, ok := marks.(map[string]interface{})
+	if !ok {
+		return marks == true
+	}
+	switch v := m[k].(type) {
+	case bool:
+		return v
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package mcpserver
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// TextConfig controls sanitization of text returned by tools. StripControl
+// defaults to true so tool output cannot carry terminal escape sequences
+// or bidirectional overrides into client UIs.
+type TextConfig struct {
+	Normalize    string `json:"normalize,omitempty"` // NFC, NFD, NFKC or NFKD
+	MaxRunes     int    `json:"maxRunes,omitempty"`
+	StripControl *bool  `json:"stripControl,omitempty"`
+}
+
+// truncationMarker is appended to text cut short by MaxRunes.
+const truncationMarker = "… [truncated]"
+
+func (c TextConfig) stripControl() bool {
+	return c.StripControl == nil || *c.StripControl
+}
+
+func normalizationForm(name string) (norm.Form, bool, error) {
+	switch strings.ToUpper(name) {
+	case "":
+		return 0, false, nil
+	case "NFC":
+		return norm.NFC, true, nil
+	case "NFD":
+		return norm.NFD, true, nil
+	case "NFKC":
+		return norm.NFKC, true, nil
+	case "NFKD":
+		return norm.NFKD, true, nil
+	default:
+		return 0, false, fmt.Errorf("unknown normalization form %q", name)
+	}
+}
+
+// sanitizeText makes s safe to display: invalid UTF-8 is replaced,
+// control and bidi-override characters are removed (newlines and tabs are
+// kept), the configured normalization is applied and the result is capped
+// at MaxRunes.
+func sanitizeText(s string, cfg TextConfig) (string, error) {
+	s = strings.ToValidUTF8(s, "\ufffd")
+	if cfg.stripControl() {
+		s = stripControl(s)
+	}
+	form, ok, err := normalizationForm(cfg.Normalize)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		s = form.String(s)
+	}
+	return truncateRunes(s, cfg.MaxRunes), nil
+}
+
+// stripControl removes C0/C1 control characters other than newline and
+// tab, and Unicode bidirectional formatting characters.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r):
+			return -1
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069',
+			r == '\u200e', r == '\u200f', r == '\u061c':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// truncateRunes cuts s to at most limit runes (including the marker).
+// limit <= 0 means unlimited.
+func truncateRunes(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	keep := limit - utf8.RuneCountInString(truncationMarker)
+	if keep < 0 {
+		keep = 0
+	}
+	i, n := 0, 0
+	for i < len(s) && n < keep {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return s[:i] + truncationMarker
+}
+
+// sanitizeResult applies sanitizeText to every text item of a tool result
+// built by textResult or errorResult.
+func sanitizeResult(result interface{}, cfg TextConfig) interface{} {
+	m, ok := result.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	items, ok := m["content"].([]map[string]interface{})
+	if !ok {
+		return result
+	}
+	for _, item := range items {
+		if text, ok := item["text"].(string); ok && item["type"] == "text" {
+			if clean, err := sanitizeText(text, cfg); err == nil {
+				item["text"] = clean
+			}
+		}
+	}
+	return result
+}
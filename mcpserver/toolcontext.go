@@ -0,0 +1,139 @@
+package mcpserver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Services are the dependencies shared by all tool handlers. Fields left
+// nil get defaults: the standard logger and an HTTP client that propagates
+// trace context.
+type Services struct {
+	Logger     *log.Logger
+	HTTPClient *http.Client
+	DB         *sql.DB
+}
+
+// withDefaults fills in unset services. A caller-supplied HTTP client is
+// copied with trace propagation added to its transport.
+func (sv Services) withDefaults() Services {
+	if sv.Logger == nil {
+		sv.Logger = log.Default()
+	}
+	if sv.HTTPClient == nil {
+		sv.HTTPClient = outboundClient
+	} else if _, ok := sv.HTTPClient.Transport.(*tracingTransport); !ok {
+		client := *sv.HTTPClient
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &tracingTransport{base: base}
+		sv.HTTPClient = &client
+	}
+	return sv
+}
+
+// ToolContext is what a tool handler knows about its call: the shared
+// services plus who is calling. Handlers get it with ToolContextFrom;
+// tests can supply their own with WithToolContext.
+type ToolContext struct {
+	Services
+
+	// Tool is the name of the tool being called.
+	Tool string
+	// Caller is the authenticated caller, nil when auth is disabled and
+	// the call has no session.
+	Caller *Caller
+	// Session is the caller's MCP session, nil without one.
+	Session *Session
+	// ClientCapabilities are the capabilities the client announced in
+	// initialize, nil without a session.
+	ClientCapabilities json.RawMessage
+	// DryRun is set when the call only previews the tool, as tools/validate
+	// does. Handlers must then avoid side effects.
+	DryRun bool
+	// ProgressToken is the progressToken of the call's _meta, nil when the
+	// client did not ask for progress notifications.
+	ProgressToken json.RawMessage
+
+	server *MCPServer
+}
+
+type toolContextKey struct{}
+
+type progressTokenKey struct{}
+
+// withProgressToken returns ctx carrying the progressToken of a
+// tools/call's _meta, if it has one.
+func withProgressToken(ctx context.Context, meta json.RawMessage) context.Context {
+	var m struct {
+		Token json.RawMessage `json:"progressToken"`
+	}
+	if json.Unmarshal(meta, &m) != nil || len(m.Token) == 0 || string(m.Token) == "null" {
+		return ctx
+	}
+	return context.WithValue(ctx, progressTokenKey{}, m.Token)
+}
+
+// WithToolContext returns a context carrying tc.
+func WithToolContext(ctx context.Context, tc *ToolContext) context.Context {
+	return context.WithValue(ctx, toolContextKey{}, tc)
+}
+
+// ToolContextFrom returns the ToolContext of a tool call. Outside a call it
+// returns one holding only the default services.
+func ToolContextFrom(ctx context.Context) *ToolContext {
+	if tc, ok := ctx.Value(toolContextKey{}).(*ToolContext); ok {
+		return tc
+	}
+	return &ToolContext{Services: Services{}.withDefaults()}
+}
+
+// toolContext returns ctx with the ToolContext of a call to tool. A call
+// made by a dry run is a dry run too.
+func (s *MCPServer) toolContext(ctx context.Context, tool string) context.Context {
+	tc := &ToolContext{Services: s.services, Tool: tool, Caller: callerFrom(ctx), server: s}
+	if parent, ok := ctx.Value(toolContextKey{}).(*ToolContext); ok {
+		tc.DryRun = parent.DryRun
+	}
+	tc.ProgressToken, _ = ctx.Value(progressTokenKey{}).(json.RawMessage)
+	tc.HTTPClient = s.httpClient()
+	if s.vcrClient != nil {
+		tc.HTTPClient = s.vcrClient
+	}
+	if sess := sessionFrom(ctx); sess != nil {
+		tc.Session = sess
+		tc.ClientCapabilities = sess.Capabilities
+	}
+	return WithToolContext(ctx, tc)
+}
+
+// httpClient is the client of outbound requests: the services' client,
+// proxied when egress is configured.
+func (s *MCPServer) httpClient() *http.Client {
+	if s.egress != nil {
+		return s.egress
+	}
+	return s.services.HTTPClient
+}
+
+// Progress sends a notifications/progress for the call to its session.
+// It does nothing unless the client sent a progressToken. A total of 0 is
+// left out, for work of unknown size.
+func (tc *ToolContext) Progress(progress, total float64, message string) {
+	if len(tc.ProgressToken) == 0 || tc.Session == nil || tc.server == nil {
+		return
+	}
+	params := map[string]interface{}{"progressToken": tc.ProgressToken, "progress": progress}
+	if total > 0 {
+		params["total"] = total
+	}
+	if message != "" {
+		params["message"] = message
+	}
+	tc.server.notifier.send(tc.Session.ID, "notifications/progress", params)
+}
@@ -0,0 +1,145 @@
+package mcpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// maxToolLists bounds the cached tools/list results of a generation; past
+// it the cache starts over.
+const maxToolLists = 256
+
+// toolListCache keeps the serialized tools/list result per audience: the
+// API key, the client where exposure rules look at it, and the locale.
+// Each generation has its own cache, and a change of feature flags clears
+// it, so listing hundreds of tools is a map lookup.
+type toolListCache struct {
+	mu    sync.Mutex
+	flags uint64 // flagStore version the lists were built with
+	lists map[string]json.RawMessage
+}
+
+func newToolListCache() *toolListCache {
+	return &toolListCache{lists: map[string]json.RawMessage{}}
+}
+
+// listTools returns the tools/list result for caller in locale.
+func (s *MCPServer) listTools(caller *Caller, locale language.Tag) json.RawMessage {
+	audience := caller.KeyName() + "\x00" + locale.String()
+	if s.exposureByClient() && caller.Session != nil {
+		audience += "\x00" + caller.Session.ClientName + "\x00" + caller.Session.ClientVersion
+	}
+	flags := s.flags.currentVersion()
+	c := s.toolLists
+	c.mu.Lock()
+	if c.flags != flags {
+		c.flags, c.lists = flags, map[string]json.RawMessage{}
+	}
+	raw, ok := c.lists[audience]
+	c.mu.Unlock()
+	if ok {
+		return raw
+	}
+
+	// Sorted by name, so the same audience always gets the same bytes.
+	tools := []Tool{}
+	for _, name := range s.toolNames() {
+		if tool := s.tools[name]; s.toolVisible(name, caller) {
+			tool.Description = s.i18n.translate(locale, tool.Description)
+			tools = append(tools, tool)
+		}
+	}
+	raw, _ = json.Marshal(map[string]interface{}{"tools": tools})
+	c.mu.Lock()
+	if c.flags == flags {
+		if len(c.lists) >= maxToolLists {
+			c.lists = map[string]json.RawMessage{}
+		}
+		c.lists[audience] = raw
+	}
+	c.mu.Unlock()
+	return raw
+}
+
+// canonicalSchema re-encodes a schema given as raw JSON, or as a struct,
+// into generic values, so it is marshaled with sorted keys however it was
+// written. Numbers keep their exact text.
+func canonicalSchema(schema interface{}) interface{} {
+	if _, ok := schema.(map[string]interface{}); ok || schema == nil {
+		return schema
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return schema
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil {
+		return schema
+	}
+	return v
+}
+
+// exposureByClient reports whether an exposure rule depends on the
+// client's name or version, which then tell audiences apart.
+func (s *MCPServer) exposureByClient() bool {
+	for _, rule := range s.cfg.Exposure {
+		if len(rule.Clients) > 0 || rule.MinClientVersion != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// exposureFlag reports whether an exposure rule is gated on the flag, so
+// that changing it can change tool lists.
+func (s *MCPServer) exposureFlag(flag string) bool {
+	for _, rule := range s.cfg.Exposure {
+		if rule.Flag == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// toolsFingerprint summarizes everything tools/list answers depend on
+// besides flags: the tool definitions, who may see them, and their
+// translations. Reloads that keep it send no listChanged notification.
+func (s *MCPServer) toolsFingerprint() string {
+	names := s.toolNames()
+	tools := make([]Tool, len(names))
+	for i, name := range names {
+		tools[i] = s.tools[name]
+	}
+	type keyScope struct {
+		Name, Role string
+		Tools      []string
+	}
+	keys := make([]keyScope, len(s.cfg.Auth.APIKeys))
+	for i, k := range s.cfg.Auth.APIKeys {
+		keys[i] = keyScope{k.Name, k.Role, k.Tools}
+	}
+	// The flags of the config file, which reloads replace.
+	flags := map[string]FlagConfig{}
+	for _, rule := range s.cfg.Exposure {
+		if rule.Flag != "" {
+			flags[rule.Flag] = s.cfg.Features.Flags[rule.Flag]
+		}
+	}
+	raw, _ := json.Marshal(map[string]interface{}{
+		"tools":    tools,
+		"exposure": s.cfg.Exposure,
+		"flags":    flags,
+		"readOnly": s.cfg.Policy.ReadOnly,
+		"keys":     keys,
+		"i18n":     s.cfg.I18n,
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
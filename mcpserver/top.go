@@ -0,0 +1,289 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// top polls a server's admin API and shows its activity.
+type top struct {
+	base   string
+	token  string
+	client *http.Client
+	sortBy int
+
+	// Previous poll, for rates.
+	prevAt    time.Time
+	prevCalls map[string]ToolUsageStats
+}
+
+// topSnapshot is one poll of the admin API.
+type topSnapshot struct {
+	At       time.Time
+	Runtime  RuntimeStats
+	Tools    []topTool
+	Sessions []topSession
+	Errors   []ToolError
+	Rate     float64 // calls per second, all tools
+	ErrRate  float64
+}
+
+type topTool struct {
+	ToolUsageStats
+	Rate float64
+}
+
+type topSession struct {
+	ClientName    string    `json:"clientName"`
+	ClientVersion string    `json:"clientVersion"`
+	Key           string    `json:"key"`
+	Created       time.Time `json:"created"`
+	LastSeen      time.Time `json:"lastSeen"`
+}
+
+// topSorts are the orders of the tools table, cycled with "s".
+var topSorts = []struct {
+	name string
+	less func(a, b topTool) bool
+}{
+	{"calls/s", func(a, b topTool) bool { return a.Rate > b.Rate }},
+	{"calls", func(a, b topTool) bool { return a.Calls > b.Calls }},
+	{"p99", func(a, b topTool) bool { return a.P99Ms > b.P99Ms }},
+	{"errors", func(a, b topTool) bool { return a.Errors > b.Errors }},
+	{"name", func(a, b topTool) bool { return a.Tool < b.Tool }},
+}
+
+// runTopCommand implements the "top" subcommand.
+func runTopCommand(args []string) int {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	target := fs.String("target", strings.TrimSuffix(defaultTarget(), "/mcp"), "base URL of the server")
+	token := fs.String("admin-token", os.Getenv("MCP_ADMIN_TOKEN"), "admin API token (default $MCP_ADMIN_TOKEN)")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	once := fs.Bool("once", false, "print one snapshot, with rates over -interval, and exit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *token == "" || *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: mcp-server top -admin-token <token> [-target http://host:port] [-interval 2s] [-once]")
+		return 2
+	}
+	t := &top{
+		base:   strings.TrimSuffix(*target, "/"),
+		token:  *token,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fd := int(os.Stdout.Fd())
+	if *once || !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		if _, err := t.poll(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "top: %v\n", err)
+			return 1
+		}
+		time.Sleep(*interval)
+		snap, err := t.poll(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "top: %v\n", err)
+			return 1
+		}
+		t.render(os.Stdout, snap, 0, 0)
+		return 0
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "top: %v\n", err)
+		return 1
+	}
+	defer term.Restore(int(os.Stdin.Fd()), state)
+	// Alternate screen, hidden cursor; restored on exit.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				close(keys)
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var snap *topSnapshot
+	var pollErr error
+	refresh := true
+	for {
+		if refresh {
+			if s, err := t.poll(ctx); err == nil {
+				snap, pollErr = s, nil
+			} else {
+				pollErr = err
+			}
+		}
+		refresh = true
+		width, height, _ := term.GetSize(fd)
+		var b strings.Builder
+		if pollErr != nil {
+			fmt.Fprintf(&b, "poll failed: %v\n", pollErr)
+			height--
+		}
+		if snap != nil {
+			t.render(&b, snap, width, height)
+		}
+		// Raw mode does not translate newlines.
+		fmt.Print("\x1b[H\x1b[2J" + strings.ReplaceAll(b.String(), "\n", "\r\n"))
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		case k, ok := <-keys:
+			switch {
+			case !ok, k == 'q', k == 3: // Ctrl-C arrives as a byte in raw mode
+				return 0
+			case k == 's':
+				t.sortBy = (t.sortBy + 1) % len(topSorts)
+				refresh = false
+			}
+		}
+	}
+}
+
+// poll reads the admin API and computes rates against the previous poll.
+func (t *top) poll(ctx context.Context) (*topSnapshot, error) {
+	snap := &topSnapshot{At: time.Now()}
+	var usage struct {
+		Tools []ToolUsageStats `json:"tools"`
+	}
+	var sessions struct {
+		Sessions []topSession `json:"sessions"`
+	}
+	var errs struct {
+		Errors []ToolError `json:"errors"`
+	}
+	for path, v := range map[string]interface{}{
+		"/admin/runtime":      &snap.Runtime,
+		"/admin/usage":        &usage,
+		"/admin/sessions":     &sessions,
+		"/admin/usage/errors": &errs,
+	} {
+		if err := t.get(ctx, path, v); err != nil {
+			return nil, err
+		}
+	}
+	snap.Sessions, snap.Errors = sessions.Sessions, errs.Errors
+
+	elapsed := snap.At.Sub(t.prevAt).Seconds()
+	calls := map[string]ToolUsageStats{}
+	for _, st := range usage.Tools {
+		calls[st.Tool] = st
+		tt := topTool{ToolUsageStats: st}
+		if prev, ok := t.prevCalls[st.Tool]; ok && st.Calls >= prev.Calls {
+			tt.Rate = float64(st.Calls-prev.Calls) / elapsed
+			snap.Rate += tt.Rate
+			if st.Errors >= prev.Errors {
+				snap.ErrRate += float64(st.Errors-prev.Errors) / elapsed
+			}
+		}
+		snap.Tools = append(snap.Tools, tt)
+	}
+	t.prevAt, t.prevCalls = snap.At, calls
+	return snap, nil
+}
+
+func (t *top) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: HTTP %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// render writes snap to fit a width by height screen; zero means no limit.
+// The tools table gets the lines the header, sessions and errors leave.
+func (t *top) render(w io.Writer, snap *topSnapshot, width, height int) {
+	rt := snap.Runtime
+	fmt.Fprintf(w, "mcp-server top - %s  %s  up %v  goroutines %d  heap %.1f MB\n",
+		t.base, snap.At.Format("15:04:05"), time.Duration(rt.UptimeSeconds)*time.Second,
+		rt.Goroutines, float64(rt.HeapAlloc)/(1<<20))
+	fmt.Fprintf(w, "Throughput %.1f calls/s  errors %.1f/s  sessions %d\n\n", snap.Rate, snap.ErrRate, len(snap.Sessions))
+
+	sessions, errs := snap.Sessions, snap.Errors
+	toolRows := len(snap.Tools)
+	if height > 0 {
+		// Header 3, tool table header 1, and a blank line before each of
+		// the section titles and the keys hint 6.
+		room := height - 10
+		if room < 0 {
+			room = 0
+		}
+		if n := room / 4; len(sessions) > n {
+			sessions = sessions[:n]
+		}
+		if n := room / 4; len(errs) > n {
+			errs = errs[:n]
+		}
+		if n := room - len(sessions) - len(errs); toolRows > n {
+			toolRows = n
+		}
+	}
+
+	tools := append([]topTool(nil), snap.Tools...)
+	less := topSorts[t.sortBy].less
+	sort.SliceStable(tools, func(i, j int) bool { return less(tools[i], tools[j]) })
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOOL\tCALLS/S\tCALLS\tERRORS\tERR%\tP50 MS\tP90 MS\tP99 MS\t")
+	for _, tt := range tools[:toolRows] {
+		fmt.Fprintf(tw, "%s\t%.1f\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			tt.Tool, tt.Rate, tt.Calls, tt.Errors, tt.ErrorRate*100, tt.P50Ms, tt.P90Ms, tt.P99Ms)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nSESSIONS (%d)\n", len(snap.Sessions))
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range sessions {
+		client := strings.TrimSpace(s.ClientName + " " + s.ClientVersion)
+		fmt.Fprintf(tw, "%s\t%s\tage %v\tidle %v\t\n", client, s.Key,
+			snap.At.Sub(s.Created).Round(time.Second), snap.At.Sub(s.LastSeen).Round(time.Second))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nRECENT ERRORS")
+	for _, e := range errs {
+		line := fmt.Sprintf("%s  %s  %s", e.Time.Local().Format("15:04:05"), e.Tool, strings.Join(strings.Fields(e.Message), " "))
+		if width > 0 {
+			line = truncateRunes(line, width)
+		}
+		fmt.Fprintln(w, line)
+	}
+	if height > 0 {
+		fmt.Fprintf(w, "\nq quit  s sort (by %s)", topSorts[t.sortBy].name)
+	}
+}
@@ -0,0 +1,112 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceContext is a W3C Trace Context (https://www.w3.org/TR/trace-context/).
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+	State   string
+}
+
+// parseTraceparent parses a version 00 traceparent header.
+func parseTraceparent(h string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || parts[0] != "00" ||
+		!isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) ||
+		strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}, true
+}
+
+// isHex reports whether s is n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// traceFromRequest continues the caller's trace, or starts a new sampled
+// one when the request carries no valid traceparent.
+func traceFromRequest(r *http.Request) TraceContext {
+	if tc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		tc.State = r.Header.Get("tracestate")
+		return tc.child()
+	}
+	return TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "01"}
+}
+
+// child returns a new span in the same trace.
+func (tc TraceContext) child() TraceContext {
+	tc.SpanID = randomHex(8)
+	return tc
+}
+
+func (tc TraceContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type traceKey struct{}
+
+func withTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+func traceFrom(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// traceEnv returns environment variables carrying the trace into a
+// subprocess, following the OpenTelemetry environment carrier convention.
+func traceEnv(ctx context.Context) []string {
+	tc, ok := traceFrom(ctx)
+	if !ok {
+		return nil
+	}
+	child := tc.child()
+	env := []string{"TRACEPARENT=" + child.traceparent(), "TRACE_ID=" + tc.TraceID}
+	if tc.State != "" {
+		env = append(env, "TRACESTATE="+tc.State)
+	}
+	return env
+}
+
+// tracingTransport adds traceparent/tracestate headers to outbound
+// requests whose context carries a trace.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tc, ok := traceFrom(req.Context())
+	if !ok || req.Header.Get("traceparent") != "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", tc.child().traceparent())
+	if tc.State != "" {
+		req.Header.Set("tracestate", tc.State)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// outboundClient is the default HTTP client of tool handlers.
+var outboundClient = &http.Client{Transport: &tracingTransport{base: http.DefaultTransport}}
@@ -0,0 +1,536 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TransferConfig lists the SFTP and FTPS servers of legacy systems the
+// transfer tools exchange files with. Tools name an endpoint, never a
+// host, so agents reach only these servers, with credentials they never
+// see.
+type TransferConfig struct {
+	Endpoints []TransferEndpoint `json:"endpoints,omitempty"`
+}
+
+// TransferEndpoint is one server. Password, PrivateKey and Passphrase may
+// refer to ${secret:name}. FTPS servers are trusted like the targets of
+// HTTP tools, per egress.tls; SFTP servers must present a key in HostKeys.
+type TransferEndpoint struct {
+	Name     string `json:"name" schema:"required"`
+	Protocol string `json:"protocol" schema:"required,enum=sftp|ftps"`
+	// Host is a host name or host:port; the port defaults to 22 for sftp,
+	// 21 for ftps and 990 with ImplicitTLS.
+	Host       string `json:"host" schema:"required"`
+	Username   string `json:"username" schema:"required"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"` // sftp, PEM or OpenSSH format
+	Passphrase string `json:"passphrase,omitempty"` // of an encrypted PrivateKey
+	// HostKeys pin the keys an sftp server may present, as
+	// authorized_keys or known_hosts lines or SHA256: fingerprints.
+	HostKeys []string `json:"hostKeys,omitempty"`
+	// ImplicitTLS starts ftps connections with TLS instead of AUTH TLS.
+	ImplicitTLS bool `json:"implicitTLS,omitempty"`
+	// Dir confines the tools to a remote directory; relative to the login
+	// directory, which is the default, unless absolute.
+	Dir string `json:"dir,omitempty"`
+	// Writable allows transfer_upload to the endpoint.
+	Writable bool `json:"writable,omitempty"`
+	// BandwidthLimit caps the bytes per second of each transfer, e.g. "1MiB".
+	BandwidthLimit string   `json:"bandwidthLimit,omitempty" schema:"format=byteSize"`
+	Timeout        Duration `json:"timeout,omitempty" schema:"format=duration"` // connecting and logging in; default 30s
+}
+
+const (
+	defaultTransferTimeout = 30 * time.Second
+	// maxTransferEntries bounds the directory listings of transfer_list.
+	maxTransferEntries = 1000
+
+	transferBytesMetric = "mcp_transfer_bytes_total"
+)
+
+func (c TransferConfig) enabled() bool {
+	return len(c.Endpoints) > 0
+}
+
+func (c TransferConfig) writable() bool {
+	for _, e := range c.Endpoints {
+		if e.Writable {
+			return true
+		}
+	}
+	return false
+}
+
+func (c TransferConfig) check(secrets map[string]string) error {
+	seen := map[string]bool{}
+	for _, e := range c.Endpoints {
+		if e.Name == "" {
+			return errors.New("endpoints need a name")
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("duplicate endpoint %q", e.Name)
+		}
+		seen[e.Name] = true
+		if err := e.check(secrets); err != nil {
+			return fmt.Errorf("endpoint %q: %w", e.Name, err)
+		}
+	}
+	return nil
+}
+
+func (e TransferEndpoint) check(secrets map[string]string) error {
+	if e.Host == "" || e.Username == "" {
+		return errors.New("host and username are required")
+	}
+	if _, _, err := net.SplitHostPort(e.address()); err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+	if _, err := e.bandwidth(); err != nil {
+		return fmt.Errorf("bandwidthLimit: %w", err)
+	}
+	switch e.Protocol {
+	case "sftp":
+		if e.ImplicitTLS {
+			return errors.New("implicitTLS is for ftps")
+		}
+		if _, err := pinnedHostKeys(e.HostKeys); err != nil {
+			return err
+		}
+		_, err := e.sshAuth(secrets)
+		return err
+	case "ftps":
+		if len(e.HostKeys) > 0 || e.PrivateKey != "" {
+			return errors.New("hostKeys and privateKey are for sftp")
+		}
+		_, err := expandSecrets(e.Password, secrets)
+		return err
+	}
+	return fmt.Errorf("protocol %q must be sftp or ftps", e.Protocol)
+}
+
+// address returns host:port.
+func (e TransferEndpoint) address() string {
+	if _, _, err := net.SplitHostPort(e.Host); err == nil {
+		return e.Host
+	}
+	port := "21"
+	switch {
+	case e.Protocol == "sftp":
+		port = "22"
+	case e.ImplicitTLS:
+		port = "990"
+	}
+	return net.JoinHostPort(e.Host, port)
+}
+
+func (e TransferEndpoint) timeout() time.Duration {
+	return orDefault(e.Timeout, defaultTransferTimeout)
+}
+
+// bandwidth returns the limit in bytes per second, 0 for none.
+func (e TransferEndpoint) bandwidth() (int64, error) {
+	if e.BandwidthLimit == "" {
+		return 0, nil
+	}
+	return parseByteSize(e.BandwidthLimit)
+}
+
+// sshAuth offers the private key, then the password, which also answers
+// keyboard-interactive prompts.
+func (e TransferEndpoint) sshAuth(secrets map[string]string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if e.PrivateKey != "" {
+		key, err := expandSecrets(e.PrivateKey, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("privateKey: %w", err)
+		}
+		passphrase, err := expandSecrets(e.Passphrase, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("passphrase: %w", err)
+		}
+		var signer ssh.Signer
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(key))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("privateKey: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if e.Password != "" {
+		password, err := expandSecrets(e.Password, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("password: %w", err)
+		}
+		methods = append(methods, ssh.Password(password),
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("password or privateKey is required")
+	}
+	return methods, nil
+}
+
+// remotePath maps a tool's path into the endpoint's Dir; ".." stops there.
+func (e TransferEndpoint) remotePath(rel string) (string, error) {
+	if strings.ContainsAny(rel, "\x00\r\n") {
+		return "", fmt.Errorf("invalid remote path %q", rel)
+	}
+	dir := e.Dir
+	if dir == "" {
+		dir = "."
+	}
+	return path.Join(dir, path.Clean("/" + rel)[1:]), nil
+}
+
+// endpoint returns the endpoint called name; "" selects the only one.
+func (c TransferConfig) endpoint(name string) (TransferEndpoint, error) {
+	if name == "" && len(c.Endpoints) == 1 {
+		return c.Endpoints[0], nil
+	}
+	for _, e := range c.Endpoints {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	if name == "" {
+		return TransferEndpoint{}, errors.New("several transfer endpoints are configured; name one")
+	}
+	return TransferEndpoint{}, fmt.Errorf("no transfer endpoint %q", name)
+}
+
+// remoteEntry is a file or directory on an endpoint.
+type remoteEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+	Mode    string
+}
+
+// transferClient is a logged-in connection to an endpoint.
+type transferClient interface {
+	list(dir string) ([]remoteEntry, error)
+	stat(p string) (remoteEntry, error)
+	open(p string) (io.ReadCloser, error)
+	create(p string, overwrite bool) (io.WriteCloser, error)
+	close() error
+}
+
+// connectTransfer logs in to e. The connection is closed when ctx is
+// cancelled, which ends a transfer blocked on the network; the returned
+// function closes it otherwise.
+func (s *MCPServer) connectTransfer(ctx context.Context, e TransferEndpoint) (transferClient, func(), error) {
+	var c transferClient
+	switch e.Protocol {
+	case "sftp":
+		sc, err := dialSFTP(ctx, e, s.secrets)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		c = sc
+	default:
+		tlsConfig, err := s.cfg.Egress.TLS.clientConfig(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		fc, err := dialFTPS(ctx, e, s.secrets, tlsConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		c = fc
+	}
+	stop := context.AfterFunc(ctx, func() { c.close() })
+	return c, func() {
+		if stop() {
+			c.close()
+		}
+	}, nil
+}
+
+type transferListArgs struct {
+	Endpoint string `json:"endpoint,omitempty" jsonschema:"description=Configured endpoint; may be left out when there is only one"`
+	Path     string `json:"path,omitempty" jsonschema:"description=Directory relative to the endpoint's directory (default the directory itself)"`
+}
+
+type transferDownloadArgs struct {
+	Endpoint   string `json:"endpoint,omitempty" jsonschema:"description=Configured endpoint; may be left out when there is only one"`
+	RemotePath string `json:"remotePath" jsonschema:"required,description=File to download\\, relative to the endpoint's directory"`
+	Root       string `json:"root,omitempty" jsonschema:"description=Writable workspace root of path"`
+	Path       string `json:"path" jsonschema:"required,description=Root-relative file to write"`
+	Overwrite  bool   `json:"overwrite,omitempty" jsonschema:"description=Replace path if it exists"`
+}
+
+type transferUploadArgs struct {
+	Endpoint   string `json:"endpoint,omitempty" jsonschema:"description=Writable configured endpoint; may be left out when there is only one"`
+	Root       string `json:"root,omitempty" jsonschema:"description=Workspace root of path"`
+	Path       string `json:"path" jsonschema:"required,description=Root-relative file to upload"`
+	RemotePath string `json:"remotePath" jsonschema:"required,description=File to write\\, relative to the endpoint's directory"`
+	Overwrite  bool   `json:"overwrite,omitempty" jsonschema:"description=Replace remotePath if it exists"`
+}
+
+func (s *MCPServer) setupTransferTools() {
+	openWorld, destructive := true, true
+	list, listHandler, _ := typedTool("transfer_list", "List a directory on a configured SFTP or FTPS endpoint", s.transferListTool)
+	list.Annotations = readOnlyAnnotations()
+	list.Annotations.OpenWorldHint = &openWorld
+	s.addTool(list, listHandler)
+
+	if s.cfg.Workspace.writable() {
+		download, downloadHandler, _ := typedTool("transfer_download", "Download a file from a configured SFTP or FTPS endpoint into a writable workspace root, with progress updates", s.transferDownloadTool)
+		download.Annotations = &ToolAnnotations{DestructiveHint: &destructive, OpenWorldHint: &openWorld}
+		s.addTool(download, downloadHandler)
+	}
+	if s.cfg.Workspace.enabled() && s.cfg.Transfer.writable() {
+		upload, uploadHandler, _ := typedTool("transfer_upload", "Upload a workspace file to a writable SFTP or FTPS endpoint, with progress updates", s.transferUploadTool)
+		upload.Annotations = &ToolAnnotations{DestructiveHint: &destructive, OpenWorldHint: &openWorld}
+		s.addTool(upload, uploadHandler)
+	}
+}
+
+func (s *MCPServer) transferListTool(ctx context.Context, args transferListArgs) (interface{}, error) {
+	e, err := s.cfg.Transfer.endpoint(args.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := e.remotePath(args.Path)
+	if err != nil {
+		return nil, err
+	}
+	c, done, err := s.connectTransfer(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	entries, err := c.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	out := make([]map[string]interface{}, len(entries))
+	for i, entry := range entries {
+		m := map[string]interface{}{"name": entry.Name, "dir": entry.Dir}
+		if !entry.Dir {
+			m["size"] = entry.Size
+		}
+		if !entry.ModTime.IsZero() {
+			m["modTime"] = entry.ModTime.Format(time.RFC3339)
+		}
+		if entry.Mode != "" {
+			m["mode"] = entry.Mode
+		}
+		out[i] = m
+	}
+	result := map[string]interface{}{"endpoint": e.Name, "path": args.Path, "entries": out}
+	if len(entries) >= maxTransferEntries {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+func (s *MCPServer) transferDownloadTool(ctx context.Context, args transferDownloadArgs) (interface{}, error) {
+	e, err := s.cfg.Transfer.endpoint(args.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := e.remotePath(args.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.cfg.Workspace.root(args.Root)
+	if err != nil {
+		return nil, err
+	}
+	if !r.Writable {
+		return nil, fmt.Errorf("workspace root %q is not writable", r.Name)
+	}
+	full, err := r.resolve(args.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(full); err == nil && !args.Overwrite {
+		return nil, fmt.Errorf("%s exists; set overwrite to replace it", args.Path)
+	}
+
+	c, done, err := s.connectTransfer(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	st, err := c.stat(remote)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args.RemotePath, err)
+	}
+	if st.Dir {
+		return nil, fmt.Errorf("%s is a directory", args.RemotePath)
+	}
+	src, err := c.open(remote)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args.RemotePath, err)
+	}
+	// A download lands under a temporary name, so a failed one leaves
+	// no partial file behind.
+	var (
+		n       int64
+		sum     string
+		elapsed time.Duration
+	)
+	err = writeFileVia(full, func(w io.Writer) error {
+		n, sum, elapsed, err = s.copyTransfer(ctx, e, "download", w, src, st.Size)
+		return err
+	})
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"endpoint":   e.Name,
+		"remotePath": args.RemotePath,
+		"root":       r.Name,
+		"path":       args.Path,
+		"size":       n,
+		"sha256":     sum,
+		"durationMs": elapsed.Milliseconds(),
+	}, nil
+}
+
+func (s *MCPServer) transferUploadTool(ctx context.Context, args transferUploadArgs) (interface{}, error) {
+	e, err := s.cfg.Transfer.endpoint(args.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Writable {
+		return nil, fmt.Errorf("transfer endpoint %q is not writable", e.Name)
+	}
+	remote, err := e.remotePath(args.RemotePath)
+	if err != nil {
+		return nil, err
+	}
+	full, err := s.workspaceFile(args.Root, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c, done, err := s.connectTransfer(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	dst, err := c.create(remote, args.Overwrite)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%s exists on %s; set overwrite to replace it", args.RemotePath, e.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", args.RemotePath, err)
+	}
+	n, sum, elapsed, err := s.copyTransfer(ctx, e, "upload", dst, f, st.Size())
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"endpoint":   e.Name,
+		"remotePath": args.RemotePath,
+		"path":       args.Path,
+		"size":       n,
+		"sha256":     sum,
+		"durationMs": elapsed.Milliseconds(),
+	}, nil
+}
+
+// copyTransfer copies src to dst within the endpoint's bandwidth limit,
+// returning the bytes copied and their SHA-256.
+func (s *MCPServer) copyTransfer(ctx context.Context, e TransferEndpoint, direction string, dst io.Writer, src io.Reader, total int64) (int64, string, time.Duration, error) {
+	limit, _ := e.bandwidth()
+	now := time.Now()
+	tr := &transferReader{
+		ctx: ctx, r: src, limit: limit, total: total, verb: direction + "ed",
+		hash: sha256.New(), start: now, lastProgress: now,
+	}
+	n, err := io.CopyBuffer(dst, tr, make([]byte, 256<<10))
+	s.metrics.add(transferBytesMetric, float64(n), "endpoint", e.Name, "direction", direction)
+	if err != nil {
+		return n, "", 0, err
+	}
+	return n, hex.EncodeToString(tr.hash.Sum(nil)), time.Since(now), nil
+}
+
+// transferReader paces reads to an average of limit bytes a second,
+// hashes what passes, and reports progress at most every
+// progressInterval.
+type transferReader struct {
+	ctx          context.Context
+	r            io.Reader
+	limit        int64
+	total        int64
+	verb         string
+	hash         hash.Hash
+	n            int64
+	start        time.Time
+	lastProgress time.Time
+}
+
+func (t *transferReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	// Small reads under a limit keep the pauses short and even.
+	if step := t.limit/10 + 1; t.limit > 0 && int64(len(p)) > step {
+		p = p[:step]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	t.hash.Write(p[:n])
+	if time.Since(t.lastProgress) >= progressInterval {
+		t.lastProgress = time.Now()
+		ToolContextFrom(t.ctx).Progress(float64(t.n), float64(t.total), fmt.Sprintf("%.1f of %.1f MiB %s", float64(t.n)/(1<<20), float64(t.total)/(1<<20), t.verb))
+	}
+	if t.limit > 0 {
+		due := t.start.Add(time.Duration(float64(t.n) / float64(t.limit) * float64(time.Second)))
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
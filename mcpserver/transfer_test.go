@@ -0,0 +1,583 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTransferRemotePath(t *testing.T) {
+	for _, tc := range []struct{ dir, rel, want string }{
+		{"", "", "."},
+		{"", "a/b.txt", "a/b.txt"},
+		{"", "../../etc/passwd", "etc/passwd"},
+		{"outbox", "report.csv", "outbox/report.csv"},
+		{"outbox", "../inbox/x", "outbox/inbox/x"},
+		{"outbox", "/etc/passwd", "outbox/etc/passwd"},
+		{"/srv/drop", "a/../../b", "/srv/drop/b"},
+		{"/srv/drop", "..", "/srv/drop"},
+	} {
+		got, err := TransferEndpoint{Dir: tc.dir}.remotePath(tc.rel)
+		if err != nil || got != tc.want {
+			t.Errorf("remotePath(%q) in %q = %q, %v; want %q", tc.rel, tc.dir, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"a\x00b", "a\r\nDELE b"} {
+		if _, err := (TransferEndpoint{}).remotePath(bad); err == nil {
+			t.Errorf("remotePath(%q) accepted", bad)
+		}
+	}
+}
+
+func TestParseListings(t *testing.T) {
+	mlsd := map[string]remoteEntry{
+		"type=file;size=42;modify=20240102150405;unix.mode=0644; report 1.csv": {Name: "report 1.csv", Size: 42, ModTime: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), Mode: "0644"},
+		"type=dir;modify=20240102150405.123; archive":                          {Name: "archive", Dir: true, ModTime: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+	}
+	for line, want := range mlsd {
+		if got, ok := parseMLSD(line); !ok || got != want {
+			t.Errorf("parseMLSD(%q) = %+v, %v", line, got, ok)
+		}
+	}
+	if _, ok := parseMLSD("type=cdir;modify=20240102150405; ."); ok {
+		t.Error("parseMLSD kept the current directory")
+	}
+	list := map[string]remoteEntry{
+		"-rw-r--r--    1 ftp      ftp          1024 Jan 02 15:04 data  file.txt": {Name: "data  file.txt", Size: 1024},
+		"drwxr-xr-x    2 ftp      ftp          4096 Mar  3  2023 old":            {Name: "old", Size: 4096, Dir: true},
+		"lrwxrwxrwx    1 ftp      ftp             7 Jan 02 15:04 latest -> old":  {Name: "latest", Size: 7},
+	}
+	for line, want := range list {
+		if got, ok := parseLIST(line); !ok || got != want {
+			t.Errorf("parseLIST(%q) = %+v, %v", line, got, ok)
+		}
+	}
+	if _, ok := parseLIST("total 12"); ok {
+		t.Error("parseLIST kept the total line")
+	}
+}
+
+// transferTestTree creates a server tree with a file outside the
+// endpoint's directory, "dir", and a workspace, returning both.
+func transferTestTree(t *testing.T) (remote, workspace string) {
+	remote, workspace = t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(remote, "dir", "sub"), 0o755)
+	os.WriteFile(filepath.Join(remote, "outside.txt"), []byte("secret"), 0o644)
+	os.WriteFile(filepath.Join(remote, "dir", "inside.txt"), []byte(strings.Repeat("inside\n", 10000)), 0o644)
+	os.WriteFile(filepath.Join(workspace, "upload.txt"), []byte("uploaded"), 0o644)
+	return remote, workspace
+}
+
+// testTransferTools runs the transfer tools against endpoint e, whose
+// Dir is "dir" of remote.
+func testTransferTools(t *testing.T, cfg *Config, remote, workspace string) {
+	t.Helper()
+	cfg.Workspace = WorkspaceConfig{Roots: []WorkspaceRoot{{Name: "ws", Path: workspace, Writable: true}}}
+	s, err := newConfiguredServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, p := range []string{"", "..", "../.."} {
+		res, err := s.transferListTool(ctx, transferListArgs{Path: p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range res.(map[string]interface{})["entries"].([]map[string]interface{}) {
+			names = append(names, fmt.Sprintf("%s %v", e["name"], e["dir"]))
+		}
+		if fmt.Sprint(names) != "[inside.txt false sub true]" {
+			t.Errorf("listing %q: %v", p, names)
+		}
+	}
+
+	res, err := s.transferDownloadTool(ctx, transferDownloadArgs{RemotePath: "inside.txt", Path: "got/inside.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(workspace, "got", "inside.txt")); string(got) != strings.Repeat("inside\n", 10000) {
+		t.Errorf("downloaded %d bytes: %v", len(got), res)
+	}
+	if _, err := s.transferDownloadTool(ctx, transferDownloadArgs{RemotePath: "inside.txt", Path: "got/inside.txt"}); err == nil {
+		t.Error("download replaced a file without overwrite")
+	}
+	// The file above the endpoint's directory stays out of reach.
+	for _, p := range []string{"../outside.txt", "/outside.txt", "sub/../../outside.txt"} {
+		if _, err := s.transferDownloadTool(ctx, transferDownloadArgs{RemotePath: p, Path: "escaped.txt"}); err == nil {
+			t.Errorf("downloaded %s", p)
+		}
+	}
+
+	if _, err := s.transferUploadTool(ctx, transferUploadArgs{Path: "upload.txt", RemotePath: "sub/up.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.transferUploadTool(ctx, transferUploadArgs{Path: "upload.txt", RemotePath: "../up.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(remote, "dir", "sub", "up.txt")); string(got) != "uploaded" {
+		t.Errorf("uploaded %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "up.txt")); err == nil {
+		t.Error("upload escaped the endpoint's directory")
+	}
+	if _, err := s.transferUploadTool(ctx, transferUploadArgs{Path: "upload.txt", RemotePath: "sub/up.txt"}); err == nil || !strings.Contains(err.Error(), "set overwrite") {
+		t.Errorf("upload over an existing file: %v", err)
+	}
+	if _, err := s.transferUploadTool(ctx, transferUploadArgs{Path: "upload.txt", RemotePath: "sub/up.txt", Overwrite: true}); err != nil {
+		t.Errorf("upload with overwrite: %v", err)
+	}
+}
+
+// fakeSFTPServer serves root over SFTP version 3 to user "alice" with
+// password "pw", returning its address and host key.
+func fakeSFTPServer(t *testing.T, root string) (string, ssh.PublicKey) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+			if c.User() == "alice" && string(pw) == "pw" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	cfg.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					ch, chReqs, err := nc.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range chReqs {
+							ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+							req.Reply(ok, nil)
+							if ok {
+								go func() {
+									serveSFTP(ch, root)
+									ch.Close()
+								}()
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), signer.PublicKey()
+}
+
+// serveSFTP answers the requests the client makes, with paths resolved
+// under root.
+func serveSFTP(rw io.ReadWriter, root string) {
+	resolve := func(p string) string { return filepath.Join(root, path.Clean("/"+p)) }
+	handles := map[string]interface{}{}
+	var next int
+	r := bufio.NewReader(rw)
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(head[:4])-1)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		req := &sftpReader{b: body}
+		var resp sftpWriter
+		typ := byte(sftpStatus)
+		status := func(code uint32) {
+			typ = sftpStatus
+			resp.uint32(code)
+			resp.string("")
+			resp.string("")
+		}
+		if head[4] == sftpInit {
+			typ = sftpVersion
+			resp.uint32(3)
+		} else {
+			resp.uint32(req.uint32()) // request ID
+		}
+		switch head[4] {
+		case sftpInit:
+		case sftpStat:
+			st, err := os.Stat(resolve(req.string()))
+			if err != nil {
+				status(2)
+				break
+			}
+			typ = sftpAttrs
+			writeSFTPAttrs(&resp, st)
+		case sftpOpendir:
+			entries, err := os.ReadDir(resolve(req.string()))
+			if err != nil {
+				status(2)
+				break
+			}
+			next++
+			h := strconv.Itoa(next)
+			handles[h] = entries
+			typ = sftpHandle
+			resp.string(h)
+		case sftpReaddir:
+			h := req.string()
+			entries, _ := handles[h].([]os.DirEntry)
+			if len(entries) == 0 {
+				status(1)
+				break
+			}
+			handles[h] = []os.DirEntry(nil)
+			typ = sftpName
+			resp.uint32(uint32(len(entries)))
+			for _, e := range entries {
+				st, _ := e.Info()
+				resp.string(e.Name())
+				resp.string(e.Name())
+				writeSFTPAttrs(&resp, st)
+			}
+		case sftpOpen:
+			p := req.string()
+			flags, mode := req.uint32(), os.O_RDONLY
+			if flags&sftpWriteFlag != 0 {
+				mode = os.O_WRONLY
+			}
+			if flags&sftpCreat != 0 {
+				mode |= os.O_CREATE
+			}
+			if flags&sftpTrunc != 0 {
+				mode |= os.O_TRUNC
+			}
+			if flags&sftpExcl != 0 {
+				mode |= os.O_EXCL
+			}
+			f, err := os.OpenFile(resolve(p), mode, 0o644)
+			if errors.Is(err, os.ErrNotExist) {
+				status(2)
+				break
+			} else if err != nil {
+				status(4)
+				break
+			}
+			next++
+			h := strconv.Itoa(next)
+			handles[h] = f
+			typ = sftpHandle
+			resp.string(h)
+		case sftpRead:
+			f, _ := handles[req.string()].(*os.File)
+			offset, n := req.uint64(), req.uint32()
+			buf := make([]byte, n)
+			m, _ := f.ReadAt(buf, int64(offset))
+			if m == 0 {
+				status(1)
+				break
+			}
+			typ = sftpData
+			resp.string(string(buf[:m]))
+		case sftpWrite:
+			f, _ := handles[req.string()].(*os.File)
+			offset := req.uint64()
+			if _, err := f.WriteAt([]byte(req.string()), int64(offset)); err != nil {
+				status(4)
+				break
+			}
+			status(0)
+		case sftpClose:
+			h := req.string()
+			if f, ok := handles[h].(*os.File); ok {
+				f.Close()
+			}
+			delete(handles, h)
+			status(0)
+		default:
+			status(8)
+		}
+		packet := binary.BigEndian.AppendUint32(nil, uint32(1+resp.Len()))
+		if _, err := rw.Write(append(append(packet, typ), resp.Bytes()...)); err != nil {
+			return
+		}
+	}
+}
+
+func writeSFTPAttrs(w *sftpWriter, st os.FileInfo) {
+	mode := uint32(st.Mode().Perm())
+	if st.IsDir() {
+		mode |= 0o040000
+	} else {
+		mode |= 0o100000
+	}
+	w.uint32(sftpAttrSize | sftpAttrPermissions | sftpAttrTimes)
+	w.uint64(uint64(st.Size()))
+	w.uint32(mode)
+	w.uint32(uint32(st.ModTime().Unix()))
+	w.uint32(uint32(st.ModTime().Unix()))
+}
+
+func TestSFTPTransfer(t *testing.T) {
+	remote, workspace := transferTestTree(t)
+	addr, hostKey := fakeSFTPServer(t, remote)
+	e := TransferEndpoint{Name: "legacy", Protocol: "sftp", Host: addr, Username: "alice", Password: "pw",
+		HostKeys: []string{ssh.FingerprintSHA256(hostKey)}, Dir: "dir", Writable: true}
+	testTransferTools(t, &Config{Transfer: TransferConfig{Endpoints: []TransferEndpoint{e}}}, remote, workspace)
+
+	// A server presenting another key is refused.
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(other)
+	e.HostKeys = []string{string(ssh.MarshalAuthorizedKey(otherKey))}
+	if _, err := dialSFTP(context.Background(), e, nil); err == nil || !strings.Contains(err.Error(), "is not pinned") {
+		t.Errorf("dial with the wrong host key: %v", err)
+	}
+}
+
+// testCertificate returns a certificate for 127.0.0.1 and a PEM file of
+// it to trust.
+func testCertificate(t *testing.T) (tls.Certificate, string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, file
+}
+
+// fakeFTPSServer serves root over explicit FTPS to user "alice" with
+// password "pw". Without mlsd it answers MLSD with 502, as servers
+// without it do.
+func fakeFTPSServer(t *testing.T, root string, cert tls.Certificate, mlsd bool) string {
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFTPS(conn, root, cfg, mlsd)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveFTPS(conn net.Conn, root string, cfg *tls.Config, mlsd bool) {
+	defer conn.Close()
+	resolve := func(p string) string { return filepath.Join(root, path.Clean("/"+p)) }
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+	// transfer accepts the data connection of a command.
+	transfer := func(f func(c *tls.Conn) error) {
+		if data == nil {
+			reply("425 Use EPSV first")
+			return
+		}
+		reply("150 Opening data connection")
+		raw, err := data.Accept()
+		data.Close()
+		data = nil
+		if err != nil {
+			return
+		}
+		c := tls.Server(raw, cfg)
+		err = f(c)
+		c.Close()
+		if err != nil {
+			reply("451 %v", err)
+			return
+		}
+		reply("226 Transfer complete")
+	}
+	loggedIn := false
+	reply("220 fake FTPS ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		if !loggedIn && cmd != "AUTH" && cmd != "USER" && cmd != "PASS" {
+			reply("530 Please login")
+			continue
+		}
+		switch cmd {
+		case "AUTH":
+			reply("234 Proceed")
+			tc := tls.Server(conn, cfg)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, r = tc, bufio.NewReader(tc)
+		case "USER":
+			if _, ok := conn.(*tls.Conn); !ok {
+				reply("530 TLS required")
+				continue
+			}
+			reply("331 Password")
+		case "PASS":
+			if loggedIn = arg == "pw"; !loggedIn {
+				reply("530 Login incorrect")
+				continue
+			}
+			reply("230 Logged in")
+		case "PBSZ", "PROT", "TYPE":
+			reply("200 OK")
+		case "EPSV":
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 %v", err)
+				continue
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "SIZE":
+			st, err := os.Stat(resolve(arg))
+			if err != nil || st.IsDir() {
+				reply("550 No such file")
+				continue
+			}
+			reply("213 %d", st.Size())
+		case "MLSD", "LIST":
+			if cmd == "MLSD" && !mlsd {
+				reply("502 Not implemented")
+				continue
+			}
+			entries, err := os.ReadDir(resolve(arg))
+			if err != nil {
+				reply("550 No such directory")
+				continue
+			}
+			transfer(func(c *tls.Conn) error {
+				for _, e := range entries {
+					st, _ := e.Info()
+					if cmd == "MLSD" {
+						kind := "file"
+						if e.IsDir() {
+							kind = "dir"
+						}
+						fmt.Fprintf(c, "type=%s;size=%d;modify=%s; %s\r\n", kind, st.Size(), st.ModTime().UTC().Format("20060102150405"), e.Name())
+					} else {
+						fmt.Fprintf(c, "%s 1 ftp ftp %d Jan 02 15:04 %s\r\n", st.Mode(), st.Size(), e.Name())
+					}
+				}
+				return nil
+			})
+		case "RETR":
+			f, err := os.Open(resolve(arg))
+			if err != nil {
+				reply("550 No such file")
+				continue
+			}
+			transfer(func(c *tls.Conn) error {
+				_, err := io.Copy(c, f)
+				f.Close()
+				return err
+			})
+		case "STOR":
+			f, err := os.Create(resolve(arg))
+			if err != nil {
+				reply("553 %v", err)
+				continue
+			}
+			transfer(func(c *tls.Conn) error {
+				_, err := io.Copy(f, c)
+				f.Close()
+				return err
+			})
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestFTPSTransfer(t *testing.T) {
+	cert, caFile := testCertificate(t)
+	for _, mlsd := range []bool{true, false} {
+		t.Run(fmt.Sprint("mlsd=", mlsd), func(t *testing.T) {
+			remote, workspace := transferTestTree(t)
+			addr := fakeFTPSServer(t, remote, cert, mlsd)
+			e := TransferEndpoint{Name: "legacy", Protocol: "ftps", Host: addr, Username: "alice", Password: "pw", Dir: "dir", Writable: true}
+			cfg := &Config{Transfer: TransferConfig{Endpoints: []TransferEndpoint{e}}, Egress: EgressConfig{TLS: EgressTLS{CAFiles: []string{caFile}}}}
+			testTransferTools(t, cfg, remote, workspace)
+		})
+	}
+
+	// A certificate nobody vouches for is refused.
+	remote, _ := transferTestTree(t)
+	e := TransferEndpoint{Name: "legacy", Protocol: "ftps", Host: fakeFTPSServer(t, remote, cert, true), Username: "alice", Password: "pw"}
+	if _, err := dialFTPS(context.Background(), e, nil, &tls.Config{}); err == nil {
+		t.Error("dialled a server with an untrusted certificate")
+	}
+	e.Password = "wrong"
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	if _, err := dialFTPS(context.Background(), e, nil, &tls.Config{RootCAs: pool}); err == nil || !strings.Contains(err.Error(), "530") {
+		t.Errorf("login with the wrong password: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RegisterTypedTool adds a tool implemented by fn to b. The input schema is
+// derived from TArgs, a struct, by its json, schema, jsonschema and
+// validate tags; call arguments are checked against it and decoded into a
+// TArgs before fn runs. A string result is
+// returned as text and any other result as its JSON encoding.
+func RegisterTypedTool[TArgs, TResult any](b *Builder, name, desc string, fn func(context.Context, TArgs) (TResult, error)) *Builder {
+	tool, handler, err := typedTool(name, desc, fn)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.WithTool(tool, handler)
+}
+
+// typedTool builds the definition and handler of a typed tool.
+func typedTool[TArgs, TResult any](name, desc string, fn func(context.Context, TArgs) (TResult, error)) (Tool, ToolHandler, error) {
+	schema := schemaFor(reflect.TypeOf((*TArgs)(nil)).Elem())
+	if schema["type"] != "object" {
+		return Tool{}, nil, fmt.Errorf("tool %q: arguments must be a struct", name)
+	}
+	tool := Tool{Name: name, Description: desc, InputSchema: schema}
+	handler := func(ctx context.Context, raw json.RawMessage) (interface{}, error) {
+		if len(raw) == 0 || string(raw) == "null" {
+			raw = json.RawMessage("{}")
+		}
+		if issues := validateJSON(raw, schema); hasErrors(issues) {
+			return nil, argumentError(issues)
+		}
+		var args TArgs
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		result, err := fn(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return typedResult(result)
+	}
+	return tool, handler, nil
+}
+
+// argumentError reports the errors among issues as invalid arguments.
+func argumentError(issues []ConfigIssue) error {
+	return fmt.Errorf("invalid arguments: %s", issueSummary(issues))
+}
+
+// issueSummary lists the errors among issues, without positions.
+func issueSummary(issues []ConfigIssue) string {
+	var msgs []string
+	for _, issue := range issues {
+		if issue.Warning {
+			continue
+		}
+		if issue.Path == "" {
+			msgs = append(msgs, issue.Message)
+		} else {
+			msgs = append(msgs, issue.Path+": "+issue.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func typedResult(v interface{}) (interface{}, error) {
+	if text, ok := v.(string); ok {
+		return textResult(text), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return textResult(string(data)), nil
+}
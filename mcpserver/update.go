@@ -0,0 +1,291 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// UpdateConfig points "mcp-server update" at a release feed. Binaries must
+// carry a minisign signature by PublicKey; anything else is refused. With
+// RestartCommand, the update restarts the server and rolls back if
+// HealthURL does not report the new version in time.
+type UpdateConfig struct {
+	Feed           string   `json:"feed,omitempty" schema:"format=uri"`
+	PublicKey      string   `json:"publicKey,omitempty"`
+	RestartCommand []string `json:"restartCommand,omitempty"`
+	HealthURL      string   `json:"healthURL,omitempty"` // default http://localhost:$PORT/health
+}
+
+// releaseFeed is the document at UpdateConfig.Feed. Binaries is keyed by
+// "<os>-<arch>"; URLs may be relative to the feed, and each binary's
+// signature is at its URL plus ".minisig".
+type releaseFeed struct {
+	Version  string                   `json:"version"`
+	Notes    string                   `json:"notes,omitempty"`
+	Binaries map[string]releaseBinary `json:"binaries"`
+}
+
+type releaseBinary struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+const (
+	maxUpdateBytes       = 512 << 20
+	updateHealthTimeout  = time.Minute
+	updateRequestTimeout = 5 * time.Minute
+)
+
+// runVersionCommand implements the "version" subcommand.
+func runVersionCommand(args []string) int {
+	fmt.Println(serverVersion)
+	return 0
+}
+
+// runUpdateCommand implements the "update" subcommand.
+func runUpdateCommand(args []string) int {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	check := fs.Bool("check", false, "only report whether an update is available")
+	force := fs.Bool("force", false, "install the feed's release even if it is not newer")
+	rollback := fs.Bool("rollback", false, "go back to the binary the last update replaced")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loadConfig(os.Getenv("MCP_CONFIG"), os.Getenv("MCP_ENV"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update: %v\n", err)
+		return 1
+	}
+	exe, err := executablePath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update: %v\n", err)
+		return 1
+	}
+	u := &updater{cfg: cfg.Update, exe: exe, client: &http.Client{Timeout: updateRequestTimeout}}
+	if *rollback {
+		err = u.rollback()
+	} else {
+		err = u.update(*check, *force)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// updater replaces exe, resolved before the swap: afterwards the running
+// process's own path names the old binary.
+type updater struct {
+	cfg    UpdateConfig
+	exe    string
+	client *http.Client
+}
+
+func (u *updater) update(check, force bool) error {
+	if u.cfg.Feed == "" || u.cfg.PublicKey == "" {
+		return errors.New("set update.feed and update.publicKey in the config")
+	}
+	key, err := parseMinisignKey(u.cfg.PublicKey)
+	if err != nil {
+		return err
+	}
+	feedURL, err := url.Parse(u.cfg.Feed)
+	if err != nil {
+		return err
+	}
+	var feed releaseFeed
+	data, err := u.fetch(feedURL.String())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return fmt.Errorf("release feed: %w", err)
+	}
+	if compareVersions(feed.Version, serverVersion) <= 0 && !force {
+		fmt.Printf("%s is up to date (feed has %s)\n", serverVersion, feed.Version)
+		return nil
+	}
+	platform := runtime.GOOS + "-" + runtime.GOARCH
+	bin, ok := feed.Binaries[platform]
+	if !ok {
+		return fmt.Errorf("release %s has no %s binary", feed.Version, platform)
+	}
+	fmt.Printf("update available: %s -> %s\n", serverVersion, feed.Version)
+	if feed.Notes != "" {
+		fmt.Println(feed.Notes)
+	}
+	if check {
+		return nil
+	}
+
+	binURL, err := feedURL.Parse(bin.URL)
+	if err != nil {
+		return err
+	}
+	exe := u.exe
+	data, err = u.fetch(binURL.String())
+	if err != nil {
+		return err
+	}
+	if bin.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), bin.SHA256) {
+			return errors.New("downloaded binary does not match its sha256")
+		}
+	}
+	sig, err := u.fetch(binURL.String() + ".minisig")
+	if err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+	comment, err := key.verify(data, sig)
+	if err != nil {
+		return fmt.Errorf("refusing %s: %w", binURL, err)
+	}
+	fmt.Printf("signature ok: %s\n", comment)
+
+	// Staged next to the executable, so the swap is a rename on one
+	// filesystem, and run once to prove it works here.
+	staged := exe + ".new"
+	if err := os.WriteFile(staged, data, 0o755); err != nil {
+		return err
+	}
+	defer os.Remove(staged)
+	out, err := exec.Command(staged, "version").Output()
+	if err != nil {
+		return fmt.Errorf("new binary does not run: %w", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != feed.Version {
+		return fmt.Errorf("new binary reports version %q, the feed %q", got, feed.Version)
+	}
+
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	fmt.Printf("installed %s as %s; the previous binary is %s\n", feed.Version, exe, old)
+	if len(u.cfg.RestartCommand) == 0 {
+		fmt.Println("restart the server to run it; mcp-server update -rollback undoes the update")
+		return nil
+	}
+	if err := u.restart(feed.Version); err != nil {
+		if rerr := u.rollback(); rerr != nil {
+			return fmt.Errorf("%v; rolling back failed too: %v", err, rerr)
+		}
+		return fmt.Errorf("%v; rolled back to %s", err, serverVersion)
+	}
+	fmt.Printf("server is healthy on %s\n", feed.Version)
+	return nil
+}
+
+// rollback puts back the binary the last update replaced, restarting the
+// server if a restart command is configured.
+func (u *updater) rollback() error {
+	exe := u.exe
+	old := exe + ".old"
+	if _, err := os.Stat(old); err != nil {
+		return fmt.Errorf("no previous binary at %s", old)
+	}
+	if err := os.Rename(old, exe); err != nil {
+		return err
+	}
+	out, _ := exec.Command(exe, "version").Output()
+	version := strings.TrimSpace(string(out))
+	fmt.Printf("restored %s %s\n", exe, version)
+	if len(u.cfg.RestartCommand) == 0 {
+		return nil
+	}
+	return u.restart(version)
+}
+
+// restart runs the restart command and waits for the health endpoint to
+// report version.
+func (u *updater) restart(version string) error {
+	cmd := exec.Command(u.cfg.RestartCommand[0], u.cfg.RestartCommand[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("restart command: %w", err)
+	}
+	health := u.cfg.HealthURL
+	if health == "" {
+		health = strings.TrimSuffix(defaultTarget(), "/mcp") + "/health"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), updateHealthTimeout)
+	defer cancel()
+	var last string
+	for {
+		var status struct {
+			Status  string `json:"status"`
+			Version string `json:"version"`
+		}
+		data, err := u.fetchContext(ctx, health)
+		switch {
+		case err != nil:
+			last = err.Error()
+		case json.Unmarshal(data, &status) != nil:
+			last = "health endpoint did not answer JSON"
+		case status.Version != version:
+			last = fmt.Sprintf("server still reports version %s", status.Version)
+		default:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health check failed: %s", last)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (u *updater) fetch(url string) ([]byte, error) {
+	return u.fetchContext(context.Background(), url)
+}
+
+func (u *updater) fetchContext(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "mcp-server-update/"+serverVersion)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxUpdateBytes+1))
+	if err == nil && len(data) > maxUpdateBytes {
+		err = fmt.Errorf("%s: larger than %d MiB", url, maxUpdateBytes>>20)
+	}
+	return data, err
+}
+
+// executablePath is the running binary, symlinks resolved.
+func executablePath() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
@@ -0,0 +1,292 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UsageConfig controls persistence of per-tool usage statistics. Without
+// Path, statistics live in memory only.
+type UsageConfig struct {
+	Path     string   `json:"path,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// latencySamples is how many recent latencies are kept per tool for
+// percentile estimates.
+const latencySamples = 1024
+
+const toolCallsMetric = "mcp_tool_calls_total"
+
+type toolUsage struct {
+	Calls     int64     `json:"calls"`
+	Errors    int64     `json:"errors"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Latencies []float64 `json:"latencies"` // milliseconds, ring buffer
+	Next      int       `json:"next"`
+}
+
+// ToolUsageStats summarizes a tool's usage.
+type ToolUsageStats struct {
+	Tool      string     `json:"tool"`
+	Calls     int64      `json:"calls"`
+	Errors    int64      `json:"errors"`
+	ErrorRate float64    `json:"errorRate"`
+	P50Ms     float64    `json:"p50Ms"`
+	P90Ms     float64    `json:"p90Ms"`
+	P99Ms     float64    `json:"p99Ms"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+}
+
+// recentErrorLimit is how many failed calls the tracker remembers.
+const recentErrorLimit = 50
+
+// ToolError is a failed tool call.
+type ToolError struct {
+	Time    time.Time `json:"time"`
+	Tool    string    `json:"tool"`
+	Client  string    `json:"client,omitempty"`
+	Key     string    `json:"key,omitempty"`
+	Message string    `json:"message"`
+}
+
+type usageTracker struct {
+	mu     sync.Mutex
+	tools  map[string]*toolUsage
+	recent []ToolError // oldest first, not persisted
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{tools: map[string]*toolUsage{}}
+}
+
+func (u *usageTracker) record(tool string, d time.Duration, failed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	t := u.tools[tool]
+	if t == nil {
+		t = &toolUsage{}
+		u.tools[tool] = t
+	}
+	t.Calls++
+	if failed {
+		t.Errors++
+	}
+	t.LastUsed = time.Now().UTC()
+	ms := float64(d) / float64(time.Millisecond)
+	if len(t.Latencies) < latencySamples {
+		t.Latencies = append(t.Latencies, ms)
+	} else {
+		t.Latencies[t.Next] = ms
+	}
+	t.Next = (t.Next + 1) % latencySamples
+}
+
+// stats summarizes the named tools, including ones never called, sorted
+// by name.
+func (u *usageTracker) stats(names []string) []ToolUsageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	sort.Strings(names)
+	out := make([]ToolUsageStats, 0, len(names))
+	for _, name := range names {
+		st := ToolUsageStats{Tool: name}
+		if t := u.tools[name]; t != nil && t.Calls > 0 {
+			st.Calls, st.Errors = t.Calls, t.Errors
+			st.ErrorRate = float64(t.Errors) / float64(t.Calls)
+			sorted := append([]float64(nil), t.Latencies...)
+			sort.Float64s(sorted)
+			st.P50Ms = percentile(sorted, 0.50)
+			st.P90Ms = percentile(sorted, 0.90)
+			st.P99Ms = percentile(sorted, 0.99)
+			last := t.LastUsed
+			st.LastUsed = &last
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+// recordError remembers a failed call, dropping the oldest beyond
+// recentErrorLimit.
+func (u *usageTracker) recordError(e ToolError) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.recent) == recentErrorLimit {
+		copy(u.recent, u.recent[1:])
+		u.recent = u.recent[:recentErrorLimit-1]
+	}
+	u.recent = append(u.recent, e)
+}
+
+// toolErrorMessage describes why a call failed: the handler's error, or
+// the text of a result flagged isError.
+func toolErrorMessage(err error, result interface{}) string {
+	if err != nil {
+		return truncateRunes(err.Error(), 500)
+	}
+	if text, ok := resultValue(decodeResult(result)).(string); ok {
+		return truncateRunes(text, 500)
+	}
+	b, _ := json.Marshal(result)
+	return truncateRunes(string(b), 500)
+}
+
+// recentErrors returns the remembered failures, newest first.
+func (u *usageTracker) recentErrors() []ToolError {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]ToolError, len(u.recent))
+	for i, e := range u.recent {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+// forgetErrors drops the remembered failures match selects and returns
+// how many.
+func (u *usageTracker) forgetErrors(match func(ToolError) bool) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	kept := u.recent[:0]
+	for _, e := range u.recent {
+		if !match(e) {
+			kept = append(kept, e)
+		}
+	}
+	n := len(u.recent) - len(kept)
+	u.recent = kept
+	return n
+}
+
+func (u *usageTracker) reset() {
+	u.mu.Lock()
+	u.tools = map[string]*toolUsage{}
+	u.recent = nil
+	u.mu.Unlock()
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (u *usageTracker) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tools := map[string]*toolUsage{}
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.tools = tools
+	u.mu.Unlock()
+	return nil
+}
+
+func (u *usageTracker) save(path string) error {
+	u.mu.Lock()
+	data, err := json.Marshal(u.tools)
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// persistLoop saves usage statistics periodically until ctx is cancelled.
+func (u *usageTracker) persistLoop(ctx context.Context, cfg UsageConfig) {
+	interval := time.Duration(cfg.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.save(cfg.Path); err != nil {
+				log.Printf("usage stats: save failed: %v", err)
+			}
+		}
+	}
+}
+
+// toolNames returns the names of all registered tools, sorted.
+func (s *MCPServer) toolNames() []string {
+	names := make([]string, 0, len(s.tools))
+	for name := range s.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usageStatsTool reports usage statistics, optionally for a single tool.
+func (s *MCPServer) usageStatsTool(ctx context.Context, args json.RawMessage) (interface{}, error) {
+	var params struct {
+		Tool string `json:"tool"`
+	}
+	json.Unmarshal(args, &params)
+	names := s.toolNames()
+	if params.Tool != "" {
+		names = []string{params.Tool}
+	}
+	out, err := json.MarshalIndent(s.usage.stats(names), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return textResult(string(out)), nil
+}
+
+// handleAdminUsage serves GET (statistics) and DELETE (reset) on
+// /admin/usage, and GET /admin/usage/errors, the most recent failed calls.
+func (s *MCPServer) handleAdminUsage(w http.ResponseWriter, r *http.Request, rest string) {
+	if rest == "errors" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"errors": s.usage.recentErrors()})
+		return
+	}
+	if rest != "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tools": s.usage.stats(s.toolNames())})
+	case http.MethodDelete:
+		s.usage.reset()
+		s.clients.reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
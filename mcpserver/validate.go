@@ -0,0 +1,176 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is one problem found in the arguments of a tools/validate
+// request. Severity is "error" or "warning".
+type Diagnostic struct {
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+// validateToolCall checks args against the input schema of tool and, when
+// they are valid and the tool can run without side effects, runs it as a
+// dry run. Nothing is recorded in usage or metrics.
+func (s *MCPServer) validateToolCall(ctx context.Context, tool string, args json.RawMessage) map[string]interface{} {
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage("{}")
+	}
+	diagnostics := []Diagnostic{}
+	valid := true
+	if schema := schemaMap(s.tools[tool].InputSchema); schema != nil {
+		for _, issue := range validateJSON(args, schema) {
+			d := Diagnostic{Path: issue.Path, Message: issue.Message, Severity: "error"}
+			if issue.Warning {
+				d.Severity = "warning"
+			} else {
+				valid = false
+			}
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	dry := map[string]interface{}{"ran": false}
+	switch {
+	case !valid:
+		dry["reason"] = "arguments are invalid"
+	case !s.dryRunnable(tool):
+		dry["reason"] = "the tool may have side effects and does not support dry runs"
+	default:
+		tctx := s.toolContext(ctx, tool)
+		ToolContextFrom(tctx).DryRun = true
+		result, err := s.handlers[tool](tctx, args)
+		if err != nil {
+			result = errorResult(err)
+		}
+		dry["ran"] = true
+		dry["result"] = sanitizeResult(result, s.cfg.Text)
+	}
+	return map[string]interface{}{
+		"tool":        tool,
+		"valid":       valid,
+		"diagnostics": diagnostics,
+		"dryRun":      dry,
+	}
+}
+
+// dryRunnable reports whether tool can be called with DryRun set: config
+// tools, whose backends preview instead of acting, and read-only tools.
+func (s *MCPServer) dryRunnable(tool string) bool {
+	for _, tc := range s.cfg.Tools {
+		if tc.Name == tool {
+			return true
+		}
+	}
+	return readOnlyTool(s.tools[tool])
+}
+
+// schemaMap converts an input schema to the generic form validateJSON
+// reads, with enum and required lists of strings. Objects that do not
+// restrict additionalProperties allow them, as JSON Schema does. It returns
+// nil when schema is not an object.
+func schemaMap(schema interface{}) map[string]interface{} {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(data, &m) != nil {
+		return nil
+	}
+	normalizeSchema(m)
+	return m
+}
+
+func normalizeSchema(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v["type"] == "object" && v["additionalProperties"] == nil {
+			v["additionalProperties"] = true
+		}
+		for key, child := range v {
+			if list, ok := child.([]interface{}); ok && (key == "enum" || key == "required") {
+				if strs, ok := stringList(list); ok {
+					v[key] = strs
+					continue
+				}
+			}
+			normalizeSchema(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			normalizeSchema(child)
+		}
+	}
+}
+
+func stringList(list []interface{}) ([]string, bool) {
+	out := make([]string, len(list))
+	for i, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+		out[i] = s
+	}
+	return out, true
+}
+
+// dryRunResult describes what a backend would have done.
+func dryRunResult(format string, args ...interface{}) (interface{}, error) {
+	return textResult("dry run: would " + fmt.Sprintf(format, args...)), nil
+}
+
+// headerNames lists the names of h; values may hold credentials.
+func headerNames(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// handleAdminTools serves POST /admin/tools/{name}/validate, which takes
+// the call arguments as its body and answers like tools/validate. The
+// optional key parameter validates as that API key would call; without
+// it the tool must be visible to an unauthenticated caller.
+func (s *MCPServer) handleAdminTools(w http.ResponseWriter, r *http.Request, rest string) {
+	tool, action, _ := strings.Cut(rest, "/")
+	if action != "validate" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := &Caller{}
+	if name := r.URL.Query().Get("key"); name != "" {
+		if caller.Key = s.lookupKey(name); caller.Key == nil {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+	}
+	tool = s.resolveTool(tool)
+	if _, ok := s.handlers[tool]; !ok || !s.toolVisible(tool, caller) {
+		http.Error(w, "Tool not found", http.StatusNotFound)
+		return
+	}
+	var args json.RawMessage
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&args); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.validateToolCall(r.Context(), tool, args))
+}
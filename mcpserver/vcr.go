@@ -0,0 +1,291 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+)
+
+// VCRConfig records the outbound HTTP requests tools make into cassettes,
+// one JSON file per tool in Dir, or answers them from the cassettes, so
+// tools can be tested offline and deterministically. Mode "record" sends
+// requests and rewrites the cassettes, "replay" only answers from them and
+// fails requests that were not recorded, and "auto" replays what it can and
+// records the rest. Values of the Redact headers, plus credentials and
+// cookies, are not written to cassettes.
+type VCRConfig struct {
+	Mode   string   `json:"mode,omitempty" schema:"enum=record|replay|auto"`
+	Dir    string   `json:"dir,omitempty"` // default "cassettes"
+	Redact []string `json:"redact,omitempty"`
+}
+
+// Recorder modes.
+const (
+	VCRRecord = "record"
+	VCRReplay = "replay"
+	VCRAuto   = "auto"
+)
+
+const defaultCassetteDir = "cassettes"
+
+var defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Interaction is a recorded request and its response. Bodies that are not
+// valid UTF-8 are stored base64-encoded.
+type Interaction struct {
+	Request struct {
+		Method  string      `json:"method"`
+		URL     string      `json:"url"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    string      `json:"body,omitempty"`
+		Base64  bool        `json:"base64,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status  int         `json:"status"`
+		Headers http.Header `json:"headers,omitempty"`
+		Body    string      `json:"body,omitempty"`
+		Base64  bool        `json:"base64,omitempty"`
+	} `json:"response"`
+}
+
+// cassette holds the interactions of one file. used marks interactions
+// already replayed, so identical requests get their responses in order.
+type cassette struct {
+	path         string
+	Interactions []Interaction `json:"interactions"`
+	used         []bool
+}
+
+// Recorder is an http.RoundTripper that records requests into cassettes
+// and replays them. Use it in tests of Go tools:
+//
+//	rec := mcpserver.NewRecorder("testdata/lookup.json", mcpserver.VCRAuto, nil)
+//	ctx := mcpserver.WithToolContext(ctx, &mcpserver.ToolContext{
+//		Services: mcpserver.Services{HTTPClient: rec.Client()},
+//	})
+type Recorder struct {
+	mode   string
+	base   http.RoundTripper
+	redact map[string]bool
+	file   func(tool string) string
+
+	mu        sync.Mutex
+	cassettes map[string]*cassette
+}
+
+// NewRecorder returns a Recorder keeping all interactions in the cassette
+// at path. A nil base sends requests with http.DefaultTransport.
+func NewRecorder(path, mode string, base http.RoundTripper) *Recorder {
+	return newRecorder(mode, base, nil, func(string) string { return path })
+}
+
+func newRecorder(mode string, base http.RoundTripper, redact []string, file func(string) string) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	r := &Recorder{mode: mode, base: base, redact: map[string]bool{}, file: file, cassettes: map[string]*cassette{}}
+	for _, h := range append(defaultRedactedHeaders, redact...) {
+		r.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	return r
+}
+
+var cassetteNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// newVCRClient returns the HTTP client of tool handlers under cfg, or nil
+// when recording is off.
+func newVCRClient(cfg VCRConfig, client *http.Client) (*http.Client, error) {
+	switch cfg.Mode {
+	case "":
+		return nil, nil
+	case VCRRecord, VCRReplay, VCRAuto:
+	default:
+		return nil, fmt.Errorf("unknown mode %q", cfg.Mode)
+	}
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultCassetteDir
+	}
+	rec := newRecorder(cfg.Mode, client.Transport, cfg.Redact, func(tool string) string {
+		if tool == "" {
+			tool = "default"
+		}
+		return filepath.Join(dir, cassetteNameUnsafe.ReplaceAllString(tool, "_")+".json")
+	})
+	vcr := *client
+	vcr.Transport = rec
+	return &vcr, nil
+}
+
+// Client returns an HTTP client using r.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip replays a recorded response to req or, depending on the mode,
+// sends req and records the response. The cassette is picked by the tool
+// of req's ToolContext.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	path := r.file(ToolContextFrom(req.Context()).Tool)
+
+	r.mu.Lock()
+	c, err := r.cassette(path)
+	var replayed *Interaction
+	if err == nil && r.mode != VCRRecord {
+		replayed = c.match(req.Method, req.URL.String(), body)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if replayed != nil {
+		return replayed.response(req)
+	}
+	if r.mode == VCRReplay {
+		return nil, fmt.Errorf("vcr: no interaction for %s %s recorded in %s", req.Method, req.URL, path)
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := r.base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var it Interaction
+	it.Request.Method, it.Request.URL = req.Method, req.URL.String()
+	it.Request.Headers = r.redacted(req.Header)
+	it.Request.Body, it.Request.Base64 = encodeBody(body)
+	it.Response.Status = resp.StatusCode
+	it.Response.Headers = r.redacted(resp.Header)
+	it.Response.Body, it.Response.Base64 = encodeBody(respBody)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.Interactions = append(c.Interactions, it)
+	c.used = append(c.used, true)
+	if err := c.save(); err != nil {
+		return nil, fmt.Errorf("vcr: %w", err)
+	}
+	return resp, nil
+}
+
+// cassette returns the cassette at path, loading it on first use. In
+// record mode it starts empty and replaces the file.
+func (r *Recorder) cassette(path string) (*cassette, error) {
+	if c, ok := r.cassettes[path]; ok {
+		return c, nil
+	}
+	c := &cassette{path: path}
+	if r.mode != VCRRecord {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, c); err != nil {
+				return nil, fmt.Errorf("vcr: %s: %w", path, err)
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, fmt.Errorf("vcr: %w", err)
+		}
+		c.used = make([]bool, len(c.Interactions))
+	}
+	r.cassettes[path] = c
+	return c, nil
+}
+
+// match returns the first unused interaction recorded for the request, or
+// the last used one once all are used up.
+func (c *cassette) match(method, url string, body []byte) *Interaction {
+	var last *Interaction
+	for i := range c.Interactions {
+		it := &c.Interactions[i]
+		if it.Request.Method != method || it.Request.URL != url || !bytes.Equal(decodeBody(it.Request.Body, it.Request.Base64), body) {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return it
+		}
+		last = it
+	}
+	return last
+}
+
+func (c *cassette) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, append(data, '\n'))
+}
+
+// response rebuilds the recorded response to req.
+func (it *Interaction) response(req *http.Request) (*http.Response, error) {
+	body := decodeBody(it.Response.Body, it.Response.Base64)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+		StatusCode:    it.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        it.Response.Headers.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// redacted copies h with the values of sensitive headers replaced.
+func (r *Recorder) redacted(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	out := h.Clone()
+	for name := range out {
+		if r.redact[name] {
+			out[name] = []string{"REDACTED"}
+		}
+	}
+	return out
+}
+
+func encodeBody(b []byte) (string, bool) {
+	if utf8.Valid(b) {
+		return string(b), false
+	}
+	return base64.StdEncoding.EncodeToString(b), true
+}
+
+func decodeBody(s string, encoded bool) []byte {
+	if !encoded {
+		return []byte(s)
+	}
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return b
+}
@@ -0,0 +1,189 @@
+package mcpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig posts server events to URL. Events are glob patterns over
+// event types, like "tool.*"; without them every event is sent. The body
+// is the event as JSON unless Template, a Go template over the event,
+// renders another one; Headers are templates too. With Secret, requests
+// carry an HMAC-SHA256 signature. Failed deliveries are retried Retries
+// times (default 3, negative for none) with exponential backoff.
+type WebhookConfig struct {
+	URL      string            `json:"url" schema:"required"`
+	Events   []string          `json:"events,omitempty"`
+	Secret   string            `json:"secret,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Retries  int               `json:"retries,omitempty"`
+	Timeout  Duration          `json:"timeout,omitempty" schema:"format=duration"` // default 10s
+}
+
+const (
+	webhookDeliveriesMetric = "mcp_webhook_deliveries_total"
+	defaultWebhookRetries   = 3
+	defaultWebhookTimeout   = 10 * time.Second
+
+	// maxWebhookDeliveries bounds deliveries in flight, retries included;
+	// events beyond it are dropped rather than queued.
+	maxWebhookDeliveries = 64
+)
+
+// webhookBackoff is the wait before the first retry; it doubles after
+// each one.
+var webhookBackoff = time.Second
+
+type webhook struct {
+	cfg      WebhookConfig
+	body     *template.Template
+	headers  map[string]*template.Template
+	retries  int
+	timeout  time.Duration
+	inFlight chan struct{}
+}
+
+// newWebhooks compiles the configured webhooks.
+func newWebhooks(cfgs []WebhookConfig) ([]*webhook, error) {
+	// Deliveries in flight are limited across all webhooks of a config.
+	inFlight := make(chan struct{}, maxWebhookDeliveries)
+	hooks := make([]*webhook, 0, len(cfgs))
+	for i, c := range cfgs {
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook %d: url is required", i)
+		}
+		h := &webhook{cfg: c, retries: c.Retries, timeout: time.Duration(c.Timeout), inFlight: inFlight}
+		if h.retries == 0 {
+			h.retries = defaultWebhookRetries
+		}
+		if h.timeout <= 0 {
+			h.timeout = defaultWebhookTimeout
+		}
+		var err error
+		if c.Template != "" {
+			if h.body, err = compileTemplate("webhook body", c.Template); err != nil {
+				return nil, fmt.Errorf("webhook %d: %w", i, err)
+			}
+		}
+		if h.headers, err = compileHeaders(c.Headers, nil); err != nil {
+			return nil, fmt.Errorf("webhook %d: %w", i, err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// deliverWebhooks sends ev to every webhook subscribed to its type, in the
+// background.
+func (s *MCPServer) deliverWebhooks(ev Event) {
+	eventType := ev.Type
+	for _, h := range s.webhooks {
+		if len(h.cfg.Events) > 0 && !matchAny(h.cfg.Events, eventType) {
+			continue
+		}
+		select {
+		case h.inFlight <- struct{}{}:
+		default:
+			s.metrics.inc(webhookDeliveriesMetric, "event", eventType, "outcome", "dropped")
+			log.Printf("webhook %s: too many deliveries in flight, dropping %s", h.cfg.URL, eventType)
+			continue
+		}
+		go func(h *webhook) {
+			defer func() { <-h.inFlight }()
+			outcome := "ok"
+			if err := h.deliver(s.httpClient(), ev); err != nil {
+				outcome = "failed"
+				log.Printf("webhook %s: %s: %v", h.cfg.URL, eventType, err)
+			}
+			s.metrics.inc(webhookDeliveriesMetric, "event", eventType, "outcome", outcome)
+		}(h)
+	}
+}
+
+// deliver posts ev, retrying network errors, 429 and 5xx responses.
+func (h *webhook) deliver(client *http.Client, ev Event) error {
+	body, err := h.render(ev)
+	if err != nil {
+		return err
+	}
+	wait := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(client, ev, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.retries {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (h *webhook) render(ev Event) ([]byte, error) {
+	if h.body == nil {
+		return json.Marshal(ev)
+	}
+	text, err := renderTemplate(h.body, ev)
+	if err != nil {
+		return nil, fmt.Errorf("rendering body: %w", err)
+	}
+	return []byte(text), nil
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (h *webhook) post(client *http.Client, ev Event, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mcp-server-webhook")
+	req.Header.Set("X-MCP-Event", ev.Type)
+	req.Header.Set("X-MCP-Delivery", ev.ID)
+	if err := applyHeaders(req, h.headers, ev); err != nil {
+		return false, fmt.Errorf("rendering headers: %w", err)
+	}
+	if h.cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-MCP-Timestamp", ts)
+		req.Header.Set("X-MCP-Signature", "sha256="+webhookSignature(h.cfg.Secret, ts, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("HTTP %s", resp.Status)
+	default:
+		return false, fmt.Errorf("HTTP %s", resp.Status)
+	}
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "timestamp.body". Signing the
+// timestamp lets receivers reject replayed deliveries.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
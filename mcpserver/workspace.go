@@ -0,0 +1,225 @@
+package mcpserver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WorkspaceConfig names the source trees the code tools work on. Tools
+// address files by root name and a slash-separated path within the root,
+// and never see anything outside the roots.
+type WorkspaceConfig struct {
+	Roots []WorkspaceRoot `json:"roots,omitempty"`
+	// Exclude lists glob patterns of files and directories to skip, matched
+	// against base names and root-relative paths. Defaults to
+	// defaultWorkspaceExcludes.
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxFileBytes skips larger files (default 1MiB).
+	MaxFileBytes int64 `json:"maxFileBytes,omitempty"`
+	// MaxFiles bounds the files indexed per root (default 50000).
+	MaxFiles int `json:"maxFiles,omitempty"`
+	// Ctags is a universal-ctags binary. When set, it indexes everything
+	// but Go, which is always parsed with go/parser; otherwise other
+	// languages are indexed with built-in patterns.
+	Ctags string `json:"ctags,omitempty"`
+	// Ripgrep is an rg binary for code_search, which otherwise searches
+	// with Go's regexp package.
+	Ripgrep string `json:"ripgrep,omitempty"`
+	// Govulncheck and OSVScanner are the binaries scan_dependencies runs;
+	// the tool is offered when either is set.
+	Govulncheck string `json:"govulncheck,omitempty"`
+	OSVScanner  string `json:"osvScanner,omitempty"`
+	// Projects are the build and test profiles of run_build and run_tests.
+	Projects []ProjectConfig `json:"projects,omitempty"`
+	// Formatters and Linters back format_code and lint_code. Each
+	// language uses the first formatter and every linter listing it. Both
+	// default to the built-in gofmt.
+	Formatters []LanguageToolConfig `json:"formatters,omitempty"`
+	Linters    []LanguageToolConfig `json:"linters,omitempty"`
+}
+
+// WorkspaceRoot is one source tree. Only writable roots can be changed
+// by apply_patch.
+type WorkspaceRoot struct {
+	Name     string `json:"name" schema:"required"`
+	Path     string `json:"path" schema:"required"`
+	Writable bool   `json:"writable,omitempty"`
+}
+
+var defaultWorkspaceExcludes = []string{
+	".git", ".hg", ".svn", "node_modules", "vendor", "dist", "build", "target",
+	"__pycache__", ".venv", ".idea", ".vscode", "*.min.js", "*.map",
+}
+
+const (
+	defaultWorkspaceMaxFileBytes = 1 << 20
+	defaultWorkspaceMaxFiles     = 50000
+)
+
+func (c WorkspaceConfig) enabled() bool {
+	return len(c.Roots) > 0
+}
+
+func (c WorkspaceConfig) writable() bool {
+	for _, r := range c.Roots {
+		if r.Writable {
+			return true
+		}
+	}
+	return false
+}
+
+func (c WorkspaceConfig) check() error {
+	seen := map[string]bool{}
+	for _, r := range c.Roots {
+		if r.Name == "" || strings.ContainsAny(r.Name, "/\\") {
+			return fmt.Errorf("root %q: names must be non-empty and contain no slashes", r.Name)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("duplicate root %q", r.Name)
+		}
+		seen[r.Name] = true
+		if st, err := os.Stat(r.Path); err != nil {
+			return fmt.Errorf("root %q: %w", r.Name, err)
+		} else if !st.IsDir() {
+			return fmt.Errorf("root %q: %s is not a directory", r.Name, r.Path)
+		}
+	}
+	for _, pattern := range c.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("exclude %q: %w", pattern, err)
+		}
+	}
+	if err := checkLanguageTools("formatters", c.Formatters, formatterPresets); err != nil {
+		return err
+	}
+	if err := checkLanguageTools("linters", c.Linters, linterPresets); err != nil {
+		return err
+	}
+	return c.checkProjects()
+}
+
+// root returns the root called name; "" selects the only root.
+func (c WorkspaceConfig) root(name string) (WorkspaceRoot, error) {
+	if name == "" && len(c.Roots) == 1 {
+		return c.Roots[0], nil
+	}
+	for _, r := range c.Roots {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	if name == "" {
+		return WorkspaceRoot{}, errors.New("several workspace roots are configured; name one")
+	}
+	return WorkspaceRoot{}, fmt.Errorf("no workspace root %q", name)
+}
+
+// selectRoots returns the root called name, or every root for "".
+func (c WorkspaceConfig) selectRoots(name string) ([]WorkspaceRoot, error) {
+	if name == "" {
+		return c.Roots, nil
+	}
+	r, err := c.root(name)
+	return []WorkspaceRoot{r}, err
+}
+
+// excluded reports whether the root-relative path rel is skipped.
+func (c WorkspaceConfig) excluded(rel string) bool {
+	patterns := c.Exclude
+	if patterns == nil {
+		patterns = defaultWorkspaceExcludes
+	}
+	base := path.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve maps a root-relative slash path to a file system path, refusing
+// paths that leave the root, including through symlinks.
+func (r WorkspaceRoot) resolve(rel string) (string, error) {
+	clean := path.Clean("/" + filepath.ToSlash(rel))
+	full := filepath.Join(r.Path, filepath.FromSlash(clean))
+	real, err := filepath.EvalSymlinks(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Not there yet: check the deepest directory that is. What is
+		// there on the way is a dangling symlink, which creating the file
+		// would follow wherever it points.
+		dir := full
+		for {
+			if _, err := os.Lstat(dir); err == nil {
+				return "", fmt.Errorf("%s leads through a dangling symlink", rel)
+			}
+			dir = filepath.Dir(dir)
+			if real, err = filepath.EvalSymlinks(dir); !errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	base, err := filepath.EvalSymlinks(r.Path)
+	if err != nil {
+		return "", err
+	}
+	if real != base && !strings.HasPrefix(real, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside workspace root %q", rel, r.Name)
+	}
+	return full, nil
+}
+
+// walk calls fn for every regular file of r that is not excluded or too
+// large, with its root-relative slash path, in lexical order. Symlinks are
+// not followed. It stops with an error after MaxFiles files.
+func (c WorkspaceConfig) walk(r WorkspaceRoot, fn func(rel, full string) error) error {
+	maxBytes, maxFiles := c.MaxFileBytes, c.MaxFiles
+	if maxBytes <= 0 {
+		maxBytes = defaultWorkspaceMaxFileBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultWorkspaceMaxFiles
+	}
+	n := 0
+	return filepath.WalkDir(r.Path, func(full string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if full == r.Path {
+				return err
+			}
+			return nil // unreadable entries are skipped
+		}
+		rel, _ := filepath.Rel(r.Path, full)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if c.excluded(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxBytes {
+			return nil
+		}
+		if n++; n > maxFiles {
+			return fmt.Errorf("root %q has more than %d files; raise workspace.maxFiles or exclude some", r.Name, maxFiles)
+		}
+		return fn(rel, full)
+	})
+}
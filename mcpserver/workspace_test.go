@@ -0,0 +1,47 @@
+package mcpserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceResolveSymlinks(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	for _, link := range []struct{ name, target string }{
+		{"escape", outside},
+		{"dangling-out.csv", filepath.Join(outside, "missing.csv")},
+		{"dangling-dir", filepath.Join(outside, "missing")},
+		{"dangling-in.csv", filepath.Join(root, "missing.csv")},
+		{"inside", filepath.Join(root, "sub")},
+	} {
+		if err := os.Symlink(link.target, filepath.Join(root, link.name)); err != nil {
+			t.Skip(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := WorkspaceRoot{Name: "app", Path: root, Writable: true}
+	for rel, want := range map[string]string{
+		"new.csv":              "",
+		"sub/new/deeper.csv":   "",
+		"inside/new.csv":       "",
+		"../up.csv":            "", // cleaned to the root
+		"escape/new.csv":       "outside workspace root",
+		"dangling-out.csv":     "dangling symlink",
+		"dangling-dir/new.csv": "dangling symlink",
+		"dangling-in.csv":      "dangling symlink",
+	} {
+		full, err := r.resolve(rel)
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("%s: %v", rel, err)
+		case want == "" && !strings.HasPrefix(full, root):
+			t.Errorf("%s resolved to %s", rel, full)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("%s: err = %v, want %s", rel, err, want)
+		}
+	}
+}
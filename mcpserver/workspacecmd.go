@@ -0,0 +1,58 @@
+package mcpserver
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// workspaceCommands starts the programs the workspace tools run:
+// run_build and run_tests, formatters and linters, ctags, ripgrep and the
+// dependency scanners. Like exec backends they run as the runAs user and
+// through the hardening helper when it is enabled, and they see only a
+// few variables of the server's environment, so admin tokens, store keys
+// and other secrets do not reach them.
+type workspaceCommands struct {
+	hardening HardeningConfig
+	runAs     *runAsUser
+}
+
+// workspaceEnv lists the server's environment variables that workspace
+// commands keep.
+var workspaceEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "LC_CTYPE", "TZ", "TMPDIR"}
+
+// command returns name with args, run in dir. Under hardening it may read
+// below root, and write there too when writable; an empty root adds
+// nothing to the hardening paths. env is added to the minimal environment.
+func (w *workspaceCommands) command(ctx context.Context, root string, writable bool, dir, name string, args []string, env ...string) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	if w.hardening.Enabled {
+		var read, write []string
+		if writable {
+			write = []string{root}
+		} else {
+			read = []string{root}
+		}
+		var err error
+		if cmd, err = w.hardening.command(ctx, read, write, name, args); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, name, args...)
+	}
+	cmd.Dir = dir
+	for _, kv := range os.Environ() {
+		for _, k := range workspaceEnv {
+			if strings.HasPrefix(kv, k+"=") {
+				cmd.Env = append(cmd.Env, kv)
+			}
+		}
+	}
+	// The identity replaces the server's, and env wins over both.
+	cmd.Env = append(append(append(cmd.Env, w.runAs.env()...), traceEnv(ctx)...), env...)
+	if err := w.runAs.apply(cmd, ""); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
@@ -0,0 +1,33 @@
+package mcpserver
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceCommandEnv(t *testing.T) {
+	if _, err := exec.LookPath("env"); err != nil {
+		t.Skip("no env binary")
+	}
+	t.Setenv("MCP_ADMIN_TOKEN", "secret")
+	t.Setenv("LANG", "C.UTF-8")
+	cmd, err := (&workspaceCommands{}).command(context.Background(), "", false, t.TempDir(), "env", nil, "GOFLAGS=-mod=mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(out)
+	if strings.Contains(env, "MCP_ADMIN_TOKEN") {
+		t.Errorf("server secret passed on:\n%s", env)
+	}
+	for _, want := range []string{"PATH=", "LANG=C.UTF-8", "GOFLAGS=-mod=mod"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("missing %s in:\n%s", want, env)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package mcpserver
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Office Open XML workbooks are zip files of XML parts. Only cell values
+// are read and written: formulas give their cached results, dates their
+// ISO form, and formatting beyond a bold header row is not kept.
+
+const (
+	xlsxMainNS   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNS    = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPkgRelNS = "http://schemas.openxmlformats.org/package/2006/relationships"
+	// maxXLSXPart bounds a part's uncompressed size, whatever its zip
+	// header claims.
+	maxXLSXPart = 256 << 20
+)
+
+type xlsxRels struct {
+	Rels []struct {
+		ID     string `xml:"Id,attr"`
+		Type   string `xml:"Type,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	T    *string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t *xlsxText) String() string {
+	if t == nil {
+		return ""
+	}
+	var b strings.Builder
+	if t.T != nil {
+		b.WriteString(*t.T)
+	}
+	for _, r := range t.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSheetRef struct {
+	name string
+	part string
+}
+
+// xlsxWorkbook reads the values of a workbook.
+type xlsxWorkbook struct {
+	zr         *zip.ReadCloser
+	files      map[string]*zip.File
+	sheets     []xlsxSheetRef
+	strings    []string
+	dateStyles map[int]bool
+	date1904   bool
+}
+
+func openXLSX(name string) (*xlsxWorkbook, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("not an xlsx workbook: %w", err)
+	}
+	wb := &xlsxWorkbook{zr: zr, files: map[string]*zip.File{}, dateStyles: map[int]bool{}}
+	for _, f := range zr.File {
+		wb.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+	if err := wb.load(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return wb, nil
+}
+
+func (wb *xlsxWorkbook) Close() error { return wb.zr.Close() }
+
+func (wb *xlsxWorkbook) decodePart(name string, v interface{}) error {
+	f, ok := wb.files[name]
+	if !ok {
+		return fmt.Errorf("workbook part %s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(&cappedReader{r: rc, n: maxXLSXPart}).Decode(v)
+}
+
+// relTarget resolves a relationship target against the part it is from.
+func relTarget(from, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	return path.Join(path.Dir(from), target)
+}
+
+func (wb *xlsxWorkbook) load() error {
+	var root xlsxRels
+	if err := wb.decodePart("_rels/.rels", &root); err != nil {
+		return err
+	}
+	book := ""
+	for _, r := range root.Rels {
+		if strings.HasSuffix(r.Type, "/officeDocument") {
+			book = relTarget("", r.Target)
+		}
+	}
+	if book == "" {
+		return errors.New("workbook part not found")
+	}
+	var wbXML struct {
+		Pr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := wb.decodePart(book, &wbXML); err != nil {
+		return err
+	}
+	wb.date1904 = wbXML.Pr.Date1904 == "1" || wbXML.Pr.Date1904 == "true"
+	var rels xlsxRels
+	if err := wb.decodePart(path.Join(path.Dir(book), "_rels", path.Base(book)+".rels"), &rels); err != nil {
+		return err
+	}
+	targets := map[string]string{}
+	for _, r := range rels.Rels {
+		target := relTarget(book, r.Target)
+		targets[r.ID] = target
+		switch {
+		case strings.HasSuffix(r.Type, "/sharedStrings"):
+			var sst struct {
+				Items []xlsxText `xml:"si"`
+			}
+			if err := wb.decodePart(target, &sst); err != nil {
+				return err
+			}
+			wb.strings = make([]string, len(sst.Items))
+			for i := range sst.Items {
+				wb.strings[i] = sst.Items[i].String()
+			}
+		case strings.HasSuffix(r.Type, "/styles"):
+			if err := wb.loadStyles(target); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range wbXML.Sheets {
+		if part, ok := targets[s.RID]; ok {
+			wb.sheets = append(wb.sheets, xlsxSheetRef{name: s.Name, part: part})
+		}
+	}
+	return nil
+}
+
+// loadStyles notes which cell styles format numbers as dates or times.
+func (wb *xlsxWorkbook) loadStyles(part string) error {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := wb.decodePart(part, &styles); err != nil {
+		return err
+	}
+	custom := map[int]string{}
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	for i, xf := range styles.Xfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			wb.dateStyles[i] = isDateFormat(code)
+			continue
+		}
+		id := xf.NumFmtID
+		wb.dateStyles[i] = (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+	}
+	return nil
+}
+
+// isDateFormat reports whether a number format shows a date or time:
+// whether it has y, m, d, h or s outside quotes, brackets and escapes.
+func isDateFormat(code string) bool {
+	quoted, bracket := false, false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			bracket = true
+		case c == ']':
+			bracket = false
+		case bracket:
+		case c == '\\' || c == '_' || c == '*':
+			i++
+		case strings.IndexByte("ymdhsYMDHS", c) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func (wb *xlsxWorkbook) sheetNames() []string {
+	names := make([]string, len(wb.sheets))
+	for i, s := range wb.sheets {
+		names[i] = s.name
+	}
+	return names
+}
+
+type xlsxCell struct {
+	Ref   string    `xml:"r,attr"`
+	Type  string    `xml:"t,attr"`
+	Style int       `xml:"s,attr"`
+	V     *string   `xml:"v"`
+	IS    *xlsxText `xml:"is"`
+}
+
+// scanSheet calls fn with the number and cells of each row of a sheet in
+// order; cells[0] is column A. Missing rows are skipped, and fn returning
+// errStopScan ends the scan without an error.
+func (wb *xlsxWorkbook) scanSheet(part string, fn func(row int, cells []interface{}) error) error {
+	f, ok := wb.files[part]
+	if !ok {
+		return fmt.Errorf("worksheet part %s is missing", part)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	dec := xml.NewDecoder(bufio.NewReader(&cappedReader{r: rc, n: maxXLSXPart}))
+	row, col := 0, 0
+	var cells []interface{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row++
+				for _, a := range t.Attr {
+					if a.Name.Local == "r" {
+						if n, err := strconv.Atoi(a.Value); err == nil {
+							row = n
+						}
+					}
+				}
+				col, cells = 0, cells[:0]
+			case "c":
+				var c xlsxCell
+				if err := dec.DecodeElement(&c, &t); err != nil {
+					return err
+				}
+				col++
+				if c.Ref != "" {
+					if _, n, ok := parseCellRef(c.Ref); ok && n > 0 {
+						col = n
+					}
+				}
+				if col > maxSheetColumns {
+					return fmt.Errorf("row %d has more than %d columns", row, maxSheetColumns)
+				}
+				for len(cells) < col {
+					cells = append(cells, nil)
+				}
+				cells[col-1] = wb.cellValue(c)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				if err := fn(row, cells); err == errStopScan {
+					return nil
+				} else if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (wb *xlsxWorkbook) cellValue(c xlsxCell) interface{} {
+	v := ""
+	if c.V != nil {
+		v = *c.V
+	}
+	switch c.Type {
+	case "s":
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 && i < len(wb.strings) {
+			return wb.strings[i]
+		}
+		return nil
+	case "inlineStr":
+		return c.IS.String()
+	case "str", "e", "d":
+		return v
+	case "b":
+		return v == "1" || v == "true"
+	}
+	if c.V == nil {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return v
+	}
+	if wb.dateStyles[c.Style] {
+		return excelDate(f, wb.date1904)
+	}
+	return json.Number(v)
+}
+
+// excelDate formats a serial date, days since 1899-12-30 (or 1904-01-01),
+// as a date, a time, or both.
+func excelDate(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	ms := math.Round((serial - days) * 86400000)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(ms) * time.Millisecond)
+	switch {
+	case ms == 0:
+		return t.Format("2006-01-02")
+	case days == 0:
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02T15:04:05")
+}
+
+var errStopScan = errors.New("stop scan")
+
+// cappedReader fails reads past n bytes.
+type cappedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, fmt.Errorf("workbook part is larger than %d bytes", maxXLSXPart)
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+// columnName returns the letters of the 1-based column n.
+func columnName(n int) string {
+	var b []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		b = append([]byte{byte('A' + (n-1)%26)}, b...)
+	}
+	return string(b)
+}
+
+// parseCellRef splits a reference such as B12, B or 12 into its row and
+// column, 0 where absent.
+func parseCellRef(ref string) (row, col int, ok bool) {
+	i := 0
+	for i < len(ref) && i < 3 {
+		c := ref[i] | 0x20
+		if c < 'a' || c > 'z' {
+			break
+		}
+		col = col*26 + int(c-'a') + 1
+		i++
+	}
+	if i < len(ref) {
+		n, err := strconv.Atoi(ref[i:])
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		row = n
+	}
+	return row, col, ref != "" && col <= maxSheetColumns
+}
+
+// xlsxSheetData is a sheet to write; rows[0] is row 1.
+type xlsxSheetData struct {
+	name string
+	rows [][]interface{}
+	// boldFirst makes the first row bold, as a header.
+	boldFirst bool
+}
+
+// validSheetName follows Excel's rules for sheet names.
+func validSheetName(name string) error {
+	if name == "" || len([]rune(name)) > 31 || strings.ContainsAny(name, `[]:*?/\`) || strings.HasPrefix(name, "'") || strings.HasSuffix(name, "'") {
+		return fmt.Errorf("sheet name %q: names have 1 to 31 characters, none of []:*?/\\, and no quote at either end", name)
+	}
+	return nil
+}
+
+// writeXLSX writes a workbook holding sheets.
+func writeXLSX(w io.Writer, sheets []xlsxSheetData) error {
+	zw := zip.NewWriter(w)
+	part := func(name, body string) error {
+		pw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(pw, xml.Header+body)
+		return err
+	}
+	var types, book, rels strings.Builder
+	for i, s := range sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&book, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlText(s.name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, n, xlsxRelNS, n)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/>`, len(sheets)+1, xlsxRelNS)
+	err := part("[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`+
+		types.String()+`</Types>`)
+	if err == nil {
+		err = part("_rels/.rels", `<Relationships xmlns="`+xlsxPkgRelNS+`"><Relationship Id="rId1" Type="`+xlsxRelNS+`/officeDocument" Target="xl/workbook.xml"/></Relationships>`)
+	}
+	if err == nil {
+		err = part("xl/workbook.xml", `<workbook xmlns="`+xlsxMainNS+`" xmlns:r="`+xlsxRelNS+`"><sheets>`+book.String()+`</sheets></workbook>`)
+	}
+	if err == nil {
+		err = part("xl/_rels/workbook.xml.rels", `<Relationships xmlns="`+xlsxPkgRelNS+`">`+rels.String()+`</Relationships>`)
+	}
+	if err == nil {
+		// Style 1 is the bold header.
+		err = part("xl/styles.xml", `<styleSheet xmlns="`+xlsxMainNS+`">`+
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`+
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>`+
+			`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`+
+			`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`+
+			`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>`+
+			`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles></styleSheet>`)
+	}
+	for i, s := range sheets {
+		if err != nil {
+			break
+		}
+		var pw io.Writer
+		if pw, err = zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)); err == nil {
+			err = writeXLSXSheet(pw, s)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeXLSXSheet(w io.Writer, s xlsxSheetData) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header + `<worksheet xmlns="` + xlsxMainNS + `">`)
+	if s.boldFirst && len(s.rows) > 1 {
+		bw.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	bw.WriteString(`<sheetData>`)
+	for r, row := range s.rows {
+		fmt.Fprintf(bw, `<row r="%d">`, r+1)
+		style := ""
+		if r == 0 && s.boldFirst {
+			style = ` s="1"`
+		}
+		for c, v := range row {
+			ref := columnName(c+1) + strconv.Itoa(r+1)
+			switch v := v.(type) {
+			case nil:
+			case bool:
+				b := 0
+				if v {
+					b = 1
+				}
+				fmt.Fprintf(bw, `<c r="%s" t="b"%s><v>%d</v></c>`, ref, style, b)
+			case float64:
+				if math.IsInf(v, 0) || math.IsNaN(v) {
+					return fmt.Errorf("cell %s: %v cannot be stored", ref, v)
+				}
+				fmt.Fprintf(bw, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'g', -1, 64))
+			case json.Number:
+				fmt.Fprintf(bw, `<c r="%s"%s><v>%s</v></c>`, ref, style, v)
+			default:
+				text := fmt.Sprint(v)
+				if _, ok := v.(string); !ok {
+					b, _ := json.Marshal(v)
+					text = string(b)
+				}
+				if len([]rune(text)) > 32767 {
+					return fmt.Errorf("cell %s: text longer than 32767 characters", ref)
+				}
+				fmt.Fprintf(bw, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlText(text))
+			}
+		}
+		bw.WriteString(`</row>`)
+	}
+	bw.WriteString(`</sheetData></worksheet>`)
+	return bw.Flush()
+}
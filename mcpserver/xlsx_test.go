@@ -0,0 +1,263 @@
+package mcpserver
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestXLSX zips parts into a workbook file, as a spreadsheet
+// application would lay them out.
+func writeTestXLSX(t *testing.T, parts map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "book.xlsx")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for part, body := range parts {
+		w, err := zw.Create(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\r\n" + body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// xlsxTestParts is a two-sheet workbook using shared strings, rich text,
+// inline strings, built-in and custom date formats and sparse rows.
+func xlsxTestParts(date1904 string) map[string]string {
+	return map[string]string{
+		"_rels/.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/extended-properties" Target="docProps/app.xml"/>` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`,
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<workbookPr` + date1904 + `/><sheets>` +
+			`<sheet name="Data" sheetId="1" r:id="rId1"/><sheet name="Q&amp;A" sheetId="2" r:id="rId2"/>` +
+			`</sheets></workbook>`,
+		// Targets may be relative to xl/ or absolute.
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>` +
+			`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`<Relationship Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings" Target="sharedStrings.xml"/>` +
+			`</Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="4" uniqueCount="4">` +
+			`<si><t>Name</t></si>` +
+			`<si><t>When</t></si>` +
+			`<si><r><rPr><b/></rPr><t>Rich</t></r><r><t xml:space="preserve"> text</t></r><rPh sb="0" eb="1"><t>ignored</t></rPh></si>` +
+			`<si><t xml:space="preserve">  spaced  </t></si>` +
+			`</sst>`,
+		// Styles: 0 general, 1 built-in date (14), 2 custom date-time,
+		// 3 custom number with a quoted d, 4 built-in time (21),
+		// 5 custom elapsed hours.
+		"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<numFmts count="3"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd\ hh:mm"/>` +
+			`<numFmt numFmtId="165" formatCode="0.00&quot;d&quot;"/><numFmt numFmtId="166" formatCode="[h]:mm"/></numFmts>` +
+			`<cellXfs count="6"><xf numFmtId="0"/><xf numFmtId="14" applyNumberFormat="1"/><xf numFmtId="164" applyNumberFormat="1"/>` +
+			`<xf numFmtId="165" applyNumberFormat="1"/><xf numFmtId="21" applyNumberFormat="1"/><xf numFmtId="166" applyNumberFormat="1"/></cellXfs>` +
+			`</styleSheet>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="1" spans="1:3"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>` +
+			`<row r="2"><c r="A2" t="inlineStr"><is><t>inline</t></is></c><c r="B2" s="1"><v>45292</v></c><c r="C2" s="2"><v>45292.5</v></c></row>` +
+			// Rows 3 and 4 are missing; row 5 only has column D.
+			`<row r="5"><c r="D5" s="4"><v>0.75</v></c></row>` +
+			// Cells without references follow on from the last one.
+			`<row><c r="B6" s="3"><v>3.5</v></c><c><f>B6*2</f><v>7</v></c><c t="b"><v>1</v></c></row>` +
+			`<row r="7"><c r="A7" t="e"><v>#DIV/0!</v></c><c r="B7" t="str"><f>A1&amp;"!"</f><v>Name!</v></c>` +
+			`<c r="C7" s="5"><v>1.5</v></c><c r="D7" t="s"><v>3</v></c><c r="E7" t="s"><v>99</v></c><c r="F7" s="1"/></row>` +
+			`</sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="2"><c r="B2" s="1"><v>0</v></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+}
+
+type xlsxTestRow struct {
+	row   int
+	cells []interface{}
+}
+
+func scanTestSheet(t *testing.T, wb *xlsxWorkbook, sheet int) []xlsxTestRow {
+	t.Helper()
+	var rows []xlsxTestRow
+	err := wb.scanSheet(wb.sheets[sheet].part, func(row int, cells []interface{}) error {
+		rows = append(rows, xlsxTestRow{row, append([]interface{}(nil), cells...)})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestXLSXRead(t *testing.T) {
+	wb, err := openXLSX(writeTestXLSX(t, xlsxTestParts("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	if got := wb.sheetNames(); !reflect.DeepEqual(got, []string{"Data", "Q&A"}) {
+		t.Errorf("sheets %q", got)
+	}
+	want := []xlsxTestRow{
+		{1, []interface{}{"Name", "When", "Rich text"}},
+		{2, []interface{}{"inline", "2024-01-01", "2024-01-01T12:00:00"}},
+		{5, []interface{}{nil, nil, nil, "18:00:00"}},
+		{6, []interface{}{nil, json.Number("3.5"), json.Number("7"), true}},
+		{7, []interface{}{"#DIV/0!", "Name!", "1899-12-31T12:00:00", "  spaced  ", nil, nil}},
+	}
+	if got := scanTestSheet(t, wb, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("sheet 1\n got %v\nwant %v", got, want)
+	}
+	if got := scanTestSheet(t, wb, 1); !reflect.DeepEqual(got, []xlsxTestRow{{2, []interface{}{nil, "1899-12-30"}}}) {
+		t.Errorf("sheet 2: %v", got)
+	}
+
+	var seen []int
+	err = wb.scanSheet(wb.sheets[0].part, func(row int, cells []interface{}) error {
+		seen = append(seen, row)
+		if row == 2 {
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(seen, []int{1, 2}) {
+		t.Errorf("stopped scan saw rows %v, %v", seen, err)
+	}
+}
+
+func TestXLSXDate1904(t *testing.T) {
+	wb, err := openXLSX(writeTestXLSX(t, xlsxTestParts(` date1904="1"`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	if got := scanTestSheet(t, wb, 1); !reflect.DeepEqual(got, []xlsxTestRow{{2, []interface{}{nil, "1904-01-01"}}}) {
+		t.Errorf("sheet 2: %v", got)
+	}
+}
+
+func TestXLSXBroken(t *testing.T) {
+	parts := xlsxTestParts("")
+	delete(parts, "xl/sharedStrings.xml")
+	if _, err := openXLSX(writeTestXLSX(t, parts)); err == nil || err.Error() != "workbook part xl/sharedStrings.xml is missing" {
+		t.Errorf("missing shared strings: %v", err)
+	}
+	parts = xlsxTestParts("")
+	parts["xl/worksheets/sheet1.xml"] = `<worksheet><sheetData><row r="1">` + strings.Repeat(`<c><v>1</v></c>`, maxSheetColumns+1) + `</row></sheetData></worksheet>`
+	wb, err := openXLSX(writeTestXLSX(t, parts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	if err := wb.scanSheet(wb.sheets[0].part, func(int, []interface{}) error { return nil }); err == nil {
+		t.Error("cell past the last column accepted")
+	}
+	name := filepath.Join(t.TempDir(), "book.xlsx")
+	os.WriteFile(name, []byte("name,value\n"), 0o644)
+	if _, err := openXLSX(name); err == nil {
+		t.Error("CSV opened as a workbook")
+	}
+}
+
+func TestXLSXWriteRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.xlsx")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets := []xlsxSheetData{
+		{name: "Report <1>", boldFirst: true, rows: [][]interface{}{
+			{"id", "name", "ok"},
+			{json.Number("1"), "  a & b  ", true},
+			{2.5, nil, false, map[string]interface{}{"k": "v"}},
+		}},
+		{name: "Empty"},
+	}
+	if err := writeXLSX(f, sheets); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	wb, err := openXLSX(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	if got := wb.sheetNames(); !reflect.DeepEqual(got, []string{"Report <1>", "Empty"}) {
+		t.Errorf("sheets %q", got)
+	}
+	want := []xlsxTestRow{
+		{1, []interface{}{"id", "name", "ok"}},
+		{2, []interface{}{json.Number("1"), "  a & b  ", true}},
+		{3, []interface{}{json.Number("2.5"), nil, false, `{"k":"v"}`}},
+	}
+	if got := scanTestSheet(t, wb, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip\n got %v\nwant %v", got, want)
+	}
+	if got := scanTestSheet(t, wb, 1); len(got) != 0 {
+		t.Errorf("empty sheet has %v", got)
+	}
+}
+
+func TestExcelDate(t *testing.T) {
+	for _, tc := range []struct {
+		serial   float64
+		date1904 bool
+		want     string
+	}{
+		{1, false, "1899-12-31"},
+		{61, false, "1900-03-01"},
+		{45292, false, "2024-01-01"},
+		{45292.25, false, "2024-01-01T06:00:00"},
+		{0.999988425925926, false, "23:59:59"},
+		{0.5, true, "12:00:00"},
+		{43830, true, "2024-01-01"},
+	} {
+		if got := excelDate(tc.serial, tc.date1904); got != tc.want {
+			t.Errorf("excelDate(%v, %v) = %s, want %s", tc.serial, tc.date1904, got, tc.want)
+		}
+	}
+	for code, date := range map[string]bool{
+		"General": false, "0.00": false, `0.00"d"`: false, `#,##0\ "h"`: false, "[Red]0.00": false,
+		"yyyy-mm-dd": true, "d-mmm": true, "[h]:mm:ss": true, "[$-409]mmmm d, yyyy": true, "hh:mm AM/PM": true,
+	} {
+		if isDateFormat(code) != date {
+			t.Errorf("isDateFormat(%q) = %v", code, !date)
+		}
+	}
+}
+
+func TestCellRefs(t *testing.T) {
+	for n, name := range map[int]string{1: "A", 26: "Z", 27: "AA", 52: "AZ", 702: "ZZ", 703: "AAA", 16384: "XFD"} {
+		if got := columnName(n); got != name {
+			t.Errorf("columnName(%d) = %s", n, got)
+		}
+		if _, col, ok := parseCellRef(name); !ok || col != n {
+			t.Errorf("parseCellRef(%s) = %d, %v", name, col, ok)
+		}
+	}
+	for ref, want := range map[string][2]int{"B12": {12, 2}, "b12": {12, 2}, "12": {12, 0}, "XFD1048576": {1048576, 16384}} {
+		if row, col, ok := parseCellRef(ref); !ok || row != want[0] || col != want[1] {
+			t.Errorf("parseCellRef(%s) = %d, %d, %v", ref, row, col, ok)
+		}
+	}
+	for _, bad := range []string{"", "XFE1", "A0", "A1B", "ABCD1"} {
+		if _, _, ok := parseCellRef(bad); ok {
+			t.Errorf("parseCellRef(%q) accepted", bad)
+		}
+	}
+}
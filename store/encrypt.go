@@ -0,0 +1,324 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// EncryptionConfig encrypts record values at rest. Keys are tried newest
+// first: values are written with the first key and read with whichever
+// one they name, so rotating means adding a key in front, rekeying, and
+// then dropping the old key.
+type EncryptionConfig struct {
+	Keys []KeyConfig `json:"keys,omitempty"`
+}
+
+// KeyConfig is one 32-byte AES key, base64-encoded, given inline or read
+// from a file, an environment variable, or the output of Command. The
+// command is how keys held in a KMS or secret manager are fetched, e.g.
+// ["vault", "kv", "get", "-field=key", "secret/mcp"].
+type KeyConfig struct {
+	ID      string   `json:"id" schema:"required"`
+	Key     string   `json:"key,omitempty"`
+	File    string   `json:"file,omitempty"`
+	Env     string   `json:"env,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// Enabled reports whether any key is configured.
+func (c EncryptionConfig) Enabled() bool {
+	return len(c.Keys) > 0
+}
+
+// Keyring holds the keys record values are sealed with.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring resolves the configured keys.
+func NewKeyring(cfg EncryptionConfig) (*Keyring, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("no encryption keys configured")
+	}
+	k := &Keyring{primary: cfg.Keys[0].ID, aeads: map[string]cipher.AEAD{}}
+	for _, kc := range cfg.Keys {
+		if kc.ID == "" || len(kc.ID) > 255 {
+			return nil, errors.New("encryption keys need an id of at most 255 bytes")
+		}
+		if k.aeads[kc.ID] != nil {
+			return nil, fmt.Errorf("duplicate encryption key id %q", kc.ID)
+		}
+		secret, err := kc.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", kc.ID, err)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		if k.aeads[kc.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (kc KeyConfig) resolve() ([]byte, error) {
+	var text string
+	switch {
+	case kc.Key != "":
+		text = kc.Key
+	case kc.File != "":
+		data, err := os.ReadFile(kc.File)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	case kc.Env != "":
+		if text = os.Getenv(kc.Env); text == "" {
+			return nil, fmt.Errorf("$%s is not set", kc.Env)
+		}
+	case len(kc.Command) > 0:
+		var stderr bytes.Buffer
+		cmd := exec.Command(kc.Command[0], kc.Command[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v: %s", kc.Command[0], err, strings.TrimSpace(stderr.String()))
+		}
+		text = string(out)
+	default:
+		return nil, errors.New("set one of key, file, env or command")
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		return nil, errors.New("empty key")
+	}
+	secret, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %w", err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("want 32 bytes, got %d", len(secret))
+	}
+	return secret, nil
+}
+
+// Sealed values are sealedMagic, the key ID's length and the ID, a GCM
+// nonce and the ciphertext. The record's namespace and key are bound in as
+// additional data, so a value cannot be moved to another record. JSON
+// never starts with a NUL byte, which tells sealed values from the
+// plaintext written before encryption was turned on.
+const sealedMagic = "\x00enc1"
+
+func (k *Keyring) seal(namespace, key string, plain []byte) ([]byte, error) {
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(sealedMagic), byte(len(k.primary)))
+	out = append(out, k.primary...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, recordAAD(namespace, key)), nil
+}
+
+// open returns the plaintext of value and the ID of the key that sealed
+// it, "" for a value that was never sealed.
+func (k *Keyring) open(namespace, key string, value []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(value, []byte(sealedMagic)) {
+		return value, "", nil
+	}
+	rest := value[len(sealedMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return nil, "", fmt.Errorf("record %s/%s: corrupt sealed value", namespace, key)
+	}
+	id := string(rest[1 : 1+rest[0]])
+	rest = rest[1+rest[0]:]
+	aead := k.aeads[id]
+	if aead == nil {
+		return nil, id, fmt.Errorf("record %s/%s: sealed with unknown key %q", namespace, key, id)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, id, fmt.Errorf("record %s/%s: corrupt sealed value", namespace, key)
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], recordAAD(namespace, key))
+	if err != nil {
+		return nil, id, fmt.Errorf("record %s/%s: cannot decrypt with key %q", namespace, key, id)
+	}
+	return plain, id, nil
+}
+
+func recordAAD(namespace, key string) []byte {
+	return []byte(namespace + "\x00" + key)
+}
+
+// Encrypt wraps s so that record values are sealed with ring. In the
+// hashed namespaces, whose keys are secrets such as session IDs, keys are
+// stored as their SHA-256 instead; those records must carry their key in
+// their value, since List and Each return the hash.
+func Encrypt(s Store, ring *Keyring, hashed ...string) Store {
+	e := &encryptedStore{Store: s, ring: ring, hashed: map[string]bool{}}
+	for _, ns := range hashed {
+		e.hashed[ns] = true
+	}
+	return e
+}
+
+type encryptedStore struct {
+	Store
+	ring   *Keyring
+	hashed map[string]bool
+}
+
+const hashedKeyPrefix = "sha256:"
+
+// storedKey is the key a record is kept under.
+func (e *encryptedStore) storedKey(namespace, key string) string {
+	if !e.hashed[namespace] || strings.HasPrefix(key, hashedKeyPrefix) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hashedKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (e *encryptedStore) Get(ctx context.Context, namespace, key string) (*Record, error) {
+	stored := e.storedKey(namespace, key)
+	rec, err := e.Store.Get(ctx, namespace, stored)
+	if errors.Is(err, ErrNotFound) && stored != key {
+		rec, err = e.Store.Get(ctx, namespace, key) // written before encryption
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.Value, _, err = e.ring.open(namespace, rec.Key, rec.Value); err != nil {
+		return nil, err
+	}
+	rec.Key = key
+	return rec, nil
+}
+
+func (e *encryptedStore) Put(ctx context.Context, rec *Record) error {
+	sealed, err := e.sealed(rec)
+	if err != nil {
+		return err
+	}
+	if err := e.Store.Put(ctx, sealed); err != nil {
+		return err
+	}
+	if sealed.Key != rec.Key {
+		return e.Store.Delete(ctx, rec.Namespace, rec.Key)
+	}
+	return nil
+}
+
+// sealed returns a copy of rec as stored.
+func (e *encryptedStore) sealed(rec *Record) (*Record, error) {
+	out := *rec
+	out.Key = e.storedKey(rec.Namespace, rec.Key)
+	var err error
+	out.Value, err = e.ring.seal(out.Namespace, out.Key, rec.Value)
+	return &out, err
+}
+
+func (e *encryptedStore) Delete(ctx context.Context, namespace, key string) error {
+	if stored := e.storedKey(namespace, key); stored != key {
+		if err := e.Store.Delete(ctx, namespace, stored); err != nil {
+			return err
+		}
+	}
+	return e.Store.Delete(ctx, namespace, key)
+}
+
+func (e *encryptedStore) List(ctx context.Context, namespace, prefix string) ([]*Record, error) {
+	if e.hashed[namespace] && prefix != "" {
+		return nil, fmt.Errorf("namespace %s has hashed keys and cannot be listed by prefix", namespace)
+	}
+	recs, err := e.Store.List(ctx, namespace, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range recs {
+		if rec.Value, _, err = e.ring.open(namespace, rec.Key, rec.Value); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+func (e *encryptedStore) Each(ctx context.Context, fn func(*Record) error) error {
+	return e.Store.Each(ctx, func(rec *Record) error {
+		var err error
+		if rec.Value, _, err = e.ring.open(rec.Namespace, rec.Key, rec.Value); err != nil {
+			return err
+		}
+		return fn(rec)
+	})
+}
+
+func (e *encryptedStore) Replace(ctx context.Context, recs []*Record) error {
+	sealed := make([]*Record, len(recs))
+	for i, rec := range recs {
+		var err error
+		if sealed[i], err = e.sealed(rec); err != nil {
+			return err
+		}
+	}
+	return e.Store.Replace(ctx, sealed)
+}
+
+// Rekey seals every record of s, a store from Open, that is plaintext,
+// sealed with a key other than ring's first, or not yet under its hashed
+// key, and returns how many it rewrote. Timestamps are kept. Afterwards
+// keys other than the first can be removed from the configuration.
+func Rekey(ctx context.Context, s Store, ring *Keyring, hashed ...string) (int, error) {
+	raw, ok := s.(*sqlStore)
+	if !ok {
+		return 0, errors.New("rekey needs a store from Open")
+	}
+	e := Encrypt(s, ring, hashed...).(*encryptedStore)
+	// Collected first: SQLite has one connection, held while Each reads.
+	var stale []*Record
+	err := s.Each(ctx, func(rec *Record) error {
+		_, id, err := ring.open(rec.Namespace, rec.Key, rec.Value)
+		if err != nil {
+			return err
+		}
+		if id != ring.primary || e.storedKey(rec.Namespace, rec.Key) != rec.Key {
+			stale = append(stale, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, rec := range stale {
+		if rec.Value, _, err = ring.open(rec.Namespace, rec.Key, rec.Value); err != nil {
+			return i, err
+		}
+		sealed, err := e.sealed(rec)
+		if err != nil {
+			return i, err
+		}
+		if err := raw.putAs(ctx, sealed); err != nil {
+			return i, err
+		}
+		if sealed.Key != rec.Key {
+			if err := raw.Delete(ctx, rec.Namespace, rec.Key); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryption(t *testing.T) {
+	raw, ctx := openTest(t), context.Background()
+	old, err := NewKeyring(EncryptionConfig{Keys: []KeyConfig{{ID: "k1", Key: testKey(1)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written before encryption was turned on.
+	if err := raw.Put(ctx, &Record{Namespace: "memory", Key: "legacy", Value: []byte(`{"v":0}`)}); err != nil {
+		t.Fatal(err)
+	}
+	s := Encrypt(raw, old, "sessions")
+	for _, rec := range []*Record{
+		{Namespace: "memory", Key: "note", Value: []byte(`{"v":1}`)},
+		{Namespace: "sessions", Key: "secret-session-id", Value: []byte(`{"v":2}`)},
+	} {
+		if err := s.Put(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Values are sealed at rest, and session IDs stored as hashes.
+	if rec, err := raw.Get(ctx, "memory", "note"); err != nil || bytes.Contains(rec.Value, []byte(`"v"`)) || !bytes.HasPrefix(rec.Value, []byte(sealedMagic)) {
+		t.Errorf("stored %q, %v", rec.Value, err)
+	}
+	if _, err := raw.Get(ctx, "sessions", "secret-session-id"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("session stored under its ID: %v", err)
+	}
+	for key, want := range map[string]string{"note": `{"v":1}`, "legacy": `{"v":0}`} {
+		if rec, err := s.Get(ctx, "memory", key); err != nil || string(rec.Value) != want {
+			t.Errorf("%s: %+v, %v", key, rec, err)
+		}
+	}
+	if rec, err := s.Get(ctx, "sessions", "secret-session-id"); err != nil || string(rec.Value) != `{"v":2}` || rec.Key != "secret-session-id" {
+		t.Errorf("session: %+v, %v", rec, err)
+	}
+	if _, err := s.List(ctx, "sessions", "secret"); err == nil {
+		t.Error("listed hashed keys by prefix")
+	}
+
+	// A sealed value moved to another record does not open.
+	sealed, _ := raw.Get(ctx, "memory", "note")
+	if err := raw.Put(ctx, &Record{Namespace: "memory", Key: "moved", Value: sealed.Value}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "memory", "moved"); err == nil || !strings.Contains(err.Error(), "cannot decrypt") {
+		t.Errorf("moved value: %v", err)
+	}
+	raw.Delete(ctx, "memory", "moved")
+	// Without its key, nothing opens.
+	other, _ := NewKeyring(EncryptionConfig{Keys: []KeyConfig{{ID: "k2", Key: testKey(2)}}})
+	if _, err := Encrypt(raw, other).Get(ctx, "memory", "note"); err == nil || !strings.Contains(err.Error(), `unknown key "k1"`) {
+		t.Errorf("other keyring: %v", err)
+	}
+
+	// Rotation: a new first key writes, the old one still reads, and
+	// rekeying moves everything to the new key.
+	rotated, err := NewKeyring(EncryptionConfig{Keys: []KeyConfig{{ID: "k2", Key: testKey(2)}, {ID: "k1", Key: testKey(1)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := Encrypt(raw, rotated, "sessions").Get(ctx, "memory", "note"); err != nil || string(rec.Value) != `{"v":1}` {
+		t.Errorf("old value after rotation: %+v, %v", rec, err)
+	}
+	if n, err := Rekey(ctx, raw, rotated, "sessions"); err != nil || n != 3 {
+		t.Fatalf("rekey: %d, %v", n, err)
+	}
+	if n, err := Rekey(ctx, raw, rotated, "sessions"); err != nil || n != 0 {
+		t.Errorf("second rekey: %d, %v", n, err)
+	}
+	for _, key := range []string{"note", "legacy"} {
+		if rec, err := Encrypt(raw, other).Get(ctx, "memory", key); err != nil || !strings.HasPrefix(string(rec.Value), `{"v":`) {
+			t.Errorf("%s after rekey: %+v, %v", key, rec, err)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	for _, cfg := range []EncryptionConfig{
+		{},
+		{Keys: []KeyConfig{{ID: "k1"}}},
+		{Keys: []KeyConfig{{ID: "", Key: testKey(1)}}},
+		{Keys: []KeyConfig{{ID: "k1", Key: "not base64!"}}},
+		{Keys: []KeyConfig{{ID: "k1", Key: base64.StdEncoding.EncodeToString([]byte("too short"))}}},
+		{Keys: []KeyConfig{{ID: "k1", Key: testKey(1)}, {ID: "k1", Key: testKey(2)}}},
+		{Keys: []KeyConfig{{ID: "k1", Env: "MCP_TEST_UNSET_KEY"}}},
+	} {
+		if _, err := NewKeyring(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	t.Setenv("MCP_TEST_KEY", testKey(3))
+	if _, err := NewKeyring(EncryptionConfig{Keys: []KeyConfig{{ID: "k3", Env: "MCP_TEST_KEY"}}}); err != nil {
+		t.Error(err)
+	}
+}
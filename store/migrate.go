@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql, in the style of golang-migrate. A released
+// migration is never edited; changes go in a new one.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator moves a database between schema versions. Each migration runs
+// in a transaction together with the version bump, so a failed one
+// leaves the previous version in place.
+type Migrator struct {
+	db         *sql.DB
+	d          dialect
+	migrations []Migration
+}
+
+// NewMigrator connects to the database cfg describes without changing
+// its schema.
+func NewMigrator(cfg Config, dataDir string) (*Migrator, error) {
+	db, d, err := connect(cfg, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, d)
+}
+
+func newMigrator(db *sql.DB, d dialect) (*Migrator, error) {
+	migrations, err := loadMigrations(d.name)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Migrator{db: db, d: d, migrations: migrations}, nil
+}
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), ".")
+		num, name, ok2 := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || !ok2 || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %s", e.Name())
+		}
+		data, err := fs.ReadFile(migrationFiles, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both up and down files", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i, m := range out {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return out, nil
+}
+
+// Migrations lists the known migrations, oldest first.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Latest is the version the known migrations lead to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns the database's current schema version, 0 for none.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, m.db)
+}
+
+// querier is a *sql.DB or *sql.Conn.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q querier) (int, error) {
+	if _, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL)`); err != nil {
+		return 0, err
+	}
+	var version int
+	err := q.QueryRowContext(ctx, `SELECT version FROM schema_migrations`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Up applies all pending migrations and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// To migrates up or down to target and returns the migrations applied or
+// reverted, in the order they ran.
+func (m *Migrator) To(ctx context.Context, target int) ([]Migration, error) {
+	if target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("no schema version %d; this server knows 0 to %d", target, m.Latest())
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Replicas starting together must not migrate at the same time.
+	if m.d.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.d.lock); err != nil {
+			return nil, fmt.Errorf("locking schema: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), m.d.unlock)
+	}
+
+	current, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("database schema version %d is newer than this server's %d; downgrade it with the newer server first", current, m.Latest())
+	}
+	var done []Migration
+	for current != target {
+		// Going up runs migration current+1; going down reverts current.
+		var next int
+		var mig Migration
+		var stmt string
+		if target > current {
+			next, mig = current+1, m.migrations[current]
+			stmt = mig.Up
+		} else {
+			next, mig = current-1, m.migrations[current-1]
+			stmt = mig.Down
+		}
+		if err := m.apply(ctx, conn, stmt, next); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+		current = next
+	}
+	return done, nil
+}
+
+// apply runs stmt and records version next in one transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, stmt string, next int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (`+strconv.Itoa(next)+`)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close closes the database connection.
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for _, dialect := range []string{"sqlite", "postgres"} {
+		migrations, err := loadMigrations(dialect)
+		if err != nil || len(migrations) == 0 {
+			t.Errorf("%s migrations: %d, %v", dialect, len(migrations), err)
+		}
+	}
+
+	dir, ctx := t.TempDir(), context.Background()
+	m, err := NewMigrator(Config{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if v, err := m.Version(ctx); err != nil || v != 0 {
+		t.Fatalf("new database at version %d, %v", v, err)
+	}
+	done, err := m.Up(ctx)
+	if err != nil || len(done) != m.Latest() {
+		t.Fatalf("up: %d, %v", len(done), err)
+	}
+	if done, err := m.Up(ctx); err != nil || len(done) != 0 {
+		t.Errorf("second up: %d, %v", len(done), err)
+	}
+
+	// Down to nothing and back up again, keeping data written in between.
+	if done, err := m.To(ctx, 0); err != nil || len(done) != m.Latest() || done[0].Version != m.Latest() {
+		t.Fatalf("down: %v, %v", done, err)
+	}
+	if v, _ := m.Version(ctx); v != 0 {
+		t.Errorf("after down at version %d", v)
+	}
+	s, err := Open(Config{}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, &Record{Namespace: "ns", Key: "k", Owner: "o", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if v, _ := m.Version(ctx); v != m.Latest() {
+		t.Errorf("open left version %d", v)
+	}
+
+	if _, err := m.To(ctx, m.Latest()+1); err == nil || !strings.Contains(err.Error(), "no schema version") {
+		t.Errorf("unknown target: %v", err)
+	}
+	// A database migrated by a newer server is left alone.
+	if _, err := m.db.ExecContext(ctx, `UPDATE schema_migrations SET version = 99`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "newer than this server's") {
+		t.Errorf("newer schema: %v", err)
+	}
+	if _, err := Open(Config{}, dir); err == nil {
+		t.Error("opened a newer schema")
+	}
+}
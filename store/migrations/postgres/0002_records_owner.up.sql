@@ -0,0 +1,2 @@
+-- Finding a caller's records, for purges by API key.
+CREATE INDEX records_owner ON records (owner, namespace);
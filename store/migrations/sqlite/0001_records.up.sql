@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS records (
+    namespace  TEXT NOT NULL,
+    name       TEXT NOT NULL,
+    owner      TEXT NOT NULL DEFAULT '',
+    value      BLOB NOT NULL,
+    created_at BIGINT NOT NULL,
+    updated_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (namespace, name)
+);
+CREATE INDEX IF NOT EXISTS records_expires ON records (expires_at) WHERE expires_at > 0;
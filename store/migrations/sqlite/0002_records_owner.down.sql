@@ -0,0 +1 @@
+DROP INDEX records_owner;
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// openPostgres connects with dsn, a URL or key=value connection string.
+func openPostgres(dsn string) (*sql.DB, dialect, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, dialect{}, err
+	}
+	return db, dialect{
+		name:     "postgres",
+		numbered: true,
+		// An arbitrary key, the same for every replica.
+		lock:   "SELECT pg_advisory_lock(7243010661)",
+		unlock: "SELECT pg_advisory_unlock(7243010661)",
+	}, nil
+}
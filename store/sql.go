@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// dialect holds what differs between the SQL databases.
+type dialect struct {
+	name string // of the migrations directory
+	// numbered placeholders ($1, $2) instead of ?
+	numbered bool
+	// lock and unlock serialise migrations across processes.
+	lock, unlock string
+}
+
+// sqlStore implements Store on database/sql.
+type sqlStore struct {
+	db *sql.DB
+	d  dialect
+}
+
+// rebind rewrites ? placeholders for dialects that number them.
+func (s *sqlStore) rebind(query string) string {
+	if !s.d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+const recordColumns = "namespace, name, owner, value, created_at, updated_at, expires_at"
+
+func (s *sqlStore) Get(ctx context.Context, namespace, key string) (*Record, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind(`SELECT `+recordColumns+` FROM records
+		WHERE namespace = ? AND name = ? AND (expires_at = 0 OR expires_at > ?)`),
+		namespace, key, millis(time.Now()))
+	rec, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return rec, err
+}
+
+func (s *sqlStore) Put(ctx context.Context, rec *Record) error {
+	now := time.Now()
+	var expires int64
+	if !rec.Expires.IsZero() {
+		expires = millis(rec.Expires)
+	}
+	value := rec.Value
+	if value == nil {
+		value = []byte{}
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO records (`+recordColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, name) DO UPDATE SET
+			owner = excluded.owner, value = excluded.value,
+			updated_at = excluded.updated_at, expires_at = excluded.expires_at`),
+		rec.Namespace, rec.Key, rec.Owner, value, millis(now), millis(now), expires)
+	return err
+}
+
+func (s *sqlStore) Delete(ctx context.Context, namespace, key string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM records WHERE namespace = ? AND name = ?`), namespace, key)
+	return err
+}
+
+func (s *sqlStore) List(ctx context.Context, namespace, prefix string) ([]*Record, error) {
+	// substr rather than LIKE, which SQLite matches case-insensitively.
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+recordColumns+` FROM records
+		WHERE namespace = ? AND substr(name, 1, ?) = ? AND (expires_at = 0 OR expires_at > ?)
+		ORDER BY name`),
+		namespace, utf8.RuneCountInString(prefix), prefix, millis(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) PurgeExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM records WHERE expires_at > 0 AND expires_at <= ?`), millis(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) Purge(ctx context.Context, f Filter) (int64, error) {
+	query, args := `DELETE FROM records WHERE 1 = 1`, []interface{}{}
+	if f.Namespace != "" {
+		query += ` AND namespace = ?`
+		args = append(args, f.Namespace)
+	}
+	if f.Owner != "" {
+		query += ` AND owner = ?`
+		args = append(args, f.Owner)
+	}
+	if !f.UpdatedBefore.IsZero() {
+		query += ` AND updated_at < ?`
+		args = append(args, millis(f.UpdatedBefore))
+	}
+	res, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) Each(ctx context.Context, fn func(*Record) error) error {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+recordColumns+` FROM records
+		WHERE expires_at = 0 OR expires_at > ? ORDER BY namespace, name`), millis(time.Now()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStore) Replace(ctx context.Context, recs []*Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM records`); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, s.rebind(`INSERT INTO records (`+recordColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, rec := range recs {
+		if _, err := stmt.ExecContext(ctx, recordArgs(rec)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// putAs creates or replaces rec with its timestamps kept.
+func (s *sqlStore) putAs(ctx context.Context, rec *Record) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO records (`+recordColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (namespace, name) DO UPDATE SET
+			owner = excluded.owner, value = excluded.value, created_at = excluded.created_at,
+			updated_at = excluded.updated_at, expires_at = excluded.expires_at`),
+		recordArgs(rec)...)
+	return err
+}
+
+func recordArgs(rec *Record) []interface{} {
+	var expires int64
+	if !rec.Expires.IsZero() {
+		expires = millis(rec.Expires)
+	}
+	value := rec.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return []interface{}{rec.Namespace, rec.Key, rec.Owner, value, millis(rec.Created), millis(rec.Updated), expires}
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func scanRecord(row interface{ Scan(...interface{}) error }) (*Record, error) {
+	var rec Record
+	var created, updated, expires int64
+	if err := row.Scan(&rec.Namespace, &rec.Key, &rec.Owner, &rec.Value, &created, &updated, &expires); err != nil {
+		return nil, err
+	}
+	rec.Created, rec.Updated = time.UnixMilli(created), time.UnixMilli(updated)
+	if expires > 0 {
+		rec.Expires = time.UnixMilli(expires)
+	}
+	return &rec, nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
@@ -0,0 +1,24 @@
+package store
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite" // pure Go, so the server still builds without cgo
+)
+
+// openSQLite opens the database file at path. WAL lets readers proceed
+// during writes; a single connection serialises writers, which SQLite
+// does anyway, without "database is locked" errors.
+func openSQLite(path string) (*sql.DB, dialect, error) {
+	q := url.Values{}
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, dialect{}, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, dialect{name: "sqlite"}, nil
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func openTest(t *testing.T) Store {
+	t.Helper()
+	s, err := Open(Config{}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRecords(t *testing.T) {
+	s, ctx := openTest(t), context.Background()
+	put := func(ns, key, owner, value string, expires time.Time) {
+		t.Helper()
+		if err := s.Put(ctx, &Record{Namespace: ns, Key: key, Owner: owner, Value: []byte(value), Expires: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("jobs", "b", "team-a", "1", time.Time{})
+	put("jobs", "a", "team-b", "2", time.Time{})
+	put("jobs", "old", "team-a", "3", time.Now().Add(-time.Second))
+	put("sessions", "a", "team-a", "4", time.Now().Add(time.Hour))
+
+	rec, err := s.Get(ctx, "jobs", "a")
+	if err != nil || string(rec.Value) != "2" || rec.Owner != "team-b" {
+		t.Fatalf("get: %+v, %v", rec, err)
+	}
+	created := rec.Created
+	time.Sleep(2 * time.Millisecond)
+	put("jobs", "a", "team-b", "5", time.Time{})
+	if rec, err := s.Get(ctx, "jobs", "a"); err != nil || string(rec.Value) != "5" || !rec.Created.Equal(created) || !rec.Updated.After(created) {
+		t.Errorf("replaced: %+v, %v", rec, err)
+	}
+	for _, missing := range [][2]string{{"jobs", "old"}, {"jobs", "nosuch"}, {"other", "a"}} {
+		if _, err := s.Get(ctx, missing[0], missing[1]); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%v: %v", missing, err)
+		}
+	}
+
+	recs, err := s.List(ctx, "jobs", "")
+	if err != nil || len(recs) != 2 || recs[0].Key != "a" || recs[1].Key != "b" {
+		t.Errorf("list: %v, %v", recs, err)
+	}
+	if recs, _ := s.List(ctx, "jobs", "b"); len(recs) != 1 {
+		t.Errorf("list by prefix: %v", recs)
+	}
+	// Prefixes are literal, not LIKE patterns.
+	if recs, _ := s.List(ctx, "jobs", "%"); len(recs) != 0 {
+		t.Errorf("list by %%: %v", recs)
+	}
+
+	var seen []string
+	if err := s.Each(ctx, func(r *Record) error {
+		seen = append(seen, r.Namespace+"/"+r.Key)
+		return nil
+	}); err != nil || len(seen) != 3 || seen[0] != "jobs/a" || seen[2] != "sessions/a" {
+		t.Errorf("each: %v, %v", seen, err)
+	}
+
+	if n, err := s.PurgeExpired(ctx); err != nil || n != 1 {
+		t.Errorf("purge expired: %d, %v", n, err)
+	}
+	if n, err := s.Purge(ctx, Filter{Namespace: "jobs", Owner: "team-a"}); err != nil || n != 1 {
+		t.Errorf("purge by owner: %d, %v", n, err)
+	}
+	if err := s.Delete(ctx, "jobs", "nosuch"); err != nil {
+		t.Errorf("delete missing: %v", err)
+	}
+
+	// Replace keeps the timestamps it is given.
+	stamp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.Replace(ctx, []*Record{{Namespace: "memory", Key: "k", Value: []byte("v"), Created: stamp, Updated: stamp}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "jobs", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("kept after replace: %v", err)
+	}
+	if rec, err := s.Get(ctx, "memory", "k"); err != nil || !rec.Created.Equal(stamp) || !rec.Updated.Equal(stamp) {
+		t.Errorf("restored: %+v, %v", rec, err)
+	}
+}
+
+func TestOpenConfig(t *testing.T) {
+	for _, cfg := range []Config{{Driver: "mysql"}, {Driver: "postgres"}} {
+		if _, err := Open(cfg, t.TempDir()); err == nil {
+			t.Errorf("%+v opened", cfg)
+		}
+	}
+	if _, err := Open(Config{}, ""); err == nil {
+		t.Error("sqlite without a data directory opened")
+	}
+}
@@ -0,0 +1,22 @@
+// Package tools holds the Go tools compiled into the server. Scaffold a
+// new one with
+//
+//	mcp-server generate tool <name>
+package tools
+
+import "mcp-server/mcpserver"
+
+var registrations []func(*mcpserver.Builder)
+
+// register queues a tool for Register; generated tools call it from init.
+func register(fn func(*mcpserver.Builder)) {
+	registrations = append(registrations, fn)
+}
+
+// Register adds the package's tools to b.
+func Register(b *mcpserver.Builder) *mcpserver.Builder {
+	for _, fn := range registrations {
+		fn(b)
+	}
+	return b
+}